@@ -363,6 +363,11 @@ func (r *ResourceManager) CreateRun(apiRun *api.Run) (*model.RunDetail, error) {
 	// on every single step/pod so the cache server can understand.
 	// TODO: Add run_level flag with similar logic by reading flag value from create_run api.
 	workflow.SetLabelsToAllTemplates(util.LabelKeyCacheEnabled, common.IsCacheEnabled())
+	// Annotate every step with the pipeline version it runs, so that the cache service can
+	// invalidate the cache entries of a pipeline version.
+	if pipelineVersionId := getPipelineVersionIdFromResourceReferences(apiRun.GetResourceReferences()); pipelineVersionId != "" {
+		workflow.SetAnnotationsToAllTemplates(util.AnnotationKeyPipelineVersionId, pipelineVersionId)
+	}
 	// Append provided parameter
 	workflow.OverrideParameters(parameters)
 
@@ -682,6 +687,11 @@ func (r *ResourceManager) CreateJob(apiJob *api.Job) (*model.Job, error) {
 
 	// Disable istio sidecar injection
 	workflow.SetAnnotationsToAllTemplates(util.AnnotationKeyIstioSidecarInject, util.AnnotationValueIstioSidecarInjectDisabled)
+	// Annotate every step of the recurring runs with the pipeline version they run, like the steps
+	// of the runs created by CreateRun.
+	if pipelineVersionId := getPipelineVersionIdFromResourceReferences(apiJob.GetResourceReferences()); pipelineVersionId != "" {
+		workflow.SetAnnotationsToAllTemplates(util.AnnotationKeyPipelineVersionId, pipelineVersionId)
+	}
 
 	swfGeneratedName, err := toSWFCRDResourceGeneratedName(apiJob.Name)
 	if err != nil {
@@ -956,13 +966,20 @@ func (r *ResourceManager) getWorkflowSpecBytesFromPipelineSpec(spec *api.Pipelin
 	return nil, util.NewInvalidInputError("Please provide a valid pipeline spec")
 }
 
-func (r *ResourceManager) getWorkflowSpecBytesFromPipelineVersion(references []*api.ResourceReference) ([]byte, error) {
+// getPipelineVersionIdFromResourceReferences returns the ID of the pipeline version the resource
+// was created from, empty when there is none.
+func getPipelineVersionIdFromResourceReferences(references []*api.ResourceReference) string {
 	var pipelineVersionId = ""
 	for _, reference := range references {
 		if reference.Key.Type == api.ResourceType_PIPELINE_VERSION && reference.Relationship == api.Relationship_CREATOR {
 			pipelineVersionId = reference.Key.Id
 		}
 	}
+	return pipelineVersionId
+}
+
+func (r *ResourceManager) getWorkflowSpecBytesFromPipelineVersion(references []*api.ResourceReference) ([]byte, error) {
+	pipelineVersionId := getPipelineVersionIdFromResourceReferences(references)
 	if len(pipelineVersionId) == 0 {
 		return nil, util.NewInvalidInputError("No pipeline version.")
 	}
@@ -1125,7 +1142,8 @@ func (r *ResourceManager) CreatePipelineVersion(apiVersion *api.PipelineVersion,
 		return nil, util.Wrap(err, "Create pipeline version failed")
 	}
 
-	// Store the pipeline file
+	// Store the pipeline file as uploaded. Its steps are annotated with the pipeline version when
+	// runs and jobs are created from the version, rather than in the file returned to its users.
 	err = r.objectStore.AddFile(pipelineFile, r.objectStore.GetPipelineKey(fmt.Sprint(version.UUID)))
 	if err != nil {
 		return nil, util.Wrap(err, "Create pipeline version failed")
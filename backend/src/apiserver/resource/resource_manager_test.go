@@ -593,6 +593,71 @@ func TestCreateRun_ThroughPipelineVersion(t *testing.T) {
 	assert.Equal(t, expectedRunDetail, runDetail, "CreateRun stored invalid data in database")
 }
 
+func TestGetPipelineVersionIdFromResourceReferences(t *testing.T) {
+	assert.Equal(t, "", getPipelineVersionIdFromResourceReferences(nil))
+	assert.Equal(t, "version1", getPipelineVersionIdFromResourceReferences([]*api.ResourceReference{
+		{
+			Key:          &api.ResourceKey{Type: api.ResourceType_EXPERIMENT, Id: "experiment1"},
+			Relationship: api.Relationship_OWNER,
+		},
+		{
+			Key:          &api.ResourceKey{Type: api.ResourceType_PIPELINE_VERSION, Id: "version1"},
+			Relationship: api.Relationship_CREATOR,
+		},
+	}))
+}
+
+// createPipelineVersionWithTemplates uploads a version of the pipeline whose workflow has steps.
+func createPipelineVersionWithTemplates(t *testing.T, store *FakeClientManager, manager *ResourceManager, pipeline *model.Pipeline) *model.PipelineVersion {
+	pipelineStore, ok := store.pipelineStore.(*storage.PipelineStore)
+	assert.True(t, ok)
+	pipelineStore.SetUUIDGenerator(util.NewFakeUUIDGeneratorOrFatal(FakeUUIDOne, nil))
+	workflow := util.NewWorkflow(testWorkflow.DeepCopy())
+	workflow.Spec.Entrypoint = "main"
+	workflow.Spec.Templates = []v1alpha1.Template{{Name: "main"}, {Name: "step"}}
+	version, err := manager.CreatePipelineVersion(&api.PipelineVersion{
+		Name: "version_with_templates",
+		ResourceReferences: []*api.ResourceReference{
+			{
+				Key:          &api.ResourceKey{Id: pipeline.UUID, Type: api.ResourceType_PIPELINE},
+				Relationship: api.Relationship_OWNER,
+			},
+		},
+	}, []byte(workflow.ToStringForStore()), true)
+	assert.Nil(t, err)
+	return version
+}
+
+func TestCreateRun_AnnotatesPipelineVersion(t *testing.T) {
+	store, manager, experiment, pipeline := initWithExperimentAndPipeline(t)
+	defer store.Close()
+	version := createPipelineVersionWithTemplates(t, store, manager, pipeline)
+
+	runDetail, err := manager.CreateRun(&api.Run{
+		Name: "run1",
+		PipelineSpec: &api.PipelineSpec{
+			Parameters: []*api.Parameter{{Name: "param1", Value: "world"}},
+		},
+		ResourceReferences: []*api.ResourceReference{
+			{
+				Key:          &api.ResourceKey{Type: api.ResourceType_EXPERIMENT, Id: experiment.UUID},
+				Relationship: api.Relationship_OWNER,
+			},
+			{
+				Key:          &api.ResourceKey{Type: api.ResourceType_PIPELINE_VERSION, Id: version.UUID},
+				Relationship: api.Relationship_CREATOR,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	workflow, err := util.ValidateWorkflow([]byte(runDetail.WorkflowRuntimeManifest))
+	assert.Nil(t, err)
+	assert.Len(t, workflow.Spec.Templates, 2)
+	for _, template := range workflow.Spec.Templates {
+		assert.Equal(t, version.UUID, template.Metadata.Annotations[util.AnnotationKeyPipelineVersionId], template.Name)
+	}
+}
+
 func TestCreateRun_NoExperiment(t *testing.T) {
 	store := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
 	defer store.Close()
@@ -1113,6 +1178,40 @@ func TestCreateJob_ThroughPipelineVersion(t *testing.T) {
 	assert.Equal(t, expectedJob, newJob)
 }
 
+func TestCreateJob_AnnotatesPipelineVersion(t *testing.T) {
+	store, manager, experiment, pipeline := initWithExperimentAndPipeline(t)
+	defer store.Close()
+	version := createPipelineVersionWithTemplates(t, store, manager, pipeline)
+
+	newJob, err := manager.CreateJob(&api.Job{
+		Name:    "j1",
+		Enabled: true,
+		PipelineSpec: &api.PipelineSpec{
+			Parameters: []*api.Parameter{{Name: "param1", Value: "world"}},
+		},
+		ResourceReferences: []*api.ResourceReference{
+			{
+				Key:          &api.ResourceKey{Type: api.ResourceType_EXPERIMENT, Id: experiment.UUID},
+				Relationship: api.Relationship_OWNER,
+			},
+			{
+				Key:          &api.ResourceKey{Type: api.ResourceType_PIPELINE_VERSION, Id: version.UUID},
+				Relationship: api.Relationship_CREATOR,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	scheduledWorkflow, err := store.SwfClient().ScheduledWorkflow(newJob.Namespace).Get(newJob.Name, v1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, scheduledWorkflow.Spec.Workflow.Spec.Templates, 2)
+	for _, template := range scheduledWorkflow.Spec.Workflow.Spec.Templates {
+		assert.Equal(t, version.UUID, template.Metadata.Annotations[util.AnnotationKeyPipelineVersionId], template.Name)
+	}
+	template, err := manager.GetPipelineVersionTemplate(version.UUID)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(template), util.AnnotationKeyPipelineVersionId, "the uploaded file is kept as is")
+}
+
 func TestCreateJob_EmptyPipelineSpec(t *testing.T) {
 	store := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
 	defer store.Close()
@@ -0,0 +1,70 @@
+#!/bin/bash
+
+# Copyright 2020 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+# This file generates the Go sources of the protocol buffers of the cache server
+# defined in this directory with pinned versions of protoc and of its Go
+# plugins, with the license of the proto, so they can be checked-in. Run it
+# after changing a proto, from any directory.
+
+set -ex
+
+# protoc-gen-go matches the google.golang.org/protobuf version of go.mod.
+PROTOC_VERSION="3.17.3"
+PROTOC_GEN_GO_VERSION="v1.27.1"
+PROTOC_GEN_GO_GRPC_VERSION="v1.1.0"
+
+DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" > /dev/null && pwd)"
+REPO_ROOT="$DIR/../../../.."
+PROTOS="backend/src/cache/api/execution_cache.proto"
+
+case "$(uname -s)" in
+  Linux) PROTOC_OS="linux" ;;
+  Darwin) PROTOC_OS="osx" ;;
+  *) echo "ERROR: unsupported OS $(uname -s)"; exit 1 ;;
+esac
+
+TOOLS_DIR="$(mktemp -d)"
+trap 'rm -rf "$TOOLS_DIR"' EXIT
+
+# Install the pinned tools.
+curl -sSL -o "$TOOLS_DIR/protoc.zip" \
+  "https://github.com/protocolbuffers/protobuf/releases/download/v${PROTOC_VERSION}/protoc-${PROTOC_VERSION}-${PROTOC_OS}-x86_64.zip"
+unzip -q "$TOOLS_DIR/protoc.zip" -d "$TOOLS_DIR/protoc"
+GOBIN="$TOOLS_DIR/bin" go install "google.golang.org/protobuf/cmd/protoc-gen-go@${PROTOC_GEN_GO_VERSION}"
+GOBIN="$TOOLS_DIR/bin" go install "google.golang.org/grpc/cmd/protoc-gen-go-grpc@${PROTOC_GEN_GO_GRPC_VERSION}"
+
+# Generate the messages into .pb.go files and the services into _grpc.pb.go files.
+cd "$REPO_ROOT"
+for proto in $PROTOS; do
+  rm -f "${proto%.proto}.pb.go" "${proto%.proto}_grpc.pb.go"
+  "$TOOLS_DIR/protoc/bin/protoc" \
+    -I . -I "$TOOLS_DIR/protoc/include" \
+    --plugin="protoc-gen-go=$TOOLS_DIR/bin/protoc-gen-go" \
+    --plugin="protoc-gen-go-grpc=$TOOLS_DIR/bin/protoc-gen-go-grpc" \
+    --go_out=paths=source_relative:. \
+    --go-grpc_out=paths=source_relative:. \
+    "$proto"
+
+  # protoc-gen-go copies the license header of the proto, prepend it to the
+  # files of protoc-gen-go-grpc.
+  for f in "${proto%.proto}.pb.go" "${proto%.proto}_grpc.pb.go"; do
+    if [ -f "$f" ] && ! grep -q "Licensed under the Apache License" "$f"; then
+      { sed -n '1,/^$/p' "$proto"; cat "$f"; } > "$f.tmp"
+      mv "$f.tmp" "$f"
+    fi
+  done
+done
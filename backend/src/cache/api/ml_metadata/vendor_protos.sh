@@ -0,0 +1,86 @@
+#!/bin/bash
+
+# Copyright 2020 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+# This file vendors the protos of ML Metadata, unchanged, at the version of the
+# metadata gRPC server of the deployment, and generates their Go sources with the
+# protoc and plugins pinned by ../generate_api.sh, so they can be checked-in. It
+# replaces the subset of the messages and calls kept in metadata_store.proto. Run
+# it after upgrading ML Metadata, from any directory.
+
+set -ex
+
+# The version of gcr.io/tfx-oss-public/ml_metadata_store_server in
+# manifests/kustomize/base/metadata/metadata-grpc-deployment.yaml.
+ML_METADATA_VERSION="0.22.1"
+PROTOC_VERSION="3.17.3"
+PROTOC_GEN_GO_VERSION="v1.27.1"
+PROTOC_GEN_GO_GRPC_VERSION="v1.1.0"
+
+DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" > /dev/null && pwd)"
+GO_PACKAGE="github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+PROTOS="ml_metadata/proto/metadata_store.proto ml_metadata/proto/metadata_store_service.proto"
+
+case "$(uname -s)" in
+  Linux) PROTOC_OS="linux" ;;
+  Darwin) PROTOC_OS="osx" ;;
+  *) echo "ERROR: unsupported OS $(uname -s)"; exit 1 ;;
+esac
+
+TOOLS_DIR="$(mktemp -d)"
+trap 'rm -rf "$TOOLS_DIR"' EXIT
+
+# Install the pinned tools.
+curl -sSL -o "$TOOLS_DIR/protoc.zip" \
+  "https://github.com/protocolbuffers/protobuf/releases/download/v${PROTOC_VERSION}/protoc-${PROTOC_VERSION}-${PROTOC_OS}-x86_64.zip"
+unzip -q "$TOOLS_DIR/protoc.zip" -d "$TOOLS_DIR/protoc"
+GOBIN="$TOOLS_DIR/bin" go install "google.golang.org/protobuf/cmd/protoc-gen-go@${PROTOC_GEN_GO_VERSION}"
+GOBIN="$TOOLS_DIR/bin" go install "google.golang.org/grpc/cmd/protoc-gen-go-grpc@${PROTOC_GEN_GO_GRPC_VERSION}"
+
+# Vendor the protos at their upstream paths, which they import each other by.
+cd "$DIR"
+rm -f metadata_store.proto *.pb.go
+for proto in $PROTOS; do
+  mkdir -p "$(dirname "$proto")"
+  curl -sSL -o "$proto" \
+    "https://raw.githubusercontent.com/google/ml-metadata/v${ML_METADATA_VERSION}/${proto}"
+done
+
+# The protos have no go_package, all of them are generated into this package.
+GO_OPTS="paths=import,module=${GO_PACKAGE}"
+for proto in $PROTOS; do
+  GO_OPTS="${GO_OPTS},M${proto}=${GO_PACKAGE}"
+done
+"$TOOLS_DIR/protoc/bin/protoc" \
+  -I . -I "$TOOLS_DIR/protoc/include" \
+  --plugin="protoc-gen-go=$TOOLS_DIR/bin/protoc-gen-go" \
+  --plugin="protoc-gen-go-grpc=$TOOLS_DIR/bin/protoc-gen-go-grpc" \
+  --go_out="$GO_OPTS:." \
+  --go-grpc_out="$GO_OPTS:." \
+  $PROTOS
+
+# protoc-gen-go copies the license header of the protos, prepend it to the
+# files of protoc-gen-go-grpc.
+for f in *_grpc.pb.go; do
+  if ! grep -q "Licensed under the Apache License" "$f"; then
+    { sed -n '1,/^$/p' ml_metadata/proto/metadata_store_service.proto; cat "$f"; } > "$f.tmp"
+    mv "$f.tmp" "$f"
+  fi
+done
+
+# The protos are now those of ml_metadata/proto rather than metadata_store.proto.
+set +x
+echo "List ${PROTOS} in the srcs of BUILD.bazel, with strip_import_prefix = \"/backend/src/cache/api/ml_metadata\"."
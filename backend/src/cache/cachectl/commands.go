@@ -0,0 +1,358 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/server/client"
+)
+
+// listFilter holds the flags selecting the entries listed or exported.
+type listFilter struct {
+	keyPrefix     string
+	namespace     string
+	pipelineName  string
+	createdAfter  string
+	createdBefore string
+}
+
+func (f *listFilter) registerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&f.keyPrefix, "key_prefix", "", "Only the entries whose cache key starts with the prefix.")
+	flags.StringVar(&f.namespace, "namespace", "", "Only the entries owned by the profile or a service account of the namespace.")
+	flags.StringVar(&f.pipelineName, "pipeline_name", "", "Only the entries produced by the runs of the pipeline.")
+	flags.StringVar(&f.createdAfter, "created_after", "", "Only the entries created at or after the RFC 3339 time.")
+	flags.StringVar(&f.createdBefore, "created_before", "", "Only the entries created before the RFC 3339 time.")
+}
+
+// options returns the list options of the filter.
+func (f *listFilter) options() (client.ListOptions, error) {
+	options := client.ListOptions{KeyPrefix: f.keyPrefix, Namespace: f.namespace, PipelineName: f.pipelineName}
+	for _, bound := range []struct {
+		name  string
+		value string
+		time  *time.Time
+	}{
+		{name: "created_after", value: f.createdAfter, time: &options.CreatedAfter},
+		{name: "created_before", value: f.createdBefore, time: &options.CreatedBefore},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return options, usageErrorf("invalid --%s %q, must be an RFC 3339 time", bound.name, bound.value)
+		}
+		*bound.time = parsed
+	}
+	return options, nil
+}
+
+// subcommands returns the commands of cachectl by name.
+func subcommands() map[string]subcommand {
+	var (
+		filter    listFilter
+		pageSize  int
+		pageToken string
+		all       bool
+		key       string
+		selector  server.AdminInvalidateRequest
+		olderThan string
+		file      string
+	)
+	return map[string]subcommand{
+		"list": {
+			description: "Lists the entries, a page at a time or all of them with --all.",
+			registerFlags: func(flags *flag.FlagSet) {
+				filter.registerFlags(flags)
+				flags.IntVar(&pageSize, "page_size", server.DefaultAdminPageSize, "Number of entries listed at a time.")
+				flags.StringVar(&pageToken, "page_token", "", "Token of the page to list, printed after the previous page.")
+				flags.BoolVar(&all, "all", false, "List all pages.")
+			},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("list takes no arguments")
+				}
+				options, err := filter.options()
+				if err != nil {
+					return err
+				}
+				options.PageSize = pageSize
+				options.PageToken = pageToken
+				list := &server.AdminEntryList{Entries: []server.AdminEntry{}}
+				if all {
+					entries := c.client.ListEntries(ctx, options)
+					for entries.Next() {
+						list.Entries = append(list.Entries, entries.Entry())
+					}
+					if err := entries.Err(); err != nil {
+						return err
+					}
+				} else if list, err = c.client.ListEntriesPage(ctx, options); err != nil {
+					return err
+				}
+				if c.output == outputJSON {
+					return writeJSON(c.stdout, list)
+				}
+				w := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tCACHE KEY\tOWNER\tCREATED\tPIPELINE\tRUN")
+				for _, entry := range list.Entries {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.CacheKey, entry.Owner,
+						entry.CreatedAt.Format(time.RFC3339), entry.PipelineName, entry.RunID)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				if list.NextPageToken != "" {
+					fmt.Fprintf(c.stderr, "More entries are listed with --page_token=%s or --all.\n", list.NextPageToken)
+				}
+				return nil
+			},
+		},
+		"get": {
+			description:   "Prints an entry with its template and outputs.",
+			registerFlags: func(flags *flag.FlagSet) {},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 1 {
+					return usageErrorf("get takes the ID of an entry")
+				}
+				entry, err := c.client.GetEntry(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				if c.output == outputJSON {
+					return writeJSON(c.stdout, entry)
+				}
+				w := tabwriter.NewWriter(c.stdout, 0, 8, 1, ' ', 0)
+				for _, field := range [][2]string{
+					{"ID", entry.ID},
+					{"Cache key", entry.CacheKey},
+					{"Owner", entry.Owner},
+					{"Created", entry.CreatedAt.Format(time.RFC3339)},
+					{"Max staleness", fmt.Sprintf("%ds", entry.MaxCacheStaleness)},
+					{"Duration", fmt.Sprintf("%ds", entry.ExecutionDurationInSec)},
+					{"Pipeline", entry.PipelineName},
+					{"Pipeline version", entry.PipelineVersionID},
+					{"Run", entry.RunID},
+					{"Template", entry.Template},
+					{"Output", entry.Output},
+				} {
+					fmt.Fprintf(w, "%s:\t%s\n", field[0], field[1])
+				}
+				return w.Flush()
+			},
+		},
+		"delete": {
+			description: "Deletes an entry by ID, or all entries of a cache key with --key.",
+			registerFlags: func(flags *flag.FlagSet) {
+				flags.StringVar(&key, "key", "", "Cache key whose entries are deleted, instead of an ID.")
+			},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if (key == "") == (len(args) == 0) || len(args) > 1 {
+					return usageErrorf("delete takes either the ID of an entry or --key")
+				}
+				deletion := &server.AdminDeletion{Deleted: 1}
+				if key != "" {
+					if err := c.confirm(fmt.Sprintf("Delete all entries of cache key %q", key)); err != nil {
+						return err
+					}
+					var err error
+					if deletion, err = c.client.DeleteEntriesByKey(ctx, key); err != nil {
+						return err
+					}
+				} else {
+					if err := c.confirm(fmt.Sprintf("Delete entry %s", args[0])); err != nil {
+						return err
+					}
+					if err := c.client.DeleteEntry(ctx, args[0]); err != nil {
+						return err
+					}
+				}
+				if c.output == outputJSON {
+					return writeJSON(c.stdout, deletion)
+				}
+				fmt.Fprintf(c.stdout, "Deleted %d entries.\n", deletion.Deleted)
+				return nil
+			},
+		},
+		"invalidate": {
+			description: "Deletes at once the entries of a pipeline, pipeline version, run, key prefix or age.",
+			registerFlags: func(flags *flag.FlagSet) {
+				flags.StringVar(&selector.PipelineName, "pipeline_name", "", "Only the entries of the pipeline.")
+				flags.StringVar(&selector.PipelineVersionID, "pipeline_version_id", "", "Only the entries of the pipeline version, e.g. the previous one once a new version is uploaded.")
+				flags.StringVar(&selector.RunID, "run_id", "", "Only the entries of the run.")
+				flags.StringVar(&selector.KeyPrefix, "key_prefix", "", "Only the entries whose cache key starts with the prefix.")
+				flags.StringVar(&olderThan, "older_than", "", "Only the entries created before the RFC 3339 time, or longer ago than the duration, e.g. 720h.")
+				flags.BoolVar(&selector.All, "all", false, "Invalidate every entry, required without other selector.")
+				flags.BoolVar(&selector.DryRun, "dry_run", false, "Only count the entries.")
+			},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("invalidate takes no arguments")
+				}
+				if olderThan != "" {
+					if age, err := time.ParseDuration(olderThan); err == nil {
+						selector.OlderThan = c.now().Add(-age).UTC().Format(time.RFC3339)
+					} else {
+						selector.OlderThan = olderThan
+					}
+				}
+				if !selector.DryRun && !c.yes {
+					// The dry run tells how many entries would be invalidated before asking.
+					dryRun := selector
+					dryRun.DryRun = true
+					counted, err := c.client.Invalidate(ctx, dryRun)
+					if err != nil {
+						return err
+					}
+					if err := c.confirm(fmt.Sprintf("Invalidate %d entries", counted.Invalidated)); err != nil {
+						return err
+					}
+				}
+				invalidation, err := c.client.Invalidate(ctx, selector)
+				if err != nil {
+					return err
+				}
+				if c.output == outputJSON {
+					return writeJSON(c.stdout, invalidation)
+				}
+				if invalidation.DryRun {
+					fmt.Fprintf(c.stdout, "Would invalidate %d entries.\n", invalidation.Invalidated)
+				} else {
+					fmt.Fprintf(c.stdout, "Invalidated %d entries.\n", invalidation.Invalidated)
+				}
+				return nil
+			},
+		},
+		"stats": {
+			description:   "Prints the statistics of the cache store and of the lookups of the server.",
+			registerFlags: func(flags *flag.FlagSet) {},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("stats takes no arguments")
+				}
+				stats, err := c.client.Stats(ctx)
+				if err != nil {
+					return err
+				}
+				if c.output == outputJSON {
+					return writeJSON(c.stdout, stats)
+				}
+				return writeStatsTable(c.stdout, stats)
+			},
+		},
+		"export": {
+			description: "Writes the entries with their templates and outputs as JSON lines.",
+			registerFlags: func(flags *flag.FlagSet) {
+				filter.registerFlags(flags)
+				flags.StringVar(&file, "file", "-", "File the entries are written to, - for stdout.")
+			},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("export takes no arguments")
+				}
+				options, err := filter.options()
+				if err != nil {
+					return err
+				}
+				out := c.stdout
+				if file != "-" {
+					f, err := os.Create(file)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					out = f
+				}
+				w := bufio.NewWriter(out)
+				exported, err := c.client.Export(ctx, options, w)
+				if flushErr := w.Flush(); err == nil {
+					err = flushErr
+				}
+				if err != nil {
+					return fmt.Errorf("exported %d entries before failing: %w", exported, err)
+				}
+				fmt.Fprintf(c.stderr, "Exported %d entries.\n", exported)
+				return nil
+			},
+		},
+		"import": {
+			description: "Creates the entries of JSON lines written by export, as of now.",
+			registerFlags: func(flags *flag.FlagSet) {
+				flags.StringVar(&file, "file", "-", "File the entries are read from, - for stdin.")
+			},
+			run: func(ctx context.Context, c *cli, args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("import takes no arguments")
+				}
+				var in io.Reader = c.stdin
+				if file != "-" {
+					f, err := os.Open(file)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					in = f
+				}
+				imported, err := c.client.Import(ctx, in)
+				if err != nil {
+					return fmt.Errorf("imported %d entries before failing: %w", imported, err)
+				}
+				fmt.Fprintf(c.stderr, "Imported %d entries.\n", imported)
+				return nil
+			},
+		},
+	}
+}
+
+func writeStatsTable(out io.Writer, stats *server.Stats) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	if store := stats.Store; store != nil {
+		fmt.Fprintf(w, "Store, as of %s\n", store.ComputedAt.Format(time.RFC3339))
+		fmt.Fprintf(w, "  Entries:\t%d\n", store.Entries)
+		fmt.Fprintf(w, "  Output bytes:\t%d\n", store.OutputBytes)
+		fmt.Fprintf(w, "  Created in the last 24h:\t%d\n", store.CreatedLast24h)
+		fmt.Fprintf(w, "  Created in the last 7d:\t%d\n", store.CreatedLast7d)
+		fmt.Fprintf(w, "  Oldest entry age:\t%s\n", time.Duration(store.OldestEntryAgeSeconds)*time.Second)
+		fmt.Fprintln(w, "  TEMPLATE\tENTRIES\tOUTPUT BYTES")
+		for _, template := range store.TopTemplatesByBytes {
+			fmt.Fprintf(w, "  %s\t%d\t%d\n", template.Template, template.Entries, template.OutputBytes)
+		}
+	}
+	process := stats.Process
+	fmt.Fprintf(w, "Server, since %s\n", process.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "  Hits:\t%d\n", process.Hits)
+	fmt.Fprintf(w, "  Misses:\t%d\n", process.Misses)
+	fmt.Fprintln(w, "  TEMPLATE\tHITS\tMISSES")
+	for _, template := range process.TopTemplatesByHits {
+		fmt.Fprintf(w, "  %s\t%d\t%d\n", template.Template, template.Hits, template.Misses)
+	}
+	return w.Flush()
+}
+
+func writeJSON(w io.Writer, body interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(body)
+}
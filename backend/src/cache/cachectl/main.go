@@ -0,0 +1,228 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// cachectl manages the entries of the cache server through its admin API.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/server/client"
+)
+
+// The exit codes of cachectl, which scripts can tell apart.
+const (
+	exitOK int = 0
+	// exitFailure is a request the server rejected or failed, or an aborted command.
+	exitFailure int = 1
+	exitUsage   int = 2
+	// exitNotFound is a missing entry, or no entry of a cache key.
+	exitNotFound int = 3
+	// exitTransport is a request that got no response, e.g. from an unreachable server.
+	exitTransport int = 4
+)
+
+const (
+	// ServerEnv and TokenEnv are the defaults of --server and --token. TokenEnv is also the
+	// setting of the token on the server.
+	ServerEnv string = "CACHECTL_SERVER"
+	TokenEnv  string = "CACHE_ADMIN_TOKEN"
+
+	DefaultServer  string        = "http://localhost:" + config.DefaultHealthPort
+	DefaultTimeout time.Duration = 30 * time.Second
+
+	outputTable string = "table"
+	outputJSON  string = "json"
+)
+
+// errAborted is a destructive command that was not confirmed.
+var errAborted = errors.New("aborted")
+
+// usageError is an invalid command line.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, a...)}
+}
+
+// env is what a command reads and writes besides the admin API.
+type env struct {
+	stdin     *bufio.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	lookupEnv config.LookupEnvFunc
+	now       func() time.Time
+}
+
+// subcommand is a command of cachectl. registerFlags adds the flags of the command only, run runs
+// it with the positional arguments.
+type subcommand struct {
+	description   string
+	registerFlags func(flags *flag.FlagSet)
+	run           func(ctx context.Context, c *cli, args []string) error
+}
+
+// cli holds the flags every command takes.
+type cli struct {
+	env
+	client *client.Client
+	output string
+	yes    bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], env{
+		stdin:     bufio.NewReader(os.Stdin),
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		lookupEnv: os.LookupEnv,
+		now:       time.Now,
+	}))
+}
+
+// run runs the command named by the first argument and returns the exit code.
+func run(args []string, e env) int {
+	commands := subcommands()
+	if len(args) == 0 || args[0] == "help" || strings.HasPrefix(args[0], "-") {
+		printUsage(e.stderr, commands)
+		if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+			return exitOK
+		}
+		return exitUsage
+	}
+	command, exists := commands[args[0]]
+	if !exists {
+		fmt.Fprintf(e.stderr, "cachectl: unknown command %q\n", args[0])
+		printUsage(e.stderr, commands)
+		return exitUsage
+	}
+
+	c := &cli{env: e}
+	flags := flag.NewFlagSet("cachectl "+args[0], flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	server, _ := e.lookupEnv(ServerEnv)
+	if server == "" {
+		server = DefaultServer
+	}
+	token, _ := e.lookupEnv(TokenEnv)
+	flags.StringVar(&server, "server", server, "Address of the health port of the cache server. Defaults to $"+ServerEnv+".")
+	flags.StringVar(&token, "token", token, "Bearer token of the admin API. Defaults to $"+TokenEnv+".")
+	flags.StringVar(&c.output, "output", outputTable, "Output format, table or json.")
+	flags.BoolVar(&c.yes, "yes", false, "Run destructive commands without asking for confirmation.")
+	timeout := flags.Duration("timeout", DefaultTimeout, "Time limit of each request.")
+	command.registerFlags(flags)
+
+	positional, err := parseInterspersed(flags, args[1:])
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err == nil && c.output != outputTable && c.output != outputJSON {
+		err = usageErrorf("invalid --output %q, must be %s or %s", c.output, outputTable, outputJSON)
+	}
+	if err == nil {
+		// Commands are run by hand or by scripts that tell failures apart, so requests are not sent
+		// again.
+		c.client, err = client.New(client.Config{BaseURL: server, Token: token, Timeout: *timeout, Retry: client.RetryPolicy{MaxAttempts: 1}})
+	}
+	if err != nil {
+		fmt.Fprintf(e.stderr, "cachectl %s: %v\n", args[0], err)
+		return exitUsage
+	}
+
+	if err := command.run(context.Background(), c, positional); err != nil {
+		fmt.Fprintf(e.stderr, "cachectl %s: %v\n", args[0], err)
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// exitCode returns the exit code of the error of a command.
+func exitCode(err error) int {
+	var usage *usageError
+	var transport *client.TransportError
+	var notFound *client.NotFoundError
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &transport):
+		return exitTransport
+	case errors.As(err, &notFound):
+		return exitNotFound
+	}
+	return exitFailure
+}
+
+// parseInterspersed parses the flags wherever they are among the positional arguments, which it
+// returns, e.g. both get --output=json 42 and get 42 --output=json.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// confirm asks whether to go ahead with the destructive action, unless --yes was given.
+func (c *cli) confirm(action string) error {
+	if c.yes {
+		return nil
+	}
+	fmt.Fprintf(c.stderr, "%s? [y/N] ", action)
+	answer, err := c.stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errAborted
+}
+
+func printUsage(w io.Writer, commands map[string]subcommand) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Usage: cachectl <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-11s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintln(w, "\nRun cachectl <command> --help for the flags of a command.")
+}
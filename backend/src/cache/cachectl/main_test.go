@@ -0,0 +1,294 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "admin-token"
+
+// testServer serves the admin API and stats over a fake store.
+type testServer struct {
+	*httptest.Server
+	// listRequests counts the requests listing entries.
+	listRequests int32
+}
+
+// newTestServer returns a server over a store holding entries of the keys, created one second
+// apart from 2020-01-01 on.
+func newTestServer(t *testing.T, keys ...string) *testServer {
+	clientManager := server.NewFakeClientManagerOrFatal(util.NewFakeTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	t.Cleanup(func() { clientManager.Close() })
+	for _, key := range keys {
+		_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: key,
+			ExecutionTemplate: `{"name":"train","container":{}}`,
+			ExecutionOutput:   "output of " + key,
+			MaxCacheStaleness: -1,
+			PipelineName:      "pipeline",
+		})
+		require.Nil(t, err)
+	}
+	store := clientManager.CacheStore()
+	adminHandler := server.AdminHandler(store.(storage.ExecutionCacheAdminStore))
+	s := &testServer{}
+	mux := http.NewServeMux()
+	mux.Handle(server.AdminEntriesAPI, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&s.listRequests, 1)
+		}
+		adminHandler.ServeHTTP(w, r)
+	}))
+	mux.Handle(server.AdminEntriesAPI+"/", adminHandler)
+	mux.Handle(server.AdminInvalidateAPI, adminHandler)
+	mux.Handle(server.StatsAPI, server.StatsHandler(server.NewStatsCollector(store.(storage.ExecutionCacheStatsStore), time.Minute, util.NewRealTime())))
+	s.Server = httptest.NewServer(server.RequireAdminToken(server.NewAdminToken(testToken), mux))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// runCachectl runs cachectl against the server with the input and returns its exit code and
+// outputs.
+func runCachectl(t *testing.T, s *testServer, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, env{
+		stdin:  bufio.NewReader(strings.NewReader(stdin)),
+		stdout: &stdout,
+		stderr: &stderr,
+		lookupEnv: func(name string) (string, bool) {
+			switch name {
+			case ServerEnv:
+				return s.URL, true
+			case TokenEnv:
+				return testToken, true
+			}
+			return "", false
+		},
+		now: func() time.Time { return time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC) },
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestListFollowsThePages(t *testing.T) {
+	s := newTestServer(t, "a", "b", "c", "d", "e")
+
+	code, stdout, stderr := runCachectl(t, s, "", "list", "--page_size=2", "--all", "--output=json")
+	require.Equal(t, exitOK, code, stderr)
+	var list server.AdminEntryList
+	require.Nil(t, json.Unmarshal([]byte(stdout), &list))
+	var keys []string
+	for _, entry := range list.Entries {
+		keys = append(keys, entry.CacheKey)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+	assert.Empty(t, list.NextPageToken)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&s.listRequests))
+}
+
+func TestListPrintsAPage(t *testing.T) {
+	s := newTestServer(t, "a", "b", "c")
+
+	code, stdout, stderr := runCachectl(t, s, "", "list", "--page_size=2")
+	require.Equal(t, exitOK, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"ID", "CACHE", "KEY", "OWNER", "CREATED", "PIPELINE", "RUN"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"1", "a", "2020-01-01T00:00:01Z", "pipeline"}, strings.Fields(lines[1]))
+	assert.Contains(t, stderr, "--page_token=")
+
+	code, stdout, _ = runCachectl(t, s, "", "list", "--key_prefix=c", "--output=json")
+	require.Equal(t, exitOK, code)
+	var list server.AdminEntryList
+	require.Nil(t, json.Unmarshal([]byte(stdout), &list))
+	require.Len(t, list.Entries, 1)
+	assert.Equal(t, "c", list.Entries[0].CacheKey)
+
+	code, stdout, _ = runCachectl(t, s, "", "list", "--pipeline_name=other", "--output=json")
+	require.Equal(t, exitOK, code)
+	require.Nil(t, json.Unmarshal([]byte(stdout), &list))
+	assert.Empty(t, list.Entries)
+}
+
+func TestGet(t *testing.T) {
+	s := newTestServer(t, "a")
+
+	code, stdout, stderr := runCachectl(t, s, "", "get", "1", "--output=json")
+	require.Equal(t, exitOK, code, stderr)
+	var entry server.AdminEntry
+	require.Nil(t, json.Unmarshal([]byte(stdout), &entry))
+	assert.Equal(t, "a", entry.CacheKey)
+	assert.Equal(t, "output of a", entry.Output)
+
+	code, stdout, _ = runCachectl(t, s, "", "get", "1")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Output:           output of a\n")
+
+	code, _, stderr = runCachectl(t, s, "", "get", "2")
+	assert.Equal(t, exitNotFound, code)
+	assert.Contains(t, stderr, "404 Not Found: cache entry not found")
+}
+
+func TestDeleteAsksForConfirmation(t *testing.T) {
+	s := newTestServer(t, "a", "b", "b")
+
+	code, _, stderr := runCachectl(t, s, "n\n", "delete", "1")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "Delete entry 1? [y/N] ")
+	assert.Contains(t, stderr, "aborted")
+	code, _, _ = runCachectl(t, s, "", "delete", "1")
+	assert.Equal(t, exitFailure, code, "no answer aborts")
+	code, _, _ = runCachectl(t, s, "", "get", "1")
+	assert.Equal(t, exitOK, code)
+
+	code, stdout, _ := runCachectl(t, s, "y\n", "delete", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "Deleted 1 entries.\n", stdout)
+	code, _, _ = runCachectl(t, s, "", "delete", "1", "--yes")
+	assert.Equal(t, exitNotFound, code)
+
+	code, stdout, _ = runCachectl(t, s, "", "delete", "--key=b", "--yes", "--output=json")
+	assert.Equal(t, exitOK, code)
+	var deletion server.AdminDeletion
+	require.Nil(t, json.Unmarshal([]byte(stdout), &deletion))
+	assert.Equal(t, server.AdminDeletion{Deleted: 2}, deletion)
+	code, _, _ = runCachectl(t, s, "", "delete", "--key=b", "--yes")
+	assert.Equal(t, exitNotFound, code)
+}
+
+func TestInvalidate(t *testing.T) {
+	s := newTestServer(t, "a1", "a2", "b1")
+
+	code, stdout, stderr := runCachectl(t, s, "", "invalidate", "--key_prefix=a", "--dry_run")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "Would invalidate 2 entries.\n", stdout)
+
+	code, _, stderr = runCachectl(t, s, "no\n", "invalidate", "--key_prefix=a")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "Invalidate 2 entries? [y/N] ")
+
+	code, stdout, stderr = runCachectl(t, s, "yes\n", "invalidate", "--key_prefix=a")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "Invalidated 2 entries.\n", stdout)
+
+	// The remaining entry was created at 00:00:03, more than 30 minutes before now.
+	code, stdout, stderr = runCachectl(t, s, "", "invalidate", "--older_than=30m", "--yes", "--output=json")
+	require.Equal(t, exitOK, code, stderr)
+	var invalidation server.AdminInvalidation
+	require.Nil(t, json.Unmarshal([]byte(stdout), &invalidation))
+	assert.Equal(t, server.AdminInvalidation{Invalidated: 1}, invalidation)
+
+	code, _, stderr = runCachectl(t, s, "", "invalidate", "--yes")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "400 Bad Request")
+}
+
+func TestStats(t *testing.T) {
+	s := newTestServer(t, "a", "b")
+
+	code, stdout, stderr := runCachectl(t, s, "", "stats", "--output=json")
+	require.Equal(t, exitOK, code, stderr)
+	var stats server.Stats
+	require.Nil(t, json.Unmarshal([]byte(stdout), &stats))
+	assert.Equal(t, server.StatsSchemaVersion, stats.SchemaVersion)
+	require.NotNil(t, stats.Store)
+	assert.Equal(t, int64(2), stats.Store.Entries)
+
+	code, stdout, _ = runCachectl(t, s, "", "stats")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Entries:")
+	assert.Contains(t, stdout, "train")
+}
+
+func TestExportAndImport(t *testing.T) {
+	source := newTestServer(t, "a", "b", "c")
+	destination := newTestServer(t)
+
+	code, exported, stderr := runCachectl(t, source, "", "export")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "Exported 3 entries.\n", stderr)
+	assert.Len(t, strings.Split(strings.TrimSpace(exported), "\n"), 3)
+
+	code, _, stderr = runCachectl(t, destination, exported, "import")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "Imported 3 entries.\n", stderr)
+
+	code, stdout, _ := runCachectl(t, destination, "", "get", "3", "--output=json")
+	require.Equal(t, exitOK, code)
+	var entry server.AdminEntry
+	require.Nil(t, json.Unmarshal([]byte(stdout), &entry))
+	assert.Equal(t, "c", entry.CacheKey)
+	assert.Equal(t, "output of c", entry.Output)
+	assert.Equal(t, "pipeline", entry.PipelineName)
+
+	code, _, stderr = runCachectl(t, destination, exported+"{\"cacheKey\":\n", "import")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "imported 3 entries before failing: invalid entry on line 4")
+}
+
+func TestExitCodes(t *testing.T) {
+	s := newTestServer(t, "a")
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "no command", args: nil, code: exitUsage},
+		{name: "help", args: []string{"help"}, code: exitOK},
+		{name: "unknown command", args: []string{"purge"}, code: exitUsage},
+		{name: "unknown flag", args: []string{"list", "--limit=2"}, code: exitUsage},
+		{name: "invalid output", args: []string{"list", "--output=yaml"}, code: exitUsage},
+		{name: "missing ID", args: []string{"get"}, code: exitUsage},
+		{name: "ID and key", args: []string{"delete", "1", "--key=a", "--yes"}, code: exitUsage},
+		{name: "invalid token", args: []string{"list", "--token=wrong"}, code: exitFailure},
+		{name: "invalid ID", args: []string{"get", "abc"}, code: exitFailure},
+		{name: "missing entry", args: []string{"get", "2"}, code: exitNotFound},
+		{name: "unreachable server", args: []string{"list", "--server=http://127.0.0.1:1"}, code: exitTransport},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := runCachectl(t, s, "", test.args...)
+			assert.Equal(t, test.code, code, stderr)
+		})
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	for _, args := range [][]string{{"--output=json", "42"}, {"42", "--output=json"}, {"4", "--output", "json", "2"}} {
+		flags := flag.NewFlagSet("cachectl get", flag.ContinueOnError)
+		output := flags.String("output", outputTable, "")
+		positional, err := parseInterspersed(flags, args)
+		require.Nil(t, err)
+		assert.Equal(t, outputJSON, *output)
+		assert.Equal(t, strings.Join(positional, ""), "42")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	argoclient "github.com/argoproj/argo/pkg/client/clientset/versioned"
+	argoprojv1alpha1 "github.com/argoproj/argo/pkg/client/clientset/versioned/typed/workflow/v1alpha1"
+	"github.com/cenkalti/backoff"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+)
+
+type ArgoClientInterface interface {
+	Workflow(namespace string) argoprojv1alpha1.WorkflowInterface
+	// OffloadedNodes returns the node statuses of a workflow offloaded to the persistence of Argo.
+	OffloadedNodes(uid string, version string) (wfv1.Nodes, error)
+}
+
+type ArgoClient struct {
+	argoProjClient argoprojv1alpha1.ArgoprojV1alpha1Interface
+	// offloadedNodes is nil when the persistence of Argo is not configured.
+	offloadedNodes *ArgoOffloadedNodes
+}
+
+func (c *ArgoClient) Workflow(namespace string) argoprojv1alpha1.WorkflowInterface {
+	return c.argoProjClient.Workflows(namespace)
+}
+
+func (c *ArgoClient) OffloadedNodes(uid string, version string) (wfv1.Nodes, error) {
+	if c.offloadedNodes == nil {
+		return nil, errors.New("the node statuses are offloaded but the persistence of Argo is not configured")
+	}
+	return c.offloadedNodes.Get(uid, version)
+}
+
+// factory function for an ArgoClient of the given clientset, e.g. a fake one. offloadedNodes may
+// be nil.
+func NewArgoClient(clientSet argoclient.Interface, offloadedNodes *ArgoOffloadedNodes) ArgoClientInterface {
+	return &ArgoClient{clientSet.ArgoprojV1alpha1(), offloadedNodes}
+}
+
+// CreateArgoClientOrFatal creates a new client for the Argo workflows.
+func CreateArgoClientOrFatal(initConnectionTimeout time.Duration, offloadedNodes *ArgoOffloadedNodes) ArgoClientInterface {
+	var client ArgoClientInterface
+	var operation = func() error {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return errors.Wrap(err, "Failed to initialize the RestConfig")
+		}
+		clientSet, err := argoclient.NewForConfig(restConfig)
+		if err != nil {
+			return errors.Wrap(err, "Failed to initialize the Argo client set")
+		}
+		client = NewArgoClient(clientSet, offloadedNodes)
+		return nil
+	}
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = initConnectionTimeout
+	err := backoff.Retry(operation, b)
+
+	if err != nil {
+		glog.Fatalf("Failed to create Argo client. Error: %v", err)
+	}
+	return client
+}
+
+// ArgoOffloadedNodes reads the node statuses that the Argo controller offloads to its persistence
+// when they are too large for the Workflow object, as Argo's own offload repository does.
+type ArgoOffloadedNodes struct {
+	db          *sql.DB
+	clusterName string
+	tableName   string
+}
+
+// factory function for the offloaded node statuses of the cluster in the table of db
+func NewArgoOffloadedNodes(db *sql.DB, clusterName string, tableName string) *ArgoOffloadedNodes {
+	return &ArgoOffloadedNodes{db: db, clusterName: clusterName, tableName: tableName}
+}
+
+// Get returns the node statuses of the given version of a workflow.
+func (o *ArgoOffloadedNodes) Get(uid string, version string) (wfv1.Nodes, error) {
+	var raw string
+	query := fmt.Sprintf("SELECT nodes FROM %s WHERE clustername = ? AND uid = ? AND version = ?", o.tableName)
+	if err := o.db.QueryRow(query, o.clusterName, uid, version).Scan(&raw); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read the offloaded nodes of workflow %s version %s", uid, version)
+	}
+	nodes := wfv1.Nodes{}
+	if err := json.Unmarshal([]byte(raw), &nodes); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the offloaded nodes of workflow %s version %s", uid, version)
+	}
+	return nodes, nil
+}
+
+// CloseIdleConnections makes the database reconnect, e.g. with a rotated password.
+func (o *ArgoOffloadedNodes) CloseIdleConnections() {
+	CloseIdleConnections(o.db, defaultMaxIdleConns)
+}
+
+// Close closes the database of the offloaded node statuses.
+func (o *ArgoOffloadedNodes) Close() error {
+	return o.db.Close()
+}
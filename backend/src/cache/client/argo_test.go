@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"database/sql"
+	"testing"
+
+	argofake "github.com/argoproj/argo/pkg/client/clientset/versioned/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOffloadDB returns a database with the offload table of Argo, holding the nodes of a workflow.
+func newOffloadDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE argo_workflows (clustername VARCHAR(64), uid VARCHAR(128), version VARCHAR(64), namespace VARCHAR(256), nodes TEXT, updatedat TIMESTAMP)`)
+	require.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO argo_workflows (clustername, uid, version, namespace, nodes) VALUES
+		('default', 'wf-uid', 'fnv:1', 'kubeflow', '{"step-1":{"id":"step-1","name":"wf.step","outputs":{"parameters":[{"name":"message","value":"Hello"}]}}}'),
+		('other', 'wf-uid', 'fnv:1', 'kubeflow', '{}')`)
+	require.Nil(t, err)
+	return db
+}
+
+func TestArgoOffloadedNodesGet(t *testing.T) {
+	db := newOffloadDB(t)
+	defer db.Close()
+	offloaded := NewArgoOffloadedNodes(db, "default", "argo_workflows")
+
+	nodes, err := offloaded.Get("wf-uid", "fnv:1")
+	require.Nil(t, err)
+	require.Contains(t, nodes, "step-1")
+	assert.Equal(t, "wf.step", nodes["step-1"].Name)
+	assert.Equal(t, "Hello", *nodes["step-1"].Outputs.Parameters[0].Value)
+
+	_, err = offloaded.Get("wf-uid", "fnv:2")
+	assert.NotNil(t, err, "versions are not mixed up")
+}
+
+func TestArgoClientOffloadedNodesRequiresPersistence(t *testing.T) {
+	argoClient := NewArgoClient(argofake.NewSimpleClientset(), nil)
+
+	_, err := argoClient.OffloadedNodes("wf-uid", "fnv:1")
+
+	assert.Contains(t, err.Error(), "persistence of Argo is not configured")
+}
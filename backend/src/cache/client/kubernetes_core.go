@@ -7,22 +7,39 @@ import (
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"k8s.io/client-go/kubernetes"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 )
 
 type KubernetesCoreInterface interface {
 	PodClient(namespace string) v1.PodInterface
+	NamespaceClient() v1.NamespaceInterface
+	LeaseClient(namespace string) coordinationv1.LeaseInterface
 }
 
 type KubernetesCore struct {
-	coreV1Client v1.CoreV1Interface
+	coreV1Client         v1.CoreV1Interface
+	coordinationV1Client coordinationv1.CoordinationV1Interface
 }
 
 func (c *KubernetesCore) PodClient(namespace string) v1.PodInterface {
 	return c.coreV1Client.Pods(namespace)
 }
 
+func (c *KubernetesCore) NamespaceClient() v1.NamespaceInterface {
+	return c.coreV1Client.Namespaces()
+}
+
+func (c *KubernetesCore) LeaseClient(namespace string) coordinationv1.LeaseInterface {
+	return c.coordinationV1Client.Leases(namespace)
+}
+
+// factory function for a KubernetesCore of the given clientset, e.g. a fake one
+func NewKubernetesCore(clientSet kubernetes.Interface) KubernetesCoreInterface {
+	return &KubernetesCore{clientSet.CoreV1(), clientSet.CoordinationV1()}
+}
+
 func createKubernetesCore() (KubernetesCoreInterface, error) {
 	restConfig, err := rest.InClusterConfig()
 	if err != nil {
@@ -33,7 +50,7 @@ func createKubernetesCore() (KubernetesCoreInterface, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to initialize kubernetes client set.")
 	}
-	return &KubernetesCore{clientSet.CoreV1()}, nil
+	return NewKubernetesCore(clientSet), nil
 }
 
 // CreateKubernetesCoreOrFatal creates a new client for the Kubernetes pod.
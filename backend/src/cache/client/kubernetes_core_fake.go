@@ -15,7 +15,9 @@
 package client
 
 import (
+	"github.com/golang/glog"
 	"github.com/kubeflow/pipelines/backend/src/common/util"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
@@ -30,6 +32,16 @@ func (c *FakeKuberneteCoreClient) PodClient(namespace string) v1.PodInterface {
 	return c.podClientFake
 }
 
+func (c *FakeKuberneteCoreClient) NamespaceClient() v1.NamespaceInterface {
+	glog.Error("This fake method is not yet implemented.")
+	return nil
+}
+
+func (c *FakeKuberneteCoreClient) LeaseClient(namespace string) coordinationv1.LeaseInterface {
+	glog.Error("This fake method is not yet implemented.")
+	return nil
+}
+
 func NewFakeKuberneteCoresClient() *FakeKuberneteCoreClient {
 	return &FakeKuberneteCoreClient{&FakePodClient{}}
 }
@@ -45,3 +57,13 @@ func NewFakeKubernetesCoreClientWithBadPodClient() *FakeKubernetesCoreClientWith
 func (c *FakeKubernetesCoreClientWithBadPodClient) PodClient(namespace string) v1.PodInterface {
 	return c.podClientFake
 }
+
+func (c *FakeKubernetesCoreClientWithBadPodClient) NamespaceClient() v1.NamespaceInterface {
+	glog.Error("This fake method is not yet implemented.")
+	return nil
+}
+
+func (c *FakeKubernetesCoreClientWithBadPodClient) LeaseClient(namespace string) coordinationv1.LeaseInterface {
+	glog.Error("This fake method is not yet implemented.")
+	return nil
+}
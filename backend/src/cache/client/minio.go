@@ -0,0 +1,136 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/golang/glog"
+	minio "github.com/minio/minio-go"
+	credentials "github.com/minio/minio-go/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// MinioKeys is a credentials provider serving an access key pair, which is replaced when the
+// mounted secret rotates.
+type MinioKeys struct {
+	mu        sync.Mutex
+	accessKey string
+	secretKey string
+	retrieved bool
+}
+
+// factory function for MinioKeys
+func NewMinioKeys(accessKey string, secretKey string) *MinioKeys {
+	return &MinioKeys{accessKey: accessKey, secretKey: secretKey}
+}
+
+func (k *MinioKeys) Retrieve() (credentials.Value, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.retrieved = true
+	return credentials.Value{
+		AccessKeyID:     k.accessKey,
+		SecretAccessKey: k.secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// IsExpired reports whether the keys changed since they were last retrieved.
+func (k *MinioKeys) IsExpired() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return !k.retrieved
+}
+
+// SetKeys makes the requests signed from now on use the key pair.
+func (k *MinioKeys) SetKeys(accessKey string, secretKey string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.accessKey = accessKey
+	k.secretKey = secretKey
+	k.retrieved = false
+}
+
+func (k *MinioKeys) isSet() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.accessKey != "" && k.secretKey != ""
+}
+
+// createCredentialProvidersChain creates a chained providers credential for a minio client
+func createCredentialProvidersChain(keys *MinioKeys) *credentials.Credentials {
+	// first try with static api key
+	if keys != nil && keys.isSet() {
+		return credentials.New(keys)
+	}
+	// otherwise use a chained provider: minioEnv -> awsEnv -> IAM
+	providers := []credentials.Provider{
+		&credentials.EnvMinio{},
+		&credentials.EnvAWS{},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	}
+	return credentials.New(&credentials.Chain{Providers: providers})
+}
+
+func createMinioCore(minioServiceHost string, minioServicePort string,
+	keys *MinioKeys, secure bool, region string) (*minio.Core, error) {
+	endpoint := minioServiceHost
+	if minioServicePort != "" {
+		endpoint = fmt.Sprintf("%s:%s", minioServiceHost, minioServicePort)
+	}
+	cred := createCredentialProvidersChain(keys)
+	minioClient, err := minio.NewWithCredentials(endpoint, cred, secure, region)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while creating minio client: %+v", err)
+	}
+	return &minio.Core{Client: minioClient}, nil
+}
+
+// CreateMinioCoreOrFatal creates a low level S3-compatible client and makes sure the bucket exists.
+func CreateMinioCoreOrFatal(minioServiceHost string, minioServicePort string, keys *MinioKeys,
+	secure bool, region string, bucketName string, initConnectionTimeout time.Duration) *minio.Core {
+	var core *minio.Core
+	var err error
+	var operation = func() error {
+		core, err = createMinioCore(minioServiceHost, minioServicePort, keys, secure, region)
+		if err != nil {
+			return err
+		}
+		exists, err := core.BucketExists(bucketName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return core.MakeBucket(bucketName, region)
+		}
+		return nil
+	}
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = initConnectionTimeout
+	err = backoff.Retry(operation, b)
+	if err != nil {
+		glog.Fatalf("Failed to create Minio client. Error: %v", err)
+	}
+	return core
+}
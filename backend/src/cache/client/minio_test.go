@@ -0,0 +1,39 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinioKeysSignWithRotatedKeys(t *testing.T) {
+	keys := NewMinioKeys("minio", "first")
+	credentials := createCredentialProvidersChain(keys)
+
+	value, err := credentials.Get()
+	require.Nil(t, err)
+	assert.Equal(t, "first", value.SecretAccessKey)
+	assert.False(t, keys.IsExpired())
+
+	keys.SetKeys("minio", "second")
+	assert.True(t, keys.IsExpired())
+	value, err = credentials.Get()
+	require.Nil(t, err)
+	assert.Equal(t, "minio", value.AccessKeyID)
+	assert.Equal(t, "second", value.SecretAccessKey)
+}
@@ -0,0 +1,492 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultRedisPingInterval = 10 * time.Second
+
+	RedisModeStandalone string = "standalone"
+	RedisModeSentinel   string = "sentinel"
+	RedisModeCluster    string = "cluster"
+
+	// redisReconnectGracePeriod is how long the connections of a replaced client are kept for the
+	// commands already sent on them.
+	redisReconnectGracePeriod = 10 * time.Second
+
+	// redisPoolTimeoutMessage is the message of the unexported error go-redis returns when no pooled
+	// connection became available within the pool timeout.
+	redisPoolTimeoutMessage = "redis: connection pool timeout"
+)
+
+// RedisClientInterface is the part of the go-redis API used by the cache. It is implemented for
+// the standalone, failover and cluster clients alike, so callers need not know the deployment mode.
+// Commands give up once their context is done.
+type RedisClientInterface interface {
+	Ping(ctx context.Context) *redis.StatusCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	PoolStats() *redis.PoolStats
+	AddHook(hook redis.Hook)
+	Close() error
+}
+
+// contextRedisClient adapts a go-redis client, whose commands take their context from the client
+// rather than from an argument, to RedisClientInterface.
+type contextRedisClient struct {
+	withContext func(ctx context.Context) redis.Cmdable
+	poolStats   func() *redis.PoolStats
+	addHook     func(hook redis.Hook)
+	close       func() error
+}
+
+func (c *contextRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
+	return c.withContext(ctx).Ping()
+}
+
+func (c *contextRedisClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	return c.withContext(ctx).Get(key)
+}
+
+func (c *contextRedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return c.withContext(ctx).Set(key, value, expiration)
+}
+
+func (c *contextRedisClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return c.withContext(ctx).Del(keys...)
+}
+
+func (c *contextRedisClient) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
+	return c.withContext(ctx).HGetAll(key)
+}
+
+func (c *contextRedisClient) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	return c.withContext(ctx).SMembers(key)
+}
+
+func (c *contextRedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	return c.withContext(ctx).Scan(cursor, match, count)
+}
+
+func (c *contextRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return c.withContext(ctx).Eval(script, keys, args...)
+}
+
+func (c *contextRedisClient) PoolStats() *redis.PoolStats {
+	return c.poolStats()
+}
+
+func (c *contextRedisClient) AddHook(hook redis.Hook) {
+	c.addHook(hook)
+}
+
+func (c *contextRedisClient) Close() error {
+	return c.close()
+}
+
+// NewRedisClientInterface wraps a standalone or failover client.
+func NewRedisClientInterface(client *redis.Client) RedisClientInterface {
+	return &contextRedisClient{
+		withContext: func(ctx context.Context) redis.Cmdable { return client.WithContext(ctx) },
+		poolStats:   client.PoolStats,
+		addHook:     client.AddHook,
+		close:       client.Close,
+	}
+}
+
+// NewRedisClusterClientInterface wraps a cluster client.
+func NewRedisClusterClientInterface(client *redis.ClusterClient) RedisClientInterface {
+	return &contextRedisClient{
+		withContext: func(ctx context.Context) redis.Cmdable { return client.WithContext(ctx) },
+		poolStats:   client.PoolStats,
+		addHook:     client.AddHook,
+		close:       client.Close,
+	}
+}
+
+// IsRedisTimeout reports whether the command failed because its context or a network deadline
+// expired, or no pooled connection became available in time.
+func IsRedisTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled || err.Error() == redisPoolTimeoutMessage {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// RedisConfig describes how to reach and authenticate to Redis.
+type RedisConfig struct {
+	// Mode is one of standalone, sentinel or cluster. An empty mode means standalone.
+	Mode string
+	// Host and Port address a standalone server. In the other modes Host only serves as the expected
+	// TLS server name.
+	Host string
+	Port string
+	// MasterName and Addresses locate the master through sentinels in sentinel mode. In cluster mode
+	// Addresses are the seed nodes.
+	MasterName string
+	Addresses  []string
+	// Password is resolved by the caller, e.g. read from a mounted Secret with the other credentials
+	// of the cache server.
+	Password   string
+	DB         int
+	TLSEnabled bool
+	// TLSCACertPath is a PEM bundle verifying the server certificate. The system roots are used when
+	// it is empty.
+	TLSCACertPath         string
+	TLSInsecureSkipVerify bool
+	// DialTimeout, ReadTimeout, WriteTimeout and PoolTimeout bound connecting, reading replies,
+	// writing commands and waiting for a pooled connection. Zero values keep the go-redis defaults.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+	// PoolSize caps the connections per Redis node and MinIdleConns keeps that many connections
+	// open ahead of bursts. A zero PoolSize keeps the go-redis default of ten per CPU.
+	PoolSize     int
+	MinIdleConns int
+	// MaxRetries is the number of times a command failing on a network error is retried, with the
+	// go-redis backoff. Zero does not retry.
+	MaxRetries int
+}
+
+// Options converts the configuration into go-redis options for a standalone server, reading the
+// password and CA files.
+func (c RedisConfig) Options() (*redis.Options, error) {
+	if c.DB < 0 {
+		return nil, fmt.Errorf("Invalid Redis DB index %d", c.DB)
+	}
+	password, tlsConfig, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return &redis.Options{
+		Addr:         fmt.Sprintf("%s:%s", c.Host, c.Port),
+		Password:     password,
+		DB:           c.DB,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		PoolTimeout:  c.PoolTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+	}, nil
+}
+
+// FailoverOptions converts the configuration into go-redis options for a Sentinel managed master.
+func (c RedisConfig) FailoverOptions() (*redis.FailoverOptions, error) {
+	if c.DB < 0 {
+		return nil, fmt.Errorf("Invalid Redis DB index %d", c.DB)
+	}
+	if c.MasterName == "" || len(c.Addresses) == 0 {
+		return nil, fmt.Errorf("Redis sentinel mode requires a master name and at least one sentinel address")
+	}
+	password, tlsConfig, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return &redis.FailoverOptions{
+		MasterName:    c.MasterName,
+		SentinelAddrs: c.Addresses,
+		Password:      password,
+		DB:            c.DB,
+		TLSConfig:     tlsConfig,
+		DialTimeout:   c.DialTimeout,
+		ReadTimeout:   c.ReadTimeout,
+		WriteTimeout:  c.WriteTimeout,
+		PoolTimeout:   c.PoolTimeout,
+		PoolSize:      c.PoolSize,
+		MinIdleConns:  c.MinIdleConns,
+		MaxRetries:    c.MaxRetries,
+	}, nil
+}
+
+// ClusterOptions converts the configuration into go-redis options for a Redis Cluster.
+func (c RedisConfig) ClusterOptions() (*redis.ClusterOptions, error) {
+	if c.DB != 0 {
+		return nil, fmt.Errorf("Redis cluster mode only supports DB index 0, got %d", c.DB)
+	}
+	if len(c.Addresses) == 0 {
+		return nil, fmt.Errorf("Redis cluster mode requires at least one seed address")
+	}
+	password, tlsConfig, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return &redis.ClusterOptions{
+		Addrs:        c.Addresses,
+		Password:     password,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		PoolTimeout:  c.PoolTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+	}, nil
+}
+
+// newClient creates the client matching the mode together with a description of the addresses
+// for logging.
+func (c RedisConfig) newClient() (RedisClientInterface, string, error) {
+	switch c.Mode {
+	case "", RedisModeStandalone:
+		options, err := c.Options()
+		if err != nil {
+			return nil, "", err
+		}
+		return NewRedisClientInterface(redis.NewClient(options)), options.Addr, nil
+	case RedisModeSentinel:
+		options, err := c.FailoverOptions()
+		if err != nil {
+			return nil, "", err
+		}
+		return NewRedisClientInterface(redis.NewFailoverClient(options)), fmt.Sprintf("master %s via sentinels %s", c.MasterName, strings.Join(c.Addresses, ",")), nil
+	case RedisModeCluster:
+		options, err := c.ClusterOptions()
+		if err != nil {
+			return nil, "", err
+		}
+		return NewRedisClusterClientInterface(redis.NewClusterClient(options)), "cluster " + strings.Join(c.Addresses, ","), nil
+	default:
+		return nil, "", fmt.Errorf("Redis mode %q is not supported", c.Mode)
+	}
+}
+
+func (c RedisConfig) credentials() (string, *tls.Config, error) {
+	password := c.Password
+	if !c.TLSEnabled {
+		return password, nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         c.Host,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+	if c.TLSCACertPath != "" && !c.TLSInsecureSkipVerify {
+		b, err := ioutil.ReadFile(c.TLSCACertPath)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "Failed to read Redis CA certificate %q", c.TLSCACertPath)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return "", nil, fmt.Errorf("No valid PEM certificate found in Redis CA certificate %q", c.TLSCACertPath)
+		}
+	}
+	return password, tlsConfig, nil
+}
+
+// RedisClient is a lazily connected Redis client. Instead of failing at startup, a background ping
+// loop keeps track of whether Redis is reachable so that callers can degrade while it is not.
+type RedisClient struct {
+	// mu guards the go-redis client, which is replaced when the password rotates. config and hook
+	// create its replacement.
+	mu      sync.RWMutex
+	current RedisClientInterface
+	config  RedisConfig
+	hook    redis.Hook
+
+	address      string
+	pingInterval time.Duration
+	ready        int32
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+func (c *RedisClient) client() RedisClientInterface {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+func (c *RedisClient) Ping(ctx context.Context) *redis.StatusCmd {
+	return c.client().Ping(ctx)
+}
+
+func (c *RedisClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	return c.client().Get(ctx, key)
+}
+
+func (c *RedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return c.client().Set(ctx, key, value, expiration)
+}
+
+func (c *RedisClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return c.client().Del(ctx, keys...)
+}
+
+func (c *RedisClient) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
+	return c.client().HGetAll(ctx, key)
+}
+
+func (c *RedisClient) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	return c.client().SMembers(ctx, key)
+}
+
+func (c *RedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	return c.client().Scan(ctx, cursor, match, count)
+}
+
+func (c *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return c.client().Eval(ctx, script, keys, args...)
+}
+
+func (c *RedisClient) PoolStats() *redis.PoolStats {
+	return c.client().PoolStats()
+}
+
+func (c *RedisClient) AddHook(hook redis.Hook) {
+	c.client().AddHook(hook)
+}
+
+// IsReady reports whether the last ping to Redis succeeded.
+func (c *RedisClient) IsReady() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+// SetPassword reconnects to Redis with the password, e.g. after the mounted secret rotated.
+// Commands already sent finish on the previous connections, which are closed after
+// redisReconnectGracePeriod.
+func (c *RedisClient) SetPassword(password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config := c.config
+	config.Password = password
+	next, _, err := config.newClient()
+	if err != nil {
+		return err
+	}
+	if c.hook != nil {
+		next.AddHook(c.hook)
+	}
+	previous := c.current
+	c.current = next
+	c.config = config
+	time.AfterFunc(redisReconnectGracePeriod, func() { previous.Close() })
+	return nil
+}
+
+// Close stops the ping loop and closes the connection pool.
+func (c *RedisClient) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return c.client().Close()
+}
+
+// monitor pings Redis every ping interval while it is reachable and retries with exponential
+// backoff, capped at the ping interval, while it is not.
+func (c *RedisClient) monitor() {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = c.pingInterval / 20
+	b.MaxInterval = c.pingInterval
+	b.MaxElapsedTime = 0
+	first := true
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.pingInterval)
+		err := c.Ping(ctx).Err()
+		cancel()
+		c.setReady(err, first)
+		first = false
+
+		wait := c.pingInterval
+		if err != nil {
+			wait = b.NextBackOff()
+		} else {
+			b.Reset()
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *RedisClient) setReady(err error, first bool) {
+	var ready int32
+	if err == nil {
+		ready = 1
+	}
+	if atomic.SwapInt32(&c.ready, ready) == ready && !first {
+		return
+	}
+	if err == nil {
+		logger.Infof("Redis at %s is reachable", c.address)
+	} else {
+		logger.Warnf("Redis at %s is not reachable, continuing without it: %v", c.address, err)
+	}
+}
+
+// CreateRedisClient returns a client for the configured Redis server, exporting its pool
+// statistics and command errors to the registerer. It only fails on an invalid configuration and
+// does not wait for the server to be reachable.
+func CreateRedisClient(config RedisConfig, pingInterval time.Duration, registerer prometheus.Registerer) (*RedisClient, error) {
+	if config.PoolSize < 0 || config.MinIdleConns < 0 {
+		return nil, fmt.Errorf("Invalid Redis pool size %d or minimum idle connections %d", config.PoolSize, config.MinIdleConns)
+	}
+	redisClient, address, err := config.newClient()
+	if err != nil {
+		return nil, err
+	}
+	c := unmonitoredRedisClient(redisClient, address, pingInterval)
+	c.config = config
+	// The pool statistics follow the client across reconnects. The hook is added before the
+	// monitor starts pinging, as adding hooks is not safe while the client is in use.
+	c.hook = registerRedisMetrics(c, registerer)
+	redisClient.AddHook(c.hook)
+	go c.monitor()
+	return c, nil
+}
+
+func newRedisClient(redisClient RedisClientInterface, address string, pingInterval time.Duration) *RedisClient {
+	c := unmonitoredRedisClient(redisClient, address, pingInterval)
+	go c.monitor()
+	return c
+}
+
+func unmonitoredRedisClient(redisClient RedisClientInterface, address string, pingInterval time.Duration) *RedisClient {
+	return &RedisClient{
+		current:      redisClient,
+		address:      address,
+		pingInterval: pingInterval,
+		stop:         make(chan struct{}),
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	RedisErrorTypeTimeout string = "timeout"
+	RedisErrorTypeNetwork string = "network"
+	RedisErrorTypeServer  string = "server"
+	RedisErrorTypeOther   string = "other"
+)
+
+var (
+	redisPoolHitsDesc = prometheus.NewDesc("cache_redis_pool_hits",
+		"Number of times a free connection was found in the Redis connection pool.", nil, nil)
+	redisPoolMissesDesc = prometheus.NewDesc("cache_redis_pool_misses",
+		"Number of times no free connection was found in the Redis connection pool.", nil, nil)
+	redisPoolTimeoutsDesc = prometheus.NewDesc("cache_redis_pool_timeouts",
+		"Number of times waiting for a pooled Redis connection timed out.", nil, nil)
+	redisPoolTotalConnsDesc = prometheus.NewDesc("cache_redis_pool_total_connections",
+		"Number of connections in the Redis connection pool.", nil, nil)
+	redisPoolIdleConnsDesc = prometheus.NewDesc("cache_redis_pool_idle_connections",
+		"Number of idle connections in the Redis connection pool.", nil, nil)
+	redisPoolStaleConnsDesc = prometheus.NewDesc("cache_redis_pool_stale_connections",
+		"Number of stale connections removed from the Redis connection pool.", nil, nil)
+)
+
+// redisPoolCollector reads the pool statistics of the client on every scrape.
+type redisPoolCollector struct {
+	client RedisClientInterface
+}
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolHitsDesc
+	ch <- redisPoolMissesDesc
+	ch <- redisPoolTimeoutsDesc
+	ch <- redisPoolTotalConnsDesc
+	ch <- redisPoolIdleConnsDesc
+	ch <- redisPoolStaleConnsDesc
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisPoolHitsDesc, prometheus.GaugeValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(redisPoolMissesDesc, prometheus.GaugeValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(redisPoolTimeoutsDesc, prometheus.GaugeValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisPoolTotalConnsDesc, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolIdleConnsDesc, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolStaleConnsDesc, prometheus.GaugeValue, float64(stats.StaleConns))
+}
+
+// redisErrorHook counts failed commands by error type. A missing key (redis.Nil) is not a failure.
+type redisErrorHook struct {
+	commandErrors *prometheus.CounterVec
+}
+
+func (h *redisErrorHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *redisErrorHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.observe(cmd.Err())
+	return nil
+}
+
+func (h *redisErrorHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *redisErrorHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.observe(cmd.Err())
+	}
+	return nil
+}
+
+func (h *redisErrorHook) observe(err error) {
+	if err == nil || err == redis.Nil {
+		return
+	}
+	h.commandErrors.WithLabelValues(redisErrorType(err)).Inc()
+}
+
+func redisErrorType(err error) string {
+	if IsRedisTimeout(err) {
+		return RedisErrorTypeTimeout
+	}
+	if _, ok := err.(redis.Error); ok {
+		return RedisErrorTypeServer
+	}
+	if _, ok := err.(net.Error); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return RedisErrorTypeNetwork
+	}
+	return RedisErrorTypeOther
+}
+
+// registerRedisMetrics exports the pool statistics of the client and returns the hook counting
+// command errors.
+func registerRedisMetrics(client RedisClientInterface, registerer prometheus.Registerer) *redisErrorHook {
+	if err := registerer.Register(&redisPoolCollector{client: client}); err != nil {
+		logger.Errorf("Failed to register Redis pool metrics: %v", err)
+	}
+	commandErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_redis_command_errors_total",
+		Help: "Failed Redis commands by error type: timeout, network, server or other.",
+	}, []string{"type"})
+	registered, err := metrics.RegisterOrReuse(registerer, commandErrors)
+	if err != nil {
+		logger.Errorf("Failed to register Redis command metrics: %v", err)
+	}
+	commandErrors = registered.(*prometheus.CounterVec)
+	return &redisErrorHook{commandErrors: commandErrors}
+}
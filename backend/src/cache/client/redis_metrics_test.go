@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gatherGauges(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	gauges := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge() != nil {
+				gauges[family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	return gauges
+}
+
+func getRedisCommandErrors(t *testing.T, registry *prometheus.Registry, errorType string) float64 {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != "cache_redis_command_errors_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == errorType {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestRedisPoolMetricsUpdateUnderParallelLookups(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.Nil(t, err)
+	registry := prometheus.NewRegistry()
+	c, err := CreateRedisClient(RedisConfig{Host: host, Port: port, PoolSize: 5}, time.Hour, registry)
+	require.Nil(t, err)
+	defer c.Close()
+
+	gauges := gatherGauges(t, registry)
+	for _, name := range []string{"cache_redis_pool_hits", "cache_redis_pool_misses", "cache_redis_pool_timeouts",
+		"cache_redis_pool_total_connections", "cache_redis_pool_idle_connections", "cache_redis_pool_stale_connections"} {
+		assert.Contains(t, gauges, name)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Get(context.Background(), fmt.Sprintf("key%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	gauges = gatherGauges(t, registry)
+	assert.True(t, gauges["cache_redis_pool_hits"]+gauges["cache_redis_pool_misses"] >= 50, "gauges: %v", gauges)
+	assert.True(t, gauges["cache_redis_pool_total_connections"] >= 1, "gauges: %v", gauges)
+	assert.True(t, gauges["cache_redis_pool_total_connections"] <= 5, "gauges: %v", gauges)
+	// Missing keys are not errors.
+	for _, errorType := range []string{RedisErrorTypeTimeout, RedisErrorTypeNetwork, RedisErrorTypeServer, RedisErrorTypeOther} {
+		assert.Equal(t, float64(0), getRedisCommandErrors(t, registry, errorType))
+	}
+}
+
+func TestRedisCommandErrorsAreCountedByType(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.Nil(t, err)
+	registry := prometheus.NewRegistry()
+	c, err := CreateRedisClient(RedisConfig{Host: host, Port: port}, time.Hour, registry)
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.NotNil(t, c.Eval(context.Background(), "not lua", nil).Err())
+	server.Close()
+	assert.NotNil(t, c.Get(context.Background(), "testKey").Err())
+
+	assert.Equal(t, float64(1), getRedisCommandErrors(t, registry, RedisErrorTypeServer))
+	assert.Equal(t, float64(1), getRedisCommandErrors(t, registry, RedisErrorTypeNetwork))
+}
+
+func TestRedisErrorType(t *testing.T) {
+	assert.Equal(t, RedisErrorTypeTimeout, redisErrorType(context.DeadlineExceeded))
+	assert.Equal(t, RedisErrorTypeNetwork, redisErrorType(io.EOF))
+	assert.Equal(t, RedisErrorTypeNetwork, redisErrorType(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.Equal(t, RedisErrorTypeOther, redisErrorType(errors.New("redis: client is closed")))
+}
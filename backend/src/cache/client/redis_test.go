@@ -0,0 +1,319 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedisClient only answers pings, according to a switch.
+type fakeRedisClient struct {
+	RedisClientInterface
+	reachable int32
+}
+
+func (f *fakeRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
+	if atomic.LoadInt32(&f.reachable) == 1 {
+		return redis.NewStatusResult("PONG", nil)
+	}
+	return redis.NewStatusResult("", errors.New("connection refused"))
+}
+
+func (f *fakeRedisClient) Close() error {
+	return nil
+}
+
+func waitForReady(c *RedisClient, ready bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.IsReady() == ready {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestRedisClientTracksReachability(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.Nil(t, err)
+
+	c, err := CreateRedisClient(RedisConfig{Host: host, Port: port}, 100*time.Millisecond, prometheus.NewRegistry())
+	require.Nil(t, err)
+	defer c.Close()
+	assert.True(t, waitForReady(c, true))
+
+	server.Close()
+	assert.True(t, waitForReady(c, false))
+
+	require.Nil(t, server.Restart())
+	assert.True(t, waitForReady(c, true))
+}
+
+func TestRedisClientDoesNotRequireRedisAtStartup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	host, port, err := net.SplitHostPort(address)
+	require.Nil(t, err)
+
+	c, err := CreateRedisClient(RedisConfig{Host: host, Port: port}, 100*time.Millisecond, prometheus.NewRegistry())
+	require.Nil(t, err)
+	defer c.Close()
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, c.IsReady())
+	assert.NotNil(t, c.Get(context.Background(), "testKey").Err())
+}
+
+func writeTempFile(t *testing.T, dir string, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(path, content, 0600))
+	return path
+}
+
+// createSelfSignedCertificate returns a server certificate for 127.0.0.1 and its PEM encoding.
+func createSelfSignedCertificate(t *testing.T) (tls.Certificate, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.Nil(t, err)
+	return certificate, certPEM
+}
+
+func TestRedisConfigOptions(t *testing.T) {
+	options, err := RedisConfig{Host: "redis", Port: "6379", DB: 2}.Options()
+	require.Nil(t, err)
+	assert.Equal(t, "redis:6379", options.Addr)
+	assert.Equal(t, 2, options.DB)
+	assert.Empty(t, options.Password)
+	assert.Nil(t, options.TLSConfig)
+
+	options, err = RedisConfig{Host: "redis", Port: "6379", Password: "secret"}.Options()
+	require.Nil(t, err)
+	assert.Equal(t, "secret", options.Password)
+
+	options, err = RedisConfig{Host: "redis", Port: "6379", TLSEnabled: true}.Options()
+	require.Nil(t, err)
+	require.NotNil(t, options.TLSConfig)
+	assert.Equal(t, "redis", options.TLSConfig.ServerName)
+	assert.False(t, options.TLSConfig.InsecureSkipVerify)
+	assert.Nil(t, options.TLSConfig.RootCAs)
+}
+
+func TestRedisConfigOptionsWithInvalidConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	invalidCA := writeTempFile(t, dir, "ca.pem", []byte("not a certificate"))
+
+	_, err = RedisConfig{Host: "redis", Port: "6379", DB: -1}.Options()
+	assert.Contains(t, err.Error(), "Invalid Redis DB index")
+	_, err = RedisConfig{Host: "redis", Port: "6379", TLSEnabled: true, TLSCACertPath: filepath.Join(dir, "missing")}.Options()
+	assert.Contains(t, err.Error(), "Failed to read Redis CA certificate")
+	_, err = RedisConfig{Host: "redis", Port: "6379", TLSEnabled: true, TLSCACertPath: invalidCA}.Options()
+	assert.Contains(t, err.Error(), "No valid PEM certificate found")
+
+	// The CA is not needed when verification is disabled.
+	options, err := RedisConfig{Host: "redis", Port: "6379", TLSEnabled: true, TLSCACertPath: filepath.Join(dir, "missing"), TLSInsecureSkipVerify: true}.Options()
+	require.Nil(t, err)
+	assert.True(t, options.TLSConfig.InsecureSkipVerify)
+}
+
+func TestRedisClientWithTLSAndPassword(t *testing.T) {
+	certificate, certPEM := createSelfSignedCertificate(t)
+	server, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{certificate}})
+	require.Nil(t, err)
+	defer server.Close()
+	server.RequireAuth("secret")
+	dir, err := ioutil.TempDir("", "redis-tls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.Nil(t, err)
+
+	c, err := CreateRedisClient(RedisConfig{
+		Host:          host,
+		Port:          port,
+		Password:      "secret",
+		DB:            1,
+		TLSEnabled:    true,
+		TLSCACertPath: writeTempFile(t, dir, "ca.pem", certPEM),
+	}, 100*time.Millisecond, prometheus.NewRegistry())
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Set(context.Background(), "testKey", "testValue", 0).Err())
+	value, err := server.DB(1).Get("testKey")
+	require.Nil(t, err)
+	assert.Equal(t, "testValue", value)
+
+	untrusted, err := CreateRedisClient(RedisConfig{Host: host, Port: port, Password: "secret", TLSEnabled: true}, 100*time.Millisecond, prometheus.NewRegistry())
+	require.Nil(t, err)
+	defer untrusted.Close()
+	assert.NotNil(t, untrusted.Ping(context.Background()).Err())
+}
+
+func TestRedisClientSetPasswordReconnects(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+	defer server.Close()
+	server.RequireAuth("first")
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.Nil(t, err)
+	c, err := CreateRedisClient(RedisConfig{Host: host, Port: port, Password: "first"}, 100*time.Millisecond, prometheus.NewRegistry())
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Set(context.Background(), "testKey", "testValue", 0).Err())
+
+	// Restarting drops the connections authenticated with the previous password.
+	server.Close()
+	server.RequireAuth("second")
+	require.Nil(t, server.Restart())
+	assert.NotNil(t, c.Get(context.Background(), "testKey").Err())
+	require.Nil(t, c.SetPassword("second"))
+
+	value, err := c.Get(context.Background(), "testKey").Result()
+	require.Nil(t, err)
+	assert.Equal(t, "testValue", value)
+	assert.True(t, waitForReady(c, true))
+}
+
+func TestRedisConfigSentinelAndClusterOptions(t *testing.T) {
+	addresses := []string{"sentinel-0:26379", "sentinel-1:26379"}
+
+	failoverOptions, err := RedisConfig{Mode: RedisModeSentinel, MasterName: "mymaster", Addresses: addresses, Password: "secret", DB: 3}.FailoverOptions()
+	require.Nil(t, err)
+	assert.Equal(t, "mymaster", failoverOptions.MasterName)
+	assert.Equal(t, addresses, failoverOptions.SentinelAddrs)
+	assert.Equal(t, "secret", failoverOptions.Password)
+	assert.Equal(t, 3, failoverOptions.DB)
+
+	clusterOptions, err := RedisConfig{Mode: RedisModeCluster, Host: "redis", Addresses: addresses, Password: "secret", TLSEnabled: true}.ClusterOptions()
+	require.Nil(t, err)
+	assert.Equal(t, addresses, clusterOptions.Addrs)
+	assert.Equal(t, "secret", clusterOptions.Password)
+	assert.Equal(t, "redis", clusterOptions.TLSConfig.ServerName)
+
+	_, err = RedisConfig{Mode: RedisModeSentinel, Addresses: addresses}.FailoverOptions()
+	assert.Contains(t, err.Error(), "requires a master name")
+	_, err = RedisConfig{Mode: RedisModeCluster}.ClusterOptions()
+	assert.Contains(t, err.Error(), "requires at least one seed address")
+	_, err = RedisConfig{Mode: RedisModeCluster, Addresses: addresses, DB: 1}.ClusterOptions()
+	assert.Contains(t, err.Error(), "only supports DB index 0")
+}
+
+func TestCreateRedisClientSelectsClientByMode(t *testing.T) {
+	addresses := []string{"127.0.0.1:1"}
+	tests := []struct {
+		config  RedisConfig
+		address string
+	}{
+		{RedisConfig{Host: "127.0.0.1", Port: "1"}, "127.0.0.1:1"},
+		{RedisConfig{Mode: RedisModeStandalone, Host: "127.0.0.1", Port: "1"}, "127.0.0.1:1"},
+		{RedisConfig{Mode: RedisModeSentinel, MasterName: "mymaster", Addresses: addresses}, "master mymaster via sentinels 127.0.0.1:1"},
+		{RedisConfig{Mode: RedisModeCluster, Addresses: addresses}, "cluster 127.0.0.1:1"},
+	}
+	for _, tc := range tests {
+		c, err := CreateRedisClient(tc.config, time.Hour, prometheus.NewRegistry())
+		require.Nil(t, err)
+		assert.Equal(t, tc.address, c.address)
+		c.Close()
+	}
+
+	_, err := CreateRedisClient(RedisConfig{Mode: "replicated"}, time.Hour, prometheus.NewRegistry())
+	assert.Contains(t, err.Error(), `Redis mode "replicated" is not supported`)
+}
+
+func TestRedisClientTracksReachabilityIndependentlyOfMode(t *testing.T) {
+	fake := &fakeRedisClient{}
+	c := newRedisClient(fake, "fake", 100*time.Millisecond)
+	defer c.Close()
+	assert.True(t, waitForReady(c, false))
+
+	atomic.StoreInt32(&fake.reachable, 1)
+	assert.True(t, waitForReady(c, true))
+	atomic.StoreInt32(&fake.reachable, 0)
+	assert.True(t, waitForReady(c, false))
+}
+
+func TestIsRedisTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept without ever answering.
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+	options, err := RedisConfig{Host: host, Port: port, ReadTimeout: 100 * time.Millisecond}.Options()
+	require.Nil(t, err)
+	assert.Equal(t, 100*time.Millisecond, options.ReadTimeout)
+	c := NewRedisClientInterface(redis.NewClient(options))
+	defer c.Close()
+
+	assert.True(t, IsRedisTimeout(c.Get(context.Background(), "testKey").Err()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, IsRedisTimeout(c.Get(ctx, "testKey").Err()))
+	assert.False(t, IsRedisTimeout(nil))
+	assert.False(t, IsRedisTimeout(redis.Nil))
+	assert.False(t, IsRedisTimeout(errors.New("ERR unknown command")))
+}
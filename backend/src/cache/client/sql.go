@@ -15,11 +15,48 @@
 package client
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 )
 
+// defaultMaxIdleConns is the database/sql default of idle connections kept in a pool.
+const defaultMaxIdleConns = 2
+
+// Defaults of SQLPoolConfig. Every replica of the cache server opens up to DefaultSQLMaxOpenConns
+// connections, so that the replicas of a scaled out webhook stay within the max_connections of
+// MySQL, 151 by default.
+const (
+	DefaultSQLMaxOpenConns    int           = 20
+	DefaultSQLMaxIdleConns    int           = 10
+	DefaultSQLConnMaxLifetime time.Duration = 30 * time.Minute
+	DefaultSQLConnMaxIdleTime time.Duration = 5 * time.Minute
+)
+
+// SQLPoolConfig bounds the connections of a database pool. MaxOpenConns caps the connections in
+// use and idle, queries waiting for a connection beyond it, and MaxIdleConns those kept idle.
+// Connections are closed once open for ConnMaxLifetime or idle for ConnMaxIdleTime, e.g. to spread
+// again over the instances behind a proxy. Zero values do not bound them.
+type SQLPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// ConfigurePool applies the bounds of the pool to db.
+func ConfigurePool(db *sql.DB, pool SQLPoolConfig) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+}
+
 func CreateMySQLConfig(user, password string, mysqlServiceHost string,
 	mysqlServicePort string, dbName string, mysqlGroupConcatMaxLen string, mysqlExtraParams map[string]string) *mysql.Config {
 
@@ -44,3 +81,45 @@ func CreateMySQLConfig(user, password string, mysqlServiceHost string,
 		AllowNativePasswords: true,
 	}
 }
+
+// MySQLConnector opens connections with the current configuration, so that a pool picks up a
+// rotated password without being reopened.
+type MySQLConnector struct {
+	driver driver.Driver
+
+	mu     sync.Mutex
+	config *mysql.Config
+}
+
+// factory function for a MySQLConnector, meant for sql.OpenDB
+func NewMySQLConnector(config *mysql.Config) *MySQLConnector {
+	copied := *config
+	return &MySQLConnector{driver: mysql.MySQLDriver{}, config: &copied}
+}
+
+// Connect opens a connection with the current password.
+func (c *MySQLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	dsn := c.config.FormatDSN()
+	c.mu.Unlock()
+	return c.driver.Open(dsn)
+}
+
+func (c *MySQLConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// SetPassword makes the connections opened from now on use the password.
+func (c *MySQLConnector) SetPassword(password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.Passwd = password
+}
+
+// CloseIdleConnections closes the idle connections of the pool, so that it reconnects, e.g. with a
+// rotated password, and keeps up to maxIdleConns idle connections from then on. Connections in use
+// keep their session and return to the pool.
+func CloseIdleConnections(db *sql.DB, maxIdleConns int) {
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(maxIdleConns)
+}
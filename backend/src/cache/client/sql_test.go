@@ -15,10 +15,18 @@
 package client
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateMySQLConfig(t *testing.T) {
@@ -79,3 +87,72 @@ func TestCreateMySQLConfig(t *testing.T) {
 		})
 	}
 }
+
+// fakeDriver records the DSNs connections are opened with.
+type fakeDriver struct {
+	mu   sync.Mutex
+	dsns []string
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dsns = append(d.dsns, dsn)
+	return fakeConn{}, nil
+}
+
+func (d *fakeDriver) opened() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.dsns...)
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func TestMySQLConnectorReconnectsWithRotatedPassword(t *testing.T) {
+	fake := &fakeDriver{}
+	connector := NewMySQLConnector(CreateMySQLConfig("root", "first", "mysql", "3306", "cachedb", "1024", nil))
+	connector.driver = fake
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	require.Nil(t, db.Ping())
+	require.Len(t, fake.opened(), 1)
+	assert.Contains(t, fake.opened()[0], "root:first@")
+
+	connector.SetPassword("second")
+	require.Nil(t, db.Ping())
+	assert.Len(t, fake.opened(), 1, "idle connections are reused until closed")
+
+	CloseIdleConnections(db, defaultMaxIdleConns)
+	require.Nil(t, db.Ping())
+	require.Len(t, fake.opened(), 2)
+	assert.Contains(t, fake.opened()[1], "root:second@")
+}
+
+func TestConfigurePoolBoundsOpenConnections(t *testing.T) {
+	fake := &fakeDriver{}
+	connector := NewMySQLConnector(CreateMySQLConfig("root", "password", "mysql", "3306", "cachedb", "1024", nil))
+	connector.driver = fake
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	ConfigurePool(db, SQLPoolConfig{MaxOpenConns: 2, MaxIdleConns: 1, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: time.Minute})
+
+	first, err := db.Conn(context.Background())
+	require.Nil(t, err)
+	second, err := db.Conn(context.Background())
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = db.Conn(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "connections beyond the pool wait for a free one")
+	assert.Equal(t, 2, db.Stats().MaxOpenConnections)
+
+	require.Nil(t, first.Close())
+	require.Nil(t, second.Close())
+	assert.Equal(t, 1, db.Stats().Idle, "connections beyond the idle bound are closed")
+	assert.Len(t, fake.opened(), 2)
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
+	"k8s.io/client-go/rest"
+)
+
+// CreateMutatingWebhookConfigurationClient creates an in-cluster client of the
+// MutatingWebhookConfigurations. Using it requires RBAC permissions to get and patch them.
+func CreateMutatingWebhookConfigurationClient() (admissionregistrationv1.MutatingWebhookConfigurationInterface, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialize kubernetes client.")
+	}
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialize kubernetes client set.")
+	}
+	return clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations(), nil
+}
+
+// PatchMutatingWebhookCABundle sets the caBundle of every webhook of the named
+// MutatingWebhookConfiguration to the PEM encoded CA certificates.
+func PatchMutatingWebhookCABundle(client admissionregistrationv1.MutatingWebhookConfigurationInterface, name string, caBundle []byte) error {
+	config, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get MutatingWebhookConfiguration %s.", name)
+	}
+	type patchOperation struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value []byte `json:"value"`
+	}
+	var patches []patchOperation
+	for i := range config.Webhooks {
+		// Adding an object member replaces it when present, unlike replace which requires it.
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
+			Value: caBundle,
+		})
+	}
+	if len(patches) == 0 {
+		return errors.Errorf("MutatingWebhookConfiguration %s has no webhooks.", name)
+	}
+	patch, err := json.Marshal(patches)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the caBundle patch.")
+	}
+	if _, err := client.Patch(name, types.JSONPatchType, patch); err != nil {
+		return errors.Wrapf(err, "Failed to patch the caBundle of MutatingWebhookConfiguration %s.", name)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPatchMutatingWebhookCABundle(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-webhook-kubeflow"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "cache-server.kubeflow.svc"},
+			{Name: "cache-server-legacy.kubeflow.svc", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("old")}},
+		},
+	}, &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "empty"},
+	})
+	client := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	caBundle := []byte("-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n")
+	require.Nil(t, PatchMutatingWebhookCABundle(client, "cache-webhook-kubeflow", caBundle))
+	config, err := client.Get("cache-webhook-kubeflow", metav1.GetOptions{})
+	require.Nil(t, err)
+	for _, webhook := range config.Webhooks {
+		assert.Equal(t, caBundle, webhook.ClientConfig.CABundle, webhook.Name)
+	}
+
+	assert.NotNil(t, PatchMutatingWebhookCABundle(client, "empty", caBundle))
+	assert.NotNil(t, PatchMutatingWebhookCABundle(client, "missing", caBundle))
+}
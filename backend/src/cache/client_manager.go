@@ -15,74 +15,519 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/go-sql-driver/mysql"
 	"github.com/golang/glog"
 	"github.com/jinzhu/gorm"
 	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
 	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
 	"github.com/kubeflow/pipelines/backend/src/cache/storage"
 	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
-	DefaultConnectionTimeout = "6m"
+	DefaultConnectionTimeout      = "6m"
+	DefaultSlowStoreCallThreshold = "500ms"
+
+	partitionMigrationBatchSize = 500
+	// gcInterval is the time between the purges of the expired entries of the database stores,
+	// and of the partitions outside of the retention window.
+	gcInterval = time.Hour
 )
 
+// ClientManager holds the clients and stores shared by the webhook and the watchers. Each of them
+// is initialized on first use, once, so that commands only connect to what they use, and all are
+// safe for concurrent use.
 type ClientManager struct {
-	db            *storage.DB
-	cacheStore    storage.ExecutionCacheStoreInterface
+	cfg  *config.Config
+	time util.TimeInterface
+	// mu guards credentials and closed, and is held while connecting with the credentials so that
+	// rotations wait for the connections they apply to.
+	mu          sync.Mutex
+	credentials config.Credentials
+	closed      bool
+	adminToken  *server.AdminToken
+	// signatureKeys sign the cache fields of the pods admitted.
+	signatureKeys *server.CacheSignatureKeys
+
+	dbOnce sync.Once
+	// db is nil when the cache store is not backed by a relational database. mysqlConnector takes
+	// over rotated passwords.
+	db             *storage.DB
+	mysqlConnector *client.MySQLConnector
+
+	redisOnce sync.Once
+	// redisClient is nil when Redis is not configured.
+	redisClient *client.RedisClient
+
+	storesOnce sync.Once
+	cacheStore storage.ExecutionCacheStoreInterface
+	// writeThroughStore is set when Redis caches the database store.
+	writeThroughStore *storage.WriteThroughExecutionCacheStore
+	// adminStore is nil when the cache store does not support the admin API.
+	adminStore storage.ExecutionCacheAdminStore
+	// statsStore is nil when the cache store cannot be summarized.
+	statsStore storage.ExecutionCacheStatsStore
+	// quotaStore is nil when the cache store cannot enforce namespace quotas.
+	quotaStore storage.ExecutionCacheQuotaStore
+	// reuseStore is nil when the cache store is not backed by a relational database.
+	reuseStore *storage.CacheReuseStore
+	// minioKeys takes over rotated keys, nil when the object store is not in use.
+	minioKeys *client.MinioKeys
+
+	artifactOnce  sync.Once
+	artifactStore *storage.S3ArtifactStore
+
+	scrubCursorOnce sync.Once
+	// scrubCursorStore is nil when the cache store is not backed by a relational database.
+	scrubCursorStore *storage.ScrubCursorStore
+
+	k8sCoreOnce   sync.Once
 	k8sCoreClient client.KubernetesCoreInterface
-	time          util.TimeInterface
+
+	argoOnce   sync.Once
+	argoClient client.ArgoClientInterface
+	// argoPersistence and argoPersistenceConnector are nil when the offloaded node statuses of
+	// Argo are not resolved.
+	argoPersistence          *client.ArgoOffloadedNodes
+	argoPersistenceConnector *client.MySQLConnector
+}
+
+// factory function for a client manager of the configuration, connecting to nothing until used
+func NewClientManager(cfg *config.Config) *ClientManager {
+	credentials := cfg.Credentials()
+	return &ClientManager{
+		cfg:           cfg,
+		time:          util.NewRealTime(),
+		credentials:   credentials,
+		adminToken:    server.NewAdminToken(credentials.AdminToken),
+		signatureKeys: server.NewCacheSignatureKeys(credentials.SignatureKey),
+	}
+}
+
+// DB returns the database of the cache store, nil when the cache store is not backed by one.
+func (c *ClientManager) DB() *storage.DB {
+	c.dbOnce.Do(func() {
+		if c.cfg.Cache.Store == config.StoreMemory {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.db = initMemoryDB()
+			return
+		}
+		if c.cfg.Cache.Store != config.StoreMySQL {
+			return
+		}
+		timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		dbConfig := c.cfg.DB
+		dbConfig.Password = c.credentials.DBPassword
+		c.db, c.mysqlConnector = initDBClient(dbConfig, timeoutDuration)
+	})
+	return c.db
+}
+
+// RedisClient returns the Redis client, nil when Redis is not configured.
+func (c *ClientManager) RedisClient() *client.RedisClient {
+	c.redisOnce.Do(func() {
+		if !c.cfg.Redis.Enabled() {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		redisConfig := c.cfg.Redis
+		redisConfig.Password = c.credentials.RedisPassword
+		c.redisClient = initRedisClient(redisConfig)
+	})
+	return c.redisClient
 }
 
 func (c *ClientManager) CacheStore() storage.ExecutionCacheStoreInterface {
+	c.initStores()
 	return c.cacheStore
 }
 
+// AdminStore returns the store the admin API manages the entries of, nil when the cache store does
+// not support it.
+func (c *ClientManager) AdminStore() storage.ExecutionCacheAdminStore {
+	c.initStores()
+	return c.adminStore
+}
+
+// StatsStore returns the store summarized by the stats, nil when the cache store cannot be.
+func (c *ClientManager) StatsStore() storage.ExecutionCacheStatsStore {
+	c.initStores()
+	return c.statsStore
+}
+
+// QuotaStore returns the store the namespace quotas are enforced on, nil when the cache store
+// cannot enforce them.
+func (c *ClientManager) QuotaStore() storage.ExecutionCacheQuotaStore {
+	c.initStores()
+	return c.quotaStore
+}
+
+// ReuseStore returns the store of the nodes of runs served from cache, nil when the cache store
+// cannot record them.
+func (c *ClientManager) ReuseStore() *storage.CacheReuseStore {
+	c.initStores()
+	return c.reuseStore
+}
+
+// ArtifactStore returns the store the artifacts of the entries are checked in, by the artifact
+// scrubber and before reusing the entries of other clusters. Its bucket is the default one of the
+// artifacts without bucket.
+func (c *ClientManager) ArtifactStore() *storage.S3ArtifactStore {
+	c.artifactOnce.Do(func() {
+		timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+		c.mu.Lock()
+		if c.minioKeys == nil {
+			c.minioKeys = client.NewMinioKeys(c.credentials.S3AccessKey, c.credentials.S3SecretKey)
+		}
+		keys := c.minioKeys
+		c.mu.Unlock()
+		s3Config := c.cfg.S3
+		core := client.CreateMinioCoreOrFatal(s3Config.Host, s3Config.Port, keys, s3Config.Secure, s3Config.Region, s3Config.BucketName, timeoutDuration)
+		c.artifactStore = storage.NewS3ArtifactStore(&storage.MinioS3Client{Core: core}, s3Config.BucketName)
+	})
+	return c.artifactStore
+}
+
+// ScrubCursorStore returns the store of the cursor of the artifact scrubber, nil when the cache
+// store cannot be scrubbed.
+func (c *ClientManager) ScrubCursorStore() *storage.ScrubCursorStore {
+	c.scrubCursorOnce.Do(func() {
+		if c.AdminStore() == nil || c.DB() == nil {
+			return
+		}
+		cursors, err := storage.NewScrubCursorStore(c.DB())
+		if err != nil {
+			glog.Fatalf("Failed to create the scrub cursor store: %v", err)
+		}
+		c.scrubCursorStore = cursors
+	})
+	return c.scrubCursorStore
+}
+
 func (c *ClientManager) KubernetesCoreClient() client.KubernetesCoreInterface {
+	c.k8sCoreOnce.Do(func() {
+		timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+		c.k8sCoreClient = client.CreateKubernetesCoreOrFatal(timeoutDuration)
+	})
 	return c.k8sCoreClient
 }
 
+func (c *ClientManager) ArgoClient() client.ArgoClientInterface {
+	c.argoOnce.Do(func() {
+		timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+		if c.cfg.Watcher.ArgoPersistenceDBName != "" {
+			c.mu.Lock()
+			dbConfig := c.cfg.DB
+			dbConfig.Password = c.credentials.DBPassword
+			c.argoPersistence, c.argoPersistenceConnector = initArgoPersistence(dbConfig, c.cfg.Watcher)
+			c.mu.Unlock()
+		}
+		c.argoClient = client.CreateArgoClientOrFatal(timeoutDuration, c.argoPersistence)
+	})
+	return c.argoClient
+}
+
+// AdminToken returns the token of the admin API, kept up to date with the rotated credentials.
+func (c *ClientManager) AdminToken() *server.AdminToken {
+	return c.adminToken
+}
+
+// SignatureKeys returns the keys the cache fields of pods are signed with, kept up to date with the
+// rotated credentials.
+func (c *ClientManager) SignatureKeys() *server.CacheSignatureKeys {
+	return c.signatureKeys
+}
+
+// ReadinessChecks returns the checks of the dependencies in use. Redis is only critical when it is
+// the cache store.
+func (c *ClientManager) ReadinessChecks(dbTimeout time.Duration, redisTimeout time.Duration) []server.DependencyCheck {
+	var checks []server.DependencyCheck
+	c.initStores()
+	if db := c.DB(); db != nil {
+		checks = append(checks, server.DependencyCheck{
+			Name: "database",
+			Check: func(ctx context.Context) error {
+				var one int
+				return db.DB.DB().QueryRowContext(ctx, "SELECT 1").Scan(&one)
+			},
+			Timeout:  dbTimeout,
+			Critical: true,
+		})
+	}
+	if redisClient := c.RedisClient(); redisClient != nil {
+		check := server.DependencyCheck{
+			Name:     "redis",
+			Check:    func(ctx context.Context) error { return redisClient.Ping(ctx).Err() },
+			Timeout:  redisTimeout,
+			Critical: c.writeThroughStore == nil,
+		}
+		if c.writeThroughStore != nil {
+			check.Detail = func() string { return "circuit " + c.writeThroughStore.RedisCircuitState() }
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Close closes the clients initialized so far, once, and keeps the others from being initialized
+// afterwards, whose accessors then return nil.
 func (c *ClientManager) Close() {
-	c.db.Close()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+	// The handles still to initialize are marked done, and those being initialized are waited for.
+	for _, once := range []*sync.Once{&c.storesOnce, &c.dbOnce, &c.redisOnce, &c.k8sCoreOnce, &c.argoOnce} {
+		once.Do(func() {})
+	}
+	if c.db != nil {
+		c.db.Close()
+	}
+	if c.redisClient != nil {
+		c.redisClient.Close()
+	}
+	if c.argoPersistence != nil {
+		c.argoPersistence.Close()
+	}
+}
+
+// initStores initializes the cache store and the stores of the admin API, stats, quotas and
+// reuses it supports, once.
+func (c *ClientManager) initStores() {
+	c.storesOnce.Do(func() {
+		slowStoreCallThreshold, _ := time.ParseDuration(DefaultSlowStoreCallThreshold)
+		timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+		cacheConfig := c.cfg.Cache
+		redisClient := c.RedisClient()
+		switch cacheConfig.Store {
+		case config.StoreMySQL, config.StoreMemory:
+			db := c.DB()
+			dbStore := initDBStore(cacheConfig, db, c.time)
+			c.adminStore, _ = dbStore.(storage.ExecutionCacheAdminStore)
+			c.statsStore, _ = dbStore.(storage.ExecutionCacheStatsStore)
+			c.quotaStore, _ = dbStore.(storage.ExecutionCacheQuotaStore)
+			reuseStore, err := storage.NewCacheReuseStore(db)
+			if err != nil {
+				glog.Fatalf("Failed to create the cache reuse store: %v", err)
+			}
+			c.reuseStore = reuseStore
+			c.cacheStore = storage.NewInstrumentedExecutionCacheStore(dbStore, "db", prometheus.DefaultRegisterer, slowStoreCallThreshold)
+			if redisClient != nil {
+				logger.Infof("Using Redis as write-through cache in front of the database with key prefix %q", c.cfg.Redis.KeyPrefix)
+				c.writeThroughStore = storage.NewWriteThroughExecutionCacheStore(c.cacheStore,
+					storage.NewRedisExecutionCacheStore(redisClient, c.cfg.Redis.KeyPrefix, storage.EntryScope{}, c.cfg.Redis.OperationTimeout, c.time),
+					c.cfg.Redis.CircuitFailureThreshold, c.cfg.Redis.CircuitCoolDown, prometheus.DefaultRegisterer)
+				if c.adminStore != nil {
+					c.adminStore = c.writeThroughStore.AdminStore(c.adminStore)
+				}
+				if c.quotaStore != nil {
+					c.quotaStore = c.writeThroughStore.QuotaStore(c.quotaStore)
+				}
+				c.cacheStore = storage.NewInstrumentedExecutionCacheStore(
+					c.writeThroughStore, "write_through", prometheus.DefaultRegisterer, slowStoreCallThreshold)
+			}
+		case config.StoreS3:
+			c.mu.Lock()
+			c.minioKeys = client.NewMinioKeys(c.credentials.S3AccessKey, c.credentials.S3SecretKey)
+			c.mu.Unlock()
+			c.cacheStore = storage.NewInstrumentedExecutionCacheStore(
+				initS3Store(c.cfg.S3, c.minioKeys, entryScope(cacheConfig), c.time, timeoutDuration), "s3", prometheus.DefaultRegisterer, slowStoreCallThreshold)
+		case config.StoreRedis:
+			logger.Infof("Using Redis cache store with key prefix %q", c.cfg.Redis.KeyPrefix)
+			c.cacheStore = storage.NewInstrumentedExecutionCacheStore(
+				storage.NewRedisExecutionCacheStore(redisClient, c.cfg.Redis.KeyPrefix, entryScope(cacheConfig), c.cfg.Redis.OperationTimeout, c.time), "redis", prometheus.DefaultRegisterer, slowStoreCallThreshold)
+		default:
+			glog.Fatalf("Cache store %v is not supported", cacheConfig.Store)
+		}
+	})
+}
+
+// RotateCredentials reconnects the clients whose credentials changed, e.g. after their mounted
+// Secret rotated. Clients not initialized yet will connect with the rotated credentials.
+func (c *ClientManager) RotateCredentials(credentials config.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mysqlConnector != nil && credentials.DBPassword != c.credentials.DBPassword {
+		c.mysqlConnector.SetPassword(credentials.DBPassword)
+		client.CloseIdleConnections(c.db.DB.DB(), c.cfg.DB.MaxIdleConns)
+		logger.Info("Reconnecting to the database with the rotated password")
+	}
+	if c.argoPersistenceConnector != nil && credentials.DBPassword != c.credentials.DBPassword {
+		c.argoPersistenceConnector.SetPassword(credentials.DBPassword)
+		c.argoPersistence.CloseIdleConnections()
+	}
+	if c.redisClient != nil && credentials.RedisPassword != c.credentials.RedisPassword {
+		if err := c.redisClient.SetPassword(credentials.RedisPassword); err != nil {
+			logger.Errorf("Failed to reconnect to Redis with the rotated password: %v", err)
+		} else {
+			logger.Info("Reconnected to Redis with the rotated password")
+		}
+	}
+	if c.minioKeys != nil && (credentials.S3AccessKey != c.credentials.S3AccessKey || credentials.S3SecretKey != c.credentials.S3SecretKey) {
+		c.minioKeys.SetKeys(credentials.S3AccessKey, credentials.S3SecretKey)
+		logger.Info("Signing object store requests with the rotated keys")
+	}
+	if credentials.AdminToken != c.credentials.AdminToken {
+		c.adminToken.Set(credentials.AdminToken)
+		logger.Info("Authenticating admin requests with the rotated token")
+	}
+	if credentials.SignatureKey != c.credentials.SignatureKey {
+		c.signatureKeys.Set(credentials.SignatureKey)
+		logger.Info("Signing the cache fields of pods with the rotated keys")
+	}
+	c.credentials = credentials
+}
+
+func initDBStore(cacheConfig config.CacheConfig, db *storage.DB, timeInterface util.TimeInterface) storage.ExecutionCacheStoreInterface {
+	switch cacheConfig.PartitionBy {
+	case storage.PartitionByNone:
+		store := storage.NewExecutionCacheStore(db, timeInterface)
+		go collectGarbage(store, nil, timeInterface, 0)
+		return store
+	case storage.PartitionByMonth:
+		store := storage.NewPartitionedExecutionCacheStore(db, timeInterface, cacheConfig.PartitionLookback)
+		if err := store.MigratePartitionColumns(); err != nil {
+			glog.Fatalf("Failed to migrate execution cache partitions. Error: %v", err)
+		}
+		migrated, err := store.MigrateLegacyExecutionCaches(partitionMigrationBatchSize)
+		if err != nil {
+			glog.Fatalf("Failed to migrate execution caches into partitions. Error: %v", err)
+		}
+		logger.Infof("Migrated %d execution caches into monthly partitions", migrated)
+		go collectGarbage(store, store, timeInterface, cacheConfig.PartitionRetention)
+		return store
+	default:
+		glog.Fatalf("Partitioning %v is not supported", cacheConfig.PartitionBy)
+	}
+	return nil
 }
 
-func (c *ClientManager) init(params WhSvrDBParameters) {
-	timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
-	db := initDBClient(params, timeoutDuration)
+// initMemoryDB returns the in-memory database of the memory cache store.
+func initMemoryDB() *storage.DB {
+	db, err := storage.NewMemoryDB()
+	if err != nil {
+		glog.Fatalf("Failed to create the in-memory cache store: %v", err)
+	}
+	logger.Warnf("Using the in-memory cache store: entries are lost on restart and not shared between replicas")
+	return db
+}
 
-	c.time = util.NewRealTime()
-	c.db = db
-	c.cacheStore = storage.NewExecutionCacheStore(db, c.time)
-	c.k8sCoreClient = client.CreateKubernetesCoreOrFatal(timeoutDuration)
+// collectGarbage periodically purges the entries of the store whose time-to-live ended and, when
+// the retention is a positive number of months, drops the partitions of the partitioned store
+// that fall entirely outside of it. Lookups miss the expired entries in the meantime.
+func collectGarbage(store storage.ExecutionCacheExpiryStore, partitioned *storage.PartitionedExecutionCacheStore, timeInterface util.TimeInterface, retentionInMonths int) {
+	for {
+		if partitioned != nil && retentionInMonths > 0 {
+			cutoff := timeInterface.Now().AddDate(0, -retentionInMonths, 0)
+			dropped, err := partitioned.DropPartitionsOlderThan(cutoff)
+			if err != nil {
+				logger.Errorf("Failed to drop expired execution cache partitions: %v", err)
+			} else if dropped > 0 {
+				logger.Infof("Dropped %d expired execution cache partitions", dropped)
+			}
+		}
+		purged, err := store.PurgeExpiredExecutionCaches(context.Background())
+		if err != nil {
+			logger.Errorf("Failed to purge expired execution caches after purging %d: %v", purged, err)
+		} else if purged > 0 {
+			logger.Infof("Purged %d expired execution caches", purged)
+		}
+		time.Sleep(gcInterval)
+	}
 }
 
-func initDBClient(params WhSvrDBParameters, initConnectionTimeout time.Duration) *storage.DB {
-	driverName := params.dbDriver
-	var arg string
+// initRedisClient only fails on an invalid configuration. Redis is optional for admissions, so an
+// unreachable server is merely logged by the client.
+func initRedisClient(redisConfig config.RedisConfig) *client.RedisClient {
+	redisClient, err := client.CreateRedisClient(client.RedisConfig{
+		Mode:       redisConfig.Mode,
+		Host:       redisConfig.Host,
+		Port:       redisConfig.Port,
+		MasterName: redisConfig.MasterName,
+		Addresses:  redisConfig.AddressList(),
+		// The password file has been read into the password.
+		Password:              redisConfig.Password,
+		DB:                    redisConfig.DB,
+		TLSEnabled:            redisConfig.TLSEnabled,
+		TLSCACertPath:         redisConfig.TLSCACertPath,
+		TLSInsecureSkipVerify: redisConfig.TLSInsecureSkipVerify,
+		DialTimeout:           redisConfig.DialTimeout,
+		ReadTimeout:           redisConfig.ReadTimeout,
+		WriteTimeout:          redisConfig.WriteTimeout,
+		PoolTimeout:           redisConfig.PoolTimeout,
+		PoolSize:              redisConfig.PoolSize,
+		MinIdleConns:          redisConfig.MinIdleConns,
+		MaxRetries:            redisConfig.MaxRetries,
+	}, client.DefaultRedisPingInterval, prometheus.DefaultRegisterer)
+	if err != nil {
+		glog.Fatalf("Invalid Redis configuration. Error: %v", err)
+	}
+	return redisClient
+}
+
+// entryScope names the entries of the S3 and Redis stores after what the lookups of the webhook
+// tell apart: their owner when owners are enforced, and their cluster unless every cluster reuses
+// the entries of the others. The Redis copies of the write-through store are not scoped, as the
+// lookups they do not serve fall back to the database, which keeps every entry.
+func entryScope(cacheConfig config.CacheConfig) storage.EntryScope {
+	return storage.EntryScope{
+		Owner:   cacheConfig.EnforceOwner,
+		Cluster: cacheConfig.CrossCluster != "" && cacheConfig.CrossCluster != server.CrossClusterShared,
+	}
+}
+
+func initS3Store(s3Config config.S3Config, keys *client.MinioKeys, scope storage.EntryScope, timeInterface util.TimeInterface, initConnectionTimeout time.Duration) *storage.S3ExecutionCacheStore {
+	core := client.CreateMinioCoreOrFatal(s3Config.Host, s3Config.Port, keys, s3Config.Secure, s3Config.Region, s3Config.BucketName, initConnectionTimeout)
+	logger.Infof("Using S3 cache store in bucket %s with prefix %q", s3Config.BucketName, s3Config.Prefix)
+	return storage.NewS3ExecutionCacheStore(&storage.MinioS3Client{Core: core}, s3Config.BucketName, s3Config.Prefix, scope, timeInterface)
+}
+
+func initDBClient(dbConfig config.DBConfig, initConnectionTimeout time.Duration) (*storage.DB, *client.MySQLConnector) {
+	driverName := dbConfig.Driver
+	var connector *client.MySQLConnector
 
 	switch driverName {
-	case mysqlDBDriverDefault:
-		arg = initMysql(params, initConnectionTimeout)
+	case config.DriverMySQL:
+		// The connector opens new connections with the current password, which rotates with the
+		// mounted secret.
+		connector = client.NewMySQLConnector(initMysql(dbConfig, initConnectionTimeout))
 	default:
 		glog.Fatalf("Driver %v is not supported", driverName)
 	}
 
 	// db is safe for concurrent use by multiple goroutines
 	// and maintains its own pool of idle connections.
-	db, err := gorm.Open(driverName, arg)
+	sqlDB := sql.OpenDB(connector)
+	// The pool is bounded so that the replicas of the webhook share the connections of the database.
+	client.ConfigurePool(sqlDB, dbConfig.Pool())
+	db, err := gorm.Open(driverName, sqlDB)
 	util.TerminateIfError(err)
 
 	// Create table
-	response := db.AutoMigrate(&model.ExecutionCache{})
+	response := db.AutoMigrate(&model.ExecutionCache{}, &model.ExecutionCachePartition{})
 	if response.Error != nil {
 		glog.Fatalf("Failed to initialize the databases.")
 	}
+	if err := storage.AddEvictionIndexes(storage.NewDB(db), "execution_caches"); err != nil {
+		glog.Fatalf("Failed to initialize the databases. Error: %v", err)
+	}
 
 	response = db.Model(&model.ExecutionCache{}).ModifyColumn("ExecutionOutput", "longtext")
 	if response.Error != nil {
@@ -96,27 +541,47 @@ func initDBClient(params WhSvrDBParameters, initConnectionTimeout time.Duration)
 	var tableNames []string
 	db.Raw(`show tables`).Pluck("Tables_in_caches", &tableNames)
 	for _, tableName := range tableNames {
-		log.Printf(tableName)
+		logger.Debugf("Found table %s", tableName)
 	}
 
-	return storage.NewDB(db)
+	return storage.NewDB(db), connector
 }
 
-func initMysql(params WhSvrDBParameters, initConnectionTimeout time.Duration) string {
+// initArgoPersistence connects to the database where Argo offloads node statuses, with the
+// credentials of the cache database. The database is only read, and reached on first use.
+func initArgoPersistence(dbConfig config.DBConfig, watcherConfig config.WatcherConfig) (*client.ArgoOffloadedNodes, *client.MySQLConnector) {
+	connector := client.NewMySQLConnector(client.CreateMySQLConfig(
+		dbConfig.User,
+		dbConfig.Password,
+		dbConfig.Host,
+		dbConfig.Port,
+		watcherConfig.ArgoPersistenceDBName,
+		dbConfig.GroupConcatMaxLen,
+		map[string]string{},
+	))
+	logger.Infof("Resolving the node statuses offloaded by Argo from %s.%s", watcherConfig.ArgoPersistenceDBName, watcherConfig.ArgoPersistenceTable)
+	offloadedNodes := client.NewArgoOffloadedNodes(sql.OpenDB(connector), watcherConfig.ArgoPersistenceClusterName, watcherConfig.ArgoPersistenceTable)
+	return offloadedNodes, connector
+}
+
+func initMysql(dbConfig config.DBConfig, initConnectionTimeout time.Duration) *mysql.Config {
 	mysqlConfig := client.CreateMySQLConfig(
-		params.dbUser,
-		params.dbPwd,
-		params.dbHost,
-		params.dbPort,
+		dbConfig.User,
+		dbConfig.Password,
+		dbConfig.Host,
+		dbConfig.Port,
 		"",
-		params.dbGroupConcatMaxLen,
+		dbConfig.GroupConcatMaxLen,
 		map[string]string{},
 	)
+	mysqlConfig.Timeout = dbConfig.DialTimeout
+	mysqlConfig.ReadTimeout = dbConfig.ReadTimeout
+	mysqlConfig.WriteTimeout = dbConfig.WriteTimeout
 
 	var db *sql.DB
 	var err error
 	var operation = func() error {
-		db, err = sql.Open(params.dbDriver, mysqlConfig.FormatDSN())
+		db, err = sql.Open(dbConfig.Driver, mysqlConfig.FormatDSN())
 		if err != nil {
 			return err
 		}
@@ -130,13 +595,13 @@ func initMysql(params WhSvrDBParameters, initConnectionTimeout time.Duration) st
 	util.TerminateIfError(err)
 
 	// Create database if not exist
-	dbName := params.dbName
+	dbName := dbConfig.Name
 	operation = func() error {
 		_, err = db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName))
 		if err != nil {
 			return err
 		}
-		log.Printf("Database created")
+		logger.Infof("Database created")
 		return nil
 	}
 	b = backoff.NewExponentialBackOff()
@@ -161,12 +626,5 @@ func initMysql(params WhSvrDBParameters, initConnectionTimeout time.Duration) st
 	// it means this row is not found.
 	// Config reference: https://github.com/go-sql-driver/mysql#clientfoundrows
 	mysqlConfig.ClientFoundRows = true
-	return mysqlConfig.FormatDSN()
-}
-
-func NewClientManager(params WhSvrDBParameters) ClientManager {
-	clientManager := ClientManager{}
-	clientManager.init(params)
-
-	return clientManager
+	return mysqlConfig
 }
@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// newRedisClientManager returns a client manager of the Redis cache store of the returned miniredis.
+func newRedisClientManager(t *testing.T) (*ClientManager, *miniredis.Miniredis) {
+	redis, err := miniredis.Run()
+	require.Nil(t, err)
+	t.Cleanup(redis.Close)
+	flags := newFlagSet("cache_server", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	cfg, err := config.Load(flags, nil, lookupEnvOf(map[string]string{
+		"CACHE_STORE_BACKEND": config.StoreRedis,
+		"REDIS_HOST":          redis.Host(),
+		"REDIS_PORT":          redis.Port(),
+	}))
+	require.Nil(t, err)
+	return NewClientManager(cfg), redis
+}
+
+func TestClientManagerInitializesClientsOnceConcurrently(t *testing.T) {
+	clientManager, _ := newRedisClientManager(t)
+	var _ server.ClientManagerInterface = clientManager
+	assert.Nil(t, clientManager.redisClient, "nothing is connected to until used")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientManager.CacheStore()
+			clientManager.RedisClient()
+			clientManager.StatsStore()
+			clientManager.RotateCredentials(config.Credentials{AdminToken: "rotated"})
+		}()
+	}
+	wg.Wait()
+
+	redisClient := clientManager.RedisClient()
+	require.NotNil(t, redisClient)
+	assert.Nil(t, redisClient.Ping(context.Background()).Err())
+	assert.Nil(t, clientManager.DB(), "the Redis store has no database")
+	assert.Nil(t, clientManager.AdminStore())
+	assert.Nil(t, clientManager.ReuseStore())
+	checks := clientManager.ReadinessChecks(0, 0)
+	require.Len(t, checks, 1)
+	assert.Equal(t, "redis", checks[0].Name)
+	assert.True(t, checks[0].Critical)
+	assert.NotNil(t, clientManager.AdminToken())
+}
+
+func TestClientManagerClose(t *testing.T) {
+	clientManager, _ := newRedisClientManager(t)
+	redisClient := clientManager.RedisClient()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientManager.Close()
+		}()
+	}
+	wg.Wait()
+
+	assert.NotNil(t, redisClient.Ping(context.Background()).Err(), "the initialized clients are closed")
+	assert.Nil(t, clientManager.KubernetesCoreClient(), "no client is initialized once closed")
+	assert.Nil(t, clientManager.CacheStore())
+}
+
+func TestWebhookAdmitsPodsWhileRedisIsDown(t *testing.T) {
+	clientManager, redis := newRedisClientManager(t)
+	defer clientManager.Close()
+	// Redis is down from the start, the client manager connects to it lazily.
+	redis.Close()
+	webhook := server.NewWebhook(server.WebhookConfig{})
+	review, err := ioutil.ReadFile("testdata/evaluate/kfp_pod_review.json")
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(review))
+	req.Header.Set(server.ContentType, server.JsonContentType)
+	rr := httptest.NewRecorder()
+
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.True(t, response.Response.Allowed, "the pod is admitted uncached")
+	var patches []struct {
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.Nil(t, json.Unmarshal(response.Response.Patch, &patches))
+	require.Len(t, patches, 2, "the pod misses")
+	assert.NotEmpty(t, patches[0].Value[server.NewAnnotationKeys(server.DefaultAnnotationPrefix).ExecutionKey], "the outputs of the pod are recorded")
+	assert.Contains(t, rr.Body.String(), "execution cache lookup failed, step will run uncached")
+}
+
+func TestClientManagerWithMemoryStore(t *testing.T) {
+	flags := newFlagSet("cache_server", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	cfg, err := config.Load(flags, nil, lookupEnvOf(map[string]string{"CACHE_STORE_BACKEND": config.StoreMemory}))
+	require.Nil(t, err)
+	clientManager := NewClientManager(cfg)
+	defer clientManager.Close()
+
+	created, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "key",
+		ExecutionTemplate: "template",
+		ExecutionOutput:   "output",
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+	found, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.NotNil(t, clientManager.DB())
+	assert.NotNil(t, clientManager.AdminStore())
+	assert.Nil(t, clientManager.RedisClient())
+	checks := clientManager.ReadinessChecks(time.Second, 0)
+	require.Len(t, checks, 1)
+	assert.Nil(t, checks[0].Check(context.Background()))
+}
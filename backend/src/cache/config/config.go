@@ -0,0 +1,417 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config holds the settings of the cache server, which are read from flags with
+// environment variables as defaults.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+)
+
+const (
+	// DefaultWebhookPort is the TLS port of the webhook. We listen on port 8443 such that we do not
+	// need root privileges or extra capabilities for this server.
+	DefaultWebhookPort string = "8443"
+	// DefaultHealthPort serves the probes and metrics over plain HTTP, so that neither the kubelet
+	// nor Prometheus need a client certificate.
+	DefaultHealthPort string = "8080"
+
+	DefaultTLSDir      string = "/etc/webhook/certs"
+	DefaultTLSCertFile string = "cert.pem"
+	DefaultTLSKeyFile  string = "key.pem"
+)
+
+// Execution cache stores.
+const (
+	StoreMySQL string = "mysql"
+	StoreS3    string = "s3"
+	StoreRedis string = "redis"
+	// StoreMemory keeps the entries in the memory of the replica, lost on restart, e.g. for
+	// development and tests.
+	StoreMemory string = "memory"
+)
+
+const (
+	DriverMySQL string = "mysql"
+
+	redactedValue string = "REDACTED"
+)
+
+// Config holds every setting of the cache server.
+type Config struct {
+	// File is the YAML configuration file, if any. It is checked for changes every
+	// FileReloadInterval, as are the credential files.
+	File               string
+	FileReloadInterval time.Duration
+
+	NamespaceToWatch string
+	Listener         ListenerConfig
+	TLS              TLSConfig
+	DB               DBConfig
+	S3               S3Config
+	Redis            RedisConfig
+	Cache            CacheConfig
+	Watcher          WatcherConfig
+	Observability    ObservabilityConfig
+	Audit            AuditConfig
+
+	// flags holds the options of the configuration. explicit holds those given on the command line
+	// and lookupEnv reads the environment, so that the configuration can be loaded again when the
+	// file changes. envNames maps the registered flags to their environment variables.
+	flags     *flag.FlagSet
+	explicit  map[string]string
+	lookupEnv LookupEnvFunc
+	envNames  map[string]string
+	secrets   map[string]bool
+}
+
+// ListenerConfig holds the settings of the webhook and health listeners.
+type ListenerConfig struct {
+	WebhookPort string
+	HealthPort  string
+	// GRPCPort serves the ExecutionCacheService admin API over gRPC. It is not served when empty.
+	GRPCPort string
+	// ShutdownGracePeriod bounds how long in-flight admissions are waited for on shutdown.
+	ShutdownGracePeriod time.Duration
+	// ShutdownDrainDelay is how long the webhook keeps serving once reported as not ready.
+	ShutdownDrainDelay time.Duration
+	HealthDBTimeout    time.Duration
+	HealthRedisTimeout time.Duration
+	// SelfTestTimeout bounds each check of /selftest.
+	SelfTestTimeout time.Duration
+	// StatsCacheInterval is how long the store side of /v1/cache/stats is reused.
+	StatsCacheInterval time.Duration
+	// AdminToken holds the bearer tokens of the /v1/ APIs, one per line, which are disabled without
+	// one.
+	AdminToken     string
+	AdminTokenFile string
+}
+
+// TLSConfig holds the serving certificate of the webhook and the CAs of its clients.
+type TLSConfig struct {
+	Enabled bool
+	// AllowPlainHTTPOnDefaultPort allows serving without TLS on DefaultWebhookPort, which the
+	// Service exposes as HTTPS.
+	AllowPlainHTTPOnDefaultPort bool
+	// CertFile and KeyFile are relative to Dir.
+	Dir      string
+	CertFile string
+	KeyFile  string
+	// ClientCAFile makes the webhook require client certificates signed by its CAs.
+	ClientCAFile string
+	// CAFile, relative to Dir, holds the CA the self test verifies the serving certificate
+	// against. The serving certificate itself is trusted when empty.
+	CAFile string
+	// SelfSigned generates an ephemeral certificate for SelfSignedDNSNames instead of reading the
+	// one in Dir, and patches its CA into MutatingWebhookConfiguration when set.
+	SelfSigned                   bool
+	SelfSignedDNSNames           string
+	MutatingWebhookConfiguration string
+}
+
+// DBConfig holds the connection settings of the database.
+type DBConfig struct {
+	Driver            string
+	Host              string
+	Port              string
+	Name              string
+	User              string
+	Password          string
+	PasswordFile      string
+	GroupConcatMaxLen string
+	// MaxOpenConns, MaxIdleConns, ConnMaxLifetime and ConnMaxIdleTime bound the connection pool of
+	// each replica. DialTimeout, ReadTimeout and WriteTimeout bound connecting and the I/O of a query.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+}
+
+// Pool returns the bounds of the connection pool.
+func (c DBConfig) Pool() client.SQLPoolConfig {
+	return client.SQLPoolConfig{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+		ConnMaxIdleTime: c.ConnMaxIdleTime,
+	}
+}
+
+// S3Config holds the settings of the S3-compatible object store.
+type S3Config struct {
+	Host          string
+	Port          string
+	Region        string
+	Secure        bool
+	AccessKey     string
+	AccessKeyFile string
+	SecretKey     string
+	SecretKeyFile string
+	BucketName    string
+	Prefix        string
+}
+
+// RedisConfig holds the settings of Redis, which is used when a host or addresses are set.
+type RedisConfig struct {
+	Mode       string
+	Host       string
+	Port       string
+	MasterName string
+	// Addresses are comma separated sentinel addresses in sentinel mode or seed node addresses in
+	// cluster mode.
+	Addresses             string
+	KeyPrefix             string
+	Password              string
+	PasswordFile          string
+	DB                    int
+	TLSEnabled            bool
+	TLSCACertPath         string
+	TLSInsecureSkipVerify bool
+	// OperationTimeout bounds each Redis call of the cache stores. The other timeouts configure
+	// the connection pool of the client.
+	OperationTimeout time.Duration
+	DialTimeout      time.Duration
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	PoolTimeout      time.Duration
+	PoolSize         int
+	MinIdleConns     int
+	// MaxRetries is the number of times a command failing on a network error is retried.
+	MaxRetries int
+	// The write-through store skips Redis for CircuitCoolDown after CircuitFailureThreshold
+	// consecutive failures.
+	CircuitFailureThreshold int
+	CircuitCoolDown         time.Duration
+}
+
+// Enabled reports whether Redis is configured.
+func (c RedisConfig) Enabled() bool {
+	return c.Host != "" || c.Addresses != ""
+}
+
+// AddressList returns the non-empty Addresses.
+func (c RedisConfig) AddressList() []string {
+	var addresses []string
+	for _, address := range strings.Split(c.Addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// CacheConfig holds the settings of the execution cache and of the admissions looking it up.
+type CacheConfig struct {
+	// AnnotationPrefix is the domain of the annotations and labels the cache reads and writes on
+	// pods.
+	AnnotationPrefix   string
+	Store              string
+	PartitionBy        string
+	PartitionLookback  int
+	PartitionRetention int
+	EnforceOwner       bool
+	FailPolicy         string
+	// Mode is server.CacheModeActive or server.CacheModeShadow, whether the pods found in cache
+	// are served from it or only counted.
+	Mode string
+	// MaxRequestBodyBytes is an int since it is read from an int flag.
+	MaxRequestBodyBytes int
+	AdmissionDeadline   time.Duration
+	// Cache lookups are skipped for LookupCircuitCoolDown after LookupCircuitFailureThreshold
+	// consecutive failures.
+	LookupCircuitFailureThreshold int
+	LookupCircuitCoolDown         time.Duration
+	// Admissions beyond MaxConcurrentAdmissions wait up to AdmissionQueueTimeout for a slot. Each
+	// namespace gets AdmissionRatePerNamespace admissions per second with bursts of
+	// AdmissionBurstPerNamespace.
+	MaxConcurrentAdmissions    int
+	AdmissionQueueTimeout      time.Duration
+	AdmissionRatePerNamespace  float64
+	AdmissionBurstPerNamespace int
+	// LookupMissTTL is how long a cache miss answers the same lookups without querying the store.
+	LookupMissTTL time.Duration
+	// The entries of each namespace are bounded to NamespaceMaxEntries entries and
+	// NamespaceMaxOutputBytes output bytes, zero does not bound them, unless overridden by
+	// NamespaceQuotas, read from NamespaceQuotasFile.
+	NamespaceMaxEntries     int
+	NamespaceMaxOutputBytes int
+	NamespaceQuotasFile     string
+	NamespaceQuotas         map[string]server.NamespaceQuota
+	// The entries of the whole store are bounded to MaxEntries entries and MaxOutputBytes output
+	// bytes, zero does not bound them. The namespace quotas and this bound evict entries first in
+	// the order of EvictionPolicy, storage.EvictionPolicyLRU or storage.EvictionPolicyLFU.
+	MaxEntries     int
+	MaxOutputBytes int
+	EvictionPolicy string
+	// SignatureKey holds the keys the cache fields of pods are signed with, one per line, for the
+	// validating webhook to flag pods with cache fields the webhook did not issue under
+	// ValidationMode. Pods are neither signed nor validated without key.
+	SignatureKey     string
+	SignatureKeyFile string
+	ValidationMode   string
+	// ClusterID identifies the cluster among those sharing the cache store. The watcher records it
+	// on the entries and the webhook reuses those of other clusters under CrossCluster, verifying
+	// their artifacts in the object store of S3 first with VerifyRemoteArtifacts.
+	ClusterID             string
+	CrossCluster          string
+	VerifyRemoteArtifacts bool
+	// MarkWorkflows serves the webhook predicting the cache hits of the templates of workflows.
+	MarkWorkflows bool
+	// KeyVersion is the version of the key strategy of the pods that do not ask for one.
+	KeyVersion string
+	// KeyIgnoredFields is a comma separated list of the paths to the fields of the templates removed
+	// before their cache key is generated.
+	KeyIgnoredFields string
+	// DefaultTTL is the time-to-live of the entries of the pods without cache_ttl annotation, zero
+	// leaves their entries to never expire.
+	DefaultTTL time.Duration
+	// AllowedNamespaces and DeniedNamespaces are comma separated lists of the namespaces whose
+	// pods are served from cache, all when none is allowed, and of those whose pods are not.
+	AllowedNamespaces string
+	DeniedNamespaces  string
+	// ImageDigests folds the digests of the images of the templates into their cache keys, as
+	// resolved from their registry and remembered for ImageDigestTTL.
+	ImageDigests   bool
+	ImageDigestTTL time.Duration
+	// The containers running in place of the steps of the pods served from cache run DummyImage
+	// with DummyCommand, space separated, and the resources of DummyResourceRequests and
+	// DummyResourceLimits, comma separated name=quantity lists. DummyImagePullSecrets is a comma
+	// separated list of the secrets added to the pods to pull the image.
+	DummyImage            string
+	DummyCommand          string
+	DummyResourceRequests string
+	DummyResourceLimits   string
+	DummyImagePullSecrets string
+	// HitScheduling is server.HitSchedulingKeep or server.HitSchedulingStrip, whether the pods
+	// served from cache keep their tolerations, node selector, affinity and runtime class.
+	// HitNodeSelector, a comma separated list of key=value node labels, replaces their node
+	// selector when not empty.
+	HitScheduling   string
+	HitNodeSelector string
+}
+
+// WatcherConfig holds the settings of the pod watcher recording the outputs of completed pods.
+type WatcherConfig struct {
+	ResyncPeriod    time.Duration
+	CatchUpLookback time.Duration
+	PatchQPS        float64
+	PatchBurst      int
+	// WriteWorkers is the number of cache entries written at once, at a rate of WriteQPS with
+	// bursts of WriteBurst. Failed writes are retried WriteMaxRetries times. At most
+	// WriteQueueSize entries wait to be written.
+	WriteWorkers    int
+	WriteQPS        float64
+	WriteBurst      int
+	WriteMaxRetries int
+	WriteQueueSize  int
+	// BackfillOnStart records the succeeded pods completed up to BackfillMaxAge ago once the
+	// watchers start.
+	BackfillOnStart bool
+	BackfillMaxAge  time.Duration
+	// Namespaces is a comma separated list of namespaces or a label selector on namespaces, as
+	// parsed by server.ParseWatchedNamespaces. NamespaceToWatch is watched when empty.
+	Namespaces string
+	// LeaderElection runs the watchers of a single replica, the holder of the lease LeaseName in
+	// LeaseNamespace, or in NamespaceToWatch when empty. The replica holds it as LeaseIdentity,
+	// its hostname when empty, for LeaseDuration, renewing it every RetryPeriod and giving it up
+	// when not renewed within RenewDeadline.
+	LeaderElection bool
+	LeaseName      string
+	LeaseNamespace string
+	LeaseIdentity  string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+	// ArgoPersistenceDBName is the database, on the server of DB, where Argo offloads the node
+	// statuses of large workflows into ArgoPersistenceTable. Offloaded outputs are not resolved
+	// when empty.
+	ArgoPersistenceDBName      string
+	ArgoPersistenceTable       string
+	ArgoPersistenceClusterName string
+	// MLMDAddress is the host:port of the ML Metadata gRPC server the pods served from cache are
+	// recorded in as executions, retried MLMDMaxRetries times, and the executions of the TFX pods
+	// served from cache are restored in. They are not recorded, and TFX pods not served from cache,
+	// when empty.
+	MLMDAddress    string
+	MLMDMaxRetries int
+	// ScrubInterval is the time between the passes of the artifact scrubber, which deletes the
+	// entries created more than ScrubMinAge ago whose artifacts no longer exist in the object
+	// store of S3. It checks ScrubConcurrency entries at once, sending ScrubQPS requests per
+	// second with bursts of ScrubBurst. Zero disables the scrubber.
+	ScrubInterval    time.Duration
+	ScrubMinAge      time.Duration
+	ScrubConcurrency int
+	ScrubQPS         float64
+	ScrubBurst       int
+}
+
+// ObservabilityConfig holds the settings of logs, metrics, traces and profiles.
+type ObservabilityConfig struct {
+	LogLevel          string
+	LogFormat         string
+	OTLPEndpoint      string
+	MaxTemplateLabels int
+	Pprof             server.PprofConfig
+	PprofAddress      string
+	// DecisionBufferSize is the number of recent decisions served on the pprof address. Zero
+	// disables keeping them.
+	DecisionBufferSize int
+	// LogCachedOutputs logs the outputs of cache hits at debug level, with the values of the
+	// parameters matching SensitiveParameterPatterns redacted.
+	LogCachedOutputs           bool
+	SensitiveParameterPatterns string
+}
+
+// AuditConfig holds the settings of the audit log of cache decisions.
+type AuditConfig struct {
+	// Sink is one of server.AuditSinkStdout, server.AuditSinkFile and server.AuditSinkDB. Auditing
+	// is disabled when empty.
+	Sink           string
+	File           string
+	FileMaxBytes   int
+	FileMaxBackups int
+	BufferSize     int
+}
+
+// String lists the settings sorted by flag name with secrets redacted, e.g. for logging them at
+// startup.
+func (c *Config) String() string {
+	if c.flags == nil {
+		return ""
+	}
+	var lines []string
+	// Flags are visited in lexicographical order.
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if _, registered := c.envNames[f.Name]; !registered {
+			// Flags of libraries sharing the flag set.
+			return
+		}
+		value := f.Value.String()
+		if c.secrets[f.Name] && value != "" {
+			value = redactedValue
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", f.Name, value))
+	})
+	return strings.Join(lines, "\n")
+}
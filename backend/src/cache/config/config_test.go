@@ -0,0 +1,624 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the tests.")
+
+func fakeEnv(env map[string]string) LookupEnvFunc {
+	return func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+}
+
+func load(args []string, env map[string]string) (*Config, error) {
+	return Load(flag.NewFlagSet("cache", flag.ContinueOnError), args, fakeEnv(env))
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := load(nil, nil)
+	require.Nil(t, err)
+
+	assert.Equal(t, DefaultWebhookPort, config.Listener.WebhookPort)
+	assert.Equal(t, DefaultHealthPort, config.Listener.HealthPort)
+	assert.True(t, config.TLS.Enabled)
+	assert.Equal(t, filepath.Join(DefaultTLSDir, DefaultTLSCertFile), filepath.Join(config.TLS.Dir, config.TLS.CertFile))
+	assert.Equal(t, StoreMySQL, config.Cache.Store)
+	assert.Equal(t, "mysql", config.DB.Host)
+	assert.Equal(t, "kubeflow", config.NamespaceToWatch)
+	assert.False(t, config.Redis.Enabled())
+	assert.Equal(t, 100, config.Redis.PoolSize)
+}
+
+func TestLoadReadsEnvironmentAndFlags(t *testing.T) {
+	config, err := load([]string{"--redis_pool_size=20", "--db_host=mysql.kubeflow", "--tls_cert_file=tls.crt"}, map[string]string{
+		"CACHE_STORE_BACKEND":          StoreRedis,
+		"REDIS_MODE":                   "sentinel",
+		"REDIS_SENTINEL_MASTER":        "mymaster",
+		"REDIS_ADDRESSES":              "sentinel-0:26379, sentinel-1:26379,",
+		"REDIS_POOL_SIZE":              "50",
+		"ADMISSION_DEADLINE":           "2s",
+		"ADMISSION_RATE_PER_NAMESPACE": "2.5",
+		"CACHE_ENFORCE_OWNER":          "true",
+		"TLS_DIR":                      "/var/run/certs",
+		"TLS_CERT_FILE":                "cert.pem",
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, StoreRedis, config.Cache.Store)
+	assert.Equal(t, []string{"sentinel-0:26379", "sentinel-1:26379"}, config.Redis.AddressList())
+	// Flags override the environment.
+	assert.Equal(t, 20, config.Redis.PoolSize)
+	assert.Equal(t, "mysql.kubeflow", config.DB.Host)
+	assert.Equal(t, 2*time.Second, config.Cache.AdmissionDeadline)
+	assert.Equal(t, 2.5, config.Cache.AdmissionRatePerNamespace)
+	assert.True(t, config.Cache.EnforceOwner)
+	assert.Equal(t, "/var/run/certs", config.TLS.Dir)
+	assert.Equal(t, "tls.crt", config.TLS.CertFile)
+	assert.Equal(t, DefaultTLSKeyFile, config.TLS.KeyFile)
+}
+
+func TestLoadReadsTheFormerNamesOfTheEnvironment(t *testing.T) {
+	c, err := load(nil, map[string]string{"CACHE_STORE": StoreS3, "OBJECTSTORECONFIG_BUCKETNAME": "cache"})
+	require.Nil(t, err)
+	assert.Equal(t, StoreS3, c.Cache.Store)
+
+	c, err = load(nil, map[string]string{"CACHE_STORE": StoreRedis, "CACHE_STORE_BACKEND": StoreMemory})
+	require.Nil(t, err)
+	assert.Equal(t, StoreMemory, c.Cache.Store, "the current name takes precedence")
+}
+
+func TestLoadRejectsInvalidEnvironment(t *testing.T) {
+	_, err := load(nil, map[string]string{"REDIS_POOL_SIZE": "many", "ADMISSION_DEADLINE": "soon"})
+
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `invalid value "many" of REDIS_POOL_SIZE`)
+	assert.Contains(t, err.Error(), `invalid value "soon" of ADMISSION_DEADLINE`)
+}
+
+func TestLoadValidatesCombinations(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name: "plain HTTP on another port",
+			env:  map[string]string{"TLS_ENABLED": "false", "WEBHOOK_PORT": "9443"},
+		},
+		{
+			name: "plain HTTP on the default port when allowed",
+			env:  map[string]string{"TLS_ENABLED": "false", "ALLOW_PLAIN_HTTP_ON_DEFAULT_PORT": "true"},
+		},
+		{
+			name: "S3 store",
+			env:  map[string]string{"CACHE_STORE_BACKEND": StoreS3, "CACHE_WEBHOOK_FAIL_POLICY": "closed"},
+		},
+		{
+			name: "default ttl",
+			env:  map[string]string{"CACHE_DEFAULT_TTL": "168h"},
+		},
+		{
+			name: "ignored template fields",
+			env:  map[string]string{"CACHE_KEY_IGNORED_FIELDS": "container.env[name=RUN_ID], sidecars[*].env[name=\"POD_NAME\"]"},
+		},
+		{
+			name: "image digests",
+			env:  map[string]string{"CACHE_IMAGE_DIGESTS": "true", "CACHE_IMAGE_DIGEST_TTL": "5m"},
+		},
+		{
+			name: "namespace filter",
+			env:  map[string]string{"CACHE_ALLOWED_NAMESPACES": "kubeflow,team-a", "CACHE_DENIED_NAMESPACES": "team-b"},
+		},
+		{
+			name: "memory store",
+			env:  map[string]string{"CACHE_STORE_BACKEND": StoreMemory},
+		},
+		{
+			name: "leader election with a longer lease",
+			env: map[string]string{
+				"LEADER_ELECTION":                "true",
+				"LEADER_ELECTION_IDENTITY":       "cache-server-0",
+				"LEADER_ELECTION_LEASE_DURATION": "60s",
+				"LEADER_ELECTION_RENEW_DEADLINE": "40s",
+				"LEADER_ELECTION_RETRY_PERIOD":   "5s",
+			},
+		},
+		{
+			name: "shadow mode failing closed",
+			env:  map[string]string{"CACHE_MODE": "shadow", "CACHE_WEBHOOK_FAIL_POLICY": "closed"},
+		},
+		{
+			name: "cache hits on a node pool",
+			env:  map[string]string{"CACHE_HIT_SCHEDULING": "strip", "CACHE_HIT_NODE_SELECTOR": "cloud.google.com/gke-nodepool=cache-hits"},
+		},
+		{
+			name: "dummy container",
+			env: map[string]string{
+				"CACHE_DUMMY_IMAGE":              "registry.local/busybox",
+				"CACHE_DUMMY_RESOURCE_REQUESTS":  "cpu=10m,memory=16Mi",
+				"CACHE_DUMMY_RESOURCE_LIMITS":    "memory=32Mi",
+				"CACHE_DUMMY_IMAGE_PULL_SECRETS": "registry",
+			},
+		},
+		{
+			name: "database pool shared by many replicas",
+			env: map[string]string{
+				"DB_MAX_OPEN_CONNS": "5",
+				"DB_MAX_IDLE_CONNS": "5",
+				"DB_READ_TIMEOUT":   "10s",
+				"REDIS_HOST":        "redis",
+				"REDIS_MAX_RETRIES": "2",
+				"REDIS_POOL_SIZE":   "20",
+			},
+		},
+		{
+			name: "monthly partitions",
+			env:  map[string]string{"CACHE_PARTITION_BY": "month", "CACHE_PARTITION_RETENTION": "12"},
+		},
+		{
+			name: "Redis cluster with TLS",
+			env:  map[string]string{"REDIS_MODE": "cluster", "REDIS_ADDRESSES": "redis-0:6379", "REDIS_TLS_ENABLED": "true", "REDIS_TLS_CA_CERT_PATH": "/etc/redis/ca.pem"},
+		},
+		{
+			name: "self-signed certificate patched into the webhook configuration",
+			env:  map[string]string{"GENERATE_SELF_SIGNED_CERT": "true", "MUTATING_WEBHOOK_CONFIGURATION": "cache-webhook-kubeflow"},
+		},
+		{
+			name: "client certificates verified",
+			env:  map[string]string{"WEBHOOK_CLIENT_CA_FILE": "/etc/webhook/client-ca/ca.pem"},
+		},
+		{
+			name: "self test verifying the serving certificate with its CA",
+			env:  map[string]string{"TLS_CA_FILE": "ca.crt", "SELF_TEST_TIMEOUT": "10s"},
+		},
+		{
+			name: "audit to a rotated file",
+			env:  map[string]string{"AUDIT_SINK": "file", "AUDIT_FILE": "/var/log/cache/audit.log"},
+		},
+		{
+			name: "audit to the database",
+			env:  map[string]string{"AUDIT_SINK": "db"},
+		},
+		{
+			name: "annotations of another orchestrator",
+			env:  map[string]string{"CACHE_ANNOTATION_PREFIX": "workflows.platform.example.com"},
+		},
+		{
+			name: "clusters sharing the cache",
+			env:  map[string]string{"CACHE_CLUSTER_ID": "us-east1", "CACHE_CROSS_CLUSTER": "prefer-local", "CACHE_VERIFY_REMOTE_ARTIFACTS": "true"},
+		},
+		{
+			name: "canonical cache keys by default",
+			env:  map[string]string{"CACHE_KEY_VERSION": "2"},
+		},
+		{
+			name: "workflows marked with predictions",
+			env:  map[string]string{"CACHE_MARK_WORKFLOWS": "true"},
+		},
+		{
+			name:    "invalid ignored template field",
+			env:     map[string]string{"CACHE_KEY_IGNORED_FIELDS": "container.env[name]"},
+			wantErr: `invalid ignored template field "container.env[name]"`,
+		},
+		{
+			name:    "invalid dummy container resources",
+			env:     map[string]string{"CACHE_DUMMY_RESOURCE_REQUESTS": "cpu=10m,memory"},
+			wantErr: `invalid dummy container resource requests: "memory" is not a name=quantity pair`,
+		},
+		{
+			name:    "empty watcher write queue",
+			env:     map[string]string{"WATCHER_WRITE_QUEUE_SIZE": "0"},
+			wantErr: "watcher write queue size must be at least 1, got 0",
+		},
+		{
+			name:    "leader election renewing past the lease duration",
+			env:     map[string]string{"LEADER_ELECTION": "true", "LEADER_ELECTION_LEASE_DURATION": "10s", "LEADER_ELECTION_RENEW_DEADLINE": "20s"},
+			wantErr: "leader election lease duration 10s must exceed the renew deadline 20s",
+		},
+		{
+			name:    "leader election retrying past the renew deadline",
+			env:     map[string]string{"LEADER_ELECTION": "true", "LEADER_ELECTION_RETRY_PERIOD": "9s"},
+			wantErr: "leader election renew deadline 10s must exceed 1.2 times the retry period 9s",
+		},
+		{
+			name:    "database pool idling more connections than it opens",
+			env:     map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "10"},
+			wantErr: "database max idle connections (10) must not exceed max open connections (5)",
+		},
+		{
+			name:    "negative database timeout",
+			env:     map[string]string{"DB_DIAL_TIMEOUT": "-1s"},
+			wantErr: "database timeouts must not be negative",
+		},
+		{
+			name:    "unknown cache mode",
+			env:     map[string]string{"CACHE_MODE": "dry-run"},
+			wantErr: `invalid cache mode "dry-run", expected active or shadow`,
+		},
+		{
+			name:    "unknown cache hit scheduling",
+			env:     map[string]string{"CACHE_HIT_SCHEDULING": "drop"},
+			wantErr: `invalid cache hit scheduling "drop", expected keep or strip`,
+		},
+		{
+			name:    "invalid cache hit node selector",
+			env:     map[string]string{"CACHE_HIT_NODE_SELECTOR": "pool"},
+			wantErr: `invalid cache hit node selector: "pool" is not a key=value pair`,
+		},
+		{
+			name:    "unknown cache key version",
+			env:     map[string]string{"CACHE_KEY_VERSION": "v2"},
+			wantErr: `unknown cache key version "v2"`,
+		},
+		{
+			name:    "webhook port out of range",
+			args:    []string{"--webhook_port=70000"},
+			wantErr: `webhook port "70000" is not a port between 1 and 65535`,
+		},
+		{
+			name:    "health port not a number",
+			env:     map[string]string{"HEALTH_PORT": "http"},
+			wantErr: `health port "http" is not a port between 1 and 65535`,
+		},
+		{
+			name:    "webhook and health ports collide",
+			env:     map[string]string{"WEBHOOK_PORT": "8080"},
+			wantErr: "webhook and health ports must differ",
+		},
+		{
+			name:    "gRPC and health ports collide",
+			env:     map[string]string{"CACHE_GRPC_PORT": "8080"},
+			wantErr: "the gRPC port must differ from the webhook and health ports",
+		},
+		{
+			name:    "plain HTTP on the default port",
+			env:     map[string]string{"TLS_ENABLED": "false"},
+			wantErr: "refusing to serve the webhook without TLS on the default port 8443",
+		},
+		{
+			name:    "self-signed certificate without TLS",
+			env:     map[string]string{"TLS_ENABLED": "false", "WEBHOOK_PORT": "9443", "GENERATE_SELF_SIGNED_CERT": "true"},
+			wantErr: "a self-signed certificate cannot be generated with TLS disabled",
+		},
+		{
+			name:    "client certificates without TLS",
+			env:     map[string]string{"TLS_ENABLED": "false", "WEBHOOK_PORT": "9443", "WEBHOOK_CLIENT_CA_FILE": "/etc/webhook/client-ca/ca.pem"},
+			wantErr: "client certificates cannot be verified with TLS disabled",
+		},
+		{
+			name:    "serving CA without TLS",
+			env:     map[string]string{"TLS_ENABLED": "false", "WEBHOOK_PORT": "9443", "TLS_CA_FILE": "ca.crt"},
+			wantErr: "the serving certificate cannot be verified with TLS disabled",
+		},
+		{
+			name:    "self test without time limit",
+			env:     map[string]string{"SELF_TEST_TIMEOUT": "0s"},
+			wantErr: "self test timeout must be positive",
+		},
+		{
+			name:    "negative stats cache interval",
+			env:     map[string]string{"CACHE_STATS_CACHE_INTERVAL": "-1s"},
+			wantErr: "stats cache interval must not be negative",
+		},
+		{
+			name:    "TLS without key file",
+			args:    []string{"--tls_key_file="},
+			wantErr: "TLS requires the names of the certificate and key files",
+		},
+		{
+			name:    "webhook configuration without self-signed certificate",
+			env:     map[string]string{"MUTATING_WEBHOOK_CONFIGURATION": "cache-webhook-kubeflow"},
+			wantErr: "is only patched with a generated self-signed certificate",
+		},
+		{
+			name:    "unknown cache store",
+			env:     map[string]string{"CACHE_STORE_BACKEND": "memcached"},
+			wantErr: `cache store "memcached" is not supported`,
+		},
+		{
+			name:    "unknown database driver",
+			args:    []string{"--db_driver=postgres"},
+			wantErr: `database driver "postgres" is not supported`,
+		},
+		{
+			name:    "S3 store without bucket",
+			env:     map[string]string{"CACHE_STORE_BACKEND": StoreS3, "OBJECTSTORECONFIG_BUCKETNAME": ""},
+			wantErr: "cache store s3 requires a bucket name",
+		},
+		{
+			name:    "Redis store without Redis",
+			env:     map[string]string{"CACHE_STORE_BACKEND": StoreRedis},
+			wantErr: "cache store redis requires REDIS_HOST or REDIS_ADDRESSES to be set",
+		},
+		{
+			name:    "negative default ttl",
+			env:     map[string]string{"CACHE_DEFAULT_TTL": "-1h"},
+			wantErr: "default ttl must not be negative",
+		},
+		{
+			name:    "negative image digest ttl",
+			env:     map[string]string{"CACHE_IMAGE_DIGEST_TTL": "-1m"},
+			wantErr: "image digest ttl must not be negative",
+		},
+		{
+			name:    "invalid denied namespace",
+			env:     map[string]string{"CACHE_DENIED_NAMESPACES": "team_b"},
+			wantErr: `invalid denied namespaces: "team_b" is not a namespace name`,
+		},
+		{
+			name:    "partitioned memory store",
+			env:     map[string]string{"CACHE_STORE_BACKEND": StoreMemory, "CACHE_PARTITION_BY": "month"},
+			wantErr: "cache store memory cannot be partitioned",
+		},
+		{
+			name:    "unknown partitioning",
+			env:     map[string]string{"CACHE_PARTITION_BY": "week"},
+			wantErr: `partitioning "week" is not supported`,
+		},
+		{
+			name:    "monthly partitions without lookback",
+			env:     map[string]string{"CACHE_PARTITION_BY": "month", "CACHE_PARTITION_LOOKBACK": "0"},
+			wantErr: "partition lookback must be at least 1",
+		},
+		{
+			name:    "unknown Redis mode",
+			env:     map[string]string{"REDIS_HOST": "redis", "REDIS_MODE": "replicated"},
+			wantErr: `Redis mode "replicated" is not supported`,
+		},
+		{
+			name:    "Redis sentinel without master",
+			env:     map[string]string{"REDIS_MODE": "sentinel", "REDIS_ADDRESSES": "sentinel-0:26379"},
+			wantErr: "Redis sentinel mode requires a master name and at least one sentinel address",
+		},
+		{
+			name:    "Redis cluster with database index",
+			env:     map[string]string{"REDIS_MODE": "cluster", "REDIS_ADDRESSES": "redis-0:6379", "REDIS_DB": "2"},
+			wantErr: "Redis cluster mode only supports DB index 0, got 2",
+		},
+		{
+			name:    "Redis CA certificate and skipped verification",
+			env:     map[string]string{"REDIS_HOST": "redis", "REDIS_TLS_ENABLED": "true", "REDIS_TLS_CA_CERT_PATH": "/etc/redis/ca.pem", "REDIS_TLS_INSECURE_SKIP_VERIFY": "true"},
+			wantErr: "a Redis CA certificate and skipping verification are mutually exclusive",
+		},
+		{
+			name:    "Redis TLS settings without TLS",
+			env:     map[string]string{"REDIS_HOST": "redis", "REDIS_TLS_CA_CERT_PATH": "/etc/redis/ca.pem"},
+			wantErr: "Redis TLS settings require REDIS_TLS_ENABLED",
+		},
+		{
+			name:    "Redis without connections",
+			env:     map[string]string{"REDIS_HOST": "redis", "REDIS_POOL_SIZE": "0"},
+			wantErr: "Redis pool size must be positive",
+		},
+		{
+			name:    "unknown audit sink",
+			env:     map[string]string{"AUDIT_SINK": "syslog"},
+			wantErr: `audit sink "syslog" is not supported`,
+		},
+		{
+			name:    "audit file sink without file",
+			env:     map[string]string{"AUDIT_SINK": "file"},
+			wantErr: "audit sink file requires an audit file",
+		},
+		{
+			name:    "audit database sink without database",
+			env:     map[string]string{"AUDIT_SINK": "db", "CACHE_STORE_BACKEND": StoreS3},
+			wantErr: "audit sink db requires the mysql cache store",
+		},
+		{
+			name:    "unknown fail policy",
+			env:     map[string]string{"CACHE_WEBHOOK_FAIL_POLICY": "ignore"},
+			wantErr: `invalid fail policy "ignore"`,
+		},
+		{
+			name:    "unknown log level",
+			env:     map[string]string{"LOG_LEVEL": "verbose"},
+			wantErr: "invalid logging configuration",
+		},
+		{
+			name:    "invalid sensitive parameter pattern",
+			env:     map[string]string{"LOG_SENSITIVE_PARAMETERS": "token,[a-"},
+			wantErr: `invalid sensitive parameter pattern "[a-"`,
+		},
+		{
+			name:    "negative admission deadline",
+			env:     map[string]string{"ADMISSION_DEADLINE": "-1s"},
+			wantErr: "admission deadline must not be negative",
+		},
+		{
+			name:    "negative lookup miss ttl",
+			env:     map[string]string{"CACHE_LOOKUP_MISS_TTL": "-1s"},
+			wantErr: "lookup miss ttl must not be negative",
+		},
+		{
+			name:    "invalid validation mode",
+			env:     map[string]string{"CACHE_VALIDATION_MODE": "reject"},
+			wantErr: `invalid validation mode "reject", expected warn or enforce`,
+		},
+		{
+			name:    "annotation prefix with a path",
+			env:     map[string]string{"CACHE_ANNOTATION_PREFIX": "platform.example.com/cache"},
+			wantErr: `annotation prefix "platform.example.com/cache" is not a DNS subdomain`,
+		},
+		{
+			name:    "invalid cross cluster policy",
+			env:     map[string]string{"CACHE_CROSS_CLUSTER": "global"},
+			wantErr: `invalid cross cluster policy "global", expected shared, local or prefer-local`,
+		},
+		{
+			name:    "local cross cluster policy without cluster ID",
+			env:     map[string]string{"CACHE_CROSS_CLUSTER": "prefer-local"},
+			wantErr: "cross cluster policy prefer-local requires a cluster ID",
+		},
+		{
+			name:    "remote artifacts verified without bucket",
+			env:     map[string]string{"CACHE_VERIFY_REMOTE_ARTIFACTS": "true", "OBJECTSTORECONFIG_BUCKETNAME": ""},
+			wantErr: "verifying remote artifacts requires an object store host and bucket name",
+		},
+		{
+			name:    "negative namespace max entries",
+			env:     map[string]string{"CACHE_NAMESPACE_MAX_ENTRIES": "-1"},
+			wantErr: "namespace max entries must not be negative, got -1",
+		},
+		{
+			name:    "namespace quotas without database store",
+			env:     map[string]string{"CACHE_STORE_BACKEND": StoreS3, "OBJECTSTORECONFIG_BUCKETNAME": "cache", "CACHE_NAMESPACE_MAX_OUTPUT_BYTES": "1048576"},
+			wantErr: "namespace quotas require the mysql cache store, got s3",
+		},
+		{
+			name:    "negative max output bytes",
+			env:     map[string]string{"CACHE_MAX_OUTPUT_BYTES": "-1"},
+			wantErr: "max output bytes must not be negative, got -1",
+		},
+		{
+			name:    "bounded cache without database store",
+			env:     map[string]string{"CACHE_STORE_BACKEND": StoreS3, "OBJECTSTORECONFIG_BUCKETNAME": "cache", "CACHE_MAX_ENTRIES": "100000"},
+			wantErr: "bounding the cache requires the mysql cache store, got s3",
+		},
+		{
+			name:    "unknown eviction policy",
+			env:     map[string]string{"CACHE_EVICTION_POLICY": "fifo"},
+			wantErr: `eviction policy "fifo" is not supported, expected lru or lfu`,
+		},
+		{
+			name:    "negative watcher catch-up lookback",
+			env:     map[string]string{"WATCHER_CATCH_UP_LOOKBACK": "-1h"},
+			wantErr: "watcher catch-up lookback must not be negative",
+		},
+		{
+			name:    "invalid watcher namespaces",
+			env:     map[string]string{"CACHE_WATCHER_NAMESPACES": "team-a,Team B"},
+			wantErr: `watched namespaces "team-a,Team B" are neither namespace names nor a label selector`,
+		},
+		{
+			name:    "watcher without write workers",
+			env:     map[string]string{"WATCHER_WRITE_WORKERS": "0"},
+			wantErr: "watcher write workers must be at least 1, got 0",
+		},
+		{
+			name:    "negative backfill max age",
+			env:     map[string]string{"CACHE_BACKFILL_MAX_AGE": "-1h"},
+			wantErr: "backfill max age must not be negative",
+		},
+		{
+			name:    "watcher without write retries",
+			env:     map[string]string{"WATCHER_WRITE_MAX_RETRIES": "0"},
+			wantErr: "watcher write max retries must be at least 1, got 0",
+		},
+		{
+			name:    "invalid leader election lease name",
+			env:     map[string]string{"LEADER_ELECTION": "true", "LEADER_ELECTION_LEASE_NAME": "Cache Watcher"},
+			wantErr: `leader election lease name "Cache Watcher" is not a valid lease name`,
+		},
+		{
+			name:    "leader election without lease namespace",
+			args:    []string{"--namespace_to_watch="},
+			env:     map[string]string{"LEADER_ELECTION": "true"},
+			wantErr: "leader election requires a lease namespace when no namespace is watched",
+		},
+		{
+			name:    "invalid argo persistence table",
+			env:     map[string]string{"ARGO_PERSISTENCE_DB_NAME": "argo", "ARGO_PERSISTENCE_TABLE": "argo_workflows; DROP TABLE x"},
+			wantErr: `argo persistence table "argo_workflows; DROP TABLE x" is not a valid table name`,
+		},
+		{
+			name:    "mlmd address without port",
+			env:     map[string]string{"CACHE_MLMD_ADDRESS": "metadata-grpc-service.kubeflow"},
+			wantErr: `mlmd address "metadata-grpc-service.kubeflow" is not a host:port address`,
+		},
+		{
+			name:    "mlmd without retries",
+			env:     map[string]string{"CACHE_MLMD_ADDRESS": "metadata-grpc-service.kubeflow:8080", "CACHE_MLMD_MAX_RETRIES": "0"},
+			wantErr: "mlmd max retries must be at least 1, got 0",
+		},
+		{
+			name:    "artifact scrubber on redis",
+			env:     map[string]string{"CACHE_STORE_BACKEND": "redis", "REDIS_HOST": "redis", "CACHE_SCRUB_INTERVAL": "24h"},
+			wantErr: "the artifact scrubber requires the mysql cache store, got redis",
+		},
+		{
+			name:    "artifact scrubber without concurrency",
+			env:     map[string]string{"CACHE_SCRUB_INTERVAL": "24h", "CACHE_SCRUB_CONCURRENCY": "0"},
+			wantErr: "scrub concurrency must be at least 1, got 0",
+		},
+		{
+			name:    "artifact scrubber without min age",
+			env:     map[string]string{"CACHE_SCRUB_INTERVAL": "24h", "CACHE_SCRUB_MIN_AGE": "0s"},
+			wantErr: "scrub min age must be positive, got 0s",
+		},
+		{
+			name:    "rate limiting without burst",
+			env:     map[string]string{"ADMISSION_RATE_PER_NAMESPACE": "5", "ADMISSION_BURST_PER_NAMESPACE": "0"},
+			wantErr: "admission burst per namespace must be at least 1 when rate limiting",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := load(test.args, test.env)
+			if test.wantErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	_, err := load([]string{"--webhook_port=0", "--fail_policy=ignore"}, nil)
+
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "webhook port")
+	assert.Contains(t, err.Error(), "invalid fail policy")
+}
+
+func TestStringRedactsSecrets(t *testing.T) {
+	config, err := load([]string{"--db_password=db-secret"}, map[string]string{
+		"REDIS_HOST":                        "redis",
+		"REDIS_PASSWORD":                    "redis-secret",
+		"OBJECTSTORECONFIG_ACCESSKEY":       "minio",
+		"OBJECTSTORECONFIG_SECRETACCESSKEY": "minio-secret",
+		"CACHE_ADMIN_TOKEN":                 "admin-secret",
+		"CACHE_SIGNATURE_KEY":               "signature-secret",
+	})
+	require.Nil(t, err)
+
+	dump := config.String()
+	for _, secret := range []string{"db-secret", "redis-secret", "minio-secret", "admin-secret", "signature-secret"} {
+		assert.NotContains(t, dump, secret)
+	}
+
+	goldenPath := filepath.Join("testdata", "startup_dump.golden")
+	if *updateGolden {
+		require.Nil(t, ioutil.WriteFile(goldenPath, []byte(dump+"\n"), 0644))
+	}
+	golden, err := ioutil.ReadFile(goldenPath)
+	require.Nil(t, err)
+	assert.Equal(t, string(golden), dump+"\n")
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credentials are the secrets the cache stores authenticate with, the token admin requests
+// authenticate with, and the keys the cache fields of pods are signed with.
+type Credentials struct {
+	DBPassword    string
+	RedisPassword string
+	S3AccessKey   string
+	S3SecretKey   string
+	AdminToken    string
+	SignatureKey  string
+}
+
+// credentialFile is a file holding the secret of the flag name.
+type credentialFile struct {
+	name  string
+	path  string
+	value *string
+}
+
+func (c *Config) credentialFiles(credentials *Credentials) []credentialFile {
+	return []credentialFile{
+		{name: "db_password", path: c.DB.PasswordFile, value: &credentials.DBPassword},
+		{name: "redis_password", path: c.Redis.PasswordFile, value: &credentials.RedisPassword},
+		{name: "s3_access_key", path: c.S3.AccessKeyFile, value: &credentials.S3AccessKey},
+		{name: "s3_secret_key", path: c.S3.SecretKeyFile, value: &credentials.S3SecretKey},
+		{name: "admin_token", path: c.Listener.AdminTokenFile, value: &credentials.AdminToken},
+		{name: "cache_signature_key", path: c.Cache.SignatureKeyFile, value: &credentials.SignatureKey},
+	}
+}
+
+// Credentials returns the secrets of the configuration, read from their files when given.
+func (c *Config) Credentials() Credentials {
+	return Credentials{
+		DBPassword:    c.DB.Password,
+		RedisPassword: c.Redis.Password,
+		S3AccessKey:   c.S3.AccessKey,
+		S3SecretKey:   c.S3.SecretKey,
+		AdminToken:    c.Listener.AdminToken,
+		SignatureKey:  c.Cache.SignatureKey,
+	}
+}
+
+// readCredentialFile reads a secret, trimming the trailing newline editors and `echo` leave behind.
+func readCredentialFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// ReadCredentials reads the credential files again, e.g. after the mounted Secret rotated.
+// Secrets without file keep their value.
+func (c *Config) ReadCredentials() (Credentials, error) {
+	credentials := c.Credentials()
+	var problems []string
+	for _, file := range c.credentialFiles(&credentials) {
+		if file.path == "" {
+			continue
+		}
+		value, err := readCredentialFile(file.path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("could not read the %s file: %v", file.name, err))
+			continue
+		}
+		*file.value = value
+	}
+	if len(problems) > 0 {
+		return credentials, fmt.Errorf("invalid credential files: %s", strings.Join(problems, "; "))
+	}
+	return credentials, nil
+}
+
+// applyCredentialFiles replaces the secrets given as files with the content of the files.
+func (c *Config) applyCredentialFiles() error {
+	current := c.Credentials()
+	for _, file := range c.credentialFiles(&current) {
+		if file.path != "" && *file.value != "" {
+			logger.Warnf("Both %s and %s_file are set, using the file", file.name, file.name)
+		}
+	}
+	credentials, err := c.ReadCredentials()
+	if err != nil {
+		return err
+	}
+	c.DB.Password = credentials.DBPassword
+	c.Redis.Password = credentials.RedisPassword
+	c.S3.AccessKey = credentials.S3AccessKey
+	c.S3.SecretKey = credentials.S3SecretKey
+	c.Listener.AdminToken = credentials.AdminToken
+	c.Cache.SignatureKey = credentials.SignatureKey
+	return nil
+}
+
+// WatchCredentials reads the credential files again when one of them changed, checked every
+// FileReloadInterval, or when a signal arrives on signals, e.g. SIGHUP, until ctx is done. rotate
+// is called with the credentials when they changed. Files that cannot be read are logged and the
+// current credentials are kept.
+func (c *Config) WatchCredentials(ctx context.Context, signals <-chan os.Signal, rotate func(Credentials)) {
+	watcher := newCredentialWatcher(c, rotate)
+	if len(watcher.paths) == 0 {
+		return
+	}
+	var ticks <-chan time.Time
+	if c.FileReloadInterval > 0 {
+		ticker := time.NewTicker(c.FileReloadInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			watcher.check(false)
+		case <-signals:
+			watcher.check(true)
+		}
+	}
+}
+
+// credentialWatcher tracks the credential files of a configuration.
+type credentialWatcher struct {
+	config   *Config
+	paths    []string
+	current  Credentials
+	rotate   func(Credentials)
+	lastStat string
+}
+
+// factory function for a credentialWatcher of the credential files of the configuration
+func newCredentialWatcher(config *Config, rotate func(Credentials)) *credentialWatcher {
+	w := &credentialWatcher{config: config, current: config.Credentials(), rotate: rotate}
+	for _, file := range config.credentialFiles(&Credentials{}) {
+		if file.path != "" {
+			w.paths = append(w.paths, file.path)
+		}
+	}
+	w.lastStat = w.stat()
+	return w
+}
+
+// stat describes the size and modification time of the files. Files that cannot be stat'ed are
+// described by the error, so that they are read, and their error logged, once they change.
+func (w *credentialWatcher) stat() string {
+	var stats []string
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			stats = append(stats, err.Error())
+			continue
+		}
+		stats = append(stats, fmt.Sprintf("%d/%v", info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(stats, ",")
+}
+
+// check reads the files when they changed or when forced to, and rotates the credentials when
+// their content changed.
+func (w *credentialWatcher) check(force bool) {
+	stat := w.stat()
+	if !force && stat == w.lastStat {
+		return
+	}
+	w.lastStat = stat
+	credentials, err := w.config.ReadCredentials()
+	if err != nil {
+		logger.Errorf("Failed to read the credential files, keeping the current credentials: %v", err)
+		return
+	}
+	if credentials == w.current {
+		return
+	}
+	var rotated []string
+	previous := w.config.credentialFiles(&w.current)
+	next := w.config.credentialFiles(&credentials)
+	for i := range previous {
+		if *previous[i].value != *next[i].value {
+			rotated = append(rotated, previous[i].name)
+		}
+	}
+	logger.Infof("Rotating %s read from the credential files", strings.Join(rotated, ", "))
+	w.current = credentials
+	w.rotate(credentials)
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSecretFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadCredentialFilesTakePrecedence(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	SetLogger(logger)
+	defer SetLogger(logrus.StandardLogger())
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	config, err := load([]string{"--db_password=from-flag"}, map[string]string{
+		"DB_PASSWORD_FILE":                       writeSecretFile(t, dir, "db", "from-file\n"),
+		"REDIS_PASSWORD_FILE":                    writeSecretFile(t, dir, "redis", "redis-secret\r\n"),
+		"OBJECTSTORECONFIG_ACCESSKEY_FILE":       writeSecretFile(t, dir, "access", "minio"),
+		"OBJECTSTORECONFIG_SECRETACCESSKEY_FILE": writeSecretFile(t, dir, "secret", "minio-secret\n"),
+		"CACHE_ADMIN_TOKEN_FILE":                 writeSecretFile(t, dir, "admin", "admin-token\n"),
+		"CACHE_SIGNATURE_KEY_FILE":               writeSecretFile(t, dir, "signature", "signature-key\n"),
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, Credentials{
+		DBPassword:    "from-file",
+		RedisPassword: "redis-secret",
+		S3AccessKey:   "minio",
+		S3SecretKey:   "minio-secret",
+		AdminToken:    "admin-token",
+		SignatureKey:  "signature-key",
+	}, config.Credentials())
+	// Only the secrets given both ways are worth a warning.
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	assert.Contains(t, warnings, "Both db_password and db_password_file are set, using the file")
+	assert.NotContains(t, warnings, "Both redis_password and redis_password_file are set, using the file")
+}
+
+func TestLoadFailsOnUnreadableCredentialFiles(t *testing.T) {
+	_, err := load(nil, map[string]string{"DB_PASSWORD_FILE": "/nonexistent/password"})
+
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid credential files: could not read the db_password file")
+}
+
+func TestCredentialWatcherRotatesChangedCredentials(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	passwordFile := writeSecretFile(t, dir, "db", "first")
+	started, err := load(nil, map[string]string{"DB_PASSWORD_FILE": passwordFile})
+	require.Nil(t, err)
+	var rotated []Credentials
+	watcher := newCredentialWatcher(started, func(credentials Credentials) {
+		rotated = append(rotated, credentials)
+	})
+
+	watcher.check(false)
+	watcher.check(true)
+	assert.Empty(t, rotated, "unchanged credentials are not rotated")
+
+	writeSecretFile(t, dir, "db", "second, longer\n")
+	watcher.check(false)
+	require.Len(t, rotated, 1)
+	assert.Equal(t, "second, longer", rotated[0].DBPassword)
+
+	// A forced check, e.g. on SIGHUP, reads the file even when its size and time look unchanged.
+	writeSecretFile(t, dir, "db", "third, longer\n")
+	watcher.lastStat = watcher.stat()
+	watcher.check(true)
+	require.Len(t, rotated, 2)
+	assert.Equal(t, "third, longer", rotated[1].DBPassword)
+
+	require.Nil(t, os.Remove(passwordFile))
+	watcher.check(false)
+	assert.Len(t, rotated, 2, "credentials are kept when the file cannot be read")
+}
+
+func TestCredentialWatcherRotatesTheAdminTokens(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	// Both tokens are accepted while clients move to the new one.
+	tokenFile := writeSecretFile(t, dir, "admin", "old-token\nnew-token\n")
+	started, err := load(nil, map[string]string{"CACHE_ADMIN_TOKEN_FILE": tokenFile})
+	require.Nil(t, err)
+	assert.Equal(t, "old-token\nnew-token", started.Credentials().AdminToken)
+	var rotated []Credentials
+	watcher := newCredentialWatcher(started, func(credentials Credentials) {
+		rotated = append(rotated, credentials)
+	})
+
+	writeSecretFile(t, dir, "admin", "new-token\n")
+	watcher.check(true)
+	require.Len(t, rotated, 1)
+	assert.Equal(t, "new-token", rotated[0].AdminToken)
+}
@@ -0,0 +1,229 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileFlag names the flag of the configuration file.
+	FileFlag string = "config"
+	// DefaultFileReloadInterval is short enough for a changed ConfigMap, which the kubelet syncs
+	// within a minute, to take effect soon after.
+	DefaultFileReloadInterval = 10 * time.Second
+)
+
+// ReloadableFlags are the settings taken over from a changed configuration file while the server
+// runs. Changes of other settings only take effect on restart.
+var ReloadableFlags = []string{
+	"admission_deadline",
+	"allowed_namespaces",
+	"cache_mode",
+	"denied_namespaces",
+	"dummy_command",
+	"dummy_image",
+	"dummy_image_pull_secrets",
+	"dummy_resource_limits",
+	"dummy_resource_requests",
+	"enforce_owner",
+	"fail_policy",
+	"hit_node_selector",
+	"hit_scheduling",
+	"log_cached_outputs",
+	"log_level",
+	"log_sensitive_parameters",
+	"max_request_body_bytes",
+}
+
+// readFile reads the settings of a YAML file mapping flag names to scalar values.
+func readFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the configuration file: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.UnmarshalStrict(content, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse the configuration file %s: %v", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value := value.(type) {
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = value
+		case bool, int, int64, uint64, float64:
+			values[name] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("setting %s of the configuration file %s must be a single value", name, path)
+		}
+	}
+	return values, nil
+}
+
+// applyFile sets the flags that are neither given on the command line nor through their
+// environment variable to the values of the configuration file. Keys not naming a flag are
+// rejected, so that misspelled settings are not silently ignored.
+func (c *Config) applyFile() error {
+	values, err := readFile(c.File)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []string
+	for _, name := range names {
+		envName, registered := c.envNames[name]
+		if !registered || name == FileFlag {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", name))
+			continue
+		}
+		if _, given := c.explicit[name]; given {
+			continue
+		}
+		if _, exists := c.lookupEnv(envName); envName != "" && exists {
+			continue
+		}
+		if err := c.flags.Set(name, values[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value %q of %s: %v", values[name], name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration file %s: %s", c.File, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Reload loads the configuration again from the same command line flags, environment and file.
+func (c *Config) Reload() (*Config, error) {
+	flags := flag.NewFlagSet(c.flags.Name(), flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	args := make([]string, 0, len(c.explicit))
+	for name, value := range c.explicit {
+		args = append(args, fmt.Sprintf("--%s=%s", name, value))
+	}
+	return Load(flags, args, c.lookupEnv)
+}
+
+// WatchFile checks the configuration file every FileReloadInterval until ctx is done and calls
+// apply with the reloaded configuration when one of the ReloadableFlags changed. Changes of other
+// settings are logged and ignored, as are files that do not load.
+func (c *Config) WatchFile(ctx context.Context, apply func(*Config)) {
+	if c.File == "" || c.FileReloadInterval <= 0 {
+		return
+	}
+	watcher := newFileWatcher(c, apply)
+	ticker := time.NewTicker(c.FileReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			watcher.check()
+		}
+	}
+}
+
+// fileWatcher compares reloaded configurations with the one the server started with for the
+// settings needing a restart, and with the last applied one for the reloadable settings.
+type fileWatcher struct {
+	started  *Config
+	applied  *Config
+	apply    func(*Config)
+	lastStat string
+}
+
+// factory function for a fileWatcher of the file of the started configuration
+func newFileWatcher(started *Config, apply func(*Config)) *fileWatcher {
+	w := &fileWatcher{started: started, applied: started, apply: apply}
+	w.lastStat, _ = w.stat()
+	return w
+}
+
+func (w *fileWatcher) stat() (string, error) {
+	info, err := os.Stat(w.started.File)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%v", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// check reloads the configuration when the size or modification time of the file changed, e.g.
+// when the kubelet swaps the ConfigMap mount.
+func (w *fileWatcher) check() {
+	stat, err := w.stat()
+	if err != nil {
+		logger.Warnf("Failed to stat the configuration file, keeping the current configuration: %v", err)
+		return
+	}
+	if stat == w.lastStat {
+		return
+	}
+	// The stat is remembered even when loading fails, so that a broken file is not read again on
+	// every check.
+	w.lastStat = stat
+	reloaded, err := w.started.Reload()
+	if err != nil {
+		logger.Errorf("Failed to reload the configuration file, keeping the current configuration: %v", err)
+		return
+	}
+	reloadable := map[string]bool{}
+	for _, name := range ReloadableFlags {
+		reloadable[name] = true
+	}
+	names := make([]string, 0, len(w.started.envNames))
+	for name := range w.started.envNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var changed []string
+	for _, name := range names {
+		if reloadable[name] {
+			if reloaded.value(name) != w.applied.value(name) {
+				changed = append(changed, name)
+			}
+		} else if reloaded.value(name) != w.started.value(name) {
+			logger.Warnf("Changes of %s in the configuration file take effect on restart", name)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	logger.Infof("Reloaded %s from the configuration file", strings.Join(changed, ", "))
+	w.applied = reloaded
+	w.apply(reloaded)
+}
+
+// value returns the value of the flag as a string.
+func (c *Config) value(name string) string {
+	if f := c.flags.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes the content to config.yaml in dir and returns its path.
+func writeConfigFile(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cache-config")
+	require.Nil(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadFilePrecedence(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfigFile(t, dir, `
+redis_host: redis.kubeflow
+redis_pool_size: 20
+redis_tls_enabled: true
+admission_deadline: 3s
+admission_rate_per_namespace: 2.5
+enforce_owner: true
+fail_policy: closed
+`)
+
+	config, err := load([]string{"--config", path, "--fail_policy=open"}, map[string]string{
+		"REDIS_POOL_SIZE":     "30",
+		"CACHE_ENFORCE_OWNER": "false",
+	})
+	require.Nil(t, err)
+
+	// The file overrides the defaults.
+	assert.Equal(t, "redis.kubeflow", config.Redis.Host)
+	assert.True(t, config.Redis.TLSEnabled)
+	assert.Equal(t, 3*time.Second, config.Cache.AdmissionDeadline)
+	assert.Equal(t, 2.5, config.Cache.AdmissionRatePerNamespace)
+	// Environment variables override the file.
+	assert.Equal(t, 30, config.Redis.PoolSize)
+	assert.False(t, config.Cache.EnforceOwner)
+	// Flags override both.
+	assert.Equal(t, "open", config.Cache.FailPolicy)
+}
+
+func TestLoadFileFromEnvironment(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfigFile(t, dir, "cache_store: s3\n")
+
+	config, err := load(nil, map[string]string{"CACHE_CONFIG_FILE": path})
+
+	require.Nil(t, err)
+	assert.Equal(t, StoreS3, config.Cache.Store)
+}
+
+func TestLoadFileRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown keys",
+			content: "redis_hots: redis\nenforce_owner: true\nconfig: other.yaml\n",
+			wantErr: `unknown setting "config"; unknown setting "redis_hots"`,
+		},
+		{
+			name:    "invalid values",
+			content: "redis_pool_size: many\n",
+			wantErr: `invalid value "many" of redis_pool_size`,
+		},
+		{
+			name:    "nested values",
+			content: "redis:\n  host: redis\n",
+			wantErr: "setting redis of the configuration file",
+		},
+		{
+			name:    "duplicate keys",
+			content: "log_level: debug\nlog_level: info\n",
+			wantErr: "could not parse the configuration file",
+		},
+		{
+			name:    "invalid combinations",
+			content: "cache_store: redis\n",
+			wantErr: "cache store redis requires REDIS_HOST or REDIS_ADDRESSES to be set",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			path := writeConfigFile(t, dir, test.content)
+
+			_, err := load([]string{"--config=" + path}, nil)
+
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestLoadFileFailsWhenMissing(t *testing.T) {
+	_, err := load([]string{"--config=/nonexistent/config.yaml"}, nil)
+
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not read the configuration file")
+}
+
+func TestFileWatcherReloadsReloadableSettings(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	SetLogger(logger)
+	defer SetLogger(logrus.StandardLogger())
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfigFile(t, dir, "log_level: info\nredis_pool_size: 20\n")
+	started, err := load([]string{"--config=" + path, "--enforce_owner=true"}, map[string]string{"ADMISSION_DEADLINE": "3s"})
+	require.Nil(t, err)
+	var applied []*Config
+	watcher := newFileWatcher(started, func(config *Config) {
+		applied = append(applied, config)
+	})
+
+	watcher.check()
+	assert.Empty(t, applied, "unchanged files are not reloaded")
+
+	writeConfigFile(t, dir, "log_level: debug\nredis_pool_size: 50\nenforce_owner: false\nadmission_deadline: 1s\n")
+	watcher.check()
+
+	require.Len(t, applied, 1)
+	assert.Equal(t, "debug", applied[0].Observability.LogLevel)
+	// Flags and environment variables keep overriding the file.
+	assert.True(t, applied[0].Cache.EnforceOwner)
+	assert.Equal(t, 3*time.Second, applied[0].Cache.AdmissionDeadline)
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{
+		"Changes of redis_pool_size in the configuration file take effect on restart",
+		"Reloaded log_level from the configuration file",
+	}, messages)
+
+	writeConfigFile(t, dir, "log_level: debug\nredis_pool_size: 100\n")
+	watcher.check()
+	assert.Len(t, applied, 1, "changes of settings needing a restart only are not applied")
+
+	writeConfigFile(t, dir, "log_level: loud\n")
+	watcher.check()
+	assert.Len(t, applied, 1)
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, "keeping the current configuration")
+}
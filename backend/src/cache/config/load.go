@@ -0,0 +1,341 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+)
+
+// LookupEnvFunc reads an environment variable, like os.LookupEnv.
+type LookupEnvFunc func(name string) (string, bool)
+
+// loader registers the options of the configuration as flags whose defaults are read from the
+// environment. Environment variables that cannot be parsed are collected as errors.
+type loader struct {
+	flags     *flag.FlagSet
+	lookupEnv LookupEnvFunc
+	// envNames maps the name of every registered flag to its environment variable, which is empty
+	// for options only read from flags.
+	envNames map[string]string
+	secrets  map[string]bool
+	errs     []string
+}
+
+// env registers the environment variable of the flag and returns its value, or false when it is
+// unset or the option has no environment variable.
+func (l *loader) env(flagName string, envName string) (string, bool) {
+	l.envNames[flagName] = envName
+	if envName == "" {
+		return "", false
+	}
+	return l.lookupEnv(envName)
+}
+
+func (l *loader) invalidEnv(name string, value string, err error) {
+	l.errs = append(l.errs, fmt.Sprintf("invalid value %q of %s: %v", value, name, err))
+}
+
+func (l *loader) stringVar(p *string, flagName string, envName string, defaultValue string, usage string) {
+	if value, exists := l.env(flagName, envName); exists {
+		defaultValue = value
+	}
+	l.flags.StringVar(p, flagName, defaultValue, usage)
+}
+
+// secretVar registers an option whose value is redacted by Config.String.
+func (l *loader) secretVar(p *string, flagName string, envName string, usage string) {
+	l.stringVar(p, flagName, envName, "", usage)
+	l.secrets[flagName] = true
+}
+
+func (l *loader) intVar(p *int, flagName string, envName string, defaultValue int, usage string) {
+	if value, exists := l.env(flagName, envName); exists {
+		if parsed, err := strconv.Atoi(value); err != nil {
+			l.invalidEnv(envName, value, err)
+		} else {
+			defaultValue = parsed
+		}
+	}
+	l.flags.IntVar(p, flagName, defaultValue, usage)
+}
+
+func (l *loader) float64Var(p *float64, flagName string, envName string, defaultValue float64, usage string) {
+	if value, exists := l.env(flagName, envName); exists {
+		if parsed, err := strconv.ParseFloat(value, 64); err != nil {
+			l.invalidEnv(envName, value, err)
+		} else {
+			defaultValue = parsed
+		}
+	}
+	l.flags.Float64Var(p, flagName, defaultValue, usage)
+}
+
+func (l *loader) boolVar(p *bool, flagName string, envName string, defaultValue bool, usage string) {
+	if value, exists := l.env(flagName, envName); exists {
+		if parsed, err := strconv.ParseBool(value); err != nil {
+			l.invalidEnv(envName, value, err)
+		} else {
+			defaultValue = parsed
+		}
+	}
+	l.flags.BoolVar(p, flagName, defaultValue, usage)
+}
+
+func (l *loader) durationVar(p *time.Duration, flagName string, envName string, defaultValue time.Duration, usage string) {
+	if value, exists := l.env(flagName, envName); exists {
+		if parsed, err := time.ParseDuration(value); err != nil {
+			l.invalidEnv(envName, value, err)
+		} else {
+			defaultValue = parsed
+		}
+	}
+	l.flags.DurationVar(p, flagName, defaultValue, usage)
+}
+
+// envAliases maps the environment variables renamed since to their former names.
+var envAliases = map[string]string{"CACHE_STORE_BACKEND": "CACHE_STORE"}
+
+// withEnvAliases reads the former name of a renamed environment variable when it is unset, so that
+// deployments setting the former name keep working.
+func withEnvAliases(lookupEnv LookupEnvFunc) LookupEnvFunc {
+	return func(name string) (string, bool) {
+		if value, exists := lookupEnv(name); exists {
+			return value, true
+		}
+		if alias, ok := envAliases[name]; ok {
+			return lookupEnv(alias)
+		}
+		return "", false
+	}
+}
+
+// Load registers the options of the configuration on flags, parses args with the environment
+// variables read by lookupEnv as defaults, applies the configuration file if one is given and
+// validates the result. Flags override environment variables, which override the file.
+func Load(flags *flag.FlagSet, args []string, lookupEnv LookupEnvFunc) (*Config, error) {
+	lookupEnv = withEnvAliases(lookupEnv)
+	c := &Config{flags: flags, lookupEnv: lookupEnv, explicit: map[string]string{}}
+	l := &loader{flags: flags, lookupEnv: lookupEnv, envNames: map[string]string{}, secrets: map[string]bool{}}
+	c.envNames = l.envNames
+	c.secrets = l.secrets
+	c.register(l)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if len(l.errs) > 0 {
+		return nil, fmt.Errorf("invalid environment: %s", strings.Join(l.errs, "; "))
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if _, registered := c.envNames[f.Name]; registered {
+			c.explicit[f.Name] = f.Value.String()
+		}
+	})
+	if c.File != "" {
+		if err := c.applyFile(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyCredentialFiles(); err != nil {
+		return nil, err
+	}
+	quotas, err := c.ReadNamespaceQuotas()
+	if err != nil {
+		return nil, err
+	}
+	c.Cache.NamespaceQuotas = quotas
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) register(l *loader) {
+	l.stringVar(&c.File, FileFlag, "CACHE_CONFIG_FILE", "", "YAML file with settings keyed by flag name. Environment variables and flags override the file.")
+	l.durationVar(&c.FileReloadInterval, "config_reload_interval", "CACHE_CONFIG_RELOAD_INTERVAL", DefaultFileReloadInterval, "Interval at which the configuration file and the credential files are checked for changes. 0 disables reloading.")
+
+	// The database options predate the environment variables and are only read from flags.
+	l.stringVar(&c.DB.Driver, "db_driver", "", DriverMySQL, "Database driver name, mysql is the default value")
+	l.stringVar(&c.DB.Host, "db_host", "", "mysql", "Database host name.")
+	l.stringVar(&c.DB.Port, "db_port", "", "3306", "Database port number.")
+	l.stringVar(&c.DB.Name, "db_name", "", "cachedb", "Database name.")
+	l.stringVar(&c.DB.User, "db_user", "", "root", "Database user name.")
+	l.secretVar(&c.DB.Password, "db_password", "", "Database password.")
+	l.stringVar(&c.DB.PasswordFile, "db_password_file", "DB_PASSWORD_FILE", "", "File holding the database password, e.g. from a mounted Secret. Takes precedence over the password.")
+	l.stringVar(&c.DB.GroupConcatMaxLen, "db_group_concat_max_len", "", "4194304", "Database group concat max length.")
+	l.intVar(&c.DB.MaxOpenConns, "db_max_open_conns", "DB_MAX_OPEN_CONNS", client.DefaultSQLMaxOpenConns, "Maximum number of database connections per replica, in use or idle. Queries wait for a connection beyond it. 0 means no limit.")
+	l.intVar(&c.DB.MaxIdleConns, "db_max_idle_conns", "DB_MAX_IDLE_CONNS", client.DefaultSQLMaxIdleConns, "Number of idle database connections kept open per replica.")
+	l.durationVar(&c.DB.ConnMaxLifetime, "db_conn_max_lifetime", "DB_CONN_MAX_LIFETIME", client.DefaultSQLConnMaxLifetime, "Time after which a database connection is closed and replaced. 0 keeps connections open.")
+	l.durationVar(&c.DB.ConnMaxIdleTime, "db_conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", client.DefaultSQLConnMaxIdleTime, "Time after which an idle database connection is closed. 0 keeps idle connections open.")
+	l.durationVar(&c.DB.DialTimeout, "db_dial_timeout", "DB_DIAL_TIMEOUT", 5*time.Second, "Time limit for connecting to the database.")
+	l.durationVar(&c.DB.ReadTimeout, "db_read_timeout", "DB_READ_TIMEOUT", 30*time.Second, "Time limit for reading a database reply.")
+	l.durationVar(&c.DB.WriteTimeout, "db_write_timeout", "DB_WRITE_TIMEOUT", 30*time.Second, "Time limit for writing a database query.")
+	l.stringVar(&c.NamespaceToWatch, "namespace_to_watch", "", "kubeflow", "Namespace to watch.")
+	l.durationVar(&c.Watcher.ResyncPeriod, "watcher_resync_period", "WATCHER_RESYNC_PERIOD", server.DefaultPodResyncPeriod, "Period at which the watched pods are all handled again, retrying those whose outputs could not be recorded. 0 disables resyncs.")
+	l.durationVar(&c.Watcher.CatchUpLookback, "watcher_catch_up_lookback", "WATCHER_CATCH_UP_LOOKBACK", server.DefaultCatchUpLookback, "Pods that completed longer ago, e.g. while the watcher was down, are not recorded. 0 records them all.")
+	l.float64Var(&c.Watcher.PatchQPS, "watcher_patch_qps", "WATCHER_PATCH_QPS", server.DefaultPatchQPS, "Recorded pods labeled with their cache ID per second. 0 disables rate limiting.")
+	l.intVar(&c.Watcher.PatchBurst, "watcher_patch_burst", "WATCHER_PATCH_BURST", server.DefaultPatchBurst, "Recorded pods labeled with their cache ID in a burst above the rate.")
+	l.intVar(&c.Watcher.WriteWorkers, "watcher_write_workers", "WATCHER_WRITE_WORKERS", server.DefaultWriteWorkers, "Cache entries of recorded pods written at once.")
+	l.float64Var(&c.Watcher.WriteQPS, "watcher_write_qps", "WATCHER_WRITE_QPS", server.DefaultWriteQPS, "Cache entries of recorded pods written per second. 0 disables rate limiting.")
+	l.intVar(&c.Watcher.WriteBurst, "watcher_write_burst", "WATCHER_WRITE_BURST", server.DefaultWriteBurst, "Cache entries of recorded pods written in a burst above the rate.")
+	l.intVar(&c.Watcher.WriteMaxRetries, "watcher_write_max_retries", "WATCHER_WRITE_MAX_RETRIES", server.DefaultWriteMaxRetries, "Retries of a failed cache entry write, after which the entry is dropped and its cache key logged for backfilling.")
+	l.intVar(&c.Watcher.WriteQueueSize, "watcher_write_queue_size", "WATCHER_WRITE_QUEUE_SIZE", server.DefaultWriteQueueSize, "Cache entries waiting to be written, e.g. while the store is down, beyond which completed pods are left for the next resync.")
+	l.boolVar(&c.Watcher.BackfillOnStart, "backfill_on_start", "CACHE_BACKFILL_ON_START", false, "Record the succeeded pods of the watched namespaces not labeled with a cache entry once the watchers start, e.g. those completed before the cache was installed.")
+	l.durationVar(&c.Watcher.BackfillMaxAge, "backfill_max_age", "CACHE_BACKFILL_MAX_AGE", server.DefaultBackfillMaxAge, "Pods that completed longer ago are not backfilled. 0 backfills them all.")
+	l.stringVar(&c.Watcher.Namespaces, "watcher_namespaces", "CACHE_WATCHER_NAMESPACES", "", "Comma separated namespaces, or label selector on namespaces, whose pods are recorded. namespace_to_watch is watched when empty.")
+	l.boolVar(&c.Watcher.LeaderElection, "leader_election", "LEADER_ELECTION", false, "Run the watchers only on the replica holding the lease, the other replicas standing by.")
+	l.stringVar(&c.Watcher.LeaseName, "leader_election_lease_name", "LEADER_ELECTION_LEASE_NAME", server.DefaultLeaseName, "Name of the lease held by the replica running the watchers.")
+	l.stringVar(&c.Watcher.LeaseIdentity, "leader_election_identity", "LEADER_ELECTION_IDENTITY", "", "Identity the replica holds the lease as, e.g. the name of its pod from the downward API. The hostname when empty.")
+	l.durationVar(&c.Watcher.LeaseDuration, "leader_election_lease_duration", "LEADER_ELECTION_LEASE_DURATION", server.DefaultLeaseDuration, "Time the other replicas wait after the last renewal of the lease before taking it over.")
+	l.durationVar(&c.Watcher.RenewDeadline, "leader_election_renew_deadline", "LEADER_ELECTION_RENEW_DEADLINE", server.DefaultRenewDeadline, "Time the replica holding the lease tries to renew it before it stops running the watchers.")
+	l.durationVar(&c.Watcher.RetryPeriod, "leader_election_retry_period", "LEADER_ELECTION_RETRY_PERIOD", server.DefaultRetryPeriod, "Time between the attempts of the replicas to acquire or renew the lease.")
+	l.stringVar(&c.Watcher.LeaseNamespace, "leader_election_lease_namespace", "LEADER_ELECTION_LEASE_NAMESPACE", "", "Namespace of the lease held by the replica running the watchers. namespace_to_watch when empty.")
+	l.stringVar(&c.Watcher.ArgoPersistenceDBName, "argo_persistence_db_name", "ARGO_PERSISTENCE_DB_NAME", "", "Database, on the server of db_host, where Argo offloads the node statuses of workflows. Offloaded outputs are not resolved when empty.")
+	l.stringVar(&c.Watcher.ArgoPersistenceTable, "argo_persistence_table", "ARGO_PERSISTENCE_TABLE", "argo_workflows", "Table of the node statuses offloaded by Argo.")
+	l.stringVar(&c.Watcher.ArgoPersistenceClusterName, "argo_persistence_cluster_name", "ARGO_PERSISTENCE_CLUSTER_NAME", "default", "Cluster name Argo offloads the node statuses under.")
+	l.stringVar(&c.Watcher.MLMDAddress, "mlmd_address", "CACHE_MLMD_ADDRESS", "", "host:port of the ML Metadata gRPC server, e.g. metadata-grpc-service.kubeflow:8080, the pods served from cache are recorded in as executions and the executions of the TFX pods served from cache are restored in. They are not recorded, and TFX pods not served from cache, when empty.")
+	l.intVar(&c.Watcher.MLMDMaxRetries, "mlmd_max_retries", "CACHE_MLMD_MAX_RETRIES", server.DefaultMLMDMaxRetries, "Retries of a pod served from cache failing to be recorded in ML Metadata, after which it is dropped.")
+	l.durationVar(&c.Watcher.ScrubInterval, "scrub_interval", "CACHE_SCRUB_INTERVAL", 0, "Time between the passes of the artifact scrubber, which deletes the cache entries whose artifacts no longer exist in the object store. 0 disables it.")
+	l.durationVar(&c.Watcher.ScrubMinAge, "scrub_min_age", "CACHE_SCRUB_MIN_AGE", server.DefaultScrubMinAge, "Cache entries created more recently are not scrubbed.")
+	l.intVar(&c.Watcher.ScrubConcurrency, "scrub_concurrency", "CACHE_SCRUB_CONCURRENCY", server.DefaultScrubConcurrency, "Cache entries whose artifacts are checked at once by the artifact scrubber.")
+	l.float64Var(&c.Watcher.ScrubQPS, "scrub_qps", "CACHE_SCRUB_QPS", server.DefaultScrubQPS, "Object store requests per second of the artifact scrubber. 0 disables rate limiting.")
+	l.intVar(&c.Watcher.ScrubBurst, "scrub_burst", "CACHE_SCRUB_BURST", server.DefaultScrubBurst, "Object store requests the artifact scrubber may burst to.")
+
+	l.stringVar(&c.Cache.Store, "cache_store", "CACHE_STORE_BACKEND", StoreMySQL, "Execution cache store backend, one of mysql, s3, redis or memory.")
+	l.stringVar(&c.S3.Host, "s3_host", "MINIO_SERVICE_SERVICE_HOST", "minio-service", "S3-compatible object store host name.")
+	l.stringVar(&c.S3.Port, "s3_port", "MINIO_SERVICE_SERVICE_PORT", "9000", "S3-compatible object store port number.")
+	l.stringVar(&c.S3.Region, "s3_region", "MINIO_SERVICE_REGION", "", "S3-compatible object store region.")
+	l.boolVar(&c.S3.Secure, "s3_secure", "MINIO_SERVICE_SECURE", false, "Whether to use TLS for the object store.")
+	l.secretVar(&c.S3.AccessKey, "s3_access_key", "OBJECTSTORECONFIG_ACCESSKEY", "S3-compatible object store access key.")
+	l.stringVar(&c.S3.AccessKeyFile, "s3_access_key_file", "OBJECTSTORECONFIG_ACCESSKEY_FILE", "", "File holding the object store access key. Takes precedence over the access key.")
+	l.secretVar(&c.S3.SecretKey, "s3_secret_key", "OBJECTSTORECONFIG_SECRETACCESSKEY", "S3-compatible object store secret key.")
+	l.stringVar(&c.S3.SecretKeyFile, "s3_secret_key_file", "OBJECTSTORECONFIG_SECRETACCESSKEY_FILE", "", "File holding the object store secret key. Takes precedence over the secret key.")
+	l.stringVar(&c.S3.BucketName, "s3_bucket_name", "OBJECTSTORECONFIG_BUCKETNAME", "mlpipeline", "Bucket holding the execution cache objects.")
+	l.stringVar(&c.S3.Prefix, "s3_prefix", "CACHE_S3_PREFIX", "cache", "Object name prefix of the execution cache objects.")
+	l.stringVar(&c.Cache.PartitionBy, "partition_by", "CACHE_PARTITION_BY", storage.PartitionByNone, "Partitioning of the execution cache table, one of none or month.")
+	l.intVar(&c.Cache.PartitionLookback, "partition_lookback", "CACHE_PARTITION_LOOKBACK", 3, "Number of most recent partitions searched on lookup.")
+	l.intVar(&c.Cache.PartitionRetention, "partition_retention", "CACHE_PARTITION_RETENTION", 0, "Number of monthly partitions to keep, older ones are dropped. 0 keeps all partitions.")
+
+	l.stringVar(&c.Redis.Mode, "redis_mode", "REDIS_MODE", client.RedisModeStandalone, "Redis deployment, one of standalone, sentinel or cluster.")
+	l.stringVar(&c.Redis.Host, "redis_host", "REDIS_HOST", "", "Redis host name. Redis is not used when neither host nor addresses are set.")
+	l.stringVar(&c.Redis.Port, "redis_port", "REDIS_PORT", "6379", "Redis port number.")
+	l.stringVar(&c.Redis.MasterName, "redis_sentinel_master", "REDIS_SENTINEL_MASTER", "", "Name of the Sentinel managed master.")
+	l.stringVar(&c.Redis.Addresses, "redis_addresses", "REDIS_ADDRESSES", "", "Comma separated sentinel addresses in sentinel mode or seed node addresses in cluster mode.")
+	l.stringVar(&c.Redis.KeyPrefix, "redis_key_prefix", "CACHE_REDIS_KEY_PREFIX", storage.DefaultRedisKeyPrefix, "Key prefix of the execution cache entries in Redis.")
+	l.secretVar(&c.Redis.Password, "redis_password", "REDIS_PASSWORD", "Redis password.")
+	l.stringVar(&c.Redis.PasswordFile, "redis_password_file", "REDIS_PASSWORD_FILE", "", "File holding the Redis password. Takes precedence over the password.")
+	l.intVar(&c.Redis.DB, "redis_db", "REDIS_DB", 0, "Redis database index.")
+	l.boolVar(&c.Redis.TLSEnabled, "redis_tls_enabled", "REDIS_TLS_ENABLED", false, "Whether to connect to Redis over TLS.")
+	l.stringVar(&c.Redis.TLSCACertPath, "redis_tls_ca_cert_path", "REDIS_TLS_CA_CERT_PATH", "", "PEM file with the CA certificates verifying Redis. The system roots are used when empty.")
+	l.boolVar(&c.Redis.TLSInsecureSkipVerify, "redis_tls_insecure_skip_verify", "REDIS_TLS_INSECURE_SKIP_VERIFY", false, "Skip verification of the Redis server certificate.")
+	l.durationVar(&c.Redis.OperationTimeout, "redis_operation_timeout", "REDIS_OPERATION_TIMEOUT", storage.DefaultRedisOperationTimeout, "Time limit of a single Redis call. Timed out lookups count as cache misses.")
+	l.durationVar(&c.Redis.DialTimeout, "redis_dial_timeout", "REDIS_DIAL_TIMEOUT", time.Second, "Time limit for connecting to Redis.")
+	l.durationVar(&c.Redis.ReadTimeout, "redis_read_timeout", "REDIS_READ_TIMEOUT", 500*time.Millisecond, "Time limit for reading a Redis reply.")
+	l.durationVar(&c.Redis.WriteTimeout, "redis_write_timeout", "REDIS_WRITE_TIMEOUT", 500*time.Millisecond, "Time limit for writing a Redis command.")
+	l.durationVar(&c.Redis.PoolTimeout, "redis_pool_timeout", "REDIS_POOL_TIMEOUT", time.Second, "Time limit for waiting on a pooled Redis connection.")
+	l.intVar(&c.Redis.PoolSize, "redis_pool_size", "REDIS_POOL_SIZE", 100, "Maximum number of connections per Redis node.")
+	l.intVar(&c.Redis.MinIdleConns, "redis_min_idle_conns", "REDIS_MIN_IDLE_CONNS", 10, "Number of idle Redis connections kept open for bursts of admissions.")
+	l.intVar(&c.Redis.MaxRetries, "redis_max_retries", "REDIS_MAX_RETRIES", 0, "Retries of a Redis command failing on a network error, e.g. a pooled connection closed by the server. Retries stay within the operation timeout.")
+	l.intVar(&c.Redis.CircuitFailureThreshold, "redis_circuit_failure_threshold", "REDIS_CIRCUIT_FAILURE_THRESHOLD", storage.DefaultRedisCircuitFailureThreshold, "Consecutive Redis failures after which the write-through store serves from the database only. 0 disables the circuit breaker.")
+	l.durationVar(&c.Redis.CircuitCoolDown, "redis_circuit_cool_down", "REDIS_CIRCUIT_COOL_DOWN", storage.DefaultRedisCircuitCoolDown, "Time Redis is skipped for before probing it again.")
+
+	l.durationVar(&c.Listener.ShutdownGracePeriod, "shutdown_grace_period", "SHUTDOWN_GRACE_PERIOD", server.DefaultShutdownGracePeriod, "Time in-flight admissions are given to complete on SIGTERM or SIGINT. Keep it below the pod's termination grace period.")
+	l.durationVar(&c.Listener.ShutdownDrainDelay, "shutdown_drain_delay", "SHUTDOWN_DRAIN_DELAY", server.DefaultShutdownDrainDelay, "Time the webhook keeps serving on SIGTERM or SIGINT while /readyz reports it as not ready, before it stops accepting connections. Another signal cuts it short.")
+	l.stringVar(&c.Listener.WebhookPort, "webhook_port", "WEBHOOK_PORT", DefaultWebhookPort, "Port of the admission webhook.")
+	l.boolVar(&c.TLS.Enabled, "tls_enabled", "TLS_ENABLED", true, "Serve the webhook over TLS with the certificate in tls_dir. Disable only when a service mesh or local proxy terminates TLS.")
+	l.stringVar(&c.TLS.Dir, "tls_dir", "TLS_DIR", DefaultTLSDir, "Directory holding the serving certificate and key of the webhook.")
+	l.stringVar(&c.TLS.CertFile, "tls_cert_file", "TLS_CERT_FILE", DefaultTLSCertFile, "PEM file of the serving certificate, relative to tls_dir.")
+	l.stringVar(&c.TLS.KeyFile, "tls_key_file", "TLS_KEY_FILE", DefaultTLSKeyFile, "PEM file of the serving key, relative to tls_dir.")
+	l.stringVar(&c.TLS.CAFile, "tls_ca_file", "TLS_CA_FILE", "", "PEM file of the CA of the serving certificate, relative to tls_dir, which the self test verifies the webhook against. The serving certificate itself is trusted when empty.")
+	l.stringVar(&c.TLS.ClientCAFile, "webhook_client_ca_file", "WEBHOOK_CLIENT_CA_FILE", "", "PEM file with the CAs of the client certificates the webhook requires, e.g. of the kube-apiserver. Client certificates are not requested when empty.")
+	l.boolVar(&c.TLS.AllowPlainHTTPOnDefaultPort, "allow_plain_http_on_default_port", "ALLOW_PLAIN_HTTP_ON_DEFAULT_PORT", false, "Allow serving the webhook without TLS on the default port "+DefaultWebhookPort+".")
+	l.boolVar(&c.TLS.SelfSigned, "generate_self_signed_cert", "GENERATE_SELF_SIGNED_CERT", false, "Generate an ephemeral CA and serving certificate at startup instead of reading tls_dir. For local development only.")
+	l.stringVar(&c.TLS.SelfSignedDNSNames, "self_signed_cert_dns_names", "SELF_SIGNED_CERT_DNS_NAMES", "", "Comma separated DNS names of the generated certificate. Defaults to the cache-server Service in the watched namespace.")
+	l.stringVar(&c.TLS.MutatingWebhookConfiguration, "mutating_webhook_configuration", "MUTATING_WEBHOOK_CONFIGURATION", "", "MutatingWebhookConfiguration whose caBundle is patched with the generated CA. Not patched when empty.")
+	l.stringVar(&c.Listener.HealthPort, "health_port", "HEALTH_PORT", DefaultHealthPort, "Plain HTTP port serving /healthz, /readyz and /metrics.")
+	l.stringVar(&c.Listener.GRPCPort, "grpc_port", "CACHE_GRPC_PORT", "", "Port serving the admin API over gRPC with the admin tokens. Not served when empty.")
+	l.durationVar(&c.Listener.HealthDBTimeout, "health_db_timeout", "HEALTH_DB_TIMEOUT", time.Second, "Time limit of the database readiness check.")
+	l.durationVar(&c.Listener.HealthRedisTimeout, "health_redis_timeout", "HEALTH_REDIS_TIMEOUT", 500*time.Millisecond, "Time limit of the Redis readiness check.")
+	l.durationVar(&c.Listener.SelfTestTimeout, "self_test_timeout", "SELF_TEST_TIMEOUT", server.DefaultSelfTestTimeout, "Time limit of each check of the self test.")
+	l.durationVar(&c.Listener.StatsCacheInterval, "stats_cache_interval", "CACHE_STATS_CACHE_INTERVAL", server.DefaultStatsCacheInterval, "Time the store side of the cache stats is reused before the store is queried again. 0 queries it on every request.")
+	l.secretVar(&c.Listener.AdminToken, "admin_token", "CACHE_ADMIN_TOKEN", "Bearer tokens of the /v1/ APIs on the health port, one per line. They are disabled without token.")
+	l.stringVar(&c.Listener.AdminTokenFile, "admin_token_file", "CACHE_ADMIN_TOKEN_FILE", "", "File holding the admin API tokens, one per line. Takes precedence over the token.")
+
+	l.intVar(&c.Observability.MaxTemplateLabels, "max_template_labels", "CACHE_METRICS_MAX_TEMPLATES", server.DefaultMaxTemplateLabels, "Number of Argo templates given their own label in the per-template cache metrics. Further templates are counted as other.")
+	l.boolVar(&c.Observability.Pprof.Enabled, "enable_pprof", "ENABLE_PPROF", false, "Serve net/http/pprof profiles on the pprof address.")
+	l.stringVar(&c.Observability.PprofAddress, "pprof_address", "PPROF_ADDRESS", server.DefaultPprofAddress, "Address of the plain HTTP pprof listener, which also serves the recent decisions. The default is only reachable through kubectl port-forward.")
+	l.intVar(&c.Observability.DecisionBufferSize, "decision_buffer_size", "DEBUG_DECISION_BUFFER_SIZE", server.DefaultDecisionBufferSize, "Number of recent cache decisions served under /debug/decisions on the pprof address. 0 disables the endpoint.")
+	l.intVar(&c.Observability.Pprof.MutexProfileFraction, "pprof_mutex_profile_fraction", "PPROF_MUTEX_PROFILE_FRACTION", 0, "Report 1 in that many mutex contention events when pprof is enabled. 0 disables the mutex profile.")
+	l.intVar(&c.Observability.Pprof.BlockProfileRate, "pprof_block_profile_rate", "PPROF_BLOCK_PROFILE_RATE", 0, "Sample one blocking event per that many nanoseconds blocked when pprof is enabled. 0 disables the block profile.")
+	l.stringVar(&c.Observability.LogLevel, "log_level", "LOG_LEVEL", logging.DefaultLevel, "Minimum level of logged entries, one of trace, debug, info, warn or error.")
+	l.stringVar(&c.Observability.LogFormat, "log_format", "LOG_FORMAT", logging.DefaultFormat, "Encoding of log entries, json or console.")
+	l.boolVar(&c.Observability.LogCachedOutputs, "log_cached_outputs", "LOG_CACHED_OUTPUTS", false, "Log the outputs of cache hits at debug level, with the values of sensitive parameters redacted. Otherwise only their size and shape are logged.")
+	l.stringVar(&c.Observability.SensitiveParameterPatterns, "log_sensitive_parameters", "LOG_SENSITIVE_PARAMETERS", server.DefaultSensitiveParameterPatterns, "Comma separated regular expressions matching the names of output parameters whose values are redacted from logged outputs, case insensitively.")
+	l.stringVar(&c.Observability.OTLPEndpoint, "otlp_endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "", "OTLP/HTTP collector receiving the spans of admissions, e.g. http://otel-collector:4318. Tracing is disabled when empty.")
+
+	l.stringVar(&c.Audit.Sink, "audit_sink", "AUDIT_SINK", "", "Where the cache decision of every admission is recorded: stdout, file or db. Auditing is disabled when empty.")
+	l.stringVar(&c.Audit.File, "audit_file", "AUDIT_FILE", "", "File the audit events are appended to with the file sink.")
+	l.intVar(&c.Audit.FileMaxBytes, "audit_file_max_bytes", "AUDIT_FILE_MAX_BYTES", server.DefaultAuditFileMaxBytes, "Size at which the audit file is rotated.")
+	l.intVar(&c.Audit.FileMaxBackups, "audit_file_max_backups", "AUDIT_FILE_MAX_BACKUPS", server.DefaultAuditFileMaxBackups, "Number of rotated audit files kept.")
+	l.intVar(&c.Audit.BufferSize, "audit_buffer_size", "AUDIT_BUFFER_SIZE", server.DefaultAuditBufferSize, "Audit events waiting for a slow sink before further events are dropped.")
+	l.intVar(&c.Cache.MaxRequestBodyBytes, "max_request_body_bytes", "MAX_REQUEST_BODY_BYTES", int(server.DefaultMaxRequestBodyBytes), "Largest AdmissionReview body accepted by the webhook. Larger bodies are rejected with 413.")
+	l.durationVar(&c.Cache.AdmissionDeadline, "admission_deadline", "ADMISSION_DEADLINE", server.DefaultAdmissionDeadline, "Time budget of the cache lookup of a pod. Pods whose lookup takes longer are admitted uncached.")
+	l.stringVar(&c.Cache.Mode, "cache_mode", "CACHE_MODE", server.CacheModeActive, "Whether the pods found in cache are served from it, active, or admitted unchanged and only counted as shadow hits, shadow, to evaluate the hit rate before serving pods from cache.")
+	l.stringVar(&c.Cache.FailPolicy, "fail_policy", "CACHE_WEBHOOK_FAIL_POLICY", server.FailPolicyOpen, "What happens to cache enabled pods the webhook fails on, open admits them uncached and closed rejects them.")
+	l.intVar(&c.Cache.LookupCircuitFailureThreshold, "lookup_circuit_failure_threshold", "LOOKUP_CIRCUIT_FAILURE_THRESHOLD", server.DefaultLookupCircuitFailureThreshold, "Consecutive failed cache lookups after which pods are admitted without lookup. 0 disables the circuit breaker.")
+	l.durationVar(&c.Cache.LookupCircuitCoolDown, "lookup_circuit_cool_down", "LOOKUP_CIRCUIT_COOL_DOWN", server.DefaultLookupCircuitCoolDown, "Time cache lookups are skipped for before probing the store again.")
+	l.durationVar(&c.Cache.LookupMissTTL, "lookup_miss_ttl", "CACHE_LOOKUP_MISS_TTL", server.DefaultLookupMissTTL, "Time a cache miss answers the same lookups without querying the store. 0 disables remembering misses.")
+	l.intVar(&c.Cache.MaxConcurrentAdmissions, "max_concurrent_admissions", "MAX_CONCURRENT_ADMISSIONS", 0, "Admissions handled at once, further ones wait for a slot. 0 means no limit.")
+	l.durationVar(&c.Cache.AdmissionQueueTimeout, "admission_queue_timeout", "ADMISSION_QUEUE_TIMEOUT", server.DefaultAdmissionQueueTimeout, "Time an admission waits for a slot before it is allowed uncached without lookup.")
+	l.float64Var(&c.Cache.AdmissionRatePerNamespace, "admission_rate_per_namespace", "ADMISSION_RATE_PER_NAMESPACE", 0, "Admissions per second looked up for each namespace, further ones are allowed uncached without lookup. 0 disables rate limiting.")
+	l.intVar(&c.Cache.AdmissionBurstPerNamespace, "admission_burst_per_namespace", "ADMISSION_BURST_PER_NAMESPACE", server.DefaultAdmissionBurstPerSource, "Admissions of a namespace looked up in a burst above its rate.")
+	l.secretVar(&c.Cache.SignatureKey, "cache_signature_key", "CACHE_SIGNATURE_KEY", "Keys the cache ID and execution key of pods are signed with, one per line, the first one signing. Pods are neither signed nor validated without key.")
+	l.stringVar(&c.Cache.SignatureKeyFile, "cache_signature_key_file", "CACHE_SIGNATURE_KEY_FILE", "", "File holding the cache signature keys, one per line, e.g. from a mounted Secret. Takes precedence over the keys.")
+	l.stringVar(&c.Cache.ValidationMode, "validation_mode", "CACHE_VALIDATION_MODE", server.ValidationModeWarn, "What happens to pods with cache fields the webhook did not issue, warn admits them with a warning and enforce rejects them.")
+	l.stringVar(&c.Cache.ClusterID, "cluster_id", "CACHE_CLUSTER_ID", "", "Identifies the cluster among those sharing the cache store, e.g. its name. Recorded on the cache entries by the watcher.")
+	l.stringVar(&c.Cache.CrossCluster, "cross_cluster", "CACHE_CROSS_CLUSTER", server.CrossClusterShared, "Which cache entries recorded in other clusters are reused: shared reuses them all, local none and prefer-local only when the cluster has none of its own.")
+	l.boolVar(&c.Cache.VerifyRemoteArtifacts, "verify_remote_artifacts", "CACHE_VERIFY_REMOTE_ARTIFACTS", false, "Check that the artifacts of cache entries recorded in other clusters still exist in the object store before reusing them.")
+	l.stringVar(&c.Cache.KeyVersion, "cache_key_version", "CACHE_KEY_VERSION", server.CacheKeyVersionV1, "Version of the cache key strategy of the pods without cache_key_version annotation. Entries are only reused by pods keyed by the strategy that recorded them.")
+	l.stringVar(&c.Cache.KeyIgnoredFields, "cache_key_ignored_fields", "CACHE_KEY_IGNORED_FIELDS", "", "Comma separated paths to the fields of the templates removed before their cache key is generated, e.g. container.env[name=RUN_ID] or sidecars[*].env[name=POD_NAME].")
+	l.durationVar(&c.Cache.DefaultTTL, "default_ttl", "CACHE_DEFAULT_TTL", 0, "Time-to-live of the entries of the pods without cache_ttl annotation, after which lookups miss them and the store purges them. 0 keeps their entries forever.")
+	l.stringVar(&c.Cache.AllowedNamespaces, "allowed_namespaces", "CACHE_ALLOWED_NAMESPACES", "", "Comma separated namespaces whose pods are served from cache. Pods of all namespaces are when empty.")
+	l.stringVar(&c.Cache.DeniedNamespaces, "denied_namespaces", "CACHE_DENIED_NAMESPACES", "", "Comma separated namespaces whose pods are never served from cache, even when allowed.")
+	l.boolVar(&c.Cache.ImageDigests, "image_digests", "CACHE_IMAGE_DIGESTS", false, "Fold the digests of the images of the templates, resolved from their registry, into the cache keys, so that re-pushed tags are not served the entries of the images they replaced.")
+	l.durationVar(&c.Cache.ImageDigestTTL, "image_digest_ttl", "CACHE_IMAGE_DIGEST_TTL", server.DefaultImageDigestTTL, "Time the digest of an image tag is reused before its registry is asked again.")
+	l.stringVar(&c.Cache.DummyImage, "dummy_image", "CACHE_DUMMY_IMAGE", server.DefaultDummyImage, "Image of the containers running in place of the steps of the pods served from cache, e.g. from a registry reachable from air-gapped clusters. Tekton steps run sh from it.")
+	l.stringVar(&c.Cache.DummyCommand, "dummy_command", "CACHE_DUMMY_COMMAND", "", "Space separated command of the container running in place of the Argo steps served from cache. Echoes that the outputs are taken from cache when empty.")
+	l.stringVar(&c.Cache.DummyResourceRequests, "dummy_resource_requests", "CACHE_DUMMY_RESOURCE_REQUESTS", "", "Comma separated resource requests of the containers running in place of the steps served from cache, e.g. cpu=10m,memory=16Mi.")
+	l.stringVar(&c.Cache.DummyResourceLimits, "dummy_resource_limits", "CACHE_DUMMY_RESOURCE_LIMITS", "", "Comma separated resource limits of the containers running in place of the steps served from cache, e.g. cpu=100m,memory=32Mi.")
+	l.stringVar(&c.Cache.DummyImagePullSecrets, "dummy_image_pull_secrets", "CACHE_DUMMY_IMAGE_PULL_SECRETS", "", "Comma separated image pull secrets added to the pods served from cache to pull the dummy image.")
+	l.stringVar(&c.Cache.HitScheduling, "hit_scheduling", "CACHE_HIT_SCHEDULING", server.HitSchedulingKeep, "Whether the pods served from cache keep their tolerations, node selector, affinity and runtime class, keep, or have them removed, strip, so that they do not wait for the nodes, e.g. with GPUs, their steps asked for.")
+	l.stringVar(&c.Cache.HitNodeSelector, "hit_node_selector", "CACHE_HIT_NODE_SELECTOR", "", "Comma separated key=value node labels replacing the node selector of the pods served from cache, e.g. pool=cache-hits to run them on a node pool of cheap nodes.")
+	l.boolVar(&c.Cache.MarkWorkflows, "mark_workflows", "CACHE_MARK_WORKFLOWS", false, "Serve /mutate-workflow, annotating the cache enabled templates of created workflows with whether their pods are predicted to be served from cache.")
+	l.stringVar(&c.Cache.AnnotationPrefix, "annotation_prefix", "CACHE_ANNOTATION_PREFIX", server.DefaultAnnotationPrefix, "Domain of the annotations and labels the cache reads and writes on pods, for orchestrators other than KFP.")
+	l.boolVar(&c.Cache.EnforceOwner, "enforce_owner", "CACHE_ENFORCE_OWNER", false, "Only reuse cache entries produced by the same profile or service account.")
+	l.intVar(&c.Cache.NamespaceMaxEntries, "namespace_max_entries", "CACHE_NAMESPACE_MAX_ENTRIES", 0, "Cache entries kept for each namespace, entries are evicted beyond under the eviction policy. 0 means no limit.")
+	l.intVar(&c.Cache.NamespaceMaxOutputBytes, "namespace_max_output_bytes", "CACHE_NAMESPACE_MAX_OUTPUT_BYTES", 0, "Output bytes of the cache entries kept for each namespace, entries are evicted beyond under the eviction policy. 0 means no limit.")
+	l.stringVar(&c.Cache.NamespaceQuotasFile, "namespace_quotas_file", "CACHE_NAMESPACE_QUOTAS_FILE", "", "YAML file mapping namespaces to the maxEntries and maxOutputBytes overriding their quota, e.g. mounted from a ConfigMap.")
+	l.intVar(&c.Cache.MaxEntries, "max_entries", "CACHE_MAX_ENTRIES", 0, "Cache entries kept in the whole store, entries of any namespace are evicted beyond under the eviction policy. 0 means no limit.")
+	l.intVar(&c.Cache.MaxOutputBytes, "max_output_bytes", "CACHE_MAX_OUTPUT_BYTES", 0, "Output bytes of the cache entries kept in the whole store, entries of any namespace are evicted beyond under the eviction policy. 0 means no limit.")
+	l.stringVar(&c.Cache.EvictionPolicy, "eviction_policy", "CACHE_EVICTION_POLICY", storage.EvictionPolicyLRU, "Cache entries evicted first to stay within the quotas, lru for the least recently used or lfu for the least frequently used.")
+}
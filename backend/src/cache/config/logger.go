@@ -0,0 +1,27 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+var logger logrus.FieldLogger = logrus.StandardLogger()
+
+// SetLogger replaces the logger of the package. It is meant to be called once at startup, or by
+// tests capturing log entries.
+func SetLogger(l logrus.FieldLogger) {
+	logger = l
+}
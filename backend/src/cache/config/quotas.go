@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// namespaceQuotaOverride is the quota of a namespace in the namespace quotas file. Omitted fields
+// keep the default quota.
+type namespaceQuotaOverride struct {
+	MaxEntries     *int64 `yaml:"maxEntries"`
+	MaxOutputBytes *int64 `yaml:"maxOutputBytes"`
+}
+
+// DefaultNamespaceQuota returns the quota of the namespaces not overridden by the namespace quotas
+// file.
+func (c *Config) DefaultNamespaceQuota() server.NamespaceQuota {
+	return server.NamespaceQuota{
+		MaxEntries:     int64(c.Cache.NamespaceMaxEntries),
+		MaxOutputBytes: int64(c.Cache.NamespaceMaxOutputBytes),
+	}
+}
+
+// NamespaceQuotasEnabled reports whether the entries of some namespaces are bounded.
+func (c *Config) NamespaceQuotasEnabled() bool {
+	return c.DefaultNamespaceQuota() != (server.NamespaceQuota{}) || c.Cache.NamespaceQuotasFile != ""
+}
+
+// StoreQuota returns the bound of all the entries of the store.
+func (c *Config) StoreQuota() server.NamespaceQuota {
+	return server.NamespaceQuota{
+		MaxEntries:     int64(c.Cache.MaxEntries),
+		MaxOutputBytes: int64(c.Cache.MaxOutputBytes),
+	}
+}
+
+// EvictionEnabled reports whether entries are evicted, to keep some namespaces within their quota
+// or the store within its bound.
+func (c *Config) EvictionEnabled() bool {
+	return c.NamespaceQuotasEnabled() || c.StoreQuota() != (server.NamespaceQuota{})
+}
+
+// ReadNamespaceQuotas reads the namespace quotas file, a YAML file mapping namespaces to their
+// maxEntries and maxOutputBytes, e.g. mounted from a ConfigMap. It returns no quotas without file.
+func (c *Config) ReadNamespaceQuotas() (map[string]server.NamespaceQuota, error) {
+	if c.Cache.NamespaceQuotasFile == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(c.Cache.NamespaceQuotasFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the namespace quotas file: %v", err)
+	}
+	var overrides map[string]namespaceQuotaOverride
+	if err := yaml.UnmarshalStrict(content, &overrides); err != nil {
+		return nil, fmt.Errorf("could not parse the namespace quotas file %s: %v", c.Cache.NamespaceQuotasFile, err)
+	}
+	namespaces := make([]string, 0, len(overrides))
+	for namespace := range overrides {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	quotas := make(map[string]server.NamespaceQuota, len(overrides))
+	var problems []string
+	for _, namespace := range namespaces {
+		override := overrides[namespace]
+		if len(validation.IsDNS1123Label(namespace)) > 0 {
+			problems = append(problems, fmt.Sprintf("%q is not a valid namespace", namespace))
+			continue
+		}
+		quota := c.DefaultNamespaceQuota()
+		if override.MaxEntries != nil {
+			quota.MaxEntries = *override.MaxEntries
+		}
+		if override.MaxOutputBytes != nil {
+			quota.MaxOutputBytes = *override.MaxOutputBytes
+		}
+		if quota.MaxEntries < 0 || quota.MaxOutputBytes < 0 {
+			problems = append(problems, fmt.Sprintf("the quota of %s must not be negative", namespace))
+			continue
+		}
+		quotas[namespace] = quota
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid namespace quotas file %s: %s", c.Cache.NamespaceQuotasFile, strings.Join(problems, "; "))
+	}
+	return quotas, nil
+}
+
+// WatchNamespaceQuotas checks the namespace quotas file every FileReloadInterval until ctx is done
+// and calls apply with the quotas read from it when it changed. Files that cannot be read are
+// logged and the current quotas are kept.
+func (c *Config) WatchNamespaceQuotas(ctx context.Context, apply func(map[string]server.NamespaceQuota)) {
+	if c.Cache.NamespaceQuotasFile == "" || c.FileReloadInterval <= 0 {
+		return
+	}
+	lastStat := statNamespaceQuotas(c.Cache.NamespaceQuotasFile)
+	ticker := time.NewTicker(c.FileReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stat := statNamespaceQuotas(c.Cache.NamespaceQuotasFile)
+			if stat == lastStat {
+				continue
+			}
+			// The stat is remembered even when reading fails, so that a broken file is not read
+			// again on every check.
+			lastStat = stat
+			quotas, err := c.ReadNamespaceQuotas()
+			if err != nil {
+				logger.Errorf("Failed to read the namespace quotas file, keeping the current quotas: %v", err)
+				continue
+			}
+			logger.Infof("Reloaded the quotas of %d namespaces from the namespace quotas file", len(quotas))
+			apply(quotas)
+		}
+	}
+}
+
+// statNamespaceQuotas describes the size and modification time of the file, or the error to stat
+// it, so that it is read once it changes.
+func statNamespaceQuotas(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%d/%v", info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadReadsTheNamespaceQuotasFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeSecretFile(t, dir, "quotas.yaml", `
+team-a:
+  maxEntries: 5000
+team-b:
+  maxEntries: 0
+  maxOutputBytes: 1073741824
+`)
+
+	config, err := load(nil, map[string]string{
+		"CACHE_NAMESPACE_MAX_ENTRIES":      "1000",
+		"CACHE_NAMESPACE_MAX_OUTPUT_BYTES": "104857600",
+		"CACHE_NAMESPACE_QUOTAS_FILE":      path,
+	})
+	require.Nil(t, err)
+	assert.True(t, config.NamespaceQuotasEnabled())
+	assert.Equal(t, server.NamespaceQuota{MaxEntries: 1000, MaxOutputBytes: 104857600}, config.DefaultNamespaceQuota())
+	assert.Equal(t, map[string]server.NamespaceQuota{
+		"team-a": {MaxEntries: 5000, MaxOutputBytes: 104857600},
+		"team-b": {MaxEntries: 0, MaxOutputBytes: 1073741824},
+	}, config.Cache.NamespaceQuotas)
+}
+
+func TestLoadRejectsInvalidNamespaceQuotasFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	for _, tc := range []struct {
+		content string
+		wantErr string
+	}{
+		{content: "team-a: [1000]", wantErr: "could not parse the namespace quotas file"},
+		{content: "team-a:\n  maxEntry: 1000", wantErr: "field maxEntry not found"},
+		{content: "Team_A:\n  maxEntries: 1000", wantErr: `"Team_A" is not a valid namespace`},
+		{content: "team-a:\n  maxOutputBytes: -1", wantErr: "the quota of team-a must not be negative"},
+	} {
+		_, err := load(nil, map[string]string{"CACHE_NAMESPACE_QUOTAS_FILE": writeSecretFile(t, dir, "quotas.yaml", tc.content)})
+		require.NotNil(t, err, tc.content)
+		assert.Contains(t, err.Error(), tc.wantErr)
+	}
+	_, err := load(nil, map[string]string{"CACHE_NAMESPACE_QUOTAS_FILE": "/nonexistent/quotas.yaml"})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not read the namespace quotas file")
+}
+
+func TestNamespaceQuotasDisabledByDefault(t *testing.T) {
+	config, err := load(nil, nil)
+	require.Nil(t, err)
+	assert.False(t, config.NamespaceQuotasEnabled())
+	assert.False(t, config.EvictionEnabled())
+	assert.Empty(t, config.Cache.NamespaceQuotas)
+}
+
+func TestLoadStoreQuota(t *testing.T) {
+	config, err := load(nil, map[string]string{"CACHE_MAX_ENTRIES": "100000", "CACHE_EVICTION_POLICY": "lfu"})
+	require.Nil(t, err)
+	assert.False(t, config.NamespaceQuotasEnabled())
+	assert.True(t, config.EvictionEnabled())
+	assert.Equal(t, server.NamespaceQuota{MaxEntries: 100000}, config.StoreQuota())
+	assert.Equal(t, "lfu", config.Cache.EvictionPolicy)
+}
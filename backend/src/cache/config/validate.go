@@ -0,0 +1,261 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// sqlIdentifier matches the database and table names interpolated into queries.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validator collects the problems of a configuration, so that all of them are reported at once.
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) port(name string, value string) {
+	port, err := strconv.Atoi(value)
+	v.check(err == nil && port >= 1 && port <= 65535, "%s %q is not a port between 1 and 65535", name, value)
+}
+
+func (v *validator) nonNegativeDuration(name string, value time.Duration) {
+	v.check(value >= 0, "%s must not be negative, got %v", name, value)
+}
+
+func (v *validator) nonNegative(name string, value int) {
+	v.check(value >= 0, "%s must not be negative, got %d", name, value)
+}
+
+// Validate reports every invalid setting and combination of settings of the configuration.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.port("webhook port", c.Listener.WebhookPort)
+	v.port("health port", c.Listener.HealthPort)
+	v.check(c.Listener.WebhookPort != c.Listener.HealthPort, "webhook and health ports must differ, both are %s", c.Listener.WebhookPort)
+	if c.Listener.GRPCPort != "" {
+		v.port("gRPC port", c.Listener.GRPCPort)
+		v.check(c.Listener.GRPCPort != c.Listener.WebhookPort && c.Listener.GRPCPort != c.Listener.HealthPort,
+			"the gRPC port must differ from the webhook and health ports, got %s", c.Listener.GRPCPort)
+	}
+	v.nonNegativeDuration("shutdown grace period", c.Listener.ShutdownGracePeriod)
+	v.nonNegativeDuration("shutdown drain delay", c.Listener.ShutdownDrainDelay)
+	v.check(c.Listener.HealthDBTimeout > 0, "health DB timeout must be positive, got %v", c.Listener.HealthDBTimeout)
+	v.check(c.Listener.HealthRedisTimeout > 0, "health Redis timeout must be positive, got %v", c.Listener.HealthRedisTimeout)
+	v.check(c.Listener.SelfTestTimeout > 0, "self test timeout must be positive, got %v", c.Listener.SelfTestTimeout)
+	v.check(c.Listener.StatsCacheInterval >= 0, "stats cache interval must not be negative, got %v", c.Listener.StatsCacheInterval)
+
+	v.check(c.TLS.Enabled || c.Listener.WebhookPort != DefaultWebhookPort || c.TLS.AllowPlainHTTPOnDefaultPort,
+		"refusing to serve the webhook without TLS on the default port %s, which the Service exposes as HTTPS. "+
+			"Set WEBHOOK_PORT to the port the mesh forwards to, or ALLOW_PLAIN_HTTP_ON_DEFAULT_PORT=true", DefaultWebhookPort)
+	v.check(c.TLS.Enabled || !c.TLS.SelfSigned, "a self-signed certificate cannot be generated with TLS disabled")
+	v.check(c.TLS.Enabled || c.TLS.ClientCAFile == "", "client certificates cannot be verified with TLS disabled")
+	v.check(c.TLS.Enabled || c.TLS.CAFile == "", "the serving certificate cannot be verified with TLS disabled")
+	v.check(!c.TLS.Enabled || (c.TLS.CertFile != "" && c.TLS.KeyFile != ""), "TLS requires the names of the certificate and key files")
+	v.check(c.TLS.SelfSigned || c.TLS.MutatingWebhookConfiguration == "",
+		"the MutatingWebhookConfiguration %s is only patched with a generated self-signed certificate", c.TLS.MutatingWebhookConfiguration)
+
+	switch c.Cache.Store {
+	case StoreMySQL:
+		v.check(c.DB.Driver == DriverMySQL, "database driver %q is not supported, expected %s", c.DB.Driver, DriverMySQL)
+		c.DB.validate(v)
+		switch c.Cache.PartitionBy {
+		case storage.PartitionByNone:
+		case storage.PartitionByMonth:
+			v.check(c.Cache.PartitionLookback >= 1, "partition lookback must be at least 1, got %d", c.Cache.PartitionLookback)
+		default:
+			v.check(false, "partitioning %q is not supported, expected %s or %s", c.Cache.PartitionBy, storage.PartitionByNone, storage.PartitionByMonth)
+		}
+		v.nonNegative("partition retention", c.Cache.PartitionRetention)
+	case StoreS3:
+		v.check(c.S3.BucketName != "", "cache store %s requires a bucket name", StoreS3)
+	case StoreRedis:
+		v.check(c.Redis.Enabled(), "cache store %s requires REDIS_HOST or REDIS_ADDRESSES to be set", StoreRedis)
+	case StoreMemory:
+		v.check(c.Cache.PartitionBy == storage.PartitionByNone, "cache store %s cannot be partitioned", StoreMemory)
+	default:
+		v.check(false, "cache store %q is not supported, expected %s, %s, %s or %s", c.Cache.Store, StoreMySQL, StoreS3, StoreRedis, StoreMemory)
+	}
+
+	if c.Redis.Enabled() {
+		c.Redis.validate(v)
+	}
+
+	v.check(server.IsValidAnnotationPrefix(c.Cache.AnnotationPrefix), "annotation prefix %q is not a DNS subdomain", c.Cache.AnnotationPrefix)
+	v.check(server.IsValidCacheMode(c.Cache.Mode), "invalid cache mode %q, expected %s or %s", c.Cache.Mode, server.CacheModeActive, server.CacheModeShadow)
+	v.check(server.IsValidFailPolicy(c.Cache.FailPolicy), "invalid fail policy %q, expected %s or %s", c.Cache.FailPolicy, server.FailPolicyOpen, server.FailPolicyClosed)
+	v.check(server.IsValidCacheKeyVersion(c.Cache.KeyVersion), "unknown cache key version %q", c.Cache.KeyVersion)
+	if _, err := server.ParseIgnoredTemplateFields(c.Cache.KeyIgnoredFields); err != nil {
+		v.check(false, "%v", err)
+	}
+	v.check(server.IsValidValidationMode(c.Cache.ValidationMode), "invalid validation mode %q, expected %s or %s", c.Cache.ValidationMode, server.ValidationModeWarn, server.ValidationModeEnforce)
+	v.check(server.IsValidCrossClusterPolicy(c.Cache.CrossCluster), "invalid cross cluster policy %q, expected %s, %s or %s", c.Cache.CrossCluster, server.CrossClusterShared, server.CrossClusterLocal, server.CrossClusterPreferLocal)
+	v.check(c.Cache.CrossCluster == server.CrossClusterShared || c.Cache.ClusterID != "", "cross cluster policy %s requires a cluster ID", c.Cache.CrossCluster)
+	v.check(!c.Cache.VerifyRemoteArtifacts || (c.S3.Host != "" && c.S3.BucketName != ""), "verifying remote artifacts requires an object store host and bucket name")
+	v.check(c.Cache.MaxRequestBodyBytes > 0, "max request body bytes must be positive, got %d", c.Cache.MaxRequestBodyBytes)
+	v.nonNegativeDuration("admission deadline", c.Cache.AdmissionDeadline)
+	v.nonNegative("lookup circuit failure threshold", c.Cache.LookupCircuitFailureThreshold)
+	v.nonNegativeDuration("lookup circuit cool down", c.Cache.LookupCircuitCoolDown)
+	v.nonNegativeDuration("lookup miss ttl", c.Cache.LookupMissTTL)
+	v.nonNegativeDuration("default ttl", c.Cache.DefaultTTL)
+	v.nonNegativeDuration("image digest ttl", c.Cache.ImageDigestTTL)
+	if _, err := server.NewNamespaceFilter(c.Cache.AllowedNamespaces, c.Cache.DeniedNamespaces); err != nil {
+		v.check(false, "%v", err)
+	}
+	if _, err := server.NewDummyContainer(c.Cache.DummyImage, c.Cache.DummyCommand, c.Cache.DummyResourceRequests, c.Cache.DummyResourceLimits, c.Cache.DummyImagePullSecrets); err != nil {
+		v.check(false, "%v", err)
+	}
+	if _, err := server.NewHitScheduling(c.Cache.HitScheduling, c.Cache.HitNodeSelector); err != nil {
+		v.check(false, "%v", err)
+	}
+	v.nonNegative("max concurrent admissions", c.Cache.MaxConcurrentAdmissions)
+	v.nonNegativeDuration("admission queue timeout", c.Cache.AdmissionQueueTimeout)
+	v.check(c.Cache.AdmissionRatePerNamespace >= 0, "admission rate per namespace must not be negative, got %v", c.Cache.AdmissionRatePerNamespace)
+	v.check(c.Cache.AdmissionRatePerNamespace == 0 || c.Cache.AdmissionBurstPerNamespace >= 1,
+		"admission burst per namespace must be at least 1 when rate limiting, got %d", c.Cache.AdmissionBurstPerNamespace)
+	v.nonNegative("namespace max entries", c.Cache.NamespaceMaxEntries)
+	v.nonNegative("namespace max output bytes", c.Cache.NamespaceMaxOutputBytes)
+	v.check(!c.NamespaceQuotasEnabled() || c.Cache.Store == StoreMySQL, "namespace quotas require the %s cache store, got %s", StoreMySQL, c.Cache.Store)
+	v.nonNegative("max entries", c.Cache.MaxEntries)
+	v.nonNegative("max output bytes", c.Cache.MaxOutputBytes)
+	v.check(c.StoreQuota() == (server.NamespaceQuota{}) || c.Cache.Store == StoreMySQL, "bounding the cache requires the %s cache store, got %s", StoreMySQL, c.Cache.Store)
+	v.check(storage.IsValidEvictionPolicy(c.Cache.EvictionPolicy), "eviction policy %q is not supported, expected %s or %s",
+		c.Cache.EvictionPolicy, storage.EvictionPolicyLRU, storage.EvictionPolicyLFU)
+
+	v.nonNegativeDuration("watcher resync period", c.Watcher.ResyncPeriod)
+	v.nonNegativeDuration("watcher catch-up lookback", c.Watcher.CatchUpLookback)
+	v.check(c.Watcher.PatchQPS >= 0, "watcher patch qps must not be negative, got %v", c.Watcher.PatchQPS)
+	v.check(c.Watcher.PatchQPS == 0 || c.Watcher.PatchBurst >= 1, "watcher patch burst must be at least 1 when rate limiting, got %d", c.Watcher.PatchBurst)
+	v.check(c.Watcher.WriteWorkers >= 1, "watcher write workers must be at least 1, got %d", c.Watcher.WriteWorkers)
+	v.check(c.Watcher.WriteQPS >= 0, "watcher write qps must not be negative, got %v", c.Watcher.WriteQPS)
+	v.check(c.Watcher.WriteQPS == 0 || c.Watcher.WriteBurst >= 1, "watcher write burst must be at least 1 when rate limiting, got %d", c.Watcher.WriteBurst)
+	v.nonNegativeDuration("backfill max age", c.Watcher.BackfillMaxAge)
+	v.check(c.Watcher.WriteMaxRetries >= 1, "watcher write max retries must be at least 1, got %d", c.Watcher.WriteMaxRetries)
+	v.check(c.Watcher.WriteQueueSize >= 1, "watcher write queue size must be at least 1, got %d", c.Watcher.WriteQueueSize)
+	if c.Watcher.Namespaces != "" {
+		if _, err := server.ParseWatchedNamespaces(c.Watcher.Namespaces); err != nil {
+			v.check(false, "%v", err)
+		}
+	}
+	if c.Watcher.LeaderElection {
+		v.check(len(validation.IsDNS1123Subdomain(c.Watcher.LeaseName)) == 0, "leader election lease name %q is not a valid lease name", c.Watcher.LeaseName)
+		v.check(c.Watcher.LeaseNamespace != "" || c.NamespaceToWatch != "", "leader election requires a lease namespace when no namespace is watched")
+		v.check(c.Watcher.RetryPeriod > 0, "leader election retry period must be positive, got %v", c.Watcher.RetryPeriod)
+		v.check(c.Watcher.RenewDeadline > c.Watcher.RetryPeriod*6/5, "leader election renew deadline %v must exceed 1.2 times the retry period %v", c.Watcher.RenewDeadline, c.Watcher.RetryPeriod)
+		v.check(c.Watcher.LeaseDuration > c.Watcher.RenewDeadline, "leader election lease duration %v must exceed the renew deadline %v", c.Watcher.LeaseDuration, c.Watcher.RenewDeadline)
+	}
+	if c.Watcher.ArgoPersistenceDBName != "" {
+		v.check(c.DB.Driver == DriverMySQL, "argo persistence requires the %s db driver, got %q", DriverMySQL, c.DB.Driver)
+		v.check(sqlIdentifier.MatchString(c.Watcher.ArgoPersistenceDBName), "argo persistence db name %q is not a valid database name", c.Watcher.ArgoPersistenceDBName)
+		v.check(sqlIdentifier.MatchString(c.Watcher.ArgoPersistenceTable), "argo persistence table %q is not a valid table name", c.Watcher.ArgoPersistenceTable)
+	}
+	if c.Watcher.MLMDAddress != "" {
+		_, port, err := net.SplitHostPort(c.Watcher.MLMDAddress)
+		v.check(err == nil && port != "", "mlmd address %q is not a host:port address", c.Watcher.MLMDAddress)
+		v.check(c.Watcher.MLMDMaxRetries >= 1, "mlmd max retries must be at least 1, got %d", c.Watcher.MLMDMaxRetries)
+	}
+
+	v.nonNegativeDuration("scrub interval", c.Watcher.ScrubInterval)
+	if c.Watcher.ScrubInterval > 0 {
+		v.check(c.Cache.Store == StoreMySQL, "the artifact scrubber requires the %s cache store, got %s", StoreMySQL, c.Cache.Store)
+		v.check(c.Watcher.ScrubMinAge > 0, "scrub min age must be positive, got %v", c.Watcher.ScrubMinAge)
+		v.check(c.Watcher.ScrubConcurrency >= 1, "scrub concurrency must be at least 1, got %d", c.Watcher.ScrubConcurrency)
+		v.check(c.Watcher.ScrubQPS >= 0, "scrub qps must not be negative, got %v", c.Watcher.ScrubQPS)
+		v.check(c.Watcher.ScrubQPS == 0 || c.Watcher.ScrubBurst >= 1, "scrub burst must be at least 1 when rate limiting, got %d", c.Watcher.ScrubBurst)
+		v.check(c.S3.Host != "" && c.S3.BucketName != "", "the artifact scrubber requires an object store host and bucket name")
+	}
+
+	c.Audit.validate(v, c.Cache.Store)
+
+	if _, err := logging.NewLogger(c.Observability.LogLevel, c.Observability.LogFormat, ioutil.Discard); err != nil {
+		v.check(false, "invalid logging configuration: %v", err)
+	}
+	if _, err := server.ParseSensitiveParameterPatterns(c.Observability.SensitiveParameterPatterns); err != nil {
+		v.check(false, "%v", err)
+	}
+	v.nonNegative("decision buffer size", c.Observability.DecisionBufferSize)
+	v.check(c.Observability.MaxTemplateLabels >= 0, "max template labels must not be negative, got %d", c.Observability.MaxTemplateLabels)
+
+	if len(v.problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(v.problems, "; "))
+	}
+	return nil
+}
+
+func (c AuditConfig) validate(v *validator, store string) {
+	switch c.Sink {
+	case "", server.AuditSinkStdout:
+	case server.AuditSinkFile:
+		v.check(c.File != "", "audit sink %s requires an audit file", server.AuditSinkFile)
+		v.check(c.FileMaxBytes > 0, "audit file max bytes must be positive, got %d", c.FileMaxBytes)
+		v.nonNegative("audit file max backups", c.FileMaxBackups)
+	case server.AuditSinkDB:
+		v.check(store == StoreMySQL, "audit sink %s requires the %s cache store", server.AuditSinkDB, StoreMySQL)
+	default:
+		v.check(false, "audit sink %q is not supported, expected %s, %s or %s", c.Sink, server.AuditSinkStdout, server.AuditSinkFile, server.AuditSinkDB)
+	}
+	v.check(c.BufferSize > 0, "audit buffer size must be positive, got %d", c.BufferSize)
+}
+
+func (c DBConfig) validate(v *validator) {
+	v.nonNegative("database max open connections", c.MaxOpenConns)
+	v.nonNegative("database max idle connections", c.MaxIdleConns)
+	v.check(c.MaxOpenConns == 0 || c.MaxIdleConns <= c.MaxOpenConns,
+		"database max idle connections (%d) must not exceed max open connections (%d)", c.MaxIdleConns, c.MaxOpenConns)
+	v.check(c.ConnMaxLifetime >= 0 && c.ConnMaxIdleTime >= 0, "database connection lifetimes must not be negative")
+	v.check(c.DialTimeout >= 0 && c.ReadTimeout >= 0 && c.WriteTimeout >= 0, "database timeouts must not be negative")
+}
+
+func (c RedisConfig) validate(v *validator) {
+	switch c.Mode {
+	case client.RedisModeStandalone:
+		v.check(c.Host != "", "Redis %s mode requires a host", client.RedisModeStandalone)
+		v.port("Redis port", c.Port)
+	case client.RedisModeSentinel:
+		v.check(c.MasterName != "" && len(c.AddressList()) > 0, "Redis %s mode requires a master name and at least one sentinel address", client.RedisModeSentinel)
+	case client.RedisModeCluster:
+		v.check(len(c.AddressList()) > 0, "Redis %s mode requires at least one seed address", client.RedisModeCluster)
+		v.check(c.DB == 0, "Redis %s mode only supports DB index 0, got %d", client.RedisModeCluster, c.DB)
+	default:
+		v.check(false, "Redis mode %q is not supported, expected %s, %s or %s", c.Mode, client.RedisModeStandalone, client.RedisModeSentinel, client.RedisModeCluster)
+	}
+	v.check(c.DB >= 0, "invalid Redis DB index %d", c.DB)
+	v.check(c.TLSEnabled || (c.TLSCACertPath == "" && !c.TLSInsecureSkipVerify), "Redis TLS settings require REDIS_TLS_ENABLED")
+	v.check(c.TLSCACertPath == "" || !c.TLSInsecureSkipVerify, "a Redis CA certificate and skipping verification are mutually exclusive")
+	v.check(c.PoolSize > 0, "Redis pool size must be positive, got %d", c.PoolSize)
+	v.nonNegative("Redis min idle connections", c.MinIdleConns)
+	v.nonNegative("Redis max retries", c.MaxRetries)
+	v.nonNegative("Redis circuit failure threshold", c.CircuitFailureThreshold)
+	v.check(c.OperationTimeout > 0, "Redis operation timeout must be positive, got %v", c.OperationTimeout)
+}
@@ -36,9 +36,11 @@ done
 [ -z ${cert_input_path} ] && cert_input_path=${CA_FILE}
 
 export CA_BUNDLE=$(cat ${cert_input_path})
+# The domain of the cache_enabled label selecting the pods, see --annotation_prefix.
+export CACHE_ANNOTATION_PREFIX=${CACHE_ANNOTATION_PREFIX:-pipelines.kubeflow.org}
 
 if command -v envsubst >/dev/null 2>&1; then
     envsubst
 else
-    sed -e "s|\${CA_BUNDLE}|${CA_BUNDLE}|g" -e "s|\${NAMESPACE}|${NAMESPACE}|g"
+    sed -e "s|\${CA_BUNDLE}|${CA_BUNDLE}|g" -e "s|\${NAMESPACE}|${NAMESPACE}|g" -e "s|\${CACHE_ANNOTATION_PREFIX}|${CACHE_ANNOTATION_PREFIX}|g"
 fi
@@ -0,0 +1,229 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/jinzhu/gorm"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// StoreMemory evaluates against an empty execution cache held in memory.
+	StoreMemory string = "memory"
+	// StoreSQLitePrefix followed by a path evaluates against the execution cache of a SQLite file.
+	StoreSQLitePrefix string = "sqlite:"
+)
+
+// evaluateCommand prints what the webhook decides on the admission of a pod, without cluster,
+// certificates or database, e.g. to try changes to the mutation.
+type evaluateCommand struct {
+	file         string
+	pod          bool
+	store        string
+	noStore      bool
+	cacheEntries string
+}
+
+func (c *evaluateCommand) registerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.file, "file", "-", "AdmissionReview to evaluate, as JSON or YAML. - reads it from stdin.")
+	flags.BoolVar(&c.pod, "pod", false, "The file holds a bare Pod manifest rather than an AdmissionReview.")
+	flags.StringVar(&c.store, "store", StoreMemory, "Execution cache to look the pod up in, memory or sqlite:<path>.")
+	flags.BoolVar(&c.noStore, "no_store", false, "Answer every lookup as a miss without execution cache.")
+	flags.StringVar(&c.cacheEntries, "cache_entries", "", "JSON array of execution caches added to the store before the evaluation.")
+}
+
+func (c *evaluateCommand) run(cfg *config.Config, configuredLogger *logrus.Logger) {
+	evaluation, err := c.evaluate(cfg)
+	if err != nil {
+		logger.Fatalf("Failed to evaluate the admission: %v", err)
+	}
+	b, err := json.MarshalIndent(evaluation, "", "  ")
+	if err != nil {
+		logger.Fatalf("Failed to print the evaluation: %v", err)
+	}
+	fmt.Println(string(b))
+	// The webhook failed on the pod.
+	if evaluation.Rejection != "" || evaluation.Decision == server.AdmissionOutcomeError {
+		os.Exit(2)
+	}
+}
+
+// evaluate decides on the admission of the file with the settings of the webhook.
+func (c *evaluateCommand) evaluate(cfg *config.Config) (server.Evaluation, error) {
+	request, err := c.readRequest()
+	if err != nil {
+		return server.Evaluation{}, err
+	}
+	store, closeStore, err := c.openStore()
+	if err != nil {
+		return server.Evaluation{}, err
+	}
+	defer closeStore()
+	webhook := server.NewWebhook(server.WebhookConfig{
+		Mutation: mutationConfig(cfg, nil, nil, nil),
+		Keys:     server.NewAnnotationKeys(cfg.Cache.AnnotationPrefix),
+	})
+	return webhook.EvaluateAdmission(context.Background(), request, evaluationClientManager{store: store}), nil
+}
+
+func (c *evaluateCommand) readRequest() (*v1beta1.AdmissionRequest, error) {
+	var content []byte
+	var err error
+	if c.file == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(c.file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	object, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s as JSON or YAML: %v", c.file, err)
+	}
+	if c.pod {
+		return podAdmissionRequest(object)
+	}
+	var review v1beta1.AdmissionReview
+	if err := json.Unmarshal(object, &review); err != nil {
+		return nil, fmt.Errorf("could not read the admission review: %v", err)
+	}
+	if review.Request == nil {
+		return nil, fmt.Errorf("the admission review has no request")
+	}
+	return review.Request, nil
+}
+
+// podAdmissionRequest returns the request the API server sends on the creation of the pod.
+func podAdmissionRequest(object []byte) (*v1beta1.AdmissionRequest, error) {
+	var pod corev1.Pod
+	if err := json.Unmarshal(object, &pod); err != nil {
+		return nil, fmt.Errorf("could not read the pod: %v", err)
+	}
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return &v1beta1.AdmissionRequest{
+		UID:       "evaluate",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Name:      pod.Name,
+		Namespace: namespace,
+		Operation: v1beta1.Create,
+		Object:    runtime.RawExtension{Raw: object},
+	}, nil
+}
+
+// openStore returns the execution cache store to evaluate against, holding the cache entries, and
+// the function closing it.
+func (c *evaluateCommand) openStore() (storage.ExecutionCacheStoreInterface, func(), error) {
+	var entries []*model.ExecutionCache
+	if c.cacheEntries != "" {
+		content, err := ioutil.ReadFile(c.cacheEntries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return nil, nil, fmt.Errorf("could not read the cache entries: %v", err)
+		}
+	}
+	if c.noStore {
+		if len(entries) > 0 {
+			return nil, nil, fmt.Errorf("cache entries cannot be added without store")
+		}
+		return missingExecutionCacheStore{}, func() {}, nil
+	}
+
+	var source string
+	switch {
+	case c.store == StoreMemory:
+		source = ":memory:"
+	case strings.HasPrefix(c.store, StoreSQLitePrefix) && len(c.store) > len(StoreSQLitePrefix):
+		source = strings.TrimPrefix(c.store, StoreSQLitePrefix)
+	default:
+		return nil, nil, fmt.Errorf("unsupported store %q, expected %s or %s<path>", c.store, StoreMemory, StoreSQLitePrefix)
+	}
+	db, err := gorm.Open("sqlite3", source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open the %s store: %v", c.store, err)
+	}
+	if err := db.AutoMigrate(&model.ExecutionCache{}).Error; err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("could not create the execution cache table: %v", err)
+	}
+	store := storage.NewExecutionCacheStore(storage.NewDB(db), util.NewRealTime())
+	for _, entry := range entries {
+		if _, err := store.CreateExecutionCache(context.Background(), entry); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("could not add the cache entry of key %s: %v", entry.ExecutionCacheKey, err)
+		}
+	}
+	return store, func() { db.Close() }, nil
+}
+
+// evaluationClientManager provides the store of an evaluation.
+type evaluationClientManager struct {
+	store storage.ExecutionCacheStoreInterface
+}
+
+func (m evaluationClientManager) CacheStore() storage.ExecutionCacheStoreInterface {
+	return m.store
+}
+
+func (m evaluationClientManager) DB() *storage.DB {
+	return nil
+}
+
+func (m evaluationClientManager) RedisClient() *client.RedisClient {
+	return nil
+}
+
+func (m evaluationClientManager) KubernetesCoreClient() client.KubernetesCoreInterface {
+	return nil
+}
+
+func (m evaluationClientManager) ArgoClient() client.ArgoClientInterface {
+	return nil
+}
+
+// missingExecutionCacheStore finds no execution cache.
+type missingExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+}
+
+func (missingExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q, evaluating without store", executionCacheKey)
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the tests.")
+
+// evaluateArgs evaluates the admission of the evaluate command of the arguments.
+func evaluateArgs(t *testing.T, args ...string) (server.Evaluation, error) {
+	cmd, cfg, _ := loadArgs(t, append([]string{"evaluate"}, args...), nil)
+	return cmd.(*evaluateCommand).evaluate(cfg)
+}
+
+func TestEvaluateMatchesGoldenEvaluations(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "miss", args: []string{"--file=testdata/evaluate/kfp_pod_review.json"}},
+		{name: "hit", args: []string{"--file=testdata/evaluate/kfp_pod_review.json", "--cache_entries=testdata/evaluate/cache_entries.json"}},
+		{name: "pod_without_store", args: []string{"--pod", "--file=testdata/evaluate/pod.yaml", "--no_store"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evaluation, err := evaluateArgs(t, test.args...)
+			require.Nil(t, err)
+			b, err := json.MarshalIndent(evaluation, "", "  ")
+			require.Nil(t, err)
+
+			goldenPath := filepath.Join("testdata", "evaluate", test.name+".golden")
+			if *updateGolden {
+				require.Nil(t, ioutil.WriteFile(goldenPath, append(b, '\n'), 0644))
+			}
+			golden, err := ioutil.ReadFile(goldenPath)
+			require.Nil(t, err)
+			assert.Equal(t, string(golden), string(b)+"\n")
+		})
+	}
+}
+
+func TestEvaluateWithSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evaluate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := "--store=sqlite:" + filepath.Join(dir, "cache.db")
+
+	// The entries added to the file are found by later evaluations.
+	evaluation, err := evaluateArgs(t, "--file=testdata/evaluate/kfp_pod_review.json", store, "--cache_entries=testdata/evaluate/cache_entries.json")
+	require.Nil(t, err)
+	assert.Equal(t, server.AdmissionOutcomeHit, evaluation.Decision)
+	evaluation, err = evaluateArgs(t, "--file=testdata/evaluate/kfp_pod_review.json", store)
+	require.Nil(t, err)
+	assert.Equal(t, server.AdmissionOutcomeHit, evaluation.Decision)
+}
+
+func TestEvaluateFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing file", args: []string{"--file=testdata/evaluate/missing.json"}, wantErr: "no such file or directory"},
+		{name: "pod read as review", args: []string{"--file=testdata/evaluate/pod.yaml"}, wantErr: "the admission review has no request"},
+		{name: "unsupported store", args: []string{"--file=testdata/evaluate/kfp_pod_review.json", "--store=mysql"}, wantErr: `unsupported store "mysql", expected memory or sqlite:<path>`},
+		{name: "entries without store", args: []string{"--file=testdata/evaluate/kfp_pod_review.json", "--no_store", "--cache_entries=testdata/evaluate/cache_entries.json"}, wantErr: "cache entries cannot be added without store"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := evaluateArgs(t, test.args...)
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestEvaluateUnderClosedFailPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evaluate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	pod := filepath.Join(dir, "pod.yaml")
+	require.Nil(t, ioutil.WriteFile(pod, []byte(`
+metadata:
+  labels:
+    pipelines.kubeflow.org/cache_enabled: "true"
+  annotations:
+    workflows.argoproj.io/template: "{"
+spec:
+  containers:
+  - name: main
+    image: python:3.7
+`), 0644))
+
+	cmd, cfg, _ := loadArgs(t, []string{"evaluate", "--pod", "--file=" + pod}, map[string]string{"CACHE_WEBHOOK_FAIL_POLICY": "closed"})
+	evaluation, err := cmd.(*evaluateCommand).evaluate(cfg)
+
+	require.Nil(t, err)
+	assert.Equal(t, server.AdmissionOutcomeError, evaluation.Decision)
+	assert.Contains(t, evaluation.Rejection, "rejected the pod under the closed fail policy: could not generate the cache key")
+	assert.Nil(t, evaluation.Patch)
+}
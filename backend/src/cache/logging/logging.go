@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatJSON    string = "json"
+	FormatConsole string = "console"
+
+	DefaultLevel  string = "info"
+	DefaultFormat string = FormatJSON
+)
+
+// Field names shared by the log entries of the cache server, so that entries can be filtered
+// across packages.
+const (
+	FieldPod        string = "pod"
+	FieldNamespace  string = "namespace"
+	FieldCacheKey   string = "cacheKey"
+	FieldCacheID    string = "cacheId"
+	FieldDecision   string = "decision"
+	FieldDurationMs string = "durationMs"
+	FieldStore      string = "store"
+	FieldMethod     string = "method"
+	FieldRequestID  string = "requestId"
+	FieldNodeName   string = "nodeName"
+	FieldSkipReason string = "skipReason"
+	FieldWorkflow   string = "workflow"
+	FieldRunID      string = "runId"
+	// FieldTokenID identifies the admin token of a request, whose value is not logged.
+	FieldTokenID string = "tokenId"
+	// FieldOutputBytes, FieldOutputParameters and FieldOutputArtifacts summarize cached outputs,
+	// whose values are not logged.
+	FieldOutputBytes      string = "outputBytes"
+	FieldOutputParameters string = "outputParameters"
+	FieldOutputArtifacts  string = "outputArtifacts"
+)
+
+type fieldsKey struct{}
+
+// NewLogger creates a logger writing entries of at least the level, one of trace, debug, info,
+// warn, error, fatal or panic, to out. Entries are encoded as JSON objects or as console lines.
+func NewLogger(level string, format string, out io.Writer) (*logrus.Logger, error) {
+	parsedLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetLevel(parsedLevel)
+	switch format {
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	case FormatConsole:
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, FormatJSON, FormatConsole)
+	}
+	return logger, nil
+}
+
+// ContextWithFields returns a copy of ctx carrying the fields in addition to those already in
+// ctx, so that every entry logged for one admission can be correlated.
+func ContextWithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	for key, value := range FieldsFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the fields added to ctx by ContextWithFields.
+func FieldsFromContext(ctx context.Context) logrus.Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(logrus.Fields)
+	return fields
+}
+
+// WithContext returns an entry of the logger carrying the fields of ctx.
+func WithContext(logger logrus.FieldLogger, ctx context.Context) *logrus.Entry {
+	return logger.WithFields(FieldsFromContext(ctx))
+}
+
+// DurationMs expresses a duration in fractional milliseconds for FieldDurationMs.
+func DurationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerEncodesJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger("info", FormatJSON, &out)
+	require.Nil(t, err)
+
+	logger.WithField(FieldPod, "train-123").Debug("dropped")
+	logger.WithField(FieldPod, "train-123").WithField(FieldDurationMs, DurationMs(1500*time.Microsecond)).Info("kept")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "kept", entry["msg"])
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "train-123", entry[FieldPod])
+	assert.Equal(t, 1.5, entry[FieldDurationMs])
+}
+
+func TestNewLoggerEncodesConsoleLines(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger("debug", FormatConsole, &out)
+	require.Nil(t, err)
+
+	logger.WithField(FieldCacheKey, "abc").Debug("looked up")
+
+	assert.Contains(t, out.String(), "level=debug")
+	assert.Contains(t, out.String(), `msg="looked up"`)
+	assert.Contains(t, out.String(), "cacheKey=abc")
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	_, err := NewLogger("verbose", FormatJSON, &bytes.Buffer{})
+	assert.NotNil(t, err)
+	_, err = NewLogger("info", "xml", &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+func TestContextWithFieldsAccumulates(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	ctx := ContextWithFields(context.Background(), logrus.Fields{FieldRequestID: "req-1", FieldNamespace: "default"})
+	podCtx := ContextWithFields(ctx, logrus.Fields{FieldPod: "train-123", FieldNamespace: "kubeflow"})
+
+	WithContext(logger, podCtx).Info("admitted")
+	WithContext(logger, ctx).Info("received")
+	WithContext(logger, context.Background()).Info("unrelated")
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, logrus.Fields{FieldRequestID: "req-1", FieldPod: "train-123", FieldNamespace: "kubeflow"}, entries[0].Data)
+	assert.Equal(t, logrus.Fields{FieldRequestID: "req-1", FieldNamespace: "default"}, entries[1].Data)
+	assert.Empty(t, entries[2].Data)
+}
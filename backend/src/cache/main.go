@@ -15,73 +15,296 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"path/filepath"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
 	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
 )
 
 const (
-	TLSDir      string = "/etc/webhook/certs"
-	TLSCertFile string = "cert.pem"
-	TLSKeyFile  string = "key.pem"
-)
+	MutateAPI         string = "/mutate"
+	ValidateAPI       string = "/validate"
+	WorkflowMutateAPI string = "/mutate-workflow"
 
-const (
-	MutateAPI   string = "/mutate"
-	WebhookPort string = ":8443"
+	// DefaultCommand runs when no command is given, as in the manifests predating the commands.
+	DefaultCommand string = "webhook"
 )
 
-const (
-	initConnectionTimeout = "6m"
+// logger is replaced by the one configured with LOG_LEVEL and LOG_FORMAT once flags are parsed.
+var logger logrus.FieldLogger = logrus.StandardLogger()
 
-	mysqlDBDriverDefault            = "mysql"
-	mysqlDBHostDefault              = "mysql"
-	mysqlDBPortDefault              = "3306"
-	mysqlDBGroupConcatMaxLenDefault = "4194304"
-)
+// command is a subcommand of the cache server. Every command takes the options of the
+// configuration, registerFlags adds the options of the command only.
+type command interface {
+	registerFlags(flags *flag.FlagSet)
+	run(cfg *config.Config, configuredLogger *logrus.Logger)
+}
 
-type WhSvrDBParameters struct {
-	dbDriver            string
-	dbHost              string
-	dbPort              string
-	dbName              string
-	dbUser              string
-	dbPwd               string
-	dbGroupConcatMaxLen string
-	namespaceToWatch    string
+// commands creates the command of each name.
+var commands = map[string]func() command{
+	"webhook":  func() command { return &webhookCommand{} },
+	"watcher":  func() command { return &watcherCommand{} },
+	"migrate":  func() command { return &migrateCommand{} },
+	"evaluate": func() command { return &evaluateCommand{} },
 }
 
 func main() {
-	var params WhSvrDBParameters
-	flag.StringVar(&params.dbDriver, "db_driver", mysqlDBDriverDefault, "Database driver name, mysql is the default value")
-	flag.StringVar(&params.dbHost, "db_host", mysqlDBHostDefault, "Database host name.")
-	flag.StringVar(&params.dbPort, "db_port", mysqlDBPortDefault, "Database port number.")
-	flag.StringVar(&params.dbName, "db_name", "cachedb", "Database name.")
-	flag.StringVar(&params.dbUser, "db_user", "root", "Database user name.")
-	flag.StringVar(&params.dbPwd, "db_password", "", "Database password.")
-	flag.StringVar(&params.dbGroupConcatMaxLen, "db_group_concat_max_len", mysqlDBGroupConcatMaxLenDefault, "Database group concat max length.")
-	flag.StringVar(&params.namespaceToWatch, "namespace_to_watch", "kubeflow", "Namespace to watch.")
-
-	flag.Parse()
-
-	log.Println("Initing client manager....")
-	clientManager := NewClientManager(params)
-
-	go server.WatchPods(params.namespaceToWatch, &clientManager)
-
-	certPath := filepath.Join(TLSDir, TLSCertFile)
-	keyPath := filepath.Join(TLSDir, TLSKeyFile)
-
-	mux := http.NewServeMux()
-	mux.Handle(MutateAPI, server.AdmitFuncHandler(server.MutatePodIfCached, &clientManager))
-	server := &http.Server{
-		// We listen on port 8443 such that we do not need root privileges or extra capabilities for this server.
-		// The Service object will take care of mapping this port to the HTTPS port 443.
-		Addr:    WebhookPort,
-		Handler: mux,
-	}
-	log.Fatal(server.ListenAndServeTLS(certPath, keyPath))
+	name, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		logger.Fatal(err)
+	}
+	program := os.Args[0]
+	if len(args) < len(os.Args[1:]) {
+		program += " " + name
+	}
+	cmd := commands[name]()
+	cfg, err := loadCommand(cmd, newFlagSet(program, flag.ExitOnError), args, os.LookupEnv)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	configuredLogger := configureLogging(cfg)
+	buildInfo := server.GetBuildInfo()
+	logger.WithFields(logrus.Fields{
+		"command":         name,
+		"version":         buildInfo.Version,
+		"gitCommit":       buildInfo.GitCommit,
+		"buildDate":       buildInfo.BuildDate,
+		"goVersion":       buildInfo.GoVersion,
+		"cacheKeyVersion": buildInfo.CacheKeyVersion,
+	}).Info("Starting cache server")
+	logger.Infof("Starting with configuration:\n%s", cfg)
+
+	cmd.run(cfg, configuredLogger)
+}
+
+// parseCommand splits the arguments into the name of the command and its arguments. The first
+// argument names the command unless it is a flag, in which case all arguments are those of the
+// DefaultCommand.
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return DefaultCommand, args, nil
+	}
+	if _, exists := commands[args[0]]; !exists {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("unknown command %q, expected one of %s", args[0], strings.Join(names, ", "))
+	}
+	return args[0], args[1:], nil
+}
+
+// newFlagSet returns a flag set holding the flags libraries such as glog registered on the command
+// line flag set, which the server has always accepted.
+func newFlagSet(program string, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	flags := flag.NewFlagSet(program, errorHandling)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	return flags
+}
+
+// loadCommand registers the options of the command on flags and loads the configuration, whose
+// options are registered on the same flags.
+func loadCommand(cmd command, flags *flag.FlagSet, args []string, lookupEnv config.LookupEnvFunc) (*config.Config, error) {
+	cmd.registerFlags(flags)
+	return config.Load(flags, args, lookupEnv)
+}
+
+// configureLogging makes all packages log with the configured level and format.
+func configureLogging(cfg *config.Config) *logrus.Logger {
+	configuredLogger, err := logging.NewLogger(cfg.Observability.LogLevel, cfg.Observability.LogFormat, os.Stderr)
+	if err != nil {
+		logger.Fatalf("Invalid logging configuration: %v", err)
+	}
+	logger = configuredLogger
+	server.SetLogger(configuredLogger)
+	storage.SetLogger(configuredLogger)
+	config.SetLogger(configuredLogger)
+	client.SetLogger(configuredLogger)
+	// Entries of libraries using the standard logger are logged at info level.
+	log.SetFlags(0)
+	log.SetOutput(configuredLogger.WriterLevel(logrus.InfoLevel))
+	return configuredLogger
+}
+
+// startTracing exports the spans of the server and storage packages when an OTLP endpoint is
+// configured. It returns nil otherwise.
+func startTracing(cfg *config.Config) *sdktrace.TracerProvider {
+	if cfg.Observability.OTLPEndpoint == "" {
+		return nil
+	}
+	tracerProvider, err := tracing.NewTracerProvider(context.Background(), cfg.Observability.OTLPEndpoint)
+	if err != nil {
+		logger.Fatalf("Failed to create the OTLP trace exporter: %v", err)
+	}
+	server.SetTracerProvider(tracerProvider)
+	storage.SetTracerProvider(tracerProvider)
+	logger.Infof("Exporting traces to %s", cfg.Observability.OTLPEndpoint)
+	return tracerProvider
+}
+
+// stopTracing flushes the spans not exported yet.
+func stopTracing(tracerProvider *sdktrace.TracerProvider) {
+	if tracerProvider == nil {
+		return
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		logger.Warnf("Failed to flush traces: %v", err)
+	}
+}
+
+// watchConfiguration applies the reloadable settings of a changed configuration file, among them
+// the log level, and rotates the credentials of the stores until ctx is done.
+func watchConfiguration(ctx context.Context, cfg *config.Config, configuredLogger *logrus.Logger, clientManager *ClientManager, apply func(*config.Config)) {
+	go cfg.WatchFile(ctx, func(reloaded *config.Config) {
+		if level, err := logrus.ParseLevel(reloaded.Observability.LogLevel); err == nil {
+			configuredLogger.SetLevel(level)
+		}
+		if apply != nil {
+			apply(reloaded)
+		}
+	})
+	// SIGHUP makes the credential files be read again right away, e.g. after rotating a Secret.
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go cfg.WatchCredentials(ctx, hangups, clientManager.RotateCredentials)
+}
+
+// newStatsCollector returns the collector of the stats served by both the health server and the
+// gRPC server.
+func newStatsCollector(cfg *config.Config, clientManager *ClientManager) *server.StatsCollector {
+	return server.NewStatsCollector(clientManager.StatsStore(), cfg.Listener.StatsCacheInterval, util.NewRealTime())
+}
+
+// newHealthServer returns the plain HTTP server of the probes, metrics, build metadata, stats and
+// admin API.
+// The self test is served when given, the leadership of the watchers reported when electing a
+// leader and the validity of the serving certificate checked when serving TLS.
+func newHealthServer(cfg *config.Config, clientManager *ClientManager, statsCollector *server.StatsCollector, selfTest *server.SelfTest, leadership *server.WatcherLeadership, certificate *server.CertificateReloader, metrics server.MutationMetrics) *http.Server {
+	checks := clientManager.ReadinessChecks(cfg.Listener.HealthDBTimeout, cfg.Listener.HealthRedisTimeout)
+	if certificate != nil {
+		checks = append(checks, certificate.ReadinessCheck())
+	}
+	if leadership != nil {
+		checks = append(checks, leadership.ReadinessCheck())
+	}
+	healthMux := http.NewServeMux()
+	healthMux.Handle(server.HealthzAPI, server.HealthzHandler())
+	healthMux.Handle(server.ReadyzAPI, server.ReadyzHandler(checks))
+	healthMux.Handle(server.MetricsAPI, promhttp.Handler())
+	healthMux.Handle(server.VersionAPI, server.VersionHandler())
+	if selfTest != nil {
+		healthMux.Handle(server.SelfTestAPI, server.SelfTestHandler(selfTest))
+	}
+	// Every /v1/ API requires an admin token and rejects every request until one is set, which may
+	// rotate in later.
+	adminMux := http.NewServeMux()
+	adminMux.Handle(server.StatsAPI, server.StatsHandler(statsCollector))
+	if adminStore := clientManager.AdminStore(); adminStore != nil {
+		adminHandler := server.AdminHandler(adminStore)
+		adminMux.Handle(server.AdminEntriesAPI, adminHandler)
+		adminMux.Handle(server.AdminEntriesAPI+"/", adminHandler)
+		adminMux.Handle(server.AdminInvalidateAPI, adminHandler)
+	} else if cfg.Listener.AdminToken != "" {
+		logger.Warnf("The admin API is not supported by the %s cache store, the admin token only authorizes the stats", cfg.Cache.Store)
+	}
+	if reuseStore := clientManager.ReuseStore(); reuseStore != nil {
+		adminMux.Handle(server.CacheRunsAPI+"/", server.CacheRunsHandler(reuseStore))
+	}
+	healthMux.Handle(server.AdminAPIPrefix, server.RequireAdminToken(clientManager.AdminToken(), adminMux))
+	return &http.Server{
+		Addr:    ":" + cfg.Listener.HealthPort,
+		Handler: server.RecoverPanics(healthMux, metrics),
+	}
+}
+
+// startGRPCServer serves the admin API over gRPC on the gRPC port, behind the admin tokens. It
+// returns nil when no gRPC port is set.
+func startGRPCServer(cfg *config.Config, clientManager *ClientManager, statsCollector *server.StatsCollector) *grpc.Server {
+	if cfg.Listener.GRPCPort == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", ":"+cfg.Listener.GRPCPort)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	adminStore := clientManager.AdminStore()
+	if adminStore == nil {
+		logger.Warnf("The admin API is not supported by the %s cache store, the gRPC server only serves the stats", cfg.Cache.Store)
+	}
+	grpcServer := server.NewAdminGRPCServer(clientManager.AdminToken(), server.NewAdminService(adminStore, statsCollector))
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Fatal(err)
+		}
+	}()
+	return grpcServer
+}
+
+// newDebugServer returns the server of the pprof profiles when enabled and of the recent decisions
+// of the recorder, if any, on the pprof address, or nil when there is nothing to serve. Like the
+// /v1/ APIs, the decisions require an admin token.
+func newDebugServer(cfg *config.Config, adminToken *server.AdminToken, decisions *server.DecisionRecorder, metrics server.MutationMetrics) *http.Server {
+	if !cfg.Observability.Pprof.Enabled && decisions == nil {
+		return nil
+	}
+	debugMux := http.NewServeMux()
+	debugMux.Handle(server.PprofAPI, server.NewPprofHandler(cfg.Observability.Pprof))
+	if decisions != nil {
+		debugMux.Handle(server.DecisionsAPI, server.RequireAdminToken(adminToken, server.DecisionsHandler(decisions)))
+	}
+	return &http.Server{
+		Addr:    cfg.Observability.PprofAddress,
+		Handler: server.RecoverPanics(debugMux, metrics),
+	}
+}
+
+// startDebugServer serves the server of newDebugServer, if any, in the background.
+func startDebugServer(cfg *config.Config, adminToken *server.AdminToken, decisions *server.DecisionRecorder, metrics server.MutationMetrics) *http.Server {
+	debugServer := newDebugServer(cfg, adminToken, decisions, metrics)
+	if debugServer == nil {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(cfg.Observability.PprofAddress); err != nil || !isLoopbackHost(host) {
+		logger.Warnf("Debug endpoints are served on %s and reachable from the pod network", cfg.Observability.PprofAddress)
+	}
+	go func() {
+		if err := debugServer.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Fatal(err)
+		}
+	}()
+	return debugServer
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
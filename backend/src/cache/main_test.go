@@ -0,0 +1,242 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupEnvOf(env map[string]string) config.LookupEnvFunc {
+	return func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantName string
+		wantArgs []string
+		wantErr  string
+	}{
+		{name: "no arguments", args: nil, wantName: "webhook", wantArgs: nil},
+		{name: "flags only", args: []string{"--db_host=mysql", "-namespace_to_watch", "kubeflow"}, wantName: "webhook", wantArgs: []string{"--db_host=mysql", "-namespace_to_watch", "kubeflow"}},
+		{name: "webhook", args: []string{"webhook", "--db_host=mysql"}, wantName: "webhook", wantArgs: []string{"--db_host=mysql"}},
+		{name: "watcher", args: []string{"watcher"}, wantName: "watcher", wantArgs: []string{}},
+		{name: "migrate", args: []string{"migrate", "--migration_batch_size=10"}, wantName: "migrate", wantArgs: []string{"--migration_batch_size=10"}},
+		{name: "evaluate", args: []string{"evaluate", "--file=review.json"}, wantName: "evaluate", wantArgs: []string{"--file=review.json"}},
+		{name: "unknown command", args: []string{"serve"}, wantErr: `unknown command "serve", expected one of evaluate, migrate, watcher, webhook`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, args, err := parseCommand(test.args)
+			if test.wantErr != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, test.wantErr, err.Error())
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, test.wantName, name)
+			assert.Equal(t, test.wantArgs, args)
+		})
+	}
+}
+
+// loadArgs loads the command named by the arguments the way main does.
+func loadArgs(t *testing.T, args []string, env map[string]string) (command, *config.Config, *flag.FlagSet) {
+	name, commandArgs, err := parseCommand(args)
+	require.Nil(t, err)
+	cmd := commands[name]()
+	flags := newFlagSet("cache_server", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	cfg, err := loadCommand(cmd, flags, commandArgs, lookupEnvOf(env))
+	require.Nil(t, err)
+	return cmd, cfg, flags
+}
+
+// usage returns the help text of the flags.
+func usage(flags *flag.FlagSet) string {
+	var out bytes.Buffer
+	flags.SetOutput(&out)
+	flags.PrintDefaults()
+	return out.String()
+}
+
+func TestWebhookIsTheDefaultCommand(t *testing.T) {
+	// The arguments of the manifests predating the commands.
+	legacyArgs := []string{
+		"--db_driver=mysql",
+		"--db_host=mysql",
+		"--db_port=3306",
+		"--db_name=cachedb",
+		"--db_user=root",
+		"--db_password=",
+		"--namespace_to_watch=kubeflow",
+	}
+	env := map[string]string{"LOG_LEVEL": "debug", "CACHE_STORE_BACKEND": "mysql"}
+
+	legacy, legacyConfig, legacyFlags := loadArgs(t, legacyArgs, env)
+	explicit, explicitConfig, explicitFlags := loadArgs(t, append([]string{"webhook"}, legacyArgs...), env)
+
+	require.IsType(t, &webhookCommand{}, legacy)
+	assert.Equal(t, legacy, explicit)
+	assert.True(t, legacy.(*webhookCommand).watchPods, "the webhook records pod outputs as before")
+	assert.Equal(t, legacyConfig.String(), explicitConfig.String())
+	assert.Equal(t, usage(legacyFlags), usage(explicitFlags))
+
+	// Today's options are all accepted, the webhook only adds watch_pods and the self test.
+	today := newFlagSet("cache_server", flag.ContinueOnError)
+	today.SetOutput(ioutil.Discard)
+	_, err := config.Load(today, legacyArgs, lookupEnvOf(env))
+	require.Nil(t, err)
+	var added []string
+	legacyFlags.VisitAll(func(f *flag.Flag) {
+		if known := today.Lookup(f.Name); known == nil {
+			added = append(added, f.Name)
+		} else {
+			assert.Equal(t, known.DefValue, f.DefValue, f.Name)
+			assert.Equal(t, known.Value.String(), f.Value.String(), f.Name)
+		}
+	})
+	assert.Equal(t, []string{"self-test", "self_test", "watch_pods"}, added)
+}
+
+func TestSelfTestFlag(t *testing.T) {
+	for _, flag := range []string{"--self-test", "--self_test"} {
+		cmd, _, _ := loadArgs(t, []string{flag}, nil)
+		require.IsType(t, &webhookCommand{}, cmd)
+		assert.True(t, cmd.(*webhookCommand).selfTest, flag)
+	}
+}
+
+func TestRequestSelfTest(t *testing.T) {
+	status := http.StatusOK
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, server.SelfTestAPI, r.URL.Path)
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status":"report"}`))
+	}))
+	defer health.Close()
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, requestSelfTest(health.URL+server.SelfTestAPI, time.Second, &out))
+	assert.Equal(t, "{\"status\":\"report\"}\n", out.String())
+
+	status = http.StatusServiceUnavailable
+	out.Reset()
+	assert.Equal(t, 1, requestSelfTest(health.URL+server.SelfTestAPI, time.Second, &out))
+	assert.Equal(t, "{\"status\":\"report\"}\n", out.String())
+
+	// The webhook is not serving yet.
+	health.Close()
+	out.Reset()
+	assert.Equal(t, 1, requestSelfTest(health.URL+server.SelfTestAPI, time.Second, &out))
+	assert.Contains(t, out.String(), "Self test failed")
+}
+
+func TestCommandsShareTheConfiguration(t *testing.T) {
+	args := []string{"--db_host=mysql.kubeflow", "--namespace_to_watch=team-a"}
+	env := map[string]string{"CACHE_PARTITION_BY": "month"}
+	_, webhookConfig, _ := loadArgs(t, append([]string{"webhook", "--watch_pods=false"}, args...), env)
+	watcher, watcherConfig, _ := loadArgs(t, append([]string{"watcher"}, args...), env)
+	migrate, migrateConfig, _ := loadArgs(t, append([]string{"migrate", "--migration_batch_size=50"}, args...), env)
+
+	assert.Equal(t, webhookConfig.String(), watcherConfig.String())
+	assert.Equal(t, webhookConfig.String(), migrateConfig.String())
+	assert.Equal(t, &watcherCommand{}, watcher)
+	assert.Equal(t, &migrateCommand{batchSize: 50}, migrate)
+}
+
+func TestCommandsRejectTheFlagsOfOtherCommands(t *testing.T) {
+	flags := newFlagSet("cache_server watcher", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+
+	_, err := loadCommand(&watcherCommand{}, flags, []string{"--watch_pods=false"}, lookupEnvOf(nil))
+
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "flag provided but not defined: -watch_pods")
+}
+
+// newAdminTokenClientManager returns the configuration and client manager of a memory store with
+// the admin token.
+func newAdminTokenClientManager(t *testing.T, token string) (*config.Config, *ClientManager) {
+	flags := newFlagSet("cache_server", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	cfg, err := config.Load(flags, nil, lookupEnvOf(map[string]string{
+		"CACHE_STORE_BACKEND": config.StoreMemory,
+		"CACHE_ADMIN_TOKEN":   token,
+	}))
+	require.Nil(t, err)
+	clientManager := NewClientManager(cfg)
+	t.Cleanup(func() { clientManager.Close() })
+	return cfg, clientManager
+}
+
+// serveWithAuthorization returns the status of a GET request of the path presenting the
+// authorization, if any.
+func serveWithAuthorization(handler http.Handler, path string, authorization string) int {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	return rr.Code
+}
+
+func TestDebugDecisionsRequireAdminToken(t *testing.T) {
+	cfg, clientManager := newAdminTokenClientManager(t, "admin-secret")
+	debugServer := newDebugServer(cfg, clientManager.AdminToken(), server.NewDecisionRecorder(10), nil)
+	require.NotNil(t, debugServer)
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithAuthorization(debugServer.Handler, server.DecisionsAPI, ""))
+	assert.Equal(t, http.StatusUnauthorized, serveWithAuthorization(debugServer.Handler, server.DecisionsAPI, "Bearer wrong"))
+	assert.Equal(t, http.StatusOK, serveWithAuthorization(debugServer.Handler, server.DecisionsAPI, "Bearer admin-secret"))
+}
+
+func TestOnlyProbesAndMetricsAreServedWithoutAdminToken(t *testing.T) {
+	cfg, clientManager := newAdminTokenClientManager(t, "admin-secret")
+	healthServer := newHealthServer(cfg, clientManager, newStatsCollector(cfg, clientManager), nil, nil, nil, nil)
+	debugServer := newDebugServer(cfg, clientManager.AdminToken(), server.NewDecisionRecorder(10), nil)
+	require.NotNil(t, debugServer)
+
+	for _, path := range []string{server.HealthzAPI, server.ReadyzAPI, server.MetricsAPI, server.VersionAPI} {
+		assert.Equal(t, http.StatusOK, serveWithAuthorization(healthServer.Handler, path, ""), path)
+	}
+	for _, route := range []struct {
+		handler http.Handler
+		path    string
+	}{
+		{healthServer.Handler, server.StatsAPI},
+		{healthServer.Handler, server.AdminEntriesAPI},
+		{debugServer.Handler, server.DecisionsAPI},
+	} {
+		assert.Equal(t, http.StatusUnauthorized, serveWithAuthorization(route.handler, route.path, ""), route.path)
+		assert.Equal(t, http.StatusOK, serveWithAuthorization(route.handler, route.path, "Bearer admin-secret"), route.path)
+	}
+}
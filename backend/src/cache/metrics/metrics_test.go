@@ -0,0 +1,47 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterOrReuseReturnsTheRegisteredCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+	second := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+
+	registered, err := RegisterOrReuse(registry, first)
+	assert.Nil(t, err)
+	assert.Equal(t, first, registered)
+	registered, err = RegisterOrReuse(registry, second)
+	assert.Nil(t, err)
+	assert.Equal(t, first, registered, "the collector registered first keeps being exported")
+}
+
+func TestRegisterOrReuseReturnsConflicts(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_total", Help: "Another help."})
+
+	_, err := RegisterOrReuse(registry, counter)
+	assert.Nil(t, err)
+	registered, err := RegisterOrReuse(registry, gauge)
+	assert.NotNil(t, err)
+	assert.Equal(t, gauge, registered)
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+)
+
+// migrateCommand creates and updates the tables of the database store and exits, e.g. from an
+// init container or a Job ahead of an upgrade.
+type migrateCommand struct {
+	batchSize int
+}
+
+func (c *migrateCommand) registerFlags(flags *flag.FlagSet) {
+	flags.IntVar(&c.batchSize, "migration_batch_size", partitionMigrationBatchSize, "Number of execution caches moved into their monthly partition per transaction.")
+}
+
+func (c *migrateCommand) run(cfg *config.Config, configuredLogger *logrus.Logger) {
+	if c.batchSize < 1 {
+		logger.Fatalf("The migration batch size must be at least 1, got %d", c.batchSize)
+	}
+	if cfg.Cache.Store != config.StoreMySQL {
+		logger.Infof("The %s cache store has no tables to migrate", cfg.Cache.Store)
+		return
+	}
+	timeoutDuration, _ := time.ParseDuration(DefaultConnectionTimeout)
+	// The tables of the execution caches are created and updated when connecting.
+	db, _ := initDBClient(cfg.DB, timeoutDuration)
+	defer db.Close()
+	if cfg.Cache.PartitionBy == storage.PartitionByMonth {
+		store := storage.NewPartitionedExecutionCacheStore(db, util.NewRealTime(), cfg.Cache.PartitionLookback)
+		migrated, err := store.MigrateLegacyExecutionCaches(c.batchSize)
+		if err != nil {
+			logger.Fatalf("Failed to migrate execution caches into partitions: %v", err)
+		}
+		logger.Infof("Migrated %d execution caches into monthly partitions", migrated)
+	}
+	if _, err := storage.NewCacheReuseStore(db); err != nil {
+		logger.Fatalf("Failed to create the cache reuse table: %v", err)
+	}
+	if _, err := storage.NewScrubCursorStore(db); err != nil {
+		logger.Fatalf("Failed to create the scrub cursor table: %v", err)
+	}
+	if cfg.Audit.Sink == server.AuditSinkDB {
+		if _, err := storage.NewAuditEventStore(db); err != nil {
+			logger.Fatalf("Failed to create the audit table: %v", err)
+		}
+	}
+	logger.Info("Migration complete")
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "time"
+
+// AuditEvent records the cache decision the webhook made about a pod, and the inputs it was based
+// on, or the deletion of an entry by a background job such as the artifact scrubber.
+type AuditEvent struct {
+	ID        int64     `gorm:"column:ID; not null; primary_key; AUTO_INCREMENT" json:"-"`
+	Timestamp time.Time `gorm:"column:Timestamp; not null; index:idx_audit_timestamp" json:"timestamp"`
+	// RequestID is the UID of the AdmissionRequest, or the name of the background job.
+	RequestID       string `gorm:"column:RequestID; not null" json:"requestId"`
+	Namespace       string `gorm:"column:Namespace; not null" json:"namespace"`
+	PodName         string `gorm:"column:PodName; not null" json:"podName,omitempty"`
+	PodGenerateName string `gorm:"column:PodGenerateName; not null" json:"podGenerateName,omitempty"`
+	// NodeName is the Argo node of the pod.
+	NodeName string `gorm:"column:NodeName; not null" json:"nodeName,omitempty"`
+	CacheKey string `gorm:"column:CacheKey; not null" json:"cacheKey,omitempty"`
+	// Decision is the outcome of the admission, e.g. hit, miss or the reason the lookup was skipped.
+	Decision string `gorm:"column:Decision; not null" json:"decision"`
+	// CacheEntryID is the ID of the reused entry on hits.
+	CacheEntryID int64 `gorm:"column:CacheEntryID; not null" json:"cacheEntryId,omitempty"`
+
+	// CacheEnabled, MaxCacheStaleness and Owner are the inputs of the pod, EnforceOwner and
+	// FailPolicy those of the webhook.
+	CacheEnabled      bool   `gorm:"column:CacheEnabled; not null" json:"cacheEnabled"`
+	MaxCacheStaleness string `gorm:"column:MaxCacheStaleness; not null" json:"maxCacheStaleness,omitempty"`
+	Owner             string `gorm:"column:Owner; not null" json:"owner,omitempty"`
+	EnforceOwner      bool   `gorm:"column:EnforceOwner; not null" json:"enforceOwner"`
+	FailPolicy        string `gorm:"column:FailPolicy; not null" json:"failPolicy,omitempty"`
+}
+
+// GetModelName returns the name of AuditEvent.
+func (e *AuditEvent) GetModelName() string {
+	return "auditEvents"
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// CacheReuse records a node of a run that was served from a cache entry instead of running.
+type CacheReuse struct {
+	ID    int64  `gorm:"column:ID; not null; primary_key; AUTO_INCREMENT"`
+	RunID string `gorm:"column:RunID; not null; unique_index:idx_cache_reuse_run_node"`
+	// NodeID is the ID of the Argo node of the pod in the status of its Workflow, which is the name
+	// of the pod.
+	NodeID    string `gorm:"column:NodeID; not null; unique_index:idx_cache_reuse_run_node"`
+	NodeName  string `gorm:"column:NodeName; not null"`
+	Namespace string `gorm:"column:Namespace; not null"`
+	// CacheEntryID is the ID of the reused entry, and SourceRunID the run of the pod that produced
+	// it, empty when unknown.
+	CacheEntryID  int64  `gorm:"column:CacheEntryID; not null"`
+	SourceRunID   string `gorm:"column:SourceRunID; not null"`
+	ReusedAtInSec int64  `gorm:"column:ReusedAtInSec; not null"`
+}
+
+// GetModelName returns the name of CacheReuse.
+func (r *CacheReuse) GetModelName() string {
+	return "cacheReuses"
+}
@@ -14,6 +14,10 @@
 
 package model
 
+// LegacyKeyVersion is the key version of the entries recorded before entries recorded theirs, whose
+// keys were all generated by the first key strategy.
+const LegacyKeyVersion string = "1"
+
 type ExecutionCache struct {
 	ID                int64  `gorm:"column:ID; not null; primary_key; AUTO_INCREMENT"`
 	ExecutionCacheKey string `gorm:"column:ExecutionCacheKey; not null; index:idx_cache_key"`
@@ -22,6 +26,40 @@ type ExecutionCache struct {
 	MaxCacheStaleness int64  `gorm:"column:MaxCacheStaleness; not null"`
 	StartedAtInSec    int64  `gorm:"column:StartedAtInSec; not null"`
 	EndedAtInSec      int64  `gorm:"column:EndedAtInSec; not null"`
+	// Owner is the KFP profile or service account that produced the entry. Entries without an owner
+	// are shared and reusable by everyone.
+	Owner string `gorm:"column:Owner; not null; default:''"`
+	// ExecutionDurationInSec is the wall-clock time the execution took, from the start of its
+	// first container to the end of its last. It is 0 for entries recorded without it.
+	ExecutionDurationInSec int64 `gorm:"column:ExecutionDurationInSec; not null; default:0"`
+	// PipelineName, PipelineVersionID and RunID are the pipeline, pipeline version and run of the
+	// pod that produced the entry, empty when unknown, so that the entries of a pipeline, pipeline
+	// version or run can be invalidated at once.
+	PipelineName      string `gorm:"column:PipelineName; not null; default:''"`
+	PipelineVersionID string `gorm:"column:PipelineVersionID; not null; default:''"`
+	RunID             string `gorm:"column:RunID; not null; default:''"`
+	// Namespace is the namespace of the pod that produced the entry, which the entry counts
+	// against the quota of. It is empty for the entries recorded before it was.
+	Namespace string `gorm:"column:Namespace; not null; default:''; index:idx_namespace"`
+	// LastUsedAtInSec is when the entry was last reused, or created, so that the least recently
+	// used entries of a namespace over its quota are evicted first.
+	LastUsedAtInSec int64 `gorm:"column:LastUsedAtInSec; not null; default:0"`
+	// UseCount is how many times the reuse of the entry was recorded, at most once a minute by
+	// each webhook, so that the least frequently used entries are evicted first under the lfu
+	// eviction policy.
+	UseCount int64 `gorm:"column:UseCount; not null; default:0"`
+	// ClusterID is the cluster whose watcher recorded the entry, when clusters share the cache
+	// store. It is empty for the entries recorded without one.
+	ClusterID string `gorm:"column:ClusterID; not null; default:''"`
+	// KeyVersion is the version of the strategy that generated ExecutionCacheKey. Lookups only
+	// match the entries of the version they generated their key with. It is empty for the entries
+	// recorded without one, which are of LegacyKeyVersion.
+	KeyVersion string `gorm:"column:KeyVersion; not null; default:''"`
+	// ExpiresAtInSec is when the time-to-live of the entry ends, after which lookups miss it and
+	// the store deletes it. It is 0 for the entries that never expire, including those recorded
+	// before it was. Unlike MaxCacheStaleness, which only bounds the age of the entries the pods
+	// recording it reuse, it applies to every lookup.
+	ExpiresAtInSec int64 `gorm:"column:ExpiresAtInSec; not null; default:0; index:idx_expires_at"`
 }
 
 // GetValueOfPrimaryKey returns the value of ExecutionCacheKey.
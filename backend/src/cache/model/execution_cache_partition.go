@@ -0,0 +1,28 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// ExecutionCachePartition registers one time-based partition table of the execution cache. A
+// partition holds the entries whose StartedAtInSec falls in [StartsAtInSec, EndsAtInSec).
+type ExecutionCachePartition struct {
+	Name          string `gorm:"column:Name; not null; primary_key"`
+	StartsAtInSec int64  `gorm:"column:StartsAtInSec; not null; index:idx_partition_start"`
+	EndsAtInSec   int64  `gorm:"column:EndsAtInSec; not null"`
+}
+
+// GetModelName returns the name of ExecutionCachePartition.
+func (p *ExecutionCachePartition) GetModelName() string {
+	return "executionCachePartitions"
+}
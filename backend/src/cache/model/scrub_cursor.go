@@ -0,0 +1,29 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// ScrubCursor records where a background job walking the cache entries, such as the artifact
+// scrubber, stopped, so that it resumes from there after a restart.
+type ScrubCursor struct {
+	Name string `gorm:"column:Name; not null; primary_key"`
+	// Cursor is the page token of the entries left to walk, empty to start over.
+	Cursor         string `gorm:"column:Cursor; not null"`
+	UpdatedAtInSec int64  `gorm:"column:UpdatedAtInSec; not null"`
+}
+
+// GetModelName returns the name of ScrubCursor.
+func (c *ScrubCursor) GetModelName() string {
+	return "scrubCursors"
+}
@@ -0,0 +1,356 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package outputs reads the workflows.argoproj.io/outputs annotation that Argo's wait container
+// sets on the pods it ran, and writes it back in a canonical form.
+//
+// The annotation is found as plain JSON or as base64 encoded gzipped JSON, with or without
+// artifacts, and with the field names of Argo 2.x or 3.x, which differ in casing. The fields
+// downstream steps depend on, such as the exit code, are kept, and so are the fields unknown to
+// this package.
+package outputs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Canonical names of the fields of the outputs and of their parameters and artifacts.
+const (
+	fieldParameters string = "parameters"
+	fieldArtifacts  string = "artifacts"
+	fieldResult     string = "result"
+	fieldExitCode   string = "exitCode"
+	fieldName       string = "name"
+	fieldValue      string = "value"
+	fieldValueFrom  string = "valueFrom"
+	fieldDefault    string = "default"
+	fieldGlobalName string = "globalName"
+	// fieldS3 is the location of the artifacts stored in S3, which is not canonicalized.
+	fieldS3 string = "s3"
+)
+
+// Outputs are the outputs of an Argo step.
+type Outputs struct {
+	Parameters []Parameter
+	Artifacts  []Artifact
+	// Result is the standard output of script templates.
+	Result *string
+	// ExitCode is the exit code of the main container, which conditions of later steps may test.
+	ExitCode *string
+	// Other holds the fields unknown to this package by name, kept as they are.
+	Other map[string]json.RawMessage
+}
+
+// Parameter is an output parameter. Its value is a string, whichever JSON value Argo wrote.
+type Parameter struct {
+	Name       string
+	Value      *string
+	Default    *string
+	GlobalName string
+	// ValueFrom is where Argo read the value from, e.g. a path of the main container.
+	ValueFrom json.RawMessage
+	Other     map[string]json.RawMessage
+}
+
+// Artifact is an output artifact. Its location, archive settings and other fields are kept as
+// they are.
+type Artifact struct {
+	Name  string
+	Other map[string]json.RawMessage
+}
+
+// Parse decodes the outputs annotation, as plain JSON or as base64 encoded gzipped JSON.
+func Parse(annotation string) (*Outputs, error) {
+	decoded, err := decode(annotation)
+	if err != nil {
+		return nil, err
+	}
+	var outputs Outputs
+	if err := json.Unmarshal(decoded, &outputs); err != nil {
+		return nil, fmt.Errorf("invalid outputs: %v", err)
+	}
+	return &outputs, nil
+}
+
+// Normalize returns the outputs annotation in canonical form: plain compact JSON with the field
+// names of Argo 3.x, in alphabetical order.
+func Normalize(annotation string) (string, error) {
+	outputs, err := Parse(annotation)
+	if err != nil {
+		return "", err
+	}
+	return outputs.Canonical()
+}
+
+// Canonical returns the outputs in canonical form.
+func (o *Outputs) Canonical() (string, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return "", fmt.Errorf("outputs cannot be encoded: %v", err)
+	}
+	return string(b), nil
+}
+
+func decode(annotation string) ([]byte, error) {
+	annotation = strings.TrimSpace(annotation)
+	if strings.HasPrefix(annotation, "{") {
+		return []byte(annotation), nil
+	}
+	if annotation == "" {
+		return nil, fmt.Errorf("empty outputs")
+	}
+	compressed, err := base64.StdEncoding.DecodeString(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("outputs are neither JSON nor base64: %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("base64 outputs are not gzipped: %v", err)
+	}
+	defer reader.Close()
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gzipped outputs are truncated: %v", err)
+	}
+	return decompressed, nil
+}
+
+func (o *Outputs) UnmarshalJSON(b []byte) error {
+	fields, err := decodeFields(b)
+	if err != nil {
+		return err
+	}
+	*o = Outputs{}
+	for name, value := range fields {
+		switch name {
+		case fieldParameters:
+			err = json.Unmarshal(value, &o.Parameters)
+		case fieldArtifacts:
+			err = json.Unmarshal(value, &o.Artifacts)
+		case fieldResult:
+			o.Result, err = decodeString(value)
+		case fieldExitCode:
+			o.ExitCode, err = decodeString(value)
+		default:
+			o.Other = keep(o.Other, name, value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (o Outputs) MarshalJSON() ([]byte, error) {
+	fields := copyFields(o.Other)
+	if len(o.Parameters) > 0 {
+		parameters, err := json.Marshal(o.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldParameters] = parameters
+	}
+	if len(o.Artifacts) > 0 {
+		artifacts, err := json.Marshal(o.Artifacts)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldArtifacts] = artifacts
+	}
+	setString(fields, fieldResult, o.Result)
+	setString(fields, fieldExitCode, o.ExitCode)
+	return json.Marshal(fields)
+}
+
+func (p *Parameter) UnmarshalJSON(b []byte) error {
+	fields, err := decodeFields(b)
+	if err != nil {
+		return err
+	}
+	*p = Parameter{}
+	for name, value := range fields {
+		var s *string
+		switch name {
+		case fieldName:
+			if s, err = decodeString(value); s != nil {
+				p.Name = *s
+			}
+		case fieldValue:
+			p.Value, err = decodeString(value)
+		case fieldDefault:
+			p.Default, err = decodeString(value)
+		case fieldGlobalName:
+			if s, err = decodeString(value); s != nil {
+				p.GlobalName = *s
+			}
+		case fieldValueFrom:
+			p.ValueFrom = value
+		default:
+			p.Other = keep(p.Other, name, value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid parameter %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (p Parameter) MarshalJSON() ([]byte, error) {
+	fields := copyFields(p.Other)
+	setString(fields, fieldName, &p.Name)
+	setString(fields, fieldValue, p.Value)
+	setString(fields, fieldDefault, p.Default)
+	if p.GlobalName != "" {
+		setString(fields, fieldGlobalName, &p.GlobalName)
+	}
+	if len(p.ValueFrom) > 0 {
+		fields[fieldValueFrom] = p.ValueFrom
+	}
+	return json.Marshal(fields)
+}
+
+// S3Location returns the bucket and key of the artifact when it is stored in S3 or an S3
+// compatible object store such as MinIO. The bucket is empty when the artifact repository's bucket
+// applies. ok is false for the artifacts stored elsewhere.
+func (a Artifact) S3Location() (bucket string, key string, ok bool) {
+	raw, exists := a.Other[fieldS3]
+	if !exists {
+		return "", "", false
+	}
+	var location struct {
+		Bucket string `json:"bucket"`
+		Key    string `json:"key"`
+	}
+	if err := json.Unmarshal(raw, &location); err != nil || location.Key == "" {
+		return "", "", false
+	}
+	return location.Bucket, location.Key, true
+}
+
+func (a *Artifact) UnmarshalJSON(b []byte) error {
+	fields, err := decodeFields(b)
+	if err != nil {
+		return err
+	}
+	*a = Artifact{}
+	for name, value := range fields {
+		if name != fieldName {
+			a.Other = keep(a.Other, name, value)
+			continue
+		}
+		s, err := decodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid artifact name: %v", err)
+		}
+		if s != nil {
+			a.Name = *s
+		}
+	}
+	return nil
+}
+
+func (a Artifact) MarshalJSON() ([]byte, error) {
+	fields := copyFields(a.Other)
+	setString(fields, fieldName, &a.Name)
+	return json.Marshal(fields)
+}
+
+// canonicalFields maps the field names, lower cased and without separators, to their canonical
+// names.
+var canonicalFields = map[string]string{}
+
+func init() {
+	for _, name := range []string{fieldParameters, fieldArtifacts, fieldResult, fieldExitCode,
+		fieldName, fieldValue, fieldValueFrom, fieldDefault, fieldGlobalName} {
+		canonicalFields[foldFieldName(name)] = name
+	}
+}
+
+// foldFieldName folds the casings of a field name, e.g. exitCode, ExitCode and exit_code.
+func foldFieldName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// decodeFields decodes a JSON object by canonical field name. Unknown fields keep their name.
+func decodeFields(b []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("null instead of an object")
+	}
+	canonical := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		if known, ok := canonicalFields[foldFieldName(name)]; ok {
+			name = known
+		}
+		canonical[name] = value
+	}
+	return canonical, nil
+}
+
+// decodeString decodes a string, number or boolean as a string, and null as nil.
+func decodeString(value json.RawMessage) (*string, error) {
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	var s string
+	switch decoded := decoded.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		s = decoded
+	case json.Number:
+		s = decoded.String()
+	case bool:
+		s = strconv.FormatBool(decoded)
+	default:
+		return nil, fmt.Errorf("%s is not a string", value)
+	}
+	return &s, nil
+}
+
+func keep(fields map[string]json.RawMessage, name string, value json.RawMessage) map[string]json.RawMessage {
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+	fields[name] = value
+	return fields
+}
+
+func copyFields(fields map[string]json.RawMessage) map[string]json.RawMessage {
+	copied := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		copied[name] = value
+	}
+	return copied
+}
+
+// setString sets the field to the string unless nil. Strings always encode.
+func setString(fields map[string]json.RawMessage, name string, value *string) {
+	if value != nil {
+		fields[name], _ = json.Marshal(*value)
+	}
+}
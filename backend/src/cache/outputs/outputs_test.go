@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the tests.")
+
+func TestNormalizeMatchesGoldenOutputs(t *testing.T) {
+	// The annotations are in the shape Argo's wait container writes them in each version.
+	for _, name := range []string{"argo_2.12", "argo_3.3", "argo_3.4", "without_artifacts", "mixed_casing", "gzip_base64"} {
+		t.Run(name, func(t *testing.T) {
+			annotation, err := ioutil.ReadFile(filepath.Join("testdata", name+".annotation"))
+			require.Nil(t, err)
+			normalized, err := Normalize(string(annotation))
+			require.Nil(t, err)
+
+			goldenPath := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				require.Nil(t, ioutil.WriteFile(goldenPath, []byte(normalized+"\n"), 0644))
+			}
+			golden, err := ioutil.ReadFile(goldenPath)
+			require.Nil(t, err)
+			assert.Equal(t, string(golden), normalized+"\n")
+
+			renormalized, err := Normalize(normalized)
+			require.Nil(t, err)
+			assert.Equal(t, normalized, renormalized, "canonical outputs normalize to themselves")
+		})
+	}
+}
+
+func TestParseKeepsExitCodeAndUnknownFields(t *testing.T) {
+	outputs, err := Parse(`{"exitCode":"137","parameters":[{"name":"p","value":"v","enum":["v"]}],"custom":{"a":1}}`)
+	require.Nil(t, err)
+
+	require.NotNil(t, outputs.ExitCode)
+	assert.Equal(t, "137", *outputs.ExitCode)
+	require.Len(t, outputs.Parameters, 1)
+	assert.Equal(t, `["v"]`, string(outputs.Parameters[0].Other["enum"]))
+	assert.Equal(t, `{"a":1}`, string(outputs.Other["custom"]))
+	canonical, err := outputs.Canonical()
+	require.Nil(t, err)
+	assert.Equal(t, `{"custom":{"a":1},"exitCode":"137","parameters":[{"enum":["v"],"name":"p","value":"v"}]}`, canonical)
+}
+
+func TestParseDecodesScalarsAsStrings(t *testing.T) {
+	outputs, err := Parse(`{"exitCode":2,"parameters":[{"name":"n","value":1.50},{"name":"b","value":true},{"name":"z","value":null}]}`)
+	require.Nil(t, err)
+
+	assert.Equal(t, "2", *outputs.ExitCode)
+	assert.Equal(t, "1.50", *outputs.Parameters[0].Value)
+	assert.Equal(t, "true", *outputs.Parameters[1].Value)
+	assert.Nil(t, outputs.Parameters[2].Value)
+}
+
+func TestParseRejectsInvalidOutputs(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+	}{
+		{name: "empty", annotation: ""},
+		{name: "null", annotation: "null"},
+		{name: "truncated JSON", annotation: `{"parameters":[`},
+		{name: "not base64", annotation: "not outputs"},
+		{name: "base64 but not gzipped", annotation: "bm90IGd6aXBwZWQ="},
+		{name: "parameters not a list", annotation: `{"parameters":{"name":"p"}}`},
+		{name: "parameter value an object", annotation: `{"parameters":[{"name":"p","value":{"a":1}}]}`},
+		{name: "exit code a list", annotation: `{"exitCode":[0]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.annotation)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestArtifactS3Location(t *testing.T) {
+	outputs, err := Parse(`{"artifacts":[` +
+		`{"name":"model","s3":{"bucket":"mlpipeline","key":"artifacts/run/model.tgz"}},` +
+		`{"name":"default-bucket","s3":{"key":"artifacts/run/metrics.tgz"}},` +
+		`{"name":"gcs","gcs":{"bucket":"b","key":"k"}},` +
+		`{"name":"no-key","s3":{"bucket":"mlpipeline"}}]}`)
+	require.Nil(t, err)
+	require.Len(t, outputs.Artifacts, 4)
+
+	bucket, key, ok := outputs.Artifacts[0].S3Location()
+	assert.True(t, ok)
+	assert.Equal(t, "mlpipeline", bucket)
+	assert.Equal(t, "artifacts/run/model.tgz", key)
+	bucket, key, ok = outputs.Artifacts[1].S3Location()
+	assert.True(t, ok)
+	assert.Equal(t, "", bucket)
+	assert.Equal(t, "artifacts/run/metrics.tgz", key)
+	for _, artifact := range outputs.Artifacts[2:] {
+		_, _, ok := artifact.S3Location()
+		assert.False(t, ok, artifact.Name)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// AdminEntriesAPI lists and imports the cache entries, and deletes those of a cache key.
+	// AdminEntriesAPI followed by /{id} gets and deletes a single entry.
+	AdminEntriesAPI string = "/v1/cache/entries"
+	// AdminInvalidateAPI deletes the entries of a pipeline, run, key prefix or age at once.
+	AdminInvalidateAPI string = "/v1/cache:invalidate"
+
+	// DefaultAdminPageSize is the number of entries listed when the page size is not given, and
+	// MaxAdminPageSize the largest page size accepted.
+	DefaultAdminPageSize int = 100
+	MaxAdminPageSize     int = 1000
+	// maxAdminKeyLength bounds the cache keys and key prefixes accepted.
+	maxAdminKeyLength int = 256
+	// maxAdminRequestBytes bounds the request bodies read, and maxAdminImportBytes those of the
+	// imported entries, which hold their outputs.
+	maxAdminRequestBytes int64 = 64 * 1024
+	maxAdminImportBytes  int64 = 8 * 1024 * 1024
+)
+
+// AdminEntry is a cache entry as served by the admin API. IDs are strings, as the IDs of the
+// partitioned store are too large for the numbers of JSON parsers in JavaScript.
+type AdminEntry struct {
+	ID                     string    `json:"id"`
+	CacheKey               string    `json:"cacheKey"`
+	Owner                  string    `json:"owner,omitempty"`
+	CreatedAt              time.Time `json:"createdAt"`
+	MaxCacheStaleness      int64     `json:"maxCacheStalenessInSec"`
+	ExecutionDurationInSec int64     `json:"executionDurationInSec"`
+	PipelineName           string    `json:"pipelineName,omitempty"`
+	PipelineVersionID      string    `json:"pipelineVersionId,omitempty"`
+	RunID                  string    `json:"runId,omitempty"`
+	// KeyVersion is the version of the key strategy of the cache key, empty for the entries of
+	// CacheKeyVersionV1 recorded without one.
+	KeyVersion string `json:"keyVersion,omitempty"`
+	// ExpiresAt is when the time-to-live of the entry ends, nil for the entries that never expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Template and Output are only served for a single entry and the lists of the full view, and
+	// are required to import an entry.
+	Template string `json:"template,omitempty"`
+	Output   string `json:"output,omitempty"`
+}
+
+// AdminEntryList is a page of entries. NextPageToken is passed as page_token to get the next
+// page and is empty after the last page.
+type AdminEntryList struct {
+	Entries       []AdminEntry `json:"entries"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+// AdminDeletion is the number of entries deleted by cache key.
+type AdminDeletion struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// AdminInvalidateRequest selects the entries invalidated at once, e.g. those of a buggy component.
+// The entries must match every selector given. All must be set to invalidate every entry without
+// selector.
+type AdminInvalidateRequest struct {
+	PipelineName string `json:"pipelineName,omitempty"`
+	// PipelineVersionID selects the entries of the pipeline version, e.g. the previous version of
+	// a pipeline once a new one is uploaded.
+	PipelineVersionID string `json:"pipelineVersionId,omitempty"`
+	RunID             string `json:"runId,omitempty"`
+	KeyPrefix         string `json:"keyPrefix,omitempty"`
+	// OlderThan selects the entries created before the RFC 3339 time.
+	OlderThan string `json:"olderThan,omitempty"`
+	All       bool   `json:"all,omitempty"`
+	// DryRun only counts the entries.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// AdminInvalidation is the number of entries invalidated, or that would be on a dry run.
+type AdminInvalidation struct {
+	Invalidated int64 `json:"invalidated"`
+	DryRun      bool  `json:"dryRun"`
+}
+
+// AdminError is the body of every failed admin request.
+type AdminError struct {
+	Error AdminErrorDetail `json:"error"`
+}
+
+type AdminErrorDetail struct {
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// AdminHandler serves the admin API over the entries of the store, behind RequireAdminToken:
+//   - GET AdminEntriesAPI lists the entries in ID order, filtered by the key_prefix, namespace,
+//     pipeline_name, created_after and created_before (RFC 3339) query parameters, page_size at
+//     a time from page_token on. view=full lists their templates and outputs too.
+//   - POST AdminEntriesAPI imports the AdminEntry of the body as a new entry created now.
+//   - DELETE AdminEntriesAPI?key=<cache key> deletes the entries of the cache key.
+//   - GET and DELETE AdminEntriesAPI/{id} get and delete an entry.
+//   - POST AdminInvalidateAPI deletes the entries of an AdminInvalidateRequest.
+func AdminHandler(store storage.ExecutionCacheAdminStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == AdminInvalidateAPI {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				writeAdminError(w, http.StatusMethodNotAllowed, "only POST is supported")
+				return
+			}
+			invalidateAdminEntries(w, r, store)
+			return
+		}
+		if r.URL.Path == AdminEntriesAPI {
+			switch r.Method {
+			case http.MethodGet:
+				listAdminEntries(w, r, store)
+			case http.MethodPost:
+				importAdminEntry(w, r, store)
+			case http.MethodDelete:
+				deleteAdminEntriesByKey(w, r, store)
+			default:
+				w.Header().Set("Allow", "GET, POST, DELETE")
+				writeAdminError(w, http.StatusMethodNotAllowed, "only GET, POST and DELETE are supported")
+			}
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, AdminEntriesAPI+"/")
+		if id == r.URL.Path || strings.Contains(id, "/") {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("no admin API at %s", r.URL.Path))
+			return
+		}
+		if parsed, err := strconv.ParseInt(id, 10, 64); err != nil || parsed <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid entry ID %q", id))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			executionCache, err := store.GetExecutionCacheByID(r.Context(), id)
+			if err != nil {
+				writeAdminStoreError(w, r, err)
+				return
+			}
+			entry := newAdminEntry(executionCache)
+			entry.Template = executionCache.ExecutionTemplate
+			entry.Output = executionCache.ExecutionOutput
+			writeAdminJSON(w, http.StatusOK, entry)
+		case http.MethodDelete:
+			if err := store.DeleteExecutionCacheByID(r.Context(), id); err != nil {
+				writeAdminStoreError(w, r, err)
+				return
+			}
+			logger.WithField(logging.FieldCacheID, id).Info("Deleted the cache entry through the admin API")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeAdminError(w, http.StatusMethodNotAllowed, "only GET and DELETE are supported")
+		}
+	})
+}
+
+func listAdminEntries(w http.ResponseWriter, r *http.Request, store storage.ExecutionCacheAdminStore) {
+	query := r.URL.Query()
+	pageSize := DefaultAdminPageSize
+	if value := query.Get("page_size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > MaxAdminPageSize {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid page_size %q, must be between 1 and %d", value, MaxAdminPageSize))
+			return
+		}
+		pageSize = parsed
+	}
+	fullView := false
+	switch view := query.Get("view"); view {
+	case "", "basic":
+	case "full":
+		fullView = true
+	default:
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid view %q, must be basic or full", view))
+		return
+	}
+	keyPrefix := query.Get("key_prefix")
+	if len(keyPrefix) > maxAdminKeyLength {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("key_prefix is longer than %d bytes", maxAdminKeyLength))
+		return
+	}
+	filter := storage.ExecutionCacheFilter{Namespace: query.Get("namespace"), PipelineName: query.Get("pipeline_name")}
+	if filter.Namespace != "" && len(validation.IsDNS1123Label(filter.Namespace)) > 0 {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid namespace %q", filter.Namespace))
+		return
+	}
+	for _, bound := range []struct {
+		name  string
+		value *int64
+	}{{"created_after", &filter.CreatedAfterInSec}, {"created_before", &filter.CreatedBeforeInSec}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil || parsed.Unix() <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q, must be an RFC 3339 time after the epoch", bound.name, value))
+			return
+		}
+		*bound.value = parsed.Unix()
+	}
+	if filter.CreatedAfterInSec != 0 && filter.CreatedBeforeInSec != 0 && filter.CreatedAfterInSec >= filter.CreatedBeforeInSec {
+		writeAdminError(w, http.StatusBadRequest, "created_after must be before created_before")
+		return
+	}
+
+	executionCaches, nextPageToken, err := store.ListExecutionCaches(r.Context(), keyPrefix, filter, pageSize, query.Get("page_token"))
+	if err != nil {
+		writeAdminStoreError(w, r, err)
+		return
+	}
+	list := AdminEntryList{Entries: []AdminEntry{}, NextPageToken: nextPageToken}
+	for _, executionCache := range executionCaches {
+		entry := newAdminEntry(executionCache)
+		if fullView {
+			entry.Template = executionCache.ExecutionTemplate
+			entry.Output = executionCache.ExecutionOutput
+		}
+		list.Entries = append(list.Entries, entry)
+	}
+	writeAdminJSON(w, http.StatusOK, list)
+}
+
+func importAdminEntry(w http.ResponseWriter, r *http.Request, store storage.ExecutionCacheAdminStore) {
+	var entry AdminEntry
+	// The ID and creation time of exported entries are ignored, the imported entry gets new ones.
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminImportBytes)).Decode(&entry); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if entry.CacheKey == "" || len(entry.CacheKey) > maxAdminKeyLength {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("a cacheKey of at most %d bytes is required", maxAdminKeyLength))
+		return
+	}
+	if entry.Template == "" || entry.Output == "" {
+		writeAdminError(w, http.StatusBadRequest, "template and output are required")
+		return
+	}
+	if entry.KeyVersion != "" && !IsValidCacheKeyVersion(entry.KeyVersion) {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unknown keyVersion %q", entry.KeyVersion))
+		return
+	}
+	executionCache, err := store.CreateExecutionCache(r.Context(), &model.ExecutionCache{
+		ExecutionCacheKey:      entry.CacheKey,
+		ExecutionTemplate:      entry.Template,
+		ExecutionOutput:        entry.Output,
+		MaxCacheStaleness:      entry.MaxCacheStaleness,
+		ExecutionDurationInSec: entry.ExecutionDurationInSec,
+		Owner:                  entry.Owner,
+		PipelineName:           entry.PipelineName,
+		PipelineVersionID:      entry.PipelineVersionID,
+		RunID:                  entry.RunID,
+		KeyVersion:             entry.KeyVersion,
+		ExpiresAtInSec:         entry.expiresAtInSec(),
+	})
+	if err != nil {
+		writeAdminStoreError(w, r, err)
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		logging.FieldCacheKey: executionCache.ExecutionCacheKey,
+		logging.FieldCacheID:  executionCache.ID,
+	}).Info("Imported the cache entry through the admin API")
+	writeAdminJSON(w, http.StatusCreated, newAdminEntry(executionCache))
+}
+
+func deleteAdminEntriesByKey(w http.ResponseWriter, r *http.Request, store storage.ExecutionCacheAdminStore) {
+	key := r.URL.Query().Get("key")
+	if key == "" || len(key) > maxAdminKeyLength {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("a key of at most %d bytes is required, entries are deleted by cache key or ID", maxAdminKeyLength))
+		return
+	}
+	deleted, err := store.DeleteExecutionCachesByKey(r.Context(), key)
+	if err != nil {
+		writeAdminStoreError(w, r, err)
+		return
+	}
+	if deleted == 0 {
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("no cache entry with cache key %q", key))
+		return
+	}
+	logger.WithFields(logrus.Fields{logging.FieldCacheKey: key}).Infof("Deleted %d cache entries through the admin API", deleted)
+	writeAdminJSON(w, http.StatusOK, AdminDeletion{Deleted: deleted})
+}
+
+func invalidateAdminEntries(w http.ResponseWriter, r *http.Request, store storage.ExecutionCacheAdminStore) {
+	var request AdminInvalidateRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBytes))
+	// A misspelled selector must not widen the invalidation.
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if len(request.KeyPrefix) > maxAdminKeyLength {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("keyPrefix is longer than %d bytes", maxAdminKeyLength))
+		return
+	}
+	selector := storage.ExecutionCacheSelector{
+		PipelineName:      request.PipelineName,
+		PipelineVersionID: request.PipelineVersionID,
+		RunID:             request.RunID,
+		KeyPrefix:         request.KeyPrefix,
+		All:               request.All,
+	}
+	if request.OlderThan != "" {
+		olderThan, err := time.Parse(time.RFC3339, request.OlderThan)
+		if err != nil || olderThan.Unix() <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid olderThan %q, must be an RFC 3339 time after the epoch", request.OlderThan))
+			return
+		}
+		selector.OlderThanInSec = olderThan.Unix()
+	}
+	if selector == (storage.ExecutionCacheSelector{}) {
+		writeAdminError(w, http.StatusBadRequest, "one of pipelineName, pipelineVersionId, runId, keyPrefix or olderThan is required, or all to invalidate every entry")
+		return
+	}
+
+	invalidation, err := store.InvalidateExecutionCaches(r.Context(), selector, request.DryRun)
+	if err != nil {
+		if invalidation != nil && invalidation.Count > 0 {
+			logger.Warnf("Invalidated %d cache entries through the admin API, selected by %+v, before failing", invalidation.Count, selector)
+		}
+		writeAdminStoreError(w, r, err)
+		return
+	}
+	if !request.DryRun {
+		logger.Infof("Invalidated %d cache entries through the admin API, selected by %+v", invalidation.Count, selector)
+	}
+	writeAdminJSON(w, http.StatusOK, AdminInvalidation{Invalidated: invalidation.Count, DryRun: request.DryRun})
+}
+
+func newAdminEntry(executionCache *model.ExecutionCache) AdminEntry {
+	var expiresAt *time.Time
+	if executionCache.ExpiresAtInSec > 0 {
+		at := time.Unix(executionCache.ExpiresAtInSec, 0).UTC()
+		expiresAt = &at
+	}
+	return AdminEntry{
+		ID:                     strconv.FormatInt(executionCache.ID, 10),
+		CacheKey:               executionCache.ExecutionCacheKey,
+		Owner:                  executionCache.Owner,
+		CreatedAt:              time.Unix(executionCache.StartedAtInSec, 0).UTC(),
+		MaxCacheStaleness:      executionCache.MaxCacheStaleness,
+		ExecutionDurationInSec: executionCache.ExecutionDurationInSec,
+		PipelineName:           executionCache.PipelineName,
+		PipelineVersionID:      executionCache.PipelineVersionID,
+		RunID:                  executionCache.RunID,
+		KeyVersion:             executionCache.KeyVersion,
+		ExpiresAt:              expiresAt,
+	}
+}
+
+// expiresAtInSec returns the end of the time-to-live of the entry in seconds, 0 for none.
+func (e AdminEntry) expiresAtInSec() int64 {
+	if e.ExpiresAt == nil {
+		return 0
+	}
+	return e.ExpiresAt.Unix()
+}
+
+// writeAdminStoreError answers with the status of the store error: 404 for missing entries, 400
+// for invalid input such as page tokens, and 500 otherwise.
+func writeAdminStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND):
+		writeAdminError(w, http.StatusNotFound, "cache entry not found")
+	case util.IsUserErrorCodeMatch(err, codes.InvalidArgument):
+		writeAdminError(w, http.StatusBadRequest, redactCredentials(err.Error()))
+	default:
+		logger.Errorf("Admin request %s %s failed: %v", r.Method, r.URL.Path, err)
+		writeAdminError(w, http.StatusInternalServerError, redactCredentials(err.Error()))
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, AdminError{Error: AdminErrorDetail{
+		Code:    status,
+		Status:  http.StatusText(status),
+		Message: message,
+	}})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body interface{}) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(ContentType, JsonContentType)
+	w.WriteHeader(status)
+	w.Write(b)
+}
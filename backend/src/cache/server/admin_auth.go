@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AdminAPIPrefix starts the paths of every API served behind RequireAdminToken, such as the admin
+// API and the stats.
+const AdminAPIPrefix string = "/v1/"
+
+// adminTokenIDLength is the number of hexadecimal digits of the SHA-256 of a token identifying it.
+const adminTokenIDLength int = 12
+
+// adminTokenEntry is an accepted token and its identifier.
+type adminTokenEntry struct {
+	id    string
+	value []byte
+}
+
+// AdminToken holds the bearer tokens admin requests must present, one per line, so that a new
+// token can be accepted before the old one is removed. The tokens can be replaced while serving,
+// e.g. when their Secret rotates.
+type AdminToken struct {
+	tokens atomic.Value
+}
+
+// NewAdminToken returns the admin tokens of the lines of tokens. Empty lines and lines starting
+// with # are ignored, and no token authorizes no request.
+func NewAdminToken(tokens string) *AdminToken {
+	t := &AdminToken{}
+	t.Set(tokens)
+	return t
+}
+
+// Set replaces the tokens with those of the lines of tokens.
+func (t *AdminToken) Set(tokens string) {
+	var entries []adminTokenEntry
+	for _, line := range strings.Split(tokens, "\n") {
+		token := strings.TrimSpace(line)
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		entries = append(entries, adminTokenEntry{id: AdminTokenID(token), value: []byte(token)})
+	}
+	t.tokens.Store(entries)
+}
+
+// AdminTokenID identifies the token in logs without revealing it: the first digits of its SHA-256.
+func AdminTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])[:adminTokenIDLength]
+}
+
+// authorize returns the identifier of the token of the Authorization header or metadata, false
+// when it presents none of the tokens. Every token is compared, so that the time taken does not
+// tell which one matched.
+func (t *AdminToken) authorize(authorization string) (string, bool) {
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return "", false
+	}
+	presented := []byte(strings.TrimPrefix(authorization, "Bearer "))
+	id := ""
+	for _, token := range t.tokens.Load().([]adminTokenEntry) {
+		if subtle.ConstantTimeCompare(presented, token.value) == 1 {
+			id = token.id
+		}
+	}
+	return id, id != ""
+}
+
+// statusRecorder records the status of a response for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// RequireAdminToken answers 401 to the requests that do not present one of the tokens, and
+// serves the others with next, logging each of them with the identifier of its token.
+func RequireAdminToken(token *AdminToken, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := token.authorize(r.Header.Get("Authorization"))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		logger.WithField(logging.FieldTokenID, id).Infof("Admin request %s %s answered %d", r.Method, r.URL.RequestURI(), recorder.status)
+	})
+}
+
+// AdminTokenInterceptor fails with Unauthenticated the calls whose authorization metadata does not
+// present one of the tokens, like RequireAdminToken, and logs the others with the identifier of
+// their token.
+func AdminTokenInterceptor(token *AdminToken) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authorization := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				authorization = values[0]
+			}
+		}
+		id, ok := token.authorize(authorization)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "a valid bearer token is required")
+		}
+		resp, err := handler(ctx, req)
+		logger.WithField(logging.FieldTokenID, id).Infof("Admin call %s answered %s", info.FullMethod, status.Code(err))
+		return resp, err
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveWithToken serves a request for the path presenting the authorization.
+func serveWithToken(handler http.Handler, method string, path string, authorization string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	return rr
+}
+
+func TestRequireAdminTokenRejectsInvalidTokens(t *testing.T) {
+	served := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true })
+	handler := RequireAdminToken(NewAdminToken(testAdminToken), next)
+	for _, authorization := range []string{"", "Bearer wrong", "Bearer ", testAdminToken, "Basic " + testAdminToken, "Bearer " + testAdminToken + "x"} {
+		var body AdminError
+		rr := serveWithToken(handler, http.MethodGet, AdminEntriesAPI, authorization)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, authorization)
+		assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+		require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusUnauthorized, body.Error.Code)
+	}
+	assert.False(t, served)
+
+	for _, tokens := range []string{"", "\n", "# no token yet"} {
+		rr := serveWithToken(RequireAdminToken(NewAdminToken(tokens), next), http.MethodGet, StatsAPI, "Bearer ")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "no token authorizes no request")
+	}
+	assert.False(t, served)
+}
+
+func TestRequireAdminTokenAcceptsEveryTokenAndLogsItsID(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := RequireAdminToken(NewAdminToken("# rotated in on 2020-06-01\nfirst-token\n  second-token  \n"), next)
+
+	for _, token := range []string{"first-token", "second-token"} {
+		hook.Reset()
+		rr := serveWithToken(handler, http.MethodDelete, AdminEntriesAPI+"/1", "Bearer "+token)
+
+		assert.Equal(t, http.StatusNoContent, rr.Code, token)
+		require.Len(t, hook.AllEntries(), 1)
+		entry := hook.LastEntry()
+		assert.Equal(t, AdminTokenID(token), entry.Data[logging.FieldTokenID])
+		assert.Equal(t, "Admin request DELETE /v1/cache/entries/1 answered 204", entry.Message)
+		serialized, err := entry.String()
+		require.Nil(t, err)
+		assert.NotContains(t, serialized, token, "the token is not logged")
+	}
+	assert.NotEqual(t, AdminTokenID("first-token"), AdminTokenID("second-token"))
+}
+
+func TestAdminTokenRotates(t *testing.T) {
+	token := NewAdminToken("first\nsecond")
+	handler := RequireAdminToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, http.MethodGet, StatsAPI, "Bearer first").Code)
+
+	// The token file was reloaded without the first token.
+	token.Set("second")
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(handler, http.MethodGet, StatsAPI, "Bearer first").Code)
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, http.MethodGet, StatsAPI, "Bearer second").Code)
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// AdminService serves the ExecutionCacheService, the gRPC counterpart of the admin API and the
+// stats, with the same validation and errors.
+type AdminService struct {
+	api.UnimplementedExecutionCacheServiceServer
+
+	// store is nil when the cache store does not support the admin API, and the calls on entries
+	// then fail with Unimplemented.
+	store storage.ExecutionCacheAdminStore
+	stats *StatsCollector
+}
+
+// factory function for an AdminService over the entries of the store and the stats of the
+// collector.
+func NewAdminService(store storage.ExecutionCacheAdminStore, stats *StatsCollector) *AdminService {
+	return &AdminService{store: store, stats: stats}
+}
+
+// NewAdminGRPCServer returns a gRPC server of the service, behind AdminTokenInterceptor.
+func NewAdminGRPCServer(token *AdminToken, service *AdminService) *grpc.Server {
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(AdminTokenInterceptor(token)))
+	api.RegisterExecutionCacheServiceServer(grpcServer, service)
+	return grpcServer
+}
+
+func (s *AdminService) ListEntries(ctx context.Context, request *api.ListEntriesRequest) (*api.ListEntriesResponse, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	pageSize := DefaultAdminPageSize
+	if request.PageSize != 0 {
+		if request.PageSize < 0 || int(request.PageSize) > MaxAdminPageSize {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_size %d, must be between 1 and %d", request.PageSize, MaxAdminPageSize)
+		}
+		pageSize = int(request.PageSize)
+	}
+	if len(request.KeyPrefix) > maxAdminKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "key_prefix is longer than %d bytes", maxAdminKeyLength)
+	}
+	filter := storage.ExecutionCacheFilter{Namespace: request.Namespace, PipelineName: request.PipelineName}
+	if filter.Namespace != "" && len(validation.IsDNS1123Label(filter.Namespace)) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid namespace %q", filter.Namespace)
+	}
+	var err error
+	if filter.CreatedAfterInSec, err = adminTimestampInSec("created_after", request.CreatedAfter); err != nil {
+		return nil, err
+	}
+	if filter.CreatedBeforeInSec, err = adminTimestampInSec("created_before", request.CreatedBefore); err != nil {
+		return nil, err
+	}
+	if filter.CreatedAfterInSec != 0 && filter.CreatedBeforeInSec != 0 && filter.CreatedAfterInSec >= filter.CreatedBeforeInSec {
+		return nil, status.Error(codes.InvalidArgument, "created_after must be before created_before")
+	}
+
+	executionCaches, nextPageToken, err := s.store.ListExecutionCaches(ctx, request.KeyPrefix, filter, pageSize, request.PageToken)
+	if err != nil {
+		return nil, adminStoreStatus("ListEntries", err)
+	}
+	response := &api.ListEntriesResponse{NextPageToken: nextPageToken}
+	for _, executionCache := range executionCaches {
+		response.Entries = append(response.Entries, newAPICacheEntry(executionCache, request.FullView))
+	}
+	return response, nil
+}
+
+func (s *AdminService) GetEntry(ctx context.Context, request *api.GetEntryRequest) (*api.CacheEntry, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if err := checkAdminEntryID(request.Id); err != nil {
+		return nil, err
+	}
+	executionCache, err := s.store.GetExecutionCacheByID(ctx, request.Id)
+	if err != nil {
+		return nil, adminStoreStatus("GetEntry", err)
+	}
+	return newAPICacheEntry(executionCache, true), nil
+}
+
+func (s *AdminService) DeleteEntry(ctx context.Context, request *api.DeleteEntryRequest) (*api.DeleteEntryResponse, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if (request.Id == "") == (request.CacheKey == "") {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of id or cache_key is required")
+	}
+	if request.Id != "" {
+		if err := checkAdminEntryID(request.Id); err != nil {
+			return nil, err
+		}
+		if err := s.store.DeleteExecutionCacheByID(ctx, request.Id); err != nil {
+			return nil, adminStoreStatus("DeleteEntry", err)
+		}
+		logger.WithField(logging.FieldCacheID, request.Id).Info("Deleted the cache entry through the admin API")
+		return &api.DeleteEntryResponse{Deleted: 1}, nil
+	}
+	if len(request.CacheKey) > maxAdminKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "cache_key is longer than %d bytes", maxAdminKeyLength)
+	}
+	deleted, err := s.store.DeleteExecutionCachesByKey(ctx, request.CacheKey)
+	if err != nil {
+		return nil, adminStoreStatus("DeleteEntry", err)
+	}
+	if deleted == 0 {
+		return nil, status.Errorf(codes.NotFound, "no cache entry with cache key %q", request.CacheKey)
+	}
+	logger.WithFields(logrus.Fields{logging.FieldCacheKey: request.CacheKey}).Infof("Deleted %d cache entries through the admin API", deleted)
+	return &api.DeleteEntryResponse{Deleted: deleted}, nil
+}
+
+func (s *AdminService) Invalidate(ctx context.Context, request *api.InvalidateRequest) (*api.InvalidateResponse, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if len(request.KeyPrefix) > maxAdminKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "key_prefix is longer than %d bytes", maxAdminKeyLength)
+	}
+	selector := storage.ExecutionCacheSelector{
+		PipelineName:      request.PipelineName,
+		PipelineVersionID: request.PipelineVersionId,
+		RunID:             request.RunId,
+		KeyPrefix:         request.KeyPrefix,
+		All:               request.All,
+	}
+	var err error
+	if selector.OlderThanInSec, err = adminTimestampInSec("older_than", request.OlderThan); err != nil {
+		return nil, err
+	}
+	if selector == (storage.ExecutionCacheSelector{}) {
+		return nil, status.Error(codes.InvalidArgument, "one of pipeline_name, pipeline_version_id, run_id, key_prefix or older_than is required, or all to invalidate every entry")
+	}
+
+	invalidation, err := s.store.InvalidateExecutionCaches(ctx, selector, request.DryRun)
+	if err != nil {
+		if invalidation != nil && invalidation.Count > 0 {
+			logger.Warnf("Invalidated %d cache entries through the admin API, selected by %+v, before failing", invalidation.Count, selector)
+		}
+		return nil, adminStoreStatus("Invalidate", err)
+	}
+	if !request.DryRun {
+		logger.Infof("Invalidated %d cache entries through the admin API, selected by %+v", invalidation.Count, selector)
+	}
+	return &api.InvalidateResponse{Invalidated: invalidation.Count, DryRun: request.DryRun}, nil
+}
+
+func (s *AdminService) GetStats(ctx context.Context, request *api.GetStatsRequest) (*api.Stats, error) {
+	stats, err := s.stats.Collect(ctx)
+	if err != nil {
+		logger.Errorf("Failed to compute the stats of the cache store: %v", err)
+		return nil, status.Error(codes.Internal, redactCredentials(err.Error()))
+	}
+	response := &api.Stats{
+		SchemaVersion: int32(stats.SchemaVersion),
+		GeneratedAt:   timestamppb.New(stats.GeneratedAt),
+		Process: &api.ProcessStats{
+			StartedAt: timestamppb.New(stats.Process.StartedAt),
+			Hits:      stats.Process.Hits,
+			Misses:    stats.Process.Misses,
+		},
+	}
+	for _, template := range stats.Process.TopTemplatesByHits {
+		response.Process.TopTemplatesByHits = append(response.Process.TopTemplatesByHits, &api.TemplateProcessStats{
+			Template: template.Template,
+			Hits:     template.Hits,
+			Misses:   template.Misses,
+		})
+	}
+	if stats.Store != nil {
+		response.Store = &api.StoreStats{
+			ComputedAt:            timestamppb.New(stats.Store.ComputedAt),
+			Entries:               stats.Store.Entries,
+			OutputBytes:           stats.Store.OutputBytes,
+			CreatedLast_24H:       stats.Store.CreatedLast24h,
+			CreatedLast_7D:        stats.Store.CreatedLast7d,
+			OldestEntryAgeSeconds: stats.Store.OldestEntryAgeSeconds,
+		}
+		for _, template := range stats.Store.TopTemplatesByBytes {
+			response.Store.TopTemplatesByBytes = append(response.Store.TopTemplatesByBytes, &api.TemplateStoreStats{
+				Template:    template.Template,
+				Entries:     template.Entries,
+				OutputBytes: template.OutputBytes,
+			})
+		}
+	}
+	return response, nil
+}
+
+func (s *AdminService) checkStore() error {
+	if s.store == nil {
+		return status.Error(codes.Unimplemented, "the cache store does not support the admin API")
+	}
+	return nil
+}
+
+func checkAdminEntryID(id string) error {
+	if parsed, err := strconv.ParseInt(id, 10, 64); err != nil || parsed <= 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid entry ID %q", id)
+	}
+	return nil
+}
+
+// adminTimestampInSec returns the seconds of the timestamp, 0 when it is not set.
+func adminTimestampInSec(name string, timestamp *timestamppb.Timestamp) (int64, error) {
+	if timestamp == nil {
+		return 0, nil
+	}
+	if err := timestamp.CheckValid(); err != nil || timestamp.Seconds <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s, must be a time after the epoch", name)
+	}
+	return timestamp.Seconds, nil
+}
+
+// adminStoreStatus returns the status of the store error, like writeAdminStoreError: NotFound for
+// missing entries, InvalidArgument for invalid input such as page tokens, and Internal otherwise.
+func adminStoreStatus(method string, err error) error {
+	switch {
+	case util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND):
+		return status.Error(codes.NotFound, "cache entry not found")
+	case util.IsUserErrorCodeMatch(err, codes.InvalidArgument):
+		return status.Error(codes.InvalidArgument, redactCredentials(err.Error()))
+	default:
+		logger.Errorf("Admin call %s failed: %v", method, err)
+		return status.Error(codes.Internal, redactCredentials(err.Error()))
+	}
+}
+
+func newAPICacheEntry(executionCache *model.ExecutionCache, fullView bool) *api.CacheEntry {
+	entry := &api.CacheEntry{
+		Id:                     strconv.FormatInt(executionCache.ID, 10),
+		CacheKey:               executionCache.ExecutionCacheKey,
+		Owner:                  executionCache.Owner,
+		CreatedAt:              timestamppb.New(time.Unix(executionCache.StartedAtInSec, 0)),
+		MaxCacheStalenessInSec: executionCache.MaxCacheStaleness,
+		ExecutionDurationInSec: executionCache.ExecutionDurationInSec,
+		PipelineName:           executionCache.PipelineName,
+		PipelineVersionId:      executionCache.PipelineVersionID,
+		RunId:                  executionCache.RunID,
+	}
+	if fullView {
+		entry.Template = executionCache.ExecutionTemplate
+		entry.Output = executionCache.ExecutionOutput
+	}
+	return entry
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newSeededAdminClient returns a client of an in-process gRPC server over a store holding entries
+// of the keys, created one second apart from 2020-01-01 on, and the entries.
+func newSeededAdminClient(t *testing.T, keys ...string) (api.ExecutionCacheServiceClient, []*model.ExecutionCache) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	t.Cleanup(func() { clientManager.Close() })
+	var created []*model.ExecutionCache
+	for _, key := range keys {
+		entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: key,
+			ExecutionTemplate: "template",
+			ExecutionOutput:   testExecutionOutput,
+			MaxCacheStaleness: -1,
+			Owner:             "team-a",
+			PipelineName:      "pipeline-" + key,
+			PipelineVersionID: "version-" + key,
+		})
+		require.Nil(t, err)
+		created = append(created, entry)
+	}
+	stats := NewStatsCollector(clientManager.CacheStore().(storage.ExecutionCacheStatsStore), 0, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+	service := NewAdminService(clientManager.CacheStore().(storage.ExecutionCacheAdminStore), stats)
+	return dialAdminService(t, service), created
+}
+
+// dialAdminService serves the service behind the test admin token in process and returns a client.
+func dialAdminService(t *testing.T, service *AdminService) api.ExecutionCacheServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := NewAdminGRPCServer(NewAdminToken(testAdminToken), service)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithInsecure())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return api.NewExecutionCacheServiceClient(conn)
+}
+
+// withAdminToken returns a context whose calls present the token.
+func withAdminToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestAdminServiceRequiresAnAdminToken(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	client, _ := newSeededAdminClient(t, "key")
+	hook.Reset()
+
+	_, err := client.GetStats(context.Background(), &api.GetStatsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetEntry(withAdminToken("other-token"), &api.GetEntryRequest{Id: "1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Empty(t, hook.AllEntries())
+
+	_, err = client.GetEntry(withAdminToken(testAdminToken), &api.GetEntryRequest{Id: "1"})
+	require.Nil(t, err)
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, AdminTokenID(testAdminToken), entry.Data[logging.FieldTokenID])
+	assert.Equal(t, "Admin call /cache.v1.ExecutionCacheService/GetEntry answered OK", entry.Message)
+}
+
+func TestAdminServiceListsEntriesPageByPage(t *testing.T) {
+	client, created := newSeededAdminClient(t, "aa1", "b1", "aa2", "aa3")
+	ctx := withAdminToken(testAdminToken)
+
+	page, err := client.ListEntries(ctx, &api.ListEntriesRequest{KeyPrefix: "aa", PageSize: 2})
+	require.Nil(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "1", page.Entries[0].Id)
+	assert.Equal(t, "aa1", page.Entries[0].CacheKey)
+	assert.Equal(t, "team-a", page.Entries[0].Owner)
+	assert.Equal(t, created[0].StartedAtInSec, page.Entries[0].CreatedAt.AsTime().Unix())
+	assert.Equal(t, int64(-1), page.Entries[0].MaxCacheStalenessInSec)
+	assert.Empty(t, page.Entries[0].Output)
+	assert.Equal(t, "aa2", page.Entries[1].CacheKey)
+	require.NotEmpty(t, page.NextPageToken)
+
+	next, err := client.ListEntries(ctx, &api.ListEntriesRequest{KeyPrefix: "aa", PageSize: 2, PageToken: page.NextPageToken, FullView: true})
+	require.Nil(t, err)
+	require.Len(t, next.Entries, 1)
+	assert.Equal(t, "aa3", next.Entries[0].CacheKey)
+	assert.Equal(t, testExecutionOutput, next.Entries[0].Output)
+	assert.Empty(t, next.NextPageToken)
+
+	filtered, err := client.ListEntries(ctx, &api.ListEntriesRequest{Namespace: "team-b"})
+	require.Nil(t, err)
+	assert.Empty(t, filtered.Entries)
+
+	filtered, err = client.ListEntries(ctx, &api.ListEntriesRequest{PipelineName: "pipeline-aa2"})
+	require.Nil(t, err)
+	require.Len(t, filtered.Entries, 1)
+	assert.Equal(t, "aa2", filtered.Entries[0].CacheKey)
+}
+
+func TestAdminServiceRejectsInvalidRequests(t *testing.T) {
+	client, _ := newSeededAdminClient(t, "key")
+	ctx := withAdminToken(testAdminToken)
+	epoch := timestamppb.New(time.Unix(0, 0))
+	later := timestamppb.New(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	earlier := timestamppb.New(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, request := range []*api.ListEntriesRequest{
+		{PageSize: -1},
+		{PageSize: int32(MaxAdminPageSize + 1)},
+		{Namespace: "Team_A"},
+		{CreatedAfter: epoch},
+		{CreatedAfter: later, CreatedBefore: earlier},
+		{PageToken: "not-a-token"},
+	} {
+		_, err := client.ListEntries(ctx, request)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", request)
+	}
+	_, err := client.GetEntry(ctx, &api.GetEntryRequest{Id: "abc"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.DeleteEntry(ctx, &api.DeleteEntryRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.DeleteEntry(ctx, &api.DeleteEntryRequest{Id: "1", CacheKey: "key"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Invalidate(ctx, &api.InvalidateRequest{DryRun: true})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminServiceGetsAndDeletesEntries(t *testing.T) {
+	client, _ := newSeededAdminClient(t, "key", "key", "other")
+	ctx := withAdminToken(testAdminToken)
+
+	entry, err := client.GetEntry(ctx, &api.GetEntryRequest{Id: "3"})
+	require.Nil(t, err)
+	assert.Equal(t, "other", entry.CacheKey)
+	assert.Equal(t, "template", entry.Template)
+	assert.Equal(t, testExecutionOutput, entry.Output)
+
+	deleted, err := client.DeleteEntry(ctx, &api.DeleteEntryRequest{Id: "3"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), deleted.Deleted)
+	_, err = client.GetEntry(ctx, &api.GetEntryRequest{Id: "3"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.DeleteEntry(ctx, &api.DeleteEntryRequest{Id: "3"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	deleted, err = client.DeleteEntry(ctx, &api.DeleteEntryRequest{CacheKey: "key"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), deleted.Deleted)
+	_, err = client.DeleteEntry(ctx, &api.DeleteEntryRequest{CacheKey: "key"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminServiceInvalidatesEntries(t *testing.T) {
+	client, _ := newSeededAdminClient(t, "a", "b", "c")
+	ctx := withAdminToken(testAdminToken)
+
+	invalidation, err := client.Invalidate(ctx, &api.InvalidateRequest{All: true, DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), invalidation.Invalidated)
+	assert.True(t, invalidation.DryRun)
+
+	invalidation, err = client.Invalidate(ctx, &api.InvalidateRequest{PipelineName: "pipeline-b"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), invalidation.Invalidated)
+	assert.False(t, invalidation.DryRun)
+
+	invalidation, err = client.Invalidate(ctx, &api.InvalidateRequest{PipelineVersionId: "version-c"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), invalidation.Invalidated)
+
+	page, err := client.ListEntries(ctx, &api.ListEntriesRequest{})
+	require.Nil(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "a", page.Entries[0].CacheKey)
+	assert.Equal(t, "version-a", page.Entries[0].PipelineVersionId)
+}
+
+func TestAdminServiceGetsTheStats(t *testing.T) {
+	client, _ := newSeededAdminClient(t, "a", "b")
+
+	stats, err := client.GetStats(withAdminToken(testAdminToken), &api.GetStatsRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, int32(StatsSchemaVersion), stats.SchemaVersion)
+	require.NotNil(t, stats.Store)
+	assert.Equal(t, int64(2), stats.Store.Entries)
+	assert.Equal(t, int64(2*len(testExecutionOutput)), stats.Store.OutputBytes)
+	assert.Equal(t, int64(2), stats.Store.CreatedLast_24H)
+	require.NotNil(t, stats.Process)
+}
+
+func TestAdminServiceWithoutAdminStore(t *testing.T) {
+	client := dialAdminService(t, NewAdminService(nil, NewStatsCollector(nil, 0, util.NewFakeTimeForEpoch())))
+	ctx := withAdminToken(testAdminToken)
+
+	_, err := client.ListEntries(ctx, &api.ListEntriesRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	stats, err := client.GetStats(ctx, &api.GetStatsRequest{})
+	require.Nil(t, err)
+	assert.Nil(t, stats.Store)
+}
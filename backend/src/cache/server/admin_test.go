@@ -0,0 +1,304 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "admin-token"
+
+// newSeededAdminHandler returns the admin handler over a store holding entries of the keys, created
+// one second apart from 2020-01-01 on, and the entries.
+func newSeededAdminHandler(t *testing.T, keys ...string) (http.Handler, []*model.ExecutionCache) {
+	var entries []model.ExecutionCache
+	for _, key := range keys {
+		entries = append(entries, model.ExecutionCache{ExecutionCacheKey: key})
+	}
+	return newSeededAdminHandlerOfEntries(t, entries...)
+}
+
+// newSeededAdminHandlerOfEntries returns the admin handler over a store holding the entries, like
+// newSeededAdminHandler.
+func newSeededAdminHandlerOfEntries(t *testing.T, entries ...model.ExecutionCache) (http.Handler, []*model.ExecutionCache) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	t.Cleanup(func() { clientManager.Close() })
+	var created []*model.ExecutionCache
+	for _, entry := range entries {
+		entry.ExecutionTemplate = "template"
+		entry.ExecutionOutput = testExecutionOutput
+		entry.MaxCacheStaleness = -1
+		entry.Owner = "team-a"
+		entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &entry)
+		require.Nil(t, err)
+		created = append(created, entry)
+	}
+	store := clientManager.CacheStore().(storage.ExecutionCacheAdminStore)
+	return RequireAdminToken(NewAdminToken(testAdminToken), AdminHandler(store)), created
+}
+
+// serveAdmin serves the request with the admin token and decodes the JSON body into body, if any.
+func serveAdmin(t *testing.T, handler http.Handler, method string, target string, body interface{}) *httptest.ResponseRecorder {
+	return serveAdminBody(t, handler, method, target, "", body)
+}
+
+// serveAdminBody serves the request with the request body like serveAdmin.
+func serveAdminBody(t *testing.T, handler http.Handler, method string, target string, requestBody string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if requestBody != "" {
+		reader = strings.NewReader(requestBody)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	request.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	if body != nil {
+		require.Nil(t, json.Unmarshal(rr.Body.Bytes(), body), rr.Body.String())
+	}
+	return rr
+}
+
+func TestAdminHandlerListsEntriesPageByPage(t *testing.T) {
+	handler, created := newSeededAdminHandler(t, "aa1", "b1", "aa2", "aa3")
+
+	var page AdminEntryList
+	rr := serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"?key_prefix=aa&page_size=2", &page)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, JsonContentType, rr.Header().Get(ContentType))
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, AdminEntry{
+		ID:                "1",
+		CacheKey:          "aa1",
+		Owner:             "team-a",
+		CreatedAt:         time.Unix(created[0].StartedAtInSec, 0).UTC(),
+		MaxCacheStaleness: -1,
+	}, page.Entries[0])
+	assert.Equal(t, "aa2", page.Entries[1].CacheKey)
+	require.NotEmpty(t, page.NextPageToken)
+
+	var next AdminEntryList
+	serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"?key_prefix=aa&page_size=2&page_token="+page.NextPageToken, &next)
+	require.Len(t, next.Entries, 1)
+	assert.Equal(t, "aa3", next.Entries[0].CacheKey)
+	assert.Empty(t, next.NextPageToken)
+
+	var filtered AdminEntryList
+	serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"?namespace=team-b", &filtered)
+	assert.Equal(t, []AdminEntry{}, filtered.Entries)
+}
+
+func TestAdminHandlerListsTheEntriesOfAPipeline(t *testing.T) {
+	handler, _ := newSeededAdminHandlerOfEntries(t,
+		model.ExecutionCache{ExecutionCacheKey: "train", PipelineName: "training"},
+		model.ExecutionCache{ExecutionCacheKey: "serve", PipelineName: "serving"},
+		model.ExecutionCache{ExecutionCacheKey: "evaluate", PipelineName: "training"})
+
+	var list AdminEntryList
+	rr := serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"?pipeline_name=training", &list)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, list.Entries, 2)
+	assert.Equal(t, "train", list.Entries[0].CacheKey)
+	assert.Equal(t, "evaluate", list.Entries[1].CacheKey)
+	assert.Equal(t, "training", list.Entries[1].PipelineName)
+}
+
+func TestAdminHandlerListsTheFullView(t *testing.T) {
+	handler, _ := newSeededAdminHandler(t, "key")
+
+	var page AdminEntryList
+	rr := serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"?view=full", &page)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "template", page.Entries[0].Template)
+	assert.Equal(t, testExecutionOutput, page.Entries[0].Output)
+}
+
+func TestAdminHandlerImportsEntries(t *testing.T) {
+	handler, _ := newSeededAdminHandler(t, "key")
+
+	var imported AdminEntry
+	rr := serveAdminBody(t, handler, http.MethodPost, AdminEntriesAPI,
+		`{"id":"42","cacheKey":"imported","owner":"team-b","createdAt":"2019-01-01T00:00:00Z","maxCacheStalenessInSec":60,"template":"template","output":"output","runId":"run-1"}`, &imported)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "2", imported.ID, "the imported entry gets a new ID")
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 2, 0, time.UTC), imported.CreatedAt)
+
+	var entry AdminEntry
+	serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"/2", &entry)
+	assert.Equal(t, AdminEntry{
+		ID:                "2",
+		CacheKey:          "imported",
+		Owner:             "team-b",
+		CreatedAt:         imported.CreatedAt,
+		MaxCacheStaleness: 60,
+		RunID:             "run-1",
+		Template:          "template",
+		Output:            "output",
+	}, entry)
+
+	for _, body := range []string{
+		``,
+		`{"template":"template","output":"output"}`,
+		`{"cacheKey":"key","output":"output"}`,
+		`{"cacheKey":"key","template":"template"}`,
+		`{"cacheKey":`,
+	} {
+		var response AdminError
+		rr := serveAdminBody(t, handler, http.MethodPost, AdminEntriesAPI, body, &response)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		assert.NotEmpty(t, response.Error.Message)
+	}
+}
+
+func TestAdminHandlerRejectsInvalidRequests(t *testing.T) {
+	handler, _ := newSeededAdminHandler(t, "key")
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{name: "page size zero", method: http.MethodGet, target: AdminEntriesAPI + "?page_size=0", status: http.StatusBadRequest},
+		{name: "page size too large", method: http.MethodGet, target: AdminEntriesAPI + "?page_size=1001", status: http.StatusBadRequest},
+		{name: "invalid page token", method: http.MethodGet, target: AdminEntriesAPI + "?page_token=abc", status: http.StatusBadRequest},
+		{name: "invalid namespace", method: http.MethodGet, target: AdminEntriesAPI + "?namespace=Team_A", status: http.StatusBadRequest},
+		{name: "invalid time", method: http.MethodGet, target: AdminEntriesAPI + "?created_after=yesterday", status: http.StatusBadRequest},
+		{name: "empty time range", method: http.MethodGet, target: AdminEntriesAPI + "?created_after=2020-02-01T00:00:00Z&created_before=2020-01-01T00:00:00Z", status: http.StatusBadRequest},
+		{name: "delete without key", method: http.MethodDelete, target: AdminEntriesAPI, status: http.StatusBadRequest},
+		{name: "invalid ID", method: http.MethodGet, target: AdminEntriesAPI + "/abc", status: http.StatusBadRequest},
+		{name: "unknown path", method: http.MethodGet, target: AdminEntriesAPI + "/1/output", status: http.StatusNotFound},
+		{name: "invalid view", method: http.MethodGet, target: AdminEntriesAPI + "?view=everything", status: http.StatusBadRequest},
+		{name: "unsupported method", method: http.MethodPut, target: AdminEntriesAPI, status: http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body AdminError
+			rr := serveAdmin(t, handler, test.method, test.target, &body)
+
+			assert.Equal(t, test.status, rr.Code)
+			assert.Equal(t, test.status, body.Error.Code)
+			assert.Equal(t, http.StatusText(test.status), body.Error.Status)
+			assert.NotEmpty(t, body.Error.Message)
+		})
+	}
+}
+
+func TestAdminHandlerGetsAndDeletesEntries(t *testing.T) {
+	handler, _ := newSeededAdminHandler(t, "key", "key", "other")
+
+	var entry AdminEntry
+	rr := serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"/3", &entry)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "other", entry.CacheKey)
+	assert.Equal(t, "template", entry.Template)
+	assert.Equal(t, testExecutionOutput, entry.Output)
+
+	rr = serveAdmin(t, handler, http.MethodDelete, AdminEntriesAPI+"/3", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	var notFound AdminError
+	rr = serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI+"/3", &notFound)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, http.StatusNotFound, notFound.Error.Code)
+	rr = serveAdmin(t, handler, http.MethodDelete, AdminEntriesAPI+"/3", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	var deletion AdminDeletion
+	rr = serveAdmin(t, handler, http.MethodDelete, AdminEntriesAPI+"?key=key", &deletion)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, AdminDeletion{Deleted: 2}, deletion)
+	rr = serveAdmin(t, handler, http.MethodDelete, AdminEntriesAPI+"?key=key", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAdminHandlerInvalidatesEntries(t *testing.T) {
+	entries := []model.ExecutionCache{
+		{ExecutionCacheKey: "aa1", PipelineName: "train", PipelineVersionID: "train-v1", RunID: "run-1"},
+		{ExecutionCacheKey: "ab1", PipelineName: "train", PipelineVersionID: "train-v2", RunID: "run-2"},
+		{ExecutionCacheKey: "aa2", PipelineName: "serve", RunID: "run-3"},
+	}
+	tests := []struct {
+		name        string
+		body        string
+		invalidated int64
+	}{
+		{name: "pipeline name", body: `{"pipelineName":"train"}`, invalidated: 2},
+		{name: "pipeline version ID", body: `{"pipelineVersionId":"train-v1"}`, invalidated: 1},
+		{name: "run ID", body: `{"runId":"run-3"}`, invalidated: 1},
+		{name: "key prefix", body: `{"keyPrefix":"aa"}`, invalidated: 2},
+		{name: "older than", body: `{"olderThan":"2020-01-01T00:00:03Z"}`, invalidated: 2},
+		{name: "all", body: `{"all":true}`, invalidated: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, _ := newSeededAdminHandlerOfEntries(t, entries...)
+
+			var dryRun AdminInvalidation
+			rr := serveAdminBody(t, handler, http.MethodPost, AdminInvalidateAPI,
+				strings.TrimSuffix(test.body, "}")+`,"dryRun":true}`, &dryRun)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			assert.Equal(t, AdminInvalidation{Invalidated: test.invalidated, DryRun: true}, dryRun)
+			var list AdminEntryList
+			serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI, &list)
+			assert.Len(t, list.Entries, len(entries), "a dry run deletes nothing")
+
+			var invalidation AdminInvalidation
+			rr = serveAdminBody(t, handler, http.MethodPost, AdminInvalidateAPI, test.body, &invalidation)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			assert.Equal(t, AdminInvalidation{Invalidated: test.invalidated}, invalidation)
+			serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI, &list)
+			assert.Len(t, list.Entries, len(entries)-int(test.invalidated))
+		})
+	}
+}
+
+func TestAdminHandlerRejectsInvalidInvalidations(t *testing.T) {
+	handler, _ := newSeededAdminHandler(t, "key")
+	for _, body := range []string{
+		``,
+		`{}`,
+		`{"dryRun":true}`,
+		`{"all":false}`,
+		`{"pipeline":"train"}`,
+		`{"olderThan":"last week"}`,
+		`{"pipelineName":`,
+	} {
+		var response AdminError
+		rr := serveAdminBody(t, handler, http.MethodPost, AdminInvalidateAPI, body, &response)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		assert.NotEmpty(t, response.Error.Message)
+	}
+	rr := serveAdmin(t, handler, http.MethodGet, AdminInvalidateAPI, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, http.MethodPost, rr.Header().Get("Allow"))
+
+	var list AdminEntryList
+	serveAdmin(t, handler, http.MethodGet, AdminEntriesAPI, &list)
+	assert.Len(t, list.Entries, 1, "invalid selectors invalidate nothing")
+}
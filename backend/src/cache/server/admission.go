@@ -15,13 +15,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 
+	"github.com/google/uuid"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/api/admission/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -46,11 +54,16 @@ type patchOperation struct {
 
 // admitFunc is a callback for admission controller logic. Given an AdmissionRequest, it returns the sequence of patch
 // operations to be applied in case of success, or the error that will be shown when the operation is rejected.
-type admitFunc func(_ *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error)
+// The context is the one of the HTTP request and is done when the API server gives up on it.
+type admitFunc func(ctx context.Context, _ *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error)
 
 const (
 	ContentType     string = "Content-Type"
 	JsonContentType string = "application/json"
+
+	// DefaultMaxRequestBodyBytes leaves room for an AdmissionReview holding both the object and the
+	// old object at the 1.5MB etcd object size limit.
+	DefaultMaxRequestBodyBytes int64 = 4 << 20
 )
 
 var (
@@ -65,90 +78,195 @@ func isKubeNamespace(ns string) bool {
 // doServeAdmitFunc parses the HTTP request for an admission controller webhook, and -- in case of a well-formed
 // request -- delegates the admission control logic to the given admitFunc. The response body is then returned as raw
 // bytes.
-func doServeAdmitFunc(w http.ResponseWriter, r *http.Request, admit admitFunc, clientMgr ClientManagerInterface) ([]byte, error) {
-	// Step 1: Request validation. Only handle POST requests with a body and json content type.
-
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return nil, fmt.Errorf("Invalid method %q, only POST requests are allowed", r.Method)
-	}
+func (wh *Webhook) doServeAdmitFunc(w http.ResponseWriter, r *http.Request, admit admitFunc, clientMgr ClientManagerInterface) ([]byte, error) {
+	// Step 1: Request validation. Only handle POST requests with a body of bounded size and json
+	// content type.
 
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := wh.readAdmissionBody(w, r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, fmt.Errorf("Could not read request body: %v", err)
+		return nil, err
 	}
 
-	if contentType := r.Header.Get(ContentType); contentType != JsonContentType {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, fmt.Errorf("Unsupported content type %q, only %q is supported", contentType, JsonContentType)
-	}
-
-	// Step 2: Parse the AdmissionReview request.
+	// Step 2: Parse the AdmissionReview request. Reviews that cannot be parsed are answered according
+	// to the fail policy, like any other failure of the webhook.
 
 	var admissionReviewReq v1beta1.AdmissionReview
 
+	endDeserialize := startPhase(r.Context(), AdmissionPhaseDeserialize)
 	_, _, err = universalDeserializer.Decode(body, nil, &admissionReviewReq)
+	endDeserialize()
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, fmt.Errorf("Could not deserialize request: %v", err)
+		uid, object := peekRequest(body)
+		return wh.failedAdmissionResponse(r.Context(), uid, object, fmt.Errorf("could not deserialize request: %v", err)), nil
 	}
 	if admissionReviewReq.Request == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, errors.New("Malformed admission review request: request body is nil")
+		// There is no object that could be rejected.
+		logger.Warn("Allowing admission of a malformed admission review request: request body is nil")
+		return warningResponse("", "Malformed admission review request: request body is nil"), nil
 	}
 
-	// Step 3: Construct the AdmissionReview response.
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.AttributeNamespace.String(admissionReviewReq.Request.Namespace))
+
+	// Step 3: Construct the AdmissionReview response. Its UID must be the one of the request.
 
 	// Apply the admit() function only for non-Kubernetes namespaces. For objects in Kubernetes namespaces, return
 	// an empty set of patch operations.
 	if isKubeNamespace(admissionReviewReq.Request.Namespace) {
+		admissionTimingFrom(r.Context()).outcome = AdmissionOutcomeSkippedNotKFP
 		return allowedResponse(admissionReviewReq.Request.UID, nil), nil
 	}
 
+	// Every entry logged for this admission carries its request id.
+	id := requestID(admissionReviewReq.Request)
+	ctx := logging.ContextWithFields(r.Context(), logrus.Fields{
+		logging.FieldRequestID: id,
+		logging.FieldNamespace: admissionReviewReq.Request.Namespace,
+	})
+	// The admit function reports degraded caching through warnings shown to the pod's creator.
+	ctx, warnings := contextWithAdmissionWarnings(ctx)
+	// The admit function fills in the audit event, which is recorded once it has a decision.
+	auditEvent := &model.AuditEvent{
+		RequestID: id,
+		Namespace: admissionReviewReq.Request.Namespace,
+	}
+	ctx = contextWithAuditEvent(ctx, auditEvent)
+	defer wh.recordAuditEvent(auditEvent)
+	ctx, details := contextWithDecisionDetails(ctx)
+	defer wh.recordDecision(auditEvent, details)
+
+	// Admissions beyond the limits are allowed without lookup rather than wait.
+	if reason := wh.admissionLimiter.acquire(ctx, admissionReviewReq.Request.Namespace); reason != "" {
+		logging.WithContext(logger, ctx).WithField(logging.FieldDecision, reason).Debug("Shedding admission, the pod runs uncached")
+		auditEvent.Decision = reason
+		admissionTimingFrom(ctx).outcome = reason
+		setDecisionReason(ctx, "the webhook is overloaded, the pod was admitted without lookup")
+		return warningResponse(admissionReviewReq.Request.UID, "the webhook is overloaded, step will run uncached"), nil
+	}
+	defer wh.admissionLimiter.release()
 	var patchOps []patchOperation
 
-	patchOps, err = admit(admissionReviewReq.Request, clientMgr)
+	patchOps, err = admit(ctx, admissionReviewReq.Request, clientMgr)
 	if err != nil {
-		return errorResponse(admissionReviewReq.Request.UID, err), nil
+		recordSpanError(ctx, err)
+		return wh.failedAdmissionResponse(ctx, admissionReviewReq.Request.UID, admissionReviewReq.Request.Object.Raw, err), nil
 	}
 
+	endPatch := startPhase(ctx, AdmissionPhasePatch)
+	defer endPatch()
 	patchBytes, err := json.Marshal(patchOps)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return nil, fmt.Errorf("Could not marshal JSON patch: %v", err)
+		err = fmt.Errorf("could not marshal JSON patch: %v", err)
+		recordSpanError(ctx, err)
+		return wh.failedAdmissionResponse(ctx, admissionReviewReq.Request.UID, admissionReviewReq.Request.Object.Raw, err), nil
+	}
+
+	return allowedResponseWithWarnings(admissionReviewReq.Request.UID, patchBytes, warnings.list()), nil
+}
+
+// readAdmissionBody reads the body of an admission request, which must be a POST request with a
+// body of bounded size and json content type. The status of the response is written when it is
+// not.
+func (wh *Webhook) readAdmissionBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("Invalid method %q, only POST requests are allowed", r.Method)
+	}
+
+	if contentType := r.Header.Get(ContentType); !isJsonContentType(contentType) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return nil, fmt.Errorf("Unsupported content type %q, only %q is supported", contentType, JsonContentType)
+	}
+
+	maxBodyBytes := wh.mutationConfig().MaxRequestBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxRequestBodyBytes
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		if int64(len(body)) >= maxBodyBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return nil, fmt.Errorf("Request body exceeds the limit of %d bytes", maxBodyBytes)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("Could not read request body: %v", err)
 	}
+	return body, nil
+}
 
-	return allowedResponse(admissionReviewReq.Request.UID, patchBytes), nil
+// isJsonContentType accepts application/json with optional parameters such as the charset.
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == JsonContentType
+}
+
+// peekRequest returns the UID and the raw object of an AdmissionReview that failed to deserialize
+// when the body is at least valid JSON, so that the response can still be matched to the request
+// and the fail policy can tell whether the object is a KFP pod.
+func peekRequest(body []byte) (types.UID, []byte) {
+	var review struct {
+		Request struct {
+			UID    types.UID       `json:"uid"`
+			Object json.RawMessage `json:"object"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(body, &review); err != nil {
+		return "", nil
+	}
+	return review.Request.UID, review.Request.Object
+}
+
+// requestID identifies an admission in the logs by the UID the API server assigned to it, or by a
+// random id when the request has none.
+func requestID(req *v1beta1.AdmissionRequest) string {
+	if req.UID != "" {
+		return string(req.UID)
+	}
+	return uuid.New().String()
 }
 
-// serveAdmitFunc is a wrapper around doServeAdmitFunc that adds error handling and logging.
-func serveAdmitFunc(w http.ResponseWriter, r *http.Request, admit admitFunc, clientMgr ClientManagerInterface) {
-	log.Print("Handling webhook request ...")
+// serveAdmitFunc is a wrapper around doServeAdmitFunc that adds error handling, logging and the
+// admission span, which continues the trace of an incoming traceparent header.
+func (wh *Webhook) serveAdmitFunc(w http.ResponseWriter, r *http.Request, admit admitFunc, clientMgr ClientManagerInterface) {
+	logger.Debug("Handling webhook request")
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, tracing.SpanAdmission, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx, timing := contextWithAdmissionTiming(ctx)
+	defer timing.observe(wh.metrics)
+	r = r.WithContext(ctx)
 
 	var writeErr error
-	if bytes, err := doServeAdmitFunc(w, r, admit, clientMgr); err != nil {
-		log.Printf("Error handling webhook request: %v", err)
+	if bytes, err := wh.doServeAdmitFunc(w, r, admit, clientMgr); err != nil {
+		logger.Errorf("Error handling webhook request: %v", err)
+		recordSpanError(ctx, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		_, writeErr = w.Write([]byte(err.Error()))
 	} else {
-		log.Print("Webhook request handled successfully")
+		logger.Debug("Webhook request handled successfully")
 		_, writeErr = w.Write(bytes)
 	}
 
 	if writeErr != nil {
-		log.Printf("Could not write response: %v", writeErr)
+		logger.Errorf("Could not write response: %v", writeErr)
 	}
 }
 
 // AdmitFuncHandler takes an admitFunc and wraps it into a http.Handler by means of calling serveAdmitFunc.
-func AdmitFuncHandler(admit admitFunc, clientMgr ClientManagerInterface) http.Handler {
+func (wh *Webhook) AdmitFuncHandler(admit admitFunc, clientMgr ClientManagerInterface) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		serveAdmitFunc(w, r, admit, clientMgr)
+		wh.serveAdmitFunc(w, r, admit, clientMgr)
 	})
 }
 
+// recordSpanError marks the span of ctx as failed.
+func recordSpanError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
 func allowedResponse(uid types.UID, patchBytes []byte) []byte {
 	admissionReviewResponse := v1beta1.AdmissionReview{
 		Response: &v1beta1.AdmissionResponse{
@@ -165,6 +283,40 @@ func allowedResponse(uid types.UID, patchBytes []byte) []byte {
 	return bytes
 }
 
+// admissionResponseWithWarnings adds the warnings field of Kubernetes 1.19 to the AdmissionResponse
+// of the vendored API, which API servers of that version and later show to the client.
+type admissionResponseWithWarnings struct {
+	v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// warningResponse allows the admission unchanged and passes the warning on to the client.
+func warningResponse(uid types.UID, warning string) []byte {
+	return allowedResponseWithWarnings(uid, nil, []string{formatWarning(warning)})
+}
+
+// allowedResponseWithWarnings allows the admission with the patch and passes the warnings on to
+// the client. Without warnings the response is the one of allowedResponse.
+func allowedResponseWithWarnings(uid types.UID, patchBytes []byte, warnings []string) []byte {
+	admissionReviewResponse := struct {
+		Response *admissionResponseWithWarnings `json:"response"`
+	}{
+		Response: &admissionResponseWithWarnings{
+			AdmissionResponse: v1beta1.AdmissionResponse{
+				UID:     uid,
+				Allowed: true,
+				Patch:   patchBytes,
+			},
+			Warnings: warnings,
+		},
+	}
+	bytes, err := json.Marshal(&admissionReviewResponse)
+	if err != nil {
+		return allowedResponse(uid, patchBytes)
+	}
+	return bytes
+}
+
 func errorResponse(uid types.UID, err error) []byte {
 	admissionReviewResponse := v1beta1.AdmissionReview{
 		Response: &v1beta1.AdmissionResponse{
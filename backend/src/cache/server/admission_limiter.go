@@ -0,0 +1,205 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// AdmissionShedReasonQueueTimeout is an admission that waited for a free slot longer than the
+	// queue timeout.
+	AdmissionShedReasonQueueTimeout string = "queue_timeout"
+	// AdmissionShedReasonRateLimited is an admission beyond the rate of its namespace.
+	AdmissionShedReasonRateLimited string = "rate_limited"
+
+	DefaultAdmissionQueueTimeout   time.Duration = 500 * time.Millisecond
+	DefaultAdmissionBurstPerSource int           = 50
+
+	// maxRateLimitedSources bounds the token buckets kept in memory. Beyond it, buckets that have
+	// refilled since their last admission are dropped since they are equal to new ones.
+	maxRateLimitedSources int = 10000
+)
+
+// AdmissionLimiterConfig holds the limits of the admissions handled by the webhook.
+type AdmissionLimiterConfig struct {
+	// MaxConcurrent bounds the admissions handled at once. Zero or less means no bound.
+	MaxConcurrent int
+	// QueueTimeout bounds the time an admission waits for one of the MaxConcurrent slots. Zero
+	// sheds admissions as soon as all slots are taken.
+	QueueTimeout time.Duration
+	// RatePerSource is the number of admissions per second handled for each namespace, with bursts
+	// of up to BurstPerSource admissions. Zero or less disables rate limiting.
+	RatePerSource  float64
+	BurstPerSource int
+}
+
+// AdmissionLimiter protects the cache store from bursts of admissions, e.g. of a workflow with
+// thousands of steps. Admissions beyond the limits are shed: they are allowed unchanged without a
+// cache lookup, so that the pods run uncached rather than wait.
+type AdmissionLimiter struct {
+	config AdmissionLimiterConfig
+	time   util.TimeInterface
+	// slots holds a token per admission in flight. It is nil without concurrency limit.
+	slots chan struct{}
+
+	mutex   sync.Mutex
+	buckets map[string]*sourceBucket
+
+	inFlight prometheus.Gauge
+	queued   prometheus.Gauge
+	shed     *prometheus.CounterVec
+}
+
+// sourceBucket is the token bucket of a source and the time of its last admission.
+type sourceBucket struct {
+	limiter    flowcontrol.RateLimiter
+	lastUsedAt time.Time
+}
+
+// limiterClock is the clock of the token buckets, which never sleep since admissions beyond the
+// rate are shed.
+type limiterClock struct {
+	util.TimeInterface
+}
+
+func (limiterClock) Sleep(time.Duration) {}
+
+// acquire waits for the admission of ctx from the namespace source to be handled. It returns the
+// reason the admission was shed, or an empty string when it must be followed by a call of release.
+func (l *AdmissionLimiter) acquire(ctx context.Context, source string) string {
+	if !l.allowSource(source) {
+		l.shed.WithLabelValues(AdmissionShedReasonRateLimited).Inc()
+		return AdmissionShedReasonRateLimited
+	}
+	if l.slots == nil {
+		l.inFlight.Inc()
+		return ""
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Inc()
+		return ""
+	default:
+	}
+
+	l.queued.Inc()
+	defer l.queued.Dec()
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Inc()
+		return ""
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.shed.WithLabelValues(AdmissionShedReasonQueueTimeout).Inc()
+	return AdmissionShedReasonQueueTimeout
+}
+
+func (l *AdmissionLimiter) release() {
+	l.inFlight.Dec()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// allowSource takes a token from the bucket of source when rate limiting is enabled.
+func (l *AdmissionLimiter) allowSource(source string) bool {
+	if l.config.RatePerSource <= 0 {
+		return true
+	}
+	now := l.time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	bucket, exists := l.buckets[source]
+	if !exists {
+		if len(l.buckets) >= maxRateLimitedSources {
+			l.dropRefilledBuckets(now)
+		}
+		bucket = &sourceBucket{limiter: flowcontrol.NewTokenBucketRateLimiterWithClock(float32(l.config.RatePerSource), l.burstPerSource(), limiterClock{l.time})}
+		l.buckets[source] = bucket
+	}
+	bucket.lastUsedAt = now
+	return bucket.limiter.TryAccept()
+}
+
+func (l *AdmissionLimiter) burstPerSource() int {
+	if l.config.BurstPerSource < 1 {
+		return 1
+	}
+	return l.config.BurstPerSource
+}
+
+// dropRefilledBuckets drops the buckets that had the time to refill since their last admission. It
+// must be called with the mutex held.
+func (l *AdmissionLimiter) dropRefilledBuckets(now time.Time) {
+	refill := time.Duration(float64(l.burstPerSource()) / l.config.RatePerSource * float64(time.Second))
+	for source, bucket := range l.buckets {
+		if now.Sub(bucket.lastUsedAt) >= refill {
+			delete(l.buckets, source)
+		}
+	}
+}
+
+// factory function for the limiter of the admissions handled by the webhook
+func NewAdmissionLimiter(config AdmissionLimiterConfig, time util.TimeInterface, registerer prometheus.Registerer) *AdmissionLimiter {
+	l := &AdmissionLimiter{
+		config:  config,
+		time:    time,
+		buckets: map[string]*sourceBucket{},
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_admissions_in_flight",
+			Help: "Admissions handled by the cache webhook at the moment.",
+		}),
+		queued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_admissions_queued",
+			Help: "Admissions waiting for the concurrency limit of the cache webhook.",
+		}),
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_admissions_shed_total",
+			Help: "Admissions allowed without cache lookup because of the limits of the cache webhook, by reason: queue_timeout or rate_limited.",
+		}, []string{"reason"}),
+	}
+	if config.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	l.inFlight = registerLimiterCollector(registerer, l.inFlight).(prometheus.Gauge)
+	l.queued = registerLimiterCollector(registerer, l.queued).(prometheus.Gauge)
+	l.shed = registerLimiterCollector(registerer, l.shed).(*prometheus.CounterVec)
+	// Export every reason from the start so that rates are defined before the first shed admission.
+	for _, reason := range []string{AdmissionShedReasonQueueTimeout, AdmissionShedReasonRateLimited} {
+		l.shed.WithLabelValues(reason)
+	}
+	return l
+}
+
+// registerLimiterCollector registers the collector, or returns the one registered before when the
+// limiter is created again.
+func registerLimiterCollector(registerer prometheus.Registerer, collector prometheus.Collector) prometheus.Collector {
+	registered, err := metrics.RegisterOrReuse(registerer, collector)
+	if err != nil {
+		logger.Errorf("Failed to register admission limiter metrics: %v", err)
+	}
+	return registered
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// concurrentExecutionCacheStore answers lookups after a delay and tracks how many run at once.
+type concurrentExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	delay         time.Duration
+	lookups       int32
+	running       int32
+	maxConcurrent int32
+}
+
+func (s *concurrentExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	atomic.AddInt32(&s.lookups, 1)
+	running := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		maxConcurrent := atomic.LoadInt32(&s.maxConcurrent)
+		if running <= maxConcurrent || atomic.CompareAndSwapInt32(&s.maxConcurrent, maxConcurrent, running) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+}
+
+// serveConcurrentAdmissions posts count admissions of fake pods at once to webhook and returns their
+// responses and durations. The pods have distinct cache keys, so that their lookups are not
+// coalesced.
+func serveConcurrentAdmissions(t *testing.T, webhook *Webhook, clientManager ClientManagerInterface, count int) ([]admissionResponseWithWarnings, []time.Duration) {
+	responses := make([]admissionResponseWithWarnings, count)
+	durations := make([]time.Duration, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		pod := fakePod.DeepCopy()
+		pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = fmt.Sprintf(`{"container":{"command":["echo", "Hello %d"],"image":"python:3.7"}}`, i)
+		body := reviewBody(t, GetFakeRequestFromPod(pod))
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+			req.Header.Set(ContentType, JsonContentType)
+			rr := httptest.NewRecorder()
+			start := time.Now()
+			webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+			durations[i] = time.Since(start)
+			responses[i] = decodeWarningResponse(t, rr.Body.Bytes())
+		}(i)
+	}
+	wg.Wait()
+	return responses, durations
+}
+
+func TestAdmissionLimiterShedsAdmissionsBeyondQueueTimeout(t *testing.T) {
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{MaxConcurrent: 2, QueueTimeout: 100 * time.Millisecond}, util.NewRealTime(), prometheus.NewRegistry())
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{AdmissionDeadline: time.Second}, AdmissionLimiter: limiter})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &concurrentExecutionCacheStore{delay: 400 * time.Millisecond}
+	clientManager.cacheStore = store
+
+	responses, durations := serveConcurrentAdmissions(t, webhook, clientManager, 10)
+
+	shed := 0
+	for i, response := range responses {
+		assert.True(t, response.Allowed)
+		if len(response.Warnings) == 0 {
+			assert.NotEmpty(t, response.Patch)
+			continue
+		}
+		shed++
+		assert.Equal(t, []string{"pipelines.kubeflow.org cache webhook: the webhook is overloaded, step will run uncached"}, response.Warnings)
+		assert.Nil(t, response.Patch)
+		// Shed admissions are answered once the queue timeout passes, not after the slow lookups.
+		assert.True(t, durations[i] < 300*time.Millisecond, "shed admission took %v", durations[i])
+	}
+	assert.Equal(t, 8, shed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.maxConcurrent))
+	assert.Equal(t, float64(8), testutil.ToFloat64(limiter.shed.WithLabelValues(AdmissionShedReasonQueueTimeout)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(limiter.inFlight))
+	assert.Equal(t, float64(0), testutil.ToFloat64(limiter.queued))
+}
+
+func TestAdmissionLimiterQueuesAdmissionsWithinQueueTimeout(t *testing.T) {
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{MaxConcurrent: 2, QueueTimeout: 2 * time.Second}, util.NewRealTime(), prometheus.NewRegistry())
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{AdmissionDeadline: time.Second}, AdmissionLimiter: limiter})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &concurrentExecutionCacheStore{delay: 50 * time.Millisecond}
+	clientManager.cacheStore = store
+
+	responses, durations := serveConcurrentAdmissions(t, webhook, clientManager, 6)
+
+	for i, response := range responses {
+		assert.True(t, response.Allowed)
+		assert.Empty(t, response.Warnings)
+		assert.NotEmpty(t, response.Patch)
+		// Queued admissions wait for the lookups ahead of them, never for the whole queue timeout.
+		assert.True(t, durations[i] < time.Second, "admission took %v", durations[i])
+	}
+	assert.Equal(t, int32(6), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.maxConcurrent))
+	assert.Equal(t, float64(0), testutil.ToFloat64(limiter.shed.WithLabelValues(AdmissionShedReasonQueueTimeout)))
+}
+
+func TestAdmissionLimiterStopsQueueingWhenRequestIsDone(t *testing.T) {
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{MaxConcurrent: 1, QueueTimeout: time.Minute}, util.NewRealTime(), prometheus.NewRegistry())
+	require.Equal(t, "", limiter.acquire(context.Background(), "default"))
+	defer limiter.release()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Equal(t, AdmissionShedReasonQueueTimeout, limiter.acquire(ctx, "default"))
+	assert.True(t, time.Since(start) < time.Second, "queued for %v", time.Since(start))
+}
+
+func TestAdmissionLimiterRateLimitsPerNamespace(t *testing.T) {
+	clock := &fixedClock{now: time.Unix(1000, 0)}
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{RatePerSource: 2, BurstPerSource: 3}, clock, prometheus.NewRegistry())
+	admit := func(namespace string) string {
+		reason := limiter.acquire(context.Background(), namespace)
+		if reason == "" {
+			limiter.release()
+		}
+		return reason
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "", admit("team-a"))
+	}
+	assert.Equal(t, AdmissionShedReasonRateLimited, admit("team-a"))
+	assert.Equal(t, "", admit("team-b"), "namespaces have their own buckets")
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.Equal(t, "", admit("team-a"))
+	assert.Equal(t, AdmissionShedReasonRateLimited, admit("team-a"))
+	assert.Equal(t, float64(2), testutil.ToFloat64(limiter.shed.WithLabelValues(AdmissionShedReasonRateLimited)))
+}
+
+func TestAdmissionLimiterDropsRefilledBuckets(t *testing.T) {
+	clock := &fixedClock{now: time.Unix(1000, 0)}
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{RatePerSource: 1, BurstPerSource: 1}, clock, prometheus.NewRegistry())
+	require.True(t, limiter.allowSource("refilled"))
+	clock.now = clock.now.Add(time.Second)
+	require.True(t, limiter.allowSource("drained"))
+
+	limiter.dropRefilledBuckets(clock.now.Add(500 * time.Millisecond))
+
+	assert.Contains(t, limiter.buckets, "drained")
+	assert.NotContains(t, limiter.buckets, "refilled")
+}
+
+func TestAdmissionLimiterDoesNotApplyToHealthEndpoints(t *testing.T) {
+	limiter := NewAdmissionLimiter(AdmissionLimiterConfig{MaxConcurrent: 1}, util.NewRealTime(), prometheus.NewRegistry())
+	webhook := NewWebhook(WebhookConfig{AdmissionLimiter: limiter})
+	require.Equal(t, "", limiter.acquire(context.Background(), "default"))
+	defer limiter.release()
+
+	rr := httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, HealthzAPI, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Admissions in Kubernetes namespaces are not looked up and not limited either.
+	response := serveAdmissionReview(t, webhook, &v1beta1.AdmissionRequest{UID: "kube-uid", Namespace: "kube-system"}, fakeAdmitFunc)
+	assert.True(t, response.Allowed)
+}
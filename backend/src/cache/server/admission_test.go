@@ -15,7 +15,9 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -26,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"k8s.io/api/admission/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 var (
@@ -45,7 +48,7 @@ var (
 
 var fakeClientManager = NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
 
-func fakeAdmitFunc(req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+func fakeAdmitFunc(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
 	operation := patchOperation{
 		Op:    OperationTypeAdd,
 		Path:  "test",
@@ -70,7 +73,7 @@ func TestDoServeAdmitFunc(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	patchOperations, err := doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	patchOperations, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
 	require.NotNil(t, patchOperations)
 	assert.Nil(t, err)
 }
@@ -78,26 +81,87 @@ func TestDoServeAdmitFunc(t *testing.T) {
 func TestDoServeAdmitFuncWithInvalidHttpMethod(t *testing.T) {
 	req, _ := http.NewRequest("Get", "", nil)
 	rr := httptest.NewRecorder()
-	patchOperations, err := doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	patchOperations, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
 	assert.Nil(t, patchOperations)
 	assert.Contains(t, err.Error(), "Invalid method")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, http.MethodPost, rr.Header().Get("Allow"))
 }
 
 func TestDoServeAdmitFuncWithInvalidContentType(t *testing.T) {
-	req, err := http.NewRequest("POST", "/url", strings.NewReader(""))
+	for _, contentType := range []string{"", "text/plain", "application/jsonp", "application/x-yaml"} {
+		req, _ := http.NewRequest("POST", "/url", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		patchOperations, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+		assert.Nil(t, patchOperations, contentType)
+		assert.Contains(t, err.Error(), "Unsupported content type", contentType)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code, contentType)
+	}
+}
+
+func TestDoServeAdmitFuncAcceptsJsonContentTypeParameters(t *testing.T) {
+	body, err := json.Marshal(fakeAdmissionReview)
+	require.Nil(t, err)
+	req, _ := http.NewRequest("POST", "/url", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rr := httptest.NewRecorder()
+	response, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	require.Nil(t, err)
+	assert.NotNil(t, response)
+}
+
+func TestDoServeAdmitFuncWithOversizedBody(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{MaxRequestBodyBytes: 1024}})
+	req, _ := http.NewRequest("POST", "/url", strings.NewReader(strings.Repeat(" ", 2048)))
+	req.Header.Set("Content-Type", "application/json")
 	rr := httptest.NewRecorder()
-	patchOperations, err := doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	patchOperations, err := webhook.doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
 	assert.Nil(t, patchOperations)
-	assert.Contains(t, err.Error(), "Unsupported content type")
+	assert.Contains(t, err.Error(), "Request body exceeds the limit of 1024 bytes")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	// Bodies within the limit are served.
+	body, err := json.Marshal(fakeAdmissionReview)
+	require.Nil(t, err)
+	req, _ = http.NewRequest("POST", "/url", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	_, err = webhook.doServeAdmitFunc(httptest.NewRecorder(), req, fakeAdmitFunc, fakeClientManager)
+	assert.Nil(t, err)
+}
+
+// decodeWarningResponse decodes a fail-open response of the webhook.
+func decodeWarningResponse(t *testing.T, body []byte) admissionResponseWithWarnings {
+	var review struct {
+		Response *admissionResponseWithWarnings `json:"response"`
+	}
+	require.Nil(t, json.Unmarshal(body, &review))
+	require.NotNil(t, review.Response)
+	return *review.Response
 }
 
 func TestDoServeAdmitFuncWithInvalidRequestBody(t *testing.T) {
 	req, err := http.NewRequest("POST", "/url", strings.NewReader("invalid"))
 	req.Header.Set("Content-Type", "application/json")
 	rr := httptest.NewRecorder()
-	patchOperations, err := doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
-	assert.Nil(t, patchOperations)
-	assert.Contains(t, err.Error(), "Could not deserialize request")
+	responseBody, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	response := decodeWarningResponse(t, responseBody)
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+	require.Len(t, response.Warnings, 1)
+	assert.Contains(t, response.Warnings[0], "could not deserialize request")
+}
+
+func TestDoServeAdmitFuncWithUndecodableReviewKeepsRequestUID(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/url", strings.NewReader(`{"kind":1,"request":{"uid":"undecodable-uid"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	responseBody, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(httptest.NewRecorder(), req, fakeAdmitFunc, fakeClientManager)
+	require.Nil(t, err)
+	response := decodeWarningResponse(t, responseBody)
+	assert.Equal(t, types.UID("undecodable-uid"), response.UID)
+	assert.True(t, response.Allowed)
 }
 
 func TestDoServeAdmitFuncWithEmptyAdmissionRequest(t *testing.T) {
@@ -108,7 +172,64 @@ func TestDoServeAdmitFuncWithEmptyAdmissionRequest(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
-	patchOperations, err := doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
-	assert.Nil(t, patchOperations)
-	assert.Contains(t, err.Error(), "Malformed admission review request: request body is nil")
+	responseBody, err := NewWebhook(WebhookConfig{}).doServeAdmitFunc(rr, req, fakeAdmitFunc, fakeClientManager)
+	require.Nil(t, err)
+	response := decodeWarningResponse(t, responseBody)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{"pipelines.kubeflow.org cache webhook: Malformed admission review request: request body is nil"}, response.Warnings)
+}
+
+func TestAdmitFuncHandlerRejectionStatuses(t *testing.T) {
+	handler := NewWebhook(WebhookConfig{}).AdmitFuncHandler(fakeAdmitFunc, fakeClientManager)
+	serve := func(method string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/mutate", strings.NewReader(body))
+		req.Header.Set(ContentType, contentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, JsonContentType, "").Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPost, "text/plain", "{}").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(http.MethodPost, JsonContentType, strings.Repeat(" ", int(DefaultMaxRequestBodyBytes)+1)).Code)
+	rr := serve(http.MethodPost, JsonContentType, "invalid")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, decodeWarningResponse(t, rr.Body.Bytes()).Allowed)
+}
+
+// serveAdmissionReview posts the admission request to a handler of webhook admitting with admit
+// and returns the response of the review.
+func serveAdmissionReview(t *testing.T, webhook *Webhook, request *v1beta1.AdmissionRequest, admit admitFunc) *v1beta1.AdmissionResponse {
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: request})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(string(body)))
+	req.Header.Set(ContentType, JsonContentType)
+	rr := httptest.NewRecorder()
+	webhook.AdmitFuncHandler(admit, fakeClientManager).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var review v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	require.NotNil(t, review.Response)
+	return review.Response
+}
+
+func TestAdmissionResponseCarriesRequestUID(t *testing.T) {
+	rejectingAdmitFunc := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		return nil, errors.New("could not deserialize pod object")
+	}
+
+	response := serveAdmissionReview(t, NewWebhook(WebhookConfig{}), &v1beta1.AdmissionRequest{UID: "allowed-uid", Namespace: "default"}, fakeAdmitFunc)
+	assert.Equal(t, types.UID("allowed-uid"), response.UID)
+	assert.True(t, response.Allowed)
+	assert.NotEmpty(t, response.Patch)
+
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{FailPolicy: FailPolicyClosed}})
+	response = serveAdmissionReview(t, webhook, &v1beta1.AdmissionRequest{UID: "rejected-uid", Namespace: "default"}, rejectingAdmitFunc)
+	assert.Equal(t, types.UID("rejected-uid"), response.UID)
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "pipelines.kubeflow.org cache webhook rejected the pod under the closed fail policy: could not deserialize pod object", response.Result.Message)
+
+	response = serveAdmissionReview(t, webhook, &v1beta1.AdmissionRequest{UID: "kube-uid", Namespace: metav1.NamespaceSystem}, rejectingAdmitFunc)
+	assert.Equal(t, types.UID("kube-uid"), response.UID)
+	assert.True(t, response.Allowed)
 }
@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// Phases of an admission, whose durations are recorded by MutationMetrics.AdmissionPhaseCompleted.
+const (
+	// AdmissionPhaseDeserialize decodes the AdmissionReview and the pod.
+	AdmissionPhaseDeserialize string = "deserialize"
+	// AdmissionPhaseGenerateKey derives the cache key from the Argo template.
+	AdmissionPhaseGenerateKey string = "generate_key"
+	// AdmissionPhaseLookup looks the cache key up in the store.
+	AdmissionPhaseLookup string = "lookup"
+	// AdmissionPhasePatch builds and marshals the JSON patch and the response.
+	AdmissionPhasePatch string = "patch"
+)
+
+// Decisions the durations of admissions are labeled with. Unlike the outcomes, they are few enough
+// to be combined with the phase and the histogram buckets.
+const (
+	AdmissionDecisionHit   string = "hit"
+	AdmissionDecisionMiss  string = "miss"
+	AdmissionDecisionSkip  string = "skip"
+	AdmissionDecisionError string = "error"
+)
+
+// admissionDecision returns the decision of the outcome of an admission or of the reason it was
+// shed. Admissions that did not reach an outcome failed.
+func admissionDecision(outcome string) string {
+	switch outcome {
+	case AdmissionOutcomeHit:
+		return AdmissionDecisionHit
+	case AdmissionOutcomeMiss:
+		return AdmissionDecisionMiss
+	case AdmissionOutcomeSkippedNotKFP, AdmissionOutcomeSkippedTFX, AdmissionOutcomeSkippedCircuitOpen,
+		AdmissionOutcomeSkippedNamespace, AdmissionOutcomeSkippedUnresolvedImage, AdmissionShedReasonQueueTimeout,
+		AdmissionShedReasonRateLimited:
+		return AdmissionDecisionSkip
+	default:
+		return AdmissionDecisionError
+	}
+}
+
+// admissionTiming accumulates the time an admission spends in each phase until its decision is
+// known. It is only used by the goroutine handling the admission.
+type admissionTiming struct {
+	started time.Time
+	phases  map[string]time.Duration
+	outcome string
+}
+
+type admissionTimingKey struct{}
+
+// contextWithAdmissionTiming returns a context carrying the timing of an admission started now.
+func contextWithAdmissionTiming(ctx context.Context) (context.Context, *admissionTiming) {
+	timing := &admissionTiming{started: time.Now(), phases: map[string]time.Duration{}}
+	return context.WithValue(ctx, admissionTimingKey{}, timing), timing
+}
+
+// admissionTimingFrom returns the timing of the admission of ctx, or a discarded one when the
+// admission is not timed.
+func admissionTimingFrom(ctx context.Context) *admissionTiming {
+	if timing, ok := ctx.Value(admissionTimingKey{}).(*admissionTiming); ok {
+		return timing
+	}
+	return &admissionTiming{phases: map[string]time.Duration{}}
+}
+
+// startPhase starts timing the phase of the admission of ctx and returns the function ending it.
+// A phase entered more than once accumulates its durations.
+func startPhase(ctx context.Context, phase string) func() {
+	timing := admissionTimingFrom(ctx)
+	started := time.Now()
+	return func() {
+		timing.phases[phase] += time.Since(started)
+	}
+}
+
+// observe records the phases and the duration of the admission with its decision in metrics.
+func (t *admissionTiming) observe(metrics MutationMetrics) {
+	decision := admissionDecision(t.outcome)
+	for phase, duration := range t.phases {
+		metrics.AdmissionPhaseCompleted(phase, decision, duration)
+	}
+	metrics.AdmissionCompleted(decision, time.Since(t.started))
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordingMutationMetrics records the durations observed, without a registry.
+type recordingMutationMetrics struct {
+	noopMutationMetrics
+	mu        sync.Mutex
+	phases    map[string]string
+	decisions []string
+	durations []time.Duration
+}
+
+func newRecordingMutationMetrics() *recordingMutationMetrics {
+	return &recordingMutationMetrics{phases: map[string]string{}}
+}
+
+func (m *recordingMutationMetrics) AdmissionPhaseCompleted(phase string, decision string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phases[phase] = decision
+}
+
+func (m *recordingMutationMetrics) AdmissionCompleted(decision string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decisions = append(m.decisions, decision)
+	m.durations = append(m.durations, duration)
+}
+
+func TestAdmissionTimingRecordsPhasesByDecision(t *testing.T) {
+	allPhases := []string{AdmissionPhaseDeserialize, AdmissionPhaseGenerateKey, AdmissionPhaseLookup, AdmissionPhasePatch}
+	cacheDisabledRequest := GetFakeRequestFromPod(func() *corev1.Pod {
+		pod := fakePod.DeepCopy()
+		pod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey] = "false"
+		return pod
+	}())
+	kubeSystemRequest := fakeAdmissionRequest
+	kubeSystemRequest.Namespace = "kube-system"
+	tests := []struct {
+		name     string
+		request  *v1beta1.AdmissionRequest
+		cached   bool
+		failing  bool
+		decision string
+		phases   []string
+	}{
+		{"miss", &fakeAdmissionRequest, false, false, AdmissionDecisionMiss, allPhases},
+		{"hit", &fakeAdmissionRequest, true, false, AdmissionDecisionHit, allPhases},
+		{"skipped pod", cacheDisabledRequest, false, false, AdmissionDecisionSkip, []string{AdmissionPhaseDeserialize, AdmissionPhasePatch}},
+		{"kubernetes namespace", &kubeSystemRequest, false, false, AdmissionDecisionSkip, []string{AdmissionPhaseDeserialize}},
+		{"failed lookup", &fakeAdmissionRequest, false, true, AdmissionDecisionError, allPhases},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := newRecordingMutationMetrics()
+			webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			if test.cached {
+				_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+					ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+					ExecutionOutput:   testExecutionOutput,
+					ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+					MaxCacheStaleness: -1,
+				})
+				require.Nil(t, err)
+			}
+			if test.failing {
+				clientManager.cacheStore = failingExecutionCacheStore{}
+			}
+
+			body, err := json.Marshal(v1beta1.AdmissionReview{Request: test.request})
+			require.Nil(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+			req.Header.Set(ContentType, JsonContentType)
+			webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(httptest.NewRecorder(), req)
+
+			expectedPhases := map[string]string{}
+			for _, phase := range test.phases {
+				expectedPhases[phase] = test.decision
+			}
+			assert.Equal(t, expectedPhases, metrics.phases)
+			assert.Equal(t, []string{test.decision}, metrics.decisions)
+			require.Len(t, metrics.durations, 1)
+			assert.True(t, metrics.durations[0] > 0)
+		})
+	}
+}
+
+func TestAdmissionTimingOfInvalidRequestIsAnError(t *testing.T) {
+	metrics := newRecordingMutationMetrics()
+	webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+
+	req := httptest.NewRequest(http.MethodGet, "/mutate", nil)
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, fakeClientManager).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The request was answered without reaching any phase.
+	assert.Empty(t, metrics.phases)
+	assert.Equal(t, []string{AdmissionDecisionError}, metrics.decisions)
+}
+
+func TestAdmissionDecision(t *testing.T) {
+	for outcome, decision := range map[string]string{
+		AdmissionOutcomeHit:                AdmissionDecisionHit,
+		AdmissionOutcomeMiss:               AdmissionDecisionMiss,
+		AdmissionOutcomeSkippedNotKFP:      AdmissionDecisionSkip,
+		AdmissionOutcomeSkippedTFX:         AdmissionDecisionSkip,
+		AdmissionOutcomeSkippedCircuitOpen: AdmissionDecisionSkip,
+		AdmissionShedReasonQueueTimeout:    AdmissionDecisionSkip,
+		AdmissionShedReasonRateLimited:     AdmissionDecisionSkip,
+		AdmissionOutcomeError:              AdmissionDecisionError,
+		AdmissionOutcomeDeadlineExceeded:   AdmissionDecisionError,
+		"":                                 AdmissionDecisionError,
+	} {
+		assert.Equal(t, decision, admissionDecision(outcome), outcome)
+	}
+}
+
+func TestPrometheusAdmissionDurationHistograms(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels)
+
+	metrics.AdmissionPhaseCompleted(AdmissionPhaseLookup, AdmissionDecisionHit, 3*time.Millisecond)
+	metrics.AdmissionPhaseCompleted(AdmissionPhaseLookup, AdmissionDecisionHit, 40*time.Millisecond)
+	metrics.AdmissionPhaseCompleted(AdmissionPhaseGenerateKey, AdmissionDecisionHit, 100*time.Microsecond)
+	metrics.AdmissionCompleted(AdmissionDecisionHit, 45*time.Millisecond)
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	histograms := map[string]int{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			histogram := metric.GetHistogram()
+			if histogram == nil {
+				continue
+			}
+			labels := family.GetName()
+			for _, label := range metric.GetLabel() {
+				labels += " " + label.GetName() + "=" + label.GetValue()
+			}
+			histograms[labels] = int(histogram.GetSampleCount())
+			buckets := histogram.GetBucket()
+			require.Len(t, buckets, len(admissionDurationBuckets))
+			assert.Equal(t, .001, buckets[0].GetUpperBound())
+			assert.Equal(t, float64(5), buckets[len(buckets)-1].GetUpperBound())
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"cache_admission_phase_duration_seconds decision=hit phase=lookup":       2,
+		"cache_admission_phase_duration_seconds decision=hit phase=generate_key": 1,
+		"cache_admission_duration_seconds decision=hit":                          1,
+	}, histograms)
+}
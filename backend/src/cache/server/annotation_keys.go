@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultAnnotationPrefix is the domain of the annotations and labels of the pods of KFP.
+const DefaultAnnotationPrefix string = "pipelines.kubeflow.org"
+
+// AnnotationKeys holds the keys of the annotations and labels the cache reads and writes on pods,
+// under the domain of the orchestrator creating them.
+type AnnotationKeys struct {
+	// CacheEnabledLabelKey opts the pods in to the cache, CachedLabelKey labels those served from
+	// cache.
+	CacheEnabledLabelKey string
+	CachedLabelKey       string
+	ExecutionKey         string
+	CacheIDLabelKey      string
+	MetadataWrittenKey   string
+	ProfileLabelKey      string
+	// ComputeSecondsSavedKey annotates the pods served from cache with the execution time, in
+	// seconds, of the entry they reused.
+	ComputeSecondsSavedKey string
+	// CacheSourceRunIDKey annotates the pods served from cache with the run of the pod that
+	// produced the entry they reused, when known.
+	CacheSourceRunIDKey string
+	// ShadowCacheIDKey annotates the pods found in cache under CacheModeShadow with the ID of the
+	// entry they would have reused.
+	ShadowCacheIDKey       string
+	MetadataExecutionIDKey string
+	MaxCacheStalenessKey   string
+	// CacheTTLKey annotates the pods with the time-to-live of their entry, an ISO 8601 duration
+	// overriding the default one. Entries of pods annotated with a zero duration never expire.
+	CacheTTLKey string
+	// PipelineNameKey and PipelineVersionIDKey annotate the pods with the name of their pipeline
+	// and the ID of its version, which entries record as their provenance.
+	PipelineNameKey      string
+	PipelineVersionIDKey string
+	// CacheSignatureKey annotates the pods the mutating webhook set the execution key and cache ID
+	// of with a nonce and the HMAC-SHA256 of those fields, so that the validating webhook can tell
+	// them from pods created with hand-crafted cache fields.
+	CacheSignatureKey string
+	// CachePredictionKey annotates the templates of the workflows admitted by the workflow marking
+	// webhook with whether their pods are predicted to be served from cache, and PredictedCacheKey
+	// with the execution key the prediction was made for. Argo copies both to the pods.
+	CachePredictionKey string
+	PredictedCacheKey  string
+	// CacheKeyVersionKey annotates the pods with the version of the key strategy their cache key
+	// is generated with, set by the SDK or else by the webhook to the default version.
+	CacheKeyVersionKey string
+	// PurgeCacheOnDeleteKey annotates the workflows whose run's entries are purged once they are
+	// deleted, when set to "true".
+	PurgeCacheOnDeleteKey string
+}
+
+// factory function for the keys of the annotations and labels under the prefix, a DNS subdomain
+// such as DefaultAnnotationPrefix
+func NewAnnotationKeys(prefix string) AnnotationKeys {
+	key := func(name string) string {
+		return prefix + "/" + name
+	}
+	return AnnotationKeys{
+		CacheEnabledLabelKey:   key("cache_enabled"),
+		CachedLabelKey:         key("reused_from_cache"),
+		ExecutionKey:           key("execution_cache_key"),
+		CacheIDLabelKey:        key("cache_id"),
+		MetadataWrittenKey:     key("metadata_written"),
+		ProfileLabelKey:        key("profile"),
+		ComputeSecondsSavedKey: key("cache_compute_seconds_saved"),
+		CacheSourceRunIDKey:    key("cache_source_run_id"),
+		ShadowCacheIDKey:       key("shadow_cache_id"),
+		MetadataExecutionIDKey: key("metadata_execution_id"),
+		MaxCacheStalenessKey:   key("max_cache_staleness"),
+		CacheTTLKey:            key("cache_ttl"),
+		PipelineNameKey:        key("pipeline_name"),
+		PipelineVersionIDKey:   key("pipeline_version_id"),
+		CacheSignatureKey:      key("cache_signature"),
+		CachePredictionKey:     key("cache_prediction"),
+		PredictedCacheKey:      key("predicted_cache_key"),
+		CacheKeyVersionKey:     key("cache_key_version"),
+		PurgeCacheOnDeleteKey:  key("purge-cache-on-delete"),
+	}
+}
+
+// IsValidAnnotationPrefix reports whether the keys under prefix are valid annotation and label
+// keys.
+func IsValidAnnotationPrefix(prefix string) bool {
+	return len(validation.IsDNS1123Subdomain(prefix)) == 0
+}
+
+// orDefault returns the keys, or the keys under DefaultAnnotationPrefix when they are the zero
+// value.
+func (k AnnotationKeys) orDefault() AnnotationKeys {
+	if k.CacheEnabledLabelKey == "" {
+		return NewAnnotationKeys(DefaultAnnotationPrefix)
+	}
+	return k
+}
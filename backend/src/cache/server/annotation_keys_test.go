@@ -0,0 +1,151 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const customAnnotationPrefix string = "workflows.platform.example.com"
+
+// podKeys are the keys under DefaultAnnotationPrefix of the pods of the tests.
+var podKeys = NewAnnotationKeys(DefaultAnnotationPrefix)
+
+func TestNewAnnotationKeys(t *testing.T) {
+	keys := NewAnnotationKeys(DefaultAnnotationPrefix)
+	assert.Equal(t, "pipelines.kubeflow.org/cache_enabled", keys.CacheEnabledLabelKey)
+	assert.Equal(t, "pipelines.kubeflow.org/execution_cache_key", keys.ExecutionKey)
+	assert.Equal(t, "pipelines.kubeflow.org/cache_id", keys.CacheIDLabelKey)
+
+	keys = NewAnnotationKeys(customAnnotationPrefix)
+	assert.Equal(t, "workflows.platform.example.com/cache_enabled", keys.CacheEnabledLabelKey)
+	assert.Equal(t, "workflows.platform.example.com/max_cache_staleness", keys.MaxCacheStalenessKey)
+	assert.Equal(t, "workflows.platform.example.com/cache_signature", keys.CacheSignatureKey)
+}
+
+func TestIsValidAnnotationPrefix(t *testing.T) {
+	assert.True(t, IsValidAnnotationPrefix(DefaultAnnotationPrefix))
+	assert.True(t, IsValidAnnotationPrefix(customAnnotationPrefix))
+	assert.False(t, IsValidAnnotationPrefix(""))
+	assert.False(t, IsValidAnnotationPrefix("platform.example.com/cache"))
+	assert.False(t, IsValidAnnotationPrefix("Platform.example.com"))
+}
+
+// customPrefixPod returns a pod of the template opted in to the cache under the custom prefix.
+func customPrefixPod() *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "step",
+			Annotations: map[string]string{
+				ArgoWorkflowNodeName: "step",
+				ArgoWorkflowTemplate: `{"name": "Does not matter","container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+			},
+			Labels: map[string]string{
+				customAnnotationPrefix + "/cache_enabled": KFPCacheEnabledLabelValue,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "main", Image: "test_image", Command: []string{"python"}}},
+		},
+	}
+}
+
+// withoutDefaultPrefix reports whether none of the keys is under DefaultAnnotationPrefix.
+func withoutDefaultPrefix(fields map[string]string) bool {
+	for key := range fields {
+		if strings.HasPrefix(key, DefaultAnnotationPrefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMutatePodIfCachedUnderCustomAnnotationPrefix(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Keys: NewAnnotationKeys(customAnnotationPrefix)})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(customPrefixPod()), clientManager)
+
+	require.Nil(t, err)
+	require.Equal(t, 3, len(patches))
+	assert.Equal(t, OperationTypeReplace, patches[0].Op, "the pod is served from cache")
+	annotations := patches[1].Value.(map[string]string)
+	labels := patches[2].Value.(map[string]string)
+	assert.Equal(t, entry.ExecutionCacheKey, annotations[customAnnotationPrefix+"/execution_cache_key"])
+	assert.Equal(t, "0", annotations[customAnnotationPrefix+"/cache_compute_seconds_saved"])
+	assert.Equal(t, "1", labels[customAnnotationPrefix+"/cache_id"])
+	assert.Equal(t, KFPCachedLabelValue, labels[customAnnotationPrefix+"/reused_from_cache"])
+	assert.True(t, withoutDefaultPrefix(annotations), "no KFP annotation is added: %v", annotations)
+	assert.True(t, withoutDefaultPrefix(labels), "no KFP label is added: %v", labels)
+
+	// Pods opting in with the KFP label are not those of the orchestrator.
+	kfpPod := customPrefixPod()
+	kfpPod.ObjectMeta.Labels = map[string]string{podKeys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue}
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(kfpPod), clientManager)
+	assert.Nil(t, err)
+	assert.Nil(t, patches)
+}
+
+func TestRecordPodOutputUnderCustomAnnotationPrefix(t *testing.T) {
+	keys := NewAnnotationKeys(customAnnotationPrefix)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	pod.ObjectMeta.Labels = map[string]string{
+		ArgoCompleteLabelKey:      "true",
+		keys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue,
+		keys.CacheIDLabelKey:      "",
+	}
+	pod.ObjectMeta.Annotations[keys.ExecutionKey] = pod.ObjectMeta.Annotations[podKeys.ExecutionKey]
+	delete(pod.ObjectMeta.Annotations, podKeys.ExecutionKey)
+	clientset := fake.NewSimpleClientset(pod)
+	watched := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+
+	require.True(t, recordPodOutput(pod, watched, &cacheEntryWriter{
+		clientManager: watched,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		keys:          keys,
+		metrics:       noopWatcherMetrics{},
+	}, keys, noopWatcherMetrics{}))
+
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "step-key"))
+	patched, err := clientset.CoreV1().Pods(watchedNamespace).Get("step", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "1", patched.ObjectMeta.Labels[customAnnotationPrefix+"/cache_id"])
+	assert.True(t, withoutDefaultPrefix(patched.ObjectMeta.Labels), "no KFP label is added: %v", patched.ObjectMeta.Labels)
+}
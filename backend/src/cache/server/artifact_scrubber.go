@@ -0,0 +1,281 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// DefaultScrubMinAge is how old entries must be for the scrubber to check their artifacts, so
+	// that the artifacts of recent entries are not checked over and over.
+	DefaultScrubMinAge time.Duration = 7 * 24 * time.Hour
+	// DefaultScrubConcurrency is the number of entries whose artifacts are checked at once.
+	DefaultScrubConcurrency int = 4
+	// DefaultScrubQPS and DefaultScrubBurst bound the requests sent to the object store.
+	DefaultScrubQPS   float64 = 10
+	DefaultScrubBurst int     = 10
+
+	// ArtifactScrubberName names the cursor of the artifact scrubber, and is the request ID of the
+	// audit events of the entries it deletes.
+	ArtifactScrubberName string = "artifact-scrubber"
+	// AuditDecisionArtifactsMissing is the decision of the audit events of the entries deleted for
+	// their artifacts no longer existing.
+	AuditDecisionArtifactsMissing string = "artifacts_missing"
+
+	// The outcomes of scrubbing an entry.
+	ScrubOutcomeLive      string = "live"
+	ScrubOutcomeDeleted   string = "deleted"
+	ScrubOutcomeUnchecked string = "unchecked"
+	ScrubOutcomeFailed    string = "failed"
+
+	// scrubPageSize is the number of entries listed at once, whose cursor is saved once they are
+	// all scrubbed.
+	scrubPageSize int = 100
+)
+
+// ArtifactStore tells whether the artifacts of cache entries still exist in the object store.
+type ArtifactStore interface {
+	// ArtifactExists reports whether the object of the key exists in the bucket, the default
+	// bucket of the artifacts when empty.
+	ArtifactExists(bucket string, key string) (bool, error)
+}
+
+// ScrubCursorStore persists where the scrubber stopped, by name.
+type ScrubCursorStore interface {
+	// GetScrubCursor returns the saved cursor, empty when none was.
+	GetScrubCursor(name string) (string, error)
+	SaveScrubCursor(name string, cursor string, nowInSec int64) error
+}
+
+// ArtifactScrubberConfig holds the settings of the artifact scrubber.
+type ArtifactScrubberConfig struct {
+	// Interval is the time between the passes of the scrubber. Zero disables it.
+	Interval time.Duration
+	// MinAge is how long ago the entries checked must have been created, DefaultScrubMinAge when
+	// zero.
+	MinAge time.Duration
+	// Concurrency is the number of entries checked at once, DefaultScrubConcurrency when zero.
+	Concurrency int
+	// QPS is the number of requests sent to the object store per second, with bursts of up to
+	// Burst. Zero or less does not limit them.
+	QPS   float64
+	Burst int
+	// AuditLog records the entries deleted. Nil records nothing.
+	AuditLog *AuditLog
+	// Metrics records the outcome of each entry scrubbed. Nil records nothing.
+	Metrics WatcherMetrics
+}
+
+// ArtifactScrubber deletes the cache entries whose output artifacts no longer exist, e.g. once
+// lifecycle policies of the object store expired them, so that they are neither served nor kept
+// around. Each pass walks the entries older than the minimum age in ID order, checks the S3
+// locations of their artifacts with HEAD requests, and deletes the entries missing any. The cursor
+// of the pass is saved after each page, so that a restarted scrubber resumes where it stopped.
+// Entries whose artifacts cannot be checked are kept.
+type ArtifactScrubber struct {
+	entries   storage.ExecutionCacheAdminStore
+	artifacts ArtifactStore
+	cursors   ScrubCursorStore
+	config    ArtifactScrubberConfig
+	limiter   flowcontrol.RateLimiter
+	time      util.TimeInterface
+}
+
+// factory function for an artifact scrubber of the entries of the store
+func NewArtifactScrubber(entries storage.ExecutionCacheAdminStore, artifacts ArtifactStore, cursors ScrubCursorStore, config ArtifactScrubberConfig) *ArtifactScrubber {
+	return newArtifactScrubber(entries, artifacts, cursors, config, util.NewRealTime())
+}
+
+func newArtifactScrubber(entries storage.ExecutionCacheAdminStore, artifacts ArtifactStore, cursors ScrubCursorStore, config ArtifactScrubberConfig, time util.TimeInterface) *ArtifactScrubber {
+	if config.MinAge <= 0 {
+		config.MinAge = DefaultScrubMinAge
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultScrubConcurrency
+	}
+	if config.Metrics == nil {
+		config.Metrics = noopWatcherMetrics{}
+	}
+	return &ArtifactScrubber{
+		entries:   entries,
+		artifacts: artifacts,
+		cursors:   cursors,
+		config:    config,
+		limiter:   newTokenBucketLimiter(config.QPS, config.Burst),
+		time:      time,
+	}
+}
+
+// run scrubs the entries every interval until ctx is done, starting with the pass the saved cursor
+// is in.
+func (s *ArtifactScrubber) run(ctx context.Context) {
+	if s.config.Interval <= 0 {
+		return
+	}
+	for {
+		if err := s.scrub(ctx); err != nil && ctx.Err() == nil {
+			logger.Errorf("Artifact scrub failed, retrying in %v: %v", s.config.Interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.config.Interval):
+		}
+	}
+}
+
+// scrubReport counts the entries scrubbed during a pass by outcome.
+type scrubReport struct {
+	mu       sync.Mutex
+	outcomes map[string]int
+}
+
+func (r *scrubReport) add(outcome string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes[outcome]++
+}
+
+// scrub walks the entries created more than the minimum age ago from the saved cursor, and starts
+// over once all are walked. The page in progress when ctx is done is walked again on resume.
+func (s *ArtifactScrubber) scrub(ctx context.Context) error {
+	cursor, err := s.cursors.GetScrubCursor(ArtifactScrubberName)
+	if err != nil {
+		return err
+	}
+	if cursor == "" {
+		logger.Info("Starting artifact scrub")
+	} else {
+		logger.Infof("Resuming artifact scrub after entry %s", cursor)
+	}
+	filter := storage.ExecutionCacheFilter{CreatedBeforeInSec: s.time.Now().Add(-s.config.MinAge).Unix()}
+	report := &scrubReport{outcomes: map[string]int{}}
+	for {
+		entries, next, err := s.entries.ListExecutionCaches(ctx, "", filter, scrubPageSize, cursor)
+		if err != nil {
+			return fmt.Errorf("failed to list the entries to scrub: %v", err)
+		}
+		s.scrubPage(ctx, entries, report)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cursor = next
+		if err := s.cursors.SaveScrubCursor(ArtifactScrubberName, cursor, s.time.Now().Unix()); err != nil {
+			// The pass goes on, a restart resumes from the last saved cursor.
+			logger.Warnf("Failed to save the cursor of the artifact scrub: %v", err)
+		}
+		if cursor == "" {
+			break
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		ScrubOutcomeLive:      report.outcomes[ScrubOutcomeLive],
+		ScrubOutcomeDeleted:   report.outcomes[ScrubOutcomeDeleted],
+		ScrubOutcomeUnchecked: report.outcomes[ScrubOutcomeUnchecked],
+		ScrubOutcomeFailed:    report.outcomes[ScrubOutcomeFailed],
+	}).Info("Artifact scrub completed")
+	return nil
+}
+
+// scrubPage scrubs the entries with the configured concurrency until ctx is done.
+func (s *ArtifactScrubber) scrubPage(ctx context.Context, entries []*model.ExecutionCache, report *scrubReport) {
+	queue := make(chan *model.ExecutionCache)
+	var workers sync.WaitGroup
+	for i := 0; i < s.config.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for entry := range queue {
+				outcome := s.scrubEntry(ctx, entry)
+				s.config.Metrics.EntryScrubbed(outcome)
+				report.add(outcome)
+			}
+		}()
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- entry
+	}
+	close(queue)
+	workers.Wait()
+}
+
+// scrubEntry deletes the entry when any of its S3 artifacts no longer exists, and returns the
+// outcome.
+func (s *ArtifactScrubber) scrubEntry(ctx context.Context, entry *model.ExecutionCache) string {
+	entryID := strconv.FormatInt(entry.ID, 10)
+	entryLogger := logger.WithFields(logrus.Fields{
+		logging.FieldCacheID:   entry.ID,
+		logging.FieldNamespace: entry.Namespace,
+	})
+	entryOutputs, err := outputs.Parse(getValueFromSerializedMap(entry.ExecutionOutput, ArgoWorkflowOutputs))
+	if err != nil {
+		entryLogger.Debugf("Not scrubbing cache entry with unreadable outputs: %v", err)
+		return ScrubOutcomeUnchecked
+	}
+	checked := false
+	for _, artifact := range entryOutputs.Artifacts {
+		bucket, key, ok := artifact.S3Location()
+		if !ok {
+			continue
+		}
+		if err := s.limiter.Wait(ctx); err != nil {
+			return ScrubOutcomeFailed
+		}
+		exists, err := s.artifacts.ArtifactExists(bucket, key)
+		if err != nil {
+			entryLogger.Warnf("Failed to check artifact %s of cache entry: %v", artifact.Name, err)
+			return ScrubOutcomeFailed
+		}
+		checked = true
+		if exists {
+			continue
+		}
+		if err := s.entries.DeleteExecutionCacheByID(ctx, entryID); err != nil && !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			entryLogger.Errorf("Failed to delete cache entry whose artifact %s no longer exists: %v", artifact.Name, err)
+			return ScrubOutcomeFailed
+		}
+		entryLogger.Infof("Deleted cache entry whose artifact %s no longer exists at %s", artifact.Name, key)
+		s.config.AuditLog.Record(model.AuditEvent{
+			Timestamp:    time.Now().UTC(),
+			RequestID:    ArtifactScrubberName,
+			Namespace:    entry.Namespace,
+			CacheKey:     entry.ExecutionCacheKey,
+			Decision:     AuditDecisionArtifactsMissing,
+			CacheEntryID: entry.ID,
+			Owner:        entry.Owner,
+		})
+		return ScrubOutcomeDeleted
+	}
+	if !checked {
+		return ScrubOutcomeUnchecked
+	}
+	return ScrubOutcomeLive
+}
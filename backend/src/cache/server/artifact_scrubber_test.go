@@ -0,0 +1,257 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const scrubbedBucket string = "mlpipeline"
+
+// fakeArtifactStore holds the objects of the buckets by bucket/key. The checks of failing keys
+// fail, and checked is called with the number of checks so far on each.
+type fakeArtifactStore struct {
+	mu      sync.Mutex
+	objects map[string]bool
+	failing map[string]bool
+	checks  []string
+	checked func(count int)
+}
+
+func newFakeArtifactStore(objects ...string) *fakeArtifactStore {
+	s := &fakeArtifactStore{objects: map[string]bool{}, failing: map[string]bool{}}
+	for _, object := range objects {
+		s.objects[object] = true
+	}
+	return s
+}
+
+func (s *fakeArtifactStore) ArtifactExists(bucket string, key string) (bool, error) {
+	if bucket == "" {
+		bucket = scrubbedBucket
+	}
+	s.mu.Lock()
+	s.checks = append(s.checks, bucket+"/"+key)
+	count := len(s.checks)
+	exists, failing := s.objects[bucket+"/"+key], s.failing[key]
+	checked := s.checked
+	s.mu.Unlock()
+	if checked != nil {
+		checked(count)
+	}
+	if failing {
+		return false, errors.New("object store unavailable")
+	}
+	return exists, nil
+}
+
+func (s *fakeArtifactStore) checkedObjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.checks...)
+}
+
+// executionOutputWithArtifacts returns the execution output of an entry whose artifacts are
+// stored at the locations, bucket/key or /key for the default bucket.
+func executionOutputWithArtifacts(t *testing.T, locations ...string) string {
+	var artifacts []map[string]interface{}
+	for i, location := range locations {
+		parts := strings.SplitN(location, "/", 2)
+		s3 := map[string]string{"key": parts[1]}
+		if parts[0] != "" {
+			s3["bucket"] = parts[0]
+		}
+		artifacts = append(artifacts, map[string]interface{}{"name": "artifact-" + strconv.Itoa(i), "s3": s3})
+	}
+	argoOutputs, err := json.Marshal(map[string]interface{}{"artifacts": artifacts})
+	require.Nil(t, err)
+	executionOutput, err := json.Marshal(map[string]string{ArgoWorkflowOutputs: string(argoOutputs)})
+	require.Nil(t, err)
+	return string(executionOutput)
+}
+
+// scrubbedEntries stores the entries of the execution outputs, created at the epoch.
+func scrubbedEntries(t *testing.T, store *storage.ExecutionCacheStore, executionOutputs ...string) []*model.ExecutionCache {
+	var entries []*model.ExecutionCache
+	for i, executionOutput := range executionOutputs {
+		entry, err := store.CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: "key-" + strconv.Itoa(i),
+			ExecutionOutput:   executionOutput,
+			ExecutionTemplate: `{"container":{"image":"python:3.7"}}`,
+			MaxCacheStaleness: -1,
+			Namespace:         "ns1",
+		})
+		require.Nil(t, err)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func remainingEntryKeys(t *testing.T, store *storage.ExecutionCacheStore) []string {
+	entries, _, err := store.ListExecutionCaches(context.Background(), "", storage.ExecutionCacheFilter{}, 0, "")
+	require.Nil(t, err)
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.ExecutionCacheKey)
+	}
+	return keys
+}
+
+// newTestArtifactScrubber returns a scrubber of the entries of the store, 30 days after the epoch.
+func newTestArtifactScrubber(t *testing.T, db *storage.DB, store *storage.ExecutionCacheStore, artifacts ArtifactStore, config ArtifactScrubberConfig) (*ArtifactScrubber, *storage.ScrubCursorStore) {
+	cursors, err := storage.NewScrubCursorStore(db)
+	require.Nil(t, err)
+	return newArtifactScrubber(store, artifacts, cursors, config, util.NewFakeTime(time.Unix(0, 0).Add(30*24*time.Hour))), cursors
+}
+
+func TestArtifactScrubberDeletesEntriesWithMissingArtifacts(t *testing.T) {
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	sink := &recordingAuditSink{}
+	log := NewAuditLog(sink, 10, prometheus.NewRegistry())
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	artifacts := newFakeArtifactStore("mlpipeline/run-1/model.tgz", "mlpipeline/run-1/metrics.tgz", "other/run-3/model.tgz", "mlpipeline/run-4/model.tgz")
+	artifacts.failing["run-5/model.tgz"] = true
+
+	entries := scrubbedEntries(t, store,
+		executionOutputWithArtifacts(t, "mlpipeline/run-1/model.tgz", "/run-1/metrics.tgz"),
+		executionOutputWithArtifacts(t, "mlpipeline/run-2/model.tgz"),
+		executionOutputWithArtifacts(t, "other/run-3/model.tgz", "mlpipeline/run-3/metrics.tgz"),
+		testExecutionOutput,
+		executionOutputWithArtifacts(t, "mlpipeline/run-5/model.tgz"),
+		"not outputs",
+	)
+	// Entries younger than the minimum age are not checked.
+	young := storage.NewExecutionCacheStore(db, util.NewFakeTime(time.Unix(0, 0).Add(29*24*time.Hour)))
+	scrubbedEntries(t, young, executionOutputWithArtifacts(t, "mlpipeline/run-6/model.tgz"))
+	scrubber, cursors := newTestArtifactScrubber(t, db, store, artifacts, ArtifactScrubberConfig{Interval: time.Hour, Concurrency: 3, AuditLog: log, Metrics: metrics})
+
+	require.Nil(t, scrubber.scrub(context.Background()))
+
+	assert.Equal(t, []string{"key-0", "key-3", "key-4", "key-5", "key-0"}, remainingEntryKeys(t, store),
+		"the entries missing an artifact are deleted, those that cannot be checked and the young one are kept")
+	assert.NotContains(t, artifacts.checkedObjects(), "mlpipeline/run-6/model.tgz")
+	cursor, err := cursors.GetScrubCursor(ArtifactScrubberName)
+	require.Nil(t, err)
+	assert.Equal(t, "", cursor, "the next pass starts over")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.scrubbedEntries.WithLabelValues(ScrubOutcomeLive)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.scrubbedEntries.WithLabelValues(ScrubOutcomeDeleted)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.scrubbedEntries.WithLabelValues(ScrubOutcomeUnchecked)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.scrubbedEntries.WithLabelValues(ScrubOutcomeFailed)))
+
+	require.Nil(t, log.Close())
+	events := sink.written()
+	require.Len(t, events, 2)
+	var deletedIDs []int64
+	for _, event := range events {
+		assert.Equal(t, ArtifactScrubberName, event.RequestID)
+		assert.Equal(t, AuditDecisionArtifactsMissing, event.Decision)
+		assert.Equal(t, "ns1", event.Namespace)
+		assert.False(t, event.Timestamp.IsZero())
+		deletedIDs = append(deletedIDs, event.CacheEntryID)
+	}
+	assert.ElementsMatch(t, []int64{entries[1].ID, entries[2].ID}, deletedIDs)
+}
+
+func TestArtifactScrubberResumesFromTheSavedCursor(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	var executionOutputs []string
+	for i := 0; i < 2*scrubPageSize+10; i++ {
+		executionOutputs = append(executionOutputs, executionOutputWithArtifacts(t, "mlpipeline/run-"+strconv.Itoa(i)+"/model.tgz"))
+	}
+	entries := scrubbedEntries(t, store, executionOutputs...)
+	artifacts := newFakeArtifactStore()
+	for i := range entries {
+		artifacts.objects["mlpipeline/run-"+strconv.Itoa(i)+"/model.tgz"] = true
+	}
+	scrubber, cursors := newTestArtifactScrubber(t, db, store, artifacts, ArtifactScrubberConfig{Interval: time.Hour, Concurrency: 1})
+
+	// The scrubber stops within the second page.
+	ctx, stop := context.WithCancel(context.Background())
+	artifacts.checked = func(count int) {
+		if count == scrubPageSize+scrubPageSize/2 {
+			stop()
+		}
+	}
+	assert.Equal(t, context.Canceled, scrubber.scrub(ctx))
+	cursor, err := cursors.GetScrubCursor(ArtifactScrubberName)
+	require.Nil(t, err)
+	assert.Equal(t, strconv.FormatInt(entries[scrubPageSize-1].ID, 10), cursor, "the cursor of the scrubbed pages is saved")
+
+	// A restarted scrubber resumes with the page it stopped in.
+	artifacts.checked = nil
+	checksBefore := len(artifacts.checkedObjects())
+	scrubber, _ = newTestArtifactScrubber(t, db, store, artifacts, ArtifactScrubberConfig{Interval: time.Hour, Concurrency: 1})
+	require.Nil(t, scrubber.scrub(context.Background()))
+	resumed := artifacts.checkedObjects()[checksBefore:]
+	require.Len(t, resumed, len(entries)-scrubPageSize)
+	assert.Equal(t, "mlpipeline/run-"+strconv.Itoa(scrubPageSize)+"/model.tgz", resumed[0])
+	cursor, err = cursors.GetScrubCursor(ArtifactScrubberName)
+	require.Nil(t, err)
+	assert.Equal(t, "", cursor)
+	assert.Len(t, remainingEntryKeys(t, store), len(entries))
+}
+
+func TestArtifactScrubberRateLimitsTheObjectStore(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	var executionOutputs []string
+	for i := 0; i < 6; i++ {
+		executionOutputs = append(executionOutputs, executionOutputWithArtifacts(t, "/run-"+strconv.Itoa(i)+"/model.tgz"))
+	}
+	scrubbedEntries(t, store, executionOutputs...)
+	artifacts := newFakeArtifactStore()
+	scrubber, _ := newTestArtifactScrubber(t, db, store, artifacts, ArtifactScrubberConfig{Interval: time.Hour, Concurrency: 6, QPS: 20, Burst: 1})
+
+	started := time.Now()
+	require.Nil(t, scrubber.scrub(context.Background()))
+
+	assert.Len(t, artifacts.checkedObjects(), 6)
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(200*time.Millisecond), "6 checks at 20 per second with bursts of 1 take about 250ms")
+	assert.Empty(t, remainingEntryKeys(t, store))
+}
+
+func TestArtifactScrubberWithoutIntervalDoesNotRun(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	scrubbedEntries(t, store, executionOutputWithArtifacts(t, "/run-0/model.tgz"))
+	artifacts := newFakeArtifactStore()
+	scrubber, _ := newTestArtifactScrubber(t, db, store, artifacts, ArtifactScrubberConfig{})
+
+	scrubber.run(context.Background())
+
+	assert.Empty(t, artifacts.checkedObjects())
+	assert.Len(t, remainingEntryKeys(t, store), 1)
+}
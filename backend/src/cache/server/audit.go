@@ -0,0 +1,202 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	AuditSinkStdout string = "stdout"
+	AuditSinkFile   string = "file"
+	AuditSinkDB     string = "db"
+
+	// DefaultAuditBufferSize is the number of audit events waiting for a slow sink before further
+	// events are dropped.
+	DefaultAuditBufferSize int = 1000
+	// DefaultAuditFileMaxBytes and DefaultAuditFileMaxBackups keep the audit files of the file sink
+	// within 600MB.
+	DefaultAuditFileMaxBytes   int = 100 << 20
+	DefaultAuditFileMaxBackups int = 5
+)
+
+// AuditSink stores audit events. It is only called from the goroutine of its AuditLog.
+type AuditSink interface {
+	WriteAuditEvent(event *model.AuditEvent) error
+	Close() error
+}
+
+// JSONAuditSink writes one JSON object per line and event.
+type JSONAuditSink struct {
+	writer io.Writer
+	// closer is closed with the sink, unless the writer is not owned by the sink, e.g. stdout.
+	closer io.Closer
+}
+
+// factory function for a JSONAuditSink writing to the writer, which is left open
+func NewJSONAuditSink(writer io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{writer: writer}
+}
+
+// factory function for a JSONAuditSink appending to the file, which is rotated once it reaches
+// maxBytes, keeping maxBackups rotated files
+func NewFileAuditSink(path string, maxBytes int64, maxBackups int) (*JSONAuditSink, error) {
+	file, err := OpenRotatingFile(path, maxBytes, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONAuditSink{writer: file, closer: file}, nil
+}
+
+func (s *JSONAuditSink) WriteAuditEvent(event *model.AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// The line is written at once, so that a rotating file is not rotated within an event.
+	_, err = s.writer.Write(append(b, '\n'))
+	return err
+}
+
+func (s *JSONAuditSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// AuditLog hands audit events over to its sink in the background, so that admissions never wait
+// for, or fail because of, the sink. Events that find the buffer full are dropped and counted.
+type AuditLog struct {
+	sink   AuditSink
+	events chan model.AuditEvent
+	done   chan struct{}
+
+	// mu guards closed, so that no event is recorded after the events channel was closed.
+	mu     sync.RWMutex
+	closed bool
+
+	dropped  prometheus.Counter
+	failures prometheus.Counter
+}
+
+// factory function for an AuditLog buffering up to bufferSize events for the sink
+func NewAuditLog(sink AuditSink, bufferSize int, registerer prometheus.Registerer) *AuditLog {
+	if bufferSize <= 0 {
+		bufferSize = DefaultAuditBufferSize
+	}
+	l := &AuditLog{
+		sink:   sink,
+		events: make(chan model.AuditEvent, bufferSize),
+		done:   make(chan struct{}),
+		dropped: registerAuditCounter(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_audit_events_dropped_total",
+			Help: "Audit events dropped because the audit sink could not keep up.",
+		})),
+		failures: registerAuditCounter(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_audit_write_failures_total",
+			Help: "Audit events the audit sink failed to write.",
+		})),
+	}
+	go l.run()
+	return l
+}
+
+// registerAuditCounter registers the counter, or returns the one registered before when the audit
+// log is created again.
+func registerAuditCounter(registerer prometheus.Registerer, counter prometheus.Counter) prometheus.Counter {
+	registered, err := metrics.RegisterOrReuse(registerer, counter)
+	if err != nil {
+		logger.Errorf("Failed to register audit log metrics: %v", err)
+	}
+	return registered.(prometheus.Counter)
+}
+
+func (l *AuditLog) run() {
+	defer close(l.done)
+	for event := range l.events {
+		if err := l.sink.WriteAuditEvent(&event); err != nil {
+			l.failures.Inc()
+			logger.Warnf("Failed to write audit event of request %s: %v", event.RequestID, err)
+		}
+	}
+}
+
+// Record queues the event without blocking. A nil AuditLog records nothing.
+func (l *AuditLog) Record(event model.AuditEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.dropped.Inc()
+		return
+	}
+	select {
+	case l.events <- event:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+// Close writes the queued events and closes the sink. Events recorded afterwards are dropped.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.events)
+	}
+	l.mu.Unlock()
+	<-l.done
+	return l.sink.Close()
+}
+
+type auditEventKey struct{}
+
+// contextWithAuditEvent returns a context carrying the audit event of an admission, which the
+// admit function fills in as it learns about the pod.
+func contextWithAuditEvent(ctx context.Context, event *model.AuditEvent) context.Context {
+	return context.WithValue(ctx, auditEventKey{}, event)
+}
+
+// auditEventFrom returns the audit event of the admission of ctx, or a discarded one when the
+// admission is not audited.
+func auditEventFrom(ctx context.Context) *model.AuditEvent {
+	if event, ok := ctx.Value(auditEventKey{}).(*model.AuditEvent); ok {
+		return event
+	}
+	return &model.AuditEvent{}
+}
+
+// recordAuditEvent records the event once the admission reached a decision.
+func (wh *Webhook) recordAuditEvent(event *model.AuditEvent) {
+	if event.Decision == "" {
+		return
+	}
+	event.Timestamp = time.Now().UTC()
+	wh.auditLog.Record(*event)
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordingAuditSink keeps the events written to it. While block is open, writes wait for it.
+type recordingAuditSink struct {
+	mu      sync.Mutex
+	events  []model.AuditEvent
+	writing chan struct{}
+	block   chan struct{}
+	closed  bool
+}
+
+func (s *recordingAuditSink) WriteAuditEvent(event *model.AuditEvent) error {
+	if s.block != nil {
+		s.writing <- struct{}{}
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *recordingAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingAuditSink) written() []model.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.AuditEvent(nil), s.events...)
+}
+
+func decodeAuditLines(t *testing.T, content []byte) []model.AuditEvent {
+	var events []model.AuditEvent
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		var event model.AuditEvent
+		require.Nil(t, json.Unmarshal([]byte(line), &event), line)
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestJSONAuditSinkWritesOneLinePerEvent(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJSONAuditSink(&out)
+	timestamp := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	require.Nil(t, sink.WriteAuditEvent(&model.AuditEvent{Timestamp: timestamp, RequestID: "request-1", Namespace: "ns1", Decision: AdmissionOutcomeMiss, CacheKey: "key1"}))
+	require.Nil(t, sink.WriteAuditEvent(&model.AuditEvent{Timestamp: timestamp, RequestID: "request-2", Namespace: "ns1", Decision: AdmissionOutcomeHit, CacheEntryID: 7}))
+	require.Nil(t, sink.Close())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `{"timestamp":"2020-06-01T12:00:00Z","requestId":"request-1","namespace":"ns1","cacheKey":"key1","decision":"miss","cacheEnabled":false,"enforceOwner":false}`, lines[0])
+	events := decodeAuditLines(t, out.Bytes())
+	assert.Equal(t, int64(7), events[1].CacheEntryID)
+}
+
+func TestFileAuditSinkRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewFileAuditSink(path, 300, 2)
+	require.Nil(t, err)
+
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		require.Nil(t, sink.WriteAuditEvent(&model.AuditEvent{RequestID: "request-" + id, Namespace: "ns1", Decision: AdmissionOutcomeMiss}))
+	}
+	require.Nil(t, sink.Close())
+
+	var requestIDs []string
+	for _, name := range []string{"audit.log.2", "audit.log.1", "audit.log"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.Nil(t, err)
+		assert.True(t, len(content) <= 300, "%s has %d bytes", name, len(content))
+		for _, event := range decodeAuditLines(t, content) {
+			requestIDs = append(requestIDs, event.RequestID)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, "audit.log.3"))
+	assert.True(t, os.IsNotExist(err), "backups beyond the maximum are removed")
+	// Each file holds whole events, the oldest ones are gone with the removed backup.
+	assert.Equal(t, []string{"request-3", "request-4", "request-5", "request-6", "request-7", "request-8"}, requestIDs)
+
+	// A reopened file keeps growing from its current size.
+	sink, err = NewFileAuditSink(path, 300, 2)
+	require.Nil(t, err)
+	require.Nil(t, sink.WriteAuditEvent(&model.AuditEvent{RequestID: "request-9", Namespace: "ns1", Decision: AdmissionOutcomeMiss}))
+	require.Nil(t, sink.Close())
+	content, err := ioutil.ReadFile(filepath.Join(dir, "audit.log.1"))
+	require.Nil(t, err)
+	assert.Equal(t, "request-7", decodeAuditLines(t, content)[0].RequestID)
+}
+
+func TestAuditLogDropsEventsWhileSinkIsSlow(t *testing.T) {
+	sink := &recordingAuditSink{writing: make(chan struct{}), block: make(chan struct{})}
+	log := NewAuditLog(sink, 1, prometheus.NewRegistry())
+
+	log.Record(model.AuditEvent{RequestID: "request-1"})
+	<-sink.writing
+	// The sink is stuck on the first event, the second one fills the buffer.
+	start := time.Now()
+	for _, id := range []string{"2", "3", "4", "5"} {
+		log.Record(model.AuditEvent{RequestID: "request-" + id})
+	}
+	assert.True(t, time.Since(start) < time.Second, "recording does not wait for the sink")
+	assert.Equal(t, float64(3), testutil.ToFloat64(log.dropped))
+
+	close(sink.block)
+	go func() {
+		for range sink.writing {
+		}
+	}()
+	require.Nil(t, log.Close())
+	close(sink.writing)
+	var requestIDs []string
+	for _, event := range sink.written() {
+		requestIDs = append(requestIDs, event.RequestID)
+	}
+	assert.Equal(t, []string{"request-1", "request-2"}, requestIDs)
+	assert.True(t, sink.closed)
+
+	log.Record(model.AuditEvent{RequestID: "request-6"})
+	assert.Equal(t, float64(4), testutil.ToFloat64(log.dropped), "events recorded after closing are dropped")
+}
+
+func TestAdmissionsAreAudited(t *testing.T) {
+	sink := &recordingAuditSink{}
+	log := NewAuditLog(sink, 10, prometheus.NewRegistry())
+	webhook := NewWebhook(WebhookConfig{
+		Mutation: MutationConfig{EnforceOwner: true, FailPolicy: FailPolicyOpen},
+		AuditLog: log,
+	})
+
+	template := `{"name": "audited","container":{"command":["echo", "audited"],"image":"python:3.7"}}`
+	key, err := generateCacheKeyFromTemplate(template)
+	require.Nil(t, err)
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.GenerateName = "pipeline-abc-"
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = template
+	pod.ObjectMeta.Annotations[podKeys.MaxCacheStalenessKey] = "P30D"
+	serveAdmissionReview(t, webhook, GetFakeRequestFromPod(pod), webhook.MutatePodIfCached)
+	entry, err := fakeClientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+		Owner:             podKeys.getPodOwner(pod, "default"),
+	})
+	require.Nil(t, err)
+	defer fakeClientManager.CacheStore().DeleteExecutionCache(context.Background(), entry.ExecutionCacheKey)
+	serveAdmissionReview(t, webhook, GetFakeRequestFromPod(pod), webhook.MutatePodIfCached)
+	serveAdmissionReview(t, webhook, GetFakeRequestFromPod(&corev1.Pod{}), webhook.MutatePodIfCached)
+	require.Nil(t, log.Close())
+
+	events := sink.written()
+	require.Len(t, events, 3)
+	for i := range events {
+		assert.False(t, events[i].Timestamp.IsZero())
+		events[i].Timestamp = time.Time{}
+	}
+	miss := model.AuditEvent{
+		RequestID:         string(fakeAdmissionRequest.UID),
+		Namespace:         "default",
+		PodGenerateName:   "pipeline-abc-",
+		NodeName:          "test_node",
+		CacheKey:          key,
+		Decision:          AdmissionOutcomeMiss,
+		CacheEnabled:      true,
+		MaxCacheStaleness: "P30D",
+		Owner:             podKeys.getPodOwner(pod, "default"),
+		EnforceOwner:      true,
+		FailPolicy:        FailPolicyOpen,
+	}
+	assert.Equal(t, miss, events[0])
+	hit := miss
+	hit.Decision = AdmissionOutcomeHit
+	hit.CacheEntryID = entry.ID
+	assert.Equal(t, hit, events[1])
+	assert.Equal(t, AdmissionOutcomeSkippedNotKFP, events[2].Decision)
+	assert.False(t, events[2].CacheEnabled)
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+const (
+	// DefaultBackfillMaxAge bounds the age of the succeeded pods recorded by the backfill.
+	DefaultBackfillMaxAge time.Duration = 7 * 24 * time.Hour
+
+	// backfillPageSize is the number of pods listed at once by the backfill.
+	backfillPageSize int64 = 500
+)
+
+// backfillResult counts the pods seen by a backfill.
+type backfillResult struct {
+	// Listed is the number of succeeded pods listed, Eligible of those carrying an execution key,
+	// not labeled with a cache entry yet and completed within the max age.
+	Listed   int
+	Eligible int
+	// Added is the number of entries created, Failed the number of eligible pods whose entry
+	// could not be written.
+	Added  int
+	Failed int
+}
+
+// backfillWriter writes the entries of the backfilled pods one at a time, counting those created.
+type backfillWriter struct {
+	*cacheEntryWriter
+	added int
+}
+
+func (w *backfillWriter) write(entry *model.ExecutionCache, pod *corev1.Pod) bool {
+	written, created, ok := w.create(entry, pod, false)
+	if !ok {
+		return false
+	}
+	if created {
+		w.added++
+	}
+	w.label(written, []*corev1.Pod{pod})
+	return true
+}
+
+// backfillPods records the outputs of the succeeded pods of the namespaces, e.g. those completed
+// before the cache was installed or while it was down, which the watcher does not follow when
+// they lack the cache_id label. Pods that completed longer than maxAge ago are left out, unless
+// maxAge is zero. Recorded pods are labeled with their entry and entries are only created once per
+// outputs, so that the backfill can run again.
+func backfillPods(ctx context.Context, namespaces []string, clientManager ClientManagerInterface, writer *backfillWriter, maxAge time.Duration, time util.TimeInterface) (result backfillResult, err error) {
+	defer func() { result.Added = writer.added }()
+	for _, namespace := range namespaces {
+		pods := clientManager.KubernetesCoreClient().PodClient(namespace)
+		options := metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("status.phase", string(corev1.PodSucceeded)).String(),
+			Limit:         backfillPageSize,
+		}
+		for {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			list, err := pods.List(options)
+			if err != nil {
+				return result, err
+			}
+			for i := range list.Items {
+				pod := &list.Items[i]
+				if pod.Status.Phase != corev1.PodSucceeded {
+					continue
+				}
+				result.Listed++
+				if !isBackfillEligible(pod, writer.keys, maxAge, time) {
+					continue
+				}
+				result.Eligible++
+				if !recordPodOutput(pod, clientManager, writer, writer.keys, writer.metrics) {
+					result.Failed++
+				}
+			}
+			if list.Continue == "" {
+				break
+			}
+			options.Continue = list.Continue
+		}
+	}
+	return result, nil
+}
+
+// isBackfillEligible reports whether the succeeded pod is to be recorded by the backfill.
+func isBackfillEligible(pod *corev1.Pod, keys AnnotationKeys, maxAge time.Duration, time util.TimeInterface) bool {
+	if _, exists := pod.ObjectMeta.Annotations[keys.ExecutionKey]; !exists || keys.isCacheWriten(pod.ObjectMeta.Labels) {
+		return false
+	}
+	if maxAge <= 0 {
+		return true
+	}
+	completedAt := podCompletedAt(pod)
+	return !completedAt.IsZero() && !completedAt.Before(time.Now().Add(-maxAge))
+}
+
+// runBackfill backfills the pods of the namespaces and logs the outcome.
+func runBackfill(ctx context.Context, namespaces []string, clientManager ClientManagerInterface, writer *backfillWriter, maxAge time.Duration, time util.TimeInterface) {
+	logger.Info("Backfilling the cache from the succeeded pods")
+	result, err := backfillPods(ctx, namespaces, clientManager, writer, maxAge, time)
+	if err != nil {
+		logger.Errorf("Backfill stopped after adding %d cache entries from %d eligible of %d succeeded pods: %v",
+			result.Added, result.Eligible, result.Listed, err)
+		return
+	}
+	logger.Infof("Backfill added %d cache entries from %d eligible of %d succeeded pods, %d could not be written",
+		result.Added, result.Eligible, result.Listed, result.Failed)
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// uninstalledCachePod returns a pod that succeeded while the cache was not installed, without the
+// cache_id label the webhook adds.
+func uninstalledCachePod(name string, before time.Duration) *corev1.Pod {
+	pod := completedPod(name, before)
+	delete(pod.ObjectMeta.Labels, podKeys.CacheIDLabelKey)
+	return pod
+}
+
+// backfillPodMix returns succeeded pods to backfill among pods not to.
+func backfillPodMix() []runtime.Object {
+	running := cacheablePod("running")
+	running.Status.Phase = corev1.PodRunning
+	failed := completedPod("failed", time.Hour)
+	failed.Status.Phase = corev1.PodFailed
+	withoutKey := uninstalledCachePod("without-key", time.Hour)
+	delete(withoutKey.ObjectMeta.Annotations, podKeys.ExecutionKey)
+	recorded := completedPod("recorded", time.Hour)
+	recorded.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "7"
+	return []runtime.Object{
+		uninstalledCachePod("uninstalled", time.Hour),
+		completedPod("down", 2*time.Hour),
+		uninstalledCachePod("too-old", 30*24*time.Hour),
+		running, failed, withoutKey, recorded,
+	}
+}
+
+func newTestBackfillWriter(clientManager ClientManagerInterface) *backfillWriter {
+	return &backfillWriter{cacheEntryWriter: &cacheEntryWriter{
+		clientManager: clientManager,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		keys:          podKeys,
+		metrics:       noopWatcherMetrics{},
+	}}
+}
+
+func TestBackfillPodsRecordsEligibleSucceededPods(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset(backfillPodMix()...)
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	backfill := func() backfillResult {
+		result, err := backfillPods(context.Background(), []string{watchedNamespace}, watchedClientManager,
+			newTestBackfillWriter(watchedClientManager), DefaultBackfillMaxAge, util.NewFakeTime(watcherStartTime))
+		require.Nil(t, err)
+		return result
+	}
+
+	assert.Equal(t, backfillResult{Listed: 5, Eligible: 2, Added: 2}, backfill())
+
+	for _, name := range []string{"uninstalled", "down"} {
+		assert.Equal(t, 1, countCacheEntries(t, clientManager, name+"-key"), name)
+		pod, err := clientset.CoreV1().Pods(watchedNamespace).Get(name, metav1.GetOptions{})
+		require.Nil(t, err)
+		assert.NotEmpty(t, pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey], name)
+	}
+	for _, name := range []string{"too-old", "running", "failed", "recorded"} {
+		assert.Equal(t, 0, countCacheEntries(t, clientManager, name+"-key"), name)
+	}
+	// Backfilling again adds nothing.
+	assert.Equal(t, backfillResult{Listed: 5}, backfill())
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "uninstalled-key"))
+}
+
+func TestBackfillPodsAddsNoEntryForPodsRecordedButNotLabeled(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	// The entry of the pod was written but the watcher stopped before labeling it.
+	pod := uninstalledCachePod("interrupted", time.Hour)
+	require.True(t, recordPodOutputNow(pod, clientManager, noopWatcherMetrics{}))
+	clientset := fake.NewSimpleClientset(pod)
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+
+	result, err := backfillPods(context.Background(), []string{watchedNamespace}, watchedClientManager,
+		newTestBackfillWriter(watchedClientManager), 0, util.NewFakeTime(watcherStartTime))
+
+	require.Nil(t, err)
+	assert.Equal(t, backfillResult{Listed: 1, Eligible: 1}, result)
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "interrupted-key"))
+	labeled, err := clientset.CoreV1().Pods(watchedNamespace).Get("interrupted", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.NotEmpty(t, labeled.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+func TestWatchPodsBackfillsOnStart(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset(backfillPodMix()...)
+	config := WatcherConfig{BackfillOnStart: true, BackfillMaxAge: DefaultBackfillMaxAge}
+
+	stop := startWatchingPods(clientset, clientManager, config)
+	defer stop()
+
+	// The watcher does not follow the pod without cache_id label.
+	require.Eventually(t, hasCacheEntries(clientManager, "uninstalled-key", 1), 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,334 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// CacheKeyVersion is the latest version of the key strategies of cacheKeyStrategies.
+const CacheKeyVersion string = CacheKeyVersionV2
+
+// cacheKeySkeleton selects the parts of the Argo template that affect the cache key. A nil value
+// keeps the whole field, a map keeps the fields of the object it lists. Other fields, such as the
+// outputs or the archiveLocation, do not affect the key.
+var cacheKeySkeleton = map[string]interface{}{
+	"container": map[string]interface{}{
+		"image":        nil,
+		"command":      nil,
+		"args":         nil,
+		"env":          nil,
+		"volumeMounts": nil,
+	},
+	"inputs":         nil,
+	"volumes":        nil,
+	"initContainers": nil,
+	"sidecars":       nil,
+}
+
+// generateCacheKeyFromTemplate hashes the parts of the template selected by cacheKeySkeleton, in
+// the canonical form of writeCanonicalJSON. The keys were first generated by unmarshaling the
+// template and marshaling a map of these parts, which this form equals without building the map.
+// It is the key strategy of CacheKeyVersionV1, whose keys must never change.
+func generateCacheKeyFromTemplate(template string) (string, error) {
+	skeleton, err := templateSkeleton(template)
+	if err != nil {
+		return "", err
+	}
+	md := sha256.Sum256(skeleton)
+	return hex.EncodeToString(md[:]), nil
+}
+
+// templateSkeleton returns the parts of the template selected by cacheKeySkeleton, in the
+// canonical form of writeCanonicalJSON.
+func templateSkeleton(template string) ([]byte, error) {
+	data := []byte(template)
+	if !json.Valid(data) {
+		// Unmarshaling reports where the template is invalid.
+		return nil, json.Unmarshal(data, &struct{}{})
+	}
+	var b bytes.Buffer
+	b.Grow(len(data))
+	if err := writeSkeletonFields(&b, data, skipWhitespace(data, 0), cacheKeySkeleton); err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// writeSkeletonFields writes the fields of the JSON object starting at data[i] that the skeleton
+// selects as a canonical object. A null object is written as an empty one.
+func writeSkeletonFields(b *bytes.Buffer, data []byte, i int, skeleton map[string]interface{}) error {
+	if data[i] == 'n' {
+		b.WriteString("{}")
+		return nil
+	}
+	if data[i] != '{' {
+		return fmt.Errorf("expected an object at offset %d", i)
+	}
+	members, _ := objectMembers(data, i)
+	b.WriteByte('{')
+	written := 0
+	for _, member := range members {
+		skeletonValue, selected := skeleton[string(member.key)]
+		if !selected {
+			continue
+		}
+		if written > 0 {
+			b.WriteByte(',')
+		}
+		written++
+		writeCanonicalString(b, member.key)
+		b.WriteByte(':')
+		var err error
+		if skeletonValue == nil {
+			_, err = writeCanonicalJSON(b, data, member.value)
+		} else {
+			err = writeSkeletonFields(b, data, member.value, skeletonValue.(map[string]interface{}))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// writeCanonicalJSON writes the JSON value starting at data[i] as json.Marshal writes it once
+// unmarshaled into an interface{}: without whitespace, with the keys of objects sorted, numbers
+// formatted as float64s and strings escaped alike. Strings and integers already in that form, as
+// most are, are copied. It returns the offset following the value. data must be valid JSON.
+func writeCanonicalJSON(b *bytes.Buffer, data []byte, i int) (int, error) {
+	switch data[i] {
+	case '{':
+		members, end := objectMembers(data, i)
+		b.WriteByte('{')
+		for m, member := range members {
+			if m > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, member.key)
+			b.WriteByte(':')
+			if _, err := writeCanonicalJSON(b, data, member.value); err != nil {
+				return 0, err
+			}
+		}
+		b.WriteByte('}')
+		return end, nil
+	case '[':
+		b.WriteByte('[')
+		i = skipWhitespace(data, i+1)
+		for elements := 0; data[i] != ']'; elements++ {
+			if elements > 0 {
+				b.WriteByte(',')
+			}
+			end, err := writeCanonicalJSON(b, data, i)
+			if err != nil {
+				return 0, err
+			}
+			i = skipWhitespace(data, end)
+			if data[i] == ',' {
+				i = skipWhitespace(data, i+1)
+			}
+		}
+		b.WriteByte(']')
+		return i + 1, nil
+	case '"':
+		end := skipString(data, i)
+		if isCanonicalString(data[i+1 : end-1]) {
+			b.Write(data[i:end])
+			return end, nil
+		}
+		var s string
+		if err := json.Unmarshal(data[i:end], &s); err != nil {
+			return 0, err
+		}
+		return end, writeMarshaled(b, s)
+	case 't':
+		b.WriteString("true")
+		return i + len("true"), nil
+	case 'f':
+		b.WriteString("false")
+		return i + len("false"), nil
+	case 'n':
+		b.WriteString("null")
+		return i + len("null"), nil
+	}
+	end := skipNumber(data, i)
+	if isCanonicalInteger(data[i:end]) {
+		b.Write(data[i:end])
+		return end, nil
+	}
+	var f float64
+	if err := json.Unmarshal(data[i:end], &f); err != nil {
+		return 0, err
+	}
+	return end, writeMarshaled(b, f)
+}
+
+// jsonMember is a member of a JSON object, whose value starts at the offset value of the data.
+type jsonMember struct {
+	key   []byte
+	value int
+}
+
+// objectMembers returns the members of the JSON object starting at data[i] sorted by key, keeping
+// the last member of duplicate keys as unmarshaling does, and the offset following the object.
+func objectMembers(data []byte, i int) ([]jsonMember, int) {
+	var members []jsonMember
+	i = skipWhitespace(data, i+1)
+	for data[i] != '}' {
+		keyEnd := skipString(data, i)
+		key := data[i+1 : keyEnd-1]
+		if bytes.IndexByte(key, '\\') >= 0 {
+			var unescaped string
+			// The key is valid, so it unmarshals.
+			json.Unmarshal(data[i:keyEnd], &unescaped)
+			key = []byte(unescaped)
+		}
+		i = skipWhitespace(data, keyEnd)
+		i = skipWhitespace(data, i+1)
+		members = append(members, jsonMember{key: key, value: i})
+		i = skipWhitespace(data, skipValue(data, i))
+		if data[i] == ',' {
+			i = skipWhitespace(data, i+1)
+		}
+	}
+	// Objects have few members and are mostly sorted already, which insertion sort is fast on
+	// without allocating. It is stable, so the last of duplicate keys stays last.
+	for m := 1; m < len(members); m++ {
+		for n := m; n > 0 && bytes.Compare(members[n-1].key, members[n].key) > 0; n-- {
+			members[n-1], members[n] = members[n], members[n-1]
+		}
+	}
+	unique := members[:0]
+	for m, member := range members {
+		if m+1 < len(members) && bytes.Equal(members[m+1].key, member.key) {
+			continue
+		}
+		unique = append(unique, member)
+	}
+	return unique, i + 1
+}
+
+// skipValue returns the offset following the JSON value starting at data[i].
+func skipValue(data []byte, i int) int {
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for ; ; i++ {
+			switch data[i] {
+			case '"':
+				i = skipString(data, i) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+	case 't':
+		return i + len("true")
+	case 'f':
+		return i + len("false")
+	case 'n':
+		return i + len("null")
+	}
+	return skipNumber(data, i)
+}
+
+// skipString returns the offset following the JSON string starting at data[i].
+func skipString(data []byte, i int) int {
+	for i++; data[i] != '"'; i++ {
+		if data[i] == '\\' {
+			i++
+		}
+	}
+	return i + 1
+}
+
+func skipNumber(data []byte, i int) int {
+	for ; i < len(data); i++ {
+		switch c := data[i]; {
+		case c >= '0' && c <= '9', c == '-', c == '+', c == '.', c == 'e', c == 'E':
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func skipWhitespace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func writeCanonicalString(b *bytes.Buffer, s []byte) {
+	if isCanonicalString(s) {
+		b.WriteByte('"')
+		b.Write(s)
+		b.WriteByte('"')
+		return
+	}
+	// Marshaling a string does not fail.
+	writeMarshaled(b, string(s))
+}
+
+func writeMarshaled(b *bytes.Buffer, v interface{}) error {
+	marshaled, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.Write(marshaled)
+	return nil
+}
+
+// isCanonicalString tells whether json.Marshal writes the contents of a JSON string as they are,
+// which holds for printable ASCII other than escaped characters and those escaped for HTML.
+func isCanonicalString(s []byte) bool {
+	for _, c := range s {
+		if c < 0x20 || c > 0x7e || c == '\\' || c == '"' || c == '<' || c == '>' || c == '&' {
+			return false
+		}
+	}
+	return true
+}
+
+// isCanonicalInteger tells whether json.Marshal writes the JSON number as it is once unmarshaled
+// into a float64, which holds for integers without leading zeros of up to 15 digits.
+func isCanonicalInteger(number []byte) bool {
+	digits := number
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || len(digits) > 15 || (digits[0] == '0' && len(digits) > 1) {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// DefaultCacheKeyMemoSize is the number of templates whose cache keys are remembered. The entries
+// are small, so it covers the distinct steps of many concurrent workflows.
+const DefaultCacheKeyMemoSize int = 4096
+
+// cacheKeyMemo remembers the cache keys of the templates seen most recently, since the pods of
+// fan-out steps and retries carry the very same template. It is a fast path only: templates not
+// remembered have their key generated again.
+type cacheKeyMemo struct {
+	capacity int
+
+	mutex sync.Mutex
+	// recent lists the entries from the most to the least recently used.
+	recent  *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type cacheKeyMemoEntry struct {
+	templateHash [sha256.Size]byte
+	key          string
+}
+
+func newCacheKeyMemo(capacity int) *cacheKeyMemo {
+	return &cacheKeyMemo{
+		capacity: capacity,
+		recent:   list.New(),
+		entries:  map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// cacheKey returns the cache key of the template under the key strategy of the version,
+// generating it unless remembered. The version and raw template are hashed with SHA-256 rather than
+// a cheaper hash, so that no template can be crafted to be given the key of another. Templates
+// failing to generate a key are not remembered.
+func (m *cacheKeyMemo) cacheKey(version string, template string) (string, error) {
+	templateHash := sha256.Sum256([]byte(version + "\x00" + template))
+	m.mutex.Lock()
+	if element, exists := m.entries[templateHash]; exists {
+		m.recent.MoveToFront(element)
+		key := element.Value.(*cacheKeyMemoEntry).key
+		m.mutex.Unlock()
+		return key, nil
+	}
+	m.mutex.Unlock()
+
+	key, err := generateCacheKey(version, template)
+	if err != nil {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.entries[templateHash]; !exists && m.capacity > 0 {
+		m.entries[templateHash] = m.recent.PushFront(&cacheKeyMemoEntry{templateHash: templateHash, key: key})
+		if m.recent.Len() > m.capacity {
+			oldest := m.recent.Remove(m.recent.Back()).(*cacheKeyMemoEntry)
+			delete(m.entries, oldest.templateHash)
+		}
+	}
+	return key, nil
+}
+
+// clear forgets all keys, so that settings affecting the keys apply to the templates seen before.
+func (m *cacheKeyMemo) clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recent.Init()
+	m.entries = map[[sha256.Size]byte]*list.Element{}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// templateOfSize returns an Argo template of about size bytes, made of one input parameter per
+// 100 bytes.
+func templateOfSize(size int) string {
+	parameters := make([]string, 0, size/100)
+	for i := 0; i < size/100; i++ {
+		parameters = append(parameters, fmt.Sprintf(`{"name":"param-%04d","value":"%s"}`, i, strings.Repeat("v", 60)))
+	}
+	return fmt.Sprintf(`{"name":"step","inputs":{"parameters":[%s]},"container":{"command":["echo","Hello"],"image":"python:3.7"}}`, strings.Join(parameters, ","))
+}
+
+func TestCacheKeyMemoReturnsGeneratedKeys(t *testing.T) {
+	memo := newCacheKeyMemo(2)
+	for _, template := range []string{templateOfSize(2 << 10), templateOfSize(200 << 10), templateOfSize(2 << 10)} {
+		want, err := generateCacheKeyFromTemplate(template)
+		require.Nil(t, err)
+		got, err := memo.cacheKey(CacheKeyVersionV1, template)
+		require.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+	assert.Equal(t, 2, memo.recent.Len())
+}
+
+func TestCacheKeyMemoDoesNotRememberInvalidTemplates(t *testing.T) {
+	memo := newCacheKeyMemo(2)
+	_, err := memo.cacheKey(CacheKeyVersionV1, "not json")
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, memo.recent.Len())
+}
+
+func TestCacheKeyMemoEvictsLeastRecentlyUsedTemplates(t *testing.T) {
+	memo := newCacheKeyMemo(2)
+	templates := []string{
+		`{"container":{"command":["echo", "1"],"image":"python:3.7"}}`,
+		`{"container":{"command":["echo", "2"],"image":"python:3.7"}}`,
+		`{"container":{"command":["echo", "3"],"image":"python:3.7"}}`,
+	}
+	remembered := func(template string) bool {
+		key, _ := generateCacheKeyFromTemplate(template)
+		for element := memo.recent.Front(); element != nil; element = element.Next() {
+			if element.Value.(*cacheKeyMemoEntry).key == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	memo.cacheKey(CacheKeyVersionV1, templates[0])
+	memo.cacheKey(CacheKeyVersionV1, templates[1])
+	memo.cacheKey(CacheKeyVersionV1, templates[0])
+	memo.cacheKey(CacheKeyVersionV1, templates[2])
+
+	assert.True(t, remembered(templates[0]))
+	assert.False(t, remembered(templates[1]))
+	assert.True(t, remembered(templates[2]))
+	assert.Equal(t, 2, len(memo.entries))
+}
+
+func TestReconfigureForgetsCacheKeys(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{})
+	memo := webhook.templateKeys.memo
+	_, err := memo.cacheKey(CacheKeyVersionV1, templateOfSize(2<<10))
+	require.Nil(t, err)
+	require.NotZero(t, memo.recent.Len())
+
+	webhook.Reconfigure(MutationConfig{})
+
+	assert.Equal(t, 0, memo.recent.Len())
+	assert.Empty(t, memo.entries)
+}
+
+func TestCacheKeyMemoIsSafeForConcurrentUse(t *testing.T) {
+	memo := newCacheKeyMemo(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				template := fmt.Sprintf(`{"container":{"command":["echo", "%d"],"image":"python:3.7"}}`, (i+j)%8)
+				want, _ := generateCacheKeyFromTemplate(template)
+				got, err := memo.cacheKey(CacheKeyVersionV1, template)
+				assert.Nil(t, err)
+				assert.Equal(t, want, got)
+				if j%10 == 0 {
+					memo.clear()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, memo.recent.Len(), 4)
+	assert.Equal(t, memo.recent.Len(), len(memo.entries))
+}
+
+func BenchmarkCacheKeyFromTemplate(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		bytes int
+	}{{"2KB", 2 << 10}, {"200KB", 200 << 10}} {
+		template := templateOfSize(size.bytes)
+		b.Run(size.name+"/generated", func(b *testing.B) {
+			b.SetBytes(int64(len(template)))
+			for n := 0; n < b.N; n++ {
+				if _, err := generateCacheKeyFromTemplate(template); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(size.name+"/memoized", func(b *testing.B) {
+			memo := newCacheKeyMemo(DefaultCacheKeyMemoSize)
+			b.SetBytes(int64(len(template)))
+			for n := 0; n < b.N; n++ {
+				if _, err := memo.cacheKey(CacheKeyVersionV1, template); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+const (
+	// CacheKeyVersionV1 generates the keys of generateCacheKeyFromTemplate, those of the entries
+	// recorded before key versions were.
+	CacheKeyVersionV1 string = model.LegacyKeyVersion
+	// CacheKeyVersionV2 generates the keys of generateCanonicalCacheKeyFromTemplate.
+	CacheKeyVersionV2 string = "2"
+)
+
+// cacheKeyStrategy generates the cache key of an Argo template.
+type cacheKeyStrategy func(template string) (string, error)
+
+// cacheKeyStrategies holds the key strategies by version. The keys a strategy generates must never
+// change once released, since the entries recorded under them would no longer be found: a change
+// of the keys is a new strategy, which pods opt in to with CacheKeyVersionKey.
+var cacheKeyStrategies = map[string]cacheKeyStrategy{
+	CacheKeyVersionV1: generateCacheKeyFromTemplate,
+	CacheKeyVersionV2: generateCanonicalCacheKeyFromTemplate,
+}
+
+// IsValidCacheKeyVersion reports whether version names a key strategy.
+func IsValidCacheKeyVersion(version string) bool {
+	_, exists := cacheKeyStrategies[version]
+	return exists
+}
+
+// generateCacheKey generates the cache key of the template with the key strategy of the version.
+func generateCacheKey(version string, template string) (string, error) {
+	strategy, exists := cacheKeyStrategies[version]
+	if !exists {
+		return "", fmt.Errorf("unknown cache key version %q", version)
+	}
+	return strategy(template)
+}
+
+// cacheKeyVersion returns the version of the key strategy the pod or template with the annotations
+// asks for, or the default version when it asks for none.
+func (k AnnotationKeys) cacheKeyVersion(annotations map[string]string, defaultVersion string) string {
+	if version, exists := annotations[k.CacheKeyVersionKey]; exists {
+		return version
+	}
+	if defaultVersion == "" {
+		return CacheKeyVersionV1
+	}
+	return defaultVersion
+}
+
+// unorderedLists names the lists of the template skeleton whose order does not affect the pod, by
+// their path, with the field identifying their items.
+var unorderedLists = map[string]string{
+	"/container/env":          "name",
+	"/container/volumeMounts": "mountPath",
+	"/inputs/parameters":      "name",
+	"/inputs/artifacts":       "name",
+	"/volumes":                "name",
+	"/sidecars":               "name",
+}
+
+// generateCanonicalCacheKeyFromTemplate hashes the parts of the template selected by
+// cacheKeySkeleton once canonicalized by canonicalizeSkeleton, so that templates differing only in
+// the order of unordered lists or in fields set to their empty value share their key. The version
+// is hashed along, so that no key equals a key of another strategy.
+func generateCanonicalCacheKeyFromTemplate(template string) (string, error) {
+	skeleton, err := templateSkeleton(template)
+	if err != nil {
+		return "", err
+	}
+	var parts interface{}
+	if err := json.Unmarshal(skeleton, &parts); err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(canonicalizeSkeleton(parts, ""))
+	if err != nil {
+		return "", err
+	}
+	md := sha256.New()
+	md.Write([]byte("v" + CacheKeyVersionV2 + "\x00"))
+	md.Write(canonical)
+	return hex.EncodeToString(md.Sum(nil)), nil
+}
+
+// canonicalizeSkeleton drops the members of objects set to an empty value, which Kubernetes and
+// Argo read as unset, and sorts the unordered lists by the field identifying their items. The
+// items of lists are kept, empty or not.
+func canonicalizeSkeleton(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		canonical := make(map[string]interface{}, len(v))
+		for key, member := range v {
+			member = canonicalizeSkeleton(member, path+"/"+key)
+			if !isEmptyValue(member) {
+				canonical[key] = member
+			}
+		}
+		return canonical
+	case []interface{}:
+		canonical := make([]interface{}, len(v))
+		for i, item := range v {
+			canonical[i] = canonicalizeSkeleton(item, path)
+		}
+		if field, unordered := unorderedLists[path]; unordered {
+			sortItems(canonical, field)
+		}
+		return canonical
+	}
+	return value
+}
+
+// sortItems sorts the items of a list by the field identifying them, and items identified alike by
+// their JSON form.
+func sortItems(items []interface{}, field string) {
+	keys := make([]string, len(items))
+	forms := make([][]byte, len(items))
+	for i, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			keys[i], _ = object[field].(string)
+		}
+		// The items were unmarshaled, so they marshal.
+		forms[i], _ = json.Marshal(item)
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if keys[order[a]] != keys[order[b]] {
+			return keys[order[a]] < keys[order[b]]
+		}
+		return bytes.Compare(forms[order[a]], forms[order[b]]) < 0
+	})
+	sorted := make([]interface{}, len(items))
+	for i, index := range order {
+		sorted[i] = items[index]
+	}
+	copy(items, sorted)
+}
+
+// isEmptyValue reports whether the JSON value is null, false, an empty string or an empty list or
+// object.
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCacheKeyVersion(t *testing.T) {
+	assert.Equal(t, CacheKeyVersionV1, podKeys.cacheKeyVersion(nil, ""))
+	assert.Equal(t, CacheKeyVersionV2, podKeys.cacheKeyVersion(nil, CacheKeyVersionV2))
+	annotations := map[string]string{podKeys.CacheKeyVersionKey: CacheKeyVersionV1}
+	assert.Equal(t, CacheKeyVersionV1, podKeys.cacheKeyVersion(annotations, CacheKeyVersionV2))
+
+	assert.True(t, IsValidCacheKeyVersion(CacheKeyVersionV1))
+	assert.True(t, IsValidCacheKeyVersion(CacheKeyVersionV2))
+	assert.False(t, IsValidCacheKeyVersion(""))
+	_, err := generateCacheKey("v2", `{}`)
+	assert.Contains(t, err.Error(), `unknown cache key version "v2"`)
+}
+
+func TestCanonicalCacheKeyIgnoresTheOrderOfUnorderedLists(t *testing.T) {
+	key, err := generateCanonicalCacheKeyFromTemplate(`{"container":{"image":"python:3.7","env":[{"name":"A","value":"1"},{"name":"B","value":"2"}],"volumeMounts":[{"name":"a","mountPath":"/a"},{"name":"b","mountPath":"/b"}]},"inputs":{"parameters":[{"name":"x","value":"1"},{"name":"y","value":"2"}]}}`)
+	require.Nil(t, err)
+	reordered, err := generateCanonicalCacheKeyFromTemplate(`{"inputs":{"parameters":[{"name":"y","value":"2"},{"name":"x","value":"1"}]},"container":{"volumeMounts":[{"mountPath":"/b","name":"b"},{"mountPath":"/a","name":"a"}],"env":[{"name":"B","value":"2"},{"name":"A","value":"1"}],"image":"python:3.7"}}`)
+	require.Nil(t, err)
+	assert.Equal(t, key, reordered)
+
+	// The order of commands and arguments is that of the process.
+	key, err = generateCanonicalCacheKeyFromTemplate(`{"container":{"image":"python:3.7","args":["a","b"]}}`)
+	require.Nil(t, err)
+	reordered, err = generateCanonicalCacheKeyFromTemplate(`{"container":{"image":"python:3.7","args":["b","a"]}}`)
+	require.Nil(t, err)
+	assert.NotEqual(t, key, reordered)
+}
+
+func TestCanonicalCacheKeyIgnoresEmptyFields(t *testing.T) {
+	key, err := generateCanonicalCacheKeyFromTemplate(`{"container":{"image":"python:3.7","command":["echo","Hello"]}}`)
+	require.Nil(t, err)
+	for _, template := range []string{
+		`{"container":{"image":"python:3.7","command":["echo","Hello"],"args":[],"env":null}}`,
+		`{"container":{"image":"python:3.7","command":["echo","Hello"],"volumeMounts":[]},"inputs":{},"volumes":[],"sidecars":null}`,
+		`{"container":{"image":"python:3.7","command":["echo","Hello"],"workingDir":""},"outputs":{"parameters":[]}}`,
+	} {
+		got, err := generateCanonicalCacheKeyFromTemplate(template)
+		require.Nil(t, err, template)
+		assert.Equal(t, key, got, template)
+	}
+
+	// Empty items are kept, since they are items of the pod.
+	withEmptyArgument, err := generateCanonicalCacheKeyFromTemplate(`{"container":{"image":"python:3.7","command":["echo","Hello",""]}}`)
+	require.Nil(t, err)
+	assert.NotEqual(t, key, withEmptyArgument)
+}
+
+func TestCacheKeyStrategiesGenerateDistinctKeys(t *testing.T) {
+	template := `{"container":{"image":"python:3.7","command":["echo","Hello"]}}`
+	v1Key, err := generateCacheKey(CacheKeyVersionV1, template)
+	require.Nil(t, err)
+	v2Key, err := generateCacheKey(CacheKeyVersionV2, template)
+	require.Nil(t, err)
+	assert.NotEqual(t, v1Key, v2Key)
+	_, err = generateCacheKey(CacheKeyVersionV2, `{"container":`)
+	assert.NotNil(t, err)
+}
+
+// keyVersionPod returns a pod of the template asking for the key version, or for none when empty.
+func keyVersionPod(template string, keyVersion string) *corev1.Pod {
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = template
+	if keyVersion != "" {
+		pod.ObjectMeta.Annotations[podKeys.CacheKeyVersionKey] = keyVersion
+	}
+	return pod
+}
+
+func TestMutatePodIfCachedKeepsKeyVersionsApart(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`
+	entries := make(map[string]*model.ExecutionCache)
+	for _, version := range []string{CacheKeyVersionV1, CacheKeyVersionV2} {
+		key, err := generateCacheKey(version, template)
+		require.Nil(t, err)
+		entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: key,
+			ExecutionOutput:   testExecutionOutput,
+			ExecutionTemplate: template,
+			MaxCacheStaleness: -1,
+			KeyVersion:        version,
+		})
+		require.Nil(t, err)
+		entries[version] = entry
+	}
+
+	for _, test := range []struct {
+		annotation string
+		want       string
+	}{
+		{annotation: "", want: CacheKeyVersionV1},
+		{annotation: CacheKeyVersionV1, want: CacheKeyVersionV1},
+		{annotation: CacheKeyVersionV2, want: CacheKeyVersionV2},
+	} {
+		patches, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, test.annotation)), clientManager)
+		require.Nil(t, err)
+		require.Equal(t, 3, len(patches), "the pod asking for version %q hits", test.annotation)
+		annotations := patches[1].Value.(map[string]string)
+		labels := patches[2].Value.(map[string]string)
+		assert.Equal(t, test.want, annotations[podKeys.CacheKeyVersionKey])
+		assert.Equal(t, entries[test.want].ExecutionCacheKey, annotations[podKeys.ExecutionKey])
+		assert.Equal(t, strconv.FormatInt(entries[test.want].ID, 10), labels[podKeys.CacheIDLabelKey])
+	}
+}
+
+func TestMutatePodIfCachedDoesNotMatchEntriesOfOtherKeyVersions(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`
+	v2Key, err := generateCacheKey(CacheKeyVersionV2, template)
+	require.Nil(t, err)
+	// An entry recorded by the legacy strategy under the key another strategy generates.
+	_, err = clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: v2Key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	patches, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, CacheKeyVersionV2)), clientManager)
+
+	require.Nil(t, err)
+	require.Equal(t, 2, len(patches), "the pod misses")
+	assert.Equal(t, v2Key, patches[0].Value.(map[string]string)[podKeys.ExecutionKey])
+}
+
+func TestMutatePodIfCachedWithUnknownKeyVersion(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"image":"python:3.7"}}`
+	v2Key, err := generateCacheKey(CacheKeyVersionV2, template)
+	require.Nil(t, err)
+	// The pod is keyed by the default version, even under the closed fail policy.
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{KeyVersion: CacheKeyVersionV2, FailPolicy: FailPolicyClosed}})
+
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: GetFakeRequestFromPod(keyVersionPod(template, "v3"))})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+	rr := httptest.NewRecorder()
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+	response := decodeWarningResponse(t, rr.Body.Bytes())
+	assert.True(t, response.Allowed)
+	require.Len(t, response.Warnings, 1)
+	assert.Contains(t, response.Warnings[0], `unknown cache key version "v3"`)
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "v3")), clientManager)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(patches), "the pod misses")
+	annotations := patches[0].Value.(map[string]string)
+	assert.Equal(t, CacheKeyVersionV2, annotations[podKeys.CacheKeyVersionKey])
+	assert.Equal(t, v2Key, annotations[podKeys.ExecutionKey])
+}
+
+func TestRecordPodOutputRecordsTheKeyVersion(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	legacyPod := completedPod("legacy", time.Minute)
+	v2Pod := completedPod("canonical", time.Minute)
+	v2Pod.ObjectMeta.Annotations[podKeys.CacheKeyVersionKey] = CacheKeyVersionV2
+	clientset := fake.NewSimpleClientset(legacyPod, v2Pod)
+	watched := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+
+	for _, test := range []struct {
+		pod  *corev1.Pod
+		want string
+	}{
+		{pod: legacyPod, want: CacheKeyVersionV1},
+		{pod: v2Pod, want: CacheKeyVersionV2},
+	} {
+		require.True(t, recordPodOutputNow(test.pod, watched, noopWatcherMetrics{}))
+		key := test.pod.ObjectMeta.Annotations[podKeys.ExecutionKey]
+		entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), key, -1, storage.ExecutionCacheFilter{KeyVersion: test.want})
+		require.Nil(t, err, test.pod.Name)
+		assert.Equal(t, test.want, entry.KeyVersion)
+	}
+}
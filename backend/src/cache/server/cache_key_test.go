@@ -0,0 +1,191 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the tests.")
+
+// unmarshalingCacheKeyFromTemplate generates cache keys as the webhook did before reading the
+// templates without unmarshaling them, which keys must not differ from.
+func unmarshalingCacheKeyFromTemplate(template string) (string, error) {
+	var templateMap map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &templateMap); err != nil {
+		return "", err
+	}
+	var intersect func(src map[string]interface{}, skeleton map[string]interface{}) map[string]interface{}
+	intersect = func(src map[string]interface{}, skeleton map[string]interface{}) map[string]interface{} {
+		result := make(map[string]interface{})
+		for key, skeletonValue := range skeleton {
+			if value, ok := src[key]; ok {
+				if skeletonValue == nil {
+					result[key] = value
+				} else {
+					result[key] = intersect(value.(map[string]interface{}), skeletonValue.(map[string]interface{}))
+				}
+			}
+		}
+		return result
+	}
+	cacheKeyMap := intersect(templateMap, map[string]interface{}{
+		"container": map[string]interface{}{
+			"image":        nil,
+			"command":      nil,
+			"args":         nil,
+			"env":          nil,
+			"volumeMounts": nil,
+		},
+		"inputs":         nil,
+		"volumes":        nil,
+		"initContainers": nil,
+		"sidecars":       nil,
+	})
+	b, err := json.Marshal(cacheKeyMap)
+	if err != nil {
+		return "", err
+	}
+	md := sha256.Sum256(b)
+	return hex.EncodeToString(md[:]), nil
+}
+
+// cacheKeyGoldenFiles holds the golden keys of the templates of testdata/templates by key version.
+var cacheKeyGoldenFiles = map[string]string{
+	CacheKeyVersionV1: "cache_keys.golden",
+	CacheKeyVersionV2: "cache_keys_v2.golden",
+}
+
+// TestCacheKeyStrategiesKeepGoldenKeys pins the keys of every released strategy: -update only
+// writes the golden files of strategies that have none yet, since a released strategy generating
+// other keys would no longer find the entries recorded under its former keys.
+func TestCacheKeyStrategiesKeepGoldenKeys(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "templates", "*.json"))
+	require.Nil(t, err)
+	require.NotEmpty(t, paths)
+	for version := range cacheKeyStrategies {
+		goldenFile, exists := cacheKeyGoldenFiles[version]
+		require.True(t, exists, "key version %q has no golden file", version)
+		var keys strings.Builder
+		for _, path := range paths {
+			template, err := ioutil.ReadFile(path)
+			require.Nil(t, err)
+			key, err := generateCacheKey(version, string(template))
+			require.Nil(t, err, path)
+			fmt.Fprintf(&keys, "%s %s\n", filepath.Base(path), key)
+		}
+
+		goldenPath := filepath.Join("testdata", goldenFile)
+		if _, err := os.Stat(goldenPath); *updateGolden && os.IsNotExist(err) {
+			require.Nil(t, ioutil.WriteFile(goldenPath, []byte(keys.String()), 0644))
+		}
+		golden, err := ioutil.ReadFile(goldenPath)
+		require.Nil(t, err)
+		assert.Equal(t, string(golden), keys.String(), "key version %q", version)
+	}
+}
+
+func TestGenerateCacheKeyFromTemplateMatchesUnmarshalingTemplates(t *testing.T) {
+	templates := []string{
+		`{}`,
+		`null`,
+		`{"container":{}}`,
+		`{"name":"step","outputs":{"artifacts":[]},"archiveLocation":{"archiveLogs":true}}`,
+		`{"container":{"image":"python:3.7","command":["echo","Hello"],"resources":{"limits":{"cpu":"1"}}}}`,
+		` { "inputs" : { "parameters" : [ { "value" : "1" , "name" : "a" } ] } , "container" : { "args" : [ ] } } `,
+		`{"inputs":{"b":1,"a":2,"c":{"z":null,"y":true,"x":false}}}`,
+		`{"inputs":{"parameters":[{"name":"html","value":"<a href=\"x\">&</a>"}]}}`,
+		`{"inputs":{"parameters":[{"name":"escaped","value":"Aé 🚀\/"}]}}`,
+		`{"inputs":{"parameters":[{"name":"unicode","value":"résumé 🚀  "}]}}`,
+		"{\"inputs\":{\"parameters\":[{\"name\":\"invalid utf-8\",\"value\":\"\xff\xfe\"}]}}",
+		`{"inputs":{"numbers":[0,-0,1,-1,10,100000000000000,999999999999999,1000000000000000,9007199254740993,0.5,1e2,1E-7,-2.50,1e21,123456789012345678901234567890]}}`,
+		`{"inputs":{"dup":1,"dup":2},"volumes":[{"name":"a"},{"name":"a","name":"b"}]}`,
+		`{"inputs":{"€":1,"a":2,"Z":3,"é":4}}`,
+		`{"inputs":{"a\u0062":1,"ab":2,"\u003c":3,"a\"b":4,"ab":5,"a\u0062":6}}`,
+		`{"inputs":{"j":1,"i":2,"h":3,"g":4,"f":5,"e":6,"d":7,"c":8,"b":9,"a":10,"b":11}}`,
+		" \n\t{\"container\" :\r\n{ \"image\" : \"python:3.7\" } }\n ",
+		`{"sidecars":[{"name":"s"}],"initContainers":[{"name":"i"}],"volumes":[],"inputs":{},"container":{"volumeMounts":[],"env":[],"image":"","command":null,"args":[]}}`,
+		`{"Container":{"image":"python:3.7"},"INPUTS":{}}`,
+		`{"\u0063ontainer":{"imag\u0065":"python:3.7"},"container":{"command":["ls"]}}`,
+		`{"inputs":"not an object","volumes":42,"sidecars":"s","initContainers":true}`,
+	}
+	for _, template := range templates {
+		want, err := unmarshalingCacheKeyFromTemplate(template)
+		require.Nil(t, err, template)
+		got, err := generateCacheKeyFromTemplate(template)
+		require.Nil(t, err, template)
+		assert.Equal(t, want, got, template)
+	}
+	for _, size := range []int{2 << 10, 200 << 10} {
+		template := templateOfSize(size)
+		want, err := unmarshalingCacheKeyFromTemplate(template)
+		require.Nil(t, err)
+		got, err := generateCacheKeyFromTemplate(template)
+		require.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestGenerateCacheKeyFromTemplateRejectsInvalidTemplates(t *testing.T) {
+	for _, template := range []string{
+		``,
+		`not json`,
+		`{"container":`,
+		`["container"]`,
+		`{"outputs":{"parameters":[}}`,
+		`{"inputs":{"number":1e400}}`,
+		`{"container":"python:3.7"}`,
+	} {
+		_, err := generateCacheKeyFromTemplate(template)
+		assert.NotNil(t, err, template)
+	}
+}
+
+func BenchmarkGenerateCacheKeyFromTemplate(b *testing.B) {
+	small, err := ioutil.ReadFile(filepath.Join("testdata", "templates", "container_op.json"))
+	require.Nil(b, err)
+	for _, template := range []struct {
+		name     string
+		template string
+	}{{"small", string(small)}, {"large", templateOfSize(200 << 10)}} {
+		for _, generate := range []struct {
+			name     string
+			generate func(string) (string, error)
+		}{{"unmarshaling", unmarshalingCacheKeyFromTemplate}, {"raw", generateCacheKeyFromTemplate}} {
+			template, generate := template, generate
+			b.Run(template.name+"/"+generate.name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(template.template)))
+				for n := 0; n < b.N; n++ {
+					if _, err := generate.generate(template.template); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+// Cache modes decide whether the pods found in cache are served from it. Under the active mode
+// their containers are replaced with the dummy one, under the shadow mode they run unchanged and
+// are only counted, so that the hit rate of a cluster can be evaluated before its pods are
+// served from cache. Pods are never rejected under the shadow mode, whatever the fail policy.
+const (
+	CacheModeActive string = "active"
+	CacheModeShadow string = "shadow"
+)
+
+// IsValidCacheMode reports whether mode is CacheModeActive or CacheModeShadow.
+func IsValidCacheMode(mode string) bool {
+	return mode == CacheModeActive || mode == CacheModeShadow
+}
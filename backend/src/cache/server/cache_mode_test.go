@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidCacheMode(t *testing.T) {
+	assert.True(t, IsValidCacheMode(CacheModeActive))
+	assert.True(t, IsValidCacheMode(CacheModeShadow))
+	assert.False(t, IsValidCacheMode(""))
+	assert.False(t, IsValidCacheMode("dry-run"))
+}
+
+func TestMutatePodIfCachedInShadowMode(t *testing.T) {
+	metrics := NewPrometheusMutationMetrics(prometheus.NewRegistry(), DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{
+		Mutation: MutationConfig{Mode: CacheModeShadow, HitScheduling: HitScheduling{Strip: true}},
+		Metrics:  metrics,
+	})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 2, "a miss is annotated as under the active mode")
+	assert.NotContains(t, patches[0].Value.(map[string]string), podKeys.ShadowCacheIDKey)
+
+	key, err := generateCacheKey(CacheKeyVersionV1, template)
+	require.Nil(t, err)
+	entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 2, "the containers of a shadow hit are kept")
+	assert.Equal(t, AnnotationPath, patches[0].Path)
+	annotations := patches[0].Value.(map[string]string)
+	assert.Equal(t, key, annotations[podKeys.ExecutionKey])
+	assert.Equal(t, strconv.FormatInt(entry.ID, 10), annotations[podKeys.ShadowCacheIDKey])
+	assert.NotContains(t, annotations, ArgoWorkflowOutputs, "the outputs are not restored")
+	assert.NotContains(t, annotations, podKeys.ComputeSecondsSavedKey)
+	assert.Equal(t, LabelPath, patches[1].Path)
+	labels := patches[1].Value.(map[string]string)
+	assert.Equal(t, "", labels[podKeys.CacheIDLabelKey], "the watcher records the outputs of the pod")
+	assert.NotContains(t, labels, podKeys.CachedLabelKey)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeShadowHit)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeMiss)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeHit)))
+}
+
+func TestShadowModeNeverFailsClosed(t *testing.T) {
+	assert.True(t, MutationConfig{FailPolicy: FailPolicyClosed}.failsClosed())
+	assert.False(t, MutationConfig{FailPolicy: FailPolicyClosed, Mode: CacheModeShadow}.failsClosed())
+
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{FailPolicy: FailPolicyClosed, Mode: CacheModeShadow}})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.cacheStore = &togglingExecutionCacheStore{failing: 1}
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(fakePod), clientManager)
+	require.Nil(t, err, "the pod is admitted although the lookup failed")
+	assert.Len(t, patches, 2)
+	assert.False(t, webhook.rejectsFailedAdmission([]byte(undecodableKFPPod)))
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CacheRunsAPI followed by /{runId} lists the nodes of a run served from cache.
+const CacheRunsAPI string = "/v1/cache/runs"
+
+// CacheReuseStore records the nodes of runs served from cache, see storage.CacheReuseStore.
+type CacheReuseStore interface {
+	RecordCacheReuse(reuse *model.CacheReuse) (bool, error)
+	ListCacheReusesByRun(runID string) ([]*model.CacheReuse, error)
+}
+
+// RunCacheReuses is the body of CacheRunsAPI/{runId}.
+type RunCacheReuses struct {
+	RunID string `json:"runId"`
+	// CachedNodes are the nodes of the run served from cache, in the order they completed. The
+	// nodes of the run missing from it ran, or have not completed yet.
+	CachedNodes []CachedNode `json:"cachedNodes"`
+}
+
+// CachedNode is a node of a run served from cache.
+type CachedNode struct {
+	// NodeID is the ID of the node in the status of the Workflow of the run, the name of its pod.
+	NodeID    string `json:"nodeId"`
+	NodeName  string `json:"nodeName,omitempty"`
+	Namespace string `json:"namespace"`
+	// CacheEntryID is the reused entry, and SourceRunID the run that produced it when known.
+	CacheEntryID int64     `json:"cacheEntryId"`
+	SourceRunID  string    `json:"sourceRunId,omitempty"`
+	ReusedAt     time.Time `json:"reusedAt"`
+}
+
+// recordCacheReuse records the reuse of a pod of a run that succeeded after being served from
+// cache. It reports false when the reuse could not be recorded and is to be retried.
+func recordCacheReuse(pod *corev1.Pod, keys AnnotationKeys, store CacheReuseStore, time util.TimeInterface) bool {
+	runID := pod.ObjectMeta.Labels[RunIDLabelKey]
+	if pod.ObjectMeta.Labels[keys.CachedLabelKey] != KFPCachedLabelValue || runID == "" || !classifyPodTermination(pod).Succeeded() {
+		return true
+	}
+	cacheEntryID, _ := strconv.ParseInt(pod.ObjectMeta.Labels[keys.CacheIDLabelKey], 10, 64)
+	reusedAt := podCompletedAt(pod)
+	if reusedAt.IsZero() {
+		reusedAt = time.Now()
+	}
+	reuseLogger := logger.WithFields(logrus.Fields{
+		logging.FieldPod:       pod.ObjectMeta.Name,
+		logging.FieldNamespace: pod.ObjectMeta.Namespace,
+		logging.FieldCacheID:   cacheEntryID,
+	})
+	created, err := store.RecordCacheReuse(&model.CacheReuse{
+		RunID:         runID,
+		NodeID:        pod.ObjectMeta.Name,
+		NodeName:      podOrchestratorOf(pod).nodeName(pod),
+		Namespace:     pod.ObjectMeta.Namespace,
+		CacheEntryID:  cacheEntryID,
+		SourceRunID:   pod.ObjectMeta.Annotations[keys.CacheSourceRunIDKey],
+		ReusedAtInSec: reusedAt.Unix(),
+	})
+	if err != nil {
+		reuseLogger.Errorf("Unable to record the cache reuse of the pod, retrying at the next resync: %v", err)
+		return false
+	}
+	if created {
+		reuseLogger.Debug("Cache reuse recorded")
+	}
+	return true
+}
+
+// CacheRunsHandler serves GET CacheRunsAPI/{runId}, the RunCacheReuses of the run, behind
+// RequireAdminToken. Runs without node served from cache have no cached nodes.
+func CacheRunsHandler(store CacheReuseStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runID := strings.TrimPrefix(r.URL.Path, CacheRunsAPI+"/")
+		if runID == r.URL.Path || runID == "" || strings.Contains(runID, "/") {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("no admin API at %s", r.URL.Path))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeAdminError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		reuses, err := store.ListCacheReusesByRun(runID)
+		if err != nil {
+			writeAdminStoreError(w, r, err)
+			return
+		}
+		body := RunCacheReuses{RunID: runID, CachedNodes: []CachedNode{}}
+		for _, reuse := range reuses {
+			body.CachedNodes = append(body.CachedNodes, CachedNode{
+				NodeID:       reuse.NodeID,
+				NodeName:     reuse.NodeName,
+				Namespace:    reuse.Namespace,
+				CacheEntryID: reuse.CacheEntryID,
+				SourceRunID:  reuse.SourceRunID,
+				ReusedAt:     time.Unix(reuse.ReusedAtInSec, 0).UTC(),
+			})
+		}
+		writeAdminJSON(w, http.StatusOK, body)
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestCacheReuseStore(t *testing.T) *storage.CacheReuseStore {
+	db := storage.NewFakeDbOrFatal()
+	t.Cleanup(func() { db.Close() })
+	store, err := storage.NewCacheReuseStore(db)
+	require.Nil(t, err)
+	return store
+}
+
+// podOfRun returns a pod of the run completed a minute before the watcher started, served from
+// the entry of cacheID when not empty.
+func podOfRun(name string, runID string, cacheID string) *corev1.Pod {
+	pod := completedPod(name, time.Minute)
+	pod.ObjectMeta.Labels[RunIDLabelKey] = runID
+	pod.ObjectMeta.Annotations[ArgoWorkflowNodeName] = "pipeline-abc." + name
+	if cacheID != "" {
+		pod.ObjectMeta.Labels[podKeys.CachedLabelKey] = KFPCachedLabelValue
+		pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = cacheID
+		pod.ObjectMeta.Annotations[podKeys.CacheSourceRunIDKey] = "run-0"
+	}
+	return pod
+}
+
+func getRunCacheReuses(t *testing.T, handler http.Handler, runID string) RunCacheReuses {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, CacheRunsAPI+"/"+runID, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var reuses RunCacheReuses
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &reuses))
+	return reuses
+}
+
+func TestWatchPodsRecordsTheCachedNodesOfRuns(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := newTestCacheReuseStore(t)
+	failed := podOfRun("failed", "run-1", "8")
+	failed.Status.Phase = corev1.PodFailed
+	clientset := fake.NewSimpleClientset(
+		podOfRun("trained", "run-1", ""),
+		podOfRun("evaluated", "run-1", "7"),
+		failed,
+		podOfRun("other", "run-2", "7"),
+		podOfRun("without-run", "", "7"))
+
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour, CacheReuses: store})
+	defer stop()
+	require.Eventually(t, hasCacheEntries(clientManager, "trained-key", 1), 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		reuses, err := store.ListCacheReusesByRun("run-2")
+		return err == nil && len(reuses) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	handler := CacheRunsHandler(store)
+	reuses := getRunCacheReuses(t, handler, "run-1")
+	assert.Equal(t, RunCacheReuses{
+		RunID: "run-1",
+		CachedNodes: []CachedNode{{
+			NodeID:       "evaluated",
+			NodeName:     "pipeline-abc.evaluated",
+			Namespace:    watchedNamespace,
+			CacheEntryID: 7,
+			SourceRunID:  "run-0",
+			ReusedAt:     watcherStartTime.Add(-time.Minute).UTC().Truncate(time.Second),
+		}},
+	}, reuses, "only the succeeded nodes served from cache are listed")
+	assert.Equal(t, RunCacheReuses{RunID: "run-3", CachedNodes: []CachedNode{}}, getRunCacheReuses(t, handler, "run-3"))
+}
+
+// failingCacheReuseStore fails to record reuses.
+type failingCacheReuseStore struct{}
+
+func (failingCacheReuseStore) RecordCacheReuse(reuse *model.CacheReuse) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingCacheReuseStore) ListCacheReusesByRun(runID string) ([]*model.CacheReuse, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRecordCacheReuseRetriesFailures(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	fakeTime := util.NewFakeTimeForEpoch()
+
+	assert.False(t, recordCacheReuse(podOfRun("evaluated", "run-1", "7"), podKeys, failingCacheReuseStore{}, fakeTime))
+	require.NotNil(t, hook.LastEntry())
+	assert.Contains(t, hook.LastEntry().Message, "Unable to record the cache reuse of the pod")
+	assert.True(t, recordCacheReuse(podOfRun("trained", "run-1", ""), podKeys, failingCacheReuseStore{}, fakeTime), "pods not served from cache are not recorded")
+
+	w := httptest.NewRecorder()
+	CacheRunsHandler(failingCacheReuseStore{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, CacheRunsAPI+"/run-1", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCacheRunsHandlerRejectsRequests(t *testing.T) {
+	handler := CacheRunsHandler(newTestCacheReuseStore(t))
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, CacheRunsAPI + "/", http.StatusNotFound},
+		{http.MethodGet, CacheRunsAPI + "/run-1/nodes", http.StatusNotFound},
+		{http.MethodDelete, CacheRunsAPI + "/run-1", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		assert.Equal(t, test.status, w.Code, "%s %s", test.method, test.path)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// CachedExecutionTypeName is the ML Metadata type of the executions of the pods served from
+	// cache.
+	CachedExecutionTypeName string = "CachedExecution"
+	// RunContextTypeName is the ML Metadata type of the contexts the metadata writer records the
+	// runs as, named after their workflow.
+	RunContextTypeName string = "KfpRun"
+
+	// DefaultMLMDMaxRetries bounds the retries of a cached execution failing to be recorded, which
+	// is then dropped.
+	DefaultMLMDMaxRetries int = 10
+
+	// The outcomes of recording the execution of a pod served from cache.
+	CachedExecutionRecorded        string = "recorded"
+	CachedExecutionAlreadyRecorded string = "already_recorded"
+	CachedExecutionFailed          string = "failed"
+	CachedExecutionDropped         string = "dropped"
+
+	// The custom properties of the cached executions. The pod name is that of the metadata writer.
+	originalExecutionIDProperty string = "original_execution_id"
+	cacheIDProperty             string = "cache_id"
+	podNameProperty             string = "kfp_pod_name"
+	runIDProperty               string = "run_id"
+	pipelineNameProperty        string = "pipeline_name"
+
+	// Failed executions are recorded again with a backoff doubling from mlmdRetryBaseDelay up to
+	// mlmdRetryMaxDelay. mlmdCallTimeout bounds the calls recording an execution.
+	mlmdRetryBaseDelay time.Duration = time.Second
+	mlmdRetryMaxDelay  time.Duration = 5 * time.Minute
+	mlmdCallTimeout    time.Duration = 30 * time.Second
+)
+
+// cachedExecution is a pod served from cache whose execution is recorded in ML Metadata.
+type cachedExecution struct {
+	namespace    string
+	podName      string
+	workflow     string
+	runID        string
+	pipelineName string
+	cacheID      int64
+	// originalExecutionID is the execution of the pod that produced the cache entry.
+	originalExecutionID int64
+}
+
+// CachedExecutionRecorder records the pods served from cache as executions of
+// CachedExecutionTypeName in ML Metadata, which the metadata writer skips, so that the lineage of
+// their outputs stays connected. Each execution is associated with the contexts of the original
+// execution and with the run of the pod, and outputs the artifacts of the original execution.
+// Executions are recorded from a queue, asynchronously, and failures are retried with a backoff up
+// to maxRetries times, then the execution is dropped.
+type CachedExecutionRecorder struct {
+	client     ml_metadata.MetadataStoreServiceClient
+	maxRetries int
+	keys       AnnotationKeys
+	metrics    WatcherMetrics
+	// newQueue returns the queue of each run, since the watchers run again on each leadership
+	// term and a shut down queue cannot be reused. Runs are not concurrent.
+	newQueue func() workqueue.RateLimitingInterface
+	mu       sync.Mutex
+	queue    workqueue.RateLimitingInterface
+	// typeID is the ID of CachedExecutionTypeName, 0 until it is registered. It is guarded by
+	// typeMu, since TFXExecutionRestorer puts executions from concurrent admissions.
+	typeMu sync.Mutex
+	typeID int64
+}
+
+// factory function for a recorder of the cached executions in the ML Metadata server of the client,
+// reading the labels and annotations of the keys and recording its outcomes in metrics unless nil
+func NewCachedExecutionRecorder(client ml_metadata.MetadataStoreServiceClient, maxRetries int, keys AnnotationKeys, metrics WatcherMetrics) *CachedExecutionRecorder {
+	if maxRetries <= 0 {
+		maxRetries = DefaultMLMDMaxRetries
+	}
+	if metrics == nil {
+		metrics = noopWatcherMetrics{}
+	}
+	newQueue := func() workqueue.RateLimitingInterface {
+		return workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(mlmdRetryBaseDelay, mlmdRetryMaxDelay))
+	}
+	return &CachedExecutionRecorder{
+		client:     client,
+		maxRetries: maxRetries,
+		keys:       keys.orDefault(),
+		metrics:    metrics,
+		newQueue:   newQueue,
+		queue:      newQueue(),
+	}
+}
+
+func (r *CachedExecutionRecorder) currentQueue() workqueue.RateLimitingInterface {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queue
+}
+
+// record queues the execution of the pod if it succeeded after being served from cache. Pods
+// whose cache entry does not name the execution it was produced by are not recorded.
+func (r *CachedExecutionRecorder) record(pod *corev1.Pod) {
+	if pod.ObjectMeta.Labels[r.keys.CachedLabelKey] != KFPCachedLabelValue || !classifyPodTermination(pod).Succeeded() {
+		return
+	}
+	originalExecutionID, err := strconv.ParseInt(pod.ObjectMeta.Labels[r.keys.MetadataExecutionIDKey], 10, 64)
+	if err != nil || originalExecutionID <= 0 {
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:       pod.ObjectMeta.Name,
+			logging.FieldNamespace: pod.ObjectMeta.Namespace,
+		}).Debug("Pod served from cache has no original metadata execution, its execution is not recorded in ML Metadata")
+		return
+	}
+	cacheID, _ := strconv.ParseInt(pod.ObjectMeta.Labels[r.keys.CacheIDLabelKey], 10, 64)
+	r.currentQueue().Add(cachedExecution{
+		namespace:           pod.ObjectMeta.Namespace,
+		podName:             pod.ObjectMeta.Name,
+		workflow:            pod.ObjectMeta.Labels[ArgoWorkflowLabelKey],
+		runID:               pod.ObjectMeta.Labels[RunIDLabelKey],
+		pipelineName:        pod.ObjectMeta.Annotations[r.keys.PipelineNameKey],
+		cacheID:             cacheID,
+		originalExecutionID: originalExecutionID,
+	})
+}
+
+// run records the queued executions until ctx is done. The execution being recorded when ctx is
+// done is still recorded, the others are dropped, since their pods are listed again on restart.
+// The next run, e.g. of the next leadership term, records from a new queue.
+func (r *CachedExecutionRecorder) run(ctx context.Context) {
+	queue := r.currentQueue()
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		queue.ShutDown()
+		r.queue = r.newQueue()
+	}()
+	for r.recordNext(ctx, queue) {
+	}
+}
+
+// recordNext records the next execution of the queue. It reports false once the queue is shut
+// down.
+func (r *CachedExecutionRecorder) recordNext(ctx context.Context, queue workqueue.RateLimitingInterface) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+	if ctx.Err() != nil {
+		return true
+	}
+	execution := item.(cachedExecution)
+	executionLogger := logger.WithFields(logrus.Fields{
+		logging.FieldPod:       execution.podName,
+		logging.FieldNamespace: execution.namespace,
+		logging.FieldCacheID:   execution.cacheID,
+	})
+	created, err := r.put(context.Background(), execution)
+	if err != nil {
+		retries := queue.NumRequeues(item)
+		if retries < r.maxRetries {
+			executionLogger.Warnf("Unable to record the cached execution in ML Metadata, retrying: %v", err)
+			r.metrics.CachedExecutionHandled(CachedExecutionFailed)
+			queue.AddRateLimited(item)
+			return true
+		}
+		executionLogger.Errorf("Dropping the cached execution after %d failures to record it in ML Metadata: %v", retries+1, err)
+		r.metrics.CachedExecutionHandled(CachedExecutionDropped)
+		queue.Forget(item)
+		return true
+	}
+	queue.Forget(item)
+	if !created {
+		executionLogger.Debug("Cached execution was already recorded in ML Metadata")
+		r.metrics.CachedExecutionHandled(CachedExecutionAlreadyRecorded)
+		return true
+	}
+	executionLogger.Info("Cached execution recorded in ML Metadata")
+	r.metrics.CachedExecutionHandled(CachedExecutionRecorded)
+	return true
+}
+
+// put creates the execution, linked to the contexts and output artifacts of the original
+// execution and to the other contexts. It reports false when the execution already exists, e.g.
+// recorded before a restart.
+func (r *CachedExecutionRecorder) put(ctx context.Context, execution cachedExecution, otherContexts ...*ml_metadata.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, mlmdCallTimeout)
+	defer cancel()
+	typeID, err := r.executionTypeID(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	originalID := execution.originalExecutionID
+	contexts, err := r.client.GetContextsByExecution(ctx, &ml_metadata.GetContextsByExecutionRequest{ExecutionId: proto.Int64(originalID)})
+	if err != nil {
+		return false, fmt.Errorf("failed to get the contexts of execution %d: %v", originalID, err)
+	}
+	events, err := r.client.GetEventsByExecutionIDs(ctx, &ml_metadata.GetEventsByExecutionIDsRequest{ExecutionIds: []int64{originalID}})
+	if err != nil {
+		return false, fmt.Errorf("failed to get the events of execution %d: %v", originalID, err)
+	}
+	if len(contexts.GetContexts()) == 0 && len(events.GetEvents()) == 0 {
+		// The metadata writer may not have recorded the original execution yet.
+		return false, fmt.Errorf("execution %d has neither contexts nor events", originalID)
+	}
+
+	request := &ml_metadata.PutExecutionRequest{
+		Execution: &ml_metadata.Execution{
+			TypeId:         proto.Int64(typeID),
+			Name:           proto.String(execution.namespace + "/" + execution.podName),
+			LastKnownState: ml_metadata.Execution_CACHED.Enum(),
+			CustomProperties: map[string]*ml_metadata.Value{
+				originalExecutionIDProperty: intValue(originalID),
+				cacheIDProperty:             intValue(execution.cacheID),
+				podNameProperty:             stringValue(execution.podName),
+				runIDProperty:               stringValue(execution.runID),
+				pipelineNameProperty:        stringValue(execution.pipelineName),
+			},
+		},
+		Contexts: contexts.GetContexts(),
+	}
+	if execution.workflow != "" {
+		run, err := r.client.GetContextByTypeAndName(ctx, &ml_metadata.GetContextByTypeAndNameRequest{
+			TypeName:    proto.String(RunContextTypeName),
+			ContextName: proto.String(execution.workflow),
+		})
+		if err != nil && status.Code(err) != codes.NotFound {
+			return false, fmt.Errorf("failed to get the context of run %s: %v", execution.workflow, err)
+		}
+		if run.GetContext() != nil && !hasContext(request.Contexts, run.GetContext().GetId()) {
+			request.Contexts = append(request.Contexts, run.GetContext())
+		}
+	}
+	for _, context := range otherContexts {
+		if !hasContext(request.Contexts, context.GetId()) {
+			request.Contexts = append(request.Contexts, context)
+		}
+	}
+	for _, event := range events.GetEvents() {
+		if event.GetType() != ml_metadata.Event_OUTPUT && event.GetType() != ml_metadata.Event_DECLARED_OUTPUT {
+			continue
+		}
+		request.ArtifactEventPairs = append(request.ArtifactEventPairs, &ml_metadata.PutExecutionRequest_ArtifactAndEvent{
+			Event: &ml_metadata.Event{
+				ArtifactId: proto.Int64(event.GetArtifactId()),
+				Path:       event.GetPath(),
+				Type:       event.GetType().Enum(),
+			},
+		})
+	}
+	if _, err := r.client.PutExecution(ctx, request); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to put the execution: %v", err)
+	}
+	return true, nil
+}
+
+// executionTypeID registers CachedExecutionTypeName on first use and returns its ID.
+func (r *CachedExecutionRecorder) executionTypeID(ctx context.Context) (int64, error) {
+	r.typeMu.Lock()
+	defer r.typeMu.Unlock()
+	if r.typeID == 0 {
+		response, err := r.client.PutExecutionType(ctx, &ml_metadata.PutExecutionTypeRequest{
+			ExecutionType: &ml_metadata.ExecutionType{Name: proto.String(CachedExecutionTypeName)},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to register the execution type %s: %v", CachedExecutionTypeName, err)
+		}
+		r.typeID = response.GetTypeId()
+	}
+	return r.typeID, nil
+}
+
+func hasContext(contexts []*ml_metadata.Context, id int64) bool {
+	for _, context := range contexts {
+		if context.GetId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func intValue(value int64) *ml_metadata.Value {
+	return &ml_metadata.Value{Value: &ml_metadata.Value_IntValue{IntValue: value}}
+}
+
+func stringValue(value string) *ml_metadata.Value {
+	return &ml_metadata.Value{Value: &ml_metadata.Value_StringValue{StringValue: value}}
+}
@@ -0,0 +1,424 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeMetadataStore is an in-memory ML Metadata server holding the nodes and edges the recorder
+// reads and creates.
+type fakeMetadataStore struct {
+	ml_metadata.UnimplementedMetadataStoreServiceServer
+
+	mu             sync.Mutex
+	nextID         int64
+	executionTypes map[string]int64
+	contextTypes   map[string]int64
+	executions     map[int64]*ml_metadata.Execution
+	contexts       map[int64]*ml_metadata.Context
+	// associations maps the executions to the IDs of their contexts.
+	associations map[int64][]int64
+	events       []*ml_metadata.Event
+	// failures is the number of calls to PutExecution still to fail.
+	failures int
+}
+
+func newFakeMetadataStore() *fakeMetadataStore {
+	return &fakeMetadataStore{
+		executionTypes: map[string]int64{},
+		contextTypes:   map[string]int64{},
+		executions:     map[int64]*ml_metadata.Execution{},
+		contexts:       map[int64]*ml_metadata.Context{},
+		associations:   map[int64][]int64{},
+	}
+}
+
+func (s *fakeMetadataStore) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *fakeMetadataStore) PutExecutionType(ctx context.Context, request *ml_metadata.PutExecutionTypeRequest) (*ml_metadata.PutExecutionTypeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := request.GetExecutionType().GetName()
+	if _, ok := s.executionTypes[name]; !ok {
+		s.executionTypes[name] = s.id()
+	}
+	return &ml_metadata.PutExecutionTypeResponse{TypeId: proto.Int64(s.executionTypes[name])}, nil
+}
+
+func (s *fakeMetadataStore) PutExecution(ctx context.Context, request *ml_metadata.PutExecutionRequest) (*ml_metadata.PutExecutionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, status.Error(codes.Unavailable, "metadata store unavailable")
+	}
+	execution := proto.Clone(request.GetExecution()).(*ml_metadata.Execution)
+	for _, existing := range s.executions {
+		if existing.GetTypeId() == execution.GetTypeId() && existing.GetName() == execution.GetName() {
+			return nil, status.Errorf(codes.AlreadyExists, "execution %s already exists", execution.GetName())
+		}
+	}
+	for _, context := range request.GetContexts() {
+		if context.Id != nil {
+			if _, ok := s.contexts[context.GetId()]; !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown context %d", context.GetId())
+			}
+			continue
+		}
+		// Contexts without ID are created, like ML Metadata does.
+		for _, existing := range s.contexts {
+			if existing.GetTypeId() == context.GetTypeId() && existing.GetName() == context.GetName() {
+				return nil, status.Errorf(codes.AlreadyExists, "context %s already exists", context.GetName())
+			}
+		}
+	}
+	execution.Id = proto.Int64(s.id())
+	s.executions[execution.GetId()] = execution
+	response := &ml_metadata.PutExecutionResponse{ExecutionId: execution.Id}
+	for _, context := range request.GetContexts() {
+		if context.Id == nil {
+			context = proto.Clone(context).(*ml_metadata.Context)
+			context.Id = proto.Int64(s.id())
+			s.contexts[context.GetId()] = context
+		}
+		s.associations[execution.GetId()] = append(s.associations[execution.GetId()], context.GetId())
+		response.ContextIds = append(response.ContextIds, context.GetId())
+	}
+	for _, pair := range request.GetArtifactEventPairs() {
+		if pair.GetArtifact() != nil {
+			return nil, status.Error(codes.Unimplemented, "artifacts are not created by the fake")
+		}
+		event := proto.Clone(pair.GetEvent()).(*ml_metadata.Event)
+		event.ExecutionId = execution.Id
+		s.events = append(s.events, event)
+		response.ArtifactIds = append(response.ArtifactIds, event.GetArtifactId())
+	}
+	return response, nil
+}
+
+func (s *fakeMetadataStore) GetContextByTypeAndName(ctx context.Context, request *ml_metadata.GetContextByTypeAndNameRequest) (*ml_metadata.GetContextByTypeAndNameResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, context := range s.contexts {
+		if context.GetTypeId() == s.contextTypes[request.GetTypeName()] && context.GetName() == request.GetContextName() {
+			return &ml_metadata.GetContextByTypeAndNameResponse{Context: context}, nil
+		}
+	}
+	return &ml_metadata.GetContextByTypeAndNameResponse{}, nil
+}
+
+func (s *fakeMetadataStore) GetContextsByExecution(ctx context.Context, request *ml_metadata.GetContextsByExecutionRequest) (*ml_metadata.GetContextsByExecutionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := &ml_metadata.GetContextsByExecutionResponse{}
+	for _, id := range s.associations[request.GetExecutionId()] {
+		response.Contexts = append(response.Contexts, s.contexts[id])
+	}
+	return response, nil
+}
+
+func (s *fakeMetadataStore) GetEventsByExecutionIDs(ctx context.Context, request *ml_metadata.GetEventsByExecutionIDsRequest) (*ml_metadata.GetEventsByExecutionIDsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := &ml_metadata.GetEventsByExecutionIDsResponse{}
+	for _, event := range s.events {
+		for _, id := range request.GetExecutionIds() {
+			if event.GetExecutionId() == id {
+				response.Events = append(response.Events, event)
+			}
+		}
+	}
+	return response, nil
+}
+
+func (s *fakeMetadataStore) GetExecutionsByContext(ctx context.Context, request *ml_metadata.GetExecutionsByContextRequest) (*ml_metadata.GetExecutionsByContextResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := &ml_metadata.GetExecutionsByContextResponse{}
+	for id, contexts := range s.associations {
+		for _, contextID := range contexts {
+			if contextID == request.GetContextId() {
+				response.Executions = append(response.Executions, s.executions[id])
+			}
+		}
+	}
+	return response, nil
+}
+
+// addContext adds a context of the type.
+func (s *fakeMetadataStore) addContext(typeName string, name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.contextTypes[typeName]; !ok {
+		s.contextTypes[typeName] = s.id()
+	}
+	id := s.id()
+	s.contexts[id] = &ml_metadata.Context{Id: proto.Int64(id), TypeId: proto.Int64(s.contextTypes[typeName]), Name: proto.String(name)}
+	return id
+}
+
+// originalLineage is the execution of a pod that produced a cache entry, as recorded by the
+// metadata writer.
+type originalLineage struct {
+	executionID    int64
+	runContextID   int64
+	inputArtifact  int64
+	outputArtifact int64
+}
+
+// addOriginalLineage adds an execution of the run of workflow that read an artifact and output
+// another one.
+func (s *fakeMetadataStore) addOriginalLineage(workflow string) originalLineage {
+	lineage := originalLineage{runContextID: s.addContext(RunContextTypeName, workflow)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lineage.executionID = s.id()
+	lineage.inputArtifact = s.id()
+	lineage.outputArtifact = s.id()
+	s.executions[lineage.executionID] = &ml_metadata.Execution{Id: proto.Int64(lineage.executionID), TypeId: proto.Int64(s.id())}
+	s.associations[lineage.executionID] = []int64{lineage.runContextID}
+	path := func(key string) *ml_metadata.Event_Path {
+		return &ml_metadata.Event_Path{Steps: []*ml_metadata.Event_Path_Step{{Value: &ml_metadata.Event_Path_Step_Key{Key: key}}}}
+	}
+	s.events = append(s.events,
+		&ml_metadata.Event{ArtifactId: proto.Int64(lineage.inputArtifact), ExecutionId: proto.Int64(lineage.executionID), Type: ml_metadata.Event_INPUT.Enum(), Path: path("dataset")},
+		&ml_metadata.Event{ArtifactId: proto.Int64(lineage.outputArtifact), ExecutionId: proto.Int64(lineage.executionID), Type: ml_metadata.Event_OUTPUT.Enum(), Path: path("model")})
+	return lineage
+}
+
+// cachedExecutions returns the executions of CachedExecutionTypeName.
+func (s *fakeMetadataStore) cachedExecutions() []*ml_metadata.Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var executions []*ml_metadata.Execution
+	for _, execution := range s.executions {
+		if typeID, ok := s.executionTypes[CachedExecutionTypeName]; ok && execution.GetTypeId() == typeID {
+			executions = append(executions, execution)
+		}
+	}
+	return executions
+}
+
+func (s *fakeMetadataStore) setFailures(failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = failures
+}
+
+// newTestCachedExecutionRecorder serves the store in process and returns a recorder retrying
+// without delay and recording its outcomes to metrics unless nil.
+func newTestCachedExecutionRecorder(t *testing.T, store *fakeMetadataStore, maxRetries int, metrics WatcherMetrics) *CachedExecutionRecorder {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	ml_metadata.RegisterMetadataStoreServiceServer(grpcServer, store)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithInsecure())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	recorder := NewCachedExecutionRecorder(ml_metadata.NewMetadataStoreServiceClient(conn), maxRetries, podKeys, metrics)
+	recorder.newQueue = func() workqueue.RateLimitingInterface {
+		return workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+	}
+	recorder.queue = recorder.newQueue()
+	return recorder
+}
+
+// startCachedExecutionRecorder returns a recorder of newTestCachedExecutionRecorder running until
+// the test ends.
+func startCachedExecutionRecorder(t *testing.T, store *fakeMetadataStore, maxRetries int, metrics WatcherMetrics) *CachedExecutionRecorder {
+	recorder := newTestCachedExecutionRecorder(t, store, maxRetries, metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recorder.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return recorder
+}
+
+// servedFromCache returns a pod of the workflow that succeeded after being served from the cache
+// entry produced by the original execution.
+func servedFromCache(name string, workflow string, originalExecutionID int64) *corev1.Pod {
+	pod := completedPod(name, time.Minute)
+	pod.ObjectMeta.Labels[podKeys.CachedLabelKey] = KFPCachedLabelValue
+	pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "7"
+	pod.ObjectMeta.Labels[podKeys.MetadataExecutionIDKey] = strconv.FormatInt(originalExecutionID, 10)
+	pod.ObjectMeta.Labels[ArgoWorkflowLabelKey] = workflow
+	pod.ObjectMeta.Labels[RunIDLabelKey] = "run-2"
+	pod.ObjectMeta.Annotations[podKeys.PipelineNameKey] = "pipeline"
+	return pod
+}
+
+func TestCachedExecutionRecorderLinksTheOriginalLineage(t *testing.T) {
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	store := newFakeMetadataStore()
+	original := store.addOriginalLineage("original-workflow")
+	cachedRunContextID := store.addContext(RunContextTypeName, "cached-workflow")
+	recorder := startCachedExecutionRecorder(t, store, 0, metrics)
+
+	recorder.record(servedFromCache("step", "cached-workflow", original.executionID))
+	// The outcome is counted once the execution is put.
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionRecorded)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, store.cachedExecutions(), 1)
+
+	execution := store.cachedExecutions()[0]
+	assert.Equal(t, watchedNamespace+"/step", execution.GetName())
+	assert.Equal(t, ml_metadata.Execution_CACHED, execution.GetLastKnownState())
+	properties := execution.GetCustomProperties()
+	assert.Equal(t, original.executionID, properties[originalExecutionIDProperty].GetIntValue())
+	assert.Equal(t, int64(7), properties[cacheIDProperty].GetIntValue())
+	assert.Equal(t, "step", properties[podNameProperty].GetStringValue())
+	assert.Equal(t, "run-2", properties[runIDProperty].GetStringValue())
+	assert.Equal(t, "pipeline", properties[pipelineNameProperty].GetStringValue())
+
+	contexts := store.associations[execution.GetId()]
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i] < contexts[j] })
+	assert.Equal(t, []int64{original.runContextID, cachedRunContextID}, contexts)
+	events, err := store.GetEventsByExecutionIDs(context.Background(), &ml_metadata.GetEventsByExecutionIDsRequest{ExecutionIds: []int64{execution.GetId()}})
+	require.Nil(t, err)
+	require.Len(t, events.GetEvents(), 1, "only the outputs of the original execution are linked")
+	event := events.GetEvents()[0]
+	assert.Equal(t, original.outputArtifact, event.GetArtifactId())
+	assert.Equal(t, ml_metadata.Event_OUTPUT, event.GetType())
+	assert.Equal(t, "model", event.GetPath().GetSteps()[0].GetKey())
+
+	// Pods recorded again, e.g. after a restart, are not duplicated.
+	recorder.record(servedFromCache("step", "cached-workflow", original.executionID))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionAlreadyRecorded)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, store.cachedExecutions(), 1)
+}
+
+func TestCachedExecutionRecorderRetriesFailures(t *testing.T) {
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	store := newFakeMetadataStore()
+	original := store.addOriginalLineage("original-workflow")
+	store.setFailures(2)
+	recorder := startCachedExecutionRecorder(t, store, 3, metrics)
+
+	recorder.record(servedFromCache("step", "cached-workflow", original.executionID))
+	require.Eventually(t, func() bool { return len(store.cachedExecutions()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionFailed)))
+	assert.Equal(t, []int64{original.runContextID}, store.associations[store.cachedExecutions()[0].GetId()], "the run of the pod has no context yet")
+}
+
+func TestCachedExecutionRecorderDropsAfterMaxRetries(t *testing.T) {
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	hook, restore := captureLogs()
+	defer restore()
+	store := newFakeMetadataStore()
+	original := store.addOriginalLineage("original-workflow")
+	store.setFailures(10)
+	recorder := startCachedExecutionRecorder(t, store, 2, metrics)
+
+	recorder.record(servedFromCache("step", "cached-workflow", original.executionID))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionDropped)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, store.cachedExecutions())
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionFailed)))
+	require.NotNil(t, hook.LastEntry())
+	assert.Contains(t, hook.LastEntry().Message, "Dropping the cached execution after 3 failures")
+
+	// Executions whose original execution is unknown are retried as well, in case it is not
+	// recorded yet.
+	store.setFailures(0)
+	recorder.record(servedFromCache("other", "cached-workflow", 1000))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.cachedExecutions.WithLabelValues(CachedExecutionDropped)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, store.cachedExecutions())
+}
+
+func TestCachedExecutionRecorderSkipsPods(t *testing.T) {
+	recorder := NewCachedExecutionRecorder(nil, 0, podKeys, nil)
+	notCached := completedPod("not-cached", time.Minute)
+	withoutExecution := servedFromCache("without-execution", "workflow", 1)
+	delete(withoutExecution.ObjectMeta.Labels, podKeys.MetadataExecutionIDKey)
+	failed := servedFromCache("failed", "workflow", 1)
+	failed.Status.Phase = corev1.PodFailed
+
+	for _, pod := range []*corev1.Pod{notCached, withoutExecution, failed} {
+		recorder.record(pod)
+	}
+	assert.Equal(t, 0, recorder.queue.Len())
+}
+
+func TestWatchPodsRecordsCachedExecutions(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := newFakeMetadataStore()
+	original := store.addOriginalLineage("original-workflow")
+	recorder := startCachedExecutionRecorder(t, store, 0, nil)
+	clientset := fake.NewSimpleClientset(servedFromCache("step", "cached-workflow", original.executionID))
+
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour, CachedExecutions: recorder})
+	defer stop()
+	require.Eventually(t, func() bool { return len(store.cachedExecutions()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, watchedNamespace+"/step", store.cachedExecutions()[0].GetName())
+}
+
+func TestCachedExecutionRecorderRunsAgain(t *testing.T) {
+	store := newFakeMetadataStore()
+	original := store.addOriginalLineage("original-workflow")
+	recorder := newTestCachedExecutionRecorder(t, store, 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// A stopped run, e.g. of a lost leadership term, does not stop the next one from recording.
+	recorder.run(ctx)
+	require.Eventually(t, func() bool { return !recorder.currentQueue().ShuttingDown() }, 5*time.Second, 10*time.Millisecond)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go recorder.run(ctx)
+
+	recorder.record(servedFromCache("step", "cached-workflow", original.executionID))
+	require.Eventually(t, func() bool { return len(store.cachedExecutions()) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
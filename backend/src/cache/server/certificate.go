@@ -0,0 +1,172 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader serves the key pair found in the certificate and key files and picks up
+// rotated files without a restart. The files are stat'ed on every TLS handshake and only read
+// again when their size or modification time changed, e.g. when Kubernetes swaps the secret mount.
+type CertificateReloader struct {
+	certPath string
+	keyPath  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certPEM  []byte
+	keyPEM   []byte
+	lastStat string
+}
+
+// factory function for a CertificateReloader, failing when the files do not hold a valid key pair
+func NewCertificateReloader(certPath string, keyPath string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certPath: certPath, keyPath: keyPath}
+	stat, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.reload(stat); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is meant to be set as tls.Config.GetCertificate. It keeps serving the current
+// certificate when rotated files cannot be loaded.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stat, err := r.stat()
+	if err != nil {
+		logger.Warnf("Failed to stat the TLS certificate, serving the current one: %v", err)
+	} else if stat != r.lastStat {
+		if err := r.reload(stat); err != nil {
+			logger.Errorf("Failed to reload the TLS certificate, serving the current one: %v", err)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) stat() (string, error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return "", err
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%v/%d/%v", certInfo.Size(), certInfo.ModTime().UnixNano(), keyInfo.Size(), keyInfo.ModTime().UnixNano()), nil
+}
+
+// reload loads the key pair if its content changed. The stat is remembered even when loading
+// fails, so that a broken pair is not read again on every handshake.
+func (r *CertificateReloader) reload(stat string) error {
+	r.lastStat = stat
+	certPEM, err := ioutil.ReadFile(r.certPath)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) {
+		return nil
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if r.cert == nil {
+		logger.Infof("Loaded TLS certificate %s", describeCertificate(cert.Leaf))
+	} else {
+		logger.Infof("Reloaded TLS certificate %s, replacing %s", describeCertificate(cert.Leaf), describeCertificate(r.cert.Leaf))
+	}
+	r.cert = &cert
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	return nil
+}
+
+// ReadinessCheck makes /readyz fail while the certificate served, reloaded first if rotated, is
+// expired or not yet valid, since the kube-apiserver then rejects every call to the webhook.
+func (r *CertificateReloader) ReadinessCheck() DependencyCheck {
+	return DependencyCheck{
+		Name: "certificate",
+		Check: func(ctx context.Context) error {
+			cert, _ := r.GetCertificate(nil)
+			return checkCertificateValidity(cert.Leaf, time.Now())
+		},
+		Timeout:  time.Second,
+		Critical: true,
+		Detail: func() string {
+			cert, _ := r.GetCertificate(nil)
+			return describeCertificate(cert.Leaf)
+		},
+	}
+}
+
+func checkCertificateValidity(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("the TLS certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("the TLS certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// WebhookTLSConfig serves the certificate of the reloader. When clientCAFile is set, clients must
+// present a certificate signed by one of its CAs, as the kube-apiserver does when its admission
+// configuration gives it one for the webhook, so that nothing else can invoke the webhook.
+func WebhookTLSConfig(reloader *CertificateReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{GetCertificate: reloader.GetCertificate}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	caPEM, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA file: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in the client CA file %s", clientCAFile)
+	}
+	config.ClientCAs = clientCAs
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+func describeCertificate(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("sha256:%s expiring %s", hex.EncodeToString(fingerprint[:]), cert.NotAfter.Format(time.RFC3339))
+}
@@ -0,0 +1,295 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed key pair with the serial number and moves the files'
+// modification time forward, since rewrites within the file system's time granularity would go
+// unnoticed.
+func writeCertificate(t *testing.T, certPath string, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "cache-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	touch(t, certPath, keyPath)
+}
+
+var touches int
+
+func touch(t *testing.T, paths ...string) {
+	touches++
+	modTime := time.Now().Add(time.Duration(touches) * time.Second)
+	for _, path := range paths {
+		require.Nil(t, os.Chtimes(path, modTime, modTime))
+	}
+}
+
+// servedSerial opens a new connection and returns the serial number of the presented certificate.
+func servedSerial(t *testing.T, addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	require.Nil(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func startTLSListener(t *testing.T, reloader *CertificateReloader) net.Listener {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: reloader.GetCertificate})
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestCertificateReloaderServesRotatedCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certPath, keyPath, 1)
+
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	require.Nil(t, err)
+	listener := startTLSListener(t, reloader)
+	defer listener.Close()
+	addr := listener.Addr().String()
+	assert.Equal(t, int64(1), servedSerial(t, addr))
+
+	writeCertificate(t, certPath, keyPath, 2)
+	assert.Equal(t, int64(2), servedSerial(t, addr))
+	assert.Equal(t, int64(2), servedSerial(t, addr))
+
+	// A half-written rotation keeps the previous certificate.
+	require.Nil(t, ioutil.WriteFile(certPath, []byte("not a certificate"), 0600))
+	touch(t, certPath)
+	assert.Equal(t, int64(2), servedSerial(t, addr))
+	require.Nil(t, os.Remove(keyPath))
+	assert.Equal(t, int64(2), servedSerial(t, addr))
+
+	writeCertificate(t, certPath, keyPath, 3)
+	assert.Equal(t, int64(3), servedSerial(t, addr))
+}
+
+func TestCertificateReloaderReadinessCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certPath, keyPath, 1)
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	require.Nil(t, err)
+
+	status := runDependencyCheck(context.Background(), reloader.ReadinessCheck())
+	assert.Equal(t, HealthStatusOK, status.Status)
+	assert.Contains(t, status.Detail, "sha256:")
+
+	leaf := reloader.cert.Leaf
+	assert.Nil(t, checkCertificateValidity(leaf, time.Now()))
+	err = checkCertificateValidity(leaf, leaf.NotAfter.Add(time.Second))
+	assert.Contains(t, err.Error(), "the TLS certificate expired at")
+	err = checkCertificateValidity(leaf, leaf.NotBefore.Add(-time.Second))
+	assert.Contains(t, err.Error(), "the TLS certificate is not valid before")
+}
+
+func TestCertificateReloaderFailsWithoutKeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	_, err = NewCertificateReloader(certPath, keyPath)
+	assert.NotNil(t, err)
+
+	writeCertificate(t, certPath, keyPath, 1)
+	require.Nil(t, ioutil.WriteFile(keyPath, []byte("not a key"), 0600))
+	_, err = NewCertificateReloader(certPath, keyPath)
+	assert.NotNil(t, err)
+}
+
+// clientCA is a CA issuing client certificates.
+type clientCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newClientCA(t *testing.T) *clientCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kube-apiserver-client-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &clientCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *clientCA) issue(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestWebhookTLSConfigVerifiesClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certPath, keyPath, 1)
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	require.Nil(t, err)
+	ca := newClientCA(t)
+	caPath := filepath.Join(dir, "client-ca.pem")
+	require.Nil(t, ioutil.WriteFile(caPath, ca.pem, 0600))
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		clientCerts  []tls.Certificate
+		wantOK       bool
+	}{
+		{
+			name:   "no client CA, no client certificate",
+			wantOK: true,
+		},
+		{
+			name:         "client certificate of the CA",
+			clientCAFile: caPath,
+			clientCerts:  []tls.Certificate{ca.issue(t)},
+			wantOK:       true,
+		},
+		{
+			name:         "no client certificate",
+			clientCAFile: caPath,
+		},
+		{
+			name:         "client certificate of another CA",
+			clientCAFile: caPath,
+			clientCerts:  []tls.Certificate{newClientCA(t).issue(t)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := WebhookTLSConfig(reloader, test.clientCAFile)
+			require.Nil(t, err)
+			webhook := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			webhook.TLS = config
+			// The rejected handshakes are logged by the server.
+			webhook.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			webhook.StartTLS()
+			defer webhook.Close()
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       test.clientCerts,
+			}}}
+
+			response, err := client.Get(webhook.URL)
+
+			if !test.wantOK {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			response.Body.Close()
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+		})
+	}
+}
+
+func TestWebhookTLSConfigFailsWithoutClientCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certPath, keyPath, 1)
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	require.Nil(t, err)
+
+	_, err = WebhookTLSConfig(reloader, filepath.Join(dir, "missing.pem"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to read the client CA file")
+
+	caPath := filepath.Join(dir, "client-ca.pem")
+	require.Nil(t, ioutil.WriteFile(caPath, []byte("not a certificate"), 0600))
+	_, err = WebhookTLSConfig(reloader, caPath)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no PEM encoded certificates found")
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultLookupCircuitFailureThreshold int           = 5
+	DefaultLookupCircuitCoolDown         time.Duration = 30 * time.Second
+)
+
+// factory function for the circuit breaker around cache lookups. It stops the lookups after
+// failureThreshold consecutive store failures, so that admissions do not each wait for a store
+// that is down. The first lookup after the cool-down is the probe.
+func NewLookupCircuitBreaker(failureThreshold int, coolDown time.Duration, time util.TimeInterface, registerer prometheus.Registerer) *storage.CircuitBreaker {
+	return storage.NewCircuitBreaker(storage.CircuitBreakerConfig{
+		Name:             "the cache store",
+		Skipped:          "admitting pods without lookup",
+		FailureThreshold: failureThreshold,
+		CoolDown:         coolDown,
+		StateGauge: prometheus.GaugeOpts{
+			Name: "cache_lookup_circuit_state",
+			Help: "State of the circuit around cache lookups of the webhook: 0 closed, 1 half-open, 2 open. Lookups are skipped while open.",
+		},
+	}, time, registerer)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedClock returns the time set by the test.
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLookupCircuitBreaker(clock *fixedClock) *storage.CircuitBreaker {
+	return NewLookupCircuitBreaker(3, time.Minute, clock, prometheus.NewRegistry())
+}
+
+// togglingExecutionCacheStore fails lookups while failing is set and counts the lookups made.
+type togglingExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	failing int32
+	lookups int32
+}
+
+func (s *togglingExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	atomic.AddInt32(&s.lookups, 1)
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return nil, errors.New("connection refused")
+	}
+	return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+}
+
+func TestMutatePodIfCachedSkipsLookupsWhileCircuitIsOpen(t *testing.T) {
+	clock := &fixedClock{now: time.Unix(1000, 0)}
+	breaker := newTestLookupCircuitBreaker(clock)
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{
+		LookupCircuitBreaker: breaker,
+		Metrics:              metrics,
+	})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &togglingExecutionCacheStore{}
+	clientManager.cacheStore = store
+	admit := func() []patchOperation {
+		patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(fakePod), clientManager)
+		require.Nil(t, err)
+		return patches
+	}
+
+	admit()
+	atomic.StoreInt32(&store.failing, 1)
+	for i := 0; i < 3; i++ {
+		admit()
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, storage.CircuitOpen, breaker.State())
+
+	// While open, pods are admitted with their execution key but without lookup.
+	patches := admit()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&store.lookups))
+	require.Len(t, patches, 2)
+	assert.Equal(t, "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0", patches[0].Value.(map[string]string)[podKeys.ExecutionKey])
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeSkippedCircuitOpen)))
+
+	// A failed probe keeps the circuit open.
+	clock.now = clock.now.Add(time.Minute)
+	admit()
+	assert.Equal(t, int32(5), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, storage.CircuitOpen, breaker.State())
+
+	// Once the store is healthy again the next probe closes the circuit.
+	atomic.StoreInt32(&store.failing, 0)
+	admit()
+	assert.Equal(t, int32(5), atomic.LoadInt32(&store.lookups))
+	clock.now = clock.now.Add(time.Minute)
+	admit()
+	admit()
+	assert.Equal(t, int32(7), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, storage.CircuitClosed, breaker.State())
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeMiss)))
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeError)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeSkippedCircuitOpen)))
+}
@@ -0,0 +1,348 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client calls the admin API of the cache server, with the types the server serves.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+)
+
+const (
+	DefaultTimeout time.Duration = 30 * time.Second
+
+	// maxErrorBodyBytes bounds the bodies of failed responses quoted in errors, e.g. the HTML page
+	// of a proxy.
+	maxErrorBodyBytes int64 = 512
+)
+
+// DefaultRetryPolicy is the retry policy of the clients configured without one.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// RetryPolicy tells how requests that got no response or a 5xx status are sent again. Imports are
+// never sent again, as the server may have created the entry.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts of each request, 1 sends every request once.
+	MaxAttempts int
+	// InitialBackoff is the wait before the second attempt, doubled before each next one up to
+	// MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// backoff returns the wait before the attempt, counted from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 2; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// Config configures a Client.
+type Config struct {
+	// BaseURL is the address of the health port of the cache server, e.g. http://cache-server:8080.
+	BaseURL string
+	// Token is the bearer token of the admin API.
+	Token string
+	// Timeout bounds each attempt of a request, DefaultTimeout when zero.
+	Timeout time.Duration
+	// Retry is DefaultRetryPolicy when zero.
+	Retry RetryPolicy
+}
+
+// Client calls the admin API of a cache server. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	token   string
+	retry   RetryPolicy
+	client  *http.Client
+}
+
+// factory function for a client of the admin API of the server at config.BaseURL
+func New(config Config) (*Client, error) {
+	baseURL, err := url.Parse(config.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q, must be an http or https URL", config.BaseURL)
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	retry := config.Retry
+	if retry == (RetryPolicy{}) {
+		retry = DefaultRetryPolicy
+	}
+	if retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid retry policy, MaxAttempts must be at least 1")
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
+		token:   config.Token,
+		retry:   retry,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// ListOptions selects the entries listed or exported. Zero fields select every entry.
+type ListOptions struct {
+	KeyPrefix string
+	// Namespace selects the entries owned by the profile or a service account of the namespace.
+	Namespace string
+	// PipelineName selects the entries produced by the runs of the pipeline.
+	PipelineName  string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// PageSize is the number of entries listed by each request, server.DefaultAdminPageSize when
+	// zero.
+	PageSize int
+	// PageToken is where the listing starts, the NextPageToken of a previous page.
+	PageToken string
+	// Full lists the entries with their templates and outputs.
+	Full bool
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"key_prefix":    o.KeyPrefix,
+		"namespace":     o.Namespace,
+		"pipeline_name": o.PipelineName,
+		"page_token":    o.PageToken,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if !o.CreatedAfter.IsZero() {
+		query.Set("created_after", o.CreatedAfter.UTC().Format(time.RFC3339))
+	}
+	if !o.CreatedBefore.IsZero() {
+		query.Set("created_before", o.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	if o.PageSize > 0 {
+		query.Set("page_size", fmt.Sprint(o.PageSize))
+	}
+	if o.Full {
+		query.Set("view", "full")
+	}
+	return query
+}
+
+// ListEntriesPage lists the page of the entries starting at options.PageToken.
+func (c *Client) ListEntriesPage(ctx context.Context, options ListOptions) (*server.AdminEntryList, error) {
+	var list server.AdminEntryList
+	if err := c.do(ctx, http.MethodGet, server.AdminEntriesAPI, options.query(), nil, &list, true); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListEntries iterates over the entries from options.PageToken on, listing the pages as they are
+// needed.
+func (c *Client) ListEntries(ctx context.Context, options ListOptions) *EntryIterator {
+	return &EntryIterator{ctx: ctx, client: c, options: options}
+}
+
+// EntryIterator iterates over the entries listed by ListEntries:
+//
+//	entries := c.ListEntries(ctx, client.ListOptions{})
+//	for entries.Next() {
+//		entry := entries.Entry()
+//	}
+//	if err := entries.Err(); err != nil {
+type EntryIterator struct {
+	ctx     context.Context
+	client  *Client
+	options ListOptions
+
+	page  []server.AdminEntry
+	entry server.AdminEntry
+	done  bool
+	err   error
+}
+
+// Next moves to the next entry, and reports whether there is one.
+func (it *EntryIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		list, err := it.client.ListEntriesPage(it.ctx, it.options)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = list.Entries
+		it.options.PageToken = list.NextPageToken
+		it.done = list.NextPageToken == ""
+	}
+	it.entry, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Entry returns the current entry.
+func (it *EntryIterator) Entry() server.AdminEntry {
+	return it.entry
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *EntryIterator) Err() error {
+	return it.err
+}
+
+// GetEntry returns the entry of the ID with its template and outputs.
+func (c *Client) GetEntry(ctx context.Context, id string) (*server.AdminEntry, error) {
+	var entry server.AdminEntry
+	if err := c.do(ctx, http.MethodGet, entryPath(id), nil, nil, &entry, true); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// DeleteEntry deletes the entry of the ID.
+func (c *Client) DeleteEntry(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, entryPath(id), nil, nil, nil, true)
+}
+
+// DeleteEntriesByKey deletes every entry of the cache key. A key without entry is a NotFoundError.
+func (c *Client) DeleteEntriesByKey(ctx context.Context, key string) (*server.AdminDeletion, error) {
+	var deletion server.AdminDeletion
+	if err := c.do(ctx, http.MethodDelete, server.AdminEntriesAPI, url.Values{"key": {key}}, nil, &deletion, true); err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// Invalidate deletes at once the entries of the request, or counts them on a dry run.
+func (c *Client) Invalidate(ctx context.Context, request server.AdminInvalidateRequest) (*server.AdminInvalidation, error) {
+	var invalidation server.AdminInvalidation
+	if err := c.do(ctx, http.MethodPost, server.AdminInvalidateAPI, nil, request, &invalidation, true); err != nil {
+		return nil, err
+	}
+	return &invalidation, nil
+}
+
+// Stats returns the stats of the cache store and of the lookups of the server.
+func (c *Client) Stats(ctx context.Context) (*server.Stats, error) {
+	var stats server.Stats
+	if err := c.do(ctx, http.MethodGet, server.StatsAPI, nil, nil, &stats, true); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// RunCacheReuses returns the nodes of the run served from cache.
+func (c *Client) RunCacheReuses(ctx context.Context, runID string) (*server.RunCacheReuses, error) {
+	var reuses server.RunCacheReuses
+	if err := c.do(ctx, http.MethodGet, server.CacheRunsAPI+"/"+url.PathEscape(runID), nil, nil, &reuses, true); err != nil {
+		return nil, err
+	}
+	return &reuses, nil
+}
+
+// ImportEntry creates the entry, with its template and outputs, as of now.
+func (c *Client) ImportEntry(ctx context.Context, entry server.AdminEntry) error {
+	return c.do(ctx, http.MethodPost, server.AdminEntriesAPI, nil, entry, nil, false)
+}
+
+func entryPath(id string) string {
+	return server.AdminEntriesAPI + "/" + url.PathEscape(id)
+}
+
+// do sends the request with the JSON of body, if any, and decodes the JSON response into response,
+// if any. Idempotent requests are sent again according to the retry policy.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, response interface{}, idempotent bool) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.send(ctx, method, target, content, response)
+		if !idempotent || attempt >= c.retry.MaxAttempts || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(c.retry.backoff(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends a single attempt of a request.
+func (c *Client) send(ctx context.Context, method string, target string, content []byte, response interface{}) error {
+	var reader io.Reader
+	if content != nil {
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if content != nil {
+		request.Header.Set(server.ContentType, server.JsonContentType)
+	}
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		if err != nil {
+			return &TransportError{Err: err}
+		}
+		var adminError server.AdminError
+		if json.Unmarshal(b, &adminError) != nil || adminError.Error.Message == "" {
+			adminError.Error.Message = strings.TrimSpace(string(b))
+		}
+		return newAPIError(resp.StatusCode, adminError.Error)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("invalid response to %s %s: %v", method, request.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "admin-token"
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+// newTestServer returns a server of the admin API and stats over a store holding entries of the
+// keys, and the number of requests listing entries.
+func newTestServer(t *testing.T, keys ...string) (*httptest.Server, *int32) {
+	clientManager := server.NewFakeClientManagerOrFatal(util.NewFakeTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	t.Cleanup(func() { clientManager.Close() })
+	for _, key := range keys {
+		_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: key,
+			ExecutionTemplate: `{"name":"train","container":{}}`,
+			ExecutionOutput:   "output of " + key,
+			MaxCacheStaleness: -1,
+		})
+		require.Nil(t, err)
+	}
+	store := clientManager.CacheStore()
+	adminHandler := server.AdminHandler(store.(storage.ExecutionCacheAdminStore))
+	var listRequests int32
+	mux := http.NewServeMux()
+	mux.Handle(server.AdminEntriesAPI, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&listRequests, 1)
+		}
+		adminHandler.ServeHTTP(w, r)
+	}))
+	mux.Handle(server.AdminEntriesAPI+"/", adminHandler)
+	mux.Handle(server.AdminInvalidateAPI, adminHandler)
+	mux.Handle(server.StatsAPI, server.StatsHandler(server.NewStatsCollector(store.(storage.ExecutionCacheStatsStore), time.Minute, util.NewRealTime())))
+	s := httptest.NewServer(server.RequireAdminToken(server.NewAdminToken(testToken), mux))
+	t.Cleanup(s.Close)
+	return s, &listRequests
+}
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	c, err := New(Config{BaseURL: baseURL, Token: testToken, Retry: testRetryPolicy})
+	require.Nil(t, err)
+	return c
+}
+
+// failingHandler answers the first failures requests with the status, and the next ones with the
+// stats, counting the requests.
+func failingHandler(status int, failures int32, requests *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			http.Error(w, "unavailable", status)
+			return
+		}
+		w.Header().Set(server.ContentType, server.JsonContentType)
+		w.Write([]byte(`{"schemaVersion":1}`))
+	})
+}
+
+func TestListEntriesIteratesOverThePages(t *testing.T) {
+	s, listRequests := newTestServer(t, "a", "b", "c", "d", "e")
+	c := newTestClient(t, s.URL)
+
+	var keys []string
+	entries := c.ListEntries(context.Background(), ListOptions{PageSize: 2})
+	for entries.Next() {
+		keys = append(keys, entries.Entry().CacheKey)
+	}
+	require.Nil(t, entries.Err())
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+	assert.Equal(t, int32(3), atomic.LoadInt32(listRequests))
+	assert.False(t, entries.Next(), "the iteration is over")
+
+	page, err := c.ListEntriesPage(context.Background(), ListOptions{PageSize: 2, KeyPrefix: "c"})
+	require.Nil(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "c", page.Entries[0].CacheKey)
+	assert.Empty(t, page.Entries[0].Output, "the basic view has no outputs")
+	assert.Empty(t, page.NextPageToken)
+}
+
+func TestListEntriesStopsOnErrors(t *testing.T) {
+	c := newTestClient(t, "http://127.0.0.1:1")
+
+	entries := c.ListEntries(context.Background(), ListOptions{})
+	assert.False(t, entries.Next())
+	var transport *TransportError
+	assert.True(t, errors.As(entries.Err(), &transport))
+}
+
+func TestEntries(t *testing.T) {
+	s, _ := newTestServer(t, "a", "b", "b")
+	c := newTestClient(t, s.URL)
+	ctx := context.Background()
+
+	entry, err := c.GetEntry(ctx, "1")
+	require.Nil(t, err)
+	assert.Equal(t, "a", entry.CacheKey)
+	assert.Equal(t, "output of a", entry.Output)
+
+	require.Nil(t, c.DeleteEntry(ctx, "1"))
+	_, err = c.GetEntry(ctx, "1")
+	var notFound *NotFoundError
+	require.True(t, errors.As(err, &notFound), err)
+	assert.Equal(t, "404 Not Found: cache entry not found", err.Error())
+
+	deletion, err := c.DeleteEntriesByKey(ctx, "b")
+	require.Nil(t, err)
+	assert.Equal(t, &server.AdminDeletion{Deleted: 2}, deletion)
+	_, err = c.DeleteEntriesByKey(ctx, "b")
+	assert.Equal(t, http.StatusNotFound, StatusCode(err))
+}
+
+func TestInvalidateAndStats(t *testing.T) {
+	s, _ := newTestServer(t, "a1", "a2", "b1")
+	c := newTestClient(t, s.URL)
+	ctx := context.Background()
+
+	invalidation, err := c.Invalidate(ctx, server.AdminInvalidateRequest{KeyPrefix: "a"})
+	require.Nil(t, err)
+	assert.Equal(t, &server.AdminInvalidation{Invalidated: 2}, invalidation)
+
+	_, err = c.Invalidate(ctx, server.AdminInvalidateRequest{})
+	var apiError *APIError
+	require.True(t, errors.As(err, &apiError), err)
+	assert.Equal(t, http.StatusBadRequest, apiError.StatusCode)
+
+	stats, err := c.Stats(ctx)
+	require.Nil(t, err)
+	require.NotNil(t, stats.Store)
+	assert.Equal(t, int64(1), stats.Store.Entries)
+}
+
+func TestExportAndImport(t *testing.T) {
+	source, _ := newTestServer(t, "a", "b", "c")
+	destination, _ := newTestServer(t)
+	ctx := context.Background()
+
+	var exported bytes.Buffer
+	count, err := newTestClient(t, source.URL).Export(ctx, ListOptions{PageSize: 2}, &exported)
+	require.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Len(t, strings.Split(strings.TrimSpace(exported.String()), "\n"), 3)
+
+	c := newTestClient(t, destination.URL)
+	count, err = c.Import(ctx, strings.NewReader(exported.String()))
+	require.Nil(t, err)
+	assert.Equal(t, 3, count)
+	entry, err := c.GetEntry(ctx, "3")
+	require.Nil(t, err)
+	assert.Equal(t, "output of c", entry.Output)
+
+	count, err = c.Import(ctx, strings.NewReader(exported.String()+"{\"cacheKey\":\n"))
+	assert.Equal(t, 3, count)
+	assert.EqualError(t, err, "invalid entry on line 4: unexpected end of JSON input")
+}
+
+func TestRunCacheReuses(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store, err := storage.NewCacheReuseStore(db)
+	require.Nil(t, err)
+	_, err = store.RecordCacheReuse(&model.CacheReuse{RunID: "run-1", NodeID: "pipeline-abc-123", Namespace: "ns1", CacheEntryID: 7, ReusedAtInSec: 100})
+	require.Nil(t, err)
+	s := httptest.NewServer(server.RequireAdminToken(server.NewAdminToken(testToken), server.CacheRunsHandler(store)))
+	defer s.Close()
+
+	reuses, err := newTestClient(t, s.URL).RunCacheReuses(context.Background(), "run-1")
+	require.Nil(t, err)
+	require.Len(t, reuses.CachedNodes, 1)
+	assert.Equal(t, "pipeline-abc-123", reuses.CachedNodes[0].NodeID)
+	assert.Equal(t, int64(7), reuses.CachedNodes[0].CacheEntryID)
+}
+
+func TestUnauthorized(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, err := New(Config{BaseURL: s.URL, Token: "wrong", Retry: testRetryPolicy})
+	require.Nil(t, err)
+
+	_, err = c.Stats(context.Background())
+	var unauthorized *UnauthorizedError
+	require.True(t, errors.As(err, &unauthorized), err)
+	assert.Equal(t, http.StatusUnauthorized, unauthorized.StatusCode)
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(failingHandler(http.StatusServiceUnavailable, 2, &requests))
+	defer s.Close()
+
+	stats, err := newTestClient(t, s.URL).Stats(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, server.StatsSchemaVersion, stats.SchemaVersion)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestRetriesGiveUpAfterMaxAttempts(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(failingHandler(http.StatusInternalServerError, 10, &requests))
+	defer s.Close()
+	c := newTestClient(t, s.URL)
+
+	_, err := c.Stats(context.Background())
+	var serverError *ServerError
+	require.True(t, errors.As(err, &serverError), err)
+	assert.Equal(t, "500 Internal Server Error: unavailable", err.Error())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	err = c.ImportEntry(context.Background(), server.AdminEntry{CacheKey: "key"})
+	assert.True(t, errors.As(err, &serverError), err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests), "imports are not sent again")
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(failingHandler(http.StatusBadRequest, 10, &requests))
+	defer s.Close()
+
+	_, err := newTestClient(t, s.URL).Stats(context.Background())
+	assert.Equal(t, http.StatusBadRequest, StatusCode(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "ftp://cache-server"} {
+		_, err := New(Config{BaseURL: baseURL})
+		assert.NotNil(t, err, baseURL)
+	}
+	_, err := New(Config{BaseURL: "http://cache-server", Retry: RetryPolicy{InitialBackoff: time.Second}})
+	assert.NotNil(t, err, "retry policies need MaxAttempts")
+
+	c, err := New(Config{BaseURL: "http://cache-server/"})
+	require.Nil(t, err)
+	assert.Equal(t, "http://cache-server", c.baseURL)
+	assert.Equal(t, DefaultRetryPolicy, c.retry)
+	assert.Equal(t, DefaultTimeout, c.client.Timeout)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(2))
+	assert.Equal(t, 2*time.Second, policy.backoff(3))
+	assert.Equal(t, 3*time.Second, policy.backoff(4))
+	assert.Equal(t, 3*time.Second, policy.backoff(5))
+}
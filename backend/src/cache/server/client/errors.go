@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+)
+
+// APIError is a request the admin API answered with an error status other than those of
+// NotFoundError, UnauthorizedError and ServerError, e.g. an invalid request.
+type APIError struct {
+	StatusCode int
+	Detail     server.AdminErrorDetail
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Detail.Message)
+}
+
+// NotFoundError is a missing entry, or no entry of a cache key.
+type NotFoundError struct {
+	APIError
+}
+
+// UnauthorizedError is a missing or invalid admin token.
+type UnauthorizedError struct {
+	APIError
+}
+
+// ServerError is a request the server failed, after the retries of the request.
+type ServerError struct {
+	APIError
+}
+
+// TransportError is a request that got no response, e.g. because the server is unreachable.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the status the admin API answered the failed request with, or 0 when the
+// error is not an answer of the admin API.
+func StatusCode(err error) int {
+	var notFound *NotFoundError
+	var unauthorized *UnauthorizedError
+	var serverError *ServerError
+	var apiError *APIError
+	switch {
+	case errors.As(err, &notFound):
+		return notFound.StatusCode
+	case errors.As(err, &unauthorized):
+		return unauthorized.StatusCode
+	case errors.As(err, &serverError):
+		return serverError.StatusCode
+	case errors.As(err, &apiError):
+		return apiError.StatusCode
+	}
+	return 0
+}
+
+func newAPIError(statusCode int, detail server.AdminErrorDetail) error {
+	apiError := APIError{StatusCode: statusCode, Detail: detail}
+	switch {
+	case statusCode == http.StatusNotFound:
+		return &NotFoundError{apiError}
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return &UnauthorizedError{apiError}
+	case statusCode >= http.StatusInternalServerError:
+		return &ServerError{apiError}
+	}
+	return &apiError
+}
+
+// retryable reports whether a request that failed with the error may succeed when sent again.
+func retryable(err error) bool {
+	var transport *TransportError
+	var serverError *ServerError
+	return errors.As(err, &transport) || errors.As(err, &serverError)
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+)
+
+// maxImportLineBytes bounds the lines of the entries imported, which hold a whole entry each.
+const maxImportLineBytes int = 16 * 1024 * 1024
+
+// Export writes the entries selected by the options, with their templates and outputs, to w as
+// JSON lines, the largest pages at a time. It returns the number of entries written, also when it
+// fails midway.
+func (c *Client) Export(ctx context.Context, options ListOptions, w io.Writer) (int, error) {
+	options.Full = true
+	if options.PageSize == 0 {
+		options.PageSize = server.MaxAdminPageSize
+	}
+	encoder := json.NewEncoder(w)
+	exported := 0
+	entries := c.ListEntries(ctx, options)
+	for entries.Next() {
+		if err := encoder.Encode(entries.Entry()); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+	return exported, entries.Err()
+}
+
+// Import creates the entries of the JSON lines written by Export, as of now, one at a time. It
+// returns the number of entries created, also when it fails midway, after which the entries of
+// the following lines are not created.
+func (c *Client) Import(ctx context.Context, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxImportLineBytes)
+	imported := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry server.AdminEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return imported, fmt.Errorf("invalid entry on line %d: %v", line, err)
+		}
+		if err := c.ImportEntry(ctx, entry); err != nil {
+			return imported, fmt.Errorf("failed to import the entry on line %d: %w", line, err)
+		}
+		imported++
+	}
+	return imported, scanner.Err()
+}
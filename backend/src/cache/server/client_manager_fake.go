@@ -21,11 +21,15 @@ import (
 	"github.com/kubeflow/pipelines/backend/src/common/util"
 )
 
+// FakeClientManager serves a cache store over an in-memory database. Its clients are set up front,
+// and replaced by tests before use.
 type FakeClientManager struct {
 	db                *storage.DB
 	cacheStore        storage.ExecutionCacheStoreInterface
 	k8sCoreClientFake *client.FakeKuberneteCoreClient
-	time              util.TimeInterface
+	// redisClient is nil unless set by a test.
+	redisClient *client.RedisClient
+	time        util.TimeInterface
 }
 
 func NewFakeClientManager(time util.TimeInterface) (*FakeClientManager, error) {
@@ -66,6 +70,10 @@ func (f *FakeClientManager) DB() *storage.DB {
 	return f.db
 }
 
+func (f *FakeClientManager) RedisClient() *client.RedisClient {
+	return f.redisClient
+}
+
 func (f *FakeClientManager) Close() error {
 	return f.db.Close()
 }
@@ -73,3 +81,7 @@ func (f *FakeClientManager) Close() error {
 func (f *FakeClientManager) KubernetesCoreClient() client.KubernetesCoreInterface {
 	return f.k8sCoreClientFake
 }
+
+func (f *FakeClientManager) ArgoClient() client.ArgoClientInterface {
+	return nil
+}
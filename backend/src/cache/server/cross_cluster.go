@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+)
+
+const (
+	// CrossClusterShared reuses the entries recorded in any cluster sharing the cache store.
+	CrossClusterShared string = "shared"
+	// CrossClusterLocal only reuses the entries recorded in the cluster of the webhook.
+	CrossClusterLocal string = "local"
+	// CrossClusterPreferLocal reuses the entries recorded in other clusters only when the cluster
+	// of the webhook has none.
+	CrossClusterPreferLocal string = "prefer-local"
+
+	// The outcomes of verifying the artifacts of an entry recorded in another cluster.
+	RemoteEntryOutcomeLive    string = "live"
+	RemoteEntryOutcomeMissing string = "missing"
+	RemoteEntryOutcomeFailed  string = "failed"
+)
+
+// IsValidCrossClusterPolicy reports whether policy is one of the CrossCluster policies.
+func IsValidCrossClusterPolicy(policy string) bool {
+	switch policy {
+	case CrossClusterShared, CrossClusterLocal, CrossClusterPreferLocal:
+		return true
+	}
+	return false
+}
+
+// crossClusterLookups returns the store with its lookups restricted to the entries the cross
+// cluster policy of config reuses. The artifacts of the entries recorded in other clusters are
+// verified when config.RemoteArtifacts is set, and the outcomes recorded in metrics.
+func crossClusterLookups(store storage.ExecutionCacheStoreInterface, config MutationConfig, metrics MutationMetrics) storage.ExecutionCacheStoreInterface {
+	policy := config.CrossCluster
+	if policy == "" {
+		policy = CrossClusterShared
+	}
+	if policy == CrossClusterShared && config.RemoteArtifacts == nil {
+		return store
+	}
+	return &crossClusterStore{
+		ExecutionCacheStoreInterface: store,
+		clusterID:                    config.ClusterID,
+		policy:                       policy,
+		artifacts:                    config.RemoteArtifacts,
+		metrics:                      metrics,
+	}
+}
+
+// crossClusterStore looks entries up under a cross cluster policy.
+type crossClusterStore struct {
+	storage.ExecutionCacheStoreInterface
+	clusterID string
+	policy    string
+	artifacts ArtifactStore
+	metrics   MutationMetrics
+}
+
+func (s *crossClusterStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if s.policy == CrossClusterLocal || s.policy == CrossClusterPreferLocal {
+		localFilter := filter
+		localFilter.ClusterID = s.clusterID
+		executionCache, err := s.ExecutionCacheStoreInterface.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, localFilter)
+		if s.policy == CrossClusterLocal || !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			return executionCache, err
+		}
+	}
+	executionCache, err := s.ExecutionCacheStoreInterface.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+	if err != nil || s.artifacts == nil || (s.clusterID != "" && executionCache.ClusterID == s.clusterID) {
+		return executionCache, err
+	}
+	if err := s.verifyArtifacts(executionCache); err != nil {
+		return nil, err
+	}
+	return executionCache, nil
+}
+
+// verifyArtifacts checks that the S3 artifacts of an entry recorded in another cluster, or in no
+// known cluster, still exist. Entries whose artifacts are missing or cannot be checked are not
+// reused, the pod runs like a cache miss.
+func (s *crossClusterStore) verifyArtifacts(executionCache *model.ExecutionCache) error {
+	entryOutputs, err := outputs.Parse(getValueFromSerializedMap(executionCache.ExecutionOutput, ArgoWorkflowOutputs))
+	if err != nil {
+		// The webhook skips the entries with unreadable outputs anyway.
+		return nil
+	}
+	for _, artifact := range entryOutputs.Artifacts {
+		bucket, key, ok := artifact.S3Location()
+		if !ok {
+			continue
+		}
+		exists, err := s.artifacts.ArtifactExists(bucket, key)
+		if err != nil {
+			logger.Warnf("Failed to check artifact %s of cache entry %d recorded in cluster %q, not reusing it: %v", artifact.Name, executionCache.ID, executionCache.ClusterID, err)
+			s.metrics.RemoteEntryVerified(RemoteEntryOutcomeFailed)
+			return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Artifact %s of execution cache key %s could not be checked: %v", artifact.Name, executionCache.ExecutionCacheKey, err)
+		}
+		if !exists {
+			s.metrics.RemoteEntryVerified(RemoteEntryOutcomeMissing)
+			return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Artifact %s of execution cache key %s no longer exists.", artifact.Name, executionCache.ExecutionCacheKey)
+		}
+	}
+	s.metrics.RemoteEntryVerified(RemoteEntryOutcomeLive)
+	return nil
+}
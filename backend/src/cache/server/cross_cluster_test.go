@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localClusterID is the cluster of the webhook under test.
+const localClusterID string = "us-east1"
+
+// clusterEntry is an entry of the key recorded in the cluster, with the execution output.
+type clusterEntry struct {
+	key             string
+	clusterID       string
+	executionOutput string
+}
+
+// seedClusterEntries stores the entries in order, each one newer than the previous ones.
+func seedClusterEntries(t *testing.T, store storage.ExecutionCacheStoreInterface, entries ...clusterEntry) {
+	for _, entry := range entries {
+		executionOutput := entry.executionOutput
+		if executionOutput == "" {
+			executionOutput = testExecutionOutput
+		}
+		_, err := store.CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: entry.key,
+			ExecutionOutput:   executionOutput,
+			ExecutionTemplate: `{"container":{"image":"python:3.7"}}`,
+			MaxCacheStaleness: -1,
+			ClusterID:         entry.clusterID,
+		})
+		require.Nil(t, err)
+	}
+}
+
+// lookUpCluster returns the cluster of the entry of the key reused under the config, through a
+// lookup coalescer like the webhook, or "miss" when none is. The verifications of remote entries
+// are recorded to metrics.
+func lookUpCluster(t *testing.T, store storage.ExecutionCacheStoreInterface, coalescer *LookupCoalescer, config MutationConfig, metrics MutationMetrics, key string) string {
+	entry, err := crossClusterLookups(coalescer.coalesced(store, "ns1"), config, metrics).GetExecutionCache(context.Background(), key, -1, storage.ExecutionCacheFilter{})
+	if util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+		return "miss"
+	}
+	require.Nil(t, err)
+	return entry.ClusterID
+}
+
+func TestCrossClusterLookups(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	seedClusterEntries(t, store,
+		clusterEntry{key: "local-and-remote", clusterID: "eu-west1"},
+		clusterEntry{key: "local-and-remote", clusterID: localClusterID},
+		clusterEntry{key: "local-and-remote", clusterID: "asia-south1"},
+		clusterEntry{key: "remote-only", clusterID: "eu-west1"},
+		clusterEntry{key: "unidentified", clusterID: ""},
+		clusterEntry{key: "local-only", clusterID: localClusterID},
+	)
+
+	for _, tc := range []struct {
+		policy string
+		want   map[string]string
+	}{
+		{
+			policy: CrossClusterShared,
+			want: map[string]string{
+				"local-and-remote": "asia-south1",
+				"remote-only":      "eu-west1",
+				"unidentified":     "",
+				"local-only":       localClusterID,
+				"absent":           "miss",
+			},
+		},
+		{
+			policy: CrossClusterLocal,
+			want: map[string]string{
+				"local-and-remote": localClusterID,
+				"remote-only":      "miss",
+				"unidentified":     "miss",
+				"local-only":       localClusterID,
+				"absent":           "miss",
+			},
+		},
+		{
+			policy: CrossClusterPreferLocal,
+			want: map[string]string{
+				"local-and-remote": localClusterID,
+				"remote-only":      "eu-west1",
+				"unidentified":     "",
+				"local-only":       localClusterID,
+				"absent":           "miss",
+			},
+		},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			coalescer := newTestLookupCoalescer(util.NewFakeTimeForEpoch())
+			config := MutationConfig{ClusterID: localClusterID, CrossCluster: tc.policy}
+			for key, want := range tc.want {
+				assert.Equal(t, want, lookUpCluster(t, store, coalescer, config, noopMutationMetrics{}, key), key)
+				// The misses of local lookups remembered by the coalescer do not hide the entries
+				// of other clusters.
+				assert.Equal(t, want, lookUpCluster(t, store, coalescer, config, noopMutationMetrics{}, key), key+" again")
+			}
+		})
+	}
+}
+
+func TestCrossClusterLookupsWithoutPolicyShareEntries(t *testing.T) {
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	seedClusterEntries(t, store, clusterEntry{key: "key1", clusterID: "eu-west1"})
+
+	assert.Equal(t, store, crossClusterLookups(store, MutationConfig{ClusterID: localClusterID}, noopMutationMetrics{}), "lookups are left as they are")
+	assert.Equal(t, "eu-west1", lookUpCluster(t, store, newTestLookupCoalescer(util.NewFakeTimeForEpoch()), MutationConfig{}, noopMutationMetrics{}, "key1"))
+}
+
+func TestCrossClusterLookupsVerifyRemoteArtifacts(t *testing.T) {
+	metrics := NewPrometheusMutationMetrics(prometheus.NewRegistry(), 10).(*prometheusMutationMetrics)
+	db := storage.NewFakeDbOrFatal()
+	defer db.Close()
+	store := storage.NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	artifacts := newFakeArtifactStore("mlpipeline/live/model.tgz")
+	artifacts.failing["failing/model.tgz"] = true
+	seedClusterEntries(t, store,
+		clusterEntry{key: "local", clusterID: localClusterID, executionOutput: executionOutputWithArtifacts(t, "mlpipeline/local/model.tgz")},
+		clusterEntry{key: "remote-live", clusterID: "eu-west1", executionOutput: executionOutputWithArtifacts(t, "/live/model.tgz")},
+		clusterEntry{key: "remote-missing", clusterID: "eu-west1", executionOutput: executionOutputWithArtifacts(t, "mlpipeline/live/model.tgz", "mlpipeline/missing/model.tgz")},
+		clusterEntry{key: "remote-failing", clusterID: "eu-west1", executionOutput: executionOutputWithArtifacts(t, "mlpipeline/failing/model.tgz")},
+		clusterEntry{key: "unidentified-missing", clusterID: "", executionOutput: executionOutputWithArtifacts(t, "mlpipeline/missing/model.tgz")},
+		clusterEntry{key: "remote-without-artifacts", clusterID: "eu-west1"},
+		// The remote entry is verified when the cluster has no entry of its own.
+		clusterEntry{key: "preferred-remote-missing", clusterID: "eu-west1", executionOutput: executionOutputWithArtifacts(t, "mlpipeline/missing/model.tgz")},
+	)
+
+	for _, tc := range []struct {
+		policy string
+		key    string
+		want   string
+	}{
+		{CrossClusterShared, "local", localClusterID},
+		{CrossClusterShared, "remote-live", "eu-west1"},
+		{CrossClusterShared, "remote-missing", "miss"},
+		{CrossClusterShared, "remote-failing", "miss"},
+		{CrossClusterShared, "unidentified-missing", "miss"},
+		{CrossClusterShared, "remote-without-artifacts", "eu-west1"},
+		{CrossClusterPreferLocal, "preferred-remote-missing", "miss"},
+	} {
+		config := MutationConfig{ClusterID: localClusterID, CrossCluster: tc.policy, RemoteArtifacts: artifacts}
+		assert.Equal(t, tc.want, lookUpCluster(t, store, newTestLookupCoalescer(util.NewFakeTimeForEpoch()), config, metrics, tc.key), tc.key)
+	}
+
+	assert.NotContains(t, artifacts.checkedObjects(), "mlpipeline/local/model.tgz", "the artifacts of local entries are not verified")
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.remoteVerifications.WithLabelValues(RemoteEntryOutcomeLive)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.remoteVerifications.WithLabelValues(RemoteEntryOutcomeMissing)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.remoteVerifications.WithLabelValues(RemoteEntryOutcomeFailed)))
+}
+
+func TestMutatePodIfCachedWithCrossClusterPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		cached bool
+	}{
+		{CrossClusterShared, true},
+		{CrossClusterLocal, false},
+		{CrossClusterPreferLocal, true},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{ClusterID: localClusterID, CrossCluster: tc.policy}})
+			seedClusterEntries(t, clientManager.CacheStore(), clusterEntry{
+				key:       "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+				clusterID: "eu-west1",
+			})
+
+			patchOperation, err := webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+			assert.Nil(t, err)
+			if tc.cached {
+				require.Equal(t, 3, len(patchOperation))
+				assert.Equal(t, OperationTypeReplace, patchOperation[0].Op)
+			} else {
+				require.Equal(t, 2, len(patchOperation))
+				assert.Equal(t, OperationTypeAdd, patchOperation[0].Op)
+			}
+		})
+	}
+}
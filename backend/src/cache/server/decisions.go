@@ -0,0 +1,217 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+const (
+	DecisionsAPI string = "/debug/decisions"
+	// DefaultDecisionBufferSize is the number of recent decisions kept for DecisionsAPI.
+	DefaultDecisionBufferSize int = 500
+	// MaxDecisionFieldLength bounds the length in bytes of each field of a kept decision, so that
+	// the buffer stays bounded in memory.
+	MaxDecisionFieldLength int = 256
+)
+
+// Decision is a cache decision on an admission, served by DecisionsAPI to find out why a step did
+// not hit the cache.
+type Decision struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	// Pod is the name of the pod, or its generateName when the name is not assigned yet.
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace"`
+	NodeName  string `json:"nodeName,omitempty"`
+	CacheKey  string `json:"cacheKey,omitempty"`
+	Decision  string `json:"decision"`
+	// Reason explains decisions other than hits and misses, e.g. why the pod was skipped.
+	Reason           string  `json:"reason,omitempty"`
+	DurationMs       float64 `json:"durationMs"`
+	LookupDurationMs float64 `json:"lookupDurationMs,omitempty"`
+}
+
+// DecisionFilter selects decisions. Empty fields select all decisions.
+type DecisionFilter struct {
+	Namespace      string
+	CacheKeyPrefix string
+	// Limit bounds the number of decisions returned. Zero means no limit.
+	Limit int
+}
+
+func (f DecisionFilter) matches(decision *Decision) bool {
+	return (f.Namespace == "" || decision.Namespace == f.Namespace) &&
+		strings.HasPrefix(decision.CacheKey, f.CacheKeyPrefix)
+}
+
+// DecisionRecorder keeps the most recent decisions in a ring buffer. A nil recorder keeps nothing.
+type DecisionRecorder struct {
+	mutex     sync.Mutex
+	decisions []Decision
+	// next is the index the next decision is written to, the oldest decision once the buffer is
+	// full.
+	next int
+	full bool
+}
+
+// factory function for a DecisionRecorder keeping the given number of decisions
+func NewDecisionRecorder(size int) *DecisionRecorder {
+	if size <= 0 {
+		size = DefaultDecisionBufferSize
+	}
+	return &DecisionRecorder{decisions: make([]Decision, size)}
+}
+
+// Record keeps the decision with its fields truncated to MaxDecisionFieldLength, replacing the
+// oldest one when the buffer is full.
+func (r *DecisionRecorder) Record(decision Decision) {
+	if r == nil {
+		return
+	}
+	for _, field := range []*string{&decision.RequestID, &decision.Pod, &decision.Namespace,
+		&decision.NodeName, &decision.CacheKey, &decision.Decision, &decision.Reason} {
+		*field = truncate(*field, MaxDecisionFieldLength)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.decisions[r.next] = decision
+	r.next++
+	if r.next == len(r.decisions) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Recent returns the kept decisions matching the filter, the most recent first.
+func (r *DecisionRecorder) Recent(filter DecisionFilter) []Decision {
+	matching := []Decision{}
+	if r == nil {
+		return matching
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.decisions)
+	}
+	for i := 1; i <= count; i++ {
+		decision := &r.decisions[(r.next-i+len(r.decisions))%len(r.decisions)]
+		if !filter.matches(decision) {
+			continue
+		}
+		matching = append(matching, *decision)
+		if len(matching) == filter.Limit {
+			break
+		}
+	}
+	return matching
+}
+
+// DecisionsHandler serves the recent decisions of the recorder as a JSON array, the most recent
+// first. The namespace, key and limit query parameters select the decisions of a namespace, those
+// whose cache key starts with key and at most limit decisions.
+func DecisionsHandler(recorder *DecisionRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		filter := DecisionFilter{
+			Namespace:      query.Get("namespace"),
+			CacheKeyPrefix: query.Get("key"),
+		}
+		if limit := query.Get("limit"); limit != "" {
+			parsed, err := strconv.Atoi(limit)
+			if err != nil || parsed < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", limit), http.StatusBadRequest)
+				return
+			}
+			filter.Limit = parsed
+		}
+		w.Header().Set(ContentType, JsonContentType)
+		if err := json.NewEncoder(w).Encode(recorder.Recent(filter)); err != nil {
+			logger.Warnf("Failed to write the recent decisions: %v", err)
+		}
+	})
+}
+
+// decisionDetails are the details of a decision that are not audited.
+type decisionDetails struct {
+	started        time.Time
+	reason         string
+	lookupDuration time.Duration
+}
+
+type decisionDetailsKey struct{}
+
+// contextWithDecisionDetails returns a context carrying the details of the decision on an
+// admission started now.
+func contextWithDecisionDetails(ctx context.Context) (context.Context, *decisionDetails) {
+	details := &decisionDetails{started: time.Now()}
+	return context.WithValue(ctx, decisionDetailsKey{}, details), details
+}
+
+// decisionDetailsFrom returns the details of the decision on the admission of ctx, or discarded
+// ones when the decision is not recorded.
+func decisionDetailsFrom(ctx context.Context) *decisionDetails {
+	if details, ok := ctx.Value(decisionDetailsKey{}).(*decisionDetails); ok {
+		return details
+	}
+	return &decisionDetails{}
+}
+
+// setDecisionReason explains the decision on the admission of ctx. The reason is redacted like
+// warnings and must not quote cached outputs either.
+func setDecisionReason(ctx context.Context, format string, args ...interface{}) {
+	decisionDetailsFrom(ctx).reason = redactCredentials(fmt.Sprintf(format, args...))
+}
+
+// recordDecision records the decision of the audit event once the admission reached one.
+func (wh *Webhook) recordDecision(event *model.AuditEvent, details *decisionDetails) {
+	if event.Decision == "" || wh.decisions == nil {
+		return
+	}
+	pod := event.PodName
+	if pod == "" {
+		pod = event.PodGenerateName
+	}
+	decision := Decision{
+		Time:       time.Now().UTC(),
+		RequestID:  event.RequestID,
+		Pod:        pod,
+		Namespace:  event.Namespace,
+		NodeName:   event.NodeName,
+		CacheKey:   event.CacheKey,
+		Decision:   event.Decision,
+		Reason:     details.reason,
+		DurationMs: logging.DurationMs(time.Since(details.started)),
+	}
+	if details.lookupDuration > 0 {
+		decision.LookupDurationMs = logging.DurationMs(details.lookupDuration)
+	}
+	wh.decisions.Record(decision)
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// requestIDs returns the request ids of the decisions in order.
+func requestIDs(decisions []Decision) []string {
+	ids := []string{}
+	for _, decision := range decisions {
+		ids = append(ids, decision.RequestID)
+	}
+	return ids
+}
+
+func TestDecisionRecorderWrapsAround(t *testing.T) {
+	recorder := NewDecisionRecorder(3)
+	assert.Equal(t, []Decision{}, recorder.Recent(DecisionFilter{}))
+
+	for i := 1; i <= 2; i++ {
+		recorder.Record(Decision{RequestID: fmt.Sprint(i)})
+	}
+	assert.Equal(t, []string{"2", "1"}, requestIDs(recorder.Recent(DecisionFilter{})))
+
+	for i := 3; i <= 7; i++ {
+		recorder.Record(Decision{RequestID: fmt.Sprint(i)})
+	}
+	assert.Equal(t, []string{"7", "6", "5"}, requestIDs(recorder.Recent(DecisionFilter{})))
+}
+
+func TestDecisionRecorderTruncatesFields(t *testing.T) {
+	recorder := NewDecisionRecorder(1)
+
+	recorder.Record(Decision{Pod: strings.Repeat("p", 1000), Reason: strings.Repeat("é", 1000), Decision: AdmissionOutcomeMiss})
+
+	decision := recorder.Recent(DecisionFilter{})[0]
+	assert.Len(t, decision.Pod, MaxDecisionFieldLength)
+	assert.True(t, strings.HasSuffix(decision.Pod, "..."))
+	assert.LessOrEqual(t, len(decision.Reason), MaxDecisionFieldLength)
+	assert.True(t, strings.HasSuffix(decision.Reason, "é..."))
+	assert.Equal(t, AdmissionOutcomeMiss, decision.Decision)
+}
+
+func TestDecisionRecorderFilters(t *testing.T) {
+	recorder := NewDecisionRecorder(10)
+	recorder.Record(Decision{RequestID: "1", Namespace: "team-a", CacheKey: "abc1"})
+	recorder.Record(Decision{RequestID: "2", Namespace: "team-b", CacheKey: "abc2"})
+	recorder.Record(Decision{RequestID: "3", Namespace: "team-a", CacheKey: "def3"})
+	recorder.Record(Decision{RequestID: "4", Namespace: "team-a"})
+
+	tests := []struct {
+		filter DecisionFilter
+		want   []string
+	}{
+		{filter: DecisionFilter{}, want: []string{"4", "3", "2", "1"}},
+		{filter: DecisionFilter{Namespace: "team-a"}, want: []string{"4", "3", "1"}},
+		{filter: DecisionFilter{CacheKeyPrefix: "abc"}, want: []string{"2", "1"}},
+		{filter: DecisionFilter{Namespace: "team-a", CacheKeyPrefix: "abc"}, want: []string{"1"}},
+		{filter: DecisionFilter{Namespace: "team-a", Limit: 2}, want: []string{"4", "3"}},
+		{filter: DecisionFilter{Namespace: "team-c"}, want: []string{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, requestIDs(recorder.Recent(test.filter)), "%+v", test.filter)
+	}
+}
+
+func TestDecisionRecorderWithConcurrentWriters(t *testing.T) {
+	const writers = 8
+	const decisionsPerWriter = 200
+	recorder := NewDecisionRecorder(50)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < decisionsPerWriter; i++ {
+				recorder.Record(Decision{RequestID: fmt.Sprintf("%d-%03d", w, i), Namespace: fmt.Sprint(w)})
+				if i%20 == 0 {
+					recorder.Recent(DecisionFilter{Namespace: fmt.Sprint(w)})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	recent := recorder.Recent(DecisionFilter{})
+	require.Len(t, recent, 50)
+	// The decisions of each writer are kept in the order they were recorded.
+	for w := 0; w < writers; w++ {
+		ids := requestIDs(recorder.Recent(DecisionFilter{Namespace: fmt.Sprint(w)}))
+		for i := 1; i < len(ids); i++ {
+			assert.Greater(t, ids[i-1], ids[i])
+		}
+	}
+}
+
+func TestNilDecisionRecorderKeepsNothing(t *testing.T) {
+	var recorder *DecisionRecorder
+
+	recorder.Record(Decision{RequestID: "1"})
+
+	assert.Equal(t, []Decision{}, recorder.Recent(DecisionFilter{}))
+}
+
+func TestDecisionsHandler(t *testing.T) {
+	recorder := NewDecisionRecorder(10)
+	recorder.Record(Decision{RequestID: "1", Namespace: "team-a", CacheKey: "abc1", Decision: AdmissionOutcomeHit})
+	recorder.Record(Decision{RequestID: "2", Namespace: "team-b", CacheKey: "abc2", Decision: AdmissionOutcomeMiss})
+	recorder.Record(Decision{RequestID: "3", Namespace: "team-a", CacheKey: "def3", Decision: AdmissionOutcomeSkippedTFX, Reason: "pod is created by TFX pipelines"})
+	handler := DecisionsHandler(recorder)
+
+	tests := []struct {
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{query: "", wantCode: http.StatusOK, want: []string{"3", "2", "1"}},
+		{query: "?namespace=team-a", wantCode: http.StatusOK, want: []string{"3", "1"}},
+		{query: "?key=abc&limit=1", wantCode: http.StatusOK, want: []string{"2"}},
+		{query: "?namespace=team-c", wantCode: http.StatusOK, want: []string{}},
+		{query: "?limit=-1", wantCode: http.StatusBadRequest},
+		{query: "?limit=all", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, DecisionsAPI+test.query, nil))
+
+			require.Equal(t, test.wantCode, rr.Code)
+			if test.wantCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, JsonContentType, rr.Header().Get(ContentType))
+			var decisions []Decision
+			require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &decisions))
+			assert.Equal(t, test.want, requestIDs(decisions))
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, DecisionsAPI, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestAdmissionDecisionsAreRecorded(t *testing.T) {
+	recorder := NewDecisionRecorder(10)
+	webhook := NewWebhook(WebhookConfig{Decisions: recorder})
+
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = `{"name": "recorded","container":{"command":["echo", "recorded"],"image":"python:3.7"}}`
+	key, err := generateCacheKeyFromTemplate(pod.ObjectMeta.Annotations[ArgoWorkflowTemplate])
+	require.Nil(t, err)
+	serveAdmissionReview(t, webhook, GetFakeRequestFromPod(pod), webhook.MutatePodIfCached)
+	serveAdmissionReview(t, webhook, GetFakeRequestFromPod(&corev1.Pod{}), webhook.MutatePodIfCached)
+
+	decisions := recorder.Recent(DecisionFilter{})
+	require.Len(t, decisions, 2)
+	skipped, miss := decisions[0], decisions[1]
+	assert.Equal(t, AdmissionOutcomeSkippedNotKFP, skipped.Decision)
+	assert.Equal(t, "pod is not created by KFP or does not enable cache", skipped.Reason)
+	assert.Zero(t, skipped.LookupDurationMs)
+	assert.Equal(t, AdmissionOutcomeMiss, miss.Decision)
+	assert.Equal(t, string(fakeAdmissionRequest.UID), miss.RequestID)
+	assert.Equal(t, "default", miss.Namespace)
+	assert.Equal(t, "test_node", miss.NodeName)
+	assert.Equal(t, key, miss.CacheKey)
+	assert.Empty(t, miss.Reason)
+	assert.Greater(t, miss.LookupDurationMs, 0.0)
+	assert.GreaterOrEqual(t, miss.DurationMs, miss.LookupDurationMs)
+	assert.False(t, miss.Time.IsZero())
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultDummyImage is the image of the containers running in place of the steps of the pods
+	// served from cache.
+	DefaultDummyImage string = "alpine"
+
+	SpecImagePullSecretsPath string = "/spec/imagePullSecrets"
+)
+
+// DummyContainer is the container running in place of the steps of the pods served from cache,
+// e.g. with an image of a private registry for clusters that cannot pull from Docker Hub.
+type DummyContainer struct {
+	// Image is the image of the container, DefaultDummyImage when empty.
+	Image string
+	// Command replaces the command of the Argo container, which echoes that the outputs are taken
+	// from cache when empty. Tekton steps keep running sh to write their results.
+	Command   []string
+	Resources corev1.ResourceRequirements
+	// ImagePullSecrets are added to those of the pods served from cache to pull Image.
+	ImagePullSecrets []corev1.LocalObjectReference
+}
+
+// NewDummyContainer parses the settings of the dummy container: the command is space separated,
+// the resource requests and limits are comma separated name=quantity lists, e.g.
+// cpu=10m,memory=16Mi, and the image pull secrets a comma separated list of secret names.
+func NewDummyContainer(image string, command string, requests string, limits string, imagePullSecrets string) (DummyContainer, error) {
+	container := DummyContainer{Image: image}
+	if fields := strings.Fields(command); len(fields) != 0 {
+		container.Command = fields
+	}
+	var err error
+	if container.Resources.Requests, err = parseResourceList(requests); err != nil {
+		return DummyContainer{}, fmt.Errorf("invalid dummy container resource requests: %v", err)
+	}
+	if container.Resources.Limits, err = parseResourceList(limits); err != nil {
+		return DummyContainer{}, fmt.Errorf("invalid dummy container resource limits: %v", err)
+	}
+	for _, name := range strings.Split(imagePullSecrets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			container.ImagePullSecrets = append(container.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+	}
+	return container, nil
+}
+
+// parseResourceList parses a comma separated list of name=quantity, nil when empty.
+func parseResourceList(spec string) (corev1.ResourceList, error) {
+	var resources corev1.ResourceList
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q is not a name=quantity pair", item)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity of %s: %v", parts[0], err)
+		}
+		if resources == nil {
+			resources = corev1.ResourceList{}
+		}
+		resources[corev1.ResourceName(strings.TrimSpace(parts[0]))] = quantity
+	}
+	return resources, nil
+}
+
+func (c DummyContainer) image() string {
+	if c.Image == "" {
+		return DefaultDummyImage
+	}
+	return c.Image
+}
+
+// hasResources reports whether the container replaces the resources of the steps.
+func (c DummyContainer) hasResources() bool {
+	return len(c.Resources.Requests) != 0 || len(c.Resources.Limits) != 0
+}
+
+// imagePullSecretsPatches returns the patches adding the image pull secrets to those of the pod.
+func (c DummyContainer) imagePullSecretsPatches(pod *corev1.Pod) []patchOperation {
+	if len(c.ImagePullSecrets) == 0 {
+		return nil
+	}
+	secrets := append([]corev1.LocalObjectReference(nil), pod.Spec.ImagePullSecrets...)
+	for _, secret := range c.ImagePullSecrets {
+		exists := false
+		for _, existing := range pod.Spec.ImagePullSecrets {
+			exists = exists || existing.Name == secret.Name
+		}
+		if !exists {
+			secrets = append(secrets, secret)
+		}
+	}
+	return []patchOperation{{
+		Op:    OperationTypeAdd,
+		Path:  SpecImagePullSecretsPath,
+		Value: secrets,
+	}}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNewDummyContainer(t *testing.T) {
+	container, err := NewDummyContainer("", "", " ", "", " , ")
+	require.Nil(t, err)
+	assert.Equal(t, DummyContainer{}, container)
+	assert.Equal(t, DefaultDummyImage, container.image())
+	assert.False(t, container.hasResources())
+
+	container, err = NewDummyContainer("registry.local/busybox", "sh -c true", "cpu=10m, memory=16Mi", "memory=32Mi", "registry,mirror")
+	require.Nil(t, err)
+	assert.Equal(t, DummyContainer{
+		Image:   "registry.local/busybox",
+		Command: []string{"sh", "-c", "true"},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m"), corev1.ResourceMemory: resource.MustParse("16Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi")},
+		},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+	}, container)
+	assert.Equal(t, "registry.local/busybox", container.image())
+	assert.True(t, container.hasResources())
+
+	_, err = NewDummyContainer("", "", "cpu", "", "")
+	assert.Contains(t, err.Error(), "invalid dummy container resource requests")
+	_, err = NewDummyContainer("", "", "", "memory=lots", "")
+	assert.Contains(t, err.Error(), "invalid dummy container resource limits")
+}
+
+func TestDummyContainerImagePullSecretsPatches(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}}}}
+	assert.Nil(t, DummyContainer{}.imagePullSecretsPatches(pod))
+
+	patches := DummyContainer{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}}.imagePullSecretsPatches(pod)
+	assert.Equal(t, []patchOperation{{
+		Op:    OperationTypeAdd,
+		Path:  SpecImagePullSecretsPath,
+		Value: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+	}}, patches, "the secrets of the pod are kept once")
+	assert.Len(t, pod.Spec.ImagePullSecrets, 1, "the pod is left unchanged")
+}
+
+func TestMutatePodIfCachedWithDummyContainer(t *testing.T) {
+	dummy, err := NewDummyContainer("registry.local/busybox", "true", "cpu=10m", "memory=32Mi", "registry")
+	require.Nil(t, err)
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{DummyContainer: dummy}})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`
+	key, err := generateCacheKey(CacheKeyVersionV1, template)
+	require.Nil(t, err)
+	_, err = clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	byPath := make(map[string]interface{})
+	for _, patch := range patches {
+		byPath[patch.Path] = patch.Value
+	}
+	require.Contains(t, byPath, SpecContainersPath, "the pod hits")
+	assert.Equal(t, []corev1.Container{{
+		Name:      "main",
+		Image:     "registry.local/busybox",
+		Command:   []string{"true"},
+		Resources: dummy.Resources,
+	}}, byPath[SpecContainersPath])
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry"}}, byPath[SpecImagePullSecretsPath])
+}
+
+func TestTektonRestoreOutputsWithDummyContainer(t *testing.T) {
+	dummy, err := NewDummyContainer("registry.local/busybox", "true", "cpu=10m", "", "")
+	require.Nil(t, err)
+	patches, err := tektonPodOrchestrator{}.restoreOutputs(context.Background(), tektonPod("step", "Hello", "abcde"), &model.ExecutionCache{}, `{}`, map[string]string{}, dummy)
+	require.Nil(t, err)
+	containers := patches[0].Value.([]corev1.Container)
+	require.Len(t, containers, 2)
+	for _, container := range containers {
+		assert.Equal(t, "registry.local/busybox", container.Image)
+		assert.Equal(t, dummy.Resources, container.Resources)
+		assert.Contains(t, container.Args, "sh", "the steps keep writing their results")
+	}
+}
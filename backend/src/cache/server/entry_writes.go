@@ -0,0 +1,302 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// DefaultWriteWorkers, DefaultWriteQPS and DefaultWriteBurst bound the cache entries written
+	// at once and per second by the watcher.
+	DefaultWriteWorkers int     = 4
+	DefaultWriteQPS     float64 = 20
+	DefaultWriteBurst   int     = 40
+
+	// DefaultWriteMaxRetries bounds the retries of a failed write, which then drops the entry.
+	DefaultWriteMaxRetries int = 10
+	// DefaultWriteQueueSize bounds the entries waiting to be written, e.g. while the store is down.
+	DefaultWriteQueueSize int = 10000
+
+	// Failed writes are retried with a backoff doubling from writeRetryBaseDelay up to
+	// writeRetryMaxDelay.
+	writeRetryBaseDelay time.Duration = 100 * time.Millisecond
+	writeRetryMaxDelay  time.Duration = time.Minute
+)
+
+// entryWriter writes the cache entries of the pods recorded by the watcher, and labels the pods
+// with the ID of their entry.
+type entryWriter interface {
+	// write reports whether the entry of pod is written or will be. The pods whose entry was not
+	// written are recorded again on the next resync.
+	write(entry *model.ExecutionCache, pod *corev1.Pod) bool
+}
+
+// cacheEntryWriter writes the entries as they come.
+type cacheEntryWriter struct {
+	clientManager ClientManagerInterface
+	patchLimiter  flowcontrol.RateLimiter
+	// time measures the time it took to write the entries once their pod completed.
+	time util.TimeInterface
+	// quotas keeps the namespaces within their quota as their entries are created, nil when the
+	// namespace quotas are not enforced.
+	quotas *NamespaceQuotaEnforcer
+	// clusterID is recorded on the entries, empty when the cluster is not identified.
+	clusterID string
+	// enforceOwner records an entry per owner, see WatcherConfig.EnforceOwner.
+	enforceOwner bool
+	// defaultTTL is the time-to-live of the entries of pods without one, zero for none.
+	defaultTTL time.Duration
+	keys       AnnotationKeys
+	metrics    WatcherMetrics
+}
+
+func (w *cacheEntryWriter) write(entry *model.ExecutionCache, pod *corev1.Pod) bool {
+	written, _, ok := w.create(entry, pod, false)
+	if ok && written != nil {
+		w.label(written, []*corev1.Pod{pod})
+	}
+	return ok
+}
+
+// create creates the entry of pod unless it exists, and reports whether it is created and whether
+// it is written. The entry is nil, and the pods left unlabeled, when the store did not keep it. Retried writes reuse any entry of the cache key, which another replica, or a
+// failed write that went through nonetheless, may have written in the meantime.
+func (w *cacheEntryWriter) create(entry *model.ExecutionCache, pod *corev1.Pod, retried bool) (*model.ExecutionCache, bool, bool) {
+	entry.ClusterID = w.clusterID
+	entry.ExpiresAtInSec = w.keys.entryExpiresAtInSec(pod.ObjectMeta.Annotations, w.defaultTTL, w.time)
+	cacheEntryCreated, created, err := createExecutionCacheIfAbsent(context.Background(), w.clientManager.CacheStore(), entry, retried, w.enforceOwner)
+	if errors.Is(err, storage.ErrExecutionCacheNotCached) {
+		// There is no entry to label the pod with, nor any point in writing it again.
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:        pod.ObjectMeta.Name,
+			logging.FieldNamespace:  pod.ObjectMeta.Namespace,
+			logging.FieldCacheKey:   entry.ExecutionCacheKey,
+			logging.FieldSkipReason: PodSkipReasonNotCached,
+		}).Debug("Cache entry is stale on creation, the pod outputs are not recorded")
+		w.metrics.PodSkipped(PodSkipReasonNotCached)
+		return nil, false, true
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:       pod.ObjectMeta.Name,
+			logging.FieldNamespace: pod.ObjectMeta.Namespace,
+			logging.FieldCacheKey:  entry.ExecutionCacheKey,
+		}).Errorf("Unable to create cache entry: %v", err)
+		w.metrics.StoreWriteFailed()
+		return nil, false, false
+	}
+	if created {
+		sinceCompletion := time.Duration(-1)
+		if completedAt := podCompletedAt(pod); !completedAt.IsZero() {
+			sinceCompletion = w.time.Now().Sub(completedAt)
+		}
+		w.metrics.EntryCreated(sinceCompletion)
+		if w.quotas != nil {
+			w.quotas.enforce(context.Background(), cacheEntryCreated)
+		}
+	} else {
+		w.metrics.DuplicateSkipped()
+	}
+	return cacheEntryCreated, created, true
+}
+
+// label labels the pods with the ID of the written entry, that of the first pod. The other pods
+// have the same cache key and completed while the entry was pending.
+func (w *cacheEntryWriter) label(written *model.ExecutionCache, pods []*corev1.Pod) {
+	k8sCore := w.clientManager.KubernetesCoreClient()
+	for i, pod := range pods {
+		podLogger := logger.WithFields(logrus.Fields{
+			logging.FieldPod:       pod.ObjectMeta.Name,
+			logging.FieldNamespace: pod.ObjectMeta.Namespace,
+			logging.FieldCacheKey:  written.ExecutionCacheKey,
+			logging.FieldCacheID:   written.ID,
+		})
+		if err := patchCacheID(k8sCore, w.patchLimiter, w.keys, pod, written.ID); err != nil {
+			// The entry exists, recording the pod again would only duplicate it.
+			podLogger.Errorf("Unable to patch cache id: %v", err)
+			w.metrics.PatchFailed()
+			continue
+		}
+		if i == 0 {
+			podLogger.WithFields(outputSummary(getValueFromSerializedMap(written.ExecutionOutput, ArgoWorkflowOutputs))).Info("Cache entry recorded")
+		} else {
+			podLogger.Debug("Pod labeled with the cache entry of a pod with the same cache key")
+		}
+	}
+}
+
+// queuedEntryWriter writes the entries from a queue keyed by cache key, so that the pods
+// completing with the same key while its entry is pending, e.g. those of a fan-out over identical
+// parameters, are recorded by a single write. Failed writes are retried with a backoff up to
+// maxRetries times, then the entry is dropped and its pods are left unlabeled. At most maxPending
+// entries are pending, the pods of other cache keys overflow the queue and are recorded again on
+// the next resync.
+type queuedEntryWriter struct {
+	writer       *cacheEntryWriter
+	writeLimiter flowcontrol.RateLimiter
+	queue        workqueue.RateLimitingInterface
+	maxRetries   int
+	maxPending   int
+
+	mutex sync.Mutex
+	// pending holds the entries queued, being written or waiting to be retried, by cache key.
+	pending map[string]*pendingEntry
+}
+
+// pendingEntry is the entry of the first pod of a cache key, and the pods of the same key to label
+// with its ID.
+type pendingEntry struct {
+	entry model.ExecutionCache
+	pods  []*corev1.Pod
+}
+
+func newQueuedEntryWriter(writer *cacheEntryWriter, config WatcherConfig) *queuedEntryWriter {
+	maxRetries := config.WriteMaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultWriteMaxRetries
+	}
+	maxPending := config.WriteQueueSize
+	if maxPending <= 0 {
+		maxPending = DefaultWriteQueueSize
+	}
+	return &queuedEntryWriter{
+		writer:       writer,
+		writeLimiter: newTokenBucketLimiter(config.WriteQPS, config.WriteBurst),
+		queue:        workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(writeRetryBaseDelay, writeRetryMaxDelay)),
+		maxRetries:   maxRetries,
+		maxPending:   maxPending,
+		pending:      map[string]*pendingEntry{},
+	}
+}
+
+// write queues the entry of pod, or adds pod to the pending entry of its cache key. It reports
+// false when the queue is full.
+func (w *queuedEntryWriter) write(entry *model.ExecutionCache, pod *corev1.Pod) bool {
+	key := entry.ExecutionCacheKey
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if pending, ok := w.pending[key]; ok {
+		pending.pods = append(pending.pods, pod)
+		w.writer.metrics.WriteCollapsed()
+		return true
+	}
+	if len(w.pending) >= w.maxPending {
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:       pod.ObjectMeta.Name,
+			logging.FieldNamespace: pod.ObjectMeta.Namespace,
+			logging.FieldCacheKey:  key,
+		}).Warnf("Cache entry write queue is full with %d entries, the pod is recorded again on the next resync", len(w.pending))
+		w.writer.metrics.WriteQueueOverflowed()
+		return false
+	}
+	w.pending[key] = &pendingEntry{entry: *entry, pods: []*corev1.Pod{pod}}
+	w.writer.metrics.AddPendingWrites(1)
+	w.queue.Add(key)
+	return true
+}
+
+// run writes the queued entries with workers goroutines until ctx is done. The entries being
+// written when ctx is done are still written, the others are left pending.
+func (w *queuedEntryWriter) run(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	go func() {
+		<-ctx.Done()
+		w.queue.ShutDown()
+	}()
+	var running sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for w.writeNext(ctx) {
+			}
+		}()
+	}
+	running.Wait()
+}
+
+// dropPending drops the entries left pending once the writer and the recorders stopped, since
+// their pods are listed again on restart.
+func (w *queuedEntryWriter) dropPending() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writer.metrics.AddPendingWrites(-len(w.pending))
+	w.pending = map[string]*pendingEntry{}
+}
+
+// writeNext writes the next entry of the queue. It reports false once the queue is shut down.
+func (w *queuedEntryWriter) writeNext(ctx context.Context) bool {
+	key, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(key)
+	if ctx.Err() != nil {
+		return true
+	}
+	w.mutex.Lock()
+	pending := w.pending[key.(string)]
+	first := pending.pods[0]
+	w.mutex.Unlock()
+
+	w.writeLimiter.Accept()
+	retries := w.queue.NumRequeues(key)
+	written, _, ok := w.writer.create(&pending.entry, first, retries > 0)
+	if !ok && retries < w.maxRetries {
+		w.queue.AddRateLimited(key)
+		return true
+	}
+	w.queue.Forget(key)
+	// The pods added while the entry was written are labeled with its ID as well.
+	pods := w.removePending(key.(string))
+	if !ok {
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:       first.ObjectMeta.Name,
+			logging.FieldNamespace: first.ObjectMeta.Namespace,
+			logging.FieldCacheKey:  pending.entry.ExecutionCacheKey,
+		}).Errorf("Dropping the cache entry of %d pods after %d failed writes, it is only recorded if backfilled", len(pods), retries+1)
+		w.writer.metrics.WriteDropped()
+		return true
+	}
+	if written != nil {
+		w.writer.label(written, pods)
+	}
+	return true
+}
+
+// removePending removes the pending entry of key and returns its pods.
+func (w *queuedEntryWriter) removePending(key string) []*corev1.Pod {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	pods := w.pending[key].pods
+	delete(w.pending, key)
+	w.writer.metrics.AddPendingWrites(-1)
+	return pods
+}
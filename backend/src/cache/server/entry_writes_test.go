@@ -0,0 +1,347 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// creationCountingExecutionCacheStore counts the entries it is asked to create.
+type creationCountingExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	creates int32
+}
+
+func (s *creationCountingExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	atomic.AddInt32(&s.creates, 1)
+	return s.ExecutionCacheStoreInterface.CreateExecutionCache(ctx, executionCache)
+}
+
+// flakyExecutionCacheStore fails the first failures entries it is asked to create, after writing
+// them when wentThrough is set, like a connection lost before the reply.
+type flakyExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	failures    int32
+	wentThrough bool
+}
+
+func (s *flakyExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	if atomic.AddInt32(&s.failures, -1) < 0 {
+		return s.ExecutionCacheStoreInterface.CreateExecutionCache(ctx, executionCache)
+	}
+	if s.wentThrough {
+		if _, err := s.ExecutionCacheStoreInterface.CreateExecutionCache(ctx, executionCache); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("connection reset")
+}
+
+// fanOutPod returns the completed pod of a fan-out step over identical parameters. The outputs of
+// each pod name its own artifacts.
+func fanOutPod(i int) *corev1.Pod {
+	pod := completedPod(fmt.Sprintf("fan-out-%d", i), time.Minute)
+	pod.ObjectMeta.Annotations[podKeys.ExecutionKey] = "fan-out-key"
+	pod.ObjectMeta.Annotations[ArgoWorkflowOutputs] = fmt.Sprintf(`{"artifacts":[{"name":"model","s3":{"key":"artifacts/fan-out-%d/model.tgz"}}]}`, i)
+	return pod
+}
+
+// startWriting runs the writer until the returned function is called, which may be called again.
+func startWriting(writer *queuedEntryWriter, workers int) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.run(ctx, workers)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+		writer.dropPending()
+	}
+}
+
+func newTestQueuedEntryWriter(clientManager ClientManagerInterface, metrics WatcherMetrics) *queuedEntryWriter {
+	return newQueuedEntryWriter(&cacheEntryWriter{
+		clientManager: clientManager,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		keys:          podKeys,
+		metrics:       metrics,
+	}, WatcherConfig{})
+}
+
+func TestQueuedEntryWriterCollapsesPendingWritesOfTheSameKey(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &creationCountingExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore}
+	clientManager.cacheStore = store
+	clientset := fake.NewSimpleClientset()
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+
+	// The fan-out completes at once.
+	const steps = 100
+	for i := 0; i < steps; i++ {
+		pod, err := clientset.CoreV1().Pods(watchedNamespace).Create(fanOutPod(i))
+		require.Nil(t, err)
+		require.True(t, recordPodOutput(pod, watchedClientManager, writer, podKeys, metrics))
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.pendingWrites))
+	stop := startWriting(writer, 4)
+	defer stop()
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.pendingWrites) == 0 }, 5*time.Second, 10*time.Millisecond)
+	// The pods are labeled once the writer stops.
+	stop()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.creates))
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "fan-out-key"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.createdEntries))
+	assert.Equal(t, float64(steps-1), testutil.ToFloat64(metrics.collapsedWrites))
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "fan-out-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Contains(t, entry.ExecutionOutput, "artifacts/fan-out-0/model.tgz", "the first completion wins")
+	pods, err := clientset.CoreV1().Pods(watchedNamespace).List(metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Len(t, pods.Items, steps)
+	for _, pod := range pods.Items {
+		assert.Equal(t, fmt.Sprint(entry.ID), pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey], pod.ObjectMeta.Name)
+	}
+}
+
+func TestQueuedEntryWriterRetriesFailedWrites(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: errors.New("connection refused")}
+	clientManager.cacheStore = store
+	clientset := fake.NewSimpleClientset(fanOutPod(0), fanOutPod(1))
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+	stop := startWriting(writer, 1)
+	defer stop()
+
+	require.True(t, recordPodOutput(fanOutPod(0), watchedClientManager, writer, podKeys, metrics), "the pod is done with once queued")
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.storeWriteErrors) >= 1 }, 5*time.Second, 10*time.Millisecond)
+	// Pods of the same key completing while the write is retried are collapsed into it.
+	require.True(t, recordPodOutput(fanOutPod(1), watchedClientManager, writer, podKeys, metrics))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.collapsedWrites))
+	store.setCreateErr(nil)
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.pendingWrites) == 0 }, 5*time.Second, 10*time.Millisecond)
+	// The pods are labeled once the writer stops.
+	stop()
+
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "fan-out-key"))
+	for _, name := range []string{"fan-out-0", "fan-out-1"} {
+		pod, err := clientset.CoreV1().Pods(watchedNamespace).Get(name, metav1.GetOptions{})
+		require.Nil(t, err)
+		assert.NotEmpty(t, pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey], name)
+	}
+}
+
+func TestQueuedEntryWriterLeavesPodsOfEntriesNotCachedUnlabeled(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	// Like Redis given an entry of pod with a max cache staleness of P0D.
+	store := &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: storage.ErrExecutionCacheNotCached}
+	clientManager.cacheStore = store
+	clientset := fake.NewSimpleClientset(fanOutPod(0))
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+	stop := startWriting(writer, 1)
+	defer stop()
+
+	require.True(t, recordPodOutput(fanOutPod(0), watchedClientManager, writer, podKeys, metrics))
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.pendingWrites) == 0 }, 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonNotCached)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.storeWriteErrors), "the write is not retried")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.createdEntries))
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("fan-out-0", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Empty(t, pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+func TestQueuedEntryWriterDropsPendingWritesOnShutdown(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	writer := newTestQueuedEntryWriter(clientManager, metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.True(t, recordPodOutput(fanOutPod(0), clientManager, writer, podKeys, metrics))
+	writer.run(ctx, 1)
+	writer.dropPending()
+
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "fan-out-key"), "the pod is recorded on restart")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.pendingWrites))
+}
+
+func TestQueuedEntryWriterWritesOnceAfterFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		wentThrough bool
+	}{
+		{"failed writes", false},
+		{"failed writes that went through", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			const failures = 3
+			clientManager.cacheStore = &flakyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, failures: failures, wentThrough: tc.wentThrough}
+			clientset := fake.NewSimpleClientset(fanOutPod(0))
+			watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+			writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+			stop := startWriting(writer, 1)
+			defer stop()
+
+			require.True(t, recordPodOutput(fanOutPod(0), watchedClientManager, writer, podKeys, metrics))
+			require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.pendingWrites) == 0 }, 5*time.Second, 10*time.Millisecond)
+			stop()
+
+			assert.Equal(t, 1, countCacheEntries(t, clientManager, "fan-out-key"))
+			if tc.wentThrough {
+				// The first write went through and every retry found its entry.
+				assert.Equal(t, float64(1), testutil.ToFloat64(metrics.storeWriteErrors))
+				assert.Equal(t, float64(1), testutil.ToFloat64(metrics.duplicateEntries))
+			} else {
+				assert.Equal(t, float64(failures), testutil.ToFloat64(metrics.storeWriteErrors))
+				assert.Equal(t, float64(1), testutil.ToFloat64(metrics.createdEntries))
+			}
+			assert.Equal(t, float64(0), testutil.ToFloat64(metrics.droppedWrites))
+			pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("fan-out-0", metav1.GetOptions{})
+			require.Nil(t, err)
+			assert.NotEmpty(t, pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+		})
+	}
+}
+
+func TestQueuedEntryWriterRetryReusesTheEntryOfAnotherReplica(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: errors.New("connection refused")}
+	clientManager.cacheStore = store
+	clientset := fake.NewSimpleClientset(fanOutPod(0))
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, noopWatcherMetrics{})
+	require.True(t, recordPodOutput(fanOutPod(0), watchedClientManager, writer, podKeys, noopWatcherMetrics{}))
+	ctx := context.Background()
+	require.True(t, writer.writeNext(ctx))
+
+	// Another replica records a pod of the same key with outputs of its own meanwhile.
+	other, err := store.ExecutionCacheStoreInterface.CreateExecutionCache(ctx, &model.ExecutionCache{
+		ExecutionCacheKey: "fan-out-key",
+		ExecutionOutput:   "outputs of the other replica",
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+	store.setCreateErr(nil)
+	require.True(t, writer.writeNext(ctx))
+
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "fan-out-key"))
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("fan-out-0", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprint(other.ID), pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+func TestQueuedEntryWriterDropsEntriesAfterMaxRetries(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.cacheStore = &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: errors.New("connection refused")}
+	clientset := fake.NewSimpleClientset(fanOutPod(0))
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+	writer.maxRetries = 2
+	stop := startWriting(writer, 1)
+	defer stop()
+
+	require.True(t, recordPodOutput(fanOutPod(0), watchedClientManager, writer, podKeys, metrics))
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.droppedWrites) == 1 }, 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.storeWriteErrors), "the first write and its 2 retries")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.pendingWrites))
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "fan-out-key"))
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("fan-out-0", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Empty(t, pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+func TestQueuedEntryWriterOverflowsWhenFull(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset()
+	watchedClientManager := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := newTestQueuedEntryWriter(watchedClientManager, metrics)
+	assert.Equal(t, DefaultWriteQueueSize, writer.maxPending)
+	writer.maxPending = 2
+	podOfKey := func(i int, key string) *corev1.Pod {
+		pod := fanOutPod(i)
+		pod.ObjectMeta.Annotations[podKeys.ExecutionKey] = key
+		created, err := clientset.CoreV1().Pods(watchedNamespace).Create(pod)
+		require.Nil(t, err)
+		return created
+	}
+
+	// The store is slow to come up, the entries wait to be written.
+	assert.True(t, recordPodOutput(podOfKey(0, "key-a"), watchedClientManager, writer, podKeys, metrics))
+	assert.True(t, recordPodOutput(podOfKey(1, "key-b"), watchedClientManager, writer, podKeys, metrics))
+	overflowing := podOfKey(2, "key-c")
+	assert.False(t, recordPodOutput(overflowing, watchedClientManager, writer, podKeys, metrics), "the queue is full")
+	assert.True(t, recordPodOutput(podOfKey(3, "key-a"), watchedClientManager, writer, podKeys, metrics), "pods of pending keys are still collapsed")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.queueOverflows))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.pendingWrites))
+
+	stop := startWriting(writer, 1)
+	defer stop()
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.pendingWrites) == 0 }, 5*time.Second, 10*time.Millisecond)
+	// The overflowing pod is recorded on the next resync.
+	assert.True(t, recordPodOutput(overflowing, watchedClientManager, writer, podKeys, metrics))
+	require.Eventually(t, func() bool { return countCacheEntries(t, clientManager, "key-c") == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.queueOverflows))
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// Evaluation is what the webhook decides on the admission of a pod.
+type Evaluation struct {
+	CacheKey string   `json:"cacheKey,omitempty"`
+	Decision string   `json:"decision"`
+	Reason   string   `json:"reason,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// Patch is the JSON patch of the pod, null when it is admitted unchanged.
+	Patch json.RawMessage `json:"patch"`
+	// Rejection is the message the admission is rejected with under the closed fail policy.
+	Rejection string `json:"rejection,omitempty"`
+}
+
+// EvaluateAdmission decides on the admission of the request the way the webhook does, e.g. to try
+// changes to the mutation without a cluster. Unlike admissions of the webhook, the evaluation is
+// neither limited nor audited or recorded among the recent decisions.
+func (wh *Webhook) EvaluateAdmission(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) Evaluation {
+	ctx = logging.ContextWithFields(ctx, logrus.Fields{
+		logging.FieldRequestID: requestID(req),
+		logging.FieldNamespace: req.Namespace,
+	})
+	ctx, warnings := contextWithAdmissionWarnings(ctx)
+	auditEvent := &model.AuditEvent{}
+	ctx = contextWithAuditEvent(ctx, auditEvent)
+	ctx, details := contextWithDecisionDetails(ctx)
+
+	patches, err := wh.MutatePodIfCached(ctx, req, clientMgr)
+	evaluation := Evaluation{
+		CacheKey: auditEvent.CacheKey,
+		Decision: auditEvent.Decision,
+		Reason:   details.reason,
+		Warnings: warnings.list(),
+	}
+	if err == nil {
+		evaluation.Patch, err = json.Marshal(patches)
+		if err != nil {
+			err = fmt.Errorf("could not marshal JSON patch: %v", err)
+		}
+	}
+	if err != nil {
+		// As answered by failedAdmissionResponse.
+		evaluation.Patch = nil
+		if wh.rejectsFailedAdmission(req.Object.Raw) {
+			evaluation.Rejection = rejectionError(err).Error()
+		} else {
+			evaluation.Warnings = append(evaluation.Warnings, formatWarning(err.Error()))
+		}
+	}
+	return evaluation
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateAdmission(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+
+	evaluation := NewWebhook(WebhookConfig{}).EvaluateAdmission(context.Background(), GetFakeRequestFromPod(fakePod), clientManager)
+
+	assert.Equal(t, AdmissionOutcomeMiss, evaluation.Decision)
+	assert.Equal(t, "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0", evaluation.CacheKey)
+	assert.Empty(t, evaluation.Rejection)
+	var patches []patchOperation
+	require.Nil(t, json.Unmarshal(evaluation.Patch, &patches))
+	require.Len(t, patches, 2)
+	assert.Equal(t, evaluation.CacheKey, patches[0].Value.(map[string]interface{})[podKeys.ExecutionKey])
+}
+
+func TestEvaluateAdmissionFollowsTheFailPolicy(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = "{"
+
+	open := NewWebhook(WebhookConfig{}).EvaluateAdmission(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+	closed := NewWebhook(WebhookConfig{Mutation: MutationConfig{FailPolicy: FailPolicyClosed}}).EvaluateAdmission(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+
+	assert.Equal(t, AdmissionOutcomeError, open.Decision)
+	assert.Equal(t, "null", string(open.Patch))
+	require.Len(t, open.Warnings, 1)
+	assert.Contains(t, open.Warnings[0], "execution cache key could not be generated")
+	assert.Empty(t, open.Rejection)
+	assert.Equal(t, AdmissionOutcomeError, closed.Decision)
+	assert.Nil(t, closed.Patch)
+	assert.Equal(t, "pipelines.kubeflow.org cache webhook rejected the pod under the closed fail policy: could not generate the cache key of the pod: unexpected end of JSON input", closed.Rejection)
+	assert.Equal(t, "could not generate the cache key: unexpected end of JSON input", closed.Reason)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Fail policies decide what happens to a pod the webhook fails on. Under the open policy the pod is
+// admitted unchanged and runs uncached, under the closed policy it is rejected, so that a failing
+// cache never silently re-runs expensive steps.
+const (
+	FailPolicyOpen   string = "open"
+	FailPolicyClosed string = "closed"
+)
+
+// IsValidFailPolicy reports whether policy is FailPolicyOpen or FailPolicyClosed.
+func IsValidFailPolicy(policy string) bool {
+	return policy == FailPolicyOpen || policy == FailPolicyClosed
+}
+
+// failedAdmissionResponse answers an admission the webhook failed on according to the fail policy.
+// object is the raw object under admission. Objects that are clearly not cache enabled KFP pods are
+// always admitted, since the cache has no say over them.
+func (wh *Webhook) failedAdmissionResponse(ctx context.Context, uid types.UID, object []byte, err error) []byte {
+	if wh.rejectsFailedAdmission(object) {
+		logging.WithContext(logger, ctx).Errorf("Rejecting admission under the closed fail policy: %v", err)
+		return errorResponse(uid, rejectionError(err))
+	}
+	logging.WithContext(logger, ctx).Warnf("Allowing admission without cache: %v", err)
+	return warningResponse(uid, err.Error())
+}
+
+// rejectsFailedAdmission reports whether the admission of the raw object is rejected when the
+// webhook fails on it.
+func (wh *Webhook) rejectsFailedAdmission(object []byte) bool {
+	return wh.mutationConfig().failsClosed() && !wh.keys.isClearlyNotCacheEnabled(object)
+}
+
+// rejectionError is the error a failed admission is rejected with.
+func rejectionError(err error) error {
+	return fmt.Errorf("pipelines.kubeflow.org cache webhook rejected the pod under the closed fail policy: %v", err)
+}
+
+// isClearlyNotCacheEnabled reports whether the raw object is readable enough to tell that it lacks
+// the cache enabled label of KFP pods, even when it cannot be deserialized as a pod.
+func (k AnnotationKeys) isClearlyNotCacheEnabled(object []byte) bool {
+	var partialObject struct {
+		Metadata struct {
+			Labels map[string]interface{} `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &partialObject); err != nil {
+		return false
+	}
+	return partialObject.Metadata.Labels[k.CacheEnabledLabelKey] != KFPCacheEnabledLabelValue
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	undecodableKFPPod    string = `{"metadata":{"labels":{"pipelines.kubeflow.org/cache_enabled":"true"}},"spec":{"containers":"main"}}`
+	undecodableNonKFPPod string = `{"metadata":{"labels":{"app":"web"}},"spec":{"containers":"main"}}`
+)
+
+func reviewBody(t *testing.T, request *v1beta1.AdmissionRequest) []byte {
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: request})
+	require.Nil(t, err)
+	return body
+}
+
+func requestWithRawPod(raw string) *v1beta1.AdmissionRequest {
+	request := fakeAdmissionRequest
+	request.Object.Raw = []byte(raw)
+	return &request
+}
+
+func TestFailPolicy(t *testing.T) {
+	brokenTemplatePod := fakePod.DeepCopy()
+	brokenTemplatePod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = "{"
+
+	tests := []struct {
+		name  string
+		body  func(t *testing.T) []byte
+		store storage.ExecutionCacheStoreInterface
+		// rejection is the message expected under the closed policy, empty when the admission is
+		// allowed regardless of the policy.
+		rejection string
+	}{
+		{
+			name:      "unparseable review",
+			body:      func(t *testing.T) []byte { return []byte("invalid") },
+			rejection: "could not deserialize request",
+		},
+		{
+			name: "undecodable review of a KFP pod",
+			body: func(t *testing.T) []byte {
+				return []byte(`{"kind":1,"request":{"uid":"test-12345","object":` + undecodableKFPPod + `}}`)
+			},
+			rejection: "could not deserialize request",
+		},
+		{
+			name: "undecodable review of another object",
+			body: func(t *testing.T) []byte {
+				return []byte(`{"kind":1,"request":{"uid":"test-12345","object":` + undecodableNonKFPPod + `}}`)
+			},
+		},
+		{
+			name:      "undecodable KFP pod",
+			body:      func(t *testing.T) []byte { return reviewBody(t, requestWithRawPod(undecodableKFPPod)) },
+			rejection: "could not deserialize pod object",
+		},
+		{
+			name: "undecodable other pod",
+			body: func(t *testing.T) []byte { return reviewBody(t, requestWithRawPod(undecodableNonKFPPod)) },
+		},
+		{
+			name:      "cache key generation failure",
+			body:      func(t *testing.T) []byte { return reviewBody(t, GetFakeRequestFromPod(brokenTemplatePod)) },
+			rejection: "could not generate the cache key of the pod",
+		},
+		{
+			name:      "cache lookup failure",
+			body:      func(t *testing.T) []byte { return reviewBody(t, &fakeAdmissionRequest) },
+			store:     &togglingExecutionCacheStore{failing: 1},
+			rejection: "cache lookup failed: connection refused",
+		},
+		{
+			name:      "cache lookup past the deadline",
+			body:      func(t *testing.T) []byte { return reviewBody(t, &fakeAdmissionRequest) },
+			store:     &slowExecutionCacheStore{delay: time.Second, started: make(chan struct{}, 1)},
+			rejection: "cache lookup did not finish within the admission deadline of 50ms",
+		},
+	}
+	for _, policy := range []string{FailPolicyOpen, FailPolicyClosed} {
+		for _, test := range tests {
+			t.Run(policy+"/"+test.name, func(t *testing.T) {
+				webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{FailPolicy: policy, AdmissionDeadline: 50 * time.Millisecond}})
+				clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+				defer clientManager.Close()
+				if test.store != nil {
+					clientManager.cacheStore = test.store
+				}
+				req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(test.body(t)))
+				req.Header.Set(ContentType, JsonContentType)
+				rr := httptest.NewRecorder()
+
+				webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+
+				require.Equal(t, http.StatusOK, rr.Code)
+				response := decodeWarningResponse(t, rr.Body.Bytes())
+				if policy == FailPolicyClosed && test.rejection != "" {
+					assert.False(t, response.Allowed)
+					require.NotNil(t, response.Result)
+					assert.Contains(t, response.Result.Message, "rejected the pod under the closed fail policy")
+					assert.Contains(t, response.Result.Message, test.rejection)
+					assert.Nil(t, response.Patch)
+				} else {
+					assert.True(t, response.Allowed)
+				}
+				if test.name != "unparseable review" {
+					assert.Equal(t, types.UID("test-12345"), response.UID)
+				}
+			})
+		}
+	}
+}
+
+func TestFailPolicyOpenAdmitsUndecodablePodsWithWarning(t *testing.T) {
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: requestWithRawPod(undecodableKFPPod)})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+	rr := httptest.NewRecorder()
+
+	webhook := NewWebhook(WebhookConfig{})
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, fakeClientManager).ServeHTTP(rr, req)
+
+	response := decodeWarningResponse(t, rr.Body.Bytes())
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+	require.Len(t, response.Warnings, 1)
+	assert.Contains(t, response.Warnings[0], "could not deserialize pod object")
+}
+
+func TestIsClearlyNotCacheEnabled(t *testing.T) {
+	assert.True(t, podKeys.isClearlyNotCacheEnabled([]byte(undecodableNonKFPPod)))
+	assert.True(t, podKeys.isClearlyNotCacheEnabled([]byte(`{"kind":"ConfigMap"}`)))
+	assert.False(t, podKeys.isClearlyNotCacheEnabled([]byte(undecodableKFPPod)))
+	assert.False(t, podKeys.isClearlyNotCacheEnabled([]byte(`{"metadata":{"labels":"broken"}}`)))
+	assert.False(t, podKeys.isClearlyNotCacheEnabled(nil))
+}
+
+func TestIsValidFailPolicy(t *testing.T) {
+	assert.True(t, IsValidFailPolicy(FailPolicyOpen))
+	assert.True(t, IsValidFailPolicy(FailPolicyClosed))
+	assert.False(t, IsValidFailPolicy(""))
+	assert.False(t, IsValidFailPolicy("ignore"))
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HealthzAPI string = "/healthz"
+	ReadyzAPI  string = "/readyz"
+
+	HealthStatusOK          string = "ok"
+	HealthStatusDegraded    string = "degraded"
+	HealthStatusUnavailable string = "unavailable"
+)
+
+// DependencyCheck probes one dependency of the webhook for /readyz.
+type DependencyCheck struct {
+	Name string
+	// Check must return once its context is done. It is given Timeout to complete.
+	Check   func(ctx context.Context) error
+	Timeout time.Duration
+	// Critical dependencies make the webhook unready when failing. Failing non-critical ones only
+	// degrade it, since admissions still succeed without them.
+	Critical bool
+	// Detail optionally adds a line of state to the report, e.g. the Redis circuit state.
+	Detail func() string
+}
+
+// DependencyStatus is the outcome of a DependencyCheck as reported by /readyz.
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// ReadinessReport is the body of /readyz.
+type ReadinessReport struct {
+	Status       string             `json:"status"`
+	ShuttingDown bool               `json:"shuttingDown,omitempty"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// HealthzHandler reports the process as alive unless it is shutting down.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsShuttingDown() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(HealthStatusOK))
+	})
+}
+
+// ReadyzHandler runs the dependency checks in parallel and answers 200 unless a critical check
+// failed or the webhook is shutting down. Degraded readiness is reported in the body only.
+func ReadyzHandler(checks []DependencyCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := checkReadiness(r.Context(), checks)
+		b, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(ContentType, JsonContentType)
+		if report.Status == HealthStatusUnavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(b)
+	})
+}
+
+func checkReadiness(ctx context.Context, checks []DependencyCheck) ReadinessReport {
+	report := ReadinessReport{
+		Status:       HealthStatusOK,
+		ShuttingDown: IsShuttingDown(),
+		Dependencies: make([]DependencyStatus, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Dependencies[i] = runDependencyCheck(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, dependency := range report.Dependencies {
+		if dependency.Status == HealthStatusOK {
+			continue
+		}
+		logger.Warnf("Readiness check %s failed: %s", dependency.Name, dependency.Error)
+		if checks[i].Critical {
+			report.Status = HealthStatusUnavailable
+		} else if report.Status == HealthStatusOK {
+			report.Status = HealthStatusDegraded
+		}
+	}
+	if report.ShuttingDown {
+		report.Status = HealthStatusUnavailable
+	}
+	return report
+}
+
+func runDependencyCheck(ctx context.Context, check DependencyCheck) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := check.Check(ctx)
+	status := DependencyStatus{
+		Name:      check.Name,
+		Status:    HealthStatusOK,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		status.Status = HealthStatusUnavailable
+		status.Error = err.Error()
+	}
+	if check.Detail != nil {
+		status.Detail = check.Detail()
+	}
+	return status
+}
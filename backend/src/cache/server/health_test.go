@@ -0,0 +1,139 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeDependencyCheck(name string, critical bool, err error) DependencyCheck {
+	return DependencyCheck{
+		Name:     name,
+		Check:    func(ctx context.Context) error { return err },
+		Timeout:  time.Second,
+		Critical: critical,
+	}
+}
+
+func getReadiness(t *testing.T, checks []DependencyCheck) (int, ReadinessReport) {
+	rr := httptest.NewRecorder()
+	ReadyzHandler(checks).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, ReadyzAPI, nil))
+	var report ReadinessReport
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	return rr.Code, report
+}
+
+func TestReadyzReportsEachDependency(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	redisErr := errors.New("i/o timeout")
+	tests := []struct {
+		name           string
+		checks         []DependencyCheck
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			"all healthy",
+			[]DependencyCheck{fakeDependencyCheck("database", true, nil), fakeDependencyCheck("redis", false, nil)},
+			http.StatusOK, HealthStatusOK,
+		},
+		{
+			"database down",
+			[]DependencyCheck{fakeDependencyCheck("database", true, dbErr), fakeDependencyCheck("redis", false, nil)},
+			http.StatusServiceUnavailable, HealthStatusUnavailable,
+		},
+		{
+			"optional redis down",
+			[]DependencyCheck{fakeDependencyCheck("database", true, nil), fakeDependencyCheck("redis", false, redisErr)},
+			http.StatusOK, HealthStatusDegraded,
+		},
+		{
+			"redis store down",
+			[]DependencyCheck{fakeDependencyCheck("redis", true, redisErr)},
+			http.StatusServiceUnavailable, HealthStatusUnavailable,
+		},
+		{
+			"both down",
+			[]DependencyCheck{fakeDependencyCheck("database", true, dbErr), fakeDependencyCheck("redis", false, redisErr)},
+			http.StatusServiceUnavailable, HealthStatusUnavailable,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, report := getReadiness(t, tc.checks)
+			assert.Equal(t, tc.expectedCode, code)
+			assert.Equal(t, tc.expectedStatus, report.Status)
+			require.Len(t, report.Dependencies, len(tc.checks))
+			for i, check := range tc.checks {
+				dependency := report.Dependencies[i]
+				assert.Equal(t, check.Name, dependency.Name)
+				if err := check.Check(context.Background()); err != nil {
+					assert.Equal(t, HealthStatusUnavailable, dependency.Status)
+					assert.Equal(t, err.Error(), dependency.Error)
+				} else {
+					assert.Equal(t, HealthStatusOK, dependency.Status)
+					assert.Empty(t, dependency.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyzTimesOutEachCheck(t *testing.T) {
+	hung := DependencyCheck{
+		Name: "redis",
+		Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		Timeout: 50 * time.Millisecond,
+		Detail:  func() string { return "circuit open" },
+	}
+
+	start := time.Now()
+	code, report := getReadiness(t, []DependencyCheck{fakeDependencyCheck("database", true, nil), hung})
+	assert.True(t, time.Since(start) < time.Second, "readiness took %v", time.Since(start))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthStatusDegraded, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Dependencies[1].Error)
+	assert.True(t, report.Dependencies[1].LatencyMs >= 50)
+	assert.Equal(t, "circuit open", report.Dependencies[1].Detail)
+}
+
+func TestHealthProbesFailWhileShuttingDown(t *testing.T) {
+	rr := httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, HealthzAPI, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	atomic.StoreInt32(&shuttingDown, 1)
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	rr = httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, HealthzAPI, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	code, report := getReadiness(t, []DependencyCheck{fakeDependencyCheck("database", true, nil)})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthStatusUnavailable, report.Status)
+	assert.True(t, report.ShuttingDown)
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// HitSchedulingKeep keeps the scheduling constraints of the pods served from cache.
+	HitSchedulingKeep string = "keep"
+	// HitSchedulingStrip removes the tolerations, node selector, affinity and runtime class of the
+	// pods served from cache, so that their dummy containers are not scheduled on the nodes, e.g.
+	// with GPUs, their steps asked for.
+	HitSchedulingStrip string = "strip"
+
+	SpecTolerationsPath      string = "/spec/tolerations"
+	SpecNodeSelectorPath     string = "/spec/nodeSelector"
+	SpecAffinityPath         string = "/spec/affinity"
+	SpecRuntimeClassNamePath string = "/spec/runtimeClassName"
+)
+
+// IsValidHitScheduling reports whether the mode is HitSchedulingKeep or HitSchedulingStrip.
+func IsValidHitScheduling(mode string) bool {
+	return mode == HitSchedulingKeep || mode == HitSchedulingStrip
+}
+
+// HitScheduling is how the pods served from cache are scheduled. The security contexts of the pods
+// are always kept.
+type HitScheduling struct {
+	// Strip removes the scheduling constraints of the pods.
+	Strip bool
+	// NodeSelector replaces the node selector of the pods when not empty, e.g. to run them on a
+	// node pool of cheap nodes.
+	NodeSelector map[string]string
+}
+
+// NewHitScheduling parses the scheduling of the pods served from cache: the mode is
+// HitSchedulingKeep or HitSchedulingStrip and the node selector a comma separated list of
+// key=value node labels, e.g. pool=cache-hits.
+func NewHitScheduling(mode string, nodeSelector string) (HitScheduling, error) {
+	if !IsValidHitScheduling(mode) {
+		return HitScheduling{}, fmt.Errorf("invalid cache hit scheduling %q, expected %s or %s", mode, HitSchedulingKeep, HitSchedulingStrip)
+	}
+	scheduling := HitScheduling{Strip: mode == HitSchedulingStrip}
+	for _, item := range strings.Split(nodeSelector, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return HitScheduling{}, fmt.Errorf("invalid cache hit node selector: %q is not a key=value pair", item)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...); len(errs) != 0 {
+			return HitScheduling{}, fmt.Errorf("invalid cache hit node selector %q: %s", item, strings.Join(errs, ", "))
+		}
+		if scheduling.NodeSelector == nil {
+			scheduling.NodeSelector = map[string]string{}
+		}
+		scheduling.NodeSelector[key] = value
+	}
+	return scheduling, nil
+}
+
+// patches returns the patches applying the scheduling to the pod. Only the constraints the pod sets
+// are removed, as removing missing fields fails the patch.
+func (s HitScheduling) patches(pod *corev1.Pod) []patchOperation {
+	var patches []patchOperation
+	if s.Strip {
+		set := map[string]bool{
+			SpecTolerationsPath:      len(pod.Spec.Tolerations) != 0,
+			SpecNodeSelectorPath:     len(pod.Spec.NodeSelector) != 0 && len(s.NodeSelector) == 0,
+			SpecAffinityPath:         pod.Spec.Affinity != nil,
+			SpecRuntimeClassNamePath: pod.Spec.RuntimeClassName != nil,
+		}
+		for _, path := range []string{SpecTolerationsPath, SpecNodeSelectorPath, SpecAffinityPath, SpecRuntimeClassNamePath} {
+			if set[path] {
+				patches = append(patches, patchOperation{Op: OperationTypeRemove, Path: path})
+			}
+		}
+	}
+	if len(s.NodeSelector) != 0 {
+		patches = append(patches, patchOperation{
+			Op:    OperationTypeAdd,
+			Path:  SpecNodeSelectorPath,
+			Value: s.NodeSelector,
+		})
+	}
+	return patches
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gpuPod returns a pod of the template scheduled on GPU nodes.
+func gpuPod(template string) *corev1.Pod {
+	pod := keyVersionPod(template, "")
+	runtimeClass := "nvidia"
+	pod.Spec.NodeSelector = map[string]string{"accelerator": "nvidia-tesla-t4"}
+	pod.Spec.Tolerations = []corev1.Toleration{{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}
+	pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	pod.Spec.RuntimeClassName = &runtimeClass
+	runAsNonRoot := true
+	pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsNonRoot: &runAsNonRoot}
+	return pod
+}
+
+func TestNewHitScheduling(t *testing.T) {
+	scheduling, err := NewHitScheduling(HitSchedulingKeep, " , ")
+	require.Nil(t, err)
+	assert.Equal(t, HitScheduling{}, scheduling)
+
+	scheduling, err = NewHitScheduling(HitSchedulingStrip, "cloud.google.com/gke-nodepool=cache-hits, spot=true")
+	require.Nil(t, err)
+	assert.Equal(t, HitScheduling{Strip: true, NodeSelector: map[string]string{"cloud.google.com/gke-nodepool": "cache-hits", "spot": "true"}}, scheduling)
+
+	_, err = NewHitScheduling("", "")
+	assert.Contains(t, err.Error(), "invalid cache hit scheduling")
+	for _, selector := range []string{"pool", "pool=cache hits", "-pool=cache"} {
+		_, err = NewHitScheduling(HitSchedulingKeep, selector)
+		assert.Contains(t, err.Error(), "invalid cache hit node selector", selector)
+	}
+}
+
+func TestHitSchedulingPatches(t *testing.T) {
+	pod := gpuPod("{}")
+	assert.Nil(t, HitScheduling{}.patches(pod))
+	assert.Nil(t, HitScheduling{Strip: true}.patches(&corev1.Pod{}), "missing constraints are not removed")
+
+	assert.Equal(t, []patchOperation{
+		{Op: OperationTypeRemove, Path: SpecTolerationsPath},
+		{Op: OperationTypeRemove, Path: SpecNodeSelectorPath},
+		{Op: OperationTypeRemove, Path: SpecAffinityPath},
+		{Op: OperationTypeRemove, Path: SpecRuntimeClassNamePath},
+	}, HitScheduling{Strip: true}.patches(pod))
+
+	pool := map[string]string{"pool": "cache-hits"}
+	assert.Equal(t, []patchOperation{
+		{Op: OperationTypeRemove, Path: SpecTolerationsPath},
+		{Op: OperationTypeRemove, Path: SpecAffinityPath},
+		{Op: OperationTypeRemove, Path: SpecRuntimeClassNamePath},
+		{Op: OperationTypeAdd, Path: SpecNodeSelectorPath, Value: pool},
+	}, HitScheduling{Strip: true, NodeSelector: pool}.patches(pod), "the node selector is replaced rather than removed")
+	assert.Equal(t, []patchOperation{
+		{Op: OperationTypeAdd, Path: SpecNodeSelectorPath, Value: pool},
+	}, HitScheduling{NodeSelector: pool}.patches(pod))
+}
+
+func TestMutatePodIfCachedWithHitScheduling(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"command":["python","train.py"],"image":"tensorflow/tensorflow:latest-gpu"}}`
+	key, err := generateCacheKey(CacheKeyVersionV1, template)
+	require.Nil(t, err)
+	_, err = clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		scheduling HitScheduling
+		removed    bool
+	}{
+		{scheduling: HitScheduling{}},
+		{scheduling: HitScheduling{Strip: true}, removed: true},
+	} {
+		webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{HitScheduling: test.scheduling}})
+		pod := gpuPod(template)
+		patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+		require.Nil(t, err)
+		byPath := make(map[string]patchOperation)
+		for _, patch := range patches {
+			byPath[patch.Path] = patch
+		}
+		require.Contains(t, byPath, SpecContainersPath, "the pod hits")
+		containers := byPath[SpecContainersPath].Value.([]corev1.Container)
+		assert.Equal(t, pod.Spec.Containers[0].SecurityContext, containers[0].SecurityContext, "the dummy container keeps the security context")
+		for _, path := range []string{SpecTolerationsPath, SpecNodeSelectorPath, SpecAffinityPath, SpecRuntimeClassNamePath} {
+			_, removed := byPath[path]
+			assert.Equal(t, test.removed, removed, path)
+		}
+	}
+}
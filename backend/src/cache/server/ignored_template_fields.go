@@ -0,0 +1,164 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IgnoredTemplateFields removes fields of the templates before their cache key is generated, so
+// that volatile fields, e.g. environment variables holding the run ID, do not affect the keys.
+// Fields are given by paths of the form container.env[name=RUN_ID], see
+// ParseIgnoredTemplateFields.
+type IgnoredTemplateFields struct {
+	paths []templateFieldPath
+}
+
+// templateFieldPath selects fields of the template, from its root.
+type templateFieldPath []templateFieldSegment
+
+// templateFieldSegment selects a member of an object and, when selected, the items of the list it
+// holds: all of them, or those whose field equals the value.
+type templateFieldSegment struct {
+	name      string
+	selected  bool
+	selectAll bool
+	field     string
+	value     string
+}
+
+// templateFieldSegmentPattern matches the segments of the paths: a member name, optionally followed
+// by [*] or [field=value], the value optionally quoted.
+var templateFieldSegmentPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+)(?:\[(?:(\*)|([A-Za-z0-9_-]+)=(.*))\])?$`)
+
+// ParseIgnoredTemplateFields parses a comma separated list of paths to the fields of the templates
+// to remove, e.g. container.env[name=RUN_ID],sidecars[*].env[name=POD_NAME],container.workingDir.
+// Paths are dot separated member names from the root of the template, optionally starting with
+// the $. of JSONPath, and a member holding a list selects all its items with [*] or the items
+// whose field equals the value with [field=value]. A path ending with a selector removes the
+// items it selects. It returns nil when no path is given.
+func ParseIgnoredTemplateFields(spec string) (*IgnoredTemplateFields, error) {
+	var paths []templateFieldPath
+	for _, pathSpec := range strings.Split(spec, ",") {
+		if pathSpec = strings.TrimSpace(pathSpec); pathSpec == "" {
+			continue
+		}
+		path, err := parseTemplateFieldPath(pathSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignored template field %q: %v", pathSpec, err)
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	return &IgnoredTemplateFields{paths: paths}, nil
+}
+
+func parseTemplateFieldPath(spec string) (templateFieldPath, error) {
+	var path templateFieldPath
+	for _, segmentSpec := range strings.Split(strings.TrimPrefix(spec, "$."), ".") {
+		match := templateFieldSegmentPattern.FindStringSubmatch(segmentSpec)
+		if match == nil {
+			return nil, fmt.Errorf("%q is not a member name, optionally followed by [*] or [field=value]", segmentSpec)
+		}
+		segment := templateFieldSegment{
+			name:      match[1],
+			selected:  match[2] != "" || match[3] != "",
+			selectAll: match[2] != "",
+			field:     match[3],
+			value:     strings.Trim(match[4], `"'`),
+		}
+		path = append(path, segment)
+	}
+	return path, nil
+}
+
+// strip returns the template without the ignored fields. A nil IgnoredTemplateFields returns the
+// template as is. Otherwise the template is marshaled again, in the canonical form the key
+// strategies hash, so that templates without ignored fields keep their key.
+func (f *IgnoredTemplateFields) strip(template string) (string, error) {
+	if f == nil {
+		return template, nil
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(template), &parsed); err != nil {
+		return "", err
+	}
+	for _, path := range f.paths {
+		removeTemplateField(parsed, path)
+	}
+	stripped, err := json.Marshal(parsed)
+	if err != nil {
+		return "", err
+	}
+	return string(stripped), nil
+}
+
+// removeTemplateField removes the fields of the value selected by the path.
+func removeTemplateField(value interface{}, path templateFieldPath) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	segment := path[0]
+	member, exists := object[segment.name]
+	if !exists {
+		return
+	}
+	if !segment.selected {
+		if len(path) == 1 {
+			delete(object, segment.name)
+		} else {
+			removeTemplateField(member, path[1:])
+		}
+		return
+	}
+	items, ok := member.([]interface{})
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		kept := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			if !segment.matches(item) {
+				kept = append(kept, item)
+			}
+		}
+		object[segment.name] = kept
+		return
+	}
+	for _, item := range items {
+		if segment.matches(item) {
+			removeTemplateField(item, path[1:])
+		}
+	}
+}
+
+// matches reports whether the segment selects the item of a list.
+func (s templateFieldSegment) matches(item interface{}) bool {
+	if s.selectAll {
+		return true
+	}
+	object, ok := item.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	value, ok := object[s.field].(string)
+	return ok && value == s.value
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnoredTemplateFields(t *testing.T) {
+	ignored, err := ParseIgnoredTemplateFields(" , ")
+	assert.Nil(t, err)
+	assert.Nil(t, ignored)
+
+	ignored, err = ParseIgnoredTemplateFields(`$.container.env[name="RUN_ID"], sidecars[*].env[name=POD_NAME],container.workingDir`)
+	require.Nil(t, err)
+	assert.Equal(t, []templateFieldPath{
+		{{name: "container"}, {name: "env", selected: true, field: "name", value: "RUN_ID"}},
+		{{name: "sidecars", selected: true, selectAll: true}, {name: "env", selected: true, field: "name", value: "POD_NAME"}},
+		{{name: "container"}, {name: "workingDir"}},
+	}, ignored.paths)
+
+	for _, spec := range []string{"container..env", "container.env[name]", "container.env[0]", "container[*", "container env"} {
+		_, err := ParseIgnoredTemplateFields(spec)
+		assert.Contains(t, err.Error(), "invalid ignored template field", spec)
+	}
+}
+
+func TestIgnoredTemplateFieldsStrip(t *testing.T) {
+	ignored, err := ParseIgnoredTemplateFields("container.env[name=RUN_ID],sidecars[*].env[name=POD_NAME],container.workingDir,volumes[*]")
+	require.Nil(t, err)
+
+	stripped, err := ignored.strip(`{"container":{"image":"python:3.7","workingDir":"/run","env":[{"name":"RUN_ID","value":"1"},{"name":"MODE","value":"fast"}]},` +
+		`"sidecars":[{"name":"a","env":[{"name":"POD_NAME","value":"p"}]},{"name":"b"}],"volumes":[{"name":"v"}]}`)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"container":{"image":"python:3.7","env":[{"name":"MODE","value":"fast"}]},"sidecars":[{"name":"a","env":[]},{"name":"b"}],"volumes":[]}`, stripped)
+
+	// Fields of unexpected types are left alone.
+	stripped, err = ignored.strip(`{"container":"python","sidecars":{"env":[]}}`)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"container":"python","sidecars":{"env":[]}}`, stripped)
+
+	_, err = ignored.strip(`{"container":`)
+	assert.NotNil(t, err)
+
+	var none *IgnoredTemplateFields
+	stripped, err = none.strip(`{"container": {}}`)
+	require.Nil(t, err)
+	assert.Equal(t, `{"container": {}}`, stripped)
+}
+
+func TestTemplateCacheKeyWithIgnoredFields(t *testing.T) {
+	ignored, err := ParseIgnoredTemplateFields("container.env[name=RUN_ID]")
+	require.Nil(t, err)
+	template := `{"name":"step","container":{"image":"python:3.7","command":["echo", "Hello"],"env":[{"name":"MODE","value":"fast"}]}}`
+	for _, version := range []string{CacheKeyVersionV1, CacheKeyVersionV2} {
+		want, err := generateCacheKey(version, template)
+		require.Nil(t, err)
+		key, err := newTemplateKeyer(nil).cacheKey(context.Background(), version, ignored, template)
+		require.Nil(t, err)
+		assert.Equal(t, want, key, "templates without ignored fields keep their key with version %s", version)
+
+		withRunID := `{"name":"step","container":{"image":"python:3.7","command":["echo", "Hello"],"env":[{"name":"RUN_ID","value":"run-1"},{"name":"MODE","value":"fast"}]}}`
+		key, err = newTemplateKeyer(nil).cacheKey(context.Background(), version, ignored, withRunID)
+		require.Nil(t, err)
+		assert.Equal(t, want, key, "the ignored fields do not affect the key with version %s", version)
+	}
+}
+
+func TestMutatePodIfCachedWithIgnoredFields(t *testing.T) {
+	ignored, err := ParseIgnoredTemplateFields("container.env[name=RUN_ID]")
+	require.Nil(t, err)
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{IgnoredFields: ignored}})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := `{"container":{"image":"python:3.7","command":["echo","Hello"],"env":[{"name":"RUN_ID","value":"run-1"}]}}`
+	key, err := newTemplateKeyer(nil).cacheKey(context.Background(), CacheKeyVersionV1, ignored, template)
+	require.Nil(t, err)
+	_, err = clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	nextRun := `{"container":{"image":"python:3.7","command":["echo","Hello"],"env":[{"name":"RUN_ID","value":"run-2"}]}}`
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(nextRun, "")), clientManager)
+
+	require.Nil(t, err)
+	require.Equal(t, 3, len(patches), "the pod of the next run hits")
+	assert.Equal(t, key, patches[1].Value.(map[string]string)[podKeys.ExecutionKey])
+}
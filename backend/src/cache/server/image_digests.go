@@ -0,0 +1,375 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultImageDigestTTL bounds the time a re-pushed tag may still be served the entries of the
+	// image it replaced.
+	DefaultImageDigestTTL time.Duration = time.Minute
+	// DefaultImageDigestRequestTimeout bounds each request to a registry.
+	DefaultImageDigestRequestTimeout time.Duration = 5 * time.Second
+	// DefaultImageDigestMemoSize bounds the memory of the digests remembered.
+	DefaultImageDigestMemoSize int = 10000
+
+	dockerHubDomain   string = "docker.io"
+	dockerHubRegistry string = "registry-1.docker.io"
+)
+
+// manifestMediaTypes are accepted for the manifests of tags, so that registries answer with the
+// digest of the manifest list of multi-platform images rather than converting it.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ImageDigestResolver resolves the tags of the images of the templates to the digests of their
+// manifests with the Docker Registry HTTP API V2, so that re-pushing a tag changes the cache key of
+// the templates using it. Registries are queried anonymously, with a bearer token when they ask for
+// one, so the images of private registries are not resolved and their pods are not cached. The
+// digests of the most recently used images are remembered for ttl, and the concurrent resolutions
+// of an image share a single request.
+type ImageDigestResolver struct {
+	client   *http.Client
+	ttl      time.Duration
+	time     util.TimeInterface
+	capacity int
+	group    singleflight.Group
+
+	mutex sync.Mutex
+	// recent lists the digests resolved from the most to the least recently used.
+	recent  *list.List
+	digests map[string]*list.Element
+}
+
+type resolvedImageDigest struct {
+	image      string
+	digest     string
+	resolvedAt time.Time
+}
+
+// unresolvedImageDigestError is returned for the templates whose images could not be resolved,
+// whose pods are not cacheable rather than failing.
+type unresolvedImageDigestError struct {
+	image string
+	err   error
+}
+
+func (e *unresolvedImageDigestError) Error() string {
+	return fmt.Sprintf("failed to resolve the digest of image %s: %v", e.image, e.err)
+}
+
+// isUnresolvedImageDigest returns whether the error is that of an image whose digest could not be
+// resolved.
+func isUnresolvedImageDigest(err error) bool {
+	_, unresolved := err.(*unresolvedImageDigestError)
+	return unresolved
+}
+
+// factory function for the resolver of the image digests of the cache keys
+func NewImageDigestResolver(ttl time.Duration, timeInterface util.TimeInterface) *ImageDigestResolver {
+	return &ImageDigestResolver{
+		client:   &http.Client{Timeout: DefaultImageDigestRequestTimeout},
+		ttl:      ttl,
+		time:     timeInterface,
+		capacity: DefaultImageDigestMemoSize,
+		recent:   list.New(),
+		digests:  map[string]*list.Element{},
+	}
+}
+
+// templateKeyer generates the cache keys of the templates, remembering those of the recent ones.
+type templateKeyer struct {
+	memo *cacheKeyMemo
+	// images folds the digests of the images into the keys. Nil keeps the keys of the templates.
+	images *ImageDigestResolver
+}
+
+// factory function for the generator of the cache keys of the templates, with the digests of their
+// images folded in by images unless nil
+func newTemplateKeyer(images *ImageDigestResolver) *templateKeyer {
+	return &templateKeyer{
+		memo:   newCacheKeyMemo(DefaultCacheKeyMemoSize),
+		images: images,
+	}
+}
+
+// cacheKey returns the cache key of the template without the ignored fields under the key
+// strategy of the version, with the digests of its images folded in when the keyer resolves them.
+func (k *templateKeyer) cacheKey(ctx context.Context, version string, ignored *IgnoredTemplateFields, template string) (string, error) {
+	template, err := ignored.strip(template)
+	if err != nil {
+		return "", err
+	}
+	key, err := k.memo.cacheKey(version, template)
+	if err != nil || k.images == nil {
+		return key, err
+	}
+	return k.images.foldDigests(ctx, key, template)
+}
+
+// foldDigests hashes the key with the digests of the images of the template, in the order of the
+// template. The key is unchanged for templates without image. Images that cannot be resolved
+// return an unresolvedImageDigestError.
+func (r *ImageDigestResolver) foldDigests(ctx context.Context, key string, template string) (string, error) {
+	images, err := templateImages(template)
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return key, nil
+	}
+	md := sha256.New()
+	md.Write([]byte(key))
+	for _, image := range images {
+		digest, err := r.resolve(ctx, image)
+		if err != nil {
+			return "", &unresolvedImageDigestError{image: image, err: err}
+		}
+		md.Write([]byte("\x00" + digest))
+	}
+	return hex.EncodeToString(md.Sum(nil)), nil
+}
+
+// templateImages returns the images of the containers of the template that affect its cache key.
+func templateImages(template string) ([]string, error) {
+	var containers struct {
+		Container      *struct{ Image string }  `json:"container"`
+		InitContainers []struct{ Image string } `json:"initContainers"`
+		Sidecars       []struct{ Image string } `json:"sidecars"`
+	}
+	if err := json.Unmarshal([]byte(template), &containers); err != nil {
+		return nil, err
+	}
+	var images []string
+	if containers.Container != nil && containers.Container.Image != "" {
+		images = append(images, containers.Container.Image)
+	}
+	for _, container := range append(containers.InitContainers, containers.Sidecars...) {
+		if container.Image != "" {
+			images = append(images, container.Image)
+		}
+	}
+	return images, nil
+}
+
+// resolve returns the digest of the image, which is the one of the reference for images pinned
+// by digest. Failed resolutions are not remembered.
+func (r *ImageDigestResolver) resolve(ctx context.Context, image string) (string, error) {
+	registry, repository, reference := parseImageReference(image)
+	if strings.Contains(reference, ":") {
+		return reference, nil
+	}
+	now := r.time.Now()
+	if digest, exists := r.remembered(image, now); exists {
+		return digest, nil
+	}
+	result, err, _ := r.group.Do(image, func() (interface{}, error) {
+		// The resolution is shared with admissions that are not canceled with the one starting
+		// it, so it only keeps the deadline of that admission.
+		var resolveCtx context.Context = detachedContext{ctx}
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			resolveCtx, cancel = context.WithDeadline(resolveCtx, deadline)
+			defer cancel()
+		}
+		digest, err := r.manifestDigest(resolveCtx, registry, repository, reference)
+		if err != nil {
+			return "", err
+		}
+		r.remember(image, digest, now)
+		return digest, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
+}
+
+// remembered returns the digest of the image resolved within the ttl of now, if any.
+func (r *ImageDigestResolver) remembered(image string, now time.Time) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	element, exists := r.digests[image]
+	if !exists {
+		return "", false
+	}
+	resolved := element.Value.(*resolvedImageDigest)
+	if now.Sub(resolved.resolvedAt) >= r.ttl {
+		r.recent.Remove(element)
+		delete(r.digests, image)
+		return "", false
+	}
+	r.recent.MoveToFront(element)
+	return resolved.digest, true
+}
+
+// remember remembers the digest of the image resolved at resolvedAt, forgetting the least recently
+// used digest beyond the capacity of the resolver.
+func (r *ImageDigestResolver) remember(image string, digest string, resolvedAt time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if element, exists := r.digests[image]; exists {
+		r.recent.Remove(element)
+	}
+	r.digests[image] = r.recent.PushFront(&resolvedImageDigest{image: image, digest: digest, resolvedAt: resolvedAt})
+	if r.recent.Len() > r.capacity {
+		oldest := r.recent.Remove(r.recent.Back()).(*resolvedImageDigest)
+		delete(r.digests, oldest.image)
+	}
+}
+
+// parseImageReference splits the image into the host of its registry, its repository and its
+// digest, or its tag when it has none, with the defaults of Docker: images without registry are on
+// Docker Hub, in the library repository when they have no path, and images without tag are tagged
+// latest.
+func parseImageReference(image string) (string, string, string) {
+	name, reference := image, ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	}
+	tag := "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if reference == "" {
+		reference = tag
+	}
+	registry, repository := dockerHubDomain, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		domain := name[:i]
+		if strings.ContainsAny(domain, ".:") || domain == "localhost" {
+			registry, repository = domain, name[i+1:]
+		}
+	}
+	if registry == dockerHubDomain {
+		registry = dockerHubRegistry
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	return registry, repository, reference
+}
+
+// manifestDigest asks the registry for the digest of the manifest of the tag, with a bearer token
+// of the realm of the registry when it asks for one.
+func (r *ImageDigestResolver) manifestDigest(ctx context.Context, registry string, repository string, tag string) (string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, tag)
+	resp, err := r.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := r.bearerToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		resp, err = r.headManifest(ctx, manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s answered %s", registry, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry %s did not return the digest of the manifest", registry)
+	}
+	return digest, nil
+}
+
+func (r *ImageDigestResolver) headManifest(ctx context.Context, manifestURL string, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// bearerToken gets an anonymous token from the realm of the challenge of the registry, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/python:pull".
+func (r *ImageDigestResolver) bearerToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if i := strings.Index(param, "="); i >= 0 {
+			params[strings.TrimSpace(param[:i])] = strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry authentication realm %q", params["realm"])
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry authentication realm %s answered %s", realm.Host, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid registry token: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,295 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageReference(t *testing.T) {
+	for _, test := range []struct {
+		image      string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{image: "python", registry: "registry-1.docker.io", repository: "library/python", reference: "latest"},
+		{image: "python:3.7", registry: "registry-1.docker.io", repository: "library/python", reference: "3.7"},
+		{image: "tensorflow/tensorflow:2.3.0", registry: "registry-1.docker.io", repository: "tensorflow/tensorflow", reference: "2.3.0"},
+		{image: "docker.io/python:3.7", registry: "registry-1.docker.io", repository: "library/python", reference: "3.7"},
+		{image: "gcr.io/ml-pipeline/trainer", registry: "gcr.io", repository: "ml-pipeline/trainer", reference: "latest"},
+		{image: "localhost/trainer:v1", registry: "localhost", repository: "trainer", reference: "v1"},
+		{image: "registry:5000/team/trainer:v1", registry: "registry:5000", repository: "team/trainer", reference: "v1"},
+		{image: "python@sha256:abc", registry: "registry-1.docker.io", repository: "library/python", reference: "sha256:abc"},
+		{image: "gcr.io/team/trainer:v1@sha256:abc", registry: "gcr.io", repository: "team/trainer", reference: "sha256:abc"},
+	} {
+		registry, repository, reference := parseImageReference(test.image)
+		assert.Equal(t, test.registry, registry, test.image)
+		assert.Equal(t, test.repository, repository, test.image)
+		assert.Equal(t, test.reference, reference, test.image)
+	}
+}
+
+func TestTemplateImages(t *testing.T) {
+	images, err := templateImages(`{"container":{"image":"python:3.7"},"initContainers":[{"image":"busybox"}],"sidecars":[{"image":"redis"},{"name":"no image"}]}`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"python:3.7", "busybox", "redis"}, images)
+
+	images, err = templateImages(`{"resource":{"action":"create"}}`)
+	require.Nil(t, err)
+	assert.Empty(t, images)
+}
+
+// fakeRegistry serves the digests of the tags of its repositories to the holders of its bearer
+// token, like Docker Hub.
+type fakeRegistry struct {
+	server *httptest.Server
+	// delay is the time the registry takes to answer the lookups of manifests.
+	delay time.Duration
+
+	mutex           sync.Mutex
+	digests         map[string]string
+	manifestLookups int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	r := &fakeRegistry{digests: map[string]string{}}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			if req.URL.Query().Get("scope") != "repository:team/step:pull" {
+				http.Error(w, "unexpected scope", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token":"anonymous"}`))
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:team/step:pull"`, r.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		time.Sleep(r.delay)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.manifestLookups++
+		digest, exists := r.digests[req.URL.Path]
+		if req.Method != http.MethodHead || !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	}))
+	return r
+}
+
+func (r *fakeRegistry) push(tag string, digest string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.digests["/v2/team/step/manifests/"+tag] = digest
+}
+
+func (r *fakeRegistry) lookups() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.manifestLookups
+}
+
+// image returns the image of the tag in the registry.
+func (r *fakeRegistry) image(tag string) string {
+	return strings.TrimPrefix(r.server.URL, "https://") + "/team/step:" + tag
+}
+
+func (r *fakeRegistry) resolver(ttl time.Duration) *ImageDigestResolver {
+	resolver := NewImageDigestResolver(ttl, util.NewFakeTimeForEpoch())
+	resolver.client = r.server.Client()
+	return resolver
+}
+
+func TestImageDigestResolverResolvesTags(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	registry.push("v1", "sha256:1111")
+	// The fake time moves a second forward on every resolution.
+	resolver := registry.resolver(2 * time.Second)
+
+	digest, err := resolver.resolve(context.Background(), registry.image("v1"))
+	require.Nil(t, err)
+	assert.Equal(t, "sha256:1111", digest)
+	assert.Equal(t, 1, registry.lookups())
+
+	registry.push("v1", "sha256:2222")
+	digest, err = resolver.resolve(context.Background(), registry.image("v1"))
+	require.Nil(t, err)
+	assert.Equal(t, "sha256:1111", digest, "the digest is reused within the ttl")
+	digest, err = resolver.resolve(context.Background(), registry.image("v1"))
+	require.Nil(t, err)
+	assert.Equal(t, "sha256:2222", digest)
+	assert.Equal(t, 2, registry.lookups())
+
+	digest, err = resolver.resolve(context.Background(), registry.image("v1")+"@sha256:3333")
+	require.Nil(t, err)
+	assert.Equal(t, "sha256:3333", digest, "images pinned by digest are not resolved")
+	assert.Equal(t, 2, registry.lookups())
+
+	_, err = resolver.resolve(context.Background(), registry.image("v2"))
+	assert.Contains(t, err.Error(), "404 Not Found")
+}
+
+func TestImageDigestResolverForgetsTheLeastRecentlyUsedDigests(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		registry.push(tag, "sha256:"+tag)
+	}
+	resolver := registry.resolver(time.Hour)
+	resolver.capacity = 2
+	resolve := func(tag string) {
+		digest, err := resolver.resolve(context.Background(), registry.image(tag))
+		require.Nil(t, err)
+		assert.Equal(t, "sha256:"+tag, digest)
+	}
+
+	resolve("v1")
+	resolve("v2")
+	resolve("v1")
+	resolve("v3")
+	assert.Equal(t, 3, registry.lookups())
+	assert.Equal(t, 2, len(resolver.digests))
+	resolve("v1")
+	assert.Equal(t, 3, registry.lookups(), "the recently used digest is remembered")
+	resolve("v2")
+	assert.Equal(t, 4, registry.lookups(), "the least recently used digest is forgotten")
+}
+
+func TestImageDigestResolverSharesConcurrentResolutions(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	registry.delay = 200 * time.Millisecond
+	registry.push("v1", "sha256:1111")
+	// The resolutions read the clock at once, which the fake clock does not allow.
+	resolver := NewImageDigestResolver(0, util.NewRealTime())
+	resolver.client = registry.server.Client()
+
+	var wg sync.WaitGroup
+	digests := make([]string, 10)
+	errs := make([]error, len(digests))
+	for i := range digests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			digests[i], errs[i] = resolver.resolve(context.Background(), registry.image("v1"))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, registry.lookups())
+	for i := range digests {
+		require.Nil(t, errs[i])
+		assert.Equal(t, "sha256:1111", digests[i])
+	}
+}
+
+func TestTemplateCacheKeyFoldsImageDigests(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	registry.push("v1", "sha256:1111")
+	template := fmt.Sprintf(`{"container":{"image":"%s","command":["echo","Hello"]}}`, registry.image("v1"))
+	templateKey, err := generateCacheKey(CacheKeyVersionV1, template)
+	require.Nil(t, err)
+
+	keyer := newTemplateKeyer(registry.resolver(0))
+	key, err := keyer.cacheKey(context.Background(), CacheKeyVersionV1, nil, template)
+	require.Nil(t, err)
+	assert.NotEqual(t, templateKey, key)
+	again, err := keyer.cacheKey(context.Background(), CacheKeyVersionV1, nil, template)
+	require.Nil(t, err)
+	assert.Equal(t, key, again)
+
+	registry.push("v1", "sha256:2222")
+	repushed, err := keyer.cacheKey(context.Background(), CacheKeyVersionV1, nil, template)
+	require.Nil(t, err)
+	assert.NotEqual(t, key, repushed, "re-pushing the tag changes the key")
+
+	withoutImage := `{"resource":{"action":"create"}}`
+	resourceKey, err := generateCacheKey(CacheKeyVersionV1, withoutImage)
+	require.Nil(t, err)
+	key, err = keyer.cacheKey(context.Background(), CacheKeyVersionV1, nil, withoutImage)
+	require.Nil(t, err)
+	assert.Equal(t, resourceKey, key)
+}
+
+func TestMutatePodIfCachedWithImageDigests(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	registry.push("v1", "sha256:1111")
+	webhook := NewWebhook(WebhookConfig{ImageDigests: registry.resolver(0)})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	template := fmt.Sprintf(`{"container":{"image":"%s","command":["echo","Hello"]}}`, registry.image("v1"))
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(patches), "the pod misses")
+	key := patches[0].Value.(map[string]string)[podKeys.ExecutionKey]
+	_, err = clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(patches), "the pod of the same image hits")
+
+	registry.push("v1", "sha256:2222")
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(patches), "the pod of the re-pushed tag misses")
+	assert.NotEqual(t, key, patches[0].Value.(map[string]string)[podKeys.ExecutionKey])
+
+	// Pods whose images cannot be resolved run uncached and unrecorded.
+	unknownTemplate := fmt.Sprintf(`{"container":{"image":"%s","command":["echo","Hello"]}}`, registry.image("unknown"))
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(unknownTemplate, "")), clientManager)
+	assert.Nil(t, err)
+	assert.Empty(t, patches)
+}
+
+func TestMutatePodIfCachedAdmitsUnresolvedImagesUnderTheClosedFailPolicy(t *testing.T) {
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	webhook := NewWebhook(WebhookConfig{ImageDigests: registry.resolver(0), Mutation: MutationConfig{FailPolicy: FailPolicyClosed}})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	// e.g. the image of a private registry, which is not read with the pull secrets of the pod.
+	template := fmt.Sprintf(`{"container":{"image":"%s","command":["echo","Hello"]}}`, registry.image("private"))
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(keyVersionPod(template, "")), clientManager)
+	require.Nil(t, err, "the pod is not cacheable rather than rejected")
+	assert.Empty(t, patches)
+}
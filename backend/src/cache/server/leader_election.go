@@ -0,0 +1,237 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	DefaultLeaseName string = "cache-watcher"
+
+	// The durations of the core Kubernetes controllers.
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig configures the election of the replica running the watchers, through a
+// coordination/v1 Lease. Zero durations take their default.
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	// Identity tells the replicas apart, e.g. the name of the pod.
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func (c LeaderElectionConfig) withDefaults() LeaderElectionConfig {
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = DefaultLeaseDuration
+	}
+	if c.RenewDeadline == 0 {
+		c.RenewDeadline = DefaultRenewDeadline
+	}
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = DefaultRetryPeriod
+	}
+	return c
+}
+
+// WatcherLeadership reports whether the replica leads the watchers. Replicas standing by keep
+// serving admissions.
+type WatcherLeadership struct {
+	leading int32
+	mutex   sync.Mutex
+	leader  string
+}
+
+// IsLeading returns whether the watchers of the replica run.
+func (l *WatcherLeadership) IsLeading() bool {
+	return atomic.LoadInt32(&l.leading) == 1
+}
+
+func (l *WatcherLeadership) setLeading(leading bool) {
+	var value int32
+	if leading {
+		value = 1
+	}
+	atomic.StoreInt32(&l.leading, value)
+}
+
+func (l *WatcherLeadership) setLeader(identity string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.leader = identity
+}
+
+// ReadinessCheck reports the leadership in /readyz. It never fails, since standing by is the
+// expected state of all replicas but one.
+func (l *WatcherLeadership) ReadinessCheck() DependencyCheck {
+	return DependencyCheck{
+		Name:  "watcher_leadership",
+		Check: func(ctx context.Context) error { return nil },
+		Detail: func() string {
+			if l.IsLeading() {
+				return "leader"
+			}
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			if l.leader == "" {
+				return "standby"
+			}
+			return "standby, led by " + l.leader
+		},
+	}
+}
+
+// leasesGetter gets the leases through the Kubernetes client of the client manager.
+type leasesGetter struct {
+	k8sCore client.KubernetesCoreInterface
+}
+
+func (g leasesGetter) Leases(namespace string) coordinationv1.LeaseInterface {
+	return g.k8sCore.LeaseClient(namespace)
+}
+
+// WatchPodsWhileLeading runs WatchPods whenever the replica holds the lease of election, until ctx
+// is done. Losing the lease stops the watchers once the pod at hand is recorded.
+func WatchPodsWhileLeading(ctx context.Context, namespaceToWatch string, clientManager ClientManagerInterface, config WatcherConfig, election LeaderElectionConfig, leadership *WatcherLeadership) {
+	config = config.withDefaults()
+	newLock := func() resourcelock.Interface {
+		return newLeaseLock(leasesGetter{clientManager.KubernetesCoreClient()}, election)
+	}
+	runWhileLeading(ctx, newLock, election, leadership, config.Metrics, func(ctx context.Context) {
+		WatchPods(ctx, namespaceToWatch, clientManager, config)
+	})
+}
+
+func newLeaseLock(leases coordinationv1.LeasesGetter, election LeaderElectionConfig) resourcelock.Interface {
+	return &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: election.LeaseName, Namespace: election.LeaseNamespace},
+		Client:     leases,
+		LockConfig: resourcelock.ResourceLockConfig{Identity: election.Identity},
+	}
+}
+
+// runWhileLeading stands for election with a lock of newLock until ctx is done, running watch while
+// leading and recording the leadership to metrics. Each term gets its own lock, since the elector
+// may still be renewing the last one when it gives up on it.
+func runWhileLeading(ctx context.Context, newLock func() resourcelock.Interface, election LeaderElectionConfig, leadership *WatcherLeadership, metrics WatcherMetrics, watch func(ctx context.Context)) {
+	election = election.withDefaults()
+	// The election outlives ctx until watch returns, so that the lease is only released once the
+	// pod at hand is recorded.
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
+	for ctx.Err() == nil {
+		term := &leadershipTerm{}
+		lock := newLock()
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   election.LeaseDuration,
+			RenewDeadline:   election.RenewDeadline,
+			RetryPeriod:     election.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lock.Describe(),
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					term.lead(ctx, leaderCtx, leadership, metrics, watch)
+				},
+				OnStoppedLeading: func() {},
+				OnNewLeader: func(identity string) {
+					logger.Infof("The watchers are led by %s", identity)
+					leadership.setLeader(identity)
+				},
+			},
+		})
+		if err != nil {
+			logger.Errorf("Failed to elect the leader of the watchers: %v", err)
+			return
+		}
+		elected := make(chan struct{})
+		go func() {
+			elector.Run(electionCtx)
+			close(elected)
+		}()
+		select {
+		case <-elected:
+			term.end()
+			logger.Infof("Lost the lease %s, standing by", lock.Describe())
+		case <-ctx.Done():
+			term.end()
+			stopElection()
+			<-elected
+		}
+	}
+}
+
+// leadershipTerm runs the watchers while the lease is held once.
+type leadershipTerm struct {
+	mutex sync.Mutex
+	ended bool
+	// done is closed once watch returns.
+	done chan struct{}
+}
+
+// lead runs watch until ctx or leaderCtx is done, unless the term already ended, e.g. when the
+// lease was lost before the elector started leading.
+func (t *leadershipTerm) lead(ctx context.Context, leaderCtx context.Context, leadership *WatcherLeadership, metrics WatcherMetrics, watch func(ctx context.Context)) {
+	t.mutex.Lock()
+	if t.ended {
+		t.mutex.Unlock()
+		return
+	}
+	t.done = make(chan struct{})
+	t.mutex.Unlock()
+	defer close(t.done)
+
+	watchCtx, stopWatching := context.WithCancel(leaderCtx)
+	defer stopWatching()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopWatching()
+		case <-watchCtx.Done():
+		}
+	}()
+	leadership.setLeading(true)
+	defer leadership.setLeading(false)
+	metrics.SetLeading(true)
+	defer metrics.SetLeading(false)
+	logger.Info("Leading the watchers")
+	watch(watchCtx)
+}
+
+// end waits for the watchers of the term to return, if they run.
+func (t *leadershipTerm) end() {
+	t.mutex.Lock()
+	t.ended = true
+	done := t.done
+	t.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
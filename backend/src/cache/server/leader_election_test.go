@@ -0,0 +1,188 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// testElection elects a leader within tenths of a second. The lease lasts longer since its renewals
+// are only told apart to the second.
+var testElection = LeaderElectionConfig{
+	LeaseName:      DefaultLeaseName,
+	LeaseNamespace: watchedNamespace,
+	LeaseDuration:  2 * time.Second,
+	RenewDeadline:  400 * time.Millisecond,
+	RetryPeriod:    50 * time.Millisecond,
+}
+
+// electedWatch counts the watchers run by an elected replica.
+type electedWatch struct {
+	running int32
+	terms   int32
+}
+
+func (w *electedWatch) watch(ctx context.Context) {
+	atomic.AddInt32(&w.terms, 1)
+	atomic.AddInt32(&w.running, 1)
+	defer atomic.AddInt32(&w.running, -1)
+	<-ctx.Done()
+}
+
+func (w *electedWatch) isRunning() bool {
+	return atomic.LoadInt32(&w.running) == 1
+}
+
+// startElection stands for election as identity until the returned function is called, recording
+// the leadership to metrics.
+func startElection(clientset *fake.Clientset, identity string, leadership *WatcherLeadership, metrics WatcherMetrics, watch func(ctx context.Context)) func() {
+	election := testElection
+	election.Identity = identity
+	newLock := func() resourcelock.Interface {
+		return newLeaseLock(leasesGetter{client.NewKubernetesCore(clientset)}, election)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runWhileLeading(ctx, newLock, election, leadership, metrics, watch)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func leaseHolder(t *testing.T, clientset *fake.Clientset) string {
+	lease, err := clientset.CoordinationV1().Leases(watchedNamespace).Get(DefaultLeaseName, metav1.GetOptions{})
+	require.Nil(t, err)
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestRunWhileLeadingWatchesOnceElected(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientset := fake.NewSimpleClientset()
+	leadership := &WatcherLeadership{}
+	watch := &electedWatch{}
+
+	stop := startElection(clientset, "cache-server-0", leadership, metrics, watch.watch)
+	require.Eventually(t, watch.isRunning, 5*time.Second, 10*time.Millisecond)
+
+	assert.True(t, leadership.IsLeading())
+	assert.Equal(t, "leader", leadership.ReadinessCheck().Detail())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.leader))
+	assert.Equal(t, "cache-server-0", leaseHolder(t, clientset))
+
+	stop()
+	assert.False(t, watch.isRunning())
+	assert.False(t, leadership.IsLeading())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.leader))
+	assert.Equal(t, "", leaseHolder(t, clientset), "the lease is released on shutdown")
+}
+
+func TestRunWhileLeadingStandsByWhileAnotherReplicaLeads(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	leaderWatch, followerWatch := &electedWatch{}, &electedWatch{}
+	stopLeader := startElection(clientset, "cache-server-0", &WatcherLeadership{}, noopWatcherMetrics{}, leaderWatch.watch)
+	require.Eventually(t, leaderWatch.isRunning, 5*time.Second, 10*time.Millisecond)
+
+	follower := &WatcherLeadership{}
+	stopFollower := startElection(clientset, "cache-server-1", follower, noopWatcherMetrics{}, followerWatch.watch)
+	defer stopFollower()
+	require.Eventually(t, func() bool {
+		return follower.ReadinessCheck().Detail() == "standby, led by cache-server-0"
+	}, 5*time.Second, 10*time.Millisecond)
+	// The follower would take over an expired lease by now.
+	time.Sleep(testElection.LeaseDuration + 500*time.Millisecond)
+	assert.False(t, follower.IsLeading())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&followerWatch.terms))
+	assert.Nil(t, follower.ReadinessCheck().Check(context.Background()), "standing by is ready")
+
+	stopLeader()
+	require.Eventually(t, followerWatch.isRunning, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, follower.IsLeading())
+	assert.Equal(t, "cache-server-1", leaseHolder(t, clientset))
+}
+
+func TestRunWhileLeadingStopsWatchingOnceLeadershipIsLost(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientset := fake.NewSimpleClientset()
+	leadership := &WatcherLeadership{}
+	watch := &electedWatch{}
+	stop := startElection(clientset, "cache-server-0", leadership, noopWatcherMetrics{}, watch.watch)
+	defer stop()
+	require.Eventually(t, watch.isRunning, 5*time.Second, 10*time.Millisecond)
+
+	// Another replica takes the lease over, e.g. once the renewals of this one timed out.
+	lease, err := clientset.CoordinationV1().Leases(watchedNamespace).Get(DefaultLeaseName, metav1.GetOptions{})
+	require.Nil(t, err)
+	holder, renewTime := "cache-server-1", metav1.NowMicro()
+	lease.Spec.HolderIdentity, lease.Spec.RenewTime = &holder, &renewTime
+	_, err = clientset.CoordinationV1().Leases(watchedNamespace).Update(lease)
+	require.Nil(t, err)
+	require.Eventually(t, func() bool { return !watch.isRunning() }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, leadership.IsLeading())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.leader))
+	assert.Equal(t, "cache-server-1", leaseHolder(t, clientset), "the lease of the new leader is not released")
+
+	// The lease expires unless renewed by the new leader.
+	require.Eventually(t, watch.isRunning, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&watch.terms), "the lease is acquired again")
+}
+
+func TestRunWhileLeadingReleasesTheLeaseOnceTheWatchReturns(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var watchReturned, releasedBeforeReturning int32
+	clientset.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lease := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease)
+		if (lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "") && atomic.LoadInt32(&watchReturned) == 0 {
+			atomic.StoreInt32(&releasedBeforeReturning, 1)
+		}
+		return false, nil, nil
+	})
+	watch := &electedWatch{}
+	stop := startElection(clientset, "cache-server-0", &WatcherLeadership{}, noopWatcherMetrics{}, func(ctx context.Context) {
+		watch.watch(ctx)
+		// Recording the pod at hand.
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&watchReturned, 1)
+	})
+	require.Eventually(t, watch.isRunning, 5*time.Second, 10*time.Millisecond)
+
+	stop()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&watchReturned))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&releasedBeforeReturning))
+	assert.Equal(t, "", leaseHolder(t, clientset))
+}
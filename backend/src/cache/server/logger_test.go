@@ -0,0 +1,160 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// captureLogs makes the server and storage packages log into a hook recording entries of all
+// levels until the returned function is called.
+func captureLogs() (*test.Hook, func()) {
+	capturingLogger, hook := test.NewNullLogger()
+	capturingLogger.SetLevel(logrus.TraceLevel)
+	SetLogger(capturingLogger)
+	storage.SetLogger(capturingLogger)
+	return hook, func() {
+		SetLogger(logrus.StandardLogger())
+		storage.SetLogger(logrus.StandardLogger())
+	}
+}
+
+// decisionEntries returns the captured entries carrying a decision.
+func decisionEntries(hook *test.Hook) []logrus.Entry {
+	var entries []logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if _, ok := entry.Data[logging.FieldDecision]; ok {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+func TestMutatePodIfCachedLogsDecisions(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Name = "train-1234"
+
+	_, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+	require.Nil(t, err)
+	clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	_, err = NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+	require.Nil(t, err)
+	pod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey] = "false"
+	_, err = NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+	require.Nil(t, err)
+
+	entries := decisionEntries(hook)
+	require.Len(t, entries, 3)
+	for i, decision := range []string{AdmissionOutcomeMiss, AdmissionOutcomeHit} {
+		entry := entries[i]
+		assert.Equal(t, logrus.InfoLevel, entry.Level, decision)
+		assert.Equal(t, decision, entry.Data[logging.FieldDecision])
+		assert.Equal(t, "train-1234", entry.Data[logging.FieldPod])
+		assert.Equal(t, "default", entry.Data[logging.FieldNamespace])
+		assert.Equal(t, "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0", entry.Data[logging.FieldCacheKey])
+		assert.IsType(t, float64(0), entry.Data[logging.FieldDurationMs])
+	}
+	assert.Equal(t, logrus.DebugLevel, entries[2].Level)
+	assert.Equal(t, AdmissionOutcomeSkippedNotKFP, entries[2].Data[logging.FieldDecision])
+	assert.Equal(t, "train-1234", entries[2].Data[logging.FieldPod])
+}
+
+func TestAdmissionLogsCarryCorrelationFields(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	hook, restore := captureLogs()
+	defer restore()
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Name = "train-1234"
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: GetFakeRequestFromPod(pod)})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+
+	webhook := NewWebhook(WebhookConfig{})
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(httptest.NewRecorder(), req)
+
+	var correlated []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if _, ok := entry.Data[logging.FieldRequestID]; ok {
+			correlated = append(correlated, entry)
+		}
+	}
+	var messages []string
+	for _, entry := range correlated {
+		messages = append(messages, entry.Message)
+		assert.Equal(t, string(fakeAdmissionRequest.UID), entry.Data[logging.FieldRequestID], entry.Message)
+		assert.Equal(t, "train-1234", entry.Data[logging.FieldPod], entry.Message)
+		assert.Equal(t, "default", entry.Data[logging.FieldNamespace], entry.Message)
+		assert.Equal(t, "test_node", entry.Data[logging.FieldNodeName], entry.Message)
+		assert.Equal(t, "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0", entry.Data[logging.FieldCacheKey], entry.Message)
+	}
+	// The store lookup is logged by the storage package.
+	assert.Contains(t, messages, "Found execution cache row")
+	assert.Equal(t, AdmissionOutcomeHit, correlated[len(correlated)-1].Data[logging.FieldDecision])
+}
+
+func TestAdmissionLogsCarryGeneratedRequestIDWithoutUID(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	rejectingAdmitFunc := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		return nil, errors.New("could not deserialize pod object")
+	}
+
+	serveAdmissionReview(t, NewWebhook(WebhookConfig{}), &v1beta1.AdmissionRequest{Namespace: "default"}, rejectingAdmitFunc)
+	serveAdmissionReview(t, NewWebhook(WebhookConfig{}), &v1beta1.AdmissionRequest{Namespace: "default"}, rejectingAdmitFunc)
+
+	var requestIDs []interface{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			assert.Contains(t, entry.Message, "could not deserialize pod object")
+			requestIDs = append(requestIDs, entry.Data[logging.FieldRequestID])
+		}
+	}
+	require.Len(t, requestIDs, 2)
+	assert.NotEmpty(t, requestIDs[0])
+	assert.NotEqual(t, requestIDs[0], requestIDs[1])
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultLookupMissTTL covers the admissions of a fan-out step, which arrive within moments
+	// of each other, and is short compared to the steps that record an entry of the key.
+	DefaultLookupMissTTL time.Duration = 2 * time.Second
+	// maxMemoizedMisses bounds the memory of the memoized misses.
+	maxMemoizedMisses int = 10000
+)
+
+// LookupCoalescer shares a single store lookup among the concurrent admissions of pods with the
+// same cache key, e.g. of fan-out steps, and remembers misses for missTTL so that the pods of a
+// burst arriving right after a miss are not looked up again. Errors are returned to the lookups
+// sharing the failed one only. A missTTL of 0 or less disables memoizing misses.
+type LookupCoalescer struct {
+	group            singleflight.Group
+	missTTL          time.Duration
+	time             util.TimeInterface
+	coalescedLookups prometheus.Counter
+	memoizedMisses   prometheus.Counter
+
+	mutex sync.Mutex
+	// misses maps the lookups that missed to the time the miss expires.
+	misses map[string]time.Time
+}
+
+// factory function for the coalescer of the cache lookups of the webhook
+func NewLookupCoalescer(missTTL time.Duration, timeInterface util.TimeInterface, registerer prometheus.Registerer) *LookupCoalescer {
+	return &LookupCoalescer{
+		missTTL: missTTL,
+		time:    timeInterface,
+		coalescedLookups: registerLookupCounter(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_lookups_coalesced_total",
+			Help: "Cache lookups answered by the store lookup of a concurrent admission of the same cache key.",
+		})),
+		memoizedMisses: registerLookupCounter(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_lookup_misses_memoized_total",
+			Help: "Cache lookups answered as a miss without store lookup because the same lookup missed moments before.",
+		})),
+		misses: map[string]time.Time{},
+	}
+}
+
+// registerLookupCounter registers the counter, or returns the one registered before when the
+// coalescer is created again.
+func registerLookupCounter(registerer prometheus.Registerer, counter prometheus.Counter) prometheus.Counter {
+	registered, err := metrics.RegisterOrReuse(registerer, counter)
+	if err != nil {
+		logger.Errorf("Failed to register lookup coalescing metrics: %v", err)
+	}
+	return registered.(prometheus.Counter)
+}
+
+// coalesced returns the store with its lookups coalesced with the concurrent lookups in the
+// namespace.
+func (c *LookupCoalescer) coalesced(store storage.ExecutionCacheStoreInterface, namespace string) storage.ExecutionCacheStoreInterface {
+	return &coalescingStore{ExecutionCacheStoreInterface: store, coalescer: c, namespace: namespace}
+}
+
+// coalescingStore looks entries up through a LookupCoalescer.
+type coalescingStore struct {
+	storage.ExecutionCacheStoreInterface
+	coalescer *LookupCoalescer
+	namespace string
+}
+
+func (s *coalescingStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	c := s.coalescer
+	// Lookups are only shared when they are bound to return the same entry of the same store.
+	flightKey := strings.Join([]string{fmt.Sprintf("%p", s.ExecutionCacheStoreInterface), executionCacheKey, s.namespace,
+		strconv.FormatInt(maxCacheStaleness, 10), strconv.FormatBool(filter.EnforceOwner), filter.Owner, filter.ClusterID, filter.KeyVersion}, "\x00")
+	if c.missMemoized(flightKey) {
+		c.memoizedMisses.Inc()
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache key %s was not found moments ago.", executionCacheKey)
+	}
+	started := false
+	result, err, shared := c.group.Do(flightKey, func() (interface{}, error) {
+		started = true
+		// The lookup is shared with admissions that are not canceled with the one starting it,
+		// so it only keeps the deadline of that admission.
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(DefaultAdmissionDeadline)
+		}
+		lookupCtx, cancel := context.WithDeadline(detachedContext{ctx}, deadline)
+		defer cancel()
+		executionCache, err := s.ExecutionCacheStoreInterface.GetExecutionCache(lookupCtx, executionCacheKey, maxCacheStaleness, filter)
+		if util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			c.memoizeMiss(flightKey)
+		}
+		return &sharedLookup{executionCache: executionCache, deadline: deadline}, err
+	})
+	lookup := result.(*sharedLookup)
+	if shared && !started {
+		c.coalescedLookups.Inc()
+		if outlivesSharedLookup(ctx, lookup.deadline, err) {
+			// The shared lookup ran out of the time of the admission that started it, this
+			// admission has time of its own.
+			return s.ExecutionCacheStoreInterface.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+		}
+	}
+	return lookup.executionCache, err
+}
+
+// sharedLookup is the result of a lookup shared by concurrent admissions, and the deadline it ran
+// under.
+type sharedLookup struct {
+	executionCache *model.ExecutionCache
+	deadline       time.Time
+}
+
+// outlivesSharedLookup reports whether the admission of ctx, which did not start the shared lookup
+// failing with err, has time left beyond the deadline of that lookup to look the key up itself.
+func outlivesSharedLookup(ctx context.Context, lookupDeadline time.Time, err error) bool {
+	if err != context.DeadlineExceeded && err != context.Canceled {
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || deadline.After(lookupDeadline)
+}
+
+func (c *LookupCoalescer) missMemoized(flightKey string) bool {
+	if c.missTTL <= 0 {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiry, exists := c.misses[flightKey]
+	if exists && !c.time.Now().Before(expiry) {
+		delete(c.misses, flightKey)
+		return false
+	}
+	return exists
+}
+
+func (c *LookupCoalescer) memoizeMiss(flightKey string) {
+	if c.missTTL <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.time.Now()
+	if len(c.misses) >= maxMemoizedMisses {
+		for key, expiry := range c.misses {
+			if !now.Before(expiry) {
+				delete(c.misses, key)
+			}
+		}
+		if len(c.misses) >= maxMemoizedMisses {
+			return
+		}
+	}
+	c.misses[flightKey] = now.Add(c.missTTL)
+}
+
+// detachedContext carries the values of its parent, such as its span and log fields, without
+// being canceled with it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
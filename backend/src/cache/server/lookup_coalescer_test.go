@@ -0,0 +1,317 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingExecutionCacheStore answers lookups with its entry or error after a delay, or once the
+// context of the lookup is done, and counts the lookups made.
+type countingExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	delay   time.Duration
+	entry   *model.ExecutionCache
+	err     error
+	lookups int32
+}
+
+func (s *countingExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	atomic.AddInt32(&s.lookups, 1)
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.entry == nil {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return s.entry, nil
+}
+
+func newTestLookupCoalescer(clock util.TimeInterface) *LookupCoalescer {
+	return NewLookupCoalescer(DefaultLookupMissTTL, clock, prometheus.NewRegistry())
+}
+
+// lookupConcurrently looks the key up count times at once through the coalescer.
+func lookupConcurrently(coalescer *LookupCoalescer, store storage.ExecutionCacheStoreInterface, count int) ([]*model.ExecutionCache, []error) {
+	entries := make([]*model.ExecutionCache, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entries[i], errs[i] = coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+		}(i)
+	}
+	wg.Wait()
+	return entries, errs
+}
+
+func TestLookupCoalescerSharesConcurrentLookups(t *testing.T) {
+	coalescer := newTestLookupCoalescer(&fixedClock{now: time.Unix(1000, 0)})
+	entry := &model.ExecutionCache{ExecutionCacheKey: "key1", ExecutionOutput: "outputs"}
+	store := &countingExecutionCacheStore{delay: 200 * time.Millisecond, entry: entry}
+
+	entries, errs := lookupConcurrently(coalescer, store, 10)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.lookups))
+	for i := range entries {
+		require.Nil(t, errs[i])
+		assert.Equal(t, entry, entries[i])
+	}
+	// The admission starting the lookup is not coalesced.
+	assert.Equal(t, float64(9), testutil.ToFloat64(coalescer.coalescedLookups))
+}
+
+func TestLookupCoalescerDoesNotRememberErrors(t *testing.T) {
+	coalescer := newTestLookupCoalescer(&fixedClock{now: time.Unix(1000, 0)})
+	store := &countingExecutionCacheStore{delay: 200 * time.Millisecond, err: errors.New("connection refused")}
+
+	_, errs := lookupConcurrently(coalescer, store, 5)
+	for _, err := range errs {
+		assert.EqualError(t, err, "connection refused")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.lookups))
+
+	// The next lookup queries the store again, and finds the entry recorded in the meantime.
+	store.err = nil
+	store.entry = &model.ExecutionCache{ExecutionCacheKey: "key1"}
+	entry, err := coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, store.entry, entry)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, float64(0), testutil.ToFloat64(coalescer.memoizedMisses))
+}
+
+func TestLookupCoalescerRemembersMissesForMissTTL(t *testing.T) {
+	clock := &fixedClock{now: time.Unix(1000, 0)}
+	coalescer := newTestLookupCoalescer(clock)
+	store := &countingExecutionCacheStore{}
+	lookup := func() error {
+		_, err := coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+		return err
+	}
+
+	require.True(t, util.HasCustomCode(lookup(), util.CUSTOM_CODE_NOT_FOUND))
+	clock.now = clock.now.Add(DefaultLookupMissTTL - time.Millisecond)
+	require.True(t, util.HasCustomCode(lookup(), util.CUSTOM_CODE_NOT_FOUND))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.lookups))
+	assert.Equal(t, float64(1), testutil.ToFloat64(coalescer.memoizedMisses))
+
+	clock.now = clock.now.Add(time.Millisecond)
+	require.True(t, util.HasCustomCode(lookup(), util.CUSTOM_CODE_NOT_FOUND))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.lookups))
+}
+
+func TestLookupCoalescerWithoutMissTTLDoesNotRememberMisses(t *testing.T) {
+	coalescer := NewLookupCoalescer(0, &fixedClock{now: time.Unix(1000, 0)}, prometheus.NewRegistry())
+	store := &countingExecutionCacheStore{}
+	for i := 0; i < 3; i++ {
+		_, err := coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+		require.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&store.lookups))
+}
+
+func TestLookupCoalescerKeepsDistinctLookupsApart(t *testing.T) {
+	coalescer := newTestLookupCoalescer(&fixedClock{now: time.Unix(1000, 0)})
+	store := &countingExecutionCacheStore{}
+	lookups := []struct {
+		namespace         string
+		key               string
+		maxCacheStaleness int64
+		filter            storage.ExecutionCacheFilter
+	}{
+		{"ns1", "key1", -1, storage.ExecutionCacheFilter{}},
+		{"ns2", "key1", -1, storage.ExecutionCacheFilter{}},
+		{"ns1", "key2", -1, storage.ExecutionCacheFilter{}},
+		{"ns1", "key1", 3600, storage.ExecutionCacheFilter{}},
+		{"ns1", "key1", -1, storage.ExecutionCacheFilter{EnforceOwner: true, Owner: "alice"}},
+		{"ns1", "key1", -1, storage.ExecutionCacheFilter{EnforceOwner: true, Owner: "bob"}},
+	}
+	for _, lookup := range lookups {
+		_, err := coalescer.coalesced(store, lookup.namespace).GetExecutionCache(context.Background(), lookup.key, lookup.maxCacheStaleness, lookup.filter)
+		require.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	}
+	assert.Equal(t, int32(len(lookups)), atomic.LoadInt32(&store.lookups))
+
+	// The same lookup of another store is not answered with the miss of the first store.
+	otherStore := &countingExecutionCacheStore{entry: &model.ExecutionCache{ExecutionCacheKey: "key1"}}
+	entry, err := coalescer.coalesced(otherStore, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, otherStore.entry, entry)
+}
+
+func TestLookupCoalescerCanceledAdmissionDoesNotFailOthers(t *testing.T) {
+	coalescer := newTestLookupCoalescer(&fixedClock{now: time.Unix(1000, 0)})
+	entry := &model.ExecutionCache{ExecutionCacheKey: "key1"}
+	store := &countingExecutionCacheStore{delay: 200 * time.Millisecond, entry: entry}
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		coalescer.coalesced(store, "ns1").GetExecutionCache(canceledCtx, "key1", -1, storage.ExecutionCacheFilter{})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	entries, errs := lookupConcurrently(coalescer, store, 3)
+	cancel()
+	wg.Wait()
+
+	for i := range entries {
+		require.Nil(t, errs[i])
+		assert.Equal(t, entry, entries[i])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.lookups))
+}
+
+// expiringExecutionCacheStore answers its first lookup with context.DeadlineExceeded once the test
+// releases it, like a lookup running out of the time of its admission, and the others with its
+// entry.
+type expiringExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	entry   *model.ExecutionCache
+	started chan struct{}
+	release chan struct{}
+	lookups int32
+}
+
+func (s *expiringExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if atomic.AddInt32(&s.lookups, 1) > 1 {
+		return s.entry, nil
+	}
+	close(s.started)
+	<-s.release
+	return nil, context.DeadlineExceeded
+}
+
+func TestLookupCoalescerRetriesLookupOfExpiredAdmission(t *testing.T) {
+	coalescer := newTestLookupCoalescer(&fixedClock{now: time.Unix(1000, 0)})
+	entry := &model.ExecutionCache{ExecutionCacheKey: "key1"}
+	store := &expiringExecutionCacheStore{entry: entry, started: make(chan struct{}), release: make(chan struct{})}
+	// The admission starting the lookup still has time when its lookup fails: it must not look
+	// the key up again.
+	startingCtx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var startingErr error
+	go func() {
+		defer wg.Done()
+		_, startingErr = coalescer.coalesced(store, "ns1").GetExecutionCache(startingCtx, "key1", -1, storage.ExecutionCacheFilter{})
+	}()
+	<-store.started
+
+	// Whether it shares the failed lookup or comes after it, the admission without deadline gets
+	// the entry with a lookup of its own.
+	lookupDone := make(chan struct{})
+	var longEntry *model.ExecutionCache
+	var err error
+	go func() {
+		defer close(lookupDone)
+		longEntry, err = coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+	}()
+	close(store.release)
+	wg.Wait()
+	<-lookupDone
+
+	assert.Equal(t, context.DeadlineExceeded, startingErr)
+	require.Nil(t, err)
+	assert.Equal(t, entry, longEntry)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.lookups))
+}
+
+func TestOutlivesSharedLookup(t *testing.T) {
+	lookupDeadline := time.Now().Add(time.Hour)
+	later, cancelLater := context.WithDeadline(context.Background(), lookupDeadline.Add(time.Minute))
+	defer cancelLater()
+	same, cancelSame := context.WithDeadline(context.Background(), lookupDeadline)
+	defer cancelSame()
+	done, cancelDone := context.WithCancel(context.Background())
+	cancelDone()
+
+	assert.True(t, outlivesSharedLookup(context.Background(), lookupDeadline, context.DeadlineExceeded), "no deadline of its own")
+	assert.True(t, outlivesSharedLookup(later, lookupDeadline, context.DeadlineExceeded))
+	assert.True(t, outlivesSharedLookup(later, lookupDeadline, context.Canceled))
+	assert.False(t, outlivesSharedLookup(same, lookupDeadline, context.DeadlineExceeded), "the same deadline, whether or not it fired yet")
+	assert.False(t, outlivesSharedLookup(done, lookupDeadline, context.DeadlineExceeded))
+	assert.False(t, outlivesSharedLookup(later, lookupDeadline, errors.New("connection refused")))
+	assert.False(t, outlivesSharedLookup(later, lookupDeadline, nil))
+}
+
+// benchmarkLookupBurst looks the key of a fan-out step up for 100 pods admitted at once, as the
+// webhook does, and reports the store queries per burst.
+func benchmarkLookupBurst(b *testing.B, lookup func(store storage.ExecutionCacheStoreInterface) error) {
+	const pods = 100
+	store := &countingExecutionCacheStore{delay: time.Millisecond}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var wg sync.WaitGroup
+		for i := 0; i < pods; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := lookup(store); !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	b.ReportMetric(float64(atomic.LoadInt32(&store.lookups))/float64(b.N), "queries/burst")
+}
+
+func BenchmarkLookupBurst(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		benchmarkLookupBurst(b, func(store storage.ExecutionCacheStoreInterface) error {
+			_, err := store.GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+			return err
+		})
+	})
+	b.Run("coalesced", func(b *testing.B) {
+		coalescer := NewLookupCoalescer(0, util.NewRealTime(), prometheus.NewRegistry())
+		benchmarkLookupBurst(b, func(store storage.ExecutionCacheStoreInterface) error {
+			_, err := coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+			return err
+		})
+	})
+	b.Run("coalesced with memoized misses", func(b *testing.B) {
+		// The bursts following the first one within the miss TTL do not query the store.
+		coalescer := NewLookupCoalescer(DefaultLookupMissTTL, util.NewRealTime(), prometheus.NewRegistry())
+		benchmarkLookupBurst(b, func(store storage.ExecutionCacheStoreInterface) error {
+			_, err := coalescer.coalesced(store, "ns1").GetExecutionCache(context.Background(), "key1", -1, storage.ExecutionCacheFilter{})
+			return err
+		})
+	})
+}
@@ -0,0 +1,558 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	MetricsAPI string = "/metrics"
+
+	AdmissionOutcomeHit           string = "hit"
+	AdmissionOutcomeMiss          string = "miss"
+	AdmissionOutcomeSkippedNotKFP string = "skipped_not_kfp"
+	AdmissionOutcomeSkippedTFX    string = "skipped_tfx"
+	AdmissionOutcomeError         string = "error"
+	// AdmissionOutcomeDeadlineExceeded is a pod admitted uncached because its cache lookup did not
+	// finish within the admission deadline.
+	AdmissionOutcomeDeadlineExceeded string = "deadline_exceeded"
+	// AdmissionOutcomeSkippedCircuitOpen is a pod admitted uncached without lookup because the
+	// cache store failed repeatedly.
+	AdmissionOutcomeSkippedCircuitOpen string = "skipped_circuit_open"
+	// AdmissionOutcomeSkippedNamespace is a pod admitted uncached without lookup because its
+	// namespace is not served from cache, see NamespaceFilter.
+	AdmissionOutcomeSkippedNamespace string = "skipped_namespace"
+	// AdmissionOutcomeSkippedUnresolvedImage is a pod admitted uncached without lookup because the
+	// digest of one of its images could not be resolved, see ImageDigestResolver.
+	AdmissionOutcomeSkippedUnresolvedImage string = "skipped_unresolved_image"
+	// AdmissionOutcomeShadowHit is a pod found in cache but admitted unchanged under
+	// CacheModeShadow.
+	AdmissionOutcomeShadowHit string = "shadow_hit"
+)
+
+// MutationMetrics records what MutatePodIfCached did with each admission. Implementations must
+// not label by pod or cache key, which would make the number of series unbounded. Node names are
+// reduced to a bounded set of template labels.
+type MutationMetrics interface {
+	AdmissionHandled(outcome string)
+	PatchesEmitted(count int)
+	KeyGenerationFailed()
+	// CacheHit records a lookup of the step named by the Argo node name served from cache with
+	// outputBytes of outputs, saving the computeSaved the reused execution took.
+	CacheHit(nodeName string, outputBytes int, computeSaved time.Duration)
+	CacheMissed(nodeName string)
+	// HandlerPanicked records a panic recovered while handling a request.
+	HandlerPanicked()
+	// AdmissionPhaseCompleted records the time an admission spent in one of the AdmissionPhase
+	// phases, labeled with its decision, one of the AdmissionDecision values.
+	AdmissionPhaseCompleted(phase string, decision string, duration time.Duration)
+	// AdmissionCompleted records the time the webhook took to answer an admission request.
+	AdmissionCompleted(decision string, duration time.Duration)
+	// CacheFieldsFlagged records a pod whose cache fields were not issued by the webhook, admitted
+	// or rejected under the validation mode.
+	CacheFieldsFlagged(mode string)
+	// RemoteEntryVerified records the verification of the artifacts of an entry recorded in
+	// another cluster, with one of the RemoteEntryOutcome outcomes.
+	RemoteEntryVerified(outcome string)
+	// WorkflowMarked records the admission of a workflow by the workflow marking webhook, with one
+	// of the WorkflowMarkingOutcome outcomes.
+	WorkflowMarked(outcome string)
+	// WorkflowTemplatePredicted records the prediction of a container template of an admitted
+	// workflow, one of the CachePrediction values.
+	WorkflowTemplatePredicted(prediction string)
+	// PredictionChecked records how the prediction of a marked template compared with the lookup
+	// of its pod, one of the PredictionCheck results.
+	PredictionChecked(result string)
+}
+
+type noopMutationMetrics struct{}
+
+func (noopMutationMetrics) AdmissionHandled(string)                               {}
+func (noopMutationMetrics) PatchesEmitted(int)                                    {}
+func (noopMutationMetrics) KeyGenerationFailed()                                  {}
+func (noopMutationMetrics) CacheHit(string, int, time.Duration)                   {}
+func (noopMutationMetrics) CacheMissed(string)                                    {}
+func (noopMutationMetrics) HandlerPanicked()                                      {}
+func (noopMutationMetrics) AdmissionPhaseCompleted(string, string, time.Duration) {}
+func (noopMutationMetrics) AdmissionCompleted(string, time.Duration)              {}
+func (noopMutationMetrics) CacheFieldsFlagged(string)                             {}
+func (noopMutationMetrics) RemoteEntryVerified(string)                            {}
+func (noopMutationMetrics) WorkflowMarked(string)                                 {}
+func (noopMutationMetrics) WorkflowTemplatePredicted(string)                      {}
+func (noopMutationMetrics) PredictionChecked(string)                              {}
+
+type prometheusMutationMetrics struct {
+	admissions          *prometheus.CounterVec
+	patches             prometheus.Counter
+	keyGenerationErrors prometheus.Counter
+	templates           *templateLabels
+	templateHits        *prometheus.CounterVec
+	templateMisses      *prometheus.CounterVec
+	templateServedBytes *prometheus.CounterVec
+	computeSaved        *prometheus.CounterVec
+	panics              prometheus.Counter
+	phaseDurations      *prometheus.HistogramVec
+	admissionDurations  *prometheus.HistogramVec
+	flaggedPods         *prometheus.CounterVec
+	remoteVerifications *prometheus.CounterVec
+	markedWorkflows     *prometheus.CounterVec
+	predictedTemplates  *prometheus.CounterVec
+	predictionChecks    *prometheus.CounterVec
+}
+
+func (m *prometheusMutationMetrics) AdmissionHandled(outcome string) {
+	m.admissions.WithLabelValues(outcome).Inc()
+}
+
+func (m *prometheusMutationMetrics) PatchesEmitted(count int) {
+	m.patches.Add(float64(count))
+}
+
+func (m *prometheusMutationMetrics) KeyGenerationFailed() {
+	m.keyGenerationErrors.Inc()
+}
+
+func (m *prometheusMutationMetrics) CacheHit(nodeName string, outputBytes int, computeSaved time.Duration) {
+	template := m.templates.label(nodeName)
+	m.templateHits.WithLabelValues(template).Inc()
+	m.templateServedBytes.WithLabelValues(template).Add(float64(outputBytes))
+	m.computeSaved.WithLabelValues(template).Add(computeSaved.Seconds())
+}
+
+func (m *prometheusMutationMetrics) CacheMissed(nodeName string) {
+	m.templateMisses.WithLabelValues(m.templates.label(nodeName)).Inc()
+}
+
+func (m *prometheusMutationMetrics) HandlerPanicked() {
+	m.panics.Inc()
+}
+
+func (m *prometheusMutationMetrics) AdmissionPhaseCompleted(phase string, decision string, duration time.Duration) {
+	m.phaseDurations.WithLabelValues(phase, decision).Observe(duration.Seconds())
+}
+
+func (m *prometheusMutationMetrics) AdmissionCompleted(decision string, duration time.Duration) {
+	m.admissionDurations.WithLabelValues(decision).Observe(duration.Seconds())
+}
+
+func (m *prometheusMutationMetrics) CacheFieldsFlagged(mode string) {
+	m.flaggedPods.WithLabelValues(mode).Inc()
+}
+
+func (m *prometheusMutationMetrics) RemoteEntryVerified(outcome string) {
+	m.remoteVerifications.WithLabelValues(outcome).Inc()
+}
+
+func (m *prometheusMutationMetrics) WorkflowMarked(outcome string) {
+	m.markedWorkflows.WithLabelValues(outcome).Inc()
+}
+
+func (m *prometheusMutationMetrics) WorkflowTemplatePredicted(prediction string) {
+	m.predictedTemplates.WithLabelValues(prediction).Inc()
+}
+
+func (m *prometheusMutationMetrics) PredictionChecked(result string) {
+	m.predictionChecks.WithLabelValues(result).Inc()
+}
+
+// admissionDurationBuckets span the admissions answered from memory in a millisecond up to those
+// running into the 5s admission deadline of the manifests.
+var admissionDurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// factory function for mutation metrics exported to the registerer, labeling at most
+// maxTemplateLabels templates by name
+func NewPrometheusMutationMetrics(registerer prometheus.Registerer, maxTemplateLabels int) MutationMetrics {
+	m := &prometheusMutationMetrics{
+		admissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_admission_requests_total",
+			Help: "Pod admissions handled by the cache webhook by outcome: hit, miss, skipped_not_kfp, skipped_tfx, error, deadline_exceeded, skipped_circuit_open, skipped_namespace, skipped_unresolved_image or shadow_hit.",
+		}, []string{"outcome"}),
+		patches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_admission_patches_total",
+			Help: "JSON patch operations emitted by the cache webhook.",
+		}),
+		keyGenerationErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_key_generation_failures_total",
+			Help: "Pods whose cache key could not be computed from their Argo template.",
+		}),
+		templates: newTemplateLabels(maxTemplateLabels),
+		templateHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_template_hits_total",
+			Help: "Cache lookups served from cache by Argo template. Templates beyond the label limit are counted as other.",
+		}, []string{"template"}),
+		templateMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_template_misses_total",
+			Help: "Cache lookups not served from cache by Argo template. Templates beyond the label limit are counted as other.",
+		}, []string{"template"}),
+		templateServedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_template_served_bytes_total",
+			Help: "Bytes of outputs served from cache by Argo template. Templates beyond the label limit are counted as other.",
+		}, []string{"template"}),
+		computeSaved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_compute_seconds_saved_total",
+			Help: "Execution time of the cached entries reused by cache hits, by Argo template. Entries recorded without their execution time count as zero. Templates beyond the label limit are counted as other.",
+		}, []string{"template"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_handler_panics_total",
+			Help: "Panics recovered while handling requests. Admissions are allowed unchanged after a panic.",
+		}),
+		phaseDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_admission_phase_duration_seconds",
+			Help:    "Time admissions spent deserializing, generating the cache key, looking it up and building the patch, by phase and decision: hit, miss, skip or error.",
+			Buckets: admissionDurationBuckets,
+		}, []string{"phase", "decision"}),
+		admissionDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_admission_duration_seconds",
+			Help:    "Time the webhook took to answer admission requests, by decision: hit, miss, skip or error.",
+			Buckets: admissionDurationBuckets,
+		}, []string{"decision"}),
+		flaggedPods: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_unissued_cache_fields_total",
+			Help: "Pods carrying a cache ID label or execution key annotation not issued by the cache webhook, by validation mode: warn admits them and enforce rejects them.",
+		}, []string{"mode"}),
+		remoteVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_remote_entry_verifications_total",
+			Help: "Cache entries recorded in other clusters whose artifacts were verified before reuse, by outcome: live, missing or failed. Missing and failed entries are not reused.",
+		}, []string{"outcome"}),
+		markedWorkflows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_workflow_admissions_total",
+			Help: "Workflows admitted by the workflow marking webhook, by outcome: marked, skipped or error.",
+		}, []string{"outcome"}),
+		predictedTemplates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_workflow_template_predictions_total",
+			Help: "Container templates of the admitted workflows, by prediction: hit, miss or unpredictable.",
+		}, []string{"prediction"}),
+		predictionChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_workflow_prediction_checks_total",
+			Help: "Pods of marked templates whose lookup was compared with the prediction, by result: correct_hit, correct_miss, false_hit, false_miss or key_mismatch.",
+		}, []string{"result"}),
+	}
+	for _, collector := range []prometheus.Collector{m.admissions, m.patches, m.keyGenerationErrors,
+		m.templateHits, m.templateMisses, m.templateServedBytes, m.computeSaved, m.panics, m.phaseDurations, m.admissionDurations, m.flaggedPods,
+		m.remoteVerifications, m.markedWorkflows, m.predictedTemplates, m.predictionChecks} {
+		if err := registerer.Register(collector); err != nil {
+			logger.Errorf("Failed to register mutation metrics: %v", err)
+		}
+	}
+	// Export every outcome from the start so that rates are defined before the first admission.
+	for _, outcome := range []string{AdmissionOutcomeHit, AdmissionOutcomeMiss, AdmissionOutcomeSkippedNotKFP,
+		AdmissionOutcomeSkippedTFX, AdmissionOutcomeError, AdmissionOutcomeDeadlineExceeded, AdmissionOutcomeSkippedCircuitOpen,
+		AdmissionOutcomeSkippedNamespace, AdmissionOutcomeSkippedUnresolvedImage, AdmissionOutcomeShadowHit} {
+		m.admissions.WithLabelValues(outcome)
+	}
+	return m
+}
+
+// WatcherMetrics records what the watcher did with the completed pods. Like MutationMetrics, it
+// must not label by pod.
+type WatcherMetrics interface {
+	// PodSkipped records a completed pod whose outputs were not recorded, for one of the
+	// PodSkipReason reasons.
+	PodSkipped(reason string)
+	// EntryCreated records a cache entry created for a pod, sinceCompletion after the pod
+	// completed. sinceCompletion is negative when the pod does not report when it completed.
+	EntryCreated(sinceCompletion time.Duration)
+	// DuplicateSkipped records a pod whose entry was already created, e.g. before a restart of
+	// the watcher, so that none was created again.
+	DuplicateSkipped()
+	// StoreWriteFailed records a failure to create the entry of a pod, which is retried.
+	StoreWriteFailed()
+	// WriteDropped records an entry dropped once its writes failed too many times.
+	WriteDropped()
+	// PatchFailed records a failure to label a recorded pod with its cache ID.
+	PatchFailed()
+	// AddQueuedPods adds delta to the number of pods waiting to be recorded.
+	AddQueuedPods(delta int)
+	// WriteCollapsed records a pod whose entry was pending for another pod of the same cache key,
+	// so that it is labeled with the ID of that entry instead of being written.
+	WriteCollapsed()
+	// AddPendingWrites adds delta to the number of entries waiting to be written.
+	AddPendingWrites(delta int)
+	// WriteQueueOverflowed records a pod left for the next resync because too many entries were
+	// waiting to be written.
+	WriteQueueOverflowed()
+	// SetLeading records whether the replica leads the watchers when electing a leader.
+	SetLeading(leading bool)
+	// SetNamespaceUsage records what the entries of the namespace take, and its quota.
+	SetNamespaceUsage(namespace string, usage storage.NamespaceUsage, quota NamespaceQuota)
+	// EntriesEvicted records entries of the namespace evicted to keep it within its quota.
+	EntriesEvicted(namespace string, count int)
+	// SetStoreUsage records what all the entries of the store take, and the bound of the store.
+	SetStoreUsage(usage storage.NamespaceUsage, quota NamespaceQuota)
+	// StoreEntriesEvicted records entries evicted to keep the store within its bound.
+	StoreEntriesEvicted(count int)
+	// CachedExecutionHandled records an attempt to record the execution of a pod served from
+	// cache in ML Metadata, with one of the CachedExecution outcomes.
+	CachedExecutionHandled(outcome string)
+	// EntryScrubbed records a cache entry whose artifacts the artifact scrubber checked, with one
+	// of the ScrubOutcome outcomes.
+	EntryScrubbed(outcome string)
+}
+
+type noopWatcherMetrics struct{}
+
+func (noopWatcherMetrics) PodSkipped(string)          {}
+func (noopWatcherMetrics) EntryCreated(time.Duration) {}
+func (noopWatcherMetrics) DuplicateSkipped()          {}
+func (noopWatcherMetrics) StoreWriteFailed()          {}
+func (noopWatcherMetrics) WriteDropped()              {}
+func (noopWatcherMetrics) PatchFailed()               {}
+func (noopWatcherMetrics) AddQueuedPods(int)          {}
+func (noopWatcherMetrics) WriteCollapsed()            {}
+func (noopWatcherMetrics) AddPendingWrites(int)       {}
+func (noopWatcherMetrics) WriteQueueOverflowed()      {}
+func (noopWatcherMetrics) SetLeading(bool)            {}
+
+func (noopWatcherMetrics) SetNamespaceUsage(string, storage.NamespaceUsage, NamespaceQuota) {}
+func (noopWatcherMetrics) EntriesEvicted(string, int)                                       {}
+func (noopWatcherMetrics) SetStoreUsage(storage.NamespaceUsage, NamespaceQuota)             {}
+func (noopWatcherMetrics) StoreEntriesEvicted(int)                                          {}
+
+func (noopWatcherMetrics) CachedExecutionHandled(string) {}
+func (noopWatcherMetrics) EntryScrubbed(string)          {}
+
+type prometheusWatcherMetrics struct {
+	skippedPods      *prometheus.CounterVec
+	createdEntries   prometheus.Counter
+	duplicateEntries prometheus.Counter
+	storeWriteErrors prometheus.Counter
+	droppedWrites    prometheus.Counter
+	patchFailures    prometheus.Counter
+	recordLatencies  prometheus.Histogram
+	queuedPods       prometheus.Gauge
+	collapsedWrites  prometheus.Counter
+	pendingWrites    prometheus.Gauge
+	queueOverflows   prometheus.Counter
+	leader           prometheus.Gauge
+	namespaceEntries *prometheus.GaugeVec
+	namespaceBytes   *prometheus.GaugeVec
+	quotaEntries     *prometheus.GaugeVec
+	quotaBytes       *prometheus.GaugeVec
+	evictedEntries   *prometheus.CounterVec
+	storeEntries     prometheus.Gauge
+	storeBytes       prometheus.Gauge
+	storeMaxEntries  prometheus.Gauge
+	storeMaxBytes    prometheus.Gauge
+	storeEvictions   prometheus.Counter
+	// cachedExecutions counts the cached executions recorded in ML Metadata by outcome.
+	cachedExecutions *prometheus.CounterVec
+	// scrubbedEntries counts the entries checked by the artifact scrubber by outcome.
+	scrubbedEntries *prometheus.CounterVec
+}
+
+func (m *prometheusWatcherMetrics) PodSkipped(reason string) {
+	m.skippedPods.WithLabelValues(reason).Inc()
+}
+
+func (m *prometheusWatcherMetrics) EntryCreated(sinceCompletion time.Duration) {
+	m.createdEntries.Inc()
+	if sinceCompletion >= 0 {
+		m.recordLatencies.Observe(sinceCompletion.Seconds())
+	}
+}
+
+func (m *prometheusWatcherMetrics) DuplicateSkipped() {
+	m.duplicateEntries.Inc()
+}
+
+func (m *prometheusWatcherMetrics) StoreWriteFailed() {
+	m.storeWriteErrors.Inc()
+}
+
+func (m *prometheusWatcherMetrics) WriteDropped() {
+	m.droppedWrites.Inc()
+}
+
+func (m *prometheusWatcherMetrics) PatchFailed() {
+	m.patchFailures.Inc()
+}
+
+func (m *prometheusWatcherMetrics) AddQueuedPods(delta int) {
+	m.queuedPods.Add(float64(delta))
+}
+
+func (m *prometheusWatcherMetrics) WriteCollapsed() {
+	m.collapsedWrites.Inc()
+}
+
+func (m *prometheusWatcherMetrics) AddPendingWrites(delta int) {
+	m.pendingWrites.Add(float64(delta))
+}
+
+func (m *prometheusWatcherMetrics) WriteQueueOverflowed() {
+	m.queueOverflows.Inc()
+}
+
+func (m *prometheusWatcherMetrics) SetLeading(leading bool) {
+	if leading {
+		m.leader.Set(1)
+	} else {
+		m.leader.Set(0)
+	}
+}
+
+func (m *prometheusWatcherMetrics) SetNamespaceUsage(namespace string, usage storage.NamespaceUsage, quota NamespaceQuota) {
+	m.namespaceEntries.WithLabelValues(namespace).Set(float64(usage.Entries))
+	m.namespaceBytes.WithLabelValues(namespace).Set(float64(usage.OutputBytes))
+	m.quotaEntries.WithLabelValues(namespace).Set(float64(quota.MaxEntries))
+	m.quotaBytes.WithLabelValues(namespace).Set(float64(quota.MaxOutputBytes))
+}
+
+func (m *prometheusWatcherMetrics) EntriesEvicted(namespace string, count int) {
+	m.evictedEntries.WithLabelValues(namespace).Add(float64(count))
+}
+
+func (m *prometheusWatcherMetrics) SetStoreUsage(usage storage.NamespaceUsage, quota NamespaceQuota) {
+	m.storeEntries.Set(float64(usage.Entries))
+	m.storeBytes.Set(float64(usage.OutputBytes))
+	m.storeMaxEntries.Set(float64(quota.MaxEntries))
+	m.storeMaxBytes.Set(float64(quota.MaxOutputBytes))
+}
+
+func (m *prometheusWatcherMetrics) StoreEntriesEvicted(count int) {
+	m.storeEvictions.Add(float64(count))
+}
+
+func (m *prometheusWatcherMetrics) CachedExecutionHandled(outcome string) {
+	m.cachedExecutions.WithLabelValues(outcome).Inc()
+}
+
+func (m *prometheusWatcherMetrics) EntryScrubbed(outcome string) {
+	m.scrubbedEntries.WithLabelValues(outcome).Inc()
+}
+
+// recordLatencyBuckets span the pods recorded as soon as they complete up to those recorded once
+// the watcher caught up after a restart.
+var recordLatencyBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// factory function for watcher metrics exported to the registerer
+func NewPrometheusWatcherMetrics(registerer prometheus.Registerer) WatcherMetrics {
+	m := &prometheusWatcherMetrics{
+		skippedPods: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_watcher_skipped_pods_total",
+			Help: "Completed pods whose outputs were not recorded by reason: failed, evicted, oom_killed, main_failed, already_cached, workflow_deleted, no_outputs, invalid_outputs or not_cached.",
+		}, []string{"reason"}),
+		createdEntries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_entries_created_total",
+			Help: "Cache entries created for completed pods.",
+		}),
+		duplicateEntries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_duplicate_entries_skipped_total",
+			Help: "Completed pods whose cache entry already existed, so that none was created again.",
+		}),
+		storeWriteErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_store_write_errors_total",
+			Help: "Failures to create the cache entry of a completed pod. Failed writes are retried with a backoff.",
+		}),
+		droppedWrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_dropped_writes_total",
+			Help: "Cache entries dropped once their writes failed more than the retry limit. Their cache keys are logged for backfilling.",
+		}),
+		patchFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_patch_failures_total",
+			Help: "Failures to label a recorded pod with the ID of its cache entry.",
+		}),
+		recordLatencies: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cache_watcher_record_latency_seconds",
+			Help:    "Time from the completion of a pod to the creation of its cache entry.",
+			Buckets: recordLatencyBuckets,
+		}),
+		queuedPods: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_watcher_queue_depth",
+			Help: "Pods notified to the watcher and waiting to be recorded.",
+		}),
+		collapsedWrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_collapsed_writes_total",
+			Help: "Completed pods labeled with the entry pending for another pod of the same cache key, instead of writing their own.",
+		}),
+		pendingWrites: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_watcher_pending_writes",
+			Help: "Cache entries waiting to be written by the watcher, including those being retried.",
+		}),
+		queueOverflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_watcher_write_queue_overflows_total",
+			Help: "Completed pods left for the next resync because WATCHER_WRITE_QUEUE_SIZE entries were waiting to be written.",
+		}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_watcher_leader",
+			Help: "1 while the replica leads the watchers, 0 while it stands by. Only set when LEADER_ELECTION is enabled.",
+		}),
+		namespaceEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cache_namespace_entries",
+			Help: "Cache entries of the namespace, as of its latest entry. Only set when namespace quotas are enforced.",
+		}, []string{"namespace"}),
+		namespaceBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cache_namespace_output_bytes",
+			Help: "Output bytes of the cache entries of the namespace, as of its latest entry. Only set when namespace quotas are enforced.",
+		}, []string{"namespace"}),
+		quotaEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cache_namespace_quota_entries",
+			Help: "Cache entries the namespace may keep, 0 when unbounded.",
+		}, []string{"namespace"}),
+		quotaBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cache_namespace_quota_output_bytes",
+			Help: "Output bytes the cache entries of the namespace may take, 0 when unbounded.",
+		}, []string{"namespace"}),
+		evictedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_namespace_evicted_entries_total",
+			Help: "Cache entries of the namespace evicted under the eviction policy to keep it within its quota.",
+		}, []string{"namespace"}),
+		storeEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_store_entries",
+			Help: "Cache entries of the store, as of the latest entry. Only set when the store is bounded.",
+		}),
+		storeBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_store_output_bytes",
+			Help: "Output bytes of the cache entries of the store, as of the latest entry. Only set when the store is bounded.",
+		}),
+		storeMaxEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_store_max_entries",
+			Help: "Cache entries the store may keep, 0 when unbounded.",
+		}),
+		storeMaxBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_store_max_output_bytes",
+			Help: "Output bytes the cache entries of the store may take, 0 when unbounded.",
+		}),
+		storeEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_store_evicted_entries_total",
+			Help: "Cache entries evicted under the eviction policy to keep the store within its bound.",
+		}),
+		cachedExecutions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_mlmd_cached_executions_total",
+			Help: "Attempts to record the executions of the pods served from cache in ML Metadata by outcome: recorded, already_recorded, failed and retried, or dropped.",
+		}, []string{"outcome"}),
+		scrubbedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_scrubber_entries_total",
+			Help: "Cache entries checked by the artifact scrubber by outcome: live, deleted for missing artifacts, unchecked for having no S3 artifact, or failed.",
+		}, []string{"outcome"}),
+	}
+	for _, collector := range []prometheus.Collector{m.skippedPods, m.createdEntries, m.duplicateEntries,
+		m.storeWriteErrors, m.droppedWrites, m.patchFailures, m.recordLatencies, m.queuedPods, m.collapsedWrites, m.pendingWrites, m.queueOverflows, m.leader,
+		m.namespaceEntries, m.namespaceBytes, m.quotaEntries, m.quotaBytes, m.evictedEntries,
+		m.storeEntries, m.storeBytes, m.storeMaxEntries, m.storeMaxBytes, m.storeEvictions, m.cachedExecutions, m.scrubbedEntries} {
+		if err := registerer.Register(collector); err != nil {
+			logger.Errorf("Failed to register watcher metrics: %v", err)
+		}
+	}
+	for _, reason := range []string{PodSkipReasonFailed, PodSkipReasonEvicted, PodSkipReasonOOMKilled, PodSkipReasonMainFailed,
+		PodSkipReasonAlreadyCached, PodSkipReasonWorkflowDeleted, PodSkipReasonNoOutputs, PodSkipReasonInvalidOutputs, PodSkipReasonNotCached} {
+		m.skippedPods.WithLabelValues(reason)
+	}
+	return m
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failingExecutionCacheStore fails every lookup.
+type failingExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+}
+
+func (failingExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestMutatePodIfCachedRecordsMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	admitPod := func(pod *corev1.Pod) {
+		_, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+		require.Nil(t, err)
+	}
+
+	serviceRequest := fakeAdmissionRequest
+	serviceRequest.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "services"}
+	_, err := webhook.MutatePodIfCached(context.Background(), &serviceRequest, clientManager)
+	require.Nil(t, err)
+	cacheDisabledPod := fakePod.DeepCopy()
+	cacheDisabledPod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey] = "false"
+	admitPod(cacheDisabledPod)
+	tfxPod := fakePod.DeepCopy()
+	tfxPod.Spec.Containers[0].Command = append(tfxPod.Spec.Containers[0].Command, "/tfx-src/"+TFXPodSuffix)
+	admitPod(tfxPod)
+	invalidTemplatePod := fakePod.DeepCopy()
+	invalidTemplatePod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = "not json"
+	admitPod(invalidTemplatePod)
+	admitPod(fakePod)
+	clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	admitPod(fakePod)
+	clientManager.cacheStore = failingExecutionCacheStore{}
+	admitPod(fakePod)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeHit)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeMiss)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeSkippedNotKFP)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeSkippedTFX)))
+	// The unexpected resource, the invalid template and the failed lookup.
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeError)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.keyGenerationErrors))
+	// 2 patches for each miss or failed lookup and 3 for the hit.
+	assert.Equal(t, float64(7), testutil.ToFloat64(metrics.patches))
+	// The failed lookup is neither a hit nor a miss.
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.templateHits.WithLabelValues("test_node")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.templateMisses.WithLabelValues("test_node")))
+	assert.Equal(t, float64(len(`{"parameters":[{"name":"message","value":"Hello"}]}`)), testutil.ToFloat64(metrics.templateServedBytes.WithLabelValues("test_node")))
+}
+
+func TestTemplateMetricsCountServedBytesAndCapLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, 2).(*prometheusMutationMetrics)
+
+	metrics.CacheHit("wf-x7k2p.train(0:a)", 10, 0)
+	metrics.CacheHit("wf-x7k2p.train(1:b)", 5, 0)
+	metrics.CacheMissed("wf-x7k2p.evaluate")
+	metrics.CacheMissed("wf-x7k2p.deploy")
+	metrics.CacheHit("wf-x7k2p.deploy", 3, 0)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.templateHits.WithLabelValues("train")))
+	assert.Equal(t, float64(15), testutil.ToFloat64(metrics.templateServedBytes.WithLabelValues("train")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.templateMisses.WithLabelValues("evaluate")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.templateMisses.WithLabelValues(TemplateLabelOther)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.templateHits.WithLabelValues(TemplateLabelOther)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.templateServedBytes.WithLabelValues(TemplateLabelOther)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.templateMisses.WithLabelValues("deploy")))
+}
+
+func TestMutatePodIfCachedCountsComputeSaved(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	createEntry := func(executionDurationInSec int64) {
+		_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey:      "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+			ExecutionOutput:        testExecutionOutput,
+			ExecutionTemplate:      `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+			MaxCacheStaleness:      -1,
+			ExecutionDurationInSec: executionDurationInSec,
+		})
+		require.Nil(t, err)
+	}
+	admitCachedPod := func() string {
+		patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(fakePod), clientManager)
+		require.Nil(t, err)
+		for _, patch := range patches {
+			if patch.Path == AnnotationPath {
+				return patch.Value.(map[string]string)[podKeys.ComputeSecondsSavedKey]
+			}
+		}
+		t.Fatal("the pod is not annotated")
+		return ""
+	}
+
+	createEntry(90)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "90", admitCachedPod())
+	}
+	assert.Equal(t, float64(270), testutil.ToFloat64(metrics.computeSaved.WithLabelValues("test_node")))
+
+	// Entries recorded without their execution time save none.
+	createEntry(0)
+	assert.Equal(t, "0", admitCachedPod())
+	assert.Equal(t, float64(270), testutil.ToFloat64(metrics.computeSaved.WithLabelValues("test_node")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.templateHits.WithLabelValues("test_node")))
+}
+
+func TestTemplateMetricsCountComputeSavedUnderTheTemplateLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, 1).(*prometheusMutationMetrics)
+
+	metrics.CacheHit("wf-x7k2p.train(0:a)", 1, time.Minute)
+	metrics.CacheHit("wf-x7k2p.train(1:b)", 1, 30*time.Second)
+	metrics.CacheHit("wf-x7k2p.deploy", 1, time.Hour)
+
+	assert.Equal(t, float64(90), testutil.ToFloat64(metrics.computeSaved.WithLabelValues("train")))
+	assert.Equal(t, float64(3600), testutil.ToFloat64(metrics.computeSaved.WithLabelValues(TemplateLabelOther)))
+}
+
+func TestMutationMetricsHaveNoHighCardinalityLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels)
+	metrics.CacheHit("wf-x7k2p.train", 1, time.Minute)
+	metrics.CacheMissed("wf-x7k2p.train")
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	require.NotEmpty(t, families)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				assert.Contains(t, []string{"outcome", "template", "phase", "decision"}, label.GetName(), family.GetName())
+			}
+		}
+	}
+}
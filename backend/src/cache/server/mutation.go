@@ -15,33 +15,34 @@
 package server
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
 	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
 	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	KFPCacheEnabledLabelKey   string = "pipelines.kubeflow.org/cache_enabled"
 	KFPCacheEnabledLabelValue string = "true"
-	KFPCachedLabelKey         string = "pipelines.kubeflow.org/reused_from_cache"
 	KFPCachedLabelValue       string = "true"
 	ArgoWorkflowNodeName      string = "workflows.argoproj.io/node-name"
 	ArgoWorkflowTemplate      string = "workflows.argoproj.io/template"
-	ExecutionKey              string = "pipelines.kubeflow.org/execution_cache_key"
-	CacheIDLabelKey           string = "pipelines.kubeflow.org/cache_id"
 	ArgoWorkflowOutputs       string = "workflows.argoproj.io/outputs"
-	MetadataWrittenKey        string = "pipelines.kubeflow.org/metadata_written"
 	AnnotationPath            string = "/metadata/annotations"
 	LabelPath                 string = "/metadata/labels"
 	SpecContainersPath        string = "/spec/containers"
@@ -49,107 +50,368 @@ const (
 	TFXPodSuffix              string = "tfx/orchestration/kubeflow/container_entrypoint.py"
 )
 
+// DefaultAdmissionDeadline leaves the API server, which gives up on the webhook after 10 seconds by
+// default, ample time to create the pod after a slow cache lookup.
+const DefaultAdmissionDeadline = 2 * time.Second
+
 var (
 	podResource = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
 )
 
+// MutationConfig holds the settings of the mutating webhook, and of the validating webhook checking
+// the cache fields it issued.
+type MutationConfig struct {
+	// EnforceOwner makes cache entries reusable only by the owner that produced them. Shared
+	// entries without owner remain reusable by everyone.
+	EnforceOwner bool
+	// MaxRequestBodyBytes bounds the AdmissionReview bodies read by the webhook. Zero means
+	// DefaultMaxRequestBodyBytes.
+	MaxRequestBodyBytes int64
+	// AdmissionDeadline bounds the time MutatePodIfCached spends on a pod. Pods whose cache lookup
+	// does not finish in time are admitted uncached. Zero means DefaultAdmissionDeadline.
+	AdmissionDeadline time.Duration
+	// FailPolicy is FailPolicyOpen or FailPolicyClosed and decides whether cache enabled pods are
+	// admitted uncached or rejected when the webhook fails on them, including when their cache
+	// lookup fails, times out or is skipped by the circuit breaker. Empty means FailPolicyOpen.
+	FailPolicy string
+	// LogCachedOutputs logs the outputs of cache hits at debug level, with the values of the
+	// parameters matching SensitiveParameterPatterns redacted. Otherwise only their size and
+	// shape are logged.
+	LogCachedOutputs           bool
+	SensitiveParameterPatterns []*regexp.Regexp
+	// EntryUses records the reuse of the cache entries for the namespace quotas to evict the least
+	// recently used ones. Nil does not record it.
+	EntryUses *EntryUseRecorder
+	// SignatureKeys sign the execution key and cache ID of the pods, for ValidatePodCacheFields to
+	// flag the pods whose cache fields were not issued by the webhook under ValidationMode. Nil or
+	// no key does not sign them.
+	SignatureKeys  *CacheSignatureKeys
+	ValidationMode string
+	// ClusterID identifies the cluster of the webhook among those sharing the cache store, and
+	// CrossCluster, one of the CrossCluster policies, which of their entries are reused. Empty
+	// means CrossClusterShared.
+	ClusterID    string
+	CrossCluster string
+	// RemoteArtifacts verifies that the artifacts of the entries recorded in other clusters still
+	// exist before they are reused. Nil does not verify them.
+	RemoteArtifacts ArtifactStore
+	// KeyVersion is the version of the key strategy of the pods that do not ask for one with
+	// their cache_key_version annotation. Empty means CacheKeyVersionV1.
+	KeyVersion string
+	// Namespaces selects the namespaces whose pods are served from cache. Nil serves them all.
+	Namespaces *NamespaceFilter
+	// IgnoredFields are removed from the templates before their cache key is generated. Nil keeps
+	// all fields.
+	IgnoredFields *IgnoredTemplateFields
+	// DummyContainer runs in place of the steps of the pods served from cache.
+	DummyContainer DummyContainer
+	// HitScheduling is how the pods served from cache are scheduled.
+	HitScheduling HitScheduling
+	// Mode is CacheModeActive or CacheModeShadow and decides whether the pods found in cache are
+	// served from it. Empty means CacheModeActive.
+	Mode string
+}
+
+// failsClosed reports whether the pods the webhook fails on are rejected.
+func (c MutationConfig) failsClosed() bool {
+	return c.FailPolicy == FailPolicyClosed && c.Mode != CacheModeShadow
+}
+
+// ClientManagerInterface hands out the clients shared by the webhook and the watchers. They may be
+// initialized on first use, and are safe for concurrent use.
 type ClientManagerInterface interface {
 	CacheStore() storage.ExecutionCacheStoreInterface
+	// DB is nil when the cache store is not backed by a relational database.
+	DB() *storage.DB
+	// RedisClient is nil when Redis is not configured.
+	RedisClient() *client.RedisClient
 	KubernetesCoreClient() client.KubernetesCoreInterface
+	// ArgoClient is nil when the workflows are not available, e.g. to the fake client manager.
+	ArgoClient() client.ArgoClientInterface
 }
 
-// MutatePodIfCached will check whether the execution has already been run before from MLMD and apply the output into pod.metadata.output
-func MutatePodIfCached(req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+// MutatePodIfCached looks up the cache entry of the pod's template in the cache store and, on a
+// hit, applies its outputs to the pod and replaces its containers with a dummy one. The executions
+// of the pods served from cache are recorded in ML Metadata by the watcher, see
+// CachedExecutionRecorder.
+func (wh *Webhook) MutatePodIfCached(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+	config := wh.mutationConfig()
+	deadline := config.AdmissionDeadline
+	if deadline <= 0 {
+		deadline = DefaultAdmissionDeadline
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
 	// This handler should only get called on Pod objects as per the MutatingWebhookConfiguration in the YAML file.
 	// However, if (for whatever reason) this gets invoked on an object of a different kind, issue a log message but
 	// let the object request pass through otherwise.
 	if req.Resource != podResource {
-		log.Printf("Expect resource to be %q, but found %q", podResource, req.Resource)
+		logging.WithContext(logger, ctx).Warnf("Expect resource to be %q, but found %q", podResource, req.Resource)
+		setDecisionReason(ctx, "unexpected resource %q", req.Resource)
+		wh.admissionHandled(ctx, AdmissionOutcomeError)
 		return nil, nil
 	}
 
 	// Parse the Pod object.
 	raw := req.Object.Raw
 	pod := corev1.Pod{}
-	if _, _, err := universalDeserializer.Decode(raw, nil, &pod); err != nil {
+	endDeserialize := startPhase(ctx, AdmissionPhaseDeserialize)
+	_, _, err := universalDeserializer.Decode(raw, nil, &pod)
+	endDeserialize()
+	if err != nil {
+		setDecisionReason(ctx, "could not deserialize pod object: %v", err)
+		wh.admissionHandled(ctx, AdmissionOutcomeError)
 		return nil, fmt.Errorf("could not deserialize pod object: %v", err)
 	}
 
-	// Pod filtering to only cache KFP argo pods except TFX pods
+	orchestrator := podOrchestratorOf(&pod)
+	nodeName := orchestrator.nodeName(&pod)
+	ctx = logging.ContextWithFields(ctx, logrus.Fields{
+		logging.FieldPod:       pod.ObjectMeta.Name,
+		logging.FieldNamespace: req.Namespace,
+		logging.FieldNodeName:  nodeName,
+	})
+	podLogger := logging.WithContext(logger, ctx)
+	auditEvent := auditEventFrom(ctx)
+	auditEvent.PodName = pod.ObjectMeta.Name
+	auditEvent.PodGenerateName = pod.ObjectMeta.GenerateName
+	auditEvent.NodeName = nodeName
+	auditEvent.CacheEnabled = pod.ObjectMeta.Labels[wh.keys.CacheEnabledLabelKey] == KFPCacheEnabledLabelValue
+	auditEvent.MaxCacheStaleness = pod.ObjectMeta.Annotations[wh.keys.MaxCacheStalenessKey]
+	auditEvent.EnforceOwner = config.EnforceOwner
+	auditEvent.FailPolicy = config.FailPolicy
+
+	// Pod filtering to only cache KFP Argo and Tekton pods, and TFX pods when their executions are
+	// restored in ML Metadata
 	// TODO: Switch to objectSelector once Kubernetes 1.15 hits the GKE stable channel. See
 	// https://github.com/kubernetes/kubernetes/pull/78505
 	// https://cloud.google.com/kubernetes-engine/docs/release-notes-stable
-	if !isKFPCacheEnabled(&pod) {
-		log.Printf("This pod %s does not enable cache.", pod.ObjectMeta.Name)
+	if !wh.keys.isKFPCacheEnabled(&pod) {
+		podLogger.WithField(logging.FieldDecision, AdmissionOutcomeSkippedNotKFP).Debug("Pod is not created by KFP or does not enable cache")
+		setDecisionReason(ctx, "pod is not created by KFP or does not enable cache")
+		wh.admissionHandled(ctx, AdmissionOutcomeSkippedNotKFP)
 		return nil, nil
 	}
 
-	if isTFXPod(&pod) {
-		log.Printf("This pod %s is created by tfx pipelines.", pod.ObjectMeta.Name)
+	if !config.Namespaces.Admits(req.Namespace) {
+		podLogger.WithField(logging.FieldDecision, AdmissionOutcomeSkippedNamespace).Debug("Pods of the namespace are not served from cache")
+		setDecisionReason(ctx, "pods of namespace %q are not served from cache", req.Namespace)
+		wh.admissionHandled(ctx, AdmissionOutcomeSkippedNamespace)
 		return nil, nil
 	}
 
+	if tfx, isTFX := orchestrator.(tfxPodOrchestrator); isTFX {
+		if wh.tfxExecutions == nil {
+			podLogger.WithField(logging.FieldDecision, AdmissionOutcomeSkippedTFX).Debug("Pod is created by TFX pipelines")
+			setDecisionReason(ctx, "pod is created by TFX pipelines")
+			wh.admissionHandled(ctx, AdmissionOutcomeSkippedTFX)
+			return nil, nil
+		}
+		tfx.executions = wh.tfxExecutions
+		orchestrator = tfx
+	}
+
 	var patches []patchOperation
 	annotations := pod.ObjectMeta.Annotations
+	if annotations == nil {
+		// Unlike Argo, Tekton may create pods without annotations.
+		annotations = map[string]string{}
+	}
 	labels := pod.ObjectMeta.Labels
-	template, exists := annotations[ArgoWorkflowTemplate]
+	template, exists := orchestrator.template(&pod)
 	var executionHashKey string
 	if !exists {
+		podLogger.WithField(logging.FieldDecision, AdmissionOutcomeSkippedNotKFP).Debug("Pod has no template")
+		setDecisionReason(ctx, "pod has no template")
+		wh.admissionHandled(ctx, AdmissionOutcomeSkippedNotKFP)
 		return patches, nil
 	}
 
-	// Generate the executionHashKey based on pod.metadata.annotations.workflows.argoproj.io/template
-	executionHashKey, err := generateCacheKeyFromTemplate(template)
-	log.Println(executionHashKey)
+	// Generate the executionHashKey based on the template, pod.metadata.annotations.workflows.argoproj.io/template
+	// for Argo pods.
+	_, keySpan := tracer.Start(ctx, tracing.SpanGenerateKey)
+	endGenerateKey := startPhase(ctx, AdmissionPhaseGenerateKey)
+	keyVersion := wh.keys.cacheKeyVersion(annotations, config.KeyVersion)
+	if !IsValidCacheKeyVersion(keyVersion) {
+		// Pods asking for a version this webhook does not know, e.g. one of a newer release, are
+		// keyed by the default version rather than rejected under the closed fail policy.
+		defaultVersion := wh.keys.cacheKeyVersion(nil, config.KeyVersion)
+		podLogger.Warnf("Unknown cache key version %q, using version %q", keyVersion, defaultVersion)
+		addAdmissionWarning(ctx, "unknown cache key version %q, the cache key of version %q is used", keyVersion, defaultVersion)
+		keyVersion = defaultVersion
+	}
+	executionHashKey, err = orchestrator.cacheKey(ctx, wh.templateKeys, keyVersion, config.IgnoredFields, template)
+	endGenerateKey()
+	keySpan.End()
+	if isUnresolvedImageDigest(err) {
+		// Pods of images whose registry cannot be read, e.g. private ones, are not cacheable
+		// rather than rejected under the closed fail policy.
+		podLogger.WithField(logging.FieldDecision, AdmissionOutcomeSkippedUnresolvedImage).Debugf("Pod is not cacheable: %v", err)
+		setDecisionReason(ctx, "pod is not cacheable: %v", err)
+		wh.admissionHandled(ctx, AdmissionOutcomeSkippedUnresolvedImage)
+		return patches, nil
+	}
 	if err != nil {
-		log.Printf("Unable to generate cache key for pod %s : %s", pod.ObjectMeta.Name, err.Error())
+		podLogger.WithField(logging.FieldDecision, AdmissionOutcomeError).Warnf("Unable to generate cache key: %v", err)
+		wh.metrics.KeyGenerationFailed()
+		setDecisionReason(ctx, "could not generate the cache key: %v", err)
+		wh.admissionHandled(ctx, AdmissionOutcomeError)
+		if config.failsClosed() {
+			return nil, fmt.Errorf("could not generate the cache key of the pod: %v", err)
+		}
+		addAdmissionWarning(ctx, "execution cache key could not be generated, step will run uncached: %v", err)
 		return patches, nil
 	}
 
-	annotations[ExecutionKey] = executionHashKey
-	labels[CacheIDLabelKey] = ""
-	var maxCacheStalenessInSeconds int64 = -1
-	maxCacheStaleness, exists := annotations[MaxCacheStalenessKey]
-	if exists {
-		maxCacheStalenessInSeconds = getMaxCacheStaleness(maxCacheStaleness)
-	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.AttributeCacheKey.String(executionHashKey))
+	auditEvent.CacheKey = executionHashKey
+	annotations[wh.keys.ExecutionKey] = executionHashKey
+	annotations[wh.keys.CacheKeyVersionKey] = keyVersion
+	labels[wh.keys.CacheIDLabelKey] = ""
+	maxCacheStalenessInSeconds := wh.keys.podMaxCacheStaleness(annotations)
 
 	var cachedExecution *model.ExecutionCache
-	cachedExecution, err = clientMgr.CacheStore().GetExecutionCache(executionHashKey, maxCacheStalenessInSeconds)
-	if err != nil {
-		log.Println(err.Error())
+	filter := storage.ExecutionCacheFilter{
+		EnforceOwner: config.EnforceOwner,
+		Owner:        wh.keys.getPodOwner(&pod, req.Namespace),
+		KeyVersion:   keyVersion,
+	}
+	auditEvent.Owner = filter.Owner
+	ctx = logging.ContextWithFields(ctx, logrus.Fields{logging.FieldCacheKey: executionHashKey})
+	podLogger = logging.WithContext(logger, ctx)
+	outcome := AdmissionOutcomeMiss
+	// lookupErr is set when the pod could not be looked up and would run uncached, lookupWarning
+	// tells the pod's creator so unless the API server gave up on the admission.
+	var lookupErr error
+	var lookupWarning string
+	if !wh.lookupCircuitBreaker.Allow() {
+		// The pod runs like a cache miss rather than waiting for a store that is down.
+		outcome = AdmissionOutcomeSkippedCircuitOpen
+		lookupErr = fmt.Errorf("cache lookups are suspended after repeated cache store failures")
+		lookupWarning = "execution cache lookups are suspended after repeated store failures, step will run uncached"
+		podLogger.WithField(logging.FieldDecision, outcome).Debug("Skipping the cache lookup while the circuit is open")
+	} else {
+		lookupStart := time.Now()
+		endLookup := startPhase(ctx, AdmissionPhaseLookup)
+		cachedExecution, err = getExecutionCacheBeforeDeadline(ctx, crossClusterLookups(wh.lookupCoalescer.coalesced(clientMgr.CacheStore(), req.Namespace), config, wh.metrics), executionHashKey, maxCacheStalenessInSeconds, filter)
+		endLookup()
+		lookupDuration := time.Since(lookupStart)
+		decisionDetailsFrom(ctx).lookupDuration = lookupDuration
+		podLogger = podLogger.WithField(logging.FieldDurationMs, logging.DurationMs(lookupDuration))
+		switch {
+		case err == nil || util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND):
+			wh.lookupCircuitBreaker.RecordSuccess()
+		case ctx.Err() == context.DeadlineExceeded:
+			// The pod runs like a cache miss rather than waiting for a slow store.
+			outcome = AdmissionOutcomeDeadlineExceeded
+			lookupErr = fmt.Errorf("cache lookup did not finish within the admission deadline of %v", deadline)
+			lookupWarning = fmt.Sprintf("execution cache lookup did not finish within %v, step will run uncached", deadline)
+			podLogger.WithField(logging.FieldDecision, outcome).Warnf("Cache lookup did not finish within the admission deadline of %v, admitting the pod uncached", deadline)
+			wh.lookupCircuitBreaker.RecordFailure()
+		case ctx.Err() == context.Canceled:
+			outcome = AdmissionOutcomeError
+			lookupErr = fmt.Errorf("cache lookup canceled: %v", err)
+			podLogger.WithField(logging.FieldDecision, outcome).Warnf("Cache lookup canceled: %v", err)
+			wh.lookupCircuitBreaker.Release()
+		default:
+			outcome = AdmissionOutcomeError
+			lookupErr = fmt.Errorf("cache lookup failed: %v", err)
+			lookupWarning = fmt.Sprintf("execution cache lookup failed, step will run uncached: %v", err)
+			podLogger.WithField(logging.FieldDecision, outcome).Warnf("Cache lookup failed: %v", err)
+			wh.lookupCircuitBreaker.RecordFailure()
+		}
+	}
+	// Entries whose outputs cannot be parsed or restored are not injected, the pod runs and
+	// records them anew. Under the shadow mode the outputs are not restored, which could record
+	// the execution of the pod in ML Metadata.
+	var cachedOutputs string
+	var restorePatches []patchOperation
+	if cachedExecution != nil {
+		cachedOutputs, err = outputs.Normalize(getValueFromSerializedMap(cachedExecution.ExecutionOutput, ArgoWorkflowOutputs))
+		if err == nil && config.Mode != CacheModeShadow {
+			restorePatches, err = orchestrator.restoreOutputs(ctx, &pod, cachedExecution, cachedOutputs, annotations, config.DummyContainer)
+		}
+		if err != nil {
+			podLogger.WithField(logging.FieldCacheID, cachedExecution.ID).Warnf("Cached outputs cannot be parsed or restored, admitting the pod uncached: %v", err)
+			cachedExecution = nil
+		}
+	}
+	if lookupErr != nil {
+		setDecisionReason(ctx, "%v", lookupErr)
+	}
+	if lookupErr != nil && config.failsClosed() {
+		wh.admissionHandled(ctx, outcome)
+		return nil, lookupErr
+	}
+	if lookupWarning != "" {
+		addAdmissionWarning(ctx, "%s", lookupWarning)
+	}
+	_, patchSpan := tracer.Start(ctx, tracing.SpanBuildPatches)
+	defer patchSpan.End()
+	defer startPhase(ctx, AdmissionPhasePatch)()
+	// Found cached execution under the shadow mode, the pod runs and records its entry like on a
+	// miss.
+	if cachedExecution != nil && config.Mode == CacheModeShadow {
+		outcome = AdmissionOutcomeShadowHit
+		podLogger = podLogger.WithField(logging.FieldCacheID, cachedExecution.ID)
+		annotations[wh.keys.ShadowCacheIDKey] = strconv.FormatInt(cachedExecution.ID, 10)
+		auditEvent.CacheEntryID = cachedExecution.ID
+		cachedExecution = nil
 	}
 	// Found cached execution, add cached output and cache_id and replace container images.
 	if cachedExecution != nil {
-		log.Println("Cached output: " + cachedExecution.ExecutionOutput)
-
-		annotations[ArgoWorkflowOutputs] = getValueFromSerializedMap(cachedExecution.ExecutionOutput, ArgoWorkflowOutputs)
-		labels[CacheIDLabelKey] = strconv.FormatInt(cachedExecution.ID, 10)
-		labels[KFPCachedLabelKey] = KFPCachedLabelValue // This label indicates the pod is taken from cache.
-		
-		// These labels cache results for metadata-writer.
-		labels[MetadataExecutionIDKey] = getValueFromSerializedMap(cachedExecution.ExecutionOutput, MetadataExecutionIDKey)
-		labels[MetadataWrittenKey] = "true"
-
-		dummyContainer := corev1.Container{
-			Name:    "main",
-			Image:   "alpine",
-			Command: []string{`echo`, `"This step output is taken from cache."`},
+		outcome = AdmissionOutcomeHit
+		hitLogger := podLogger.WithField(logging.FieldCacheID, cachedExecution.ID).WithFields(outputSummary(cachedOutputs))
+		if config.LogCachedOutputs {
+			hitLogger.Debugf("Cached outputs: %s", redactOutputs(cachedOutputs, config.SensitiveParameterPatterns))
+		} else {
+			hitLogger.Debug("Found cached outputs")
 		}
-		dummyContainers := []corev1.Container{
-			dummyContainer,
+		// Entries recorded without their execution time save none.
+		computeSaved := time.Duration(cachedExecution.ExecutionDurationInSec) * time.Second
+		annotations[wh.keys.ComputeSecondsSavedKey] = strconv.FormatInt(cachedExecution.ExecutionDurationInSec, 10)
+		wh.metrics.CacheHit(nodeName, len(cachedOutputs), computeSaved)
+		processLookups.hit(nodeName)
+		if config.EntryUses != nil {
+			config.EntryUses.used(cachedExecution.ID)
 		}
-		patches = append(patches, patchOperation{
-			Op:    OperationTypeReplace,
-			Path:  SpecContainersPath,
-			Value: dummyContainers,
-		})
-		if pod.Spec.InitContainers != nil || len(pod.Spec.InitContainers) != 0 {
-			patches = append(patches, patchOperation{
-				Op:   OperationTypeRemove,
-				Path: SpecInitContainersPath,
-			})
+		labels[wh.keys.CacheIDLabelKey] = strconv.FormatInt(cachedExecution.ID, 10)
+		if cachedExecution.RunID != "" {
+			annotations[wh.keys.CacheSourceRunIDKey] = cachedExecution.RunID
 		}
+		auditEvent.CacheEntryID = cachedExecution.ID
+		labels[wh.keys.CachedLabelKey] = KFPCachedLabelValue // This label indicates the pod is taken from cache.
+
+		// These labels cache results for metadata-writer.
+		labels[wh.keys.MetadataExecutionIDKey] = getValueFromSerializedMap(cachedExecution.ExecutionOutput, wh.keys.MetadataExecutionIDKey)
+		labels[wh.keys.MetadataWrittenKey] = "true"
+
+		patches = append(patches, restorePatches...)
+		patches = append(patches, config.DummyContainer.imagePullSecretsPatches(&pod)...)
+		patches = append(patches, config.HitScheduling.patches(&pod)...)
+	}
+
+	if outcome == AdmissionOutcomeMiss {
+		wh.metrics.CacheMissed(nodeName)
+		processLookups.missed(nodeName)
+	}
+	if outcome == AdmissionOutcomeHit || outcome == AdmissionOutcomeMiss || outcome == AdmissionOutcomeShadowHit {
+		podLogger.WithField(logging.FieldDecision, outcome).Info("Cache lookup completed")
+	}
+	if outcome == AdmissionOutcomeShadowHit {
+		// Predictions are checked against what would have happened.
+		wh.checkWorkflowPrediction(annotations, executionHashKey, AdmissionOutcomeHit)
+	} else {
+		wh.checkWorkflowPrediction(annotations, executionHashKey, outcome)
+	}
+
+	signature, err := config.SignatureKeys.sign(req.Namespace, executionHashKey, labels[wh.keys.CacheIDLabelKey])
+	if err != nil {
+		// The pod is admitted unsigned, the validating webhook decides whether it runs.
+		podLogger.Warnf("Unable to sign the cache fields: %v", err)
+	} else if signature != "" {
+		annotations[wh.keys.CacheSignatureKey] = signature
 	}
 
 	// Add executionKey to pod.metadata.annotations
@@ -166,59 +428,42 @@ func MutatePodIfCached(req *v1beta1.AdmissionRequest, clientMgr ClientManagerInt
 		Value: labels,
 	})
 
+	wh.admissionHandled(ctx, outcome)
+	wh.metrics.PatchesEmitted(len(patches))
 	return patches, nil
 }
 
-// intersectStructureWithSkeleton recursively intersects two maps
-// nil values in the skeleton map mean that the whole value (which can also be a map) should be kept.
-func intersectStructureWithSkeleton(src map[string]interface{}, skeleton map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for key, skeletonValue := range skeleton {
-		if value, ok := src[key]; ok {
-			if skeletonValue == nil {
-				result[key] = value
-			} else {
-				result[key] = intersectStructureWithSkeleton(value.(map[string]interface{}), skeletonValue.(map[string]interface{}))
-			}
-		}
+// getExecutionCacheBeforeDeadline looks the entry up in the background and gives up when the
+// deadline of ctx passes, also for stores that do not honor ctx. A lookup given up on still
+// completes on its own.
+func getExecutionCacheBeforeDeadline(ctx context.Context, store storage.ExecutionCacheStoreInterface, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return result
-}
-
-func generateCacheKeyFromTemplate(template string) (string, error) {
-	var templateMap map[string]interface{}
-	b := []byte(template)
-	err := json.Unmarshal(b, &templateMap)
-	if err != nil {
-		return "", err
-	}
-
-	// Selectively copying parts of the template that should affect the cache
-	templateSkeleton := map[string]interface{}{
-		"container": map[string]interface{}{
-			"image":        nil,
-			"command":      nil,
-			"args":         nil,
-			"env":          nil,
-			"volumeMounts": nil,
-		},
-		"inputs":         nil,
-		"volumes":        nil,
-		"initContainers": nil,
-		"sidecars":       nil,
-	}
-	cacheKeyMap := intersectStructureWithSkeleton(templateMap, templateSkeleton)
-
-	b, err = json.Marshal(cacheKeyMap)
-	if err != nil {
-		return "", err
+	type lookupResult struct {
+		executionCache *model.ExecutionCache
+		err            error
+	}
+	results := make(chan lookupResult, 1)
+	go func() {
+		executionCache, err := store.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+		results <- lookupResult{executionCache, err}
+	}()
+	select {
+	case result := <-results:
+		return result.executionCache, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	hash := sha256.New()
-	hash.Write(b)
-	md := hash.Sum(nil)
-	executionHashKey := hex.EncodeToString(md)
+}
 
-	return executionHashKey, nil
+// admissionHandled records the outcome of the admission in the metrics, on its span, in its audit
+// event and as the decision its durations are labeled with.
+func (wh *Webhook) admissionHandled(ctx context.Context, outcome string) {
+	auditEventFrom(ctx).Decision = outcome
+	admissionTimingFrom(ctx).outcome = outcome
+	trace.SpanFromContext(ctx).SetAttributes(tracing.AttributeDecision.String(outcome))
+	wh.metrics.AdmissionHandled(outcome)
 }
 
 func getValueFromSerializedMap(serializedMap string, key string) string {
@@ -236,19 +481,27 @@ func getValueFromSerializedMap(serializedMap string, key string) string {
 	return value
 }
 
-func isKFPCacheEnabled(pod *corev1.Pod) bool {
-	cacheEnabled, exists := pod.ObjectMeta.Labels[KFPCacheEnabledLabelKey]
-	if !exists {
-		log.Printf("This pod %s is not created by KFP.", pod.ObjectMeta.Name)
-		return false
+// getPodOwner identifies the KFP profile or, lacking a profile label, the service account the pod
+// runs as. The namespace is passed separately since it is not always set on pods under admission.
+func (k AnnotationKeys) getPodOwner(pod *corev1.Pod, namespace string) string {
+	if profile, exists := pod.ObjectMeta.Labels[k.ProfileLabelKey]; exists && profile != "" {
+		return profile
 	}
-	return cacheEnabled == KFPCacheEnabledLabelValue
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccountName)
+}
+
+func (k AnnotationKeys) isKFPCacheEnabled(pod *corev1.Pod) bool {
+	cacheEnabled, exists := pod.ObjectMeta.Labels[k.CacheEnabledLabelKey]
+	return exists && cacheEnabled == KFPCacheEnabledLabelValue
 }
 
 func isTFXPod(pod *corev1.Pod) bool {
 	containers := pod.Spec.Containers
 	if containers == nil || len(containers) == 0 {
-		log.Printf("This pod container does not exist.")
 		return true
 	}
 	var mainContainers []corev1.Container
@@ -16,10 +16,22 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/go-redis/redis/v7"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
 	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"k8s.io/api/admission/v1beta1"
@@ -40,8 +52,8 @@ var (
 				ArgoWorkflowTemplate: `{"name": "Does not matter","container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
 			},
 			Labels: map[string]string{
-				ArgoCompleteLabelKey:    "true",
-				KFPCacheEnabledLabelKey: KFPCacheEnabledLabelValue,
+				ArgoCompleteLabelKey:         "true",
+				podKeys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue,
 			},
 		},
 		Spec: corev1.PodSpec{
@@ -73,6 +85,8 @@ var (
 			Raw: EncodePod(fakePod),
 		},
 	}
+	// testExecutionOutput is the output of the cache entries the test pods hit.
+	testExecutionOutput = `{"workflows.argoproj.io/outputs":"{\"parameters\":[{\"name\":\"message\",\"value\":\"Hello\"}]}"}`
 )
 
 func EncodePod(pod *corev1.Pod) []byte {
@@ -94,7 +108,7 @@ func TestMutatePodIfCachedWithErrorPodResource(t *testing.T) {
 			Version: "wrong", Resource: "wrong",
 		},
 	}
-	patchOperations, err := MutatePodIfCached(mockAdmissionRequest, fakeClientManager)
+	patchOperations, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), mockAdmissionRequest, fakeClientManager)
 	assert.Nil(t, patchOperations)
 	assert.Nil(t, err)
 }
@@ -102,15 +116,15 @@ func TestMutatePodIfCachedWithErrorPodResource(t *testing.T) {
 func TestMutatePodIfCachedWithDecodeError(t *testing.T) {
 	invalidAdmissionRequest := fakeAdmissionRequest
 	invalidAdmissionRequest.Object.Raw = []byte{5, 5}
-	patchOperation, err := MutatePodIfCached(&invalidAdmissionRequest, fakeClientManager)
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), &invalidAdmissionRequest, fakeClientManager)
 	assert.Nil(t, patchOperation)
 	assert.Contains(t, err.Error(), "could not deserialize pod object")
 }
 
 func TestMutatePodIfCachedWithCacheDisabledPod(t *testing.T) {
 	cacheDisabledPod := *fakePod.DeepCopy()
-	cacheDisabledPod.ObjectMeta.Labels[KFPCacheEnabledLabelKey] = "false"
-	patchOperation, err := MutatePodIfCached(GetFakeRequestFromPod(&cacheDisabledPod), fakeClientManager)
+	cacheDisabledPod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey] = "false"
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(&cacheDisabledPod), fakeClientManager)
 	assert.Nil(t, patchOperation)
 	assert.Nil(t, err)
 }
@@ -119,13 +133,15 @@ func TestMutatePodIfCachedWithTFXPod(t *testing.T) {
 	tfxPod := *fakePod.DeepCopy()
 	mainContainerCommand := append(tfxPod.Spec.Containers[0].Command, "/tfx-src/"+TFXPodSuffix)
 	tfxPod.Spec.Containers[0].Command = mainContainerCommand
-	patchOperation, err := MutatePodIfCached(GetFakeRequestFromPod(&tfxPod), fakeClientManager)
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), GetFakeRequestFromPod(&tfxPod), fakeClientManager)
 	assert.Nil(t, patchOperation)
 	assert.Nil(t, err)
 }
 
 func TestMutatePodIfCached(t *testing.T) {
-	patchOperation, err := MutatePodIfCached(&fakeAdmissionRequest, fakeClientManager)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
 	assert.Nil(t, err)
 	require.NotNil(t, patchOperation)
 	require.Equal(t, 2, len(patchOperation))
@@ -136,13 +152,13 @@ func TestMutatePodIfCached(t *testing.T) {
 func TestMutatePodIfCachedWithCacheEntryExist(t *testing.T) {
 	executionCache := &model.ExecutionCache{
 		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
-		ExecutionOutput:   "testOutput",
+		ExecutionOutput:   testExecutionOutput,
 		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
 		MaxCacheStaleness: -1,
 	}
-	fakeClientManager.CacheStore().CreateExecutionCache(executionCache)
+	fakeClientManager.CacheStore().CreateExecutionCache(context.Background(), executionCache)
 
-	patchOperation, err := MutatePodIfCached(&fakeAdmissionRequest, fakeClientManager)
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), &fakeAdmissionRequest, fakeClientManager)
 	assert.Nil(t, err)
 	require.NotNil(t, patchOperation)
 	require.Equal(t, 3, len(patchOperation))
@@ -151,14 +167,58 @@ func TestMutatePodIfCachedWithCacheEntryExist(t *testing.T) {
 	require.Equal(t, patchOperation[2].Op, OperationTypeAdd)
 }
 
+func TestMutatePodIfCachedRecordsTheUseOfHitEntries(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+	uses := NewEntryUseRecorder(clientManager.CacheStore().(storage.ExecutionCacheQuotaStore), util.NewFakeTime(time.Unix(1000, 0)))
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{EntryUses: uses}})
+
+	_, err = webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	uses.wait()
+	used, err := clientManager.CacheStore().(storage.ExecutionCacheAdminStore).GetExecutionCacheByID(context.Background(), strconv.FormatInt(entry.ID, 10))
+	require.Nil(t, err)
+	assert.Equal(t, int64(1001), used.LastUsedAtInSec)
+}
+
+func TestMutatePodIfCachedAnnotatesTheSourceRun(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+		RunID:             "run-0",
+	})
+	require.Nil(t, err)
+
+	patches, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	var annotations map[string]string
+	for _, patch := range patches {
+		if patch.Path == AnnotationPath {
+			annotations = patch.Value.(map[string]string)
+		}
+	}
+	assert.Equal(t, "run-0", annotations[podKeys.CacheSourceRunIDKey])
+}
+
 func TestMutatePodIfCachedWithTeamplateCleanup(t *testing.T) {
 	executionCache := &model.ExecutionCache{
 		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
-		ExecutionOutput:   "testOutput",
+		ExecutionOutput:   testExecutionOutput,
 		ExecutionTemplate: `Cache key was calculated from this: {"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
 		MaxCacheStaleness: -1,
 	}
-	fakeClientManager.CacheStore().CreateExecutionCache(executionCache)
+	fakeClientManager.CacheStore().CreateExecutionCache(context.Background(), executionCache)
 
 	pod := *fakePod.DeepCopy()
 	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = `{
@@ -173,7 +233,7 @@ func TestMutatePodIfCachedWithTeamplateCleanup(t *testing.T) {
 	}`
 	request := GetFakeRequestFromPod(&pod)
 
-	patchOperation, err := MutatePodIfCached(request, fakeClientManager)
+	patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), request, fakeClientManager)
 	assert.Nil(t, err)
 	require.NotNil(t, patchOperation)
 	require.Equal(t, 3, len(patchOperation))
@@ -181,3 +241,190 @@ func TestMutatePodIfCachedWithTeamplateCleanup(t *testing.T) {
 	require.Equal(t, patchOperation[1].Op, OperationTypeAdd)
 	require.Equal(t, patchOperation[2].Op, OperationTypeAdd)
 }
+
+func TestMutatePodIfCachedWithOwnerEnforcement(t *testing.T) {
+	tests := []struct {
+		name         string
+		entryOwner   string
+		enforceOwner bool
+		cached       bool
+	}{
+		{"not enforced, matching owner", "alice", false, true},
+		{"not enforced, other owner", "bob", false, true},
+		{"not enforced, shared entry", "", false, true},
+		{"enforced, matching owner", "alice", true, true},
+		{"enforced, other owner", "bob", true, false},
+		{"enforced, shared entry", "", true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{EnforceOwner: tc.enforceOwner}})
+			clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+				ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+				ExecutionOutput:   testExecutionOutput,
+				ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+				MaxCacheStaleness: -1,
+				Owner:             tc.entryOwner,
+			})
+			pod := *fakePod.DeepCopy()
+			pod.ObjectMeta.Labels[podKeys.ProfileLabelKey] = "alice"
+
+			patchOperation, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(&pod), clientManager)
+			assert.Nil(t, err)
+			if tc.cached {
+				require.Equal(t, 3, len(patchOperation))
+				assert.Equal(t, OperationTypeReplace, patchOperation[0].Op)
+			} else {
+				require.Equal(t, 2, len(patchOperation))
+				assert.Equal(t, OperationTypeAdd, patchOperation[0].Op)
+			}
+		})
+	}
+}
+
+func TestMutatePodIfCachedInjectsNormalizedOutputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		outputs  string
+		expected string
+	}{
+		{"canonical", `{"exitCode":"0","parameters":[{"name":"message","value":"Hello"}]}`, `{"exitCode":"0","parameters":[{"name":"message","value":"Hello"}]}`},
+		{"recorded before normalization", `{"Parameters":[{"name":"message","value":"Hello"}],"exit_code":0}`, `{"exitCode":"0","parameters":[{"name":"message","value":"Hello"}]}`},
+		{"invalid", `{"parameters":[`, ""},
+		{"missing", "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			executionOutput, err := json.Marshal(map[string]string{ArgoWorkflowOutputs: tc.outputs})
+			require.Nil(t, err)
+			clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+				ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+				ExecutionOutput:   string(executionOutput),
+				ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+				MaxCacheStaleness: -1,
+			})
+
+			patchOperation, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+			require.Nil(t, err)
+			if tc.expected == "" {
+				// The pod runs uncached.
+				require.Equal(t, 2, len(patchOperation))
+				assert.NotContains(t, patchOperation[0].Value, ArgoWorkflowOutputs)
+				return
+			}
+			require.Equal(t, 3, len(patchOperation))
+			assert.Equal(t, tc.expected, patchOperation[1].Value.(map[string]string)[ArgoWorkflowOutputs])
+		})
+	}
+}
+
+func TestGetPodOwner(t *testing.T) {
+	pod := fakePod.DeepCopy()
+	assert.Equal(t, "system:serviceaccount:kubeflow:default", podKeys.getPodOwner(pod, "kubeflow"))
+	pod.Spec.ServiceAccountName = "pipeline-runner"
+	assert.Equal(t, "system:serviceaccount:kubeflow:pipeline-runner", podKeys.getPodOwner(pod, "kubeflow"))
+	pod.ObjectMeta.Labels[podKeys.ProfileLabelKey] = "alice"
+	assert.Equal(t, "alice", podKeys.getPodOwner(pod, "kubeflow"))
+}
+
+func TestMutatePodIfCachedWithHungRedisRespondsWithinBudget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept connections without ever answering, like a hung Redis server.
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	redisClient := client.NewRedisClientInterface(redis.NewClient(&redis.Options{Addr: listener.Addr().String()}))
+	defer redisClient.Close()
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.cacheStore = storage.NewWriteThroughExecutionCacheStore(clientManager.cacheStore,
+		storage.NewRedisExecutionCacheStore(redisClient, storage.DefaultRedisKeyPrefix, storage.EntryScope{}, 200*time.Millisecond, util.NewFakeTimeForEpoch()),
+		storage.DefaultRedisCircuitFailureThreshold, storage.DefaultRedisCircuitCoolDown, prometheus.NewRegistry())
+
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: &fakeAdmissionRequest})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+	rr := httptest.NewRecorder()
+	webhook := NewWebhook(WebhookConfig{})
+	start := time.Now()
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+
+	assert.True(t, time.Since(start) < time.Second, "admission took %v", time.Since(start))
+	var review v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	assert.True(t, review.Response.Allowed)
+}
+
+func TestMutatePodIfCachedWithSlowStoreFailsOpenAtDeadline(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMutationMetrics(registry, DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{
+		Mutation: MutationConfig{AdmissionDeadline: 100 * time.Millisecond},
+		Metrics:  metrics,
+	})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.cacheStore = &slowExecutionCacheStore{delay: 2 * time.Second, started: make(chan struct{}, 1)}
+
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: &fakeAdmissionRequest})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(rr, req)
+
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "admission took %v", time.Since(start))
+	var review v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	require.True(t, review.Response.Allowed)
+	// The pod runs uncached: it gets its execution key but keeps its containers.
+	var patches []patchOperation
+	require.Nil(t, json.Unmarshal(review.Response.Patch, &patches))
+	require.Len(t, patches, 2)
+	assert.Equal(t, AnnotationPath, patches[0].Path)
+	annotations := patches[0].Value.(map[string]interface{})
+	assert.Equal(t, "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0", annotations[podKeys.ExecutionKey])
+	assert.NotContains(t, annotations, ArgoWorkflowOutputs)
+	assert.Equal(t, LabelPath, patches[1].Path)
+	assert.NotContains(t, patches[1].Value, podKeys.CachedLabelKey)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeDeadlineExceeded)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.admissions.WithLabelValues(AdmissionOutcomeMiss)))
+}
+
+func TestMutatePodIfCachedWithStoreWithinDeadline(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{AdmissionDeadline: time.Second}})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &slowExecutionCacheStore{delay: 50 * time.Millisecond, started: make(chan struct{}, 1)}
+	clientManager.cacheStore = store
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	<-store.started
+	require.Len(t, patches, 2)
+}
+
+func TestGetExecutionCacheBeforeDeadlineWithExpiredContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	store := &slowExecutionCacheStore{started: make(chan struct{}, 1)}
+
+	executionCache, err := getExecutionCacheBeforeDeadline(ctx, store, "testKey", -1, storage.ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, store.started, "the store must not be called once the deadline passed")
+}
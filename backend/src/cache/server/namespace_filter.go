@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceFilter selects the namespaces whose pods the webhook serves from cache: those allowed,
+// or every namespace when none is, except those denied.
+type NamespaceFilter struct {
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+// factory function for a namespace filter of comma separated lists of allowed and denied namespaces
+func NewNamespaceFilter(allowed string, denied string) (*NamespaceFilter, error) {
+	allowedNames, err := parseNamespaceNames(allowed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed namespaces: %v", err)
+	}
+	deniedNames, err := parseNamespaceNames(denied)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied namespaces: %v", err)
+	}
+	if len(allowedNames) == 0 && len(deniedNames) == 0 {
+		return nil, nil
+	}
+	return &NamespaceFilter{allowed: allowedNames, denied: deniedNames}, nil
+}
+
+// parseNamespaceNames parses a comma separated list of namespace names.
+func parseNamespaceNames(spec string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if problems := validation.IsDNS1123Label(name); len(problems) > 0 {
+			return nil, fmt.Errorf("%q is not a namespace name: %s", name, strings.Join(problems, ", "))
+		}
+		names[name] = true
+	}
+	return names, nil
+}
+
+// Admits reports whether the pods of the namespace are served from cache. A nil filter admits
+// every namespace.
+func (f *NamespaceFilter) Admits(namespace string) bool {
+	if f == nil {
+		return true
+	}
+	if f.denied[namespace] {
+		return false
+	}
+	return len(f.allowed) == 0 || f.allowed[namespace]
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	tests := []struct {
+		allowed string
+		denied  string
+		admits  map[string]bool
+	}{
+		{allowed: "", denied: "", admits: map[string]bool{"kubeflow": true, "team-a": true}},
+		{allowed: "kubeflow, team-a", denied: "", admits: map[string]bool{"kubeflow": true, "team-a": true, "team-b": false}},
+		{allowed: "", denied: "team-b", admits: map[string]bool{"kubeflow": true, "team-b": false}},
+		{allowed: "team-a,team-b", denied: "team-b", admits: map[string]bool{"team-a": true, "team-b": false, "kubeflow": false}},
+	}
+	for _, test := range tests {
+		filter, err := NewNamespaceFilter(test.allowed, test.denied)
+		require.Nil(t, err)
+		for namespace, admits := range test.admits {
+			assert.Equal(t, admits, filter.Admits(namespace), "allowed %q, denied %q, namespace %s", test.allowed, test.denied, namespace)
+		}
+	}
+
+	_, err := NewNamespaceFilter("kubeflow,Team-A", "")
+	assert.Contains(t, err.Error(), `invalid allowed namespaces: "Team-A" is not a namespace name`)
+	_, err = NewNamespaceFilter("", "team/a")
+	assert.Contains(t, err.Error(), "invalid denied namespaces")
+}
+
+func TestMutatePodIfCachedSkipsFilteredNamespaces(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	filter, err := NewNamespaceFilter("", fakeAdmissionRequest.Namespace)
+	require.Nil(t, err)
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{Namespaces: filter}})
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+
+	assert.Nil(t, err)
+	assert.Nil(t, patches, "the pod is not looked up")
+
+	filter, err = NewNamespaceFilter(fakeAdmissionRequest.Namespace, "")
+	require.Nil(t, err)
+	webhook.Reconfigure(MutationConfig{Namespaces: filter})
+	patches, err = webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(patches), "the pod of an allowed namespace is looked up")
+}
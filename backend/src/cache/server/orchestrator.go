@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podOrchestrator is the engine that created the pod of a KFP step, Argo or Tekton. It tells the
+// webhook and the watcher where the pods of its steps keep their template and outputs, and how the
+// outputs of a cache entry are restored on a hit. Outputs are exchanged in the canonical form of
+// the Argo outputs annotation, whichever engine produced them, so that entries are stored, scrubbed
+// and served alike.
+type podOrchestrator interface {
+	// completed reports whether the engine is done with the pod, whose status no longer changes.
+	completed(pod *corev1.Pod) bool
+	// stepFailed reports whether the step of a Succeeded pod failed nonetheless.
+	stepFailed(pod *corev1.Pod) bool
+	// nodeName identifies the step of the pod within its run.
+	nodeName(pod *corev1.Pod) string
+	// template returns the template of the step the cache key is generated from, false when the
+	// pod has none.
+	template(pod *corev1.Pod) (string, bool)
+	// cacheKey returns the cache key of the template without the ignored fields under the key
+	// strategy of the version, generated by keyer unless the orchestrator has keys of its own.
+	cacheKey(ctx context.Context, keyer *templateKeyer, version string, ignored *IgnoredTemplateFields, template string) (string, error)
+	// outputs returns the outputs of the completed pod, false when the pod does not hold them.
+	outputs(pod *corev1.Pod) (string, bool)
+	// restoreOutputs returns the patches making the pod restore the cached outputs of the entry
+	// in the dummy container instead of running its step, and sets the annotations the patches of
+	// the annotations will hold.
+	restoreOutputs(ctx context.Context, pod *corev1.Pod, entry *model.ExecutionCache, cachedOutputs string, annotations map[string]string, dummy DummyContainer) ([]patchOperation, error)
+}
+
+// podOrchestratorOf returns the engine that created the pod, Argo unless the pod is a Tekton one,
+// with the TFX strategy for the Argo pods of TFX pipelines.
+func podOrchestratorOf(pod *corev1.Pod) podOrchestrator {
+	if isTektonPod(pod) {
+		return tektonPodOrchestrator{}
+	}
+	if isTFXPod(pod) {
+		return tfxPodOrchestrator{}
+	}
+	return argoPodOrchestrator{}
+}
+
+// argoPodOrchestrator handles the pods of Argo workflows, which hold their template and outputs in
+// annotations.
+type argoPodOrchestrator struct{}
+
+func (argoPodOrchestrator) completed(pod *corev1.Pod) bool {
+	return pod.ObjectMeta.Labels[ArgoCompleteLabelKey] == "true"
+}
+
+func (argoPodOrchestrator) stepFailed(pod *corev1.Pod) bool {
+	return mainExitedWithError(pod)
+}
+
+func (argoPodOrchestrator) nodeName(pod *corev1.Pod) string {
+	return pod.ObjectMeta.Annotations[ArgoWorkflowNodeName]
+}
+
+func (argoPodOrchestrator) template(pod *corev1.Pod) (string, bool) {
+	template, exists := pod.ObjectMeta.Annotations[ArgoWorkflowTemplate]
+	return template, exists
+}
+
+func (argoPodOrchestrator) cacheKey(ctx context.Context, keyer *templateKeyer, version string, ignored *IgnoredTemplateFields, template string) (string, error) {
+	return keyer.cacheKey(ctx, version, ignored, template)
+}
+
+func (argoPodOrchestrator) outputs(pod *corev1.Pod) (string, bool) {
+	podOutputs, exists := pod.ObjectMeta.Annotations[ArgoWorkflowOutputs]
+	return podOutputs, exists
+}
+
+// restoreOutputs sets the outputs annotation, which Argo's wait container reports as the outputs of
+// the step, and replaces the containers with a dummy one, which keeps the security context of the
+// main container so that the pod still passes the policies of its namespace.
+func (argoPodOrchestrator) restoreOutputs(ctx context.Context, pod *corev1.Pod, entry *model.ExecutionCache, cachedOutputs string, annotations map[string]string, dummy DummyContainer) ([]patchOperation, error) {
+	annotations[ArgoWorkflowOutputs] = cachedOutputs
+	dummyContainer := corev1.Container{
+		Name:      "main",
+		Image:     dummy.image(),
+		Command:   []string{`echo`, `"This step output is taken from cache."`},
+		Resources: dummy.Resources,
+	}
+	if len(dummy.Command) != 0 {
+		dummyContainer.Command = dummy.Command
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "main" {
+			dummyContainer.SecurityContext = container.SecurityContext
+		}
+	}
+	dummyContainers := []corev1.Container{
+		dummyContainer,
+	}
+	patches := []patchOperation{{
+		Op:    OperationTypeReplace,
+		Path:  SpecContainersPath,
+		Value: dummyContainers,
+	}}
+	if pod.Spec.InitContainers != nil || len(pod.Spec.InitContainers) != 0 {
+		patches = append(patches, patchOperation{
+			Op:   OperationTypeRemove,
+			Path: SpecInitContainersPath,
+		})
+	}
+	return patches, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// PodSkipReasonFailed is a pod in the Failed phase.
+	PodSkipReasonFailed string = "failed"
+	// PodSkipReasonEvicted is a pod evicted by the kubelet, e.g. under node pressure.
+	PodSkipReasonEvicted string = "evicted"
+	// PodSkipReasonOOMKilled is a pod one of whose containers was killed for running out of
+	// memory, whatever its phase.
+	PodSkipReasonOOMKilled string = "oom_killed"
+	// PodSkipReasonMainFailed is a Succeeded pod whose main container exited with a non-zero code,
+	// which some Argo executors report while the wait container itself exits cleanly, or one of
+	// whose Tekton steps did.
+	PodSkipReasonMainFailed string = "main_failed"
+
+	argoMainContainerName string = "main"
+	podReasonEvicted      string = "Evicted"
+	containerReasonOOM    string = "OOMKilled"
+)
+
+// PodTermination is how a pod terminated, as far as recording its outputs is concerned.
+type PodTermination struct {
+	// Terminated is set once the pod is completed by its orchestrator, after which its status no
+	// longer changes.
+	Terminated bool
+	// SkipReason is one of the PodSkipReason values for the terminated pods whose outputs must
+	// not be recorded, and empty for the pods that genuinely succeeded.
+	SkipReason string
+}
+
+// Succeeded reports whether the outputs of the pod are to be recorded.
+func (t PodTermination) Succeeded() bool {
+	return t.Terminated && t.SkipReason == ""
+}
+
+// classifyPodTermination classifies the pod from its phase, its container statuses and, for Argo
+// pods, the exit code Argo's wait container reported in its outputs.
+func classifyPodTermination(pod *corev1.Pod) PodTermination {
+	orchestrator := podOrchestratorOf(pod)
+	if !orchestrator.completed(pod) {
+		return PodTermination{}
+	}
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+	case corev1.PodFailed:
+		if pod.Status.Reason == podReasonEvicted {
+			return PodTermination{Terminated: true, SkipReason: PodSkipReasonEvicted}
+		}
+		if isOOMKilled(pod) {
+			return PodTermination{Terminated: true, SkipReason: PodSkipReasonOOMKilled}
+		}
+		return PodTermination{Terminated: true, SkipReason: PodSkipReasonFailed}
+	default:
+		// Argo labels the pods it gave up on as completed whatever their phase.
+		return PodTermination{Terminated: true, SkipReason: PodSkipReasonFailed}
+	}
+	if isOOMKilled(pod) {
+		return PodTermination{Terminated: true, SkipReason: PodSkipReasonOOMKilled}
+	}
+	if orchestrator.stepFailed(pod) {
+		return PodTermination{Terminated: true, SkipReason: PodSkipReasonMainFailed}
+	}
+	return PodTermination{Terminated: true}
+}
+
+func isOOMKilled(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+			if state.Terminated != nil && state.Terminated.Reason == containerReasonOOM {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mainExitedWithError reports whether the main container exited with a non-zero code, either
+// according to its status or to the exitCode Argo's wait container wrote into the outputs.
+func mainExitedWithError(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == argoMainContainerName && status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+			return true
+		}
+	}
+	podOutputs, err := outputs.Parse(pod.ObjectMeta.Annotations[ArgoWorkflowOutputs])
+	if err != nil {
+		return false
+	}
+	return podOutputs.ExitCode != nil && *podOutputs.ExitCode != "0"
+}
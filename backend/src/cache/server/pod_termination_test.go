@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The statuses below are trimmed from pods of KFP runs, as reported by the API server.
+const (
+	succeededStatus string = `{"phase":"Succeeded","containerStatuses":[
+		{"name":"main","state":{"terminated":{"exitCode":0,"reason":"Completed"}}},
+		{"name":"wait","state":{"terminated":{"exitCode":0,"reason":"Completed"}}}]}`
+	failedStatus string = `{"phase":"Failed","containerStatuses":[
+		{"name":"main","state":{"terminated":{"exitCode":1,"reason":"Error"}}},
+		{"name":"wait","state":{"terminated":{"exitCode":0,"reason":"Completed"}}}]}`
+	evictedStatus string = `{"phase":"Failed","reason":"Evicted",
+		"message":"The node was low on resource: memory. Container main was using 1843964Ki, which exceeds its request of 0."}`
+	oomKilledStatus string = `{"phase":"Failed","containerStatuses":[
+		{"name":"main","state":{"terminated":{"exitCode":137,"reason":"OOMKilled"}}},
+		{"name":"wait","state":{"terminated":{"exitCode":0,"reason":"Completed"}}}]}`
+	// restartedOOMKilledStatus is a pod whose main container was killed out of memory, then
+	// restarted and succeeded, possibly with partial outputs.
+	restartedOOMKilledStatus string = `{"phase":"Succeeded","containerStatuses":[
+		{"name":"main","restartCount":1,"state":{"terminated":{"exitCode":0,"reason":"Completed"}},
+			"lastState":{"terminated":{"exitCode":137,"reason":"OOMKilled"}}},
+		{"name":"wait","state":{"terminated":{"exitCode":0,"reason":"Completed"}}}]}`
+	succeededWithFailedMainStatus string = `{"phase":"Succeeded","containerStatuses":[
+		{"name":"main","state":{"terminated":{"exitCode":2,"reason":"Error"}}},
+		{"name":"wait","state":{"terminated":{"exitCode":0,"reason":"Completed"}}}]}`
+	runningStatus string = `{"phase":"Running","containerStatuses":[
+		{"name":"main","state":{"running":{"startedAt":"2020-06-01T00:00:00Z"}}},
+		{"name":"wait","state":{"running":{"startedAt":"2020-06-01T00:00:00Z"}}}]}`
+)
+
+func podWithStatus(t *testing.T, status string, completed bool, outputs string) *corev1.Pod {
+	pod := cacheablePod("step")
+	require.Nil(t, json.Unmarshal([]byte(status), &pod.Status))
+	if completed {
+		pod.ObjectMeta.Labels[ArgoCompleteLabelKey] = "true"
+	}
+	pod.ObjectMeta.Annotations[ArgoWorkflowOutputs] = outputs
+	return pod
+}
+
+func TestClassifyPodTermination(t *testing.T) {
+	const outputs string = `{"parameters":[{"name":"message","value":"Hello"}]}`
+	tests := []struct {
+		name      string
+		status    string
+		completed bool
+		outputs   string
+		expected  PodTermination
+	}{
+		{name: "succeeded", status: succeededStatus, completed: true, outputs: outputs,
+			expected: PodTermination{Terminated: true}},
+		{name: "succeeded with exit code 0 reported by the wait container", status: succeededStatus, completed: true,
+			outputs:  `{"parameters":[{"name":"message","value":"Hello"}],"exitCode":"0"}`,
+			expected: PodTermination{Terminated: true}},
+		{name: "succeeded without outputs", status: succeededStatus, completed: true,
+			expected: PodTermination{Terminated: true}},
+		{name: "succeeded before Argo completed it", status: succeededStatus, outputs: outputs},
+		{name: "running", status: runningStatus},
+		{name: "failed", status: failedStatus, completed: true, outputs: outputs,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonFailed}},
+		{name: "evicted", status: evictedStatus, completed: true,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonEvicted}},
+		{name: "out of memory", status: oomKilledStatus, completed: true,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonOOMKilled}},
+		{name: "succeeded after restarting out of memory", status: restartedOOMKilledStatus, completed: true, outputs: outputs,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonOOMKilled}},
+		{name: "succeeded with the main container failed", status: succeededWithFailedMainStatus, completed: true, outputs: outputs,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonMainFailed}},
+		{name: "succeeded with a failure reported by the wait container", status: succeededStatus, completed: true,
+			outputs:  `{"parameters":[{"name":"message","value":"Hello"}],"exitCode":"1"}`,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonMainFailed}},
+		{name: "succeeded with a failure reported in gzipped outputs", status: succeededStatus, completed: true,
+			// {"exitCode":1}
+			outputs:  "H4sIAAAAAAAAA6tWSq3ILHHOT0lVsjKsBQCvWxYADgAAAA==",
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonMainFailed}},
+		{name: "running when Argo gave up on it", status: runningStatus, completed: true,
+			expected: PodTermination{Terminated: true, SkipReason: PodSkipReasonFailed}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			termination := classifyPodTermination(podWithStatus(t, test.status, test.completed, test.outputs))
+
+			assert.Equal(t, test.expected, termination)
+			assert.Equal(t, test.expected.Terminated && test.expected.SkipReason == "", termination.Succeeded())
+		})
+	}
+}
+
+func TestRecordPodOutputSkipsPodsThatDidNotSucceed(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+
+	for _, status := range []string{failedStatus, evictedStatus, oomKilledStatus, restartedOOMKilledStatus, succeededWithFailedMainStatus} {
+		assert.True(t, recordPodOutputNow(podWithStatus(t, status, true, "{}"), clientManager, metrics), "the pod is done with")
+	}
+	assert.False(t, recordPodOutputNow(podWithStatus(t, runningStatus, false, "{}"), clientManager, metrics), "the pod is handled again once completed")
+
+	var count int
+	require.Nil(t, clientManager.DB().Table("execution_caches").Count(&count).Error)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonFailed)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonEvicted)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonOOMKilled)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonMainFailed)))
+}
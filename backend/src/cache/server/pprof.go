@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+const (
+	PprofAPI string = "/debug/pprof/"
+	// DefaultPprofAddress keeps the profiles reachable through kubectl port-forward only.
+	DefaultPprofAddress string = "localhost:6060"
+)
+
+// PprofConfig holds the profiling settings of the webhook.
+type PprofConfig struct {
+	Enabled bool
+	// MutexProfileFraction reports on average 1 in that many mutex contention events. 0 disables
+	// the mutex profile.
+	MutexProfileFraction int
+	// BlockProfileRate samples one blocking event per that many nanoseconds spent blocked. 0
+	// disables the block profile.
+	BlockProfileRate int
+}
+
+// NewPprofHandler serves the net/http/pprof profiles under PprofAPI when profiling is enabled and
+// answers 404 otherwise. Enabling it also sets the mutex and block profile rates of the process.
+func NewPprofHandler(config PprofConfig) http.Handler {
+	mux := http.NewServeMux()
+	if !config.Enabled {
+		return mux
+	}
+	runtime.SetMutexProfileFraction(config.MutexProfileFraction)
+	runtime.SetBlockProfileRate(config.BlockProfileRate)
+	logger.Infof("Serving pprof profiles with mutex profile fraction %d and block profile rate %d",
+		config.MutexProfileFraction, config.BlockProfileRate)
+	mux.HandleFunc(PprofAPI, pprof.Index)
+	mux.HandleFunc(PprofAPI+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofAPI+"profile", pprof.Profile)
+	mux.HandleFunc(PprofAPI+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofAPI+"trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getPprof(handler http.Handler, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	return rr
+}
+
+func TestPprofIsNotFoundWhenDisabled(t *testing.T) {
+	handler := NewPprofHandler(PprofConfig{Enabled: false, MutexProfileFraction: 5})
+
+	for _, path := range []string{PprofAPI, PprofAPI + "heap", PprofAPI + "cmdline"} {
+		assert.Equal(t, http.StatusNotFound, getPprof(handler, path).Code, path)
+	}
+	assert.Equal(t, 0, runtime.SetMutexProfileFraction(-1))
+}
+
+func TestPprofServesProfilesWhenEnabled(t *testing.T) {
+	handler := NewPprofHandler(PprofConfig{Enabled: true, MutexProfileFraction: 5, BlockProfileRate: 1000})
+	defer runtime.SetBlockProfileRate(0)
+	defer runtime.SetMutexProfileFraction(0)
+
+	assert.Equal(t, 5, runtime.SetMutexProfileFraction(-1))
+	for _, path := range []string{PprofAPI, PprofAPI + "heap?debug=1", PprofAPI + "mutex?debug=1", PprofAPI + "block?debug=1", PprofAPI + "cmdline"} {
+		rr := getPprof(handler, path)
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.NotEmpty(t, rr.Body.String(), path)
+	}
+}
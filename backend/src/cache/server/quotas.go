@@ -0,0 +1,310 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// entryUseInterval is how often the use of an entry is recorded at most, so that entries hit by
+	// every pod of a large fan-out are not updated for each of them.
+	entryUseInterval int64 = 60
+	// maxTrackedEntryUses bounds the entries whose last recorded use is remembered. The memory is
+	// cleared once full, at the cost of recording some uses again.
+	maxTrackedEntryUses int = 10000
+	// entryUseTimeout bounds the time spent recording the use of an entry.
+	entryUseTimeout time.Duration = 5 * time.Second
+	// quotaUsageTTL is how long the usage of a namespace is tracked from the entries created and
+	// evicted before it is counted again in the store, so that writes do not aggregate the entries
+	// of their namespace each time. Entries deleted otherwise, e.g. invalidated, are only accounted
+	// for by the next count.
+	quotaUsageTTL time.Duration = time.Minute
+)
+
+// NamespaceQuota bounds the cache entries of a namespace, or of the whole store, and their total
+// output size. Zero does not bound them.
+type NamespaceQuota struct {
+	MaxEntries     int64
+	MaxOutputBytes int64
+}
+
+// excess returns how much usage is over the quota.
+func (q NamespaceQuota) excess(usage storage.NamespaceUsage) storage.NamespaceUsage {
+	var excess storage.NamespaceUsage
+	if q.MaxEntries > 0 && usage.Entries > q.MaxEntries {
+		excess.Entries = usage.Entries - q.MaxEntries
+	}
+	if q.MaxOutputBytes > 0 && usage.OutputBytes > q.MaxOutputBytes {
+		excess.OutputBytes = usage.OutputBytes - q.MaxOutputBytes
+	}
+	return excess
+}
+
+// NamespaceQuotas holds the quota of every namespace: the default quota, overridden for some
+// namespaces. The overrides can be replaced while the quotas are enforced.
+type NamespaceQuotas struct {
+	defaultQuota NamespaceQuota
+	// overrides holds a map[string]NamespaceQuota.
+	overrides atomic.Value
+}
+
+// factory function for namespace quotas of defaultQuota, except for the namespaces of overrides.
+func NewNamespaceQuotas(defaultQuota NamespaceQuota, overrides map[string]NamespaceQuota) *NamespaceQuotas {
+	quotas := &NamespaceQuotas{defaultQuota: defaultQuota}
+	quotas.SetOverrides(overrides)
+	return quotas
+}
+
+// SetOverrides replaces the quotas overriding the default one.
+func (q *NamespaceQuotas) SetOverrides(overrides map[string]NamespaceQuota) {
+	if overrides == nil {
+		overrides = map[string]NamespaceQuota{}
+	}
+	q.overrides.Store(overrides)
+}
+
+// Get returns the quota of the namespace.
+func (q *NamespaceQuotas) Get(namespace string) NamespaceQuota {
+	if quota, ok := q.overrides.Load().(map[string]NamespaceQuota)[namespace]; ok {
+		return quota
+	}
+	return q.defaultQuota
+}
+
+// NamespaceQuotaEnforcer keeps the namespaces within their quota, and the store within its bound,
+// as the watcher creates their entries. It evicts the entries of the namespace of each new entry,
+// then those of the whole store, first in the order of the eviction policy: the least recently
+// used or the least frequently used. Namespace quotas never affect other namespaces, and writes
+// are never rejected. The usage of each namespace, and of the store, is counted in the store once
+// every quotaUsageTTL, and tracked from the entries created and evicted in between.
+type NamespaceQuotaEnforcer struct {
+	store      storage.ExecutionCacheQuotaStore
+	quotas     *NamespaceQuotas
+	storeQuota NamespaceQuota
+	policy     string
+	metrics    WatcherMetrics
+	time       util.TimeInterface
+
+	mutex sync.Mutex
+	// usages maps the namespaces, and storage.AllNamespaces, to their tracked usage.
+	usages map[string]*trackedUsage
+}
+
+// trackedUsage is what the entries of a namespace, or of the whole store, take: as counted in the
+// store at countedAt, plus the entries created and minus those evicted since. Its mutex is held
+// while the namespace is brought within its quota, so that concurrent writes do not evict the
+// same excess twice.
+type trackedUsage struct {
+	mutex sync.Mutex
+	usage storage.NamespaceUsage
+	// countedAt is the zero time until the usage is counted.
+	countedAt time.Time
+}
+
+// factory function for an enforcer of the quotas, and of the bound of the whole store, on the
+// entries of the store under the eviction policy, recording the usage and evictions to metrics
+// unless nil
+func NewNamespaceQuotaEnforcer(store storage.ExecutionCacheQuotaStore, quotas *NamespaceQuotas, storeQuota NamespaceQuota, policy string, metrics WatcherMetrics) *NamespaceQuotaEnforcer {
+	return newNamespaceQuotaEnforcer(store, quotas, storeQuota, policy, metrics, util.NewRealTime())
+}
+
+func newNamespaceQuotaEnforcer(store storage.ExecutionCacheQuotaStore, quotas *NamespaceQuotas, storeQuota NamespaceQuota, policy string, metrics WatcherMetrics, time util.TimeInterface) *NamespaceQuotaEnforcer {
+	if metrics == nil {
+		metrics = noopWatcherMetrics{}
+	}
+	return &NamespaceQuotaEnforcer{
+		store:      store,
+		quotas:     quotas,
+		storeQuota: storeQuota,
+		policy:     policy,
+		metrics:    metrics,
+		time:       time,
+		usages:     make(map[string]*trackedUsage),
+	}
+}
+
+// enforce brings the namespace of the created entry back within its quota, then the store within
+// its bound, keeping the created entry. Failures are logged, the entry stays created.
+func (e *NamespaceQuotaEnforcer) enforce(ctx context.Context, created *model.ExecutionCache) {
+	if namespace := created.Namespace; namespace != "" {
+		quota := e.quotas.Get(namespace)
+		usage, ok, eviction, evictedAt := e.evictExcess(ctx, created, namespace, quota)
+		if ok {
+			e.metrics.SetNamespaceUsage(namespace, usage, quota)
+		}
+		if eviction != nil {
+			e.untrack(storage.AllNamespaces, eviction.Freed, evictedAt)
+		}
+	}
+	if e.storeQuota != (NamespaceQuota{}) {
+		usage, ok, eviction, evictedAt := e.evictExcess(ctx, created, storage.AllNamespaces, e.storeQuota)
+		if ok {
+			e.metrics.SetStoreUsage(usage, e.storeQuota)
+		}
+		if eviction != nil {
+			for namespace, freed := range eviction.FreedNamespaces {
+				e.untrack(namespace, freed, evictedAt)
+			}
+		}
+	}
+}
+
+// evictExcess evicts the entries of the namespace, or of the whole store for
+// storage.AllNamespaces, over the quota and returns what the remaining entries take, false when
+// it is unknown, and the eviction with when it started, nil when nothing was evicted.
+func (e *NamespaceQuotaEnforcer) evictExcess(ctx context.Context, created *model.ExecutionCache, namespace string, quota NamespaceQuota) (storage.NamespaceUsage, bool, *storage.ExecutionCacheEviction, time.Time) {
+	scope, scopeLogger := "the cache", logger
+	if namespace != storage.AllNamespaces {
+		scope, scopeLogger = "the namespace", logger.WithField(logging.FieldNamespace, namespace)
+	}
+	tracked := e.trackedUsage(namespace)
+	tracked.mutex.Lock()
+	defer tracked.mutex.Unlock()
+	now := e.time.Now()
+	if tracked.countedAt.IsZero() || now.Sub(tracked.countedAt) >= quotaUsageTTL {
+		// The count includes the created entry.
+		counted, err := e.store.NamespaceUsage(ctx, namespace)
+		if err != nil {
+			scopeLogger.Errorf("Unable to enforce the cache quota of %s: %v", scope, err)
+			return storage.NamespaceUsage{}, false, nil, now
+		}
+		tracked.usage, tracked.countedAt = *counted, now
+	} else {
+		tracked.usage.Entries++
+		tracked.usage.OutputBytes += int64(len(created.ExecutionOutput))
+	}
+	usage := &tracked.usage
+	excess := quota.excess(*usage)
+	if excess == (storage.NamespaceUsage{}) {
+		return *usage, true, nil, now
+	}
+	eviction, err := e.store.Evict(ctx, namespace, excess, strconv.FormatInt(created.ID, 10), e.policy)
+	if eviction != nil && eviction.Freed.Entries > 0 {
+		usage.Entries -= eviction.Freed.Entries
+		usage.OutputBytes -= eviction.Freed.OutputBytes
+		if namespace == storage.AllNamespaces {
+			e.metrics.StoreEntriesEvicted(int(eviction.Freed.Entries))
+		} else {
+			e.metrics.EntriesEvicted(namespace, int(eviction.Freed.Entries))
+		}
+		scopeLogger.WithFields(logrus.Fields{
+			logging.FieldCacheID: created.ID,
+		}).Infof("Evicted the %d %s cache entries of %s to stay within its quota", eviction.Freed.Entries, evictedEntriesDescription(e.policy), scope)
+	}
+	if err != nil {
+		scopeLogger.Errorf("Unable to evict the cache entries over the quota of %s: %v", scope, err)
+	} else if quota.excess(*usage) != (storage.NamespaceUsage{}) {
+		scopeLogger.WithField(logging.FieldCacheID, created.ID).Warnf("The latest cache entry of %s alone exceeds its quota", scope)
+	}
+	return *usage, true, eviction, now
+}
+
+// trackedUsage returns the tracked usage of the namespace, or of the whole store for
+// storage.AllNamespaces.
+func (e *NamespaceQuotaEnforcer) trackedUsage(namespace string) *trackedUsage {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	tracked, ok := e.usages[namespace]
+	if !ok {
+		tracked = &trackedUsage{}
+		e.usages[namespace] = tracked
+	}
+	return tracked
+}
+
+// untrack removes the entries evicted from another scope, from evictedAt on, from the usage of the
+// namespace, or of the whole store for storage.AllNamespaces, unless the usage was counted since
+// or is not tracked.
+func (e *NamespaceQuotaEnforcer) untrack(namespace string, freed storage.NamespaceUsage, evictedAt time.Time) {
+	e.mutex.Lock()
+	tracked, ok := e.usages[namespace]
+	e.mutex.Unlock()
+	if !ok {
+		return
+	}
+	tracked.mutex.Lock()
+	defer tracked.mutex.Unlock()
+	if !tracked.countedAt.IsZero() && !tracked.countedAt.After(evictedAt) {
+		tracked.usage.Entries -= freed.Entries
+		tracked.usage.OutputBytes -= freed.OutputBytes
+	}
+}
+
+// evictedEntriesDescription describes the entries evicted first under the eviction policy.
+func evictedEntriesDescription(policy string) string {
+	if policy == storage.EvictionPolicyLFU {
+		return "least frequently used"
+	}
+	return "least recently used"
+}
+
+// EntryUseRecorder records when and how often the webhook reuses cache entries, for the quotas to
+// evict the least recently or least frequently used ones. The uses are recorded asynchronously, so that admissions do not wait
+// for the store, and at most once per entryUseInterval for each entry.
+type EntryUseRecorder struct {
+	store storage.ExecutionCacheQuotaStore
+	time  util.TimeInterface
+
+	mu sync.Mutex
+	// recordedAtInSec maps the IDs of the entries to when their use was last recorded.
+	recordedAtInSec map[int64]int64
+	pending         sync.WaitGroup
+}
+
+// factory function for a recorder of the uses of the entries of the store
+func NewEntryUseRecorder(store storage.ExecutionCacheQuotaStore, time util.TimeInterface) *EntryUseRecorder {
+	return &EntryUseRecorder{store: store, time: time, recordedAtInSec: make(map[int64]int64)}
+}
+
+// used records the use of the entry of the ID.
+func (r *EntryUseRecorder) used(id int64) {
+	r.mu.Lock()
+	nowInSec := r.time.Now().Unix()
+	if recordedAtInSec, ok := r.recordedAtInSec[id]; ok && nowInSec-recordedAtInSec < entryUseInterval {
+		r.mu.Unlock()
+		return
+	}
+	if len(r.recordedAtInSec) >= maxTrackedEntryUses {
+		r.recordedAtInSec = make(map[int64]int64)
+	}
+	r.recordedAtInSec[id] = nowInSec
+	r.mu.Unlock()
+
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), entryUseTimeout)
+		defer cancel()
+		if err := r.store.MarkExecutionCacheUsed(ctx, strconv.FormatInt(id, 10), nowInSec); err != nil {
+			logger.WithField(logging.FieldCacheID, id).Warnf("Unable to record the use of the cache entry: %v", err)
+		}
+	}()
+}
+
+// wait waits for the uses being recorded.
+func (r *EntryUseRecorder) wait() {
+	r.pending.Wait()
+}
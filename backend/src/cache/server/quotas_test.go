@@ -0,0 +1,266 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// newQuotaWriter returns a writer enforcing the quotas on the fake store of the client manager,
+// recording the usage and evictions to metrics.
+func newQuotaWriter(clientManager *FakeClientManager, quotas *NamespaceQuotas, metrics WatcherMetrics) *cacheEntryWriter {
+	return &cacheEntryWriter{
+		clientManager: clientManager,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		quotas:        NewNamespaceQuotaEnforcer(clientManager.CacheStore().(storage.ExecutionCacheQuotaStore), quotas, NamespaceQuota{}, storage.EvictionPolicyLRU, metrics),
+		keys:          podKeys,
+		metrics:       metrics,
+	}
+}
+
+// writeNamespaceEntry writes the entry of the key in the namespace, as recorded for a pod.
+func writeNamespaceEntry(t *testing.T, writer *cacheEntryWriter, namespace string, key string) *model.ExecutionCache {
+	pod := completedPod(key, time.Minute)
+	pod.ObjectMeta.Namespace = namespace
+	written, created, ok := writer.create(&model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   testExecutionOutput,
+		MaxCacheStaleness: -1,
+		Namespace:         namespace,
+	}, pod, false)
+	require.True(t, ok)
+	require.True(t, created)
+	return written
+}
+
+// namespaceKeys returns the cache keys of the entries of the namespace, in ID order.
+func namespaceKeys(t *testing.T, clientManager *FakeClientManager, namespace string) []string {
+	entries, _, err := clientManager.CacheStore().(storage.ExecutionCacheAdminStore).ListExecutionCaches(context.Background(), "", storage.ExecutionCacheFilter{}, 0, "")
+	require.Nil(t, err)
+	var keys []string
+	for _, entry := range entries {
+		if entry.Namespace == namespace {
+			keys = append(keys, entry.ExecutionCacheKey)
+		}
+	}
+	return keys
+}
+
+func TestNamespaceQuotaEvictsWithinTheOffendingNamespace(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	writer := newQuotaWriter(clientManager, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), metrics)
+
+	writeNamespaceEntry(t, writer, "team-b", "b1")
+	writeNamespaceEntry(t, writer, "team-b", "b2")
+	writeNamespaceEntry(t, writer, "team-a", "a1")
+	writeNamespaceEntry(t, writer, "team-a", "a2")
+	writeNamespaceEntry(t, writer, "team-a", "a3")
+
+	assert.Equal(t, []string{"a2", "a3"}, namespaceKeys(t, clientManager, "team-a"))
+	assert.Equal(t, []string{"b1", "b2"}, namespaceKeys(t, clientManager, "team-b"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.evictedEntries.WithLabelValues("team-a")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.evictedEntries.WithLabelValues("team-b")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.namespaceEntries.WithLabelValues("team-a")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.quotaEntries.WithLabelValues("team-a")))
+	assert.Equal(t, float64(2*len(testExecutionOutput)), testutil.ToFloat64(metrics.namespaceBytes.WithLabelValues("team-a")))
+}
+
+func TestNamespaceQuotaEvictsTheLeastRecentlyUsedEntries(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := clientManager.CacheStore().(storage.ExecutionCacheQuotaStore)
+	writer := newQuotaWriter(clientManager, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), noopWatcherMetrics{})
+
+	first := writeNamespaceEntry(t, writer, "team-a", "a1")
+	writeNamespaceEntry(t, writer, "team-a", "a2")
+	uses := NewEntryUseRecorder(store, util.NewFakeTime(time.Unix(1000, 0)))
+	uses.used(first.ID)
+	uses.wait()
+	writeNamespaceEntry(t, writer, "team-a", "a3")
+
+	assert.Equal(t, []string{"a1", "a3"}, namespaceKeys(t, clientManager, "team-a"))
+}
+
+func TestNamespaceQuotaBoundsTheOutputBytes(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	hook, restore := captureLogs()
+	defer restore()
+	writer := newQuotaWriter(clientManager, NewNamespaceQuotas(NamespaceQuota{MaxOutputBytes: int64(2 * len(testExecutionOutput))}, nil), noopWatcherMetrics{})
+
+	writeNamespaceEntry(t, writer, "team-a", "a1")
+	writeNamespaceEntry(t, writer, "team-a", "a2")
+	writeNamespaceEntry(t, writer, "team-a", "a3")
+	assert.Equal(t, []string{"a2", "a3"}, namespaceKeys(t, clientManager, "team-a"))
+
+	writer.quotas.quotas.SetOverrides(map[string]NamespaceQuota{"team-a": {MaxOutputBytes: 1}})
+	hook.Reset()
+	writeNamespaceEntry(t, writer, "team-a", "a4")
+	assert.Equal(t, []string{"a4"}, namespaceKeys(t, clientManager, "team-a"), "the latest entry is kept")
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, "The latest cache entry of the namespace alone exceeds its quota", hook.LastEntry().Message)
+}
+
+func TestStoreQuotaEvictsAcrossNamespaces(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	writer := newQuotaWriter(clientManager, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), metrics)
+	writer.quotas.storeQuota = NamespaceQuota{MaxEntries: 3}
+
+	writeNamespaceEntry(t, writer, "team-a", "a1")
+	writeNamespaceEntry(t, writer, "team-b", "b1")
+	writeNamespaceEntry(t, writer, "team-a", "a2")
+	writeNamespaceEntry(t, writer, "team-b", "b2")
+
+	assert.Equal(t, []string{"a2"}, namespaceKeys(t, clientManager, "team-a"))
+	assert.Equal(t, []string{"b1", "b2"}, namespaceKeys(t, clientManager, "team-b"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.storeEvictions))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.evictedEntries.WithLabelValues("team-a")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.storeEntries))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.storeMaxEntries))
+}
+
+func TestNamespaceQuotaEvictsTheLeastFrequentlyUsedEntries(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := clientManager.CacheStore().(storage.ExecutionCacheQuotaStore)
+	writer := newQuotaWriter(clientManager, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), noopWatcherMetrics{})
+	writer.quotas.policy = storage.EvictionPolicyLFU
+
+	first := writeNamespaceEntry(t, writer, "team-a", "a1")
+	second := writeNamespaceEntry(t, writer, "team-a", "a2")
+	uses := NewEntryUseRecorder(store, util.NewFakeTime(time.Unix(1000, 0)))
+	uses.used(first.ID)
+	uses.wait()
+	uses.time = util.NewFakeTime(time.Unix(1000+entryUseInterval, 0))
+	uses.used(first.ID)
+	uses.used(second.ID)
+	uses.wait()
+	writeNamespaceEntry(t, writer, "team-a", "a3")
+
+	assert.Equal(t, []string{"a1", "a3"}, namespaceKeys(t, clientManager, "team-a"), "a2 was used last but less often")
+}
+
+// countingQuotaStore counts the usages aggregated by its store.
+type countingQuotaStore struct {
+	storage.ExecutionCacheQuotaStore
+	counts int
+}
+
+func (s *countingQuotaStore) NamespaceUsage(ctx context.Context, namespace string) (*storage.NamespaceUsage, error) {
+	s.counts++
+	return s.ExecutionCacheQuotaStore.NamespaceUsage(ctx, namespace)
+}
+
+func TestNamespaceQuotaCountsTheUsageOncePerTTL(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &countingQuotaStore{ExecutionCacheQuotaStore: clientManager.CacheStore().(storage.ExecutionCacheQuotaStore)}
+	clock := &fixedClock{now: watcherStartTime}
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	writer := newQuotaWriter(clientManager, nil, metrics)
+	writer.quotas = newNamespaceQuotaEnforcer(store, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), NamespaceQuota{}, storage.EvictionPolicyLRU, metrics, clock)
+
+	writeNamespaceEntry(t, writer, "team-a", "a1")
+	writeNamespaceEntry(t, writer, "team-a", "a2")
+	writeNamespaceEntry(t, writer, "team-a", "a3")
+	assert.Equal(t, 1, store.counts, "the usage is tracked from the entries created and evicted")
+	assert.Equal(t, []string{"a2", "a3"}, namespaceKeys(t, clientManager, "team-a"))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.namespaceEntries.WithLabelValues("team-a")))
+
+	// Entries deleted otherwise are accounted for by the next count.
+	require.Nil(t, clientManager.CacheStore().DeleteExecutionCache(context.Background(), "a2"))
+	clock.now = clock.now.Add(quotaUsageTTL)
+	writeNamespaceEntry(t, writer, "team-a", "a4")
+	assert.Equal(t, 2, store.counts)
+	assert.Equal(t, []string{"a3", "a4"}, namespaceKeys(t, clientManager, "team-a"), "a3 is not evicted")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.evictedEntries.WithLabelValues("team-a")))
+}
+
+func TestStoreQuotaTracksTheEvictionsOfTheNamespaces(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &countingQuotaStore{ExecutionCacheQuotaStore: clientManager.CacheStore().(storage.ExecutionCacheQuotaStore)}
+	metrics := NewPrometheusWatcherMetrics(prometheus.NewRegistry()).(*prometheusWatcherMetrics)
+	writer := newQuotaWriter(clientManager, nil, metrics)
+	writer.quotas = newNamespaceQuotaEnforcer(store, NewNamespaceQuotas(NamespaceQuota{MaxEntries: 2}, nil), NamespaceQuota{MaxEntries: 4}, storage.EvictionPolicyLRU, metrics, &fixedClock{now: watcherStartTime})
+
+	for _, key := range []string{"a1", "a2", "a3"} {
+		writeNamespaceEntry(t, writer, "team-a", key)
+	}
+	for _, key := range []string{"b1", "b2", "b3"} {
+		writeNamespaceEntry(t, writer, "team-b", key)
+	}
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.storeEntries), "a1 and b1 evicted from their namespaces are untracked")
+	writeNamespaceEntry(t, writer, "team-c", "c1")
+	assert.Equal(t, []string{"a3"}, namespaceKeys(t, clientManager, "team-a"), "a2 is evicted from the store")
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.storeEntries))
+	assert.Equal(t, 4, store.counts, "the usage of the store and of each namespace is counted once")
+
+	// a2 evicted from the store is untracked, so that the namespace is within its quota and a4
+	// only takes the place of a3 in the store.
+	writeNamespaceEntry(t, writer, "team-a", "a4")
+	assert.Equal(t, []string{"a4"}, namespaceKeys(t, clientManager, "team-a"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.evictedEntries.WithLabelValues("team-a")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.storeEvictions))
+}
+
+func TestNamespaceQuotasOverrides(t *testing.T) {
+	quotas := NewNamespaceQuotas(NamespaceQuota{MaxEntries: 10}, map[string]NamespaceQuota{"team-a": {MaxEntries: 100}})
+	assert.Equal(t, NamespaceQuota{MaxEntries: 100}, quotas.Get("team-a"))
+	assert.Equal(t, NamespaceQuota{MaxEntries: 10}, quotas.Get("team-b"))
+
+	quotas.SetOverrides(map[string]NamespaceQuota{"team-b": {MaxOutputBytes: 1024}})
+	assert.Equal(t, NamespaceQuota{MaxEntries: 10}, quotas.Get("team-a"))
+	assert.Equal(t, NamespaceQuota{MaxOutputBytes: 1024}, quotas.Get("team-b"))
+}
+
+func TestEntryUseRecorderRecordsEachEntryOncePerInterval(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	entry, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{ExecutionCacheKey: "key", Namespace: "team-a"})
+	require.Nil(t, err)
+	store := clientManager.CacheStore().(storage.ExecutionCacheAdminStore)
+	uses := NewEntryUseRecorder(clientManager.CacheStore().(storage.ExecutionCacheQuotaStore), util.NewFakeTime(time.Unix(1000, 0)))
+
+	lastUsedAtInSec := func() int64 {
+		uses.wait()
+		used, err := store.GetExecutionCacheByID(context.Background(), "1")
+		require.Nil(t, err)
+		return used.LastUsedAtInSec
+	}
+	uses.used(entry.ID)
+	assert.Equal(t, int64(1001), lastUsedAtInSec())
+	uses.used(entry.ID)
+	assert.Equal(t, int64(1001), lastUsedAtInSec(), "used again within the interval")
+	uses.time = util.NewFakeTime(time.Unix(1000+entryUseInterval, 0))
+	uses.used(entry.ID)
+	assert.Equal(t, 1001+entryUseInterval, lastUsedAtInSec())
+}
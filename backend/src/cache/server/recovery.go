@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/google/uuid"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+)
+
+// recordingBody keeps a copy of the request body read by the handler, so that an admission can
+// still be answered with the UID of its request after a panic.
+type recordingBody struct {
+	io.ReadCloser
+	recorded bytes.Buffer
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.recorded.Write(p[:n])
+	return n, err
+}
+
+// RecoverPanics wraps a handler so that a panic while handling a request is logged with its stack
+// and counted in metrics, unless nil, instead of failing the request. Admission requests, which are
+// POST requests, are allowed unchanged with a warning whatever the fail policy, so that pods are
+// never blocked by a bug of the webhook. Other requests are answered with 500.
+func RecoverPanics(next http.Handler, metrics MutationMetrics) http.Handler {
+	if metrics == nil {
+		metrics = noopMutationMetrics{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body *recordingBody
+		if r.Body != nil {
+			body = &recordingBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// Handlers abort responses on purpose with this panic.
+				panic(recovered)
+			}
+			metrics.HandlerPanicked()
+			var recordedBody []byte
+			if body != nil {
+				recordedBody = body.recorded.Bytes()
+			}
+			uid, _ := peekRequest(recordedBody)
+			id := string(uid)
+			if id == "" {
+				id = uuid.New().String()
+			}
+			logger.WithField(logging.FieldRequestID, id).Errorf("Recovered from panic handling %s %s: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+			if r.Method != http.MethodPost {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if _, err := w.Write(warningResponse(uid, "internal error handling the admission, step will run uncached")); err != nil {
+				logger.Errorf("Could not write response: %v", err)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
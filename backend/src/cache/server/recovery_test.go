@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func panickingAdmitFunc(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+	var labels map[string]string
+	labels["boom"] = "true"
+	return nil, nil
+}
+
+func TestRecoverPanicsAllowsAdmission(t *testing.T) {
+	hook, restore := captureLogs()
+	defer restore()
+	metrics := NewPrometheusMutationMetrics(prometheus.NewRegistry(), DefaultMaxTemplateLabels).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{
+		// Panics fail open even under the closed fail policy.
+		Mutation: MutationConfig{FailPolicy: FailPolicyClosed},
+		// The slot of a panicking admission is released.
+		AdmissionLimiter: NewAdmissionLimiter(AdmissionLimiterConfig{MaxConcurrent: 1}, util.NewRealTime(), prometheus.NewRegistry()),
+		Metrics:          metrics,
+	})
+	handler := RecoverPanics(webhook.AdmitFuncHandler(panickingAdmitFunc, fakeClientManager), metrics)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(reviewBody(t, &fakeAdmissionRequest)))
+		req.Header.Set(ContentType, JsonContentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		response := decodeWarningResponse(t, rr.Body.Bytes())
+		assert.True(t, response.Allowed)
+		assert.Equal(t, types.UID("test-12345"), response.UID)
+		assert.Nil(t, response.Patch)
+		assert.Equal(t, []string{"pipelines.kubeflow.org cache webhook: internal error handling the admission, step will run uncached"}, response.Warnings)
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.panics))
+
+	var panicEntries []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.ErrorLevel && entry.Data[logging.FieldRequestID] == "test-12345" {
+			panicEntries = append(panicEntries, entry)
+		}
+	}
+	require.Len(t, panicEntries, 2)
+	assert.Contains(t, panicEntries[0].Message, "assignment to entry in nil map")
+	assert.Contains(t, panicEntries[0].Message, "panickingAdmitFunc")
+}
+
+func TestRecoverPanicsAnswersOtherRequestsWithServerError(t *testing.T) {
+	handler := RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), noopMutationMetrics{})
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, HealthzAPI, nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestRecoverPanicsPassesAbortedResponsesOn(t *testing.T) {
+	handler := RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), noopMutationMetrics{})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HealthzAPI, nil))
+	})
+}
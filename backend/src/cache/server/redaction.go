@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSensitiveParameterPatterns match the names of output parameters commonly holding
+	// credentials or signed URLs.
+	DefaultSensitiveParameterPatterns string = "password,passwd,secret,token,credential,key,signature,auth"
+
+	redactedValue string = "REDACTED"
+)
+
+// ParseSensitiveParameterPatterns compiles a comma separated list of regular expressions, which
+// match output parameter names case insensitively anywhere in the name.
+func ParseSensitiveParameterPatterns(list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expression := range strings.Split(list, ",") {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			continue
+		}
+		pattern, err := regexp.Compile("(?i)" + expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensitive parameter pattern %q: %v", expression, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// redactCredentials masks the credentials of URLs and key=value pairs in the message.
+func redactCredentials(message string) string {
+	message = urlUserInfoPattern.ReplaceAllString(message, "://REDACTED@")
+	return secretSettingPattern.ReplaceAllString(message, "${1}${2}"+redactedValue)
+}
+
+// parseOutputs decodes the Argo outputs annotation, which lists parameters and artifacts among
+// other fields.
+func parseOutputs(outputs string) (map[string]interface{}, bool) {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(outputs), &decoded); err != nil || decoded == nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// outputSummary describes the size and shape of the Argo outputs annotation without any of its
+// values, so that it can be logged at every level.
+func outputSummary(outputs string) logrus.Fields {
+	fields := logrus.Fields{logging.FieldOutputBytes: len(outputs)}
+	if decoded, ok := parseOutputs(outputs); ok {
+		parameters, _ := decoded["parameters"].([]interface{})
+		artifacts, _ := decoded["artifacts"].([]interface{})
+		fields[logging.FieldOutputParameters] = len(parameters)
+		fields[logging.FieldOutputArtifacts] = len(artifacts)
+	}
+	return fields
+}
+
+// redactOutputs returns the Argo outputs annotation with the values of the parameters whose name
+// matches one of the patterns masked, and the credentials of URLs and key=value pairs masked
+// everywhere. Outputs that do not decode are not returned at all, as their secrets cannot be told
+// apart.
+func redactOutputs(outputs string, patterns []*regexp.Regexp) string {
+	decoded, ok := parseOutputs(outputs)
+	if !ok {
+		return redactedValue
+	}
+	parameters, _ := decoded["parameters"].([]interface{})
+	for _, parameter := range parameters {
+		parameter, ok := parameter.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := parameter["name"].(string)
+		if isSensitiveParameter(name, patterns) {
+			if _, hasValue := parameter["value"]; hasValue {
+				parameter["value"] = redactedValue
+			}
+		}
+	}
+	redacted, err := json.Marshal(redactStrings(decoded))
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+// redactStrings masks the credentials in the strings of a decoded JSON value.
+func redactStrings(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return redactCredentials(value)
+	case []interface{}:
+		for i := range value {
+			value[i] = redactStrings(value[i])
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = redactStrings(value[key])
+		}
+	}
+	return value
+}
+
+func isSensitiveParameter(name string, patterns []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// sentinelSecret is seeded into cached outputs and must not show up in any log entry.
+const sentinelSecret = "sentinel-8c1f0e2b7d"
+
+var sentinelOutputs = fmt.Sprintf(`{"parameters":[`+
+	`{"name":"accuracy","value":"0.93"},`+
+	`{"name":"api_token","value":%q},`+
+	`{"name":"Download-Signature","value":"https://bucket.example.com/model?X-Amz-Signature=%s"},`+
+	`{"name":"uri","value":"https://user:%s@registry.example.com/model"},`+
+	`{"name":"config","value":"password=%s"}],`+
+	`"artifacts":[{"name":"model","s3":{"key":"models/model.tgz"}}]}`,
+	sentinelSecret, sentinelSecret, sentinelSecret, sentinelSecret)
+
+func mustParseSensitiveParameterPatterns(t *testing.T, list string) []*regexp.Regexp {
+	patterns, err := ParseSensitiveParameterPatterns(list)
+	require.Nil(t, err)
+	return patterns
+}
+
+func TestParseSensitiveParameterPatterns(t *testing.T) {
+	patterns, err := ParseSensitiveParameterPatterns(" token, ,^db_")
+	require.Nil(t, err)
+	require.Len(t, patterns, 2)
+	assert.True(t, isSensitiveParameter("API_TOKEN", patterns))
+	assert.True(t, isSensitiveParameter("db_name", patterns))
+	assert.False(t, isSensitiveParameter("my_db_name", patterns))
+
+	_, err = ParseSensitiveParameterPatterns("token,(")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `invalid sensitive parameter pattern "("`)
+}
+
+func TestOutputSummary(t *testing.T) {
+	assert.Equal(t, logrus.Fields{
+		logging.FieldOutputBytes:      len(sentinelOutputs),
+		logging.FieldOutputParameters: 5,
+		logging.FieldOutputArtifacts:  1,
+	}, outputSummary(sentinelOutputs))
+	assert.Equal(t, logrus.Fields{logging.FieldOutputBytes: 10}, outputSummary("testOutput"))
+}
+
+func TestRedactOutputs(t *testing.T) {
+	redacted := redactOutputs(sentinelOutputs, mustParseSensitiveParameterPatterns(t, DefaultSensitiveParameterPatterns))
+
+	assert.NotContains(t, redacted, sentinelSecret)
+	assert.Contains(t, redacted, `{"name":"accuracy","value":"0.93"}`)
+	assert.Contains(t, redacted, `{"name":"api_token","value":"REDACTED"}`)
+	assert.Contains(t, redacted, `{"name":"Download-Signature","value":"REDACTED"}`)
+	assert.Contains(t, redacted, `"value":"https://REDACTED@registry.example.com/model"`)
+	assert.Contains(t, redacted, `"value":"password=REDACTED"`)
+	assert.Contains(t, redacted, `"key":"models/model.tgz"`)
+
+	assert.Equal(t, redactedValue, redactOutputs("not json "+sentinelSecret, nil))
+}
+
+// assertNoSentinel fails when an entry, its fields included, contains the sentinel secret.
+func assertNoSentinel(t *testing.T, entries []*logrus.Entry) {
+	for _, entry := range entries {
+		line, err := entry.String()
+		require.Nil(t, err)
+		assert.NotContains(t, line, sentinelSecret, "level %v", entry.Level)
+	}
+}
+
+func TestCachedOutputsAreNotLogged(t *testing.T) {
+	template := `{"name": "sentinel","container":{"command":["echo", "sentinel"],"image":"python:3.7"}}`
+	key, err := generateCacheKeyFromTemplate(template)
+	require.Nil(t, err)
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = template
+	entry, err := fakeClientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionOutput:   fmt.Sprintf(`{%q:%q}`, ArgoWorkflowOutputs, sentinelOutputs),
+		ExecutionTemplate: template,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+	defer fakeClientManager.CacheStore().DeleteExecutionCache(context.Background(), entry.ExecutionCacheKey)
+
+	for _, logCachedOutputs := range []bool{false, true} {
+		for _, level := range logrus.AllLevels {
+			t.Run(fmt.Sprintf("%v/%v", logCachedOutputs, level), func(t *testing.T) {
+				hook, restore := captureLogs()
+				defer restore()
+				logger.(*logrus.Logger).SetLevel(level)
+				var audit bytes.Buffer
+				auditLog := NewAuditLog(NewJSONAuditSink(&audit), 10, prometheus.NewRegistry())
+				webhook := NewWebhook(WebhookConfig{
+					Mutation: MutationConfig{
+						LogCachedOutputs:           logCachedOutputs,
+						SensitiveParameterPatterns: mustParseSensitiveParameterPatterns(t, DefaultSensitiveParameterPatterns),
+					},
+					AuditLog: auditLog,
+				})
+
+				body, err := json.Marshal(v1beta1.AdmissionReview{Request: GetFakeRequestFromPod(pod)})
+				require.Nil(t, err)
+				req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+				req.Header.Set(ContentType, JsonContentType)
+				rr := httptest.NewRecorder()
+				webhook.AdmitFuncHandler(webhook.MutatePodIfCached, fakeClientManager).ServeHTTP(rr, req)
+				require.Nil(t, auditLog.Close())
+
+				// The base64 encoded patch carries the outputs to the pod, nothing else in the
+				// response, e.g. its warnings, may.
+				var review v1beta1.AdmissionReview
+				require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &review))
+				assert.Contains(t, string(review.Response.Patch), sentinelSecret)
+				assert.NotContains(t, rr.Body.String(), sentinelSecret)
+				assert.Contains(t, audit.String(), AdmissionOutcomeHit)
+				assert.NotContains(t, audit.String(), sentinelSecret)
+				assertNoSentinel(t, hook.AllEntries())
+				var messages []string
+				for _, entry := range hook.AllEntries() {
+					messages = append(messages, entry.Message)
+				}
+				if logCachedOutputs && level >= logrus.DebugLevel {
+					assert.Contains(t, strings.Join(messages, "\n"), `{"name":"accuracy","value":"0.93"}`, "outputs are logged redacted")
+				} else {
+					assert.NotContains(t, strings.Join(messages, "\n"), "0.93")
+				}
+			})
+		}
+	}
+}
+
+func TestRecordedOutputsAreNotLogged(t *testing.T) {
+	for _, level := range logrus.AllLevels {
+		t.Run(level.String(), func(t *testing.T) {
+			hook, restore := captureLogs()
+			defer restore()
+			logger.(*logrus.Logger).SetLevel(level)
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			pod := &corev1.Pod{}
+			pod.ObjectMeta.Name = "sentinel"
+			pod.ObjectMeta.Namespace = "default"
+			pod.ObjectMeta.Labels = map[string]string{ArgoCompleteLabelKey: "true", podKeys.CacheIDLabelKey: ""}
+			pod.ObjectMeta.Annotations = map[string]string{podKeys.ExecutionKey: "sentinel-key", ArgoWorkflowOutputs: sentinelOutputs}
+			pod.Status.Phase = corev1.PodSucceeded
+
+			assert.True(t, recordPodOutputNow(pod, clientManager, noopWatcherMetrics{}), "the outputs are recorded")
+			assertNoSentinel(t, hook.AllEntries())
+		})
+	}
+}
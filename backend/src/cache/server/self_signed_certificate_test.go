@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseCertificatePEM(t *testing.T, certPEM []byte) *x509.Certificate {
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+	return cert
+}
+
+func TestSelfSignedCertificateChainVerifies(t *testing.T) {
+	dnsNames := []string{"cache-server.kubeflow.svc", "cache-server.kubeflow.svc.cluster.local"}
+	generated, err := GenerateSelfSignedCertificate(dnsNames, time.Hour)
+	require.Nil(t, err)
+
+	ca := parseCertificatePEM(t, generated.CACertPEM)
+	assert.True(t, ca.IsCA)
+	cert := parseCertificatePEM(t, generated.CertPEM)
+	assert.False(t, cert.IsCA)
+	assert.True(t, cert.NotAfter.Before(time.Now().Add(time.Hour)))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, name := range append(dnsNames, "localhost", "127.0.0.1", "::1") {
+		_, err := cert.Verify(x509.VerifyOptions{
+			DNSName:   name,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		assert.Nil(t, err, name)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "cache-server.other.svc", Roots: roots})
+	assert.NotNil(t, err)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "localhost"})
+	assert.NotNil(t, err, "verified without the generated CA")
+
+	other, err := GenerateSelfSignedCertificate(dnsNames, time.Hour)
+	require.Nil(t, err)
+	assert.NotEqual(t, generated.CACertPEM, other.CACertPEM)
+}
+
+func TestSelfSignedCertificateIsServedByCertificateReloader(t *testing.T) {
+	generated, err := GenerateSelfSignedCertificate([]string{"cache-server.kubeflow.svc"}, time.Hour)
+	require.Nil(t, err)
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.Nil(t, generated.WriteKeyPair(certPath, keyPath))
+	info, err := os.Stat(keyPath)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	require.Nil(t, err)
+	listener := startTLSListener(t, reloader)
+	defer listener.Close()
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(generated.CACertPEM))
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "cache-server.kubeflow.svc"})
+	require.Nil(t, err)
+	conn.Close()
+}
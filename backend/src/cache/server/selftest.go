@@ -0,0 +1,340 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	SelfTestAPI string = "/selftest"
+
+	// DefaultSelfTestTimeout bounds each check of the self test.
+	DefaultSelfTestTimeout = 5 * time.Second
+
+	SelfTestCheckAdmission string = "admission"
+	SelfTestCheckStore     string = "store"
+
+	// selfTestKeyPrefix starts the cache keys of the sentinel entries, which cannot collide with the
+	// hex encoded keys of templates.
+	selfTestKeyPrefix string = "selftest-"
+	// maxSelfTestResponseBytes bounds the admission response read by the self test.
+	maxSelfTestResponseBytes int64 = 1 << 20
+)
+
+// selfTestTemplate is the Argo template of the fixture pod.
+const selfTestTemplate = `{"name":"cache-self-test","container":{"image":"alpine","command":["echo","cache self test"]}}`
+
+// selfTestAdmissionReview is the fixture posted to the webhook by the self test, the review of a
+// cache enabled KFP pod labeled with the keys of the webhook. Its UID and namespace are set for
+// every run.
+func selfTestAdmissionReview(keys AnnotationKeys) string {
+	return `{
+  "kind": "AdmissionReview",
+  "apiVersion": "admission.k8s.io/v1beta1",
+  "request": {
+    "kind": {"group": "", "version": "v1", "kind": "Pod"},
+    "resource": {"group": "", "version": "v1", "resource": "pods"},
+    "operation": "CREATE",
+    "object": {
+      "apiVersion": "v1",
+      "kind": "Pod",
+      "metadata": {
+        "generateName": "cache-self-test-",
+        "labels": {"` + keys.CacheEnabledLabelKey + `": "` + KFPCacheEnabledLabelValue + `"},
+        "annotations": {
+          "` + ArgoWorkflowNodeName + `": "cache-self-test",
+          "` + ArgoWorkflowTemplate + `": ` + jsonString(selfTestTemplate) + `
+        }
+      },
+      "spec": {"containers": [{"name": "main", "image": "alpine", "command": ["echo", "cache self test"]}]}
+    }
+  }
+}`
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// SelfTestReport is the body of /selftest.
+type SelfTestReport struct {
+	Status string             `json:"status"`
+	Checks []DependencyStatus `json:"checks"`
+}
+
+// SelfTest exercises the webhook end to end: it posts the fixture review to the listener of the
+// webhook, validates the patch of the response and round-trips a sentinel entry through the
+// cache store.
+type SelfTest struct {
+	// WebhookURL is the mutate API of the local listener, e.g. https://localhost:8443/mutate.
+	WebhookURL string
+	// RootCAs returns the CAs the serving certificate must chain to. It is called on every run, so
+	// that rotated certificates are picked up. The webhook is posted to over plain HTTP when nil.
+	RootCAs func() (*x509.CertPool, error)
+	// ClientCertificate is presented to a webhook requiring client certificates.
+	ClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	// Webhook is the webhook serving at WebhookURL, whose keys and settings the patch is checked
+	// against.
+	Webhook *Webhook
+	// Namespace is the namespace of the fixture pod.
+	Namespace     string
+	ClientManager ClientManagerInterface
+	// Timeout bounds each check.
+	Timeout time.Duration
+}
+
+// Checks returns the checks of the self test, all of which must pass.
+func (s *SelfTest) Checks() []DependencyCheck {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSelfTestTimeout
+	}
+	return []DependencyCheck{
+		{Name: SelfTestCheckAdmission, Check: s.checkAdmission, Timeout: timeout, Critical: true},
+		{Name: SelfTestCheckStore, Check: s.checkStore, Timeout: timeout, Critical: true},
+	}
+}
+
+// Run runs the checks one after the other and reports whether all passed.
+func (s *SelfTest) Run(ctx context.Context) SelfTestReport {
+	checks := s.Checks()
+	report := SelfTestReport{Status: HealthStatusOK, Checks: make([]DependencyStatus, len(checks))}
+	for i, check := range checks {
+		report.Checks[i] = runDependencyCheck(ctx, check)
+		if report.Checks[i].Status != HealthStatusOK {
+			logger.Warnf("Self test check %s failed: %s", check.Name, report.Checks[i].Error)
+			report.Status = HealthStatusUnavailable
+		}
+	}
+	return report
+}
+
+// SelfTestHandler runs the self test and answers 200 when it passed, 503 otherwise, with the
+// report of every check.
+func SelfTestHandler(selfTest *SelfTest) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := selfTest.Run(r.Context())
+		b, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(ContentType, JsonContentType)
+		if report.Status != HealthStatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(b)
+	})
+}
+
+// checkAdmission posts the fixture review to the webhook and validates that the response patches
+// the execution key of the fixture template into the pod.
+func (s *SelfTest) checkAdmission(ctx context.Context) error {
+	transport := &http.Transport{DisableKeepAlives: true}
+	if s.RootCAs != nil {
+		roots, err := s.RootCAs()
+		if err != nil {
+			return fmt.Errorf("could not load the CAs of the serving certificate: %v", err)
+		}
+		transport.TLSClientConfig = selfTestTLSConfig(roots, s.ClientCertificate)
+	}
+	uid := types.UID(selfTestKeyPrefix + uuid.New().String())
+	body, err := selfTestReview(s.Webhook.keys, uid, s.Namespace)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ContentType, JsonContentType)
+	resp, err := (&http.Client{Transport: transport}).Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not post the admission review: %v", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSelfTestResponseBytes))
+	if err != nil {
+		return fmt.Errorf("could not read the admission response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the webhook answered %s: %s", resp.Status, strings.TrimSpace(string(responseBody)))
+	}
+	return s.validateResponse(ctx, uid, responseBody)
+}
+
+// selfTestTLSConfig verifies that the serving certificate chains to one of roots. Its host name is
+// not verified, the webhook being reached on localhost rather than on the name of its Service.
+func selfTestTLSConfig(roots *x509.CertPool, clientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		// The chain is verified by VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("the webhook presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			var leaf *x509.Certificate
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				if i == 0 {
+					leaf = cert
+				} else {
+					intermediates.AddCert(cert)
+				}
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		},
+		GetClientCertificate: clientCertificate,
+	}
+}
+
+// SelfTestRootCAs returns the function reading the CAs of the PEM file at path, e.g. the CA of the
+// serving certificate, or the serving certificate itself to trust it alone.
+func SelfTestRootCAs(path string) func() (*x509.CertPool, error) {
+	return func() (*x509.CertPool, error) {
+		caPEM, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in %s", path)
+		}
+		return roots, nil
+	}
+}
+
+func selfTestReview(keys AnnotationKeys, uid types.UID, namespace string) ([]byte, error) {
+	var review v1beta1.AdmissionReview
+	if err := json.Unmarshal([]byte(selfTestAdmissionReview(keys)), &review); err != nil {
+		return nil, fmt.Errorf("invalid self test fixture: %v", err)
+	}
+	review.Request.UID = uid
+	review.Request.Namespace = namespace
+	return json.Marshal(review)
+}
+
+func (s *SelfTest) validateResponse(ctx context.Context, uid types.UID, body []byte) error {
+	var review struct {
+		Response *admissionResponseWithWarnings `json:"response"`
+	}
+	if err := json.Unmarshal(body, &review); err != nil {
+		return fmt.Errorf("could not parse the admission response: %v", err)
+	}
+	response := review.Response
+	switch {
+	case response == nil:
+		return errors.New("the admission review has no response")
+	case response.UID != uid:
+		return fmt.Errorf("the response is for request %q instead of %q", response.UID, uid)
+	case !response.Allowed:
+		message := ""
+		if response.Result != nil {
+			message = response.Result.Message
+		}
+		return fmt.Errorf("the pod was rejected: %s", message)
+	case len(response.Warnings) > 0:
+		return fmt.Errorf("the pod was admitted with warnings: %s", strings.Join(response.Warnings, "; "))
+	}
+	var patches []patchOperation
+	if err := json.Unmarshal(response.Patch, &patches); err != nil {
+		return fmt.Errorf("could not parse the patch: %v", err)
+	}
+	var annotations, labels map[string]interface{}
+	for _, patch := range patches {
+		if patch.Op != OperationTypeAdd {
+			continue
+		}
+		values, _ := patch.Value.(map[string]interface{})
+		switch patch.Path {
+		case AnnotationPath:
+			annotations = values
+		case LabelPath:
+			labels = values
+		}
+	}
+	// The pod is keyed by the default key version of the webhook.
+	keys := s.Webhook.keys
+	keyVersion, _ := annotations[keys.CacheKeyVersionKey].(string)
+	if keyVersion == "" {
+		keyVersion = CacheKeyVersionV1
+	}
+	expectedKey, err := s.Webhook.templateKeys.cacheKey(ctx, keyVersion, s.Webhook.mutationConfig().IgnoredFields, selfTestTemplate)
+	if err != nil {
+		return fmt.Errorf("could not generate the cache key of the self test fixture: %v", err)
+	}
+	if key := annotations[keys.ExecutionKey]; key != expectedKey {
+		return fmt.Errorf("the patch sets the execution key %v instead of %s", key, expectedKey)
+	}
+	if _, exists := labels[keys.CacheIDLabelKey]; !exists {
+		return fmt.Errorf("the patch does not add the %s label", keys.CacheIDLabelKey)
+	}
+	return nil
+}
+
+// checkStore writes, reads and deletes a sentinel entry of its own key.
+func (s *SelfTest) checkStore(ctx context.Context) error {
+	store := s.ClientManager.CacheStore()
+	key := selfTestKeyPrefix + uuid.New().String()
+	created, err := store.CreateExecutionCache(ctx, &model.ExecutionCache{
+		ExecutionCacheKey: key,
+		ExecutionTemplate: selfTestTemplate,
+		ExecutionOutput:   "{}",
+		MaxCacheStaleness: -1,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write the sentinel entry: %v", err)
+	}
+	entry, err := store.GetExecutionCache(ctx, key, -1, storage.ExecutionCacheFilter{})
+	if err == nil && entry == nil {
+		err = errors.New("not found")
+	}
+	deleteErr := store.DeleteExecutionCache(ctx, created.ExecutionCacheKey)
+	if err != nil {
+		return fmt.Errorf("could not read the sentinel entry back: %v", err)
+	}
+	if deleteErr != nil {
+		return fmt.Errorf("could not delete the sentinel entry: %v", deleteErr)
+	}
+	if _, err := store.GetExecutionCache(ctx, key, -1, storage.ExecutionCacheFilter{}); !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+		return fmt.Errorf("the sentinel entry is still found after deleting it: %v", err)
+	}
+	return nil
+}
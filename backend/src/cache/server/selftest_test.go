@@ -0,0 +1,303 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// faultyExecutionCacheStore fails the calls given an error, and deletes nothing when ignoreDelete
+// is set.
+type faultyExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	mu           sync.Mutex
+	createErr    error
+	getErr       error
+	deleteErr    error
+	ignoreDelete bool
+}
+
+func (s *faultyExecutionCacheStore) setCreateErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createErr = err
+}
+
+func (s *faultyExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	s.mu.Lock()
+	createErr := s.createErr
+	s.mu.Unlock()
+	if createErr != nil {
+		return nil, createErr
+	}
+	return s.ExecutionCacheStoreInterface.CreateExecutionCache(ctx, executionCache)
+}
+
+func (s *faultyExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	return s.ExecutionCacheStoreInterface.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+}
+
+func (s *faultyExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	if s.ignoreDelete {
+		return nil
+	}
+	return s.ExecutionCacheStoreInterface.DeleteExecutionCache(ctx, executionCacheKey)
+}
+
+// newSelfTest returns the self test of the webhook served over TLS, admitting with admit and
+// looking up clientManager's store.
+func newSelfTest(t *testing.T, webhook *Webhook, admit admitFunc, clientManager *FakeClientManager) *SelfTest {
+	server := httptest.NewTLSServer(webhook.AdmitFuncHandler(admit, clientManager))
+	t.Cleanup(server.Close)
+	return &SelfTest{
+		WebhookURL: server.URL + "/mutate",
+		RootCAs: func() (*x509.CertPool, error) {
+			roots := x509.NewCertPool()
+			roots.AddCert(server.Certificate())
+			return roots, nil
+		},
+		Namespace:     "kubeflow",
+		ClientManager: clientManager,
+		Webhook:       webhook,
+	}
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	webhook := NewWebhook(WebhookConfig{})
+	selfTest := newSelfTest(t, webhook, webhook.MutatePodIfCached, clientManager)
+
+	rr := httptest.NewRecorder()
+	SelfTestHandler(selfTest).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, SelfTestAPI, nil))
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report SelfTestReport
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, HealthStatusOK, report.Status)
+	require.Len(t, report.Checks, 2)
+	for _, check := range report.Checks {
+		assert.Equal(t, HealthStatusOK, check.Status, check.Error)
+	}
+	// The sentinel entry is gone.
+	var sentinels int
+	require.Nil(t, clientManager.DB().Table("execution_caches").Where("ExecutionCacheKey LIKE ?", selfTestKeyPrefix+"%").Count(&sentinels).Error)
+	assert.Equal(t, 0, sentinels)
+}
+
+func TestSelfTestPassesOverPlainHTTP(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	webhook := NewWebhook(WebhookConfig{})
+	server := httptest.NewServer(webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager))
+	defer server.Close()
+	selfTest := &SelfTest{WebhookURL: server.URL + "/mutate", Namespace: "kubeflow", ClientManager: clientManager, Webhook: webhook}
+
+	assert.Equal(t, HealthStatusOK, selfTest.Run(context.Background()).Status)
+}
+
+func TestSelfTestFailures(t *testing.T) {
+	patchingNothing := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		return nil, nil
+	}
+	patchingAnotherKey := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		return []patchOperation{
+			{Op: OperationTypeAdd, Path: AnnotationPath, Value: map[string]string{podKeys.ExecutionKey: "0123"}},
+			{Op: OperationTypeAdd, Path: LabelPath, Value: map[string]string{podKeys.CacheIDLabelKey: ""}},
+		}, nil
+	}
+	patchingNoLabel := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		patches, err := NewWebhook(WebhookConfig{}).MutatePodIfCached(ctx, req, clientMgr)
+		return patches[:1], err
+	}
+	failing := func(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+		return nil, errors.New("could not deserialize pod object")
+	}
+	tests := []struct {
+		name        string
+		admit       admitFunc
+		store       *faultyExecutionCacheStore
+		config      MutationConfig
+		modify      func(selfTest *SelfTest)
+		failedCheck string
+		wantErr     string
+	}{
+		{
+			name: "untrusted serving certificate",
+			modify: func(selfTest *SelfTest) {
+				selfTest.RootCAs = func() (*x509.CertPool, error) { return x509.NewCertPool(), nil }
+			},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "certificate signed by unknown authority",
+		},
+		{
+			name: "unreadable CAs",
+			modify: func(selfTest *SelfTest) {
+				selfTest.RootCAs = SelfTestRootCAs("/nonexistent/ca.crt")
+			},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "could not load the CAs of the serving certificate",
+		},
+		{
+			name: "webhook not listening",
+			modify: func(selfTest *SelfTest) {
+				selfTest.WebhookURL = "https://127.0.0.1:1/mutate"
+			},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "could not post the admission review",
+		},
+		{
+			name:        "webhook refusing the request",
+			config:      MutationConfig{MaxRequestBodyBytes: 64},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the webhook answered 413 Request Entity Too Large: Request body exceeds the limit of 64 bytes",
+		},
+		{
+			name:        "pod rejected",
+			admit:       failing,
+			config:      MutationConfig{FailPolicy: FailPolicyClosed},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the pod was rejected: pipelines.kubeflow.org cache webhook rejected the pod under the closed fail policy: could not deserialize pod object",
+		},
+		{
+			name:        "patch without execution key",
+			admit:       patchingNothing,
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the patch sets the execution key <nil> instead of",
+		},
+		{
+			name:        "patch with another execution key",
+			admit:       patchingAnotherKey,
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the patch sets the execution key 0123 instead of",
+		},
+		{
+			name:        "patch without cache id label",
+			admit:       patchingNoLabel,
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the patch does not add the pipelines.kubeflow.org/cache_id label",
+		},
+		{
+			name:        "lookup failing",
+			store:       &faultyExecutionCacheStore{getErr: errors.New("connection refused")},
+			failedCheck: SelfTestCheckAdmission,
+			wantErr:     "the pod was admitted with warnings: pipelines.kubeflow.org cache webhook: execution cache lookup failed",
+		},
+		{
+			name:        "write failing",
+			store:       &faultyExecutionCacheStore{createErr: errors.New("read-only transaction")},
+			failedCheck: SelfTestCheckStore,
+			wantErr:     "could not write the sentinel entry: read-only transaction",
+		},
+		{
+			name:        "read failing",
+			store:       &faultyExecutionCacheStore{getErr: errors.New("connection refused")},
+			failedCheck: SelfTestCheckStore,
+			wantErr:     "could not read the sentinel entry back: connection refused",
+		},
+		{
+			name:        "delete failing",
+			store:       &faultyExecutionCacheStore{deleteErr: errors.New("lock wait timeout")},
+			failedCheck: SelfTestCheckStore,
+			wantErr:     "could not delete the sentinel entry: lock wait timeout",
+		},
+		{
+			name:        "delete ineffective",
+			store:       &faultyExecutionCacheStore{ignoreDelete: true},
+			failedCheck: SelfTestCheckStore,
+			wantErr:     "the sentinel entry is still found after deleting it",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook := NewWebhook(WebhookConfig{Mutation: test.config})
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			if test.store != nil {
+				test.store.ExecutionCacheStoreInterface = clientManager.cacheStore
+				clientManager.cacheStore = test.store
+			}
+			admit := test.admit
+			if admit == nil {
+				admit = webhook.MutatePodIfCached
+			}
+			selfTest := newSelfTest(t, webhook, admit, clientManager)
+			if test.modify != nil {
+				test.modify(selfTest)
+			}
+
+			rr := httptest.NewRecorder()
+			SelfTestHandler(selfTest).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, SelfTestAPI, nil))
+
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+			var report SelfTestReport
+			require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+			assert.Equal(t, HealthStatusUnavailable, report.Status)
+			var failed *DependencyStatus
+			for i := range report.Checks {
+				if report.Checks[i].Name == test.failedCheck {
+					failed = &report.Checks[i]
+				}
+			}
+			require.NotNil(t, failed)
+			assert.Equal(t, HealthStatusUnavailable, failed.Status)
+			assert.Contains(t, failed.Error, test.wantErr)
+		})
+	}
+}
+
+func TestSelfTestRootCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	require.Nil(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	generated, err := GenerateSelfSignedCertificate([]string{"cache-server.kubeflow.svc"}, DefaultSelfSignedCertificateValidity)
+	require.Nil(t, err)
+	caPath := filepath.Join(dir, "ca.crt")
+	require.Nil(t, ioutil.WriteFile(caPath, generated.CACertPEM, 0600))
+
+	_, err = SelfTestRootCAs(filepath.Join(dir, "missing.crt"))()
+	assert.NotNil(t, err)
+	_, err = SelfTestRootCAs(notPEM)()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no PEM encoded certificates found")
+	roots, err := SelfTestRootCAs(caPath)()
+	require.Nil(t, err)
+	assert.NotNil(t, roots)
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultShutdownGracePeriod = 25 * time.Second
+	// DefaultShutdownDrainDelay leaves the endpoints controller and kube-proxy time to stop routing
+	// admissions to a pod reported as not ready before it closes its listener.
+	DefaultShutdownDrainDelay = 5 * time.Second
+)
+
+var shuttingDown int32
+
+// IsShuttingDown reports whether the webhook has started to shut down, in which case it must no
+// longer be reported as ready.
+func IsShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// ServeUntilSignalled runs serve, which is expected to start srv, until a signal arrives. It then
+// marks the webhook as shutting down, keeps serving for drainDelay while it is reported as not
+// ready, or until another signal arrives, then stops accepting connections and waits up to
+// gracePeriod for in-flight requests to complete. The connections of those still running after
+// gracePeriod are closed. The error of serve is returned if it stops on its own.
+func ServeUntilSignalled(srv *http.Server, serve func() error, signals <-chan os.Signal, drainDelay time.Duration, gracePeriod time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve()
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		logger.Infof("Received %v, reporting not ready for %v before draining in-flight requests for up to %v", sig, drainDelay, gracePeriod)
+	}
+	atomic.StoreInt32(&shuttingDown, 1)
+	if drainDelay > 0 {
+		select {
+		case <-time.After(drainDelay):
+		case sig := <-signals:
+			logger.Infof("Received %v again, draining in-flight requests right away", sig)
+		case err := <-serveErr:
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	shutdownErr := srv.Shutdown(ctx)
+	if shutdownErr != nil && shutdownErr != context.DeadlineExceeded {
+		return shutdownErr
+	}
+	if shutdownErr != nil {
+		// The rest of the teardown, e.g. flushing the audit events, still has to run.
+		logger.Warnf("In-flight requests did not complete within %v, closing their connections", gracePeriod)
+		srv.Close()
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		return err
+	}
+	if shutdownErr == nil {
+		logger.Info("All in-flight requests completed")
+	}
+	return nil
+}
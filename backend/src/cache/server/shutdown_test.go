@@ -0,0 +1,160 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// slowExecutionCacheStore signals each lookup and answers it after a delay.
+type slowExecutionCacheStore struct {
+	storage.ExecutionCacheStoreInterface
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (s *slowExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter storage.ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	s.started <- struct{}{}
+	time.Sleep(s.delay)
+	return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+}
+
+func TestServeUntilSignalledDrainsInFlightRequests(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &slowExecutionCacheStore{delay: 300 * time.Millisecond, started: make(chan struct{}, 1)}
+	clientManager.cacheStore = store
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	webhook := NewWebhook(WebhookConfig{})
+	srv := &http.Server{Handler: webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager)}
+	signals := make(chan os.Signal, 1)
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- ServeUntilSignalled(srv, func() error { return srv.Serve(listener) }, signals, 0, 5*time.Second)
+	}()
+
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: &fakeAdmissionRequest})
+	require.Nil(t, err)
+	url := "http://" + listener.Addr().String() + "/mutate"
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	requestDone := make(chan result, 1)
+	go func() {
+		resp, err := http.Post(url, JsonContentType, bytes.NewReader(body))
+		requestDone <- result{resp, err}
+	}()
+	<-store.started
+	assert.False(t, IsShuttingDown())
+	signals <- syscall.SIGTERM
+
+	r := <-requestDone
+	require.Nil(t, r.err)
+	defer r.resp.Body.Close()
+	assert.Equal(t, http.StatusOK, r.resp.StatusCode)
+	b, err := ioutil.ReadAll(r.resp.Body)
+	require.Nil(t, err)
+	var review v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(b, &review))
+	assert.True(t, review.Response.Allowed)
+	assert.True(t, IsShuttingDown())
+
+	assert.Nil(t, <-serveDone)
+	_, err = http.Post(url, JsonContentType, bytes.NewReader(body))
+	assert.NotNil(t, err)
+}
+
+func TestServeUntilSignalledReturnsServeError(t *testing.T) {
+	srv := &http.Server{}
+	err := ServeUntilSignalled(srv, func() error { return http.ErrServerClosed }, make(chan os.Signal), 0, time.Second)
+	assert.Equal(t, http.ErrServerClosed, err)
+	assert.False(t, IsShuttingDown())
+}
+
+func TestServeUntilSignalledServesWhileReportedNotReady(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	signals := make(chan os.Signal, 1)
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- ServeUntilSignalled(srv, func() error { return srv.Serve(listener) }, signals, time.Minute, 5*time.Second)
+	}()
+
+	signals <- syscall.SIGTERM
+	require.Eventually(t, IsShuttingDown, 5*time.Second, 10*time.Millisecond)
+	resp, err := http.Get("http://" + listener.Addr().String())
+	require.Nil(t, err, "admissions still routed to the pod are served")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Another signal cuts the delay short.
+	signals <- syscall.SIGTERM
+	assert.Nil(t, <-serveDone)
+	_, err = http.Get("http://" + listener.Addr().String())
+	assert.NotNil(t, err)
+}
+
+func TestServeUntilSignalledClosesRequestsStillRunningAfterGracePeriod(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	signals := make(chan os.Signal, 1)
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- ServeUntilSignalled(srv, func() error { return srv.Serve(listener) }, signals, 0, 50*time.Millisecond)
+	}()
+	requestDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		requestDone <- err
+	}()
+	<-started
+
+	signals <- syscall.SIGTERM
+	assert.Nil(t, <-serveDone, "the teardown goes on after the grace period")
+	assert.NotNil(t, <-requestDone, "the connection of the request still running is closed")
+}
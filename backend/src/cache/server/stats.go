@@ -0,0 +1,248 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+)
+
+const (
+	StatsAPI string = "/v1/cache/stats"
+	// StatsSchemaVersion is incremented whenever fields of the stats change meaning or are removed.
+	StatsSchemaVersion int = 1
+	// DefaultStatsCacheInterval is how long the store side of the stats is reused, so that
+	// dashboards polling them do not query the store each time.
+	DefaultStatsCacheInterval = 30 * time.Second
+
+	statsTopTemplates int = 10
+)
+
+// Stats summarize the cache store and the lookups of the webhook process.
+type Stats struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	// Store is missing when the cache store cannot be summarized.
+	Store   *StoreStats  `json:"store,omitempty"`
+	Process ProcessStats `json:"process"`
+}
+
+// StoreStats are the aggregates of the entries of the cache store, as of ComputedAt.
+type StoreStats struct {
+	ComputedAt     time.Time `json:"computedAt"`
+	Entries        int64     `json:"entries"`
+	OutputBytes    int64     `json:"outputBytes"`
+	CreatedLast24h int64     `json:"createdLast24h"`
+	CreatedLast7d  int64     `json:"createdLast7d"`
+	// OldestEntryAgeSeconds is 0 without entries.
+	OldestEntryAgeSeconds int64                `json:"oldestEntryAgeSeconds"`
+	TopTemplatesByBytes   []TemplateStoreStats `json:"topTemplatesByBytes"`
+}
+
+type TemplateStoreStats struct {
+	Template    string `json:"template"`
+	Entries     int64  `json:"entries"`
+	OutputBytes int64  `json:"outputBytes"`
+}
+
+// ProcessStats count the lookups of the webhook since the process started.
+type ProcessStats struct {
+	StartedAt          time.Time              `json:"startedAt"`
+	Hits               int64                  `json:"hits"`
+	Misses             int64                  `json:"misses"`
+	TopTemplatesByHits []TemplateProcessStats `json:"topTemplatesByHits"`
+}
+
+type TemplateProcessStats struct {
+	Template string `json:"template"`
+	Hits     int64  `json:"hits"`
+	Misses   int64  `json:"misses"`
+}
+
+// lookupCounters counts the hits and misses of the webhook, per template.
+type lookupCounters struct {
+	startedAt time.Time
+	templates *templateLabels
+
+	mu             sync.Mutex
+	hits           int64
+	misses         int64
+	templateHits   map[string]int64
+	templateMisses map[string]int64
+}
+
+// factory function for lookup counters of at most maxTemplates templates
+func newLookupCounters(startedAt time.Time, maxTemplates int) *lookupCounters {
+	return &lookupCounters{
+		startedAt:      startedAt,
+		templates:      newTemplateLabels(maxTemplates),
+		templateHits:   make(map[string]int64),
+		templateMisses: make(map[string]int64),
+	}
+}
+
+// processLookups counts the lookups of the process for the stats.
+var processLookups = newLookupCounters(time.Now().UTC(), DefaultMaxTemplateLabels)
+
+func (c *lookupCounters) hit(nodeName string) {
+	template := c.templates.label(nodeName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits++
+	c.templateHits[template]++
+}
+
+func (c *lookupCounters) missed(nodeName string) {
+	template := c.templates.label(nodeName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	c.templateMisses[template]++
+}
+
+func (c *lookupCounters) stats() ProcessStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := ProcessStats{StartedAt: c.startedAt, Hits: c.hits, Misses: c.misses, TopTemplatesByHits: []TemplateProcessStats{}}
+	for template, hits := range c.templateHits {
+		stats.TopTemplatesByHits = append(stats.TopTemplatesByHits, TemplateProcessStats{Template: template, Hits: hits, Misses: c.templateMisses[template]})
+	}
+	for template, misses := range c.templateMisses {
+		if _, ok := c.templateHits[template]; !ok {
+			stats.TopTemplatesByHits = append(stats.TopTemplatesByHits, TemplateProcessStats{Template: template, Misses: misses})
+		}
+	}
+	sort.Slice(stats.TopTemplatesByHits, func(i, j int) bool {
+		a, b := stats.TopTemplatesByHits[i], stats.TopTemplatesByHits[j]
+		return a.Hits > b.Hits || (a.Hits == b.Hits && a.Template < b.Template)
+	})
+	if len(stats.TopTemplatesByHits) > statsTopTemplates {
+		stats.TopTemplatesByHits = stats.TopTemplatesByHits[:statsTopTemplates]
+	}
+	return stats
+}
+
+// storeStatsCache reuses the stats of the store for cacheInterval.
+type storeStatsCache struct {
+	store         storage.ExecutionCacheStatsStore
+	cacheInterval time.Duration
+
+	// mu is held while the stats are computed, so that concurrent requests share one computation.
+	mu         sync.Mutex
+	stats      *storage.ExecutionCacheStats
+	computedAt time.Time
+}
+
+func (c *storeStatsCache) get(ctx context.Context, now time.Time) (*storage.ExecutionCacheStats, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats != nil && now.Sub(c.computedAt) < c.cacheInterval {
+		return c.stats, c.computedAt, nil
+	}
+	stats, err := c.store.ExecutionCacheStats(ctx, now.Unix())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	c.stats, c.computedAt = stats, now
+	return stats, now, nil
+}
+
+// StatsCollector collects the Stats of the store, reused for its cache interval, and of the
+// lookups of the process, for the stats of both the HTTP and gRPC admin APIs.
+type StatsCollector struct {
+	// cache is nil when the store cannot be summarized.
+	cache *storeStatsCache
+	time  util.TimeInterface
+}
+
+// factory function for a StatsCollector reusing the stats of the store for cacheInterval. The
+// store side is left out when store is nil.
+func NewStatsCollector(store storage.ExecutionCacheStatsStore, cacheInterval time.Duration, time util.TimeInterface) *StatsCollector {
+	collector := &StatsCollector{time: time}
+	if store != nil {
+		collector.cache = &storeStatsCache{store: store, cacheInterval: cacheInterval}
+	}
+	return collector
+}
+
+// Collect returns the stats as of now.
+func (c *StatsCollector) Collect(ctx context.Context) (*Stats, error) {
+	now := c.time.Now().UTC()
+	stats := &Stats{SchemaVersion: StatsSchemaVersion, GeneratedAt: now, Process: processLookups.stats()}
+	if c.cache != nil {
+		storeStats, computedAt, err := c.cache.get(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		stats.Store = newStoreStats(storeStats, computedAt, now)
+	}
+	return stats, nil
+}
+
+// StatsHandler serves the Stats of the collector.
+func StatsHandler(collector *StatsCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeAdminError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		stats, err := collector.Collect(r.Context())
+		if err != nil {
+			logger.Errorf("Failed to compute the stats of the cache store: %v", err)
+			writeAdminError(w, http.StatusInternalServerError, redactCredentials(err.Error()))
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, stats)
+	})
+}
+
+func newStoreStats(stats *storage.ExecutionCacheStats, computedAt time.Time, now time.Time) *StoreStats {
+	storeStats := &StoreStats{
+		ComputedAt:          computedAt,
+		Entries:             stats.Entries,
+		OutputBytes:         stats.OutputBytes,
+		CreatedLast24h:      stats.CreatedLastDay,
+		CreatedLast7d:       stats.CreatedLastWeek,
+		TopTemplatesByBytes: []TemplateStoreStats{},
+	}
+	if stats.OldestCreatedAtInSec != 0 {
+		storeStats.OldestEntryAgeSeconds = now.Unix() - stats.OldestCreatedAtInSec
+	}
+	for template, templateStats := range stats.Templates {
+		if template == "" {
+			template = TemplateLabelUnknown
+		}
+		storeStats.TopTemplatesByBytes = append(storeStats.TopTemplatesByBytes, TemplateStoreStats{
+			Template:    template,
+			Entries:     templateStats.Entries,
+			OutputBytes: templateStats.OutputBytes,
+		})
+	}
+	sort.Slice(storeStats.TopTemplatesByBytes, func(i, j int) bool {
+		a, b := storeStats.TopTemplatesByBytes[i], storeStats.TopTemplatesByBytes[j]
+		return a.OutputBytes > b.OutputBytes || (a.OutputBytes == b.OutputBytes && a.Template < b.Template)
+	})
+	if len(storeStats.TopTemplatesByBytes) > statsTopTemplates {
+		storeStats.TopTemplatesByBytes = storeStats.TopTemplatesByBytes[:statsTopTemplates]
+	}
+	return storeStats
+}
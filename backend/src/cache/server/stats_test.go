@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStatsStore counts the computations of the stats of the wrapped store.
+type countingStatsStore struct {
+	storage.ExecutionCacheStatsStore
+	calls int
+}
+
+func (s *countingStatsStore) ExecutionCacheStats(ctx context.Context, nowInSec int64) (*storage.ExecutionCacheStats, error) {
+	s.calls++
+	return s.ExecutionCacheStatsStore.ExecutionCacheStats(ctx, nowInSec)
+}
+
+// newSeededStatsStore returns a store holding an entry of each template with the output, created
+// one second apart from 2020-01-01 on.
+func newSeededStatsStore(t *testing.T, templates []string, outputs []string) *countingStatsStore {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	t.Cleanup(func() { clientManager.Close() })
+	for i, template := range templates {
+		_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+			ExecutionCacheKey: fmt.Sprintf("key-%d", i),
+			ExecutionTemplate: template,
+			ExecutionOutput:   outputs[i],
+			MaxCacheStaleness: -1,
+		})
+		require.Nil(t, err)
+	}
+	return &countingStatsStore{ExecutionCacheStatsStore: clientManager.CacheStore().(storage.ExecutionCacheStatsStore)}
+}
+
+func serveStats(t *testing.T, handler http.Handler, stats *Stats) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, StatsAPI, nil))
+	if stats != nil {
+		require.Nil(t, json.Unmarshal(rr.Body.Bytes(), stats), rr.Body.String())
+	}
+	return rr
+}
+
+func TestStatsHandlerAggregatesTheStore(t *testing.T) {
+	store := newSeededStatsStore(t,
+		[]string{`{"name":"train","container":{}}`, `{"name":"train","script":{}}`, `{"name":"evaluate","container":{}}`, "template"},
+		[]string{"12345", "123", "12", "1"})
+	// The entries were created from 00:00:01 to 00:00:04 on 2020-01-01 and the stats are generated at
+	// 00:00:02 the day after.
+	handler := StatsHandler(NewStatsCollector(store, time.Minute, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 1, 0, time.UTC))))
+
+	var stats Stats
+	rr := serveStats(t, handler, &stats)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, StatsSchemaVersion, stats.SchemaVersion)
+	assert.Equal(t, time.Date(2020, 1, 2, 0, 0, 2, 0, time.UTC), stats.GeneratedAt)
+	require.NotNil(t, stats.Store)
+	assert.Equal(t, StoreStats{
+		ComputedAt:            stats.GeneratedAt,
+		Entries:               4,
+		OutputBytes:           11,
+		CreatedLast24h:        3,
+		CreatedLast7d:         4,
+		OldestEntryAgeSeconds: 24*60*60 + 1,
+		TopTemplatesByBytes: []TemplateStoreStats{
+			{Template: "train", Entries: 2, OutputBytes: 8},
+			{Template: "evaluate", Entries: 1, OutputBytes: 2},
+			{Template: TemplateLabelUnknown, Entries: 1, OutputBytes: 1},
+		},
+	}, *stats.Store)
+}
+
+func TestStatsHandlerReusesTheStoreStatsForTheInterval(t *testing.T) {
+	store := newSeededStatsStore(t, []string{`{"name":"train"}`}, []string{"output"})
+	// The fake time advances by a second on every request.
+	handler := StatsHandler(NewStatsCollector(store, 3*time.Second, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))))
+
+	var first, second, third, fourth Stats
+	serveStats(t, handler, &first)
+	serveStats(t, handler, &second)
+	serveStats(t, handler, &third)
+	assert.Equal(t, 1, store.calls)
+	assert.Equal(t, first.Store.ComputedAt, third.Store.ComputedAt)
+	assert.Equal(t, first.Store.OldestEntryAgeSeconds+2, third.Store.OldestEntryAgeSeconds)
+	assert.True(t, third.GeneratedAt.After(first.GeneratedAt))
+
+	serveStats(t, handler, &fourth)
+	assert.Equal(t, 2, store.calls)
+	assert.Equal(t, fourth.GeneratedAt, fourth.Store.ComputedAt)
+}
+
+func TestStatsHandlerWithoutCacheInterval(t *testing.T) {
+	store := newSeededStatsStore(t, nil, nil)
+	handler := StatsHandler(NewStatsCollector(store, 0, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))))
+
+	var stats Stats
+	serveStats(t, handler, &stats)
+	serveStats(t, handler, &stats)
+	assert.Equal(t, 2, store.calls)
+	assert.Equal(t, StoreStats{ComputedAt: stats.GeneratedAt, TopTemplatesByBytes: []TemplateStoreStats{}}, *stats.Store)
+}
+
+func TestStatsHandlerWithoutStore(t *testing.T) {
+	handler := StatsHandler(NewStatsCollector(nil, time.Minute, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))))
+
+	var stats Stats
+	rr := serveStats(t, handler, &stats)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, stats.Store)
+	assert.NotContains(t, rr.Body.String(), `"store"`)
+}
+
+func TestStatsHandlerMergesTheProcessLookups(t *testing.T) {
+	defer func(lookups *lookupCounters) { processLookups = lookups }(processLookups)
+	startedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	processLookups = newLookupCounters(startedAt, 2)
+	processLookups.hit("run.train")
+	processLookups.hit("run.train")
+	processLookups.missed("run.train")
+	processLookups.missed("run.evaluate")
+	processLookups.hit("run.serve")
+	handler := StatsHandler(NewStatsCollector(nil, time.Minute, util.NewFakeTime(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))))
+
+	var stats Stats
+	serveStats(t, handler, &stats)
+	assert.Equal(t, ProcessStats{
+		StartedAt: startedAt,
+		Hits:      3,
+		Misses:    2,
+		TopTemplatesByHits: []TemplateProcessStats{
+			{Template: "train", Hits: 2, Misses: 1},
+			{Template: TemplateLabelOther, Hits: 1},
+			{Template: "evaluate", Misses: 1},
+		},
+	}, stats.Process)
+}
+
+func TestStatsHandlerRejectsOtherMethods(t *testing.T) {
+	handler := StatsHandler(NewStatsCollector(nil, time.Minute, util.NewRealTime()))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, StatsAPI, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, http.MethodGet, rr.Header().Get("Allow"))
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// TektonTaskRunLabelKey labels the pods of Tekton with the name of their TaskRun, and
+	// TektonPipelineTaskLabelKey with the name of their task in the pipeline.
+	TektonTaskRunLabelKey      string = "tekton.dev/taskRun"
+	TektonPipelineTaskLabelKey string = "tekton.dev/pipelineTask"
+
+	tektonStepPrefix         string = "step-"
+	tektonSidecarPrefix      string = "sidecar-"
+	tektonPlaceScripts       string = "place-scripts"
+	tektonInternalVolume     string = "tekton-"
+	tektonResultsPath        string = "/tekton/results"
+	tektonEntrypointFlag     string = "-entrypoint"
+	tektonTaskRunResultType  int    = 1
+	tektonCachedStepsMessage string = "This step output is taken from cache."
+)
+
+// tektonResultNamePattern matches the names Tekton allows for results, which are files of the
+// results directory.
+var tektonResultNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// tektonScriptPattern matches the paths of the scripts of the steps, which end with a random
+// suffix in every pod.
+var tektonScriptPattern = regexp.MustCompile(`(/tekton/scripts/(?:sidecar-)?script-[0-9]+)-[a-z0-9]{5}`)
+
+// tektonTaskSpec is the part of the TaskSpec of a TaskRun that affects the cache key, as resolved
+// in its pod: the steps and sidecars with their parameters substituted, and the scripts they run.
+// The coordination of the steps by Tekton's entrypoint and Tekton's own volumes are left out.
+type tektonTaskSpec struct {
+	Steps    []tektonStep `json:"steps"`
+	Sidecars []tektonStep `json:"sidecars,omitempty"`
+	Scripts  []string     `json:"scripts,omitempty"`
+}
+
+type tektonStep struct {
+	Name         string               `json:"name"`
+	Image        string               `json:"image"`
+	Command      []string             `json:"command,omitempty"`
+	Args         []string             `json:"args,omitempty"`
+	WorkingDir   string               `json:"workingDir,omitempty"`
+	Env          []corev1.EnvVar      `json:"env,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+// tektonResult is a result of a step, as Tekton's entrypoint writes them to the termination
+// message of the step's container.
+type tektonResult struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+// isTektonPod reports whether the pod runs a TaskRun, e.g. of the kfp-tekton backend.
+func isTektonPod(pod *corev1.Pod) bool {
+	_, exists := pod.ObjectMeta.Labels[TektonTaskRunLabelKey]
+	return exists
+}
+
+// tektonPodOrchestrator handles the pods of Tekton TaskRuns. Their template is the TaskSpec resolved
+// from their containers, and their outputs are the results of their steps.
+type tektonPodOrchestrator struct{}
+
+// completed reports whether the pod terminated, Tekton does not label the pods it is done with.
+func (tektonPodOrchestrator) completed(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func (tektonPodOrchestrator) stepFailed(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if strings.HasPrefix(status.Name, tektonStepPrefix) && status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (tektonPodOrchestrator) nodeName(pod *corev1.Pod) string {
+	if task, exists := pod.ObjectMeta.Labels[TektonPipelineTaskLabelKey]; exists {
+		return task
+	}
+	return pod.ObjectMeta.Labels[TektonTaskRunLabelKey]
+}
+
+func (tektonPodOrchestrator) template(pod *corev1.Pod) (string, bool) {
+	var spec tektonTaskSpec
+	for _, container := range pod.Spec.Containers {
+		switch {
+		case strings.HasPrefix(container.Name, tektonStepPrefix):
+			spec.Steps = append(spec.Steps, newTektonStep(container, tektonStepPrefix))
+		case strings.HasPrefix(container.Name, tektonSidecarPrefix):
+			spec.Sidecars = append(spec.Sidecars, newTektonStep(container, tektonSidecarPrefix))
+		}
+	}
+	if len(spec.Steps) == 0 {
+		return "", false
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == tektonPlaceScripts {
+			for _, arg := range container.Args {
+				spec.Scripts = append(spec.Scripts, tektonScriptPattern.ReplaceAllString(arg, "$1"))
+			}
+		}
+	}
+	// The spec only holds strings and lists of them, which marshal.
+	template, _ := json.Marshal(spec)
+	return string(template), true
+}
+
+// newTektonStep returns the step of the container, with the command it runs rather than the call
+// of Tekton's entrypoint waiting for the previous step.
+func newTektonStep(container corev1.Container, prefix string) tektonStep {
+	step := tektonStep{
+		Name:       strings.TrimPrefix(container.Name, prefix),
+		Image:      container.Image,
+		Command:    container.Command,
+		Args:       container.Args,
+		WorkingDir: container.WorkingDir,
+	}
+	if i := indexOf(container.Args, tektonEntrypointFlag); i >= 0 && i+1 < len(container.Args) {
+		step.Command = []string{container.Args[i+1]}
+		step.Args = nil
+		if separator := indexOf(container.Args[i:], "--"); separator >= 0 {
+			step.Args = container.Args[i+separator+1:]
+		}
+	}
+	step.Command = withoutScriptSuffixes(step.Command)
+	step.Args = withoutScriptSuffixes(step.Args)
+	step.Env = append([]corev1.EnvVar(nil), container.Env...)
+	sort.SliceStable(step.Env, func(a, b int) bool { return step.Env[a].Name < step.Env[b].Name })
+	for _, mount := range container.VolumeMounts {
+		if !strings.HasPrefix(mount.Name, tektonInternalVolume) {
+			step.VolumeMounts = append(step.VolumeMounts, mount)
+		}
+	}
+	sort.SliceStable(step.VolumeMounts, func(a, b int) bool { return step.VolumeMounts[a].MountPath < step.VolumeMounts[b].MountPath })
+	return step
+}
+
+func withoutScriptSuffixes(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	stripped := make([]string, len(values))
+	for i, value := range values {
+		stripped[i] = tektonScriptPattern.ReplaceAllString(value, "$1")
+	}
+	return stripped
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// cacheKey hashes the TaskSpec without the ignored fields. Its keys are the same under every key
+// version, and the images of the steps are not resolved to their digests.
+func (tektonPodOrchestrator) cacheKey(ctx context.Context, keyer *templateKeyer, version string, ignored *IgnoredTemplateFields, template string) (string, error) {
+	template, err := ignored.strip(template)
+	if err != nil {
+		return "", err
+	}
+	var spec tektonTaskSpec
+	if err := json.Unmarshal([]byte(template), &spec); err != nil {
+		return "", fmt.Errorf("invalid TaskSpec: %v", err)
+	}
+	canonical, _ := json.Marshal(spec)
+	md := sha256.New()
+	md.Write([]byte("tekton\x00"))
+	md.Write(canonical)
+	return hex.EncodeToString(md.Sum(nil)), nil
+}
+
+// outputs returns the results of the steps as the parameters of the outputs. Every step reports
+// the results written so far, so the last value of each result is kept.
+func (tektonPodOrchestrator) outputs(pod *corev1.Pod) (string, bool) {
+	var podOutputs outputs.Outputs
+	indexes := map[string]int{}
+	for _, status := range pod.Status.ContainerStatuses {
+		if !strings.HasPrefix(status.Name, tektonStepPrefix) || status.State.Terminated == nil || status.State.Terminated.Message == "" {
+			continue
+		}
+		var results []tektonResult
+		if err := json.Unmarshal([]byte(status.State.Terminated.Message), &results); err != nil {
+			continue
+		}
+		for _, result := range results {
+			if result.Type != tektonTaskRunResultType {
+				continue
+			}
+			value := result.Value
+			parameter := outputs.Parameter{Name: result.Key, Value: &value}
+			if i, exists := indexes[result.Key]; exists {
+				podOutputs.Parameters[i] = parameter
+			} else {
+				indexes[result.Key] = len(podOutputs.Parameters)
+				podOutputs.Parameters = append(podOutputs.Parameters, parameter)
+			}
+		}
+	}
+	canonical, err := podOutputs.Canonical()
+	return canonical, err == nil
+}
+
+// restoreOutputs keeps the steps under Tekton's entrypoint, which reports the results written to
+// the results directory as the step exits, but has them write the cached results instead of
+// running, in the image of the dummy container, which must provide sh. The init containers
+// installing the entrypoint are kept.
+func (tektonPodOrchestrator) restoreOutputs(ctx context.Context, pod *corev1.Pod, entry *model.ExecutionCache, cachedOutputs string, annotations map[string]string, dummy DummyContainer) ([]patchOperation, error) {
+	cached, err := outputs.Parse(cachedOutputs)
+	if err != nil {
+		return nil, err
+	}
+	script := "echo " + shellQuote(tektonCachedStepsMessage)
+	for _, parameter := range cached.Parameters {
+		if !tektonResultNamePattern.MatchString(parameter.Name) || parameter.Name == "." || parameter.Name == ".." {
+			return nil, fmt.Errorf("invalid result name %q", parameter.Name)
+		}
+		if parameter.Value != nil {
+			script += fmt.Sprintf(" && printf '%%s' %s > %s", shellQuote(*parameter.Value), shellQuote(tektonResultsPath+"/"+parameter.Name))
+		}
+	}
+	containers := make([]corev1.Container, len(pod.Spec.Containers))
+	for i, container := range pod.Spec.Containers {
+		containers[i] = container
+		if !strings.HasPrefix(container.Name, tektonStepPrefix) {
+			continue
+		}
+		containers[i].Image = dummy.image()
+		if dummy.hasResources() {
+			containers[i].Resources = dummy.Resources
+		}
+		if flag := indexOf(container.Args, tektonEntrypointFlag); flag >= 0 {
+			containers[i].Args = append(append([]string(nil), container.Args[:flag]...), tektonEntrypointFlag, "sh", "--", "-c", script)
+		} else {
+			containers[i].Command = []string{"sh", "-c", script}
+			containers[i].Args = nil
+		}
+	}
+	return []patchOperation{{
+		Op:    OperationTypeReplace,
+		Path:  SpecContainersPath,
+		Value: containers,
+	}}, nil
+}
+
+// shellQuote quotes the value for sh.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
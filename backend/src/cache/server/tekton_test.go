@@ -0,0 +1,208 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// tektonPod returns the pod of a TaskRun with a step echoing the message through Tekton's
+// entrypoint and a step running a script, whose paths end with the suffix.
+func tektonPod(name string, message string, scriptSuffix string) *corev1.Pod {
+	script := "/tekton/scripts/script-1-" + scriptSuffix
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: watchedNamespace,
+			Labels: map[string]string{
+				podKeys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue,
+				TektonTaskRunLabelKey:        name + "-run",
+				TektonPipelineTaskLabelKey:   "train",
+			},
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "place-tools", Image: "entrypoint", Command: []string{"/ko-app/entrypoint", "cp", "/ko-app/entrypoint", "/tekton/tools/entrypoint"}},
+				{Name: "place-scripts", Image: "shell", Command: []string{"sh"}, Args: []string{"-c", `scriptfile="` + script + `"
+cat > ${scriptfile} << '_EOF_'
+IyEvYmluL3NoCmVjaG8gZG9uZQ==
+_EOF_`}},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:    "step-echo",
+					Image:   "python:3.7",
+					Command: []string{"/tekton/tools/entrypoint"},
+					Args: []string{"-wait_file", "/tekton/downward/ready", "-post_file", "/tekton/tools/0", "-termination_path", "/tekton/termination",
+						"-results", "message", "-entrypoint", "echo", "--", message},
+					Env:          []corev1.EnvVar{{Name: "MODE", Value: "fast"}, {Name: "HOME", Value: "/tekton/home"}},
+					VolumeMounts: []corev1.VolumeMount{{Name: "tekton-internal-tools", MountPath: "/tekton/tools"}, {Name: "data", MountPath: "/data"}},
+				},
+				{
+					Name:         "step-script",
+					Image:        "python:3.7",
+					Command:      []string{"/tekton/tools/entrypoint"},
+					Args:         []string{"-wait_file", "/tekton/tools/0", "-post_file", "/tekton/tools/1", "-entrypoint", script, "--"},
+					VolumeMounts: []corev1.VolumeMount{{Name: "tekton-internal-scripts-" + scriptSuffix, MountPath: "/tekton/scripts"}},
+				},
+			},
+		},
+	}
+}
+
+func TestTektonTemplate(t *testing.T) {
+	orchestrator := podOrchestratorOf(tektonPod("step", "Hello", "abcde"))
+	require.Equal(t, tektonPodOrchestrator{}, orchestrator)
+	assert.Equal(t, argoPodOrchestrator{}, podOrchestratorOf(fakePod))
+
+	template, exists := orchestrator.template(tektonPod("step", "Hello", "abcde"))
+	require.True(t, exists)
+	var spec tektonTaskSpec
+	require.Nil(t, json.Unmarshal([]byte(template), &spec))
+	require.Len(t, spec.Steps, 2)
+	assert.Equal(t, tektonStep{
+		Name:         "echo",
+		Image:        "python:3.7",
+		Command:      []string{"echo"},
+		Args:         []string{"Hello"},
+		Env:          []corev1.EnvVar{{Name: "HOME", Value: "/tekton/home"}, {Name: "MODE", Value: "fast"}},
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+	}, spec.Steps[0])
+	assert.Equal(t, []string{"/tekton/scripts/script-1"}, spec.Steps[1].Command)
+	assert.Empty(t, spec.Steps[1].Args)
+	require.Len(t, spec.Scripts, 2)
+	assert.Contains(t, spec.Scripts[1], `scriptfile="/tekton/scripts/script-1"`)
+
+	_, exists = orchestrator.template(&corev1.Pod{})
+	assert.False(t, exists, "pods without steps have no template")
+}
+
+func TestTektonCacheKey(t *testing.T) {
+	orchestrator := tektonPodOrchestrator{}
+	cacheKey := func(pod *corev1.Pod, ignored *IgnoredTemplateFields) string {
+		template, _ := orchestrator.template(pod)
+		key, err := orchestrator.cacheKey(context.Background(), newTemplateKeyer(nil), CacheKeyVersionV1, ignored, template)
+		require.Nil(t, err)
+		return key
+	}
+	key := cacheKey(tektonPod("step", "Hello", "abcde"), nil)
+	assert.Equal(t, key, cacheKey(tektonPod("other", "Hello", "fghij"), nil), "the names of the pods and scripts do not affect the key")
+	assert.NotEqual(t, key, cacheKey(tektonPod("step", "Bye", "abcde"), nil))
+
+	ignored, err := ParseIgnoredTemplateFields("steps[*].env[name=MODE]")
+	require.Nil(t, err)
+	withoutMode := tektonPod("step", "Hello", "abcde")
+	withoutMode.Spec.Containers[0].Env = withoutMode.Spec.Containers[0].Env[1:]
+	assert.Equal(t, cacheKey(withoutMode, nil), cacheKey(tektonPod("step", "Hello", "abcde"), ignored))
+
+	_, err = orchestrator.cacheKey(context.Background(), newTemplateKeyer(nil), CacheKeyVersionV1, nil, `{"steps":`)
+	assert.NotNil(t, err)
+}
+
+func TestTektonOutputs(t *testing.T) {
+	pod := tektonPod("step", "Hello", "abcde")
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "step-echo", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Message: `[{"key":"StartedAt","value":"2020-01-01T00:00:00Z","type":3},{"key":"message","value":"Hi","type":1}]`}}},
+		{Name: "step-script", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Message: `[{"key":"message","value":"Hello","type":1},{"key":"count","value":"2","type":1}]`}}},
+	}
+
+	podOutputs, exists := tektonPodOrchestrator{}.outputs(pod)
+	require.True(t, exists)
+	assert.Equal(t, `{"parameters":[{"name":"message","value":"Hello"},{"name":"count","value":"2"}]}`, podOutputs)
+
+	podOutputs, exists = tektonPodOrchestrator{}.outputs(tektonPod("step", "Hello", "abcde"))
+	require.True(t, exists)
+	assert.Equal(t, `{}`, podOutputs)
+}
+
+func TestTektonPodTermination(t *testing.T) {
+	pod := tektonPod("step", "Hello", "abcde")
+	assert.Equal(t, PodTermination{}, classifyPodTermination(pod))
+
+	pod.Status.Phase = corev1.PodSucceeded
+	assert.Equal(t, PodTermination{Terminated: true}, classifyPodTermination(pod))
+
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "step-echo", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}},
+	}
+	assert.Equal(t, PodTermination{Terminated: true, SkipReason: PodSkipReasonMainFailed}, classifyPodTermination(pod))
+}
+
+func TestMutateAndRecordTektonPod(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	webhook := NewWebhook(WebhookConfig{})
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tektonPod("first", "Hello", "abcde")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 2, "the pod misses")
+	annotations := patches[0].Value.(map[string]string)
+	key := annotations[podKeys.ExecutionKey]
+	require.NotEmpty(t, key)
+
+	completed := tektonPod("first", "Hello", "abcde")
+	completed.ObjectMeta.Annotations = annotations
+	completed.ObjectMeta.Labels = patches[1].Value.(map[string]string)
+	completed.Status.Phase = corev1.PodSucceeded
+	completed.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "step-echo", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Message: `[{"key":"message","value":"it's done","type":1}]`}}},
+	}
+	clientset := fake.NewSimpleClientset(completed)
+	require.True(t, recordPodOutputNow(completed, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), key, -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, `{"parameters":[{"name":"message","value":"it's done"}]}`, getValueFromSerializedMap(entry.ExecutionOutput, ArgoWorkflowOutputs))
+	assert.Contains(t, entry.ExecutionTemplate, `"steps"`)
+
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tektonPod("second", "Hello", "fghij")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 3, "the pod of the same TaskSpec hits")
+	assert.Equal(t, SpecContainersPath, patches[0].Path)
+	containers := patches[0].Value.([]corev1.Container)
+	require.Len(t, containers, 2)
+	assert.Equal(t, "alpine", containers[0].Image)
+	assert.Equal(t, []string{"/tekton/tools/entrypoint"}, containers[0].Command)
+	assert.Equal(t, []string{"-wait_file", "/tekton/downward/ready", "-post_file", "/tekton/tools/0", "-termination_path", "/tekton/termination",
+		"-results", "message", "-entrypoint", "sh", "--", "-c",
+		`echo 'This step output is taken from cache.' && printf '%s' 'it'\''s done' > '/tekton/results/message'`}, containers[0].Args)
+	assert.Equal(t, []string{"-wait_file", "/tekton/tools/0", "-post_file", "/tekton/tools/1", "-entrypoint", "sh", "--", "-c",
+		`echo 'This step output is taken from cache.' && printf '%s' 'it'\''s done' > '/tekton/results/message'`}, containers[1].Args)
+	labels := patches[2].Value.(map[string]string)
+	assert.Equal(t, KFPCachedLabelValue, labels[podKeys.CachedLabelKey])
+	_, exists := patches[1].Value.(map[string]string)[ArgoWorkflowOutputs]
+	assert.False(t, exists, "Tekton pods get no Argo outputs")
+}
+
+func TestTektonRestoreOutputsRejectsInvalidResultNames(t *testing.T) {
+	_, err := tektonPodOrchestrator{}.restoreOutputs(context.Background(), tektonPod("step", "Hello", "abcde"), &model.ExecutionCache{}, `{"parameters":[{"name":"../etc","value":"x"}]}`, map[string]string{}, DummyContainer{})
+	assert.Contains(t, err.Error(), "invalid result name")
+}
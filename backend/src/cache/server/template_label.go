@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultMaxTemplateLabels int = 100
+
+	TemplateLabelOther   string = "other"
+	TemplateLabelUnknown string = "unknown"
+
+	maxTemplateLabelLength int = 63
+)
+
+// templateNameFromNodeName extracts the template of an Argo node name such as
+// "my-workflow-x7k2p.train.evaluate(1:{\"a\":\"b\"})". Loop item and retry suffixes in parentheses
+// are stripped, the part following the last dot is kept and any character outside of
+// [a-zA-Z0-9_-] is replaced by an underscore, so that every iteration of a step shares a label.
+func templateNameFromNodeName(nodeName string) string {
+	var name strings.Builder
+	depth := 0
+	for _, r := range nodeName {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			name.WriteRune(r)
+		}
+	}
+	template := name.String()
+	if i := strings.LastIndex(template, "."); i >= 0 {
+		template = template[i+1:]
+	}
+	template = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, template)
+	if len(template) > maxTemplateLabelLength {
+		template = template[:maxTemplateLabelLength]
+	}
+	if template == "" {
+		return TemplateLabelUnknown
+	}
+	return template
+}
+
+// templateLabels hands out template labels for the first maxLabels templates seen and
+// TemplateLabelOther for all later ones, which bounds the number of series of per-template metrics.
+type templateLabels struct {
+	mu        sync.Mutex
+	maxLabels int
+	seen      map[string]bool
+}
+
+// factory function for a set of at most maxLabels template labels
+func newTemplateLabels(maxLabels int) *templateLabels {
+	return &templateLabels{maxLabels: maxLabels, seen: make(map[string]bool)}
+}
+
+func (l *templateLabels) label(nodeName string) string {
+	template := templateNameFromNodeName(nodeName)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[template] {
+		return template
+	}
+	if len(l.seen) >= l.maxLabels {
+		return TemplateLabelOther
+	}
+	l.seen[template] = true
+	return template
+}
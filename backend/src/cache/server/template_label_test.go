@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateNameFromNodeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeName string
+		expected string
+	}{
+		{"step", "my-pipeline-x7k2p.train", "train"},
+		{"nested step", "my-pipeline-x7k2p.outer.inner", "inner"},
+		{"no workflow prefix", "train", "train"},
+		{"loop item", "my-pipeline-x7k2p.train(0:foo)", "train"},
+		{"loop item with dots", "my-pipeline-x7k2p.train(1:1.5)", "train"},
+		{"loop item with json", `my-pipeline-x7k2p.train(2:{"a":"b.c","d":[1,(2)]})`, "train"},
+		{"nested loop", "my-pipeline-x7k2p.outer(0:a).inner(3:b)", "inner"},
+		{"retry", "my-pipeline-x7k2p.train(0)", "train"},
+		{"weird characters", "my-pipeline-x7k2p.trän ing/step:1", "tr_n_ing_step_1"},
+		{"underscores and dashes", "wf.Train_model-2", "Train_model-2"},
+		{"very long", "wf." + strings.Repeat("a", 300), strings.Repeat("a", maxTemplateLabelLength)},
+		{"empty", "", TemplateLabelUnknown},
+		{"trailing dot", "my-pipeline-x7k2p.", TemplateLabelUnknown},
+		{"only suffix", "(0:foo)", TemplateLabelUnknown},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, templateNameFromNodeName(tc.nodeName))
+		})
+	}
+}
+
+func TestTemplateLabelsAreCapped(t *testing.T) {
+	labels := newTemplateLabels(2)
+
+	assert.Equal(t, "train", labels.label("wf-1.train(0:a)"))
+	assert.Equal(t, "evaluate", labels.label("wf-1.evaluate"))
+	assert.Equal(t, TemplateLabelOther, labels.label("wf-1.deploy"))
+	assert.Equal(t, "train", labels.label("wf-2.train(1:b)"))
+	assert.Equal(t, "evaluate", labels.label("wf-2.evaluate"))
+	assert.Equal(t, TemplateLabelOther, labels.label("wf-2.deploy"))
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// TFXWorkflowKey is the key of the outputs of the cache entries of TFX pods holding the workflow
+	// of the run the execution that produced the entry is recorded in.
+	TFXWorkflowKey string = "tfx_workflow"
+	// TFXRunContextTypeName is the ML Metadata type of the contexts TFX records its runs as, named
+	// <pipeline name>.<workflow>.
+	TFXRunContextTypeName string = "run"
+
+	tfxPipelineNameFlag        string = "--pipeline_name"
+	tfxComponentIDProperty     string = "component_id"
+	tfxRunIDProperty           string = "run_id"
+	tfxWorkflowNamePlaceholder string = "{{workflow.name}}"
+	tfxRunIDPlaceholder        string = "{{workflow.uid}}"
+)
+
+// tfxComponentNamePattern matches the characters the KFP compiler replaces in the IDs of the TFX
+// components to name their templates.
+var tfxComponentNamePattern = regexp.MustCompile(`[^0-9a-z]+`)
+
+// tfxPodOrchestrator handles the Argo pods of TFX pipelines. Their entrypoint is given the name and
+// the ID of their run, which are replaced with the placeholders they were compiled from in their
+// template, so that the steps of the next runs share the key. TFX components resolve their inputs
+// from ML Metadata rather than from the outputs of Argo, so outputs are also restored there.
+type tfxPodOrchestrator struct {
+	argoPodOrchestrator
+	// executions restores the executions of the pods served from cache, which are not when nil.
+	executions *TFXExecutionRestorer
+}
+
+func (o tfxPodOrchestrator) template(pod *corev1.Pod) (string, bool) {
+	template, exists := o.argoPodOrchestrator.template(pod)
+	if !exists {
+		return template, exists
+	}
+	return withoutTFXRunIDs(template, pod), true
+}
+
+// withoutTFXRunIDs replaces the name and the ID of the run of the pod in the arguments of the
+// container of the template. Templates that cannot be parsed are returned unchanged, their key
+// fails to be generated.
+func withoutTFXRunIDs(template string, pod *corev1.Pod) string {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &parsed); err != nil {
+		return template
+	}
+	container, _ := parsed["container"].(map[string]interface{})
+	args, _ := container["args"].([]interface{})
+	if len(args) == 0 {
+		return template
+	}
+	replacer := tfxRunIDReplacer(pod)
+	for i, arg := range args {
+		if value, ok := arg.(string); ok {
+			args[i] = replacer.Replace(value)
+		}
+	}
+	stripped, err := json.Marshal(parsed)
+	if err != nil {
+		return template
+	}
+	return string(stripped)
+}
+
+func tfxRunIDReplacer(pod *corev1.Pod) *strings.Replacer {
+	var replacements []string
+	if workflow := pod.ObjectMeta.Labels[ArgoWorkflowLabelKey]; workflow != "" {
+		replacements = append(replacements, workflow, tfxWorkflowNamePlaceholder)
+	}
+	if runID := pod.ObjectMeta.Labels[RunIDLabelKey]; runID != "" {
+		replacements = append(replacements, runID, tfxRunIDPlaceholder)
+	}
+	return strings.NewReplacer(replacements...)
+}
+
+// restoreOutputs records the execution of the pod in the run of the pod in ML Metadata before
+// restoring the outputs of Argo, so that the next components of the run find the outputs of the
+// original execution.
+func (o tfxPodOrchestrator) restoreOutputs(ctx context.Context, pod *corev1.Pod, entry *model.ExecutionCache, cachedOutputs string, annotations map[string]string, dummy DummyContainer) ([]patchOperation, error) {
+	if o.executions == nil {
+		return nil, fmt.Errorf("the executions of TFX pods are not restored")
+	}
+	if err := o.executions.restore(ctx, pod, entry); err != nil {
+		return nil, err
+	}
+	return o.argoPodOrchestrator.restoreOutputs(ctx, pod, entry, cachedOutputs, annotations, dummy)
+}
+
+// TFXExecutionRestorer records the TFX pods served from cache in ML Metadata as executions of
+// CachedExecutionTypeName in the run of the pod, outputting the artifacts of the execution that
+// produced the cache entry, which the components of the run resolve their inputs from. Unlike
+// CachedExecutionRecorder, it records them synchronously when the pods are admitted, before the
+// next components start.
+type TFXExecutionRestorer struct {
+	client   ml_metadata.MetadataStoreServiceClient
+	recorder *CachedExecutionRecorder
+	keys     AnnotationKeys
+}
+
+// factory function for the restorer of the executions of the TFX pods in the ML Metadata server of
+// the client, reading the annotations of the keys
+func NewTFXExecutionRestorer(client ml_metadata.MetadataStoreServiceClient, keys AnnotationKeys) *TFXExecutionRestorer {
+	return &TFXExecutionRestorer{
+		client:   client,
+		recorder: NewCachedExecutionRecorder(client, 0, keys, nil),
+		keys:     keys.orDefault(),
+	}
+}
+
+// restore records the execution of the pod served from the entry in the run of the pod. The
+// execution that produced the entry is the one of the component of the pod in the run the entry
+// names.
+func (r *TFXExecutionRestorer) restore(ctx context.Context, pod *corev1.Pod, entry *model.ExecutionCache) error {
+	pipelineName := tfxPipelineName(pod)
+	if pipelineName == "" {
+		return fmt.Errorf("pod has no TFX pipeline name")
+	}
+	originalWorkflow := getValueFromSerializedMap(entry.ExecutionOutput, TFXWorkflowKey)
+	if originalWorkflow == "" {
+		return fmt.Errorf("cache entry does not name the run of its execution")
+	}
+	workflow := pod.ObjectMeta.Labels[ArgoWorkflowLabelKey]
+	if workflow == "" {
+		return fmt.Errorf("pod has no workflow")
+	}
+	componentName, err := tfxComponentName(pod)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mlmdCallTimeout)
+	defer cancel()
+	originalRun, err := r.runContext(ctx, pipelineName, originalWorkflow)
+	if err != nil {
+		return err
+	}
+	if originalRun == nil {
+		return fmt.Errorf("run %s.%s is not recorded in ML Metadata", pipelineName, originalWorkflow)
+	}
+	originalID, err := r.componentExecution(ctx, originalRun, componentName)
+	if err != nil {
+		return err
+	}
+
+	run, err := r.runContext(ctx, pipelineName, workflow)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		// The context is created along with the execution, as TFX would have.
+		run = &ml_metadata.Context{
+			TypeId:     proto.Int64(originalRun.GetTypeId()),
+			Name:       proto.String(pipelineName + "." + workflow),
+			Properties: map[string]*ml_metadata.Value{},
+		}
+		for name, value := range originalRun.GetProperties() {
+			run.Properties[name] = value
+		}
+		run.Properties[tfxRunIDProperty] = stringValue(workflow)
+	}
+	execution := cachedExecution{
+		namespace:           pod.ObjectMeta.Namespace,
+		podName:             pod.ObjectMeta.Name,
+		workflow:            workflow,
+		runID:               pod.ObjectMeta.Labels[RunIDLabelKey],
+		pipelineName:        pod.ObjectMeta.Annotations[r.keys.PipelineNameKey],
+		cacheID:             entry.ID,
+		originalExecutionID: originalID,
+	}
+	created, err := r.recorder.put(ctx, execution, run)
+	if err == nil && !created && run.Id == nil {
+		// Another component of the run may have created its context first.
+		if run, err = r.runContext(ctx, pipelineName, workflow); err == nil && run != nil {
+			_, err = r.recorder.put(ctx, execution, run)
+		}
+	}
+	return err
+}
+
+// runContext returns the context of the run of the pipeline, nil when it is not recorded.
+func (r *TFXExecutionRestorer) runContext(ctx context.Context, pipelineName string, workflow string) (*ml_metadata.Context, error) {
+	name := pipelineName + "." + workflow
+	response, err := r.client.GetContextByTypeAndName(ctx, &ml_metadata.GetContextByTypeAndNameRequest{
+		TypeName:    proto.String(TFXRunContextTypeName),
+		ContextName: proto.String(name),
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to get the context of run %s: %v", name, err)
+	}
+	return response.GetContext(), nil
+}
+
+// componentExecution returns the ID of the last execution of the component in the run.
+func (r *TFXExecutionRestorer) componentExecution(ctx context.Context, run *ml_metadata.Context, componentName string) (int64, error) {
+	response, err := r.client.GetExecutionsByContext(ctx, &ml_metadata.GetExecutionsByContextRequest{ContextId: proto.Int64(run.GetId())})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the executions of run %s: %v", run.GetName(), err)
+	}
+	var id int64
+	for _, execution := range response.GetExecutions() {
+		componentID := execution.GetProperties()[tfxComponentIDProperty].GetStringValue()
+		if sanitizeTFXComponentID(componentID) == componentName && execution.GetId() > id {
+			id = execution.GetId()
+		}
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("run %s has no execution of component %s", run.GetName(), componentName)
+	}
+	return id, nil
+}
+
+// tfxPipelineName returns the name of the pipeline the main container of the pod is given.
+func tfxPipelineName(pod *corev1.Pod) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != "main" {
+			continue
+		}
+		for i, arg := range container.Args {
+			if arg == tfxPipelineNameFlag && i+1 < len(container.Args) {
+				return container.Args[i+1]
+			}
+			if strings.HasPrefix(arg, tfxPipelineNameFlag+"=") {
+				return strings.TrimPrefix(arg, tfxPipelineNameFlag+"=")
+			}
+		}
+	}
+	return ""
+}
+
+// tfxComponentName returns the name of the template of the pod, which the KFP compiler names after
+// the ID of the component.
+func tfxComponentName(pod *corev1.Pod) (string, error) {
+	var template struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(pod.ObjectMeta.Annotations[ArgoWorkflowTemplate]), &template); err != nil || template.Name == "" {
+		return "", fmt.Errorf("pod template has no name")
+	}
+	return template.Name, nil
+}
+
+// sanitizeTFXComponentID returns the name of the template of the component as the KFP compiler
+// names it.
+func sanitizeTFXComponentID(componentID string) string {
+	return strings.Trim(tfxComponentNamePattern.ReplaceAllString(strings.ToLower(componentID), "-"), "-")
+}
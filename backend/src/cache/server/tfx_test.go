@@ -0,0 +1,180 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// tfxPod returns the pod of the CsvExampleGen component of the taxi pipeline in the run of the
+// workflow, whose entrypoint is given the run.
+func tfxPod(name string, workflow string, runID string) *corev1.Pod {
+	pod := fakePod.DeepCopy()
+	pod.ObjectMeta.Name = name
+	pod.ObjectMeta.Namespace = watchedNamespace
+	pod.ObjectMeta.Labels[ArgoWorkflowLabelKey] = workflow
+	pod.ObjectMeta.Labels[RunIDLabelKey] = runID
+	command := []string{"python", "/tfx-src/" + TFXPodSuffix}
+	args := []string{"--pipeline_name", "taxi", "--pipeline_root", "gs://bucket/taxi/" + runID, "--additional_pipeline_args", `{"workflow": "` + workflow + `"}`}
+	pod.Spec.Containers[0].Command = command
+	pod.Spec.Containers[0].Args = args
+	template, _ := json.Marshal(map[string]interface{}{
+		"name":      "csvexamplegen",
+		"container": map[string]interface{}{"image": "tensorflow/tfx:0.21.4", "command": command, "args": args},
+	})
+	pod.ObjectMeta.Annotations[ArgoWorkflowTemplate] = string(template)
+	return pod
+}
+
+// addTFXRun adds the run context of the workflow with an execution of the component outputting an
+// artifact, as TFX records them, and returns the ID of the artifact.
+func addTFXRun(store *fakeMetadataStore, workflow string, componentID string) int64 {
+	runContextID := store.addContext(TFXRunContextTypeName, "taxi."+workflow)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.contexts[runContextID].Properties = map[string]*ml_metadata.Value{
+		"pipeline_name":  stringValue("taxi"),
+		tfxRunIDProperty: stringValue(workflow),
+	}
+	executionID := store.id()
+	artifactID := store.id()
+	store.executions[executionID] = &ml_metadata.Execution{
+		Id:         proto.Int64(executionID),
+		TypeId:     proto.Int64(store.id()),
+		Properties: map[string]*ml_metadata.Value{tfxComponentIDProperty: stringValue(componentID)},
+	}
+	store.associations[executionID] = []int64{runContextID}
+	store.events = append(store.events, &ml_metadata.Event{
+		ArtifactId:  proto.Int64(artifactID),
+		ExecutionId: proto.Int64(executionID),
+		Type:        ml_metadata.Event_OUTPUT.Enum(),
+		Path:        &ml_metadata.Event_Path{Steps: []*ml_metadata.Event_Path_Step{{Value: &ml_metadata.Event_Path_Step_Key{Key: "examples"}}}},
+	})
+	return artifactID
+}
+
+func TestTFXTemplate(t *testing.T) {
+	orchestrator := podOrchestratorOf(tfxPod("step", "wf-1", "run-1"))
+	require.Equal(t, tfxPodOrchestrator{}, orchestrator)
+
+	template, exists := orchestrator.template(tfxPod("step", "wf-1", "run-1"))
+	require.True(t, exists)
+	assert.Contains(t, template, `"gs://bucket/taxi/{{workflow.uid}}"`)
+	assert.Contains(t, template, `{\"workflow\": \"{{workflow.name}}\"}`)
+	next, _ := orchestrator.template(tfxPod("other", "wf-2", "run-2"))
+	assert.Equal(t, template, next, "the runs of the pods do not affect the template")
+
+	_, exists = orchestrator.template(&corev1.Pod{})
+	assert.False(t, exists)
+}
+
+func TestSanitizeTFXComponentID(t *testing.T) {
+	assert.Equal(t, "csvexamplegen", sanitizeTFXComponentID("CsvExampleGen"))
+	assert.Equal(t, "trainer-my-model", sanitizeTFXComponentID("Trainer.my_model"))
+	assert.Equal(t, "a-b", sanitizeTFXComponentID("--A__B--"))
+}
+
+func TestMutateAndRecordTFXPod(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := newFakeMetadataStore()
+	artifactID := addTFXRun(store, "wf-1", "CsvExampleGen")
+	webhook := NewWebhook(WebhookConfig{TFXExecutions: NewTFXExecutionRestorer(newTestCachedExecutionRecorder(t, store, 0, nil).client, podKeys)})
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tfxPod("first", "wf-1", "run-1")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 2, "the pod misses")
+	annotations := patches[0].Value.(map[string]string)
+	key := annotations[podKeys.ExecutionKey]
+
+	completed := tfxPod("first", "wf-1", "run-1")
+	completed.ObjectMeta.Annotations = annotations
+	completed.ObjectMeta.Annotations[ArgoWorkflowOutputs] = `{"parameters":[{"name":"examples","value":"gs://bucket/taxi/run-1/examples"}]}`
+	completed.ObjectMeta.Labels = patches[1].Value.(map[string]string)
+	completed.Status.Phase = corev1.PodSucceeded
+	clientset := fake.NewSimpleClientset(completed)
+	require.True(t, recordPodOutputNow(completed, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), key, -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "wf-1", getValueFromSerializedMap(entry.ExecutionOutput, TFXWorkflowKey))
+
+	for _, name := range []string{"second", "third"} {
+		patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tfxPod(name, "wf-2", "run-2")), clientManager)
+		require.Nil(t, err)
+		require.Len(t, patches, 3, "the pod of the next run hits")
+		assert.Equal(t, key, patches[1].Value.(map[string]string)[podKeys.ExecutionKey])
+	}
+
+	executions := store.cachedExecutions()
+	require.Len(t, executions, 2)
+	run, err := store.GetContextByTypeAndName(context.Background(), &ml_metadata.GetContextByTypeAndNameRequest{
+		TypeName:    proto.String(TFXRunContextTypeName),
+		ContextName: proto.String("taxi.wf-2"),
+	})
+	require.Nil(t, err)
+	require.NotNil(t, run.GetContext(), "the run context of the pod is created")
+	assert.Equal(t, "wf-2", run.GetContext().GetProperties()[tfxRunIDProperty].GetStringValue())
+	assert.Equal(t, "taxi", run.GetContext().GetProperties()["pipeline_name"].GetStringValue())
+	inRun, err := store.GetExecutionsByContext(context.Background(), &ml_metadata.GetExecutionsByContextRequest{ContextId: run.GetContext().Id})
+	require.Nil(t, err)
+	assert.Len(t, inRun.GetExecutions(), 2, "the pods of the run share its context")
+	for _, execution := range executions {
+		events, err := store.GetEventsByExecutionIDs(context.Background(), &ml_metadata.GetEventsByExecutionIDsRequest{ExecutionIds: []int64{execution.GetId()}})
+		require.Nil(t, err)
+		require.Len(t, events.GetEvents(), 1)
+		assert.Equal(t, artifactID, events.GetEvents()[0].GetArtifactId())
+	}
+}
+
+func TestMutateTFXPodMissesWhenTheExecutionCannotBeRestored(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := newFakeMetadataStore()
+	webhook := NewWebhook(WebhookConfig{TFXExecutions: NewTFXExecutionRestorer(newTestCachedExecutionRecorder(t, store, 0, nil).client, podKeys)})
+
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tfxPod("first", "wf-1", "run-1")), clientManager)
+	require.Nil(t, err)
+	require.Len(t, patches, 2)
+	completed := tfxPod("first", "wf-1", "run-1")
+	completed.ObjectMeta.Annotations = patches[0].Value.(map[string]string)
+	completed.ObjectMeta.Annotations[ArgoWorkflowOutputs] = `{}`
+	completed.ObjectMeta.Labels = patches[1].Value.(map[string]string)
+	completed.Status.Phase = corev1.PodSucceeded
+	clientset := fake.NewSimpleClientset(completed)
+	require.True(t, recordPodOutputNow(completed, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+
+	// The run of the entry is not recorded in ML Metadata.
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tfxPod("second", "wf-2", "run-2")), clientManager)
+	require.Nil(t, err)
+	assert.Len(t, patches, 2, "the pod misses")
+	assert.Empty(t, store.cachedExecutions())
+
+	addTFXRun(store, "wf-1", "Trainer")
+	patches, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(tfxPod("third", "wf-2", "run-2")), clientManager)
+	require.Nil(t, err)
+	assert.Len(t, patches, 2, "the pod misses without an execution of its component")
+}
@@ -0,0 +1,28 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.NoopTracerProvider().Tracer(tracing.TracerName)
+
+// SetTracerProvider makes the webhook record its spans with the provider. It is meant to be called
+// once at startup, or by tests recording spans.
+func SetTracerProvider(provider trace.TracerProvider) {
+	tracer = provider.Tracer(tracing.TracerName)
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/api/admission/v1beta1"
+)
+
+const (
+	fakeTraceID      string = "4bf92f3577b34da6a3ce929d0e0e4736"
+	fakeParentSpanID string = "00f067aa0ba902b7"
+)
+
+// recordSpans makes the server and storage packages record their spans into an in-memory exporter
+// until the returned function is called.
+func recordSpans() (*tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	SetTracerProvider(provider)
+	storage.SetTracerProvider(provider)
+	return exporter, func() {
+		SetTracerProvider(tracing.NoopTracerProvider())
+		storage.SetTracerProvider(tracing.NoopTracerProvider())
+	}
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]string {
+	attributes := map[attribute.Key]string{}
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value.Emit()
+	}
+	return attributes
+}
+
+func TestAdmissionSpansContinueIncomingTrace(t *testing.T) {
+	exporter, restore := recordSpans()
+	defer restore()
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	exporter.Reset()
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: GetFakeRequestFromPod(fakePod)})
+	require.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set(ContentType, JsonContentType)
+	req.Header.Set("traceparent", "00-"+fakeTraceID+"-"+fakeParentSpanID+"-01")
+
+	webhook := NewWebhook(WebhookConfig{})
+	webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := spansByName(exporter.GetSpans())
+	require.Len(t, spans, 4)
+	admission, ok := spans[tracing.SpanAdmission]
+	require.True(t, ok)
+	assert.Equal(t, fakeTraceID, admission.SpanContext.TraceID().String())
+	assert.Equal(t, fakeParentSpanID, admission.Parent.SpanID().String())
+	assert.True(t, admission.Parent.IsRemote())
+	assert.Equal(t, map[attribute.Key]string{
+		tracing.AttributeNamespace: "default",
+		tracing.AttributeDecision:  AdmissionOutcomeHit,
+		tracing.AttributeCacheKey:  "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+	}, spanAttributes(admission))
+	for _, name := range []string{tracing.SpanGenerateKey, tracing.SpanDBLookup, tracing.SpanBuildPatches} {
+		child, ok := spans[name]
+		require.True(t, ok, name)
+		assert.Equal(t, fakeTraceID, child.SpanContext.TraceID().String(), name)
+		assert.Equal(t, admission.SpanContext.SpanID(), child.Parent.SpanID(), name)
+	}
+}
+
+func TestAdmissionSpanStartsTraceWithoutTraceparent(t *testing.T) {
+	exporter, restore := recordSpans()
+	defer restore()
+
+	serveAdmissionReview(t, NewWebhook(WebhookConfig{}), &v1beta1.AdmissionRequest{UID: "allowed-uid", Namespace: "default"}, fakeAdmitFunc)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, tracing.SpanAdmission, spans[0].Name)
+	assert.False(t, spans[0].Parent.IsValid())
+	assert.True(t, spans[0].SpanContext.IsValid())
+}
+
+func TestSpansAreNotRecordedWithoutTracerProvider(t *testing.T) {
+	_, span := tracer.Start(context.Background(), tracing.SpanAdmission)
+	defer span.End()
+	assert.False(t, span.IsRecording())
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Validation modes decide what happens to pods carrying cache fields the mutating webhook did not
+// issue. Under the warn mode they are admitted with a warning, under the enforce mode they are
+// rejected.
+const (
+	ValidationModeWarn    string = "warn"
+	ValidationModeEnforce string = "enforce"
+)
+
+// cacheSignatureNonceBytes is the number of random bytes of the nonce of a signature.
+const cacheSignatureNonceBytes int = 16
+
+// IsValidValidationMode reports whether mode is ValidationModeWarn or ValidationModeEnforce.
+func IsValidValidationMode(mode string) bool {
+	return mode == ValidationModeWarn || mode == ValidationModeEnforce
+}
+
+// CacheSignatureKeys holds the keys the cache fields of pods are signed with, one per line. The
+// first one signs and all of them verify, so that a new key can be added before the old one is
+// removed. The keys can be replaced while serving, e.g. when their Secret rotates.
+type CacheSignatureKeys struct {
+	keys atomic.Value
+}
+
+// NewCacheSignatureKeys returns the signature keys of the lines of keys. Empty lines and lines
+// starting with # are ignored, and without key pods are neither signed nor validated.
+func NewCacheSignatureKeys(keys string) *CacheSignatureKeys {
+	k := &CacheSignatureKeys{}
+	k.Set(keys)
+	return k
+}
+
+// Set replaces the keys with those of the lines of keys.
+func (k *CacheSignatureKeys) Set(keys string) {
+	var entries [][]byte
+	for _, line := range strings.Split(keys, "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		entries = append(entries, []byte(key))
+	}
+	k.keys.Store(entries)
+}
+
+// Enabled reports whether there is a key to sign with.
+func (k *CacheSignatureKeys) Enabled() bool {
+	return k != nil && len(k.current()) > 0
+}
+
+func (k *CacheSignatureKeys) current() [][]byte {
+	keys, _ := k.keys.Load().([][]byte)
+	return keys
+}
+
+// sign returns the signature of the cache fields of a pod of the namespace, a random nonce and
+// its MAC separated by a dot, or an empty string without key.
+func (k *CacheSignatureKeys) sign(namespace string, executionKey string, cacheID string) (string, error) {
+	if !k.Enabled() {
+		return "", nil
+	}
+	nonce := make([]byte, cacheSignatureNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate the nonce of the cache signature: %v", err)
+	}
+	encodedNonce := hex.EncodeToString(nonce)
+	return encodedNonce + "." + hex.EncodeToString(cacheFieldsMAC(k.current()[0], encodedNonce, namespace, executionKey, cacheID)), nil
+}
+
+// verify reports whether the signature is the one of the cache fields under any of the keys.
+func (k *CacheSignatureKeys) verify(namespace string, executionKey string, cacheID string, signature string) bool {
+	parts := strings.SplitN(signature, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	mac, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	for _, key := range k.current() {
+		if hmac.Equal(mac, cacheFieldsMAC(key, parts[0], namespace, executionKey, cacheID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheFieldsMAC is the HMAC-SHA256 of the cache fields of a pod. The namespace is included so
+// that signed fields cannot be copied to pods of other namespaces.
+func cacheFieldsMAC(key []byte, nonce string, namespace string, executionKey string, cacheID string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{nonce, namespace, executionKey, cacheID}, "\n")))
+	return mac.Sum(nil)
+}
+
+// cacheFieldsProblem describes why the cache fields of the pod under admission were not issued by
+// the mutating webhook, or returns an empty string when they were or the pod has none.
+func (k AnnotationKeys) cacheFieldsProblem(pod *corev1.Pod, namespace string, operation v1beta1.Operation, signatureKeys *CacheSignatureKeys) string {
+	executionKey, hasExecutionKey := pod.ObjectMeta.Annotations[k.ExecutionKey]
+	cacheID, hasCacheID := pod.ObjectMeta.Labels[k.CacheIDLabelKey]
+	if !hasExecutionKey && !hasCacheID {
+		return ""
+	}
+	signature, signed := pod.ObjectMeta.Annotations[k.CacheSignatureKey]
+	if !signed {
+		return fmt.Sprintf("pod sets the %s label or the %s annotation, which only the cache webhook may set", k.CacheIDLabelKey, k.ExecutionKey)
+	}
+	if signatureKeys.verify(namespace, executionKey, cacheID, signature) {
+		return ""
+	}
+	// The watcher labels the pods admitted as cache misses with the entry their outputs were
+	// recorded as.
+	if operation == v1beta1.Update && pod.ObjectMeta.Labels[k.CachedLabelKey] != KFPCachedLabelValue &&
+		signatureKeys.verify(namespace, executionKey, "", signature) {
+		return ""
+	}
+	return fmt.Sprintf("pod's %s label or %s annotation differ from those the cache webhook issued", k.CacheIDLabelKey, k.ExecutionKey)
+}
+
+// ValidatePodCacheFields answers the admission of pods carrying a cache ID label or an execution
+// key annotation without the signature of the mutating webhook, which the watcher would otherwise
+// trust, according to the validation mode. Every pod is admitted when no signature key is
+// configured, since none is signed.
+func (wh *Webhook) ValidatePodCacheFields(req *v1beta1.AdmissionRequest, keys *CacheSignatureKeys) []byte {
+	if !keys.Enabled() || isKubeNamespace(req.Namespace) || req.Resource != podResource || len(req.Object.Raw) == 0 {
+		return allowedResponse(req.UID, nil)
+	}
+	pod := corev1.Pod{}
+	if _, _, err := universalDeserializer.Decode(req.Object.Raw, nil, &pod); err != nil {
+		// Pods that cannot be read are rejected by the API server anyway.
+		logger.Warnf("Allowing the validation of a pod that could not be deserialized: %v", err)
+		return allowedResponse(req.UID, nil)
+	}
+	problem := wh.keys.cacheFieldsProblem(&pod, req.Namespace, req.Operation, keys)
+	if problem == "" {
+		return allowedResponse(req.UID, nil)
+	}
+	mode := wh.mutationConfig().ValidationMode
+	if mode == "" {
+		mode = ValidationModeWarn
+	}
+	wh.metrics.CacheFieldsFlagged(mode)
+	podLogger := logger.WithFields(logrus.Fields{
+		logging.FieldPod:       podName(&pod),
+		logging.FieldNamespace: req.Namespace,
+		"user":                 req.UserInfo.Username,
+		"operation":            req.Operation,
+	})
+	if mode == ValidationModeEnforce {
+		podLogger.Warnf("Rejecting pod with cache fields not issued by the cache webhook: %s", problem)
+		return errorResponse(req.UID, fmt.Errorf("pipelines.kubeflow.org cache webhook rejected the pod: %s", problem))
+	}
+	podLogger.Warnf("Allowing pod with cache fields not issued by the cache webhook: %s", problem)
+	return warningResponse(req.UID, problem+", the execution cache may not trust it")
+}
+
+// podName is the name of the pod, or its generate name while under admission of its creation.
+func podName(pod *corev1.Pod) string {
+	if pod.ObjectMeta.Name != "" {
+		return pod.ObjectMeta.Name
+	}
+	return pod.ObjectMeta.GenerateName
+}
+
+// ValidateHandler serves the validating webhook flagging pods with cache fields the mutating
+// webhook did not issue, see ValidatePodCacheFields.
+func (wh *Webhook) ValidateHandler(keys *CacheSignatureKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := wh.readAdmissionBody(w, r)
+		if err != nil {
+			logger.Errorf("Error handling validation request: %v", err)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		var review v1beta1.AdmissionReview
+		if _, _, err := universalDeserializer.Decode(body, nil, &review); err != nil || review.Request == nil {
+			// There is nothing to validate, the pod is not held up.
+			uid, _ := peekRequest(body)
+			w.Write(warningResponse(uid, "Malformed admission review request"))
+			return
+		}
+		if _, err := w.Write(wh.ValidatePodCacheFields(review.Request, keys)); err != nil {
+			logger.Errorf("Could not write response: %v", err)
+		}
+	})
+}
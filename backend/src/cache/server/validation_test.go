@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// validationResponse is the part of the answer of the validating webhook the tests look at.
+type validationResponse struct {
+	Response struct {
+		Allowed bool `json:"allowed"`
+		Result  *struct {
+			Message string `json:"message"`
+		} `json:"status"`
+		Warnings []string `json:"warnings"`
+	} `json:"response"`
+}
+
+func decodeValidationResponse(t *testing.T, body []byte) validationResponse {
+	var response validationResponse
+	require.Nil(t, json.Unmarshal(body, &response))
+	return response
+}
+
+// mutatedPod returns the pod as admitted by the mutating webhook signing with keys.
+func mutatedPod(t *testing.T, clientManager ClientManagerInterface, pod *corev1.Pod, keys *CacheSignatureKeys) *corev1.Pod {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{SignatureKeys: keys}})
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(pod), clientManager)
+	require.Nil(t, err)
+	mutated := pod.DeepCopy()
+	for _, patch := range patches {
+		switch patch.Path {
+		case AnnotationPath:
+			mutated.ObjectMeta.Annotations = patch.Value.(map[string]string)
+		case LabelPath:
+			mutated.ObjectMeta.Labels = patch.Value.(map[string]string)
+		}
+	}
+	return mutated
+}
+
+// validationRequest is the admission of the pod by the validating webhook.
+func validationRequest(pod *corev1.Pod, operation v1beta1.Operation) *v1beta1.AdmissionRequest {
+	request := GetFakeRequestFromPod(pod)
+	request.Operation = operation
+	return request
+}
+
+func TestValidatePodCacheFieldsAllowsMutatedPods(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	keys := NewCacheSignatureKeys("signing-key")
+	webhook := NewWebhook(WebhookConfig{})
+
+	missed := mutatedPod(t, clientManager, fakePod.DeepCopy(), keys)
+	require.Contains(t, missed.ObjectMeta.Annotations, podKeys.CacheSignatureKey)
+	response := decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(missed, v1beta1.Create), keys))
+	assert.True(t, response.Response.Allowed)
+	assert.Empty(t, response.Response.Warnings)
+
+	// The watcher labels the missed pod with the entry its outputs were recorded as.
+	missed.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+	response = decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(missed, v1beta1.Update), keys))
+	assert.True(t, response.Response.Allowed)
+	assert.Empty(t, response.Response.Warnings)
+
+	_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0",
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"command":["echo", "Hello"],"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+	hit := mutatedPod(t, clientManager, fakePod.DeepCopy(), keys)
+	require.Equal(t, KFPCachedLabelValue, hit.ObjectMeta.Labels[podKeys.CachedLabelKey])
+	for _, operation := range []v1beta1.Operation{v1beta1.Create, v1beta1.Update} {
+		response = decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(hit, operation), keys))
+		assert.True(t, response.Response.Allowed)
+		assert.Empty(t, response.Response.Warnings)
+	}
+}
+
+func TestValidatePodCacheFieldsFlagsImpostors(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	keys := NewCacheSignatureKeys("signing-key")
+	signed := mutatedPod(t, clientManager, fakePod.DeepCopy(), keys)
+
+	handCrafted := fakePod.DeepCopy()
+	handCrafted.ObjectMeta.Annotations[podKeys.ExecutionKey] = "f5fe913be7a4516ebfe1b5de29bcb35edd12ecc776b2f33f10ca19709ea3b2f0"
+	handCrafted.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+	cacheIDOnly := fakePod.DeepCopy()
+	cacheIDOnly.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+	otherKey := signed.DeepCopy()
+	otherKey.ObjectMeta.Annotations[podKeys.ExecutionKey] = "0000000000000000000000000000000000000000000000000000000000000000"
+	forgedHit := signed.DeepCopy()
+	forgedHit.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+	forgedHit.ObjectMeta.Labels[podKeys.CachedLabelKey] = KFPCachedLabelValue
+	forgedSignature := signed.DeepCopy()
+	forgedSignature.ObjectMeta.Annotations[podKeys.CacheSignatureKey] = mutatedPod(t, clientManager, fakePod.DeepCopy(), NewCacheSignatureKeys("other-key")).ObjectMeta.Annotations[podKeys.CacheSignatureKey]
+
+	tests := []struct {
+		name      string
+		pod       *corev1.Pod
+		operation v1beta1.Operation
+		namespace string
+	}{
+		{name: "hand-crafted cache fields", pod: handCrafted, operation: v1beta1.Create},
+		{name: "hand-crafted cache ID", pod: cacheIDOnly, operation: v1beta1.Create},
+		{name: "changed execution key", pod: otherKey, operation: v1beta1.Update},
+		{name: "missed pod labeled as served from cache", pod: forgedHit, operation: v1beta1.Update},
+		{name: "cache ID set at creation", pod: forgedHit, operation: v1beta1.Create},
+		{name: "signature of another key", pod: forgedSignature, operation: v1beta1.Create},
+		{name: "signature of another namespace", pod: signed, operation: v1beta1.Create, namespace: "other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := validationRequest(test.pod, test.operation)
+			if test.namespace != "" {
+				request.Namespace = test.namespace
+			}
+
+			warning := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeWarn}})
+			warned := decodeValidationResponse(t, warning.ValidatePodCacheFields(request, keys))
+			assert.True(t, warned.Response.Allowed, "the warn mode admits the pod")
+			require.Len(t, warned.Response.Warnings, 1)
+			assert.Contains(t, warned.Response.Warnings[0], podKeys.CacheIDLabelKey)
+
+			enforcing := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeEnforce}})
+			rejected := decodeValidationResponse(t, enforcing.ValidatePodCacheFields(request, keys))
+			assert.False(t, rejected.Response.Allowed, "the enforce mode rejects the pod")
+			require.NotNil(t, rejected.Response.Result)
+			assert.Contains(t, rejected.Response.Result.Message, "cache webhook rejected the pod")
+		})
+	}
+}
+
+func TestValidatePodCacheFieldsAcceptsThePreviousKeys(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	keys := NewCacheSignatureKeys("old-key")
+	signed := mutatedPod(t, clientManager, fakePod.DeepCopy(), keys)
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeEnforce}})
+
+	keys.Set("new-key\nold-key")
+	response := decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(signed, v1beta1.Update), keys))
+	assert.True(t, response.Response.Allowed)
+
+	keys.Set("new-key")
+	response = decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(signed, v1beta1.Update), keys))
+	assert.False(t, response.Response.Allowed)
+}
+
+func TestValidatePodCacheFieldsWithoutKeyAdmitsEveryPod(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeEnforce}})
+	handCrafted := fakePod.DeepCopy()
+	handCrafted.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+
+	for _, keys := range []*CacheSignatureKeys{nil, NewCacheSignatureKeys("# no key yet\n")} {
+		response := decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(handCrafted, v1beta1.Create), keys))
+		assert.True(t, response.Response.Allowed)
+		assert.Empty(t, response.Response.Warnings)
+	}
+}
+
+func TestValidatePodCacheFieldsAdmitsPodsWithoutCacheFields(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeEnforce}})
+
+	response := decodeValidationResponse(t, webhook.ValidatePodCacheFields(validationRequest(fakePod.DeepCopy(), v1beta1.Create), NewCacheSignatureKeys("signing-key")))
+
+	assert.True(t, response.Response.Allowed)
+	assert.Empty(t, response.Response.Warnings)
+}
+
+func TestValidateHandler(t *testing.T) {
+	webhook := NewWebhook(WebhookConfig{Mutation: MutationConfig{ValidationMode: ValidationModeEnforce}})
+	handCrafted := fakePod.DeepCopy()
+	handCrafted.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "42"
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: validationRequest(handCrafted, v1beta1.Create)})
+	require.Nil(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+	request.Header.Set(ContentType, JsonContentType)
+	recorder := httptest.NewRecorder()
+	webhook.ValidateHandler(NewCacheSignatureKeys("signing-key")).ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	response := decodeValidationResponse(t, recorder.Body.Bytes())
+	assert.False(t, response.Response.Allowed)
+
+	recorder = httptest.NewRecorder()
+	webhook.ValidateHandler(NewCacheSignatureKeys("signing-key")).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/validate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/kubeflow/pipelines/backend/src/cache/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const VersionAPI string = "/version"
+
+// BuildInfo is the body of /version: the build metadata of the webhook and the version of its
+// cache keys, which tells whether webhooks of different clusters find each other's entries.
+type BuildInfo struct {
+	version.Info
+	CacheKeyVersion string `json:"cacheKeyVersion"`
+}
+
+// GetBuildInfo returns the build metadata of the running webhook.
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{Info: version.Get(), CacheKeyVersion: CacheKeyVersion}
+}
+
+// VersionHandler serves the BuildInfo as JSON.
+func VersionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(GetBuildInfo())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(ContentType, JsonContentType)
+		w.Write(b)
+	})
+}
+
+// RegisterBuildInfo exports the BuildInfo as the labels of the cache_build_info gauge, which is
+// always 1, so that the build of every webhook can be joined onto its other metrics.
+func RegisterBuildInfo(registerer prometheus.Registerer) {
+	info := GetBuildInfo()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_build_info",
+		Help: "Build metadata of the cache webhook, always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":           info.Version,
+			"git_commit":        info.GitCommit,
+			"build_date":        info.BuildDate,
+			"go_version":        info.GoVersion,
+			"cache_key_version": info.CacheKeyVersion,
+		},
+	})
+	gauge.Set(1)
+	if _, err := metrics.RegisterOrReuse(registerer, gauge); err != nil {
+		logger.Errorf("Failed to register the build info metric: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getVersion(t *testing.T) map[string]string {
+	rr := httptest.NewRecorder()
+	VersionHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, VersionAPI, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, JsonContentType, rr.Header().Get(ContentType))
+	var body map[string]string
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	return body
+}
+
+func TestVersionHandlerReportsBuildInfo(t *testing.T) {
+	defer func(v, commit, date string) {
+		version.Version, version.GitCommit, version.BuildDate = v, commit, date
+	}(version.Version, version.GitCommit, version.BuildDate)
+
+	version.Version, version.GitCommit, version.BuildDate = "", "", ""
+	assert.Equal(t, map[string]string{
+		"version":         "dev",
+		"gitCommit":       "dev",
+		"buildDate":       "dev",
+		"goVersion":       runtime.Version(),
+		"cacheKeyVersion": CacheKeyVersion,
+	}, getVersion(t))
+
+	version.Version, version.GitCommit, version.BuildDate = "1.0.0", "0a1b2c3", "2020-06-01T00:00:00Z"
+	body := getVersion(t)
+	assert.Equal(t, "1.0.0", body["version"])
+	assert.Equal(t, "0a1b2c3", body["gitCommit"])
+	assert.Equal(t, "2020-06-01T00:00:00Z", body["buildDate"])
+
+	registry := prometheus.NewRegistry()
+	RegisterBuildInfo(registry)
+	RegisterBuildInfo(registry)
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "cache_build_info", families[0].GetName())
+	labels := map[string]string{}
+	for _, label := range families[0].GetMetric()[0].GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, "1.0.0", labels["version"])
+	assert.Equal(t, CacheKeyVersion, labels["cache_key_version"])
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// MaxAdmissionWarnings bounds the warnings of a single admission response.
+	MaxAdmissionWarnings int = 4
+	// MaxAdmissionWarningLength bounds the length in bytes of each warning, prefix included.
+	MaxAdmissionWarningLength int = 256
+
+	warningPrefix string = "pipelines.kubeflow.org cache webhook: "
+)
+
+var (
+	// Credentials of URLs and key=value pairs that store errors may quote.
+	urlUserInfoPattern   = regexp.MustCompile(`://[^/@\s]+@`)
+	secretSettingPattern = regexp.MustCompile(`(?i)\b(password|passwd|pwd|secret|token|access_?key)(\s*[=:]\s*)[^\s,;&]+`)
+)
+
+// admissionWarnings collects the warnings about an admission, which are shown to the client that
+// created the pod, e.g. by kubectl.
+type admissionWarnings struct {
+	mutex    sync.Mutex
+	warnings []string
+}
+
+type admissionWarningsKey struct{}
+
+// contextWithAdmissionWarnings returns a context collecting the warnings added to it.
+func contextWithAdmissionWarnings(ctx context.Context) (context.Context, *admissionWarnings) {
+	warnings := &admissionWarnings{}
+	return context.WithValue(ctx, admissionWarningsKey{}, warnings), warnings
+}
+
+// addAdmissionWarning adds a warning to the admission of ctx. Warnings beyond MaxAdmissionWarnings
+// are dropped. The message must not quote cached outputs.
+func addAdmissionWarning(ctx context.Context, format string, args ...interface{}) {
+	warnings, ok := ctx.Value(admissionWarningsKey{}).(*admissionWarnings)
+	if !ok {
+		return
+	}
+	warnings.mutex.Lock()
+	defer warnings.mutex.Unlock()
+	if len(warnings.warnings) < MaxAdmissionWarnings {
+		warnings.warnings = append(warnings.warnings, formatWarning(fmt.Sprintf(format, args...)))
+	}
+}
+
+func (w *admissionWarnings) list() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string(nil), w.warnings...)
+}
+
+// formatWarning prefixes the message, redacts credentials from it and truncates it to
+// MaxAdmissionWarningLength.
+func formatWarning(message string) string {
+	return truncate(warningPrefix+redactCredentials(message), MaxAdmissionWarningLength)
+}
+
+// truncate shortens s to at most maxLength bytes, ending it with an ellipsis when cut, without
+// splitting a UTF-8 sequence.
+func truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	const ellipsis = "..."
+	cut := maxLength - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + ellipsis
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxNamespaceInformers is the largest number of watched namespaces followed by an informer each.
+// More namespaces are followed by a single informer over all namespaces whose pods are filtered,
+// which costs the API server less than as many watches.
+const maxNamespaceInformers = 10
+
+// namespaceListRetryInterval is the time waited before listing the namespaces matching the selector
+// again after a failure.
+const namespaceListRetryInterval = 5 * time.Second
+
+// WatchedNamespaces are the namespaces whose pods the watcher records, given either as a list of
+// names or as a label selector on namespaces.
+type WatchedNamespaces struct {
+	Names    []string
+	Selector labels.Selector
+}
+
+// ParseWatchedNamespaces parses a comma separated list of namespaces, or a label selector on
+// namespaces. The spec is a selector unless every item is a valid namespace name, so a selector on
+// the mere existence of a label only tells apart when its key has a prefix, e.g.
+// pipelines.kubeflow.org/enabled, or is written with a value.
+func ParseWatchedNamespaces(spec string) (WatchedNamespaces, error) {
+	var names []string
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			names = append(names, item)
+		}
+	}
+	if len(names) == 0 {
+		return WatchedNamespaces{}, fmt.Errorf("no watched namespaces in %q", spec)
+	}
+	isList := true
+	for _, name := range names {
+		if len(validation.IsDNS1123Label(name)) > 0 {
+			isList = false
+			break
+		}
+	}
+	if isList {
+		return WatchedNamespaces{Names: names}, nil
+	}
+	selector, err := labels.Parse(spec)
+	if err != nil {
+		return WatchedNamespaces{}, fmt.Errorf("watched namespaces %q are neither namespace names nor a label selector: %v", spec, err)
+	}
+	return WatchedNamespaces{Selector: selector}, nil
+}
+
+// resolve returns the names of the watched namespaces, listing those matching the selector until
+// it succeeds or ctx is done.
+func (n WatchedNamespaces) resolve(ctx context.Context, k8sCore client.KubernetesCoreInterface) ([]string, error) {
+	if n.Selector == nil {
+		return n.Names, nil
+	}
+	for {
+		namespaces, err := k8sCore.NamespaceClient().List(metav1.ListOptions{LabelSelector: n.Selector.String()})
+		if err == nil {
+			var names []string
+			for _, namespace := range namespaces.Items {
+				names = append(names, namespace.ObjectMeta.Name)
+			}
+			sort.Strings(names)
+			return names, nil
+		}
+		logger.Errorf("Failed to list the namespaces matching %q: %v", n.Selector.String(), err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(namespaceListRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseWatchedNamespaces(t *testing.T) {
+	tests := []struct {
+		spec      string
+		names     []string
+		selector  string
+		wantError string
+	}{
+		{spec: "kubeflow", names: []string{"kubeflow"}},
+		{spec: " team-a, team-b ,", names: []string{"team-a", "team-b"}},
+		{spec: "app.kubernetes.io/part-of=kubeflow-profile", selector: "app.kubernetes.io/part-of=kubeflow-profile"},
+		{spec: "pipelines.kubeflow.org/enabled", selector: "pipelines.kubeflow.org/enabled"},
+		{spec: "team in (a,b),!legacy", selector: "!legacy,team in (a,b)"},
+		{spec: " , ", wantError: "no watched namespaces"},
+		{spec: "Team A", wantError: "neither namespace names nor a label selector"},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			watched, err := ParseWatchedNamespaces(test.spec)
+			if test.wantError != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), test.wantError)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, test.names, watched.Names)
+			if test.selector == "" {
+				assert.Nil(t, watched.Selector)
+			} else {
+				assert.Equal(t, test.selector, watched.Selector.String())
+			}
+		})
+	}
+}
+
+func namespacedPod(namespace string, name string) *corev1.Pod {
+	pod := completedPod(name, time.Minute)
+	pod.ObjectMeta.Namespace = namespace
+	return pod
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// podActionNamespaces returns the namespaces the pods were listed, watched or patched in.
+func podActionNamespaces(clientset *fake.Clientset) map[string]bool {
+	namespaces := map[string]bool{}
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "pods" {
+			namespaces[action.GetNamespace()] = true
+		}
+	}
+	return namespaces
+}
+
+func TestWatchPodsOnlyRecordsWatchedNamespaces(t *testing.T) {
+	var manyNamespaces []string
+	for i := 0; i < maxNamespaceInformers+1; i++ {
+		manyNamespaces = append(manyNamespaces, fmt.Sprintf("team-%d", i))
+	}
+	tests := []struct {
+		name       string
+		namespaces string
+		// informerNamespaces are the namespaces whose pods are listed and watched, empty for all.
+		informerNamespaces []string
+	}{
+		{name: "list", namespaces: "team-0,team-1", informerNamespaces: []string{"team-0", "team-1"}},
+		{name: "selector", namespaces: "pipelines.kubeflow.org/enabled=true", informerNamespaces: []string{"team-0", "team-1"}},
+		{name: "long list", namespaces: strings.Join(append(manyNamespaces[2:], "team-0", "team-1"), ","), informerNamespaces: []string{metav1.NamespaceAll}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			enabled := map[string]string{"pipelines.kubeflow.org/enabled": "true"}
+			clientset := fake.NewSimpleClientset(
+				namespace("team-0", enabled), namespace("team-1", enabled), namespace("other", nil),
+				namespacedPod("team-0", "step-a"), namespacedPod("team-1", "step-b"), namespacedPod("other", "step-c"))
+			var patchedNamespaces []string
+			clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				patchedNamespaces = append(patchedNamespaces, action.GetNamespace())
+				return false, nil, nil
+			})
+
+			stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour, Namespaces: test.namespaces})
+			require.Eventually(t, func() bool {
+				return hasCacheEntries(clientManager, "step-a-key", 1)() && hasCacheEntries(clientManager, "step-b-key", 1)()
+			}, 5*time.Second, 10*time.Millisecond)
+			stop()
+
+			assert.Equal(t, 0, countCacheEntries(t, clientManager, "step-c-key"), "pods of other namespaces are not recorded")
+			assert.ElementsMatch(t, []string{"team-0", "team-1"}, patchedNamespaces)
+			expectedNamespaces := map[string]bool{"team-0": true, "team-1": true}
+			for _, namespace := range test.informerNamespaces {
+				expectedNamespaces[namespace] = true
+			}
+			assert.Equal(t, expectedNamespaces, podActionNamespaces(clientset))
+		})
+	}
+}
+
+func TestWatchPodsWithoutMatchingNamespaceWatchesNothing(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset(namespace("other", nil), namespacedPod("other", "step"))
+
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour, Namespaces: "pipelines.kubeflow.org/enabled=true"})
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	assert.Empty(t, podActionNamespaces(clientset))
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "step-key"))
+}
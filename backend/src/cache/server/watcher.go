@@ -1,126 +1,616 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
 	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/outputs"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
 	"github.com/peterhellberg/duration"
+	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
-	ArgoCompleteLabelKey   string = "workflows.argoproj.io/completed"
-	MetadataExecutionIDKey string = "pipelines.kubeflow.org/metadata_execution_id"
-	MaxCacheStalenessKey   string = "pipelines.kubeflow.org/max_cache_staleness"
+	ArgoCompleteLabelKey string = "workflows.argoproj.io/completed"
+	// RunIDLabelKey labels the pods of a KFP run with the ID of the run. Entries record it as
+	// their provenance.
+	RunIDLabelKey string = "pipeline/runid"
+
+	// PodSkipReasonAlreadyCached is a pod served from cache, whose outputs are those of the entry
+	// it reused.
+	PodSkipReasonAlreadyCached string = "already_cached"
+	// PodSkipReasonInvalidOutputs is a pod whose outputs annotation cannot be parsed.
+	PodSkipReasonInvalidOutputs string = "invalid_outputs"
+	// PodSkipReasonNotCached is a pod whose entry is stale on creation, e.g. with a max cache
+	// staleness of P0D, which the store did not keep.
+	PodSkipReasonNotCached string = "not_cached"
+)
+
+const (
+	// DefaultPodResyncPeriod is the period at which the watched pods are all handled again, so
+	// that pods whose outputs could not be recorded are retried.
+	DefaultPodResyncPeriod time.Duration = 5 * time.Minute
+	// DefaultCatchUpLookback bounds the age of the completed pods recorded, e.g. of those that
+	// completed while the watcher was down.
+	DefaultCatchUpLookback time.Duration = 24 * time.Hour
+	// DefaultPatchQPS and DefaultPatchBurst bound the rate at which recorded pods are labeled
+	// with their cache ID, so that workflows with thousands of steps do not flood the API server.
+	DefaultPatchQPS   float64 = 10
+	DefaultPatchBurst int     = 20
 )
 
-func WatchPods(namespaceToWatch string, clientManager ClientManagerInterface) {
+// WatcherConfig holds the settings of the pod watcher.
+type WatcherConfig struct {
+	// ResyncPeriod is the period at which the watched pods are all handled again. Zero disables
+	// resyncs.
+	ResyncPeriod time.Duration
+	// CatchUpLookback bounds how long ago a pod may have completed for its outputs to be recorded.
+	// It mostly applies to the pods listed at startup, since the others are recorded as they
+	// complete. Zero records pods however long ago they completed.
+	CatchUpLookback time.Duration
+	// PatchQPS is the number of pods labeled with their cache ID per second, with bursts of up to
+	// PatchBurst pods. Zero or less does not limit them.
+	PatchQPS   float64
+	PatchBurst int
+	// WriteWorkers is the number of cache entries written at once, WriteQPS the number written per
+	// second with bursts of up to WriteBurst. A WriteQPS of zero or less does not limit them.
+	WriteWorkers int
+	WriteQPS     float64
+	WriteBurst   int
+	// WriteMaxRetries is the number of times a failed write is retried before its entry is
+	// dropped. Zero means DefaultWriteMaxRetries.
+	WriteMaxRetries int
+	// WriteQueueSize is the number of cache entries waiting to be written beyond which completed
+	// pods are left for the next resync. Zero means DefaultWriteQueueSize.
+	WriteQueueSize int
+	// BackfillOnStart records the succeeded pods not followed by the watcher, e.g. those completed
+	// before the cache was installed, once the watcher starts. BackfillMaxAge bounds how long ago
+	// they may have completed, zero does not.
+	BackfillOnStart bool
+	BackfillMaxAge  time.Duration
+	// Namespaces is a comma separated list of the namespaces whose pods are recorded, or a label
+	// selector on them, as parsed by ParseWatchedNamespaces.
+	Namespaces string
+	// Quotas evicts entries of the namespaces over their quota, and of the store over its bound, as
+	// entries are created. Nil does not enforce quotas.
+	Quotas *NamespaceQuotaEnforcer
+	// CachedExecutions records the pods served from cache in ML Metadata as they succeed. Nil does
+	// not record them.
+	CachedExecutions *CachedExecutionRecorder
+	// CacheReuses records the nodes of runs served from cache as their pods succeed. Nil does not
+	// record them.
+	CacheReuses CacheReuseStore
+	// Scrubber deletes the entries whose artifacts no longer exist in the background. Nil does not
+	// scrub them.
+	Scrubber *ArtifactScrubber
+	// Purger deletes the entries of the runs whose workflow is deleted with the purge annotation.
+	// Nil does not purge them.
+	Purger *WorkflowPurger
+	// ClusterID is recorded on the entries, for the webhooks of the clusters sharing the cache
+	// store to tell them apart. Empty does not identify the cluster.
+	ClusterID string
+	// EnforceOwner records an entry per owner, as the webhook only reuses the entries of the owner
+	// of the pod, or shared ones.
+	EnforceOwner bool
+	// DefaultTTL is the time-to-live of the entries of the pods without cache_ttl annotation. Zero
+	// keeps them forever.
+	DefaultTTL time.Duration
+	// Keys are the keys of the annotations and labels of the pods. The zero value means the keys
+	// under DefaultAnnotationPrefix.
+	Keys AnnotationKeys
+	// Metrics records what the watcher does. Nil records nothing.
+	Metrics WatcherMetrics
+}
+
+// withDefaults returns the config with the keys and metrics of the zero values.
+func (c WatcherConfig) withDefaults() WatcherConfig {
+	c.Keys = c.Keys.orDefault()
+	if c.Metrics == nil {
+		c.Metrics = noopWatcherMetrics{}
+	}
+	return c
+}
+
+// WatchPods records the outputs of completed cacheable pods until ctx is done. The pods labeled
+// with the cache ID label are followed by an informer, which lists them again whenever its watch
+// breaks or expires, so that pods completing in between are still recorded. Pods that completed
+// while the watcher was down are found by the initial list. The pod being processed when ctx is
+// done is still recorded. With config.BackfillOnStart, the succeeded pods without the label are
+// backfilled alongside, with config.Scrubber, the entries whose artifacts no longer exist are
+// deleted, and with config.Purger, those of the runs whose workflow is deleted with the purge
+// annotation.
+//
+// The pods of namespaceToWatch, all namespaces when empty, are watched unless config.Namespaces
+// names others. These are resolved once, so changes to them are picked up on restart.
+func WatchPods(ctx context.Context, namespaceToWatch string, clientManager ClientManagerInterface, config WatcherConfig) {
+	watchPods(ctx, namespaceToWatch, clientManager, config, util.NewRealTime())
+}
+
+func watchPods(ctx context.Context, namespaceToWatch string, clientManager ClientManagerInterface, config WatcherConfig, time util.TimeInterface) {
+	config = config.withDefaults()
 	k8sCore := clientManager.KubernetesCoreClient()
+	namespaces := []string{namespaceToWatch}
+	if config.Namespaces != "" {
+		watched, err := ParseWatchedNamespaces(config.Namespaces)
+		if err != nil {
+			logger.Errorf("Not watching pods: %v", err)
+			return
+		}
+		if namespaces, err = watched.resolve(ctx, k8sCore); err != nil {
+			return
+		}
+		if len(namespaces) == 0 {
+			logger.Warnf("No namespace matches %q, no pod is watched", config.Namespaces)
+			<-ctx.Done()
+			return
+		}
+		logger.Infof("Watching the pods of the namespaces %s", strings.Join(namespaces, ","))
+	}
 
-	for {
-		listOptions := metav1.ListOptions{
-			Watch:         true,
-			LabelSelector: CacheIDLabelKey,
+	patchLimiter := newPatchLimiter(config)
+	writer := newQueuedEntryWriter(&cacheEntryWriter{
+		clientManager: clientManager,
+		patchLimiter:  patchLimiter,
+		time:          time,
+		quotas:        config.Quotas,
+		clusterID:     config.ClusterID,
+		enforceOwner:  config.EnforceOwner,
+		defaultTTL:    config.DefaultTTL,
+		keys:          config.Keys,
+		metrics:       config.Metrics,
+	}, config)
+	writing := make(chan struct{})
+	go func() {
+		writer.run(ctx, config.WriteWorkers)
+		close(writing)
+	}()
+	backfilling := make(chan struct{})
+	go func() {
+		defer close(backfilling)
+		if config.BackfillOnStart {
+			runBackfill(ctx, namespaces, clientManager, &backfillWriter{cacheEntryWriter: &cacheEntryWriter{
+				clientManager: clientManager,
+				patchLimiter:  patchLimiter,
+				time:          time,
+				quotas:        config.Quotas,
+				clusterID:     config.ClusterID,
+				enforceOwner:  config.EnforceOwner,
+				defaultTTL:    config.DefaultTTL,
+				keys:          config.Keys,
+				metrics:       config.Metrics,
+			}}, config.BackfillMaxAge, time)
+		}
+	}()
+	recordingExecutions := make(chan struct{})
+	go func() {
+		defer close(recordingExecutions)
+		if config.CachedExecutions != nil {
+			config.CachedExecutions.run(ctx)
+		}
+	}()
+	scrubbing := make(chan struct{})
+	go func() {
+		defer close(scrubbing)
+		if config.Scrubber != nil {
+			config.Scrubber.run(ctx)
+		}
+	}()
+	purging := make(chan struct{})
+	go func() {
+		defer close(purging)
+		if config.Purger != nil && clientManager.ArgoClient() != nil {
+			config.Purger.run(ctx, clientManager.ArgoClient(), namespaces, config.Keys)
+		}
+	}()
+	var informers sync.WaitGroup
+	runInformer := func(namespace string, recordedNamespaces map[string]bool) {
+		recorder := &podOutputRecorder{
+			namespaces:       recordedNamespaces,
+			clientManager:    clientManager,
+			catchUpLookback:  config.CatchUpLookback,
+			writer:           writer,
+			cachedExecutions: config.CachedExecutions,
+			cacheReuses:      config.CacheReuses,
+			keys:             config.Keys,
+			metrics:          config.Metrics,
+			time:             time,
+			recorded:         map[string]types.UID{},
+		}
+		informers.Add(1)
+		go func() {
+			defer informers.Done()
+			runPodInformer(ctx, k8sCore.PodClient(namespace), config.ResyncPeriod, recorder)
+		}()
+	}
+	if len(namespaces) <= maxNamespaceInformers {
+		for _, namespace := range namespaces {
+			runInformer(namespace, nil)
+		}
+	} else {
+		recordedNamespaces := make(map[string]bool, len(namespaces))
+		for _, namespace := range namespaces {
+			recordedNamespaces[namespace] = true
 		}
-		watcher, err := k8sCore.PodClient(namespaceToWatch).Watch(listOptions)
+		runInformer(metav1.NamespaceAll, recordedNamespaces)
+	}
+	informers.Wait()
+	<-backfilling
+	<-writing
+	<-recordingExecutions
+	<-scrubbing
+	<-purging
+	writer.dropPending()
+}
 
+// runPodInformer queues the cacheable pods of the client for the recorder until ctx is done. Pods
+// notified again while queued are only recorded once, in their latest state.
+func runPodInformer(ctx context.Context, pods v1.PodInterface, resyncPeriod time.Duration, recorder *podOutputRecorder) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = recorder.keys.CacheIDLabelKey
+			return pods.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = recorder.keys.CacheIDLabelKey
+			return pods.Watch(options)
+		},
+	}, &corev1.Pod{}, resyncPeriod, cache.Indexers{})
+	queue := &podQueue{Interface: workqueue.New(), metrics: recorder.metrics}
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 		if err != nil {
-			log.Printf("Watcher error:" + err.Error())
+			logger.Errorf("Unable to queue the pod: %v", err)
+			return
+		}
+		queue.Add(key)
+		queue.updateDepth()
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(_, newObj interface{}) {
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	})
+
+	var informing sync.WaitGroup
+	informing.Add(1)
+	go func() {
+		defer informing.Done()
+		informer.Run(ctx.Done())
+		queue.ShutDown()
+	}()
+	for {
+		key, shutdown := queue.Get()
+		queue.updateDepth()
+		if shutdown {
+			break
+		}
+		// The pods still queued once ctx is done are dropped, they are listed again on restart.
+		if ctx.Err() == nil {
+			recorder.recordKey(key.(string), informer.GetIndexer())
+		}
+		queue.Done(key)
+	}
+	informing.Wait()
+}
+
+// podQueue queues the keys of the pods to record, and exports its depth.
+type podQueue struct {
+	workqueue.Interface
+	metrics WatcherMetrics
+	mutex   sync.Mutex
+	depth   int
+}
+
+// updateDepth reports the change in the depth of the queue since the last update.
+func (q *podQueue) updateDepth() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	depth := q.Len()
+	q.metrics.AddQueuedPods(depth - q.depth)
+	q.depth = depth
+}
+
+// podOutputRecorder records the outputs of the pods notified by an informer, one at a time.
+type podOutputRecorder struct {
+	// namespaces filters the pods of an informer over all namespaces. Pods of every namespace are
+	// recorded when nil.
+	namespaces      map[string]bool
+	clientManager   ClientManagerInterface
+	catchUpLookback time.Duration
+	writer          entryWriter
+	// cachedExecutions records the pods served from cache in ML Metadata, unless nil.
+	cachedExecutions *CachedExecutionRecorder
+	// cacheReuses records the nodes of runs served from cache, unless nil.
+	cacheReuses CacheReuseStore
+	time        util.TimeInterface
+	keys        AnnotationKeys
+	metrics     WatcherMetrics
+	// recorded holds the UID of the pods recorded or skipped by key until they are deleted, since
+	// updates of a pod notified before its cache_id label was patched would otherwise record it
+	// again.
+	recorded map[string]types.UID
+}
+
+// recordKey records the pod of key in the informer's cache, or forgets it once deleted.
+func (r *podOutputRecorder) recordKey(key string, pods cache.Indexer) {
+	obj, exists, err := pods.GetByKey(key)
+	if err != nil {
+		logger.Errorf("Unable to get the pod %s: %v", key, err)
+		return
+	}
+	if !exists {
+		delete(r.recorded, key)
+		return
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || r.recorded[key] == pod.ObjectMeta.UID {
+		return
+	}
+	if r.namespaces != nil && !r.namespaces[pod.ObjectMeta.Namespace] {
+		return
+	}
+	if r.completedBeforeLookback(pod) {
+		logger.WithFields(logrus.Fields{
+			logging.FieldPod:       pod.ObjectMeta.Name,
+			logging.FieldNamespace: pod.ObjectMeta.Namespace,
+		}).Debug("Pod completed before the catch-up lookback, its outputs are not recorded")
+		r.recorded[key] = pod.ObjectMeta.UID
+		return
+	}
+	if recordPodOutput(pod, r.clientManager, r.writer, r.keys, r.metrics) {
+		if r.cacheReuses != nil && !recordCacheReuse(pod, r.keys, r.cacheReuses, r.time) {
+			return
 		}
+		r.recorded[key] = pod.ObjectMeta.UID
+		if r.cachedExecutions != nil {
+			r.cachedExecutions.record(pod)
+		}
+	}
+}
 
-		for event := range watcher.ResultChan() {
-			pod := reflect.ValueOf(event.Object).Interface().(*corev1.Pod)
-			if event.Type == watch.Error {
-				continue
-			}
-			log.Printf((*pod).GetName())
+func newPatchLimiter(config WatcherConfig) flowcontrol.RateLimiter {
+	return newTokenBucketLimiter(config.PatchQPS, config.PatchBurst)
+}
+
+// newTokenBucketLimiter limits to qps with bursts of burst, or not at all when qps is zero or
+// less.
+func newTokenBucketLimiter(qps float64, burst int) flowcontrol.RateLimiter {
+	if qps <= 0 {
+		return flowcontrol.NewFakeAlwaysRateLimiter()
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(float32(qps), burst)
+}
 
-			if !isPodCompletedAndSucceeded(pod) {
-				log.Printf("Pod %s is not completed or not in successful status.", pod.ObjectMeta.Name)
-				continue
-			}
+func (r *podOutputRecorder) completedBeforeLookback(pod *corev1.Pod) bool {
+	if r.catchUpLookback <= 0 || !classifyPodTermination(pod).Terminated {
+		return false
+	}
+	completedAt := podCompletedAt(pod)
+	return !completedAt.IsZero() && completedAt.Before(r.time.Now().Add(-r.catchUpLookback))
+}
 
-			if isCacheWriten(pod.ObjectMeta.Labels) {
-				continue
-			}
+// podCompletedAt returns the time the last container of the pod terminated, or the zero time when
+// none reports it.
+func podCompletedAt(pod *corev1.Pod) time.Time {
+	var completedAt time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.FinishedAt.Time.After(completedAt) {
+			completedAt = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	return completedAt
+}
 
-			executionKey, exists := pod.ObjectMeta.Annotations[ExecutionKey]
-			if !exists {
-				continue
-			}
+// podExecutionDuration returns the wall-clock time from the start of the first container of the
+// pod to the end of its last, or 0 when its containers do not report both.
+func podExecutionDuration(pod *corev1.Pod) time.Duration {
+	var startedAt time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.StartedAt.IsZero() {
+			continue
+		}
+		if startedAt.IsZero() || terminated.StartedAt.Time.Before(startedAt) {
+			startedAt = terminated.StartedAt.Time
+		}
+	}
+	completedAt := podCompletedAt(pod)
+	if startedAt.IsZero() || completedAt.Before(startedAt) {
+		return 0
+	}
+	return completedAt.Sub(startedAt)
+}
 
-			executionOutput, exists := pod.ObjectMeta.Annotations[ArgoWorkflowOutputs]
+// recordPodOutput has writer create the cache entry of a completed and succeeded pod and label the
+// pod with its ID. The pod, which may be shared with the informer's cache, is left unchanged. It
+// reports whether the pod is done with, that is recorded or completed without genuinely
+// succeeding.
+func recordPodOutput(pod *corev1.Pod, clientManager ClientManagerInterface, writer entryWriter, keys AnnotationKeys, metrics WatcherMetrics) bool {
+	podLogger := logger.WithFields(logrus.Fields{
+		logging.FieldPod:       pod.ObjectMeta.Name,
+		logging.FieldNamespace: pod.ObjectMeta.Namespace,
+	})
 
-			executionOutputMap := make(map[string]interface{})
-			executionOutputMap[ArgoWorkflowOutputs] = executionOutput
-			executionOutputMap[MetadataExecutionIDKey] = pod.ObjectMeta.Labels[MetadataExecutionIDKey]
-			executionOutputJSON, _ := json.Marshal(executionOutputMap)
+	termination := classifyPodTermination(pod)
+	if !termination.Terminated {
+		podLogger.Debug("Pod is not completed")
+		return false
+	}
+	if !termination.Succeeded() {
+		podLogger.WithField(logging.FieldSkipReason, termination.SkipReason).Debug("Pod did not succeed, its outputs are not recorded")
+		metrics.PodSkipped(termination.SkipReason)
+		return true
+	}
 
-			executionMaxCacheStaleness, exists := pod.ObjectMeta.Annotations[MaxCacheStalenessKey]
-			var maxCacheStalenessInSeconds int64 = -1
-			if exists {
-				maxCacheStalenessInSeconds = getMaxCacheStaleness(executionMaxCacheStaleness)
-			}
+	if pod.ObjectMeta.Labels[keys.CachedLabelKey] == KFPCachedLabelValue {
+		podLogger.WithField(logging.FieldSkipReason, PodSkipReasonAlreadyCached).Debug("Pod was served from cache, its outputs are not recorded")
+		metrics.PodSkipped(PodSkipReasonAlreadyCached)
+		return true
+	}
+	// Pods served from cache carry the ID of the entry they reused, and pods already recorded the
+	// ID of their own.
+	if keys.isCacheWriten(pod.ObjectMeta.Labels) {
+		return false
+	}
 
-			executionTemplate := pod.ObjectMeta.Annotations[ArgoWorkflowTemplate]
-			executionToPersist := model.ExecutionCache{
-				ExecutionCacheKey: executionKey,
-				ExecutionTemplate: executionTemplate,
-				ExecutionOutput:   string(executionOutputJSON),
-				MaxCacheStaleness: maxCacheStalenessInSeconds,
-			}
+	executionKey, exists := pod.ObjectMeta.Annotations[keys.ExecutionKey]
+	if !exists {
+		return false
+	}
 
-			cacheEntryCreated, err := clientManager.CacheStore().CreateExecutionCache(&executionToPersist)
-			if err != nil {
-				log.Println("Unable to create cache entry.")
-				continue
-			}
-			err = patchCacheID(k8sCore, pod, namespaceToWatch, cacheEntryCreated.ID)
-			if err != nil {
-				log.Printf(err.Error())
-			}
+	orchestrator := podOrchestratorOf(pod)
+	executionOutput, exists := orchestrator.outputs(pod)
+	if _, tekton := orchestrator.(tektonPodOrchestrator); !exists && !tekton && clientManager.ArgoClient() != nil {
+		resolved, skipReason, err := resolveWorkflowOutputs(clientManager.ArgoClient(), pod)
+		if err != nil {
+			podLogger.Errorf("Unable to resolve the outputs from the workflow: %v", err)
+			return false
+		}
+		if skipReason != "" {
+			podLogger.WithField(logging.FieldSkipReason, skipReason).Warn("Pod has no outputs annotation and its outputs could not be resolved from the workflow, they are not recorded")
+			metrics.PodSkipped(skipReason)
+			return true
+		}
+		executionOutput = resolved
+	}
+	// Outputs are recorded in canonical form, whichever Argo version or orchestrator wrote them.
+	// Pods without outputs record none, as before.
+	if executionOutput != "" {
+		normalized, err := outputs.Normalize(executionOutput)
+		if err != nil {
+			podLogger.WithField(logging.FieldSkipReason, PodSkipReasonInvalidOutputs).Warnf("Pod outputs cannot be parsed, they are not recorded: %v", err)
+			metrics.PodSkipped(PodSkipReasonInvalidOutputs)
+			return true
 		}
+		executionOutput = normalized
+	}
+
+	executionOutputMap := make(map[string]interface{})
+	executionOutputMap[ArgoWorkflowOutputs] = executionOutput
+	executionOutputMap[keys.MetadataExecutionIDKey] = pod.ObjectMeta.Labels[keys.MetadataExecutionIDKey]
+	if _, tfx := orchestrator.(tfxPodOrchestrator); tfx {
+		// TFX records the execution of the pod in the run of its workflow, where hits find it.
+		executionOutputMap[TFXWorkflowKey] = pod.ObjectMeta.Labels[ArgoWorkflowLabelKey]
+	}
+	executionOutputJSON, _ := json.Marshal(executionOutputMap)
+
+	executionTemplate, _ := orchestrator.template(pod)
+	executionToPersist := model.ExecutionCache{
+		ExecutionCacheKey:      executionKey,
+		ExecutionTemplate:      executionTemplate,
+		ExecutionOutput:        string(executionOutputJSON),
+		MaxCacheStaleness:      keys.podMaxCacheStaleness(pod.ObjectMeta.Annotations),
+		Owner:                  keys.getPodOwner(pod, pod.ObjectMeta.Namespace),
+		ExecutionDurationInSec: int64(podExecutionDuration(pod).Seconds()),
+		PipelineName:           pod.ObjectMeta.Annotations[keys.PipelineNameKey],
+		PipelineVersionID:      pod.ObjectMeta.Annotations[keys.PipelineVersionIDKey],
+		RunID:                  pod.ObjectMeta.Labels[RunIDLabelKey],
+		Namespace:              pod.ObjectMeta.Namespace,
+		// Pods admitted before the webhook annotated key versions were keyed by the first one.
+		KeyVersion: keys.cacheKeyVersion(pod.ObjectMeta.Annotations, CacheKeyVersionV1),
 	}
+
+	return writer.write(&executionToPersist, pod)
 }
 
-func isPodCompletedAndSucceeded(pod *corev1.Pod) bool {
-	return pod.ObjectMeta.Labels[ArgoCompleteLabelKey] == "true" && pod.Status.Phase == corev1.PodSucceeded
+// createExecutionCacheIfAbsent creates the cache entry unless the latest entry of its key recorded
+// in its cluster holds the same outputs, or unless its key has any entry in its cluster when
+// anyOutputs is set, which is then returned as not created. Outputs name the artifacts of the pod
+// that produced them, so the same outputs are those of a pod recorded before its cache_id label was
+// patched, e.g. by a watcher that stopped in between. With enforceOwner, only the entries of its
+// owner and shared ones count, as those of other owners are not reused for it.
+func createExecutionCacheIfAbsent(ctx context.Context, store storage.ExecutionCacheStoreInterface, executionCache *model.ExecutionCache, anyOutputs bool, enforceOwner bool) (*model.ExecutionCache, bool, error) {
+	existing, err := store.GetExecutionCache(ctx, executionCache.ExecutionCacheKey, -1, storage.ExecutionCacheFilter{
+		EnforceOwner: enforceOwner,
+		Owner:        executionCache.Owner,
+		ClusterID:    executionCache.ClusterID,
+		KeyVersion:   executionCache.KeyVersion,
+	})
+	if err == nil && (anyOutputs || existing.ExecutionOutput == executionCache.ExecutionOutput) {
+		return existing, false, nil
+	}
+	created, err := store.CreateExecutionCache(ctx, executionCache)
+	return created, err == nil, err
 }
 
-func isCacheWriten(labels map[string]string) bool {
-	cacheID := labels[CacheIDLabelKey]
+func (k AnnotationKeys) isCacheWriten(labels map[string]string) bool {
+	cacheID := labels[k.CacheIDLabelKey]
 	return cacheID != ""
 }
 
-func patchCacheID(k8sCore client.KubernetesCoreInterface, podToPatch *corev1.Pod, namespaceToWatch string, id int64) error {
-	labels := podToPatch.ObjectMeta.Labels
-	labels[CacheIDLabelKey] = strconv.FormatInt(id, 10)
-	log.Println(id)
-	var patchOps []patchOperation
-	patchOps = append(patchOps, patchOperation{
-		Op:    OperationTypeAdd,
-		Path:  LabelPath,
-		Value: labels,
-	})
-	patchBytes, err := json.Marshal(patchOps)
+// patchCacheID labels the pod with the ID of its cache entry, so that users and the KFP UI can see
+// the step is cached. The merge patch only sets the label, leaving those set since the pod was
+// notified. Pods deleted in the meantime, e.g. garbage collected once succeeded, are no error.
+func patchCacheID(k8sCore client.KubernetesCoreInterface, limiter flowcontrol.RateLimiter, keys AnnotationKeys, podToPatch *corev1.Pod, id int64) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{keys.CacheIDLabelKey: strconv.FormatInt(id, 10)},
+		},
+	}
+	patchBytes, err := json.Marshal(patch)
 	if err != nil {
 		return fmt.Errorf("Unable to patch cache_id to pod: %s", podToPatch.ObjectMeta.Name)
 	}
-	_, err = k8sCore.PodClient(namespaceToWatch).Patch(podToPatch.ObjectMeta.Name, types.JSONPatchType, patchBytes)
-	if err != nil {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		limiter.Accept()
+		_, err := k8sCore.PodClient(podToPatch.ObjectMeta.Namespace).Patch(podToPatch.ObjectMeta.Name, types.MergePatchType, patchBytes)
 		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// podMaxCacheStaleness returns the max cache staleness in seconds of the pod or template with the
+// annotations, or -1 when they set none.
+func (k AnnotationKeys) podMaxCacheStaleness(annotations map[string]string) int64 {
+	if maxCacheStaleness, exists := annotations[k.MaxCacheStalenessKey]; exists {
+		return getMaxCacheStaleness(maxCacheStaleness)
+	}
+	return -1
+}
+
+// entryExpiresAtInSec returns when the time-to-live of the entry of the pod with the annotations,
+// recorded now on the clock, ends: after the time-to-live of its annotation, or else after
+// defaultTTL. It returns 0 for entries that never expire, without reading the clock. Invalid
+// annotations are ignored.
+func (k AnnotationKeys) entryExpiresAtInSec(annotations map[string]string, defaultTTL time.Duration, clock util.TimeInterface) int64 {
+	ttlInSec := int64(defaultTTL / time.Second)
+	if ttl, exists := annotations[k.CacheTTLKey]; exists {
+		if annotatedTTLInSec := getMaxCacheStaleness(ttl); annotatedTTLInSec >= 0 {
+			ttlInSec = annotatedTTLInSec
+		}
+	}
+	if ttlInSec <= 0 {
+		return 0
 	}
-	log.Printf("Cache id patched.")
-	return nil
+	return clock.Now().Unix() + ttlInSec
 }
 
 // Convert RFC3339 Duration(Eg. "P1DT30H4S") to int64 seconds.
@@ -0,0 +1,696 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const watchedNamespace = "kubeflow"
+
+// watcherStartTime is the time watchers start at, to which the completion times of pods are compared.
+var watcherStartTime = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// watchedClientManager serves the pods of a fake clientset.
+type watchedClientManager struct {
+	*FakeClientManager
+	core client.KubernetesCoreInterface
+}
+
+func (m watchedClientManager) KubernetesCoreClient() client.KubernetesCoreInterface {
+	return m.core
+}
+
+// watcherTime shares a fake time between the recorders and the writers of a watcher.
+type watcherTime struct {
+	mutex sync.Mutex
+	time  util.TimeInterface
+}
+
+func (t *watcherTime) Now() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.time.Now()
+}
+
+// startWatchingPods watches the pods of clientset until the returned function is called.
+func startWatchingPods(clientset *fake.Clientset, clientManager *FakeClientManager, config WatcherConfig) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchPods(ctx, watchedNamespace, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, config, &watcherTime{time: util.NewFakeTime(watcherStartTime)})
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// cacheablePod returns a pending KFP pod whose outputs are to be cached.
+func cacheablePod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: watchedNamespace,
+			UID:       types.UID(name + "-uid"),
+			Labels: map[string]string{
+				podKeys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue,
+				podKeys.CacheIDLabelKey:      "",
+			},
+			Annotations: map[string]string{
+				podKeys.ExecutionKey: name + "-key",
+				ArgoWorkflowTemplate: `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`,
+				ArgoWorkflowOutputs:  `{"parameters":[{"name":"message","value":"Hello"}]}`,
+			},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+}
+
+// transitionPod moves the pod to phase, completing it when Argo would.
+func transitionPod(t *testing.T, clientset *fake.Clientset, name string, phase corev1.PodPhase) {
+	pods := clientset.CoreV1().Pods(watchedNamespace)
+	pod, err := pods.Get(name, metav1.GetOptions{})
+	require.Nil(t, err)
+	pod.Status.Phase = phase
+	if phase == corev1.PodSucceeded || phase == corev1.PodFailed {
+		pod.ObjectMeta.Labels[ArgoCompleteLabelKey] = "true"
+	}
+	_, err = pods.Update(pod)
+	require.Nil(t, err)
+}
+
+// recordPodOutputNow records the pod, writing its entry right away and recording the outcome to
+// metrics.
+func recordPodOutputNow(pod *corev1.Pod, clientManager ClientManagerInterface, metrics WatcherMetrics) bool {
+	return recordPodOutput(pod, clientManager, &cacheEntryWriter{
+		clientManager: clientManager,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		keys:          podKeys,
+		metrics:       metrics,
+	}, podKeys, metrics)
+}
+
+func countCacheEntries(t *testing.T, clientManager *FakeClientManager, executionCacheKey string) int {
+	var count int
+	require.Nil(t, clientManager.DB().Table("execution_caches").Where("ExecutionCacheKey = ?", executionCacheKey).Count(&count).Error)
+	return count
+}
+
+// hasCacheEntries returns a condition for require.Eventually. Failed queries, e.g. while the
+// watcher holds the database, leave it unmet.
+func hasCacheEntries(clientManager *FakeClientManager, executionCacheKey string, expected int) func() bool {
+	return func() bool {
+		var count int
+		err := clientManager.DB().Table("execution_caches").Where("ExecutionCacheKey = ?", executionCacheKey).Count(&count).Error
+		return err == nil && count == expected
+	}
+}
+
+func TestWatchPodsRecordsSucceededPods(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset()
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+	defer stop()
+
+	_, err := clientset.CoreV1().Pods(watchedNamespace).Create(cacheablePod("step"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "step", corev1.PodRunning)
+	transitionPod(t, clientset, "step", corev1.PodSucceeded)
+
+	require.Eventually(t, func() bool {
+		pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("step", metav1.GetOptions{})
+		return err == nil && pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("step", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, strconv.FormatInt(entry.ID, 10), pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+	assert.Equal(t, KFPCacheEnabledLabelValue, pod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey], "the other labels are kept")
+	assert.Equal(t, `{"container":{"command":["echo","Hello"],"image":"python:3.7"}}`, entry.ExecutionTemplate)
+	assert.Equal(t, int64(-1), entry.MaxCacheStaleness)
+	assert.Equal(t, util.NewFakeTimeForEpoch().Now().Unix(), entry.StartedAtInSec)
+	var output map[string]string
+	require.Nil(t, json.Unmarshal([]byte(entry.ExecutionOutput), &output))
+	assert.Equal(t, `{"parameters":[{"name":"message","value":"Hello"}]}`, output[ArgoWorkflowOutputs])
+}
+
+func TestWatchPodsRecordsPodsSucceededBeforeWatching(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := cacheablePod("step")
+	pod.Status.Phase = corev1.PodSucceeded
+	pod.ObjectMeta.Labels[ArgoCompleteLabelKey] = "true"
+	clientset := fake.NewSimpleClientset(pod)
+
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+	defer stop()
+
+	assert.Eventually(t, hasCacheEntries(clientManager, "step-key", 1), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchPodsSkipsPodsNotToRecord(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset()
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+	defer stop()
+	pods := clientset.CoreV1().Pods(watchedNamespace)
+
+	cachedPod := cacheablePod("cached")
+	cachedPod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "7"
+	_, err := pods.Create(cachedPod)
+	require.Nil(t, err)
+	transitionPod(t, clientset, "cached", corev1.PodSucceeded)
+	_, err = pods.Create(cacheablePod("failed"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "failed", corev1.PodFailed)
+	_, err = pods.Create(cacheablePod("running"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "running", corev1.PodRunning)
+	keylessPod := cacheablePod("keyless")
+	delete(keylessPod.ObjectMeta.Annotations, podKeys.ExecutionKey)
+	_, err = pods.Create(keylessPod)
+	require.Nil(t, err)
+	transitionPod(t, clientset, "keyless", corev1.PodSucceeded)
+	// The pods are handled in order, so the others were handled once this one is recorded.
+	_, err = pods.Create(cacheablePod("last"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "last", corev1.PodSucceeded)
+	require.Eventually(t, hasCacheEntries(clientManager, "last-key", 1), 5*time.Second, 10*time.Millisecond)
+
+	for _, name := range []string{"cached", "failed", "running"} {
+		assert.Equal(t, 0, countCacheEntries(t, clientManager, name+"-key"), name)
+	}
+	var count int
+	require.Nil(t, clientManager.DB().Table("execution_caches").Count(&count).Error)
+	assert.Equal(t, 1, count)
+	cached, err := pods.Get("cached", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "7", cached.ObjectMeta.Labels[podKeys.CacheIDLabelKey], "pods served from cache keep the ID of the entry they reused")
+}
+
+func TestWatchPodsRecordsEachPodOnce(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	clientset := fake.NewSimpleClientset()
+	// The pod keeps an empty cache_id label, like a pod updated before its label is patched.
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+	defer stop()
+
+	_, err := clientset.CoreV1().Pods(watchedNamespace).Create(cacheablePod("step"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "step", corev1.PodSucceeded)
+	require.Eventually(t, hasCacheEntries(clientManager, "step-key", 1), 5*time.Second, 10*time.Millisecond)
+	transitionPod(t, clientset, "step", corev1.PodSucceeded)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "step-key"))
+}
+
+func TestWatchPodsRecordsAgainAfterFailedWrite(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	store := &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: errors.New("connection refused")}
+	clientManager.cacheStore = store
+	clientset := fake.NewSimpleClientset()
+
+	_, err := clientset.CoreV1().Pods(watchedNamespace).Create(cacheablePod("step"))
+	require.Nil(t, err)
+	transitionPod(t, clientset, "step", corev1.PodSucceeded)
+	// Failed writes are retried without waiting for a resync.
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+	defer stop()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 0, countCacheEntries(t, clientManager, "step-key"))
+	store.setCreateErr(nil)
+
+	assert.Eventually(t, hasCacheEntries(clientManager, "step-key", 1), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchPodsRecordsPodsCompletedWhileTheWatchWasBroken(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakWatch func(watcher *watch.FakeWatcher)
+	}{
+		{
+			name:       "closed",
+			breakWatch: func(watcher *watch.FakeWatcher) { watcher.Stop() },
+		},
+		{
+			name: "expired",
+			breakWatch: func(watcher *watch.FakeWatcher) {
+				watcher.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+			defer clientManager.Close()
+			clientset := fake.NewSimpleClientset()
+			// The first watch is served by firstWatcher, which delivers no events until it breaks.
+			firstWatcher := watch.NewFake()
+			var watches int32
+			clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+				return atomic.AddInt32(&watches, 1) == 1, firstWatcher, nil
+			})
+			stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour})
+			defer stop()
+			require.Eventually(t, func() bool { return atomic.LoadInt32(&watches) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+			_, err := clientset.CoreV1().Pods(watchedNamespace).Create(cacheablePod("step"))
+			require.Nil(t, err)
+			transitionPod(t, clientset, "step", corev1.PodSucceeded)
+			test.breakWatch(firstWatcher)
+
+			require.Eventually(t, hasCacheEntries(clientManager, "step-key", 1), 5*time.Second, 10*time.Millisecond)
+			// Updates delivered by the new watch do not record the pod again.
+			transitionPod(t, clientset, "step", corev1.PodSucceeded)
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, 1, countCacheEntries(t, clientManager, "step-key"))
+		})
+	}
+}
+
+// completedPod returns a pod that succeeded the given time before the watcher started.
+func completedPod(name string, before time.Duration) *corev1.Pod {
+	pod := cacheablePod(name)
+	pod.Status.Phase = corev1.PodSucceeded
+	pod.ObjectMeta.Labels[ArgoCompleteLabelKey] = "true"
+	finishedAt := metav1.NewTime(watcherStartTime.Add(-before))
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "main", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed", FinishedAt: finishedAt}}},
+		{Name: "wait", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed", FinishedAt: finishedAt}}},
+	}
+	return pod
+}
+
+func TestWatchPodsCatchesUpOnRestart(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	config := WatcherConfig{ResyncPeriod: time.Hour, CatchUpLookback: 24 * time.Hour}
+	clientset := fake.NewSimpleClientset(completedPod("interrupted", time.Hour))
+	// The first watcher stops after recording the pod but before labeling it.
+	var patchFails int32 = 1
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.LoadInt32(&patchFails) == 1 {
+			return true, nil, errors.New("connection reset")
+		}
+		return false, nil, nil
+	})
+	stop := startWatchingPods(clientset, clientManager, config)
+	require.Eventually(t, hasCacheEntries(clientManager, "interrupted-key", 1), 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	// Pods completed while the watcher was down are found when it starts again.
+	atomic.StoreInt32(&patchFails, 0)
+	pods := clientset.CoreV1().Pods(watchedNamespace)
+	for _, pod := range []*corev1.Pod{completedPod("while-down", 2*time.Hour), completedPod("long-ago", 48*time.Hour)} {
+		_, err := pods.Create(pod)
+		require.Nil(t, err)
+	}
+	stop = startWatchingPods(clientset, clientManager, config)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		for _, name := range []string{"interrupted", "while-down"} {
+			pod, err := pods.Get(name, metav1.GetOptions{})
+			if err != nil || pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] == "" {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "interrupted-key"), "the entry recorded before the restart is reused")
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "while-down-key"))
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "long-ago-key"), "pods completed before the lookback are not recorded")
+	interrupted, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "interrupted-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	pod, err := pods.Get("interrupted", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, strconv.FormatInt(interrupted.ID, 10), pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+// recordLatencies returns the number and the sum in seconds of the record latencies observed.
+func recordLatencies(t *testing.T, registry *prometheus.Registry) (uint64, float64) {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == "cache_watcher_record_latency_seconds" {
+			histogram := family.GetMetric()[0].GetHistogram()
+			return histogram.GetSampleCount(), histogram.GetSampleSum()
+		}
+	}
+	return 0, 0
+}
+
+func TestWatchPodsExportsMetrics(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	failedPod := completedPod("failed", time.Minute)
+	failedPod.Status.Phase = corev1.PodFailed
+	reusedPod := completedPod("reused", time.Minute)
+	reusedPod.ObjectMeta.Labels[podKeys.CachedLabelKey] = KFPCachedLabelValue
+	reusedPod.ObjectMeta.Labels[podKeys.CacheIDLabelKey] = "7"
+	// The entry of this pod was created by a watcher that stopped before labeling it.
+	duplicatePod := completedPod("duplicate", time.Minute)
+	require.True(t, recordPodOutputNow(duplicatePod, watchedClientManager{clientManager, client.NewKubernetesCore(fake.NewSimpleClientset())}, noopWatcherMetrics{}))
+	clientset := fake.NewSimpleClientset(completedPod("step", 30*time.Second), completedPod("unlabeled", time.Minute),
+		failedPod, reusedPod, duplicatePod)
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.PatchAction).GetName() == "unlabeled" {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+
+	stop := startWatchingPods(clientset, clientManager, WatcherConfig{ResyncPeriod: time.Hour, Metrics: metrics})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.createdEntries) == 2 && testutil.ToFloat64(metrics.duplicateEntries) == 1 &&
+			testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonFailed)) == 1 &&
+			testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonAlreadyCached)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.patchFailures), "the unlabeled pod")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.storeWriteErrors))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.queuedPods))
+	count, sum := recordLatencies(t, registry)
+	assert.Equal(t, uint64(2), count)
+	// The pods completed 30s and a minute before the watcher started, whose time advances by a
+	// second at each reading.
+	assert.Equal(t, float64(30+60+1+2), sum)
+
+	clientManager.cacheStore = &faultyExecutionCacheStore{ExecutionCacheStoreInterface: clientManager.cacheStore, createErr: errors.New("connection refused")}
+	assert.False(t, recordPodOutputNow(completedPod("unwritten", time.Minute), watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, metrics))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.storeWriteErrors))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.createdEntries))
+}
+
+func TestPatchCacheIDMergesTheLabel(t *testing.T) {
+	clientset := fake.NewSimpleClientset(cacheablePod("step"))
+	var patches []k8stesting.PatchAction
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction))
+		return false, nil, nil
+	})
+
+	err := patchCacheID(client.NewKubernetesCore(clientset), flowcontrol.NewFakeAlwaysRateLimiter(), podKeys, cacheablePod("step"), 42)
+
+	require.Nil(t, err)
+	require.Len(t, patches, 1)
+	assert.Equal(t, types.MergePatchType, patches[0].GetPatchType())
+	assert.JSONEq(t, `{"metadata":{"labels":{"pipelines.kubeflow.org/cache_id":"42"}}}`, string(patches[0].GetPatch()))
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("step", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "42", pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+	assert.Equal(t, KFPCacheEnabledLabelValue, pod.ObjectMeta.Labels[podKeys.CacheEnabledLabelKey], "the other labels are kept")
+}
+
+func TestPatchCacheIDRetriesConflicts(t *testing.T) {
+	clientset := fake.NewSimpleClientset(cacheablePod("step"))
+	var attempts int
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts == 1 {
+			return true, nil, apierrors.NewConflict(corev1.Resource("pods"), "step", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	err := patchCacheID(client.NewKubernetesCore(clientset), flowcontrol.NewFakeAlwaysRateLimiter(), podKeys, cacheablePod("step"), 42)
+
+	require.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	pod, err := clientset.CoreV1().Pods(watchedNamespace).Get("step", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "42", pod.ObjectMeta.Labels[podKeys.CacheIDLabelKey])
+}
+
+func TestRecordPodOutputToleratesDeletedPods(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	// The pod was garbage collected once succeeded, before it was recorded.
+	clientset := fake.NewSimpleClientset()
+	pod := completedPod("step", time.Minute)
+
+	recorded := recordPodOutputNow(pod, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{})
+
+	assert.True(t, recorded)
+	assert.Equal(t, 1, countCacheEntries(t, clientManager, "step-key"))
+	err := patchCacheID(client.NewKubernetesCore(clientset), flowcontrol.NewFakeAlwaysRateLimiter(), podKeys, pod, 42)
+	assert.Nil(t, err)
+}
+
+func TestPodExecutionDuration(t *testing.T) {
+	at := func(offset time.Duration) metav1.Time { return metav1.NewTime(watcherStartTime.Add(offset)) }
+	terminated := func(name string, startedAt, finishedAt metav1.Time) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{Reason: "Completed", StartedAt: startedAt, FinishedAt: finishedAt},
+		}}
+	}
+	for _, tc := range []struct {
+		name     string
+		statuses []corev1.ContainerStatus
+		want     time.Duration
+	}{
+		{"single container", []corev1.ContainerStatus{terminated("main", at(0), at(90*time.Second))}, 90 * time.Second},
+		{"first start to last finish", []corev1.ContainerStatus{
+			terminated("main", at(2*time.Second), at(60*time.Second)),
+			terminated("wait", at(0), at(65*time.Second)),
+		}, 65 * time.Second},
+		{"start not reported", []corev1.ContainerStatus{terminated("main", metav1.Time{}, at(time.Minute))}, 0},
+		{"not terminated", []corev1.ContainerStatus{{Name: "main", State: corev1.ContainerState{
+			Running: &corev1.ContainerStateRunning{StartedAt: at(0)},
+		}}}, 0},
+		{"no containers", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := cacheablePod("step")
+			pod.Status.ContainerStatuses = tc.statuses
+			assert.Equal(t, tc.want, podExecutionDuration(pod))
+		})
+	}
+}
+
+func TestRecordPodOutputPersistsTheExecutionDuration(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	for i := range pod.Status.ContainerStatuses {
+		terminated := pod.Status.ContainerStatuses[i].State.Terminated
+		terminated.StartedAt = metav1.NewTime(terminated.FinishedAt.Add(-90 * time.Second))
+	}
+	clientset := fake.NewSimpleClientset(pod)
+
+	require.True(t, recordPodOutputNow(pod, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(90), entry.ExecutionDurationInSec)
+}
+
+func TestRecordPodOutputRecordsTheClusterID(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	clientset := fake.NewSimpleClientset(pod)
+	watched := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	// Another cluster sharing the store recorded the same outputs, which do not keep this cluster
+	// from recording its own entry.
+	require.True(t, recordPodOutput(pod, watched, &cacheEntryWriter{
+		clientManager: watched,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		clusterID:     "eu-west1",
+		keys:          podKeys,
+		metrics:       noopWatcherMetrics{},
+	}, podKeys, noopWatcherMetrics{}))
+
+	require.True(t, recordPodOutput(pod, watched, &cacheEntryWriter{
+		clientManager: watched,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		clusterID:     "us-east1",
+		keys:          podKeys,
+		metrics:       noopWatcherMetrics{},
+	}, podKeys, noopWatcherMetrics{}))
+
+	assert.Equal(t, 2, countCacheEntries(t, clientManager, "step-key"))
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{ClusterID: "us-east1"})
+	require.Nil(t, err)
+	assert.Equal(t, "us-east1", entry.ClusterID)
+}
+
+func TestRecordPodOutputRecordsAnEntryPerOwner(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	alicePod := completedPod("step", time.Minute)
+	alicePod.ObjectMeta.Labels[podKeys.ProfileLabelKey] = "alice"
+	bobPod := completedPod("step", time.Minute)
+	bobPod.ObjectMeta.Name = "bob-step"
+	bobPod.ObjectMeta.Labels[podKeys.ProfileLabelKey] = "bob"
+	clientset := fake.NewSimpleClientset(alicePod, bobPod)
+	watched := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := &cacheEntryWriter{
+		clientManager: watched,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          util.NewFakeTime(watcherStartTime),
+		enforceOwner:  true,
+		keys:          podKeys,
+		metrics:       noopWatcherMetrics{},
+	}
+
+	// The entry of alice is not reused for the pod of bob, which gets an entry of its own.
+	require.True(t, recordPodOutput(alicePod, watched, writer, podKeys, noopWatcherMetrics{}))
+	require.True(t, recordPodOutput(bobPod, watched, writer, podKeys, noopWatcherMetrics{}))
+
+	assert.Equal(t, 2, countCacheEntries(t, clientManager, "step-key"))
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{EnforceOwner: true, Owner: "bob"})
+	require.Nil(t, err)
+	assert.Equal(t, "bob", entry.Owner)
+}
+
+func TestRecordPodOutputRecordsTheTimeToLive(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	pod.ObjectMeta.Annotations[podKeys.MaxCacheStalenessKey] = "P0D"
+	annotatedPod := completedPod("annotated", time.Minute)
+	annotatedPod.ObjectMeta.Annotations[podKeys.CacheTTLKey] = "PT1H"
+	lastingPod := completedPod("lasting", time.Minute)
+	lastingPod.ObjectMeta.Annotations[podKeys.CacheTTLKey] = "P0D"
+	clientset := fake.NewSimpleClientset(pod, annotatedPod, lastingPod)
+	watched := watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}
+	writer := &cacheEntryWriter{
+		clientManager: watched,
+		patchLimiter:  flowcontrol.NewFakeAlwaysRateLimiter(),
+		time:          &fixedClock{now: watcherStartTime},
+		defaultTTL:    24 * time.Hour,
+		keys:          podKeys,
+		metrics:       noopWatcherMetrics{},
+	}
+
+	for _, recorded := range []*corev1.Pod{pod, annotatedPod, lastingPod} {
+		require.True(t, recordPodOutput(recorded, watched, writer, podKeys, noopWatcherMetrics{}))
+	}
+
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", 1000, storage.ExecutionCacheFilter{})
+	require.Nil(t, err, "the entries of pods not reusing results are reused by the others")
+	assert.Equal(t, int64(0), entry.MaxCacheStaleness)
+	assert.Equal(t, watcherStartTime.Unix()+24*60*60, entry.ExpiresAtInSec)
+	entry, err = clientManager.CacheStore().GetExecutionCache(context.Background(), "annotated-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(-1), entry.MaxCacheStaleness)
+	assert.Equal(t, watcherStartTime.Unix()+60*60, entry.ExpiresAtInSec, "the annotation of the pod wins")
+	entry, err = clientManager.CacheStore().GetExecutionCache(context.Background(), "lasting-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), entry.ExpiresAtInSec)
+}
+
+func TestRecordPodOutputNormalizesTheOutputs(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	pod.ObjectMeta.Annotations[ArgoWorkflowOutputs] = `{"Parameters":[{"Name":"message","Value":"Hello"}],"exit_code":0}`
+	clientset := fake.NewSimpleClientset(pod)
+
+	require.True(t, recordPodOutputNow(pod, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	var output map[string]string
+	require.Nil(t, json.Unmarshal([]byte(entry.ExecutionOutput), &output))
+	assert.Equal(t, `{"exitCode":"0","parameters":[{"name":"message","value":"Hello"}]}`, output[ArgoWorkflowOutputs])
+}
+
+func TestRecordPodOutputRecordsTheProvenance(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	pod.ObjectMeta.Labels[RunIDLabelKey] = "run-1"
+	pod.ObjectMeta.Annotations[podKeys.PipelineNameKey] = "train"
+	pod.ObjectMeta.Annotations[podKeys.PipelineVersionIDKey] = "train-v1"
+	clientset := fake.NewSimpleClientset(pod)
+
+	require.True(t, recordPodOutputNow(pod, watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, noopWatcherMetrics{}))
+
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "train", entry.PipelineName)
+	assert.Equal(t, "train-v1", entry.PipelineVersionID)
+	assert.Equal(t, "run-1", entry.RunID)
+}
+
+func TestRecordPodOutputSkipsInvalidOutputs(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusWatcherMetrics(registry).(*prometheusWatcherMetrics)
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := completedPod("step", time.Minute)
+	pod.ObjectMeta.Annotations[ArgoWorkflowOutputs] = `{"parameters":[`
+
+	assert.True(t, recordPodOutputNow(pod, clientManager, metrics), "the pod is done with")
+
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "step-key"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.skippedPods.WithLabelValues(PodSkipReasonInvalidOutputs)))
+}
+
+func TestNewPatchLimiter(t *testing.T) {
+	unlimited := newPatchLimiter(WatcherConfig{})
+	for i := 0; i < 100; i++ {
+		require.True(t, unlimited.TryAccept())
+	}
+	limiter := newPatchLimiter(WatcherConfig{PatchQPS: 5, PatchBurst: 2})
+	assert.Equal(t, float32(5), limiter.QPS())
+	assert.True(t, limiter.TryAccept())
+	assert.True(t, limiter.TryAccept())
+	assert.False(t, limiter.TryAccept(), "the burst is used up")
+}
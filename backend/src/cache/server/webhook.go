@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WebhookConfig holds the settings of the webhooks and the collaborators they share across
+// admissions.
+type WebhookConfig struct {
+	// Mutation holds the settings of the webhooks, which Webhook.Reconfigure replaces while
+	// serving.
+	Mutation MutationConfig
+	// Keys are the keys of the annotations and labels of the pods. The zero value means the keys
+	// under DefaultAnnotationPrefix.
+	Keys AnnotationKeys
+	// AdmissionLimiter limits the admissions handled at once and per namespace. Nil does not
+	// limit them.
+	AdmissionLimiter *AdmissionLimiter
+	// LookupCircuitBreaker suspends the cache lookups after repeated store failures. Nil never
+	// suspends them.
+	LookupCircuitBreaker *storage.CircuitBreaker
+	// LookupCoalescer coalesces the concurrent lookups of a cache key and remembers misses. Nil
+	// coalesces the concurrent lookups without remembering misses.
+	LookupCoalescer *LookupCoalescer
+	// ImageDigests folds the digests of the images into the cache keys of the templates. Nil
+	// keeps the keys of the templates.
+	ImageDigests *ImageDigestResolver
+	// TFXExecutions restores the executions of the TFX pods served from cache. TFX pods are not
+	// looked up when nil.
+	TFXExecutions *TFXExecutionRestorer
+	// AuditLog records the decisions on the admissions. Nil records nothing.
+	AuditLog *AuditLog
+	// Decisions keeps the recent decisions on the admissions for DecisionsHandler. Nil keeps
+	// nothing.
+	Decisions *DecisionRecorder
+	// Metrics records what the webhooks do. Nil records nothing.
+	Metrics MutationMetrics
+}
+
+// Webhook serves the mutating webhook looking the pods up in the cache, the validating webhook
+// flagging the cache fields it did not issue and the webhook marking the templates of workflows.
+// Webhooks share no state, so that several can serve side by side.
+type Webhook struct {
+	// config holds the current MutationConfig.
+	config               atomic.Value
+	keys                 AnnotationKeys
+	templateKeys         *templateKeyer
+	admissionLimiter     *AdmissionLimiter
+	lookupCircuitBreaker *storage.CircuitBreaker
+	lookupCoalescer      *LookupCoalescer
+	tfxExecutions        *TFXExecutionRestorer
+	auditLog             *AuditLog
+	decisions            *DecisionRecorder
+	metrics              MutationMetrics
+}
+
+// factory function for the webhooks of the settings and collaborators of the config
+func NewWebhook(config WebhookConfig) *Webhook {
+	webhook := &Webhook{
+		keys:                 config.Keys.orDefault(),
+		templateKeys:         newTemplateKeyer(config.ImageDigests),
+		admissionLimiter:     config.AdmissionLimiter,
+		lookupCircuitBreaker: config.LookupCircuitBreaker,
+		lookupCoalescer:      config.LookupCoalescer,
+		tfxExecutions:        config.TFXExecutions,
+		auditLog:             config.AuditLog,
+		decisions:            config.Decisions,
+		metrics:              config.Metrics,
+	}
+	// The disabled limiter, breaker and coalescer export their metrics to a registry of their own.
+	if webhook.admissionLimiter == nil {
+		webhook.admissionLimiter = NewAdmissionLimiter(AdmissionLimiterConfig{}, util.NewRealTime(), prometheus.NewRegistry())
+	}
+	if webhook.lookupCircuitBreaker == nil {
+		webhook.lookupCircuitBreaker = NewLookupCircuitBreaker(0, 0, util.NewRealTime(), prometheus.NewRegistry())
+	}
+	if webhook.lookupCoalescer == nil {
+		webhook.lookupCoalescer = NewLookupCoalescer(0, util.NewRealTime(), prometheus.NewRegistry())
+	}
+	if webhook.metrics == nil {
+		webhook.metrics = noopMutationMetrics{}
+	}
+	webhook.config.Store(config.Mutation)
+	return webhook
+}
+
+// Reconfigure replaces the settings of the webhooks. It is safe to call while admissions are
+// handled, which keep the settings they started with. The remembered cache keys of templates are
+// forgotten, in case the settings affect the keys.
+func (wh *Webhook) Reconfigure(config MutationConfig) {
+	wh.config.Store(config)
+	wh.templateKeys.memo.clear()
+}
+
+func (wh *Webhook) mutationConfig() MutationConfig {
+	config, _ := wh.config.Load().(MutationConfig)
+	return config
+}
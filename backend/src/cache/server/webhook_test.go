@@ -0,0 +1,45 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooksServeSideBySide(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	filter, err := NewNamespaceFilter("", fakeAdmissionRequest.Namespace)
+	require.Nil(t, err)
+	filtering := NewWebhook(WebhookConfig{Mutation: MutationConfig{Namespaces: filter}})
+	serving := NewWebhook(WebhookConfig{})
+
+	patches, err := filtering.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	assert.Nil(t, patches, "the pod is not looked up")
+	patches, err = serving.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(patches), "the settings of another webhook do not apply")
+
+	filtering.Reconfigure(MutationConfig{})
+	patches, err = filtering.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(patches), "the pod is looked up once reconfigured")
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CachePredictionHit marks the templates whose pods are predicted to be served from cache,
+	// CachePredictionMiss those predicted to run. The pods of CachePredictionUnpredictable
+	// templates, whose cache key depends on values only known at run time, are not marked.
+	CachePredictionHit           string = "hit"
+	CachePredictionMiss          string = "miss"
+	CachePredictionUnpredictable string = "unpredictable"
+
+	// WorkflowMarkingOutcomeMarked is the outcome of the workflows whose container templates were
+	// predicted, WorkflowMarkingOutcomeSkipped of those admitted without lookup and
+	// WorkflowMarkingOutcomeError of those that could not be read.
+	WorkflowMarkingOutcomeMarked  string = "marked"
+	WorkflowMarkingOutcomeSkipped string = "skipped"
+	WorkflowMarkingOutcomeError   string = "error"
+
+	// PredictionCheckCorrectHit and the other PredictionCheck results compare the prediction of a
+	// template with the lookup of its pod. PredictionCheckKeyMismatch is the result of the pods
+	// whose cache key differs from the predicted one, whose prediction does not apply.
+	PredictionCheckCorrectHit  string = "correct_hit"
+	PredictionCheckCorrectMiss string = "correct_miss"
+	PredictionCheckFalseHit    string = "false_hit"
+	PredictionCheckFalseMiss   string = "false_miss"
+	PredictionCheckKeyMismatch string = "key_mismatch"
+
+	// workflowLookupConcurrency bounds the lookups of the templates of a workflow in flight at once.
+	workflowLookupConcurrency = 8
+)
+
+var workflowResource = metav1.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+
+// templatePrediction is the prediction of a container template and the cache key it was made for.
+type templatePrediction struct {
+	prediction string
+	key        string
+}
+
+// MarkWorkflowCachedNodes predicts, on the creation of a workflow, which of its cache-enabled
+// container templates have their pods served from cache, and annotates them with the prediction
+// and the cache key it was made for. The marking is read-only: pods are still served from cache
+// by MutatePodIfCached alone, which checks the prediction of the pods of marked templates against
+// its lookup.
+func (wh *Webhook) MarkWorkflowCachedNodes(ctx context.Context, req *v1beta1.AdmissionRequest, clientMgr ClientManagerInterface) ([]patchOperation, error) {
+	config := wh.mutationConfig()
+	if req.Resource != workflowResource || req.Operation != v1beta1.Create || isKubeNamespace(req.Namespace) ||
+		!config.Namespaces.Admits(req.Namespace) {
+		wh.metrics.WorkflowMarked(WorkflowMarkingOutcomeSkipped)
+		return nil, nil
+	}
+	workflow := wfv1.Workflow{}
+	if err := json.Unmarshal(req.Object.Raw, &workflow); err != nil {
+		wh.metrics.WorkflowMarked(WorkflowMarkingOutcomeError)
+		return nil, fmt.Errorf("could not deserialize workflow object: %v", err)
+	}
+	// The breaker is left to the lookups of the pods, which run uncached while it is open.
+	if wh.lookupCircuitBreaker.State() != storage.CircuitClosed {
+		wh.metrics.WorkflowMarked(WorkflowMarkingOutcomeSkipped)
+		return nil, nil
+	}
+
+	deadline := config.AdmissionDeadline
+	if deadline <= 0 {
+		deadline = DefaultAdmissionDeadline
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+	ctx = logging.ContextWithFields(ctx, logrus.Fields{
+		"workflow":             workflowName(&workflow),
+		logging.FieldNamespace: req.Namespace,
+	})
+	store := crossClusterLookups(wh.lookupCoalescer.coalesced(clientMgr.CacheStore(), req.Namespace), config, wh.metrics)
+
+	predictions := make([]templatePrediction, len(workflow.Spec.Templates))
+	slots := make(chan struct{}, workflowLookupConcurrency)
+	var wg sync.WaitGroup
+	for i := range workflow.Spec.Templates {
+		template := &workflow.Spec.Templates[i]
+		if !wh.keys.isCacheEnabledTemplate(template) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			predictions[i] = wh.predictTemplate(ctx, store, &workflow, template, req.Namespace, config)
+		}(i)
+	}
+	wg.Wait()
+
+	var patches []patchOperation
+	for i, prediction := range predictions {
+		if prediction.prediction == "" {
+			continue
+		}
+		wh.metrics.WorkflowTemplatePredicted(prediction.prediction)
+		metadata := workflow.Spec.Templates[i].Metadata
+		annotations := make(map[string]string, len(metadata.Annotations)+2)
+		for key, value := range metadata.Annotations {
+			annotations[key] = value
+		}
+		// Predictions copied along with the workflow do not hold for it.
+		delete(annotations, wh.keys.CachePredictionKey)
+		delete(annotations, wh.keys.PredictedCacheKey)
+		if prediction.prediction != CachePredictionUnpredictable {
+			annotations[wh.keys.CachePredictionKey] = prediction.prediction
+			annotations[wh.keys.PredictedCacheKey] = prediction.key
+		}
+		if len(annotations) == len(metadata.Annotations) && prediction.prediction == CachePredictionUnpredictable {
+			continue
+		}
+		patches = append(patches, patchOperation{
+			Op:    OperationTypeAdd,
+			Path:  fmt.Sprintf("/spec/templates/%d/metadata", i),
+			Value: wfv1.Metadata{Annotations: annotations, Labels: metadata.Labels},
+		})
+	}
+	wh.metrics.WorkflowMarked(WorkflowMarkingOutcomeMarked)
+	return patches, nil
+}
+
+// isCacheEnabledTemplate reports whether the pods of the template would be looked up by
+// MutatePodIfCached. TFX templates are left out, their keys depend on the labels of their pods.
+func (k AnnotationKeys) isCacheEnabledTemplate(template *wfv1.Template) bool {
+	if template.Container == nil || template.Metadata.Labels[k.CacheEnabledLabelKey] != KFPCacheEnabledLabelValue {
+		return false
+	}
+	return !isTFXPod(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{*template.Container}}})
+}
+
+// predictTemplate looks the cache key of the template up as MutatePodIfCached looks up the one of
+// its pods. The key is predictable when Argo writes the template to the pods unchanged, so
+// templates with inputs or expressions substituted at run time are unpredictable, and so are those
+// whose lookup failed.
+func (wh *Webhook) predictTemplate(ctx context.Context, store storage.ExecutionCacheStoreInterface, workflow *wfv1.Workflow, template *wfv1.Template, namespace string, config MutationConfig) templatePrediction {
+	unpredictable := templatePrediction{prediction: CachePredictionUnpredictable}
+	if len(template.Inputs.Parameters) > 0 || len(template.Inputs.Artifacts) > 0 {
+		return unpredictable
+	}
+	// Argo writes the template to the pod annotation as marshaled by this same type.
+	serialized, err := json.Marshal(template)
+	if err != nil || strings.Contains(string(serialized), "{{") {
+		return unpredictable
+	}
+	keyVersion := wh.keys.cacheKeyVersion(template.Metadata.Annotations, config.KeyVersion)
+	key, err := wh.templateKeys.cacheKey(ctx, keyVersion, config.IgnoredFields, string(serialized))
+	if err != nil {
+		return unpredictable
+	}
+	maxCacheStaleness := wh.keys.podMaxCacheStaleness(template.Metadata.Annotations)
+	filter := storage.ExecutionCacheFilter{
+		EnforceOwner: config.EnforceOwner,
+		Owner:        wh.keys.getPodOwner(templatePod(workflow, template), namespace),
+		KeyVersion:   keyVersion,
+	}
+	_, err = getExecutionCacheBeforeDeadline(ctx, store, key, maxCacheStaleness, filter)
+	switch {
+	case err == nil:
+		return templatePrediction{prediction: CachePredictionHit, key: key}
+	case util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND):
+		return templatePrediction{prediction: CachePredictionMiss, key: key}
+	default:
+		logging.WithContext(logger, ctx).WithField(logging.FieldCacheKey, key).Debugf("Could not predict template %s: %v", template.Name, err)
+		return unpredictable
+	}
+}
+
+// templatePod returns the fields of the pods of the template that getPodOwner reads.
+func templatePod(workflow *wfv1.Workflow, template *wfv1.Template) *corev1.Pod {
+	serviceAccountName := template.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = workflow.Spec.ServiceAccountName
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: template.Metadata.Labels},
+		Spec:       corev1.PodSpec{ServiceAccountName: serviceAccountName},
+	}
+}
+
+// workflowName is the name of the workflow, or its generate name while under admission of its
+// creation.
+func workflowName(workflow *wfv1.Workflow) string {
+	if workflow.ObjectMeta.Name != "" {
+		return workflow.ObjectMeta.Name
+	}
+	return workflow.ObjectMeta.GenerateName
+}
+
+// checkWorkflowPrediction records how the prediction the pod's template was marked with compares
+// with the outcome of the lookup of the pod's cache key.
+func (wh *Webhook) checkWorkflowPrediction(annotations map[string]string, key string, outcome string) {
+	prediction, marked := annotations[wh.keys.CachePredictionKey]
+	if !marked || (outcome != AdmissionOutcomeHit && outcome != AdmissionOutcomeMiss) {
+		return
+	}
+	switch {
+	case annotations[wh.keys.PredictedCacheKey] != key:
+		wh.metrics.PredictionChecked(PredictionCheckKeyMismatch)
+	case prediction == CachePredictionHit && outcome == AdmissionOutcomeHit:
+		wh.metrics.PredictionChecked(PredictionCheckCorrectHit)
+	case prediction == CachePredictionHit:
+		wh.metrics.PredictionChecked(PredictionCheckFalseHit)
+	case outcome == AdmissionOutcomeMiss:
+		wh.metrics.PredictionChecked(PredictionCheckCorrectMiss)
+	default:
+		wh.metrics.PredictionChecked(PredictionCheckFalseMiss)
+	}
+}
+
+// WorkflowMarkingHandler serves the webhook marking the templates of workflows with predictions,
+// see MarkWorkflowCachedNodes. Workflows are always admitted, unmarked when the marking fails.
+func (wh *Webhook) WorkflowMarkingHandler(clientMgr ClientManagerInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := wh.readAdmissionBody(w, r)
+		if err != nil {
+			logger.Errorf("Error handling workflow marking request: %v", err)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		var review v1beta1.AdmissionReview
+		if _, _, err := universalDeserializer.Decode(body, nil, &review); err != nil || review.Request == nil {
+			uid, _ := peekRequest(body)
+			w.Write(warningResponse(uid, "Malformed admission review request"))
+			return
+		}
+		var patchBytes []byte
+		patches, err := wh.MarkWorkflowCachedNodes(r.Context(), review.Request, clientMgr)
+		if err != nil {
+			logger.Warnf("Admitting workflow unmarked: %v", err)
+		} else if len(patches) > 0 {
+			if patchBytes, err = json.Marshal(patches); err != nil {
+				logger.Warnf("Admitting workflow unmarked, could not marshal JSON patch: %v", err)
+				patchBytes = nil
+			}
+		}
+		if _, err := w.Write(allowedResponse(review.Request.UID, patchBytes)); err != nil {
+			logger.Errorf("Could not write response: %v", err)
+		}
+	})
+}
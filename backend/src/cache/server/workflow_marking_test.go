@@ -0,0 +1,265 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// containerTemplate returns a cache enabled container template running the image.
+func containerTemplate(name string, image string) wfv1.Template {
+	return wfv1.Template{
+		Name:      name,
+		Container: &corev1.Container{Name: "main", Image: image, Command: []string{"echo", "Hello"}},
+		Metadata: wfv1.Metadata{
+			Labels: map[string]string{podKeys.CacheEnabledLabelKey: KFPCacheEnabledLabelValue},
+		},
+	}
+}
+
+// templateKey returns the cache key of the pods of the template.
+func templateKey(t *testing.T, template wfv1.Template) string {
+	serialized, err := json.Marshal(template)
+	require.Nil(t, err)
+	key, err := generateCacheKeyFromTemplate(string(serialized))
+	require.Nil(t, err)
+	return key
+}
+
+// seedTemplateEntry stores an entry of the cache key of the template.
+func seedTemplateEntry(t *testing.T, clientManager *FakeClientManager, template wfv1.Template) {
+	_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+		ExecutionCacheKey: templateKey(t, template),
+		ExecutionOutput:   testExecutionOutput,
+		ExecutionTemplate: `{"container":{"image":"python:3.7"}}`,
+		MaxCacheStaleness: -1,
+	})
+	require.Nil(t, err)
+}
+
+// workflowRequest returns the request of the creation of the workflow.
+func workflowRequest(t *testing.T, workflow *wfv1.Workflow) *v1beta1.AdmissionRequest {
+	raw, err := json.Marshal(workflow)
+	require.Nil(t, err)
+	return &v1beta1.AdmissionRequest{
+		UID:       "workflow-12345",
+		Resource:  workflowResource,
+		Namespace: "default",
+		Operation: v1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// markedMetadata returns the metadata the patches set on the templates, by path.
+func markedMetadata(patches []patchOperation) map[string]wfv1.Metadata {
+	metadata := map[string]wfv1.Metadata{}
+	for _, patch := range patches {
+		metadata[patch.Path] = patch.Value.(wfv1.Metadata)
+	}
+	return metadata
+}
+
+func TestMarkWorkflowCachedNodes(t *testing.T) {
+	metrics := NewPrometheusMutationMetrics(prometheus.NewRegistry(), 10).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+
+	cached := containerTemplate("cached", "python:3.7")
+	cached.Metadata.Annotations = map[string]string{"owner": "team-a"}
+	seedTemplateEntry(t, clientManager, cached)
+	uncached := containerTemplate("uncached", "python:3.8")
+	parameterized := containerTemplate("parameterized", "python:3.7")
+	parameterized.Inputs.Parameters = []wfv1.Parameter{{Name: "message"}}
+	substituted := containerTemplate("substituted", "{{workflow.parameters.image}}")
+	copied := containerTemplate("copied", "{{workflow.parameters.image}}")
+	copied.Metadata.Annotations = map[string]string{
+		podKeys.CachePredictionKey: CachePredictionHit,
+		podKeys.PredictedCacheKey:  "stale-key",
+		"owner":                    "team-a",
+	}
+	disabled := containerTemplate("disabled", "python:3.7")
+	disabled.Metadata.Labels = nil
+	dag := wfv1.Template{Name: "dag", DAG: &wfv1.DAGTemplate{}}
+	workflow := &wfv1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "pipeline-"},
+		Spec: wfv1.WorkflowSpec{
+			Templates: []wfv1.Template{dag, cached, uncached, parameterized, substituted, copied, disabled},
+		},
+	}
+
+	patches, err := webhook.MarkWorkflowCachedNodes(context.Background(), workflowRequest(t, workflow), clientManager)
+
+	require.Nil(t, err)
+	metadata := markedMetadata(patches)
+	require.Equal(t, 3, len(metadata), "patches: %v", patches)
+	assert.Equal(t, OperationTypeAdd, patches[0].Op)
+	assert.Equal(t, map[string]string{
+		"owner":                    "team-a",
+		podKeys.CachePredictionKey: CachePredictionHit,
+		podKeys.PredictedCacheKey:  templateKey(t, cached),
+	}, metadata["/spec/templates/1/metadata"].Annotations)
+	assert.Equal(t, cached.Metadata.Labels, metadata["/spec/templates/1/metadata"].Labels, "the labels are kept")
+	assert.Equal(t, map[string]string{
+		podKeys.CachePredictionKey: CachePredictionMiss,
+		podKeys.PredictedCacheKey:  templateKey(t, uncached),
+	}, metadata["/spec/templates/2/metadata"].Annotations)
+	assert.Equal(t, map[string]string{"owner": "team-a"}, metadata["/spec/templates/5/metadata"].Annotations, "the copied prediction is removed")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.markedWorkflows.WithLabelValues(WorkflowMarkingOutcomeMarked)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictedTemplates.WithLabelValues(CachePredictionHit)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictedTemplates.WithLabelValues(CachePredictionMiss)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.predictedTemplates.WithLabelValues(CachePredictionUnpredictable)))
+}
+
+func TestMarkWorkflowCachedNodesSkipsOtherAdmissions(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	workflow := &wfv1.Workflow{Spec: wfv1.WorkflowSpec{Templates: []wfv1.Template{containerTemplate("step", "python:3.7")}}}
+
+	update := workflowRequest(t, workflow)
+	update.Operation = v1beta1.Update
+	patches, err := NewWebhook(WebhookConfig{}).MarkWorkflowCachedNodes(context.Background(), update, clientManager)
+	assert.Nil(t, err)
+	assert.Nil(t, patches)
+
+	patches, err = NewWebhook(WebhookConfig{}).MarkWorkflowCachedNodes(context.Background(), &fakeAdmissionRequest, clientManager)
+	assert.Nil(t, err)
+	assert.Nil(t, patches, "pods are not workflows")
+
+	invalid := workflowRequest(t, workflow)
+	invalid.Object.Raw = []byte(`{"spec":`)
+	_, err = NewWebhook(WebhookConfig{}).MarkWorkflowCachedNodes(context.Background(), invalid, clientManager)
+	assert.Contains(t, err.Error(), "could not deserialize workflow object")
+}
+
+// markedTemplatePod returns the pod Argo creates for the template of the workflow once marked.
+func markedTemplatePod(t *testing.T, template wfv1.Template, patches []patchOperation) *corev1.Pod {
+	for _, patch := range patches {
+		template.Metadata = patch.Value.(wfv1.Metadata)
+	}
+	serialized, err := json.Marshal(template)
+	require.Nil(t, err)
+	annotations := map[string]string{
+		ArgoWorkflowNodeName: template.Name,
+		ArgoWorkflowTemplate: string(serialized),
+	}
+	for key, value := range template.Metadata.Annotations {
+		annotations[key] = value
+	}
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: template.Name, Annotations: annotations, Labels: template.Metadata.Labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{*template.Container}},
+	}
+}
+
+func TestMutatePodIfCachedChecksWorkflowPredictions(t *testing.T) {
+	metrics := NewPrometheusMutationMetrics(prometheus.NewRegistry(), 10).(*prometheusMutationMetrics)
+	webhook := NewWebhook(WebhookConfig{Metrics: metrics})
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	cached := containerTemplate("cached", "python:3.7")
+	seedTemplateEntry(t, clientManager, cached)
+	uncached := containerTemplate("uncached", "python:3.8")
+	workflow := &wfv1.Workflow{Spec: wfv1.WorkflowSpec{Templates: []wfv1.Template{cached}}}
+	cachedPatches, err := webhook.MarkWorkflowCachedNodes(context.Background(), workflowRequest(t, workflow), clientManager)
+	require.Nil(t, err)
+	workflow.Spec.Templates = []wfv1.Template{uncached}
+	uncachedPatches, err := webhook.MarkWorkflowCachedNodes(context.Background(), workflowRequest(t, workflow), clientManager)
+	require.Nil(t, err)
+
+	// The pods of the marked templates are looked up under the predicted keys.
+	patches, err := webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(markedTemplatePod(t, cached, cachedPatches)), clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, OperationTypeReplace, patches[0].Op, "the pod is served from cache")
+	_, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(markedTemplatePod(t, uncached, uncachedPatches)), clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckCorrectHit)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckCorrectMiss)))
+
+	// The entry of the uncached template was recorded after the marking.
+	seedTemplateEntry(t, clientManager, uncached)
+	_, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(markedTemplatePod(t, uncached, uncachedPatches)), clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckFalseMiss)))
+
+	// Argo wrote another template to the pod than the one predicted.
+	changed := markedTemplatePod(t, cached, cachedPatches)
+	changed.ObjectMeta.Annotations[ArgoWorkflowTemplate] = `{"container":{"image":"python:3.9"}}`
+	_, err = webhook.MutatePodIfCached(context.Background(), GetFakeRequestFromPod(changed), clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckKeyMismatch)))
+
+	// Pods of unmarked templates are not checked.
+	_, err = webhook.MutatePodIfCached(context.Background(), &fakeAdmissionRequest, clientManager)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckCorrectMiss)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.predictionChecks.WithLabelValues(PredictionCheckFalseHit)))
+}
+
+func TestWorkflowMarkingHandler(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	workflow := &wfv1.Workflow{Spec: wfv1.WorkflowSpec{Templates: []wfv1.Template{containerTemplate("step", "python:3.7")}}}
+	body, err := json.Marshal(v1beta1.AdmissionReview{Request: workflowRequest(t, workflow)})
+	require.Nil(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/mutate-workflow", bytes.NewReader(body))
+	request.Header.Set(ContentType, JsonContentType)
+	recorder := httptest.NewRecorder()
+	NewWebhook(WebhookConfig{}).WorkflowMarkingHandler(clientManager).ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var review v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+	assert.True(t, review.Response.Allowed)
+	assert.Equal(t, workflowRequest(t, workflow).UID, review.Response.UID)
+	var patches []map[string]interface{}
+	require.Nil(t, json.Unmarshal(review.Response.Patch, &patches))
+	require.Equal(t, 1, len(patches))
+	assert.Equal(t, "/spec/templates/0/metadata", patches[0]["path"])
+
+	// Workflows that cannot be read are admitted unmarked.
+	invalid := workflowRequest(t, workflow)
+	invalid.Object.Raw = []byte(`{"spec":{"templates":"step"}}`)
+	body, err = json.Marshal(v1beta1.AdmissionReview{Request: invalid})
+	require.Nil(t, err)
+	request = httptest.NewRequest(http.MethodPost, "/mutate-workflow", bytes.NewReader(body))
+	request.Header.Set(ContentType, JsonContentType)
+	recorder = httptest.NewRecorder()
+	NewWebhook(WebhookConfig{}).WorkflowMarkingHandler(clientManager).ServeHTTP(recorder, request)
+	var unmarked v1beta1.AdmissionReview
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &unmarked))
+	assert.True(t, unmarked.Response.Allowed)
+	assert.Empty(t, unmarked.Response.Patch)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ArgoWorkflowLabelKey string = "workflows.argoproj.io/workflow"
+
+	// PodSkipReasonWorkflowDeleted is a pod without outputs annotation whose workflow was deleted,
+	// so that its outputs are unknown.
+	PodSkipReasonWorkflowDeleted string = "workflow_deleted"
+	// PodSkipReasonNoOutputs is a pod without outputs annotation whose node is missing from
+	// its workflow.
+	PodSkipReasonNoOutputs string = "no_outputs"
+)
+
+// resolveWorkflowOutputs returns the outputs of the pod's node in its workflow, serialized like the
+// outputs annotation, for the pods completed without it: when Argo offloads the node statuses, or
+// the pod was garbage collected before its wait container annotated it. A non-empty skip reason
+// tells the outputs will never be known, an error that they may be once retried.
+func resolveWorkflowOutputs(argoClient client.ArgoClientInterface, pod *corev1.Pod) (outputs string, skipReason string, err error) {
+	workflowName := pod.ObjectMeta.Labels[ArgoWorkflowLabelKey]
+	if workflowName == "" {
+		return "", PodSkipReasonNoOutputs, nil
+	}
+	workflow, err := argoClient.Workflow(pod.ObjectMeta.Namespace).Get(workflowName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", PodSkipReasonWorkflowDeleted, nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get workflow %s: %v", workflowName, err)
+	}
+	nodes := workflow.Status.Nodes
+	if workflow.Status.IsOffloadNodeStatus() {
+		nodes, err = argoClient.OffloadedNodes(string(workflow.ObjectMeta.UID), workflow.Status.OffloadNodeStatusVersion)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	node, found := findPodNode(nodes, pod)
+	if !found {
+		return "", PodSkipReasonNoOutputs, nil
+	}
+	if node.Outputs == nil {
+		return "", "", nil
+	}
+	serialized, err := json.Marshal(node.Outputs)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to serialize the outputs of node %s: %v", node.Name, err)
+	}
+	return string(serialized), "", nil
+}
+
+// findPodNode returns the pod node named by the node-name annotation of the pod, or else whose ID
+// is the pod's name, as Argo names the pods of a workflow after their nodes.
+func findPodNode(nodes wfv1.Nodes, pod *corev1.Pod) (wfv1.NodeStatus, bool) {
+	nodeName := pod.ObjectMeta.Annotations[ArgoWorkflowNodeName]
+	for _, node := range nodes {
+		if node.Type == wfv1.NodeTypePod && nodeName != "" && node.Name == nodeName {
+			return node, true
+		}
+	}
+	if node, found := nodes[pod.ObjectMeta.Name]; found && node.Type == wfv1.NodeTypePod {
+		return node, true
+	}
+	return wfv1.NodeStatus{}, false
+}
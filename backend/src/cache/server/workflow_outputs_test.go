@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	argofake "github.com/argoproj/argo/pkg/client/clientset/versioned/fake"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// workflowOutputs are the outputs of a KFP step, as Argo serializes them.
+const workflowOutputs string = `{"parameters":[{"name":"message","value":"Hello"}],"artifacts":[{"name":"data","s3":{
+	"endpoint":"minio-service.kubeflow:9000","bucket":"mlpipeline","insecure":true,
+	"accessKeySecret":{"name":"mlpipeline-minio-artifact","key":"accesskey"},
+	"secretKeySecret":{"name":"mlpipeline-minio-artifact","key":"secretkey"},
+	"key":"artifacts/hello-world/step/data.tgz"}}]}`
+
+// workflowClientManager serves the pods of a fake clientset and the workflows of a fake Argo
+// clientset.
+type workflowClientManager struct {
+	watchedClientManager
+	argo client.ArgoClientInterface
+}
+
+func (m workflowClientManager) ArgoClient() client.ArgoClientInterface {
+	return m.argo
+}
+
+// offloadedArgoClient serves the offloaded node statuses of a fake Argo clientset.
+type offloadedArgoClient struct {
+	client.ArgoClientInterface
+	nodes wfv1.Nodes
+	err   error
+}
+
+func (c offloadedArgoClient) OffloadedNodes(uid string, version string) (wfv1.Nodes, error) {
+	return c.nodes, c.err
+}
+
+// podNodes returns the nodes of a workflow running the step pod, as reported by Argo.
+func podNodes(t *testing.T) wfv1.Nodes {
+	var outputs wfv1.Outputs
+	require.Nil(t, json.Unmarshal([]byte(workflowOutputs), &outputs))
+	return wfv1.Nodes{
+		"hello-world": {ID: "hello-world", Name: "hello-world", Type: wfv1.NodeTypeSteps, Phase: wfv1.NodeSucceeded},
+		"step":        {ID: "step", Name: "hello-world[0].step", Type: wfv1.NodeTypePod, Phase: wfv1.NodeSucceeded, Outputs: &outputs},
+	}
+}
+
+func workflow(nodes wfv1.Nodes) *wfv1.Workflow {
+	return &wfv1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello-world", Namespace: watchedNamespace, UID: "hello-world-uid"},
+		Status:     wfv1.WorkflowStatus{Phase: wfv1.NodeSucceeded, Nodes: nodes},
+	}
+}
+
+// unannotatedPod returns the succeeded step pod of the hello-world workflow without outputs
+// annotation.
+func unannotatedPod(t *testing.T) *corev1.Pod {
+	pod := podWithStatus(t, succeededStatus, true, "")
+	delete(pod.ObjectMeta.Annotations, ArgoWorkflowOutputs)
+	pod.ObjectMeta.Labels[ArgoWorkflowLabelKey] = "hello-world"
+	pod.ObjectMeta.Annotations[ArgoWorkflowNodeName] = "hello-world[0].step"
+	return pod
+}
+
+func TestResolveWorkflowOutputs(t *testing.T) {
+	offloaded := workflow(nil)
+	offloaded.Status.OffloadNodeStatusVersion = "fnv:1"
+	otherPodName := unannotatedPod(t)
+	otherPodName.ObjectMeta.Name = "renamed"
+	unnamedNode := unannotatedPod(t)
+	delete(unnamedNode.ObjectMeta.Annotations, ArgoWorkflowNodeName)
+	withoutWorkflowLabel := unannotatedPod(t)
+	delete(withoutWorkflowLabel.ObjectMeta.Labels, ArgoWorkflowLabelKey)
+	nodesWithoutOutputs := podNodes(t)
+	stepNode := nodesWithoutOutputs["step"]
+	stepNode.Outputs = nil
+	nodesWithoutOutputs["step"] = stepNode
+	tests := []struct {
+		name               string
+		workflow           *wfv1.Workflow
+		offloadedNodes     wfv1.Nodes
+		offloadedErr       error
+		pod                *corev1.Pod
+		expectedOutputs    string
+		expectedSkipReason string
+		expectedErr        bool
+	}{
+		{name: "node in the workflow status", workflow: workflow(podNodes(t)), pod: unannotatedPod(t),
+			expectedOutputs: workflowOutputs},
+		{name: "node found by name", workflow: workflow(podNodes(t)), pod: otherPodName,
+			expectedOutputs: workflowOutputs},
+		{name: "node found by ID", workflow: workflow(podNodes(t)), pod: unnamedNode,
+			expectedOutputs: workflowOutputs},
+		{name: "node without outputs", workflow: workflow(nodesWithoutOutputs), pod: unannotatedPod(t)},
+		{name: "node absent", workflow: workflow(wfv1.Nodes{"hello-world": podNodes(t)["hello-world"]}), pod: unannotatedPod(t),
+			expectedSkipReason: PodSkipReasonNoOutputs},
+		{name: "offloaded node statuses", workflow: offloaded, offloadedNodes: podNodes(t), pod: unannotatedPod(t),
+			expectedOutputs: workflowOutputs},
+		{name: "offloaded node statuses unavailable", workflow: offloaded, offloadedErr: errors.New("connection refused"), pod: unannotatedPod(t),
+			expectedErr: true},
+		{name: "workflow deleted", pod: unannotatedPod(t),
+			expectedSkipReason: PodSkipReasonWorkflowDeleted},
+		{name: "pod without workflow", workflow: workflow(podNodes(t)), pod: withoutWorkflowLabel,
+			expectedSkipReason: PodSkipReasonNoOutputs},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			argoClientset := argofake.NewSimpleClientset()
+			if test.workflow != nil {
+				argoClientset = argofake.NewSimpleClientset(test.workflow)
+			}
+			argoClient := offloadedArgoClient{client.NewArgoClient(argoClientset, nil), test.offloadedNodes, test.offloadedErr}
+
+			outputs, skipReason, err := resolveWorkflowOutputs(argoClient, test.pod)
+
+			assert.Equal(t, test.expectedErr, err != nil, "error %v", err)
+			assert.Equal(t, test.expectedSkipReason, skipReason)
+			if test.expectedOutputs == "" {
+				assert.Equal(t, "", outputs)
+			} else {
+				assert.JSONEq(t, test.expectedOutputs, outputs)
+			}
+		})
+	}
+}
+
+func TestRecordPodOutputResolvesOutputsFromTheWorkflow(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := unannotatedPod(t)
+	clientset := fake.NewSimpleClientset(pod)
+	argoClient := client.NewArgoClient(argofake.NewSimpleClientset(workflow(podNodes(t))), nil)
+	workflowClientManager := workflowClientManager{watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, argoClient}
+
+	assert.True(t, recordPodOutputNow(pod, workflowClientManager, noopWatcherMetrics{}))
+
+	entry, err := clientManager.CacheStore().GetExecutionCache(context.Background(), "step-key", -1, storage.ExecutionCacheFilter{})
+	require.Nil(t, err)
+	var output map[string]string
+	require.Nil(t, json.Unmarshal([]byte(entry.ExecutionOutput), &output))
+	assert.JSONEq(t, workflowOutputs, output[ArgoWorkflowOutputs])
+}
+
+func TestRecordPodOutputSkipsPodsOfDeletedWorkflows(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	pod := unannotatedPod(t)
+	clientset := fake.NewSimpleClientset(pod)
+	argoClient := client.NewArgoClient(argofake.NewSimpleClientset(), nil)
+	workflowClientManager := workflowClientManager{watchedClientManager{clientManager, client.NewKubernetesCore(clientset)}, argoClient}
+
+	assert.True(t, recordPodOutputNow(pod, workflowClientManager, noopWatcherMetrics{}), "the pod is done with")
+
+	assert.Equal(t, 0, countCacheEntries(t, clientManager, "step-key"))
+}
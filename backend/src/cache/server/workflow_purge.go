@@ -0,0 +1,138 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	argoprojv1alpha1 "github.com/argoproj/argo/pkg/client/clientset/versioned/typed/workflow/v1alpha1"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// WorkflowPurger deletes the cache entries of a run once its workflow is deleted with the
+// PurgeCacheOnDeleteKey annotation set to "true", so that a run found to have produced bad outputs
+// can be dropped from the cache along with its workflow. The entries are selected by the run ID
+// they record, and deleted in batches by the store.
+type WorkflowPurger struct {
+	entries storage.ExecutionCacheAdminStore
+}
+
+// factory function for a purger of the entries of the store
+func NewWorkflowPurger(entries storage.ExecutionCacheAdminStore) *WorkflowPurger {
+	return &WorkflowPurger{entries: entries}
+}
+
+// run purges the entries of the runs of the workflows deleted from the namespaces until ctx is
+// done. Like the pod informers, one informer is run per namespace unless there are too many.
+func (p *WorkflowPurger) run(ctx context.Context, argo client.ArgoClientInterface, namespaces []string, keys AnnotationKeys) {
+	done := make(chan struct{}, len(namespaces))
+	informers := 0
+	runInformer := func(namespace string, purgedNamespaces map[string]bool) {
+		informers++
+		go func() {
+			p.runWorkflowInformer(ctx, argo.Workflow(namespace), purgedNamespaces, keys)
+			done <- struct{}{}
+		}()
+	}
+	if len(namespaces) <= maxNamespaceInformers {
+		for _, namespace := range namespaces {
+			runInformer(namespace, nil)
+		}
+	} else {
+		purgedNamespaces := make(map[string]bool, len(namespaces))
+		for _, namespace := range namespaces {
+			purgedNamespaces[namespace] = true
+		}
+		runInformer(metav1.NamespaceAll, purgedNamespaces)
+	}
+	for ; informers > 0; informers-- {
+		<-done
+	}
+}
+
+// runWorkflowInformer purges the entries of the runs of the workflows of the client as they are
+// deleted, until ctx is done. Only the workflows of KFP runs are followed. purgedNamespaces filters
+// the workflows of an informer over all namespaces, all are purged when nil.
+func (p *WorkflowPurger) runWorkflowInformer(ctx context.Context, workflows argoprojv1alpha1.WorkflowInterface, purgedNamespaces map[string]bool, keys AnnotationKeys) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = RunIDLabelKey
+			return workflows.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = RunIDLabelKey
+			return workflows.Watch(options)
+		},
+	}, &wfv1.Workflow{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			// A workflow deleted while the watch was broken is only known in its last listed
+			// state, which holds the annotation if it was set before.
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			workflow, ok := obj.(*wfv1.Workflow)
+			if !ok {
+				logger.Errorf("Unable to purge the cache entries of the deleted object %T, not a workflow", obj)
+				return
+			}
+			if purgedNamespaces != nil && !purgedNamespaces[workflow.ObjectMeta.Namespace] {
+				return
+			}
+			p.purge(ctx, workflow, keys)
+		},
+	})
+	informer.Run(ctx.Done())
+}
+
+// purge deletes the entries of the run of the workflow if it is annotated to be purged on
+// deletion. Failed deletions are retried, since the workflow is not notified again.
+func (p *WorkflowPurger) purge(ctx context.Context, workflow *wfv1.Workflow, keys AnnotationKeys) {
+	if workflow.ObjectMeta.Annotations[keys.PurgeCacheOnDeleteKey] != "true" {
+		return
+	}
+	runID := workflow.ObjectMeta.Labels[RunIDLabelKey]
+	workflowLogger := logger.WithFields(logrus.Fields{
+		logging.FieldWorkflow:  workflow.ObjectMeta.Name,
+		logging.FieldNamespace: workflow.ObjectMeta.Namespace,
+		logging.FieldRunID:     runID,
+	})
+	if runID == "" {
+		workflowLogger.Warn("Deleted workflow is annotated to purge its cache entries but has no run ID, none are purged")
+		return
+	}
+	var purged int64
+	err := retry.OnError(retry.DefaultBackoff, func(error) bool { return ctx.Err() == nil }, func() error {
+		invalidation, err := p.entries.InvalidateExecutionCaches(ctx, storage.ExecutionCacheSelector{RunID: runID}, false)
+		if invalidation != nil {
+			purged += invalidation.Count
+		}
+		return err
+	})
+	if err != nil {
+		workflowLogger.Errorf("Purged %d cache entries of the deleted workflow before failing: %v", purged, err)
+		return
+	}
+	workflowLogger.Infof("Purged %d cache entries of the deleted workflow", purged)
+}
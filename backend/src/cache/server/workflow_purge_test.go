@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	wfv1 "github.com/argoproj/argo/pkg/apis/workflow/v1alpha1"
+	argofake "github.com/argoproj/argo/pkg/client/clientset/versioned/fake"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// runWorkflow returns the workflow of a KFP run, annotated to purge its entries when purge is set.
+func runWorkflow(name string, runID string, purge bool) *wfv1.Workflow {
+	workflow := &wfv1.Workflow{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   watchedNamespace,
+		Labels:      map[string]string{RunIDLabelKey: runID},
+		Annotations: map[string]string{},
+	}}
+	if purge {
+		workflow.ObjectMeta.Annotations[NewAnnotationKeys(DefaultAnnotationPrefix).PurgeCacheOnDeleteKey] = "true"
+	}
+	return workflow
+}
+
+// hasRunEntries reports whether the store holds the expected number of entries of the run.
+func hasRunEntries(clientManager *FakeClientManager, runID string, expected int) func() bool {
+	return func() bool {
+		var count int
+		err := clientManager.DB().Table("execution_caches").Where("RunID = ?", runID).Count(&count).Error
+		return err == nil && count == expected
+	}
+}
+
+func TestWorkflowPurgerPurgesEntriesOfDeletedAnnotatedWorkflows(t *testing.T) {
+	clientManager := NewFakeClientManagerOrFatal(util.NewFakeTimeForEpoch())
+	defer clientManager.Close()
+	// More entries than the store deletes in a batch.
+	entriesPerRun := map[string]int{"garbage-run": 600, "unannotated-run": 3, "other-run": 2}
+	for runID, count := range entriesPerRun {
+		for i := 0; i < count; i++ {
+			_, err := clientManager.CacheStore().CreateExecutionCache(context.Background(), &model.ExecutionCache{
+				ExecutionCacheKey: fmt.Sprintf("%s-%d", runID, i),
+				ExecutionTemplate: "template",
+				ExecutionOutput:   "outputs",
+				MaxCacheStaleness: -1,
+				RunID:             runID,
+			})
+			require.Nil(t, err)
+		}
+	}
+	clientset := argofake.NewSimpleClientset(
+		runWorkflow("garbage", "garbage-run", true),
+		runWorkflow("empty", "empty-run", true),
+		runWorkflow("unannotated", "unannotated-run", false))
+	// Deletions are only notified once the informer watches the workflows it listed.
+	watching := make(chan struct{})
+	var watchOnce sync.Once
+	clientset.PrependWatchReactor("workflows", func(k8stesting.Action) (bool, watch.Interface, error) {
+		watchOnce.Do(func() { close(watching) })
+		return false, nil, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewWorkflowPurger(storage.NewExecutionCacheStore(clientManager.DB(), clientManager.Time())).run(ctx, client.NewArgoClient(clientset, nil), []string{watchedNamespace}, NewAnnotationKeys(DefaultAnnotationPrefix))
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the workflows are not watched")
+	}
+
+	// Deletions are handled in order, so that the garbage run is purged last.
+	for _, name := range []string{"unannotated", "empty", "garbage"} {
+		require.Nil(t, clientset.ArgoprojV1alpha1().Workflows(watchedNamespace).Delete(name, &metav1.DeleteOptions{}))
+	}
+
+	assert.Eventually(t, hasRunEntries(clientManager, "garbage-run", 0), 5*time.Second, 10*time.Millisecond)
+	assert.Condition(t, hasRunEntries(clientManager, "unannotated-run", 3), "the entries of unannotated workflows are kept")
+	assert.Condition(t, hasRunEntries(clientManager, "other-run", 2), "the entries of other runs are kept")
+}
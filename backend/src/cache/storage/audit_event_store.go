@@ -0,0 +1,50 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+// AuditEventStore appends audit events to the audit_events table.
+type AuditEventStore struct {
+	db *DB
+}
+
+// WriteAuditEvent inserts the event. Events are never updated or deleted by the webhook.
+func (s *AuditEventStore) WriteAuditEvent(event *model.AuditEvent) error {
+	// The ID is assigned by the database.
+	row := *event
+	row.ID = 0
+	if err := s.db.Create(&row).Error; err != nil {
+		return fmt.Errorf("failed to insert audit event: %v", err)
+	}
+	return nil
+}
+
+// Close does nothing, the database is closed by its owner.
+func (s *AuditEventStore) Close() error {
+	return nil
+}
+
+// factory function for an audit event store, creating the audit_events table if it is missing
+func NewAuditEventStore(db *DB) (*AuditEventStore, error) {
+	if err := db.AutoMigrate(&model.AuditEvent{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to create the audit_events table: %v", err)
+	}
+	return &AuditEventStore{db: db}, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditEventStoreAppendsEvents(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store, err := NewAuditEventStore(db)
+	require.Nil(t, err)
+	timestamp := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	event := &model.AuditEvent{
+		Timestamp:         timestamp,
+		RequestID:         "request-1",
+		Namespace:         "ns1",
+		PodName:           "pipeline-abc-123",
+		NodeName:          "pipeline-abc.step",
+		CacheKey:          "key1",
+		Decision:          "hit",
+		CacheEntryID:      7,
+		CacheEnabled:      true,
+		MaxCacheStaleness: "P30D",
+		FailPolicy:        "open",
+	}
+
+	require.Nil(t, store.WriteAuditEvent(event))
+	require.Nil(t, store.WriteAuditEvent(event))
+
+	var events []model.AuditEvent
+	require.Nil(t, db.Order("ID").Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(1), events[0].ID)
+	assert.Equal(t, int64(2), events[1].ID)
+	events[0].ID = 0
+	events[0].Timestamp = events[0].Timestamp.UTC()
+	assert.Equal(t, *event, events[0])
+	assert.Equal(t, int64(0), event.ID, "the written event is left unchanged")
+}
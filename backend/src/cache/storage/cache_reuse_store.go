@@ -0,0 +1,63 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+// CacheReuseStore records the nodes of runs served from cache in the cache_reuses table, so that
+// the nodes of a run served from cache can be listed.
+type CacheReuseStore struct {
+	db *DB
+}
+
+// RecordCacheReuse inserts the reuse unless one of the same run and node exists, e.g. recorded
+// before the watcher restarted. It reports whether the reuse was inserted.
+func (s *CacheReuseStore) RecordCacheReuse(reuse *model.CacheReuse) (bool, error) {
+	var count int64
+	if err := s.db.Model(&model.CacheReuse{}).Where("RunID = ? AND NodeID = ?", reuse.RunID, reuse.NodeID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to look up the cache reuse of node %s of run %s: %v", reuse.NodeID, reuse.RunID, err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+	// The ID is assigned by the database.
+	row := *reuse
+	row.ID = 0
+	if err := s.db.Create(&row).Error; err != nil {
+		return false, fmt.Errorf("failed to insert the cache reuse of node %s of run %s: %v", reuse.NodeID, reuse.RunID, err)
+	}
+	return true, nil
+}
+
+// ListCacheReusesByRun returns the reuses of the nodes of the run, in the order they were recorded.
+func (s *CacheReuseStore) ListCacheReusesByRun(runID string) ([]*model.CacheReuse, error) {
+	var reuses []*model.CacheReuse
+	if err := s.db.Where("RunID = ?", runID).Order("ID").Find(&reuses).Error; err != nil {
+		return nil, fmt.Errorf("failed to list the cache reuses of run %s: %v", runID, err)
+	}
+	return reuses, nil
+}
+
+// factory function for a cache reuse store, creating the cache_reuses table if it is missing
+func NewCacheReuseStore(db *DB) (*CacheReuseStore, error) {
+	if err := db.AutoMigrate(&model.CacheReuse{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to create the cache_reuses table: %v", err)
+	}
+	return &CacheReuseStore{db: db}, nil
+}
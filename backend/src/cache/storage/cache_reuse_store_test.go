@@ -0,0 +1,54 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheReuseStore(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store, err := NewCacheReuseStore(db)
+	require.Nil(t, err)
+	reuse := func(runID string, nodeID string) *model.CacheReuse {
+		return &model.CacheReuse{RunID: runID, NodeID: nodeID, NodeName: nodeID + ".step", Namespace: "ns1", CacheEntryID: 7, SourceRunID: "run-0", ReusedAtInSec: 100}
+	}
+
+	for _, r := range []*model.CacheReuse{reuse("run-1", "node-b"), reuse("run-2", "node-a"), reuse("run-1", "node-a")} {
+		created, err := store.RecordCacheReuse(r)
+		require.Nil(t, err)
+		assert.True(t, created)
+	}
+	created, err := store.RecordCacheReuse(reuse("run-1", "node-b"))
+	require.Nil(t, err)
+	assert.False(t, created, "reuses are recorded once per run and node")
+
+	reuses, err := store.ListCacheReusesByRun("run-1")
+	require.Nil(t, err)
+	require.Len(t, reuses, 2)
+	assert.Equal(t, []string{"node-b", "node-a"}, []string{reuses[0].NodeID, reuses[1].NodeID})
+	want := reuse("run-1", "node-b")
+	want.ID = reuses[0].ID
+	assert.Equal(t, want, reuses[0])
+
+	reuses, err = store.ListCacheReusesByRun("run-3")
+	require.Nil(t, err)
+	assert.Empty(t, reuses)
+}
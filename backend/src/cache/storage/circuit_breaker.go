@@ -0,0 +1,214 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	CircuitClosed   string = "closed"
+	CircuitHalfOpen string = "half_open"
+	CircuitOpen     string = "open"
+
+	DefaultRedisCircuitFailureThreshold int           = 5
+	DefaultRedisCircuitCoolDown         time.Duration = 30 * time.Second
+)
+
+// circuitStateValues are the values of the circuit state gauges.
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+// CircuitBreakerConfig holds the settings of a circuit breaker.
+type CircuitBreakerConfig struct {
+	// Name is what the circuit is around in the logs, e.g. "Redis" or "the cache store".
+	Name string
+	// Skipped tells what happens to the calls while the circuit is open, in the logs.
+	Skipped string
+	// A FailureThreshold of 0 or less disables the breaker.
+	FailureThreshold int
+	CoolDown         time.Duration
+	// Probe checks in the background whether the calls would succeed again. Without probe the first
+	// call allowed after the cool-down is the probe.
+	Probe func() error
+	// StateGauge names the gauge exporting the state: 0 closed, 1 half-open, 2 open.
+	StateGauge prometheus.GaugeOpts
+}
+
+// CircuitBreaker stops calls after FailureThreshold consecutive failures, so that callers do not
+// each wait for a dependency that is down. While open, calls are skipped. Once the cool-down has
+// passed the circuit is half-open and a single probe runs, which closes the circuit when it
+// succeeds and opens it for another cool-down when it fails. The probe is either the configured
+// Probe, run in the background while calls keep being skipped, or the first call allowed.
+type CircuitBreaker struct {
+	config     CircuitBreakerConfig
+	time       util.TimeInterface
+	stateGauge prometheus.Gauge
+
+	mutex               sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool
+}
+
+// Allow reports whether the call should be made. Without configured probe, each allowed call must
+// be followed by a call of RecordSuccess, RecordFailure or Release.
+func (b *CircuitBreaker) Allow() bool {
+	if b.config.FailureThreshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if b.time.Now().Sub(b.openedAt) < b.config.CoolDown {
+			return false
+		}
+		logger.Infof("Probing %s after a cool-down of %v", b.config.Name, b.config.CoolDown)
+		b.setState(CircuitHalfOpen)
+		if b.config.Probe != nil {
+			go b.runProbe()
+		}
+	}
+	if b.config.Probe != nil || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch {
+	case b.state == CircuitClosed:
+		b.consecutiveFailures = 0
+	case b.state == CircuitHalfOpen && b.config.Probe == nil:
+		b.close()
+	}
+}
+
+// RecordFailure counts a failed call. Failures of calls that started before the circuit opened do
+// not reset the cool-down.
+func (b *CircuitBreaker) RecordFailure() {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch {
+	case b.state == CircuitClosed:
+		b.consecutiveFailures++
+		if b.consecutiveFailures >= b.config.FailureThreshold {
+			logger.Warnf("Calls to %s failed %d times in a row, %s for %v", b.config.Name, b.consecutiveFailures, b.config.Skipped, b.config.CoolDown)
+			b.open()
+		}
+	case b.state == CircuitHalfOpen && b.config.Probe == nil:
+		logger.Warnf("The probe of %s failed, %s for another %v", b.config.Name, b.config.Skipped, b.config.CoolDown)
+		b.open()
+	}
+}
+
+// Release ends an allowed call that tells nothing about the dependency, e.g. one the caller gave
+// up on, so that a new probe can be made.
+func (b *CircuitBreaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) runProbe() {
+	err := b.config.Probe()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err != nil {
+		logger.Warnf("The probe of %s failed, %s for another %v: %v", b.config.Name, b.config.Skipped, b.config.CoolDown, err)
+		b.open()
+		return
+	}
+	b.close()
+}
+
+// open must be called with the mutex held.
+func (b *CircuitBreaker) open() {
+	b.probing = false
+	b.openedAt = b.time.Now()
+	b.setState(CircuitOpen)
+}
+
+// close must be called with the mutex held.
+func (b *CircuitBreaker) close() {
+	logger.Infof("The probe of %s succeeded, closing the circuit", b.config.Name)
+	b.probing = false
+	b.consecutiveFailures = 0
+	b.setState(CircuitClosed)
+}
+
+// setState must be called with the mutex held.
+func (b *CircuitBreaker) setState(state string) {
+	b.state = state
+	b.stateGauge.Set(circuitStateValues[state])
+}
+
+// State returns the state of the circuit, one of CircuitClosed, CircuitHalfOpen or CircuitOpen.
+func (b *CircuitBreaker) State() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// factory function for circuit breaker
+func NewCircuitBreaker(config CircuitBreakerConfig, time util.TimeInterface, registerer prometheus.Registerer) *CircuitBreaker {
+	stateGauge := prometheus.NewGauge(config.StateGauge)
+	registered, err := metrics.RegisterOrReuse(registerer, stateGauge)
+	if err != nil {
+		logger.Errorf("Failed to register the circuit metrics of %s: %v", config.Name, err)
+	}
+	stateGauge = registered.(prometheus.Gauge)
+	b := &CircuitBreaker{
+		config:     config,
+		time:       time,
+		stateGauge: stateGauge,
+	}
+	b.setState(CircuitClosed)
+	return b
+}
+
+// factory function for the circuit breaker around Redis. probe checks whether Redis is reachable
+// again.
+func newRedisCircuitBreaker(failureThreshold int, coolDown time.Duration, probe func() error, time util.TimeInterface, registerer prometheus.Registerer) *CircuitBreaker {
+	return NewCircuitBreaker(CircuitBreakerConfig{
+		Name:             "Redis",
+		Skipped:          "using the backing store only",
+		FailureThreshold: failureThreshold,
+		CoolDown:         coolDown,
+		Probe:            probe,
+		StateGauge: prometheus.GaugeOpts{
+			Name: "cache_store_redis_circuit_state",
+			Help: "State of the circuit around the Redis cache layer: 0 closed, 1 half-open, 2 open. Redis is skipped unless closed.",
+		},
+	}, time, registerer)
+}
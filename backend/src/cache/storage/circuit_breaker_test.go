@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProbe hands each probe result to the breaker once the test sends it.
+type fakeProbe struct {
+	calls   chan struct{}
+	results chan error
+}
+
+func newFakeProbe() *fakeProbe {
+	return &fakeProbe{calls: make(chan struct{}, 1), results: make(chan error)}
+}
+
+func (p *fakeProbe) probe() error {
+	p.calls <- struct{}{}
+	return <-p.results
+}
+
+func newTestRedisCircuitBreaker(clock *fixedTime, probe *fakeProbe) *CircuitBreaker {
+	return newRedisCircuitBreaker(3, time.Minute, probe.probe, clock, prometheus.NewRegistry())
+}
+
+// newTestCircuitBreaker returns a breaker whose first call after the cool-down is the probe.
+func newTestCircuitBreaker(clock *fixedTime) *CircuitBreaker {
+	return NewCircuitBreaker(CircuitBreakerConfig{
+		Name:             "the test store",
+		Skipped:          "skipping it",
+		FailureThreshold: 3,
+		CoolDown:         time.Minute,
+		StateGauge:       prometheus.GaugeOpts{Name: "test_circuit_state"},
+	}, clock, prometheus.NewRegistry())
+}
+
+func waitForCircuitState(t *testing.T, b *CircuitBreaker, state string) {
+	require.Eventually(t, func() bool { return b.State() == state }, time.Second, time.Millisecond)
+}
+
+func TestRedisCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newTestRedisCircuitBreaker(&fixedTime{now: time.Unix(1000, 0)}, newFakeProbe())
+
+	b.RecordFailure()
+	b.RecordFailure()
+	b.RecordSuccess()
+	b.RecordFailure()
+	b.RecordFailure()
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.True(t, b.Allow())
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.stateGauge))
+
+	b.RecordFailure()
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, b.Allow())
+	assert.Equal(t, float64(2), testutil.ToFloat64(b.stateGauge))
+}
+
+func TestRedisCircuitBreakerClosesAfterSuccessfulProbe(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	probe := newFakeProbe()
+	b := newTestRedisCircuitBreaker(clock, probe)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+
+	clock.now = time.Unix(1059, 0)
+	assert.False(t, b.Allow())
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.Len(t, probe.calls, 0)
+
+	clock.now = time.Unix(1060, 0)
+	assert.False(t, b.Allow())
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.stateGauge))
+	<-probe.calls
+	// A single probe runs at a time, and calls keep skipping Redis meanwhile.
+	assert.False(t, b.Allow())
+	assert.Len(t, probe.calls, 0)
+
+	probe.results <- nil
+	waitForCircuitState(t, b, CircuitClosed)
+	assert.True(t, b.Allow())
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.stateGauge))
+	b.RecordFailure()
+	b.RecordFailure()
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestRedisCircuitBreakerReopensAfterFailedProbe(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	probe := newFakeProbe()
+	b := newTestRedisCircuitBreaker(clock, probe)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+	clock.now = time.Unix(1060, 0)
+	assert.False(t, b.Allow())
+	<-probe.calls
+
+	probe.results <- errors.New("connection refused")
+	waitForCircuitState(t, b, CircuitOpen)
+	// The cool-down restarts with the failed probe.
+	clock.now = time.Unix(1119, 0)
+	assert.False(t, b.Allow())
+	assert.Equal(t, CircuitOpen, b.State())
+	clock.now = time.Unix(1120, 0)
+	assert.False(t, b.Allow())
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	<-probe.calls
+	probe.results <- nil
+	waitForCircuitState(t, b, CircuitClosed)
+}
+
+func TestRedisCircuitBreakerIgnoresFailuresWhileNotClosed(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	probe := newFakeProbe()
+	b := newTestRedisCircuitBreaker(clock, probe)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+	clock.now = time.Unix(1060, 0)
+	b.Allow()
+	<-probe.calls
+
+	// Failures of calls that started before the circuit opened must not reset the cool-down.
+	b.RecordFailure()
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	probe.results <- nil
+	waitForCircuitState(t, b, CircuitClosed)
+}
+
+func TestRedisCircuitBreakerDisabled(t *testing.T) {
+	b := newRedisCircuitBreaker(0, time.Minute, newFakeProbe().probe, &fixedTime{now: time.Unix(1000, 0)}, prometheus.NewRegistry())
+	for i := 0; i < 100; i++ {
+		b.RecordFailure()
+	}
+	assert.True(t, b.Allow())
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestCircuitBreakerWithoutProbeClosesAfterSuccessfulCall(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	b := newTestCircuitBreaker(clock)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+
+	clock.now = time.Unix(1059, 0)
+	assert.False(t, b.Allow())
+	assert.Equal(t, CircuitOpen, b.State())
+
+	clock.now = time.Unix(1060, 0)
+	assert.True(t, b.Allow(), "the first call after the cool-down is the probe")
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.stateGauge))
+	assert.False(t, b.Allow(), "only one probe is in flight")
+
+	b.RecordSuccess()
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.stateGauge))
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+}
+
+func TestCircuitBreakerWithoutProbeReopensAfterFailedCall(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	b := newTestCircuitBreaker(clock)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+
+	clock.now = time.Unix(1060, 0)
+	require.True(t, b.Allow())
+	b.RecordFailure()
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// The cool-down starts over at the failed probe.
+	clock.now = time.Unix(1119, 0)
+	assert.False(t, b.Allow())
+	clock.now = time.Unix(1120, 0)
+	assert.True(t, b.Allow())
+}
+
+func TestCircuitBreakerWithoutProbeRetriesReleasedProbe(t *testing.T) {
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	b := newTestCircuitBreaker(clock)
+	for i := 0; i < 3; i++ {
+		b.RecordFailure()
+	}
+	clock.now = time.Unix(1060, 0)
+	require.True(t, b.Allow())
+
+	b.Release()
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.True(t, b.Allow())
+}
@@ -29,8 +29,13 @@ func NewFakeDb() (*DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not create the GORM database: %v", err)
 	}
+	// Every connection to ":memory:" opens a database of its own, so all queries share one.
+	db.DB().SetMaxOpenConns(1)
 	// Create tables
-	db.AutoMigrate(&model.ExecutionCache{})
+	db.AutoMigrate(&model.ExecutionCache{}, &model.ExecutionCachePartition{})
+	if err := AddEvictionIndexes(NewDB(db), "execution_caches"); err != nil {
+		return nil, err
+	}
 
 	return NewDB(db), nil
 }
@@ -0,0 +1,43 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewMemoryDB returns a SQLite database held in memory with the execution cache table, for the
+// memory cache store. Its entries are lost when it is closed.
+func NewMemoryDB() (*DB, error) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("could not open the in-memory database: %v", err)
+	}
+	// Every connection to ":memory:" opens a database of its own, so all queries share one.
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&model.ExecutionCache{}).Error; err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create the execution cache table: %v", err)
+	}
+	if err := AddEvictionIndexes(NewDB(db), "execution_caches"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewDB(db), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDBSharesEntriesBetweenConcurrentCalls(t *testing.T) {
+	db, err := NewMemoryDB()
+	require.Nil(t, err)
+	defer db.Close()
+	store := NewExecutionCacheStore(db, util.NewRealTime())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			_, err := store.CreateExecutionCache(context.Background(), createExecutionCache(key, "testOutput"))
+			assert.Nil(t, err)
+			_, err = store.GetExecutionCache(context.Background(), key, -1, ExecutionCacheFilter{})
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	var count int
+	require.Nil(t, db.Table("execution_caches").Count(&count).Error)
+	assert.Equal(t, 10, count)
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+)
+
+const (
+	// DefaultListMaxPageSize bounds the entries listed at once by the database stores.
+	DefaultListMaxPageSize int = 1000
+	// invalidateBatchSize bounds the entries deleted by each statement of a bulk invalidation, so
+	// that invalidating many entries does not hold long locks on the table.
+	invalidateBatchSize int = 500
+
+	// serviceAccountOwnerPrefix starts the owner of the entries produced by pods without profile,
+	// followed by the namespace and name of their service account.
+	serviceAccountOwnerPrefix string = "system:serviceaccount:"
+)
+
+// ExecutionCacheAdminStore manages the entries of a store one at a time, e.g. for operators
+// without access to the database. IDs are those handed out by CreateExecutionCache.
+type ExecutionCacheAdminStore interface {
+	// ListExecutionCaches returns the entries whose cache key starts with keyPrefix and that pass
+	// the filter in ID order, a page of at most pageSize at a time, together with the token of the
+	// next page. The next page token is empty after the last page.
+	ListExecutionCaches(ctx context.Context, keyPrefix string, filter ExecutionCacheFilter, pageSize int, pageToken string) ([]*model.ExecutionCache, string, error)
+	// CreateExecutionCache creates the entry as of now, e.g. when imported from another store.
+	CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error)
+	// GetExecutionCacheByID returns the entry of the ID, stale or not.
+	GetExecutionCacheByID(ctx context.Context, executionCacheID string) (*model.ExecutionCache, error)
+	// DeleteExecutionCacheByID deletes the entry of the ID, failing with CUSTOM_CODE_NOT_FOUND when
+	// there is none.
+	DeleteExecutionCacheByID(ctx context.Context, executionCacheID string) error
+	// DeleteExecutionCachesByKey deletes all the entries of the cache key and returns their number.
+	DeleteExecutionCachesByKey(ctx context.Context, executionCacheKey string) (int64, error)
+	// InvalidateExecutionCaches deletes the entries of the selector in batches, or only counts them
+	// when dryRun is set.
+	InvalidateExecutionCaches(ctx context.Context, selector ExecutionCacheSelector, dryRun bool) (*ExecutionCacheInvalidation, error)
+}
+
+// ExecutionCacheSelector selects the entries invalidated in bulk, e.g. those produced by a buggy
+// component. The entries must match every field set.
+type ExecutionCacheSelector struct {
+	PipelineName      string
+	PipelineVersionID string
+	RunID             string
+	KeyPrefix         string
+	// OlderThanInSec selects the entries created before that time.
+	OlderThanInSec int64
+	// All must be set to select every entry with an otherwise empty selector, so that a forgotten
+	// field does not wipe the cache.
+	All bool
+}
+
+// validate rejects the selectors of every entry that do not say so explicitly.
+func (s ExecutionCacheSelector) validate() error {
+	if !s.All && s.PipelineName == "" && s.PipelineVersionID == "" && s.RunID == "" && s.KeyPrefix == "" && s.OlderThanInSec == 0 {
+		return util.NewInvalidInputError("The selector selects every entry, but does not set all")
+	}
+	return nil
+}
+
+// apply adds the selector conditions to a query over an execution cache table.
+func (s ExecutionCacheSelector) apply(db *gorm.DB) *gorm.DB {
+	if s.PipelineName != "" {
+		db = db.Where("PipelineName = ?", s.PipelineName)
+	}
+	if s.PipelineVersionID != "" {
+		db = db.Where("PipelineVersionID = ?", s.PipelineVersionID)
+	}
+	if s.RunID != "" {
+		db = db.Where("RunID = ?", s.RunID)
+	}
+	if s.KeyPrefix != "" {
+		db = db.Where("ExecutionCacheKey LIKE ? ESCAPE '!'", escapeLike(s.KeyPrefix)+"%")
+	}
+	if s.OlderThanInSec != 0 {
+		db = db.Where("StartedAtInSec < ?", s.OlderThanInSec)
+	}
+	return db
+}
+
+// ExecutionCacheInvalidation is the outcome of a bulk invalidation.
+type ExecutionCacheInvalidation struct {
+	// Count is the number of entries deleted, or that would be on a dry run.
+	Count int64
+	// CacheKeys are the distinct cache keys of the deleted entries, also when the invalidation
+	// failed midway, for the copies of the entries held elsewhere to be dropped. Dry runs leave them
+	// empty.
+	CacheKeys []string
+}
+
+// invalidateExecutionCacheTable deletes the entries of the selector from the table in batches of
+// invalidateBatchSize, or counts them on a dry run, and adds them to the invalidation.
+func invalidateExecutionCacheTable(db *DB, table string, selector ExecutionCacheSelector, dryRun bool, invalidation *ExecutionCacheInvalidation) error {
+	if dryRun {
+		var count int64
+		if d := selector.apply(db.Table(table)).Count(&count); d.Error != nil {
+			return fmt.Errorf("Failed to count the execution caches of %s: %v", table, d.Error)
+		}
+		invalidation.Count += count
+		return nil
+	}
+	keys := make(map[string]bool)
+	for _, key := range invalidation.CacheKeys {
+		keys[key] = true
+	}
+	for {
+		r, err := selector.apply(db.Table(table).Select("ID, ExecutionCacheKey")).Order("ID").Limit(invalidateBatchSize).Rows()
+		if err != nil {
+			return fmt.Errorf("Failed to select the execution caches of %s: %v", table, err)
+		}
+		var ids []int64
+		for r.Next() {
+			var id int64
+			var key string
+			if err := r.Scan(&id, &key); err != nil {
+				r.Close()
+				return fmt.Errorf("Failed to select the execution caches of %s: %v", table, err)
+			}
+			ids = append(ids, id)
+			if !keys[key] {
+				keys[key] = true
+				invalidation.CacheKeys = append(invalidation.CacheKeys, key)
+			}
+		}
+		r.Close()
+		if len(ids) == 0 {
+			return nil
+		}
+		d := db.Table(table).Delete(&model.ExecutionCache{}, "ID IN (?)", ids)
+		if d.Error != nil {
+			return fmt.Errorf("Failed to delete the execution caches of %s: %v", table, d.Error)
+		}
+		invalidation.Count += d.RowsAffected
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with '!', which both MySQL and SQLite accept
+// as ESCAPE character.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// listPageSize returns the page size to list with, DefaultListMaxPageSize when unset or larger.
+func listPageSize(pageSize int) int {
+	if pageSize <= 0 || pageSize > DefaultListMaxPageSize {
+		return DefaultListMaxPageSize
+	}
+	return pageSize
+}
+
+// parseIDPageToken returns the ID the page token resumes after, 0 for the first page. The tokens of
+// the database stores are the ID of the last entry of the previous page.
+func parseIDPageToken(pageToken string) (int64, error) {
+	if pageToken == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(pageToken, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, util.NewInvalidInputError("Invalid page token %q", pageToken)
+	}
+	return id, nil
+}
+
+// parseExecutionCacheID parses the ID of an entry of a database store.
+func parseExecutionCacheID(executionCacheID string) (int64, error) {
+	id, err := strconv.ParseInt(executionCacheID, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, util.NewInvalidInputError("Invalid execution cache ID %q", executionCacheID)
+	}
+	return id, nil
+}
+
+// idPage cuts the entries, listed in ID order up to one past the page size, to a page and returns
+// the token of the next page.
+func idPage(executionCaches []*model.ExecutionCache, pageSize int) ([]*model.ExecutionCache, string) {
+	if len(executionCaches) <= pageSize {
+		return executionCaches, ""
+	}
+	executionCaches = executionCaches[:pageSize]
+	return executionCaches, strconv.FormatInt(executionCaches[pageSize-1].ID, 10)
+}
+
+// scanAllExecutionCacheRows reads the rows of executionCacheColumns, stale or not.
+func scanAllExecutionCacheRows(rows *sql.Rows) ([]*model.ExecutionCache, error) {
+	var executionCaches []*model.ExecutionCache
+	for rows.Next() {
+		var executionCache model.ExecutionCache
+		err := rows.Scan(
+			&executionCache.ID,
+			&executionCache.ExecutionCacheKey,
+			&executionCache.ExecutionTemplate,
+			&executionCache.ExecutionOutput,
+			&executionCache.MaxCacheStaleness,
+			&executionCache.StartedAtInSec,
+			&executionCache.EndedAtInSec,
+			&executionCache.Owner,
+			&executionCache.ExecutionDurationInSec,
+			&executionCache.PipelineName,
+			&executionCache.RunID,
+			&executionCache.Namespace,
+			&executionCache.LastUsedAtInSec,
+			&executionCache.ClusterID,
+			&executionCache.KeyVersion,
+			&executionCache.PipelineVersionID,
+			&executionCache.ExpiresAtInSec)
+		if err != nil {
+			return nil, err
+		}
+		executionCaches = append(executionCaches, &executionCache)
+	}
+	return executionCaches, rows.Err()
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+// adminStores returns constructors of the database stores, each over a fresh database. Their clock
+// starts at endOfJanuary, so that the partitioned store spreads the entries over two partitions.
+func adminStores(t *testing.T) map[string]func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore) {
+	newDB := func() *DB {
+		db := NewFakeDbOrFatal()
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	return map[string]func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore){
+		"unpartitioned": func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore) {
+			store := NewExecutionCacheStore(newDB(), util.NewFakeTime(endOfJanuary))
+			return store, store
+		},
+		"partitioned": func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore) {
+			store := NewPartitionedExecutionCacheStore(newDB(), util.NewFakeTime(endOfJanuary), 3)
+			return store, store
+		},
+	}
+}
+
+// createAdminEntries creates entries of the keys one second apart, owned by owner.
+func createAdminEntries(t *testing.T, store ExecutionCacheStoreInterface, owner string, keys ...string) []*model.ExecutionCache {
+	var created []*model.ExecutionCache
+	for _, key := range keys {
+		executionCache := createExecutionCache(key, "output")
+		executionCache.Owner = owner
+		entry, err := store.CreateExecutionCache(context.Background(), executionCache)
+		require.Nil(t, err)
+		created = append(created, entry)
+	}
+	return created
+}
+
+func entryKeys(executionCaches []*model.ExecutionCache) []string {
+	var keys []string
+	for _, executionCache := range executionCaches {
+		keys = append(keys, executionCache.ExecutionCacheKey)
+	}
+	return keys
+}
+
+func TestListExecutionCachesPagesInIDOrder(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			createAdminEntries(t, store, "", "aa1", "ab1", "aa2", "b1", "aa3")
+
+			page, token, err := admin.ListExecutionCaches(context.Background(), "aa", ExecutionCacheFilter{}, 2, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"aa1", "aa2"}, entryKeys(page))
+			require.NotEmpty(t, token)
+			page, token, err = admin.ListExecutionCaches(context.Background(), "aa", ExecutionCacheFilter{}, 2, token)
+			require.Nil(t, err)
+			assert.Equal(t, []string{"aa3"}, entryKeys(page))
+			assert.Empty(t, token)
+
+			all, token, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"aa1", "ab1", "aa2", "b1", "aa3"}, entryKeys(all))
+			assert.Empty(t, token)
+		})
+	}
+}
+
+func TestListExecutionCachesFilters(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			created := createAdminEntries(t, store, "team-a", "profile")
+			created = append(created, createAdminEntries(t, store, "system:serviceaccount:team-a:pipeline-runner", "service-account")...)
+			created = append(created, createAdminEntries(t, store, "system:serviceaccount:team-ab:default", "other-namespace")...)
+			created = append(created, createAdminEntries(t, store, "", "a_%")...)
+
+			page, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{Namespace: "team-a"}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"profile", "service-account"}, entryKeys(page))
+
+			page, _, err = admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{
+				CreatedAfterInSec:  created[1].StartedAtInSec,
+				CreatedBeforeInSec: created[3].StartedAtInSec,
+			}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"service-account", "other-namespace"}, entryKeys(page))
+
+			page, _, err = admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{PipelineName: "pipeline"}, 0, "")
+			require.Nil(t, err)
+			assert.Empty(t, page)
+			pipelineEntry := createExecutionCache("pipeline-entry", "output")
+			pipelineEntry.PipelineName = "pipeline"
+			_, err = store.CreateExecutionCache(context.Background(), pipelineEntry)
+			require.Nil(t, err)
+			page, _, err = admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{PipelineName: "pipeline"}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"pipeline-entry"}, entryKeys(page))
+
+			page, _, err = admin.ListExecutionCaches(context.Background(), "a_%", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"a_%"}, entryKeys(page), "wildcards in the prefix are matched literally")
+		})
+	}
+}
+
+func TestListExecutionCachesRejectsInvalidPageTokens(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, admin := newStore()
+			for _, token := range []string{"not a token", "-1"} {
+				_, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, token)
+				assert.True(t, util.IsUserErrorCodeMatch(err, codes.InvalidArgument), "token %q: %v", token, err)
+			}
+		})
+	}
+}
+
+func TestGetAndDeleteExecutionCacheByID(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			created := createAdminEntries(t, store, "", "key", "key", "other")
+			id := strconv.FormatInt(created[0].ID, 10)
+
+			executionCache, err := admin.GetExecutionCacheByID(context.Background(), id)
+			require.Nil(t, err)
+			assert.Equal(t, created[0], executionCache)
+
+			require.Nil(t, admin.DeleteExecutionCacheByID(context.Background(), id))
+			_, err = admin.GetExecutionCacheByID(context.Background(), id)
+			assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+			err = admin.DeleteExecutionCacheByID(context.Background(), id)
+			assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+			_, err = admin.GetExecutionCacheByID(context.Background(), "300001000000000001")
+			assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND), "the partition does not exist")
+
+			deleted, err := admin.DeleteExecutionCachesByKey(context.Background(), "key")
+			require.Nil(t, err)
+			assert.Equal(t, int64(1), deleted)
+			page, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"other"}, entryKeys(page))
+		})
+	}
+}
+
+func TestWriteThroughAdminStoreDeletesRedisCopies(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+	admin := store.AdminStore(backing)
+	byID, err := store.CreateExecutionCache(context.Background(), createExecutionCache("byID", "output"))
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("byKey", "output"))
+	require.Nil(t, err)
+
+	require.Nil(t, admin.DeleteExecutionCacheByID(context.Background(), strconv.FormatInt(byID.ID, 10)))
+	deleted, err := admin.DeleteExecutionCachesByKey(context.Background(), "byKey")
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	assert.False(t, server.Exists("cache:byID"))
+	assert.False(t, server.Exists("cache:byKey"))
+	_, err = store.GetExecutionCache(context.Background(), "byKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+// createProvenanceEntries creates entries one second apart of the cache keys, pipelines and runs.
+func createProvenanceEntries(t *testing.T, store ExecutionCacheStoreInterface, entries ...model.ExecutionCache) []*model.ExecutionCache {
+	var created []*model.ExecutionCache
+	for i := range entries {
+		entries[i].ExecutionOutput = "output"
+		entries[i].MaxCacheStaleness = -1
+		entry, err := store.CreateExecutionCache(context.Background(), &entries[i])
+		require.Nil(t, err)
+		created = append(created, entry)
+	}
+	return created
+}
+
+func TestInvalidateExecutionCachesSelectors(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			seed := func() (ExecutionCacheAdminStore, []*model.ExecutionCache) {
+				store, admin := newStore()
+				return admin, createProvenanceEntries(t, store,
+					model.ExecutionCache{ExecutionCacheKey: "aa1", PipelineName: "train", PipelineVersionID: "train-v1", RunID: "run-1"},
+					model.ExecutionCache{ExecutionCacheKey: "ab1", PipelineName: "train", PipelineVersionID: "train-v2", RunID: "run-2"},
+					model.ExecutionCache{ExecutionCacheKey: "aa2", PipelineName: "serve", RunID: "run-3"},
+					model.ExecutionCache{ExecutionCacheKey: "b1"})
+			}
+			tests := []struct {
+				name      string
+				selector  func(created []*model.ExecutionCache) ExecutionCacheSelector
+				remaining []string
+			}{
+				{
+					name: "pipeline name",
+					selector: func([]*model.ExecutionCache) ExecutionCacheSelector {
+						return ExecutionCacheSelector{PipelineName: "train"}
+					},
+					remaining: []string{"aa2", "b1"},
+				},
+				{
+					name: "pipeline version ID",
+					selector: func([]*model.ExecutionCache) ExecutionCacheSelector {
+						return ExecutionCacheSelector{PipelineVersionID: "train-v1"}
+					},
+					remaining: []string{"ab1", "aa2", "b1"},
+				},
+				{
+					name:      "run ID",
+					selector:  func([]*model.ExecutionCache) ExecutionCacheSelector { return ExecutionCacheSelector{RunID: "run-2"} },
+					remaining: []string{"aa1", "aa2", "b1"},
+				},
+				{
+					name:      "key prefix",
+					selector:  func([]*model.ExecutionCache) ExecutionCacheSelector { return ExecutionCacheSelector{KeyPrefix: "aa"} },
+					remaining: []string{"ab1", "b1"},
+				},
+				{
+					name: "older than",
+					selector: func(created []*model.ExecutionCache) ExecutionCacheSelector {
+						return ExecutionCacheSelector{OlderThanInSec: created[2].StartedAtInSec}
+					},
+					remaining: []string{"aa2", "b1"},
+				},
+				{
+					name: "combined",
+					selector: func([]*model.ExecutionCache) ExecutionCacheSelector {
+						return ExecutionCacheSelector{PipelineName: "train", KeyPrefix: "aa"}
+					},
+					remaining: []string{"ab1", "aa2", "b1"},
+				},
+				{
+					name:     "all",
+					selector: func([]*model.ExecutionCache) ExecutionCacheSelector { return ExecutionCacheSelector{All: true} },
+				},
+			}
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					admin, created := seed()
+					selector := test.selector(created)
+
+					counted, err := admin.InvalidateExecutionCaches(context.Background(), selector, true)
+					require.Nil(t, err)
+					assert.Equal(t, int64(len(created)-len(test.remaining)), counted.Count)
+					assert.Empty(t, counted.CacheKeys)
+					all, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+					require.Nil(t, err)
+					assert.Len(t, all, len(created), "a dry run deletes nothing")
+
+					invalidation, err := admin.InvalidateExecutionCaches(context.Background(), selector, false)
+					require.Nil(t, err)
+					assert.Equal(t, counted.Count, invalidation.Count)
+					assert.Len(t, invalidation.CacheKeys, int(invalidation.Count))
+					remaining, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+					require.Nil(t, err)
+					assert.Equal(t, test.remaining, entryKeys(remaining))
+				})
+			}
+		})
+	}
+}
+
+func TestInvalidateExecutionCachesRequiresAllForEmptySelectors(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			createAdminEntries(t, store, "", "key")
+
+			for _, dryRun := range []bool{true, false} {
+				_, err := admin.InvalidateExecutionCaches(context.Background(), ExecutionCacheSelector{}, dryRun)
+				assert.True(t, util.IsUserErrorCodeMatch(err, codes.InvalidArgument), "dry run %v: %v", dryRun, err)
+			}
+			all, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Len(t, all, 1)
+		})
+	}
+}
+
+func TestInvalidateExecutionCachesDeletesInBatches(t *testing.T) {
+	store := NewExecutionCacheStore(NewFakeDbOrFatal(), util.NewFakeTimeForEpoch())
+	defer store.db.Close()
+	for i := 0; i < invalidateBatchSize+1; i++ {
+		_, err := store.CreateExecutionCache(context.Background(), &model.ExecutionCache{ExecutionCacheKey: "key", RunID: "run"})
+		require.Nil(t, err)
+	}
+
+	invalidation, err := store.InvalidateExecutionCaches(context.Background(), ExecutionCacheSelector{RunID: "run"}, false)
+	require.Nil(t, err)
+	assert.Equal(t, int64(invalidateBatchSize+1), invalidation.Count)
+	assert.Equal(t, []string{"key"}, invalidation.CacheKeys)
+}
+
+func TestWriteThroughAdminStoreInvalidatesRedisCopies(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+	admin := store.AdminStore(backing)
+	_, err := store.CreateExecutionCache(context.Background(), &model.ExecutionCache{ExecutionCacheKey: "buggy", PipelineName: "train", MaxCacheStaleness: -1})
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), &model.ExecutionCache{ExecutionCacheKey: "fine", PipelineName: "serve", MaxCacheStaleness: -1})
+	require.Nil(t, err)
+
+	_, err = admin.InvalidateExecutionCaches(context.Background(), ExecutionCacheSelector{PipelineName: "train"}, true)
+	require.Nil(t, err)
+	assert.True(t, server.Exists("cache:buggy"), "a dry run keeps the Redis copies")
+
+	invalidation, err := admin.InvalidateExecutionCaches(context.Background(), ExecutionCacheSelector{PipelineName: "train"}, false)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), invalidation.Count)
+	assert.False(t, server.Exists("cache:buggy"))
+	assert.True(t, server.Exists("cache:fine"))
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+// expiryPurgeBatchSize bounds the entries deleted at once by a purge of the expired entries.
+const expiryPurgeBatchSize int = 500
+
+// ExecutionCacheExpiryStore is implemented by the stores that keep the entries past their
+// time-to-live until they are purged, rather than expire them themselves like Redis. Lookups miss
+// those entries either way.
+type ExecutionCacheExpiryStore interface {
+	// PurgeExpiredExecutionCaches deletes the entries whose time-to-live ended and returns how many
+	// it deleted, also when it failed midway.
+	PurgeExpiredExecutionCaches(ctx context.Context) (int64, error)
+}
+
+func (s *ExecutionCacheStore) PurgeExpiredExecutionCaches(ctx context.Context) (int64, error) {
+	return purgeExpiredRows(ctx, s.db, "execution_caches", s.time.Now().UTC().Unix())
+}
+
+func (s *PartitionedExecutionCacheStore) PurgeExpiredExecutionCaches(ctx context.Context) (int64, error) {
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Find(&partitions); d.Error != nil {
+		return 0, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	nowInSec := s.time.Now().UTC().Unix()
+	var purged int64
+	for _, partition := range partitions {
+		partitionPurged, err := purgeExpiredRows(ctx, s.db, partition.Name, nowInSec)
+		purged += partitionPurged
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purgeExpiredRows deletes the rows of the table whose time-to-live ended by nowInSec, in batches
+// read from the ExpiresAtInSec index, and returns how many it deleted.
+func purgeExpiredRows(ctx context.Context, db *DB, table string, nowInSec int64) (int64, error) {
+	var purged int64
+	for ctx.Err() == nil {
+		var ids []int64
+		d := db.Table(table).Where("ExpiresAtInSec > 0 AND ExpiresAtInSec <= ?", nowInSec).
+			Order("ExpiresAtInSec").Limit(expiryPurgeBatchSize).Pluck("ID", &ids)
+		if d.Error != nil {
+			return purged, fmt.Errorf("Failed to list the expired execution caches of %s: %v", table, d.Error)
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+		d = db.Table(table).Delete(&model.ExecutionCache{}, "ID IN (?)", ids)
+		if d.Error != nil {
+			return purged, fmt.Errorf("Failed to purge the expired execution caches of %s: %v", table, d.Error)
+		}
+		purged += d.RowsAffected
+		if len(ids) < expiryPurgeBatchSize {
+			return purged, nil
+		}
+	}
+	return purged, ctx.Err()
+}
+
+// addExpiryIndex indexes the end of the time-to-live of the entries of the partition, which the
+// expired entries are purged by, unless it is already.
+func (s *PartitionedExecutionCacheStore) addExpiryIndex(partitionName string) error {
+	indexName := "idx_" + partitionName + "_expires_at"
+	if s.db.Dialect().HasIndex(partitionName, indexName) {
+		return nil
+	}
+	if d := s.db.Table(partitionName).AddIndex(indexName, "ExpiresAtInSec"); d.Error != nil {
+		return fmt.Errorf("Failed to index execution cache partition %s: %v", partitionName, d.Error)
+	}
+	return nil
+}
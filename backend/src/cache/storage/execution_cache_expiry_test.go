@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiryStores returns the stores purging their expired entries, on the clock.
+func expiryStores(t *testing.T, clock util.TimeInterface) map[string]func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore, ExecutionCacheExpiryStore) {
+	newDB := func() *DB {
+		db := NewFakeDbOrFatal()
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	return map[string]func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore, ExecutionCacheExpiryStore){
+		"unpartitioned": func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore, ExecutionCacheExpiryStore) {
+			store := NewExecutionCacheStore(newDB(), clock)
+			return store, store, store
+		},
+		"partitioned": func() (ExecutionCacheStoreInterface, ExecutionCacheAdminStore, ExecutionCacheExpiryStore) {
+			store := NewPartitionedExecutionCacheStore(newDB(), clock, 3)
+			return store, store, store
+		},
+	}
+}
+
+func TestExpiredEntriesAreMissedUntilPurged(t *testing.T) {
+	clock := &fixedTime{now: endOfJanuary}
+	nowInSec := endOfJanuary.Unix()
+	for name, newStores := range expiryStores(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			clock.now = endOfJanuary
+			store, admin, expiry := newStores()
+			// More expired entries than purged in a batch.
+			var expired []string
+			for i := 0; i <= expiryPurgeBatchSize; i++ {
+				executionCache := createExecutionCache(fmt.Sprintf("expired-%d", i), "output")
+				executionCache.ExpiresAtInSec = nowInSec + 10
+				created, err := store.CreateExecutionCache(context.Background(), executionCache)
+				require.Nil(t, err)
+				expired = append(expired, strconv.FormatInt(created.ID, 10))
+			}
+			// Entries of pods not reusing results are reused by the others until they expire.
+			expiring := createExecutionCache("expiring", "output")
+			expiring.MaxCacheStaleness = 0
+			expiring.ExpiresAtInSec = nowInSec + 100
+			_, err := store.CreateExecutionCache(context.Background(), expiring)
+			require.Nil(t, err)
+			_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("lasting", "output"))
+			require.Nil(t, err)
+
+			clock.now = endOfJanuary.Add(50 * time.Second)
+			_, err = store.GetExecutionCache(context.Background(), "expired-0", -1, ExecutionCacheFilter{})
+			assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+			_, err = admin.GetExecutionCacheByID(context.Background(), expired[0])
+			assert.Nil(t, err, "lookups do not delete the expired entries")
+			_, err = store.GetExecutionCache(context.Background(), "expiring", 1000, ExecutionCacheFilter{})
+			assert.Nil(t, err)
+
+			purged, err := expiry.PurgeExpiredExecutionCaches(context.Background())
+			require.Nil(t, err)
+			assert.Equal(t, int64(len(expired)), purged)
+			_, err = admin.GetExecutionCacheByID(context.Background(), expired[len(expired)-1])
+			assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+			_, err = store.GetExecutionCache(context.Background(), "expiring", 1000, ExecutionCacheFilter{})
+			assert.Nil(t, err)
+			_, err = store.GetExecutionCache(context.Background(), "lasting", -1, ExecutionCacheFilter{})
+			assert.Nil(t, err, "entries without time-to-live never expire")
+		})
+	}
+}
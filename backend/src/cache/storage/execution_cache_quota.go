@@ -0,0 +1,328 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+const (
+	// evictBatchSize bounds the entries of each table considered, and deleted, by each round of an
+	// eviction.
+	evictBatchSize int = 500
+
+	// EvictionPolicyLRU evicts the least recently used entries first.
+	EvictionPolicyLRU string = "lru"
+	// EvictionPolicyLFU evicts the least frequently used entries first, and the least recently used
+	// of the entries used as often.
+	EvictionPolicyLFU string = "lfu"
+
+	// AllNamespaces accounts and evicts the entries of every namespace, including those of no
+	// namespace, to bound the whole store. It is not a valid namespace name.
+	AllNamespaces string = "*"
+)
+
+// evictionIndexes are the suffixes of the names, and the columns, of the indexes of the entries of
+// an execution cache table in the order they are evicted in.
+var evictionIndexes = []struct {
+	suffix  string
+	columns []string
+}{
+	{suffix: "namespace_lru", columns: []string{"Namespace", "LastUsedAtInSec", "ID"}},
+	{suffix: "namespace_lfu", columns: []string{"Namespace", "UseCount", "LastUsedAtInSec", "ID"}},
+	{suffix: "lru", columns: []string{"LastUsedAtInSec", "ID"}},
+	{suffix: "lfu", columns: []string{"UseCount", "LastUsedAtInSec", "ID"}},
+}
+
+// AddEvictionIndexes indexes the entries of the execution cache table in the order they are
+// evicted in, so that evictions read the first entries of an index rather than sort all those of
+// the namespace or of the store, unless they are already.
+func AddEvictionIndexes(db *DB, table string) error {
+	for _, index := range evictionIndexes {
+		indexName := "idx_" + table + "_" + index.suffix
+		if db.Dialect().HasIndex(table, indexName) {
+			continue
+		}
+		if d := db.Table(table).AddIndex(indexName, index.columns...); d.Error != nil {
+			return fmt.Errorf("Failed to index execution cache table %s: %v", table, d.Error)
+		}
+	}
+	return nil
+}
+
+// IsValidEvictionPolicy reports whether policy is EvictionPolicyLRU or EvictionPolicyLFU.
+func IsValidEvictionPolicy(policy string) bool {
+	return policy == EvictionPolicyLRU || policy == EvictionPolicyLFU
+}
+
+// ExecutionCacheQuotaStore accounts the entries of a store by namespace, or as a whole with
+// AllNamespaces, and evicts the least recently or least frequently used ones, for the quotas.
+// Entries created before their namespace was recorded belong to no namespace and are only evicted
+// from the whole store.
+type ExecutionCacheQuotaStore interface {
+	// NamespaceUsage returns the entries of the namespace, stale or not, and their output bytes.
+	NamespaceUsage(ctx context.Context, namespace string) (*NamespaceUsage, error)
+	// Evict deletes the entries of the namespace first in the order of the eviction policy, other
+	// than the one of keepID, until at least excess.Entries entries and excess.OutputBytes output
+	// bytes are freed or no other entry is left.
+	Evict(ctx context.Context, namespace string, excess NamespaceUsage, keepID string, policy string) (*ExecutionCacheEviction, error)
+	// MarkExecutionCacheUsed records that the entry of the ID was reused at nowInSec, once more.
+	MarkExecutionCacheUsed(ctx context.Context, executionCacheID string, nowInSec int64) error
+}
+
+// NamespaceUsage is what the entries of a namespace take in a store.
+type NamespaceUsage struct {
+	Entries     int64
+	OutputBytes int64
+}
+
+// ExecutionCacheEviction is the outcome of an eviction.
+type ExecutionCacheEviction struct {
+	// Freed is what the evicted entries took, and FreedNamespaces what those of each namespace took.
+	Freed           NamespaceUsage
+	FreedNamespaces map[string]NamespaceUsage
+	// ExecutionCacheIDs are the IDs of the evicted entries, also when the eviction failed midway,
+	// for the copies of the entries held elsewhere to be dropped.
+	ExecutionCacheIDs []string
+}
+
+// evictionCandidate is an entry that an eviction may delete.
+type evictionCandidate struct {
+	table           string
+	rowID           int64
+	id              int64
+	namespace       string
+	outputBytes     int64
+	lastUsedAtInSec int64
+	useCount        int64
+}
+
+// evictedBefore reports whether the policy evicts candidate a before candidate b.
+func evictedBefore(a evictionCandidate, b evictionCandidate, policy string) bool {
+	if policy == EvictionPolicyLFU && a.useCount != b.useCount {
+		return a.useCount < b.useCount
+	}
+	return a.lastUsedAtInSec < b.lastUsedAtInSec || (a.lastUsedAtInSec == b.lastUsedAtInSec && a.id < b.id)
+}
+
+// namespaceScope selects the entries of the namespace in the table, all of them for AllNamespaces.
+func namespaceScope(db *DB, table string, namespace string) *gorm.DB {
+	scope := db.Table(table)
+	if namespace != AllNamespaces {
+		scope = scope.Where("Namespace = ?", namespace)
+	}
+	return scope
+}
+
+// addNamespaceTableUsage adds what the entries of the namespace take in the table to usage.
+func addNamespaceTableUsage(db *DB, table string, namespace string, usage *NamespaceUsage) error {
+	var entries, outputBytes int64
+	row := namespaceScope(db, table, namespace).Select("COUNT(*), COALESCE(SUM(LENGTH(ExecutionOutput)), 0)").Row()
+	if err := row.Scan(&entries, &outputBytes); err != nil {
+		return fmt.Errorf("Failed to aggregate the execution caches of %s in %s: %v", namespace, table, err)
+	}
+	usage.Entries += entries
+	usage.OutputBytes += outputBytes
+	return nil
+}
+
+// addNamespaceUsage adds usage to the one of the namespace in usages.
+func addNamespaceUsage(usages map[string]NamespaceUsage, namespace string, usage NamespaceUsage) {
+	total := usages[namespace]
+	total.Entries += usage.Entries
+	total.OutputBytes += usage.OutputBytes
+	usages[namespace] = total
+}
+
+// evictEntries evicts from the tables in rounds. Each round merges the first entries of every table
+// in the order of the policy, at most evictBatchSize of each, so that the entries are evicted in
+// order across the tables. encodeID maps the row IDs of a table to the IDs of the store.
+func evictEntries(db *DB, tables []string, encodeID func(table string, rowID int64) int64, namespace string, excess NamespaceUsage, keepID int64, policy string) (*ExecutionCacheEviction, error) {
+	eviction := &ExecutionCacheEviction{FreedNamespaces: make(map[string]NamespaceUsage)}
+	for eviction.Freed.Entries < excess.Entries || eviction.Freed.OutputBytes < excess.OutputBytes {
+		var candidates []evictionCandidate
+		for _, table := range tables {
+			tableCandidates, err := selectEvictionCandidates(db, table, encodeID, namespace, keepID, policy)
+			if err != nil {
+				return eviction, err
+			}
+			candidates = append(candidates, tableCandidates...)
+		}
+		if len(candidates) == 0 {
+			return eviction, nil
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return evictedBefore(candidates[i], candidates[j], policy)
+		})
+		if len(candidates) > evictBatchSize {
+			candidates = candidates[:evictBatchSize]
+		}
+
+		evicted := make(map[string][]int64)
+		var freed NamespaceUsage
+		freedNamespaces := make(map[string]NamespaceUsage)
+		for _, candidate := range candidates {
+			if eviction.Freed.Entries+freed.Entries >= excess.Entries && eviction.Freed.OutputBytes+freed.OutputBytes >= excess.OutputBytes {
+				break
+			}
+			evicted[candidate.table] = append(evicted[candidate.table], candidate.rowID)
+			freed.Entries++
+			freed.OutputBytes += candidate.outputBytes
+			addNamespaceUsage(freedNamespaces, candidate.namespace, NamespaceUsage{Entries: 1, OutputBytes: candidate.outputBytes})
+		}
+		for _, candidate := range candidates {
+			rowIDs := evicted[candidate.table]
+			if rowIDs == nil {
+				continue
+			}
+			delete(evicted, candidate.table)
+			if d := db.Table(candidate.table).Delete(&model.ExecutionCache{}, "ID IN (?)", rowIDs); d.Error != nil {
+				return eviction, fmt.Errorf("Failed to evict the execution caches of %s from %s: %v", namespace, candidate.table, d.Error)
+			}
+			for _, rowID := range rowIDs {
+				eviction.ExecutionCacheIDs = append(eviction.ExecutionCacheIDs, strconv.FormatInt(encodeID(candidate.table, rowID), 10))
+			}
+		}
+		eviction.Freed.Entries += freed.Entries
+		eviction.Freed.OutputBytes += freed.OutputBytes
+		for namespace, freedNamespace := range freedNamespaces {
+			addNamespaceUsage(eviction.FreedNamespaces, namespace, freedNamespace)
+		}
+	}
+	return eviction, nil
+}
+
+// selectEvictionCandidates returns the first entries of the namespace in the table in the order of
+// the policy, at most evictBatchSize of them.
+func selectEvictionCandidates(db *DB, table string, encodeID func(table string, rowID int64) int64, namespace string, keepID int64, policy string) ([]evictionCandidate, error) {
+	scope := namespaceScope(db, table, namespace).Select("ID, Namespace, COALESCE(LENGTH(ExecutionOutput), 0), LastUsedAtInSec, UseCount")
+	if policy == EvictionPolicyLFU {
+		scope = scope.Order("UseCount")
+	}
+	rows, err := scope.Order("LastUsedAtInSec").Order("ID").Limit(evictBatchSize + 1).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select the execution caches of %s in %s: %v", namespace, table, err)
+	}
+	defer rows.Close()
+	var candidates []evictionCandidate
+	for rows.Next() {
+		candidate := evictionCandidate{table: table}
+		if err := rows.Scan(&candidate.rowID, &candidate.namespace, &candidate.outputBytes, &candidate.lastUsedAtInSec, &candidate.useCount); err != nil {
+			return nil, fmt.Errorf("Failed to select the execution caches of %s in %s: %v", namespace, table, err)
+		}
+		candidate.id = encodeID(table, candidate.rowID)
+		if candidate.id == keepID || len(candidates) == evictBatchSize {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+func unpartitionedID(table string, rowID int64) int64 {
+	return rowID
+}
+
+func (s *ExecutionCacheStore) NamespaceUsage(ctx context.Context, namespace string) (*NamespaceUsage, error) {
+	usage := &NamespaceUsage{}
+	if err := addNamespaceTableUsage(s.db, "execution_caches", namespace, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (s *ExecutionCacheStore) Evict(ctx context.Context, namespace string, excess NamespaceUsage, keepID string, policy string) (*ExecutionCacheEviction, error) {
+	id, err := parseExecutionCacheID(keepID)
+	if err != nil {
+		return nil, err
+	}
+	return evictEntries(s.db, []string{"execution_caches"}, unpartitionedID, namespace, excess, id, policy)
+}
+
+func (s *ExecutionCacheStore) MarkExecutionCacheUsed(ctx context.Context, executionCacheID string, nowInSec int64) error {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return err
+	}
+	if d := s.db.Table("execution_caches").Where("ID = ?", id).Updates(entryUse(nowInSec)); d.Error != nil {
+		return fmt.Errorf("Failed to mark execution cache %q as used: %v", executionCacheID, d.Error)
+	}
+	return nil
+}
+
+// entryUse is the update of an entry reused at nowInSec.
+func entryUse(nowInSec int64) map[string]interface{} {
+	return map[string]interface{}{"LastUsedAtInSec": nowInSec, "UseCount": gorm.Expr("UseCount + 1")}
+}
+
+func (s *PartitionedExecutionCacheStore) NamespaceUsage(ctx context.Context, namespace string) (*NamespaceUsage, error) {
+	tables, err := s.partitionNames()
+	if err != nil {
+		return nil, err
+	}
+	usage := &NamespaceUsage{}
+	for _, table := range tables {
+		if err := addNamespaceTableUsage(s.db, table, namespace, usage); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+func (s *PartitionedExecutionCacheStore) Evict(ctx context.Context, namespace string, excess NamespaceUsage, keepID string, policy string) (*ExecutionCacheEviction, error) {
+	id, err := parseExecutionCacheID(keepID)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := s.partitionNames()
+	if err != nil {
+		return nil, err
+	}
+	return evictEntries(s.db, tables, encodePartitionedID, namespace, excess, id, policy)
+}
+
+func (s *PartitionedExecutionCacheStore) MarkExecutionCacheUsed(ctx context.Context, executionCacheID string, nowInSec int64) error {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return err
+	}
+	partitionName, rowID := decodePartitionedID(id)
+	if !s.db.HasTable(partitionName) {
+		return nil
+	}
+	if d := s.db.Table(partitionName).Where("ID = ?", rowID).Updates(entryUse(nowInSec)); d.Error != nil {
+		return fmt.Errorf("Failed to mark execution cache %q as used: %v", executionCacheID, d.Error)
+	}
+	return nil
+}
+
+// partitionNames returns the names of the registered partitions.
+func (s *PartitionedExecutionCacheStore) partitionNames() ([]string, error) {
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Find(&partitions); d.Error != nil {
+		return nil, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	var names []string
+	for _, partition := range partitions {
+		names = append(names, partition.Name)
+	}
+	return names, nil
+}
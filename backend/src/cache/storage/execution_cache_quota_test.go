@@ -0,0 +1,198 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createNamespaceEntries creates entries of the keys one second apart in the namespace.
+func createNamespaceEntries(t *testing.T, store ExecutionCacheStoreInterface, namespace string, keys ...string) []*model.ExecutionCache {
+	var created []*model.ExecutionCache
+	for _, key := range keys {
+		executionCache := createExecutionCache(key, "output")
+		executionCache.Namespace = namespace
+		entry, err := store.CreateExecutionCache(context.Background(), executionCache)
+		require.Nil(t, err)
+		created = append(created, entry)
+	}
+	return created
+}
+
+func entryID(executionCache *model.ExecutionCache) string {
+	return strconv.FormatInt(executionCache.ID, 10)
+}
+
+func TestNamespaceUsage(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			quota := admin.(ExecutionCacheQuotaStore)
+			createNamespaceEntries(t, store, "team-a", "a1", "a2", "a3")
+			createNamespaceEntries(t, store, "team-b", "b1")
+
+			usage, err := quota.NamespaceUsage(context.Background(), "team-a")
+			require.Nil(t, err)
+			assert.Equal(t, &NamespaceUsage{Entries: 3, OutputBytes: 3 * int64(len("output"))}, usage)
+			usage, err = quota.NamespaceUsage(context.Background(), "team-c")
+			require.Nil(t, err)
+			assert.Equal(t, &NamespaceUsage{}, usage)
+		})
+	}
+}
+
+func TestEvictLeastRecentlyUsedStaysInTheNamespace(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			quota := admin.(ExecutionCacheQuotaStore)
+			teamA := createNamespaceEntries(t, store, "team-a", "a1", "a2", "a3")
+			teamB := createNamespaceEntries(t, store, "team-b", "b1", "b2")
+			// a1 was reused after every other entry was created.
+			require.Nil(t, quota.MarkExecutionCacheUsed(context.Background(), entryID(teamA[0]), teamB[1].StartedAtInSec+1))
+
+			eviction, err := quota.Evict(context.Background(), "team-a", NamespaceUsage{Entries: 1}, entryID(teamA[2]), EvictionPolicyLRU)
+			require.Nil(t, err)
+			assert.Equal(t, NamespaceUsage{Entries: 1, OutputBytes: int64(len("output"))}, eviction.Freed)
+			assert.Equal(t, []string{entryID(teamA[1])}, eviction.ExecutionCacheIDs)
+
+			page, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"a1", "a3", "b1", "b2"}, entryKeys(page))
+		})
+	}
+}
+
+func TestEvictLeastRecentlyUsedFreesOutputBytes(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			quota := admin.(ExecutionCacheQuotaStore)
+			created := createNamespaceEntries(t, store, "team-a", "a1", "a2", "a3")
+
+			eviction, err := quota.Evict(context.Background(), "team-a", NamespaceUsage{OutputBytes: int64(len("output")) + 1}, entryID(created[2]), EvictionPolicyLRU)
+			require.Nil(t, err)
+			assert.Equal(t, []string{entryID(created[0]), entryID(created[1])}, eviction.ExecutionCacheIDs)
+
+			eviction, err = quota.Evict(context.Background(), "team-a", NamespaceUsage{Entries: 5}, entryID(created[2]), EvictionPolicyLRU)
+			require.Nil(t, err)
+			assert.Empty(t, eviction.ExecutionCacheIDs, "the kept entry is never evicted")
+		})
+	}
+}
+
+func TestEvictLeastRecentlyUsedEvictsInBatches(t *testing.T) {
+	store := NewExecutionCacheStore(NewFakeDbOrFatal(), util.NewFakeTimeForEpoch())
+	defer store.db.Close()
+	var keep *model.ExecutionCache
+	for i := 0; i < evictBatchSize+2; i++ {
+		var err error
+		keep, err = store.CreateExecutionCache(context.Background(), &model.ExecutionCache{ExecutionCacheKey: "key", Namespace: "team-a"})
+		require.Nil(t, err)
+	}
+
+	eviction, err := store.Evict(context.Background(), "team-a", NamespaceUsage{Entries: int64(evictBatchSize + 1)}, entryID(keep), EvictionPolicyLRU)
+	require.Nil(t, err)
+	assert.Equal(t, int64(evictBatchSize+1), eviction.Freed.Entries)
+	usage, err := store.NamespaceUsage(context.Background(), "team-a")
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), usage.Entries)
+}
+
+func TestEvictLeastFrequentlyUsed(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			quota := admin.(ExecutionCacheQuotaStore)
+			created := createNamespaceEntries(t, store, "team-a", "a1", "a2", "a3", "a4")
+			// a1 was reused twice and a2 once, before a3 was last reused.
+			now := created[3].StartedAtInSec
+			require.Nil(t, quota.MarkExecutionCacheUsed(context.Background(), entryID(created[0]), now+1))
+			require.Nil(t, quota.MarkExecutionCacheUsed(context.Background(), entryID(created[0]), now+2))
+			require.Nil(t, quota.MarkExecutionCacheUsed(context.Background(), entryID(created[1]), now+3))
+			require.Nil(t, quota.MarkExecutionCacheUsed(context.Background(), entryID(created[2]), now+4))
+
+			eviction, err := quota.Evict(context.Background(), "team-a", NamespaceUsage{Entries: 2}, entryID(created[3]), EvictionPolicyLFU)
+			require.Nil(t, err)
+			assert.Equal(t, []string{entryID(created[1]), entryID(created[2])}, eviction.ExecutionCacheIDs, "the least recently used of the entries used once first")
+
+			page, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"a1", "a4"}, entryKeys(page))
+		})
+	}
+}
+
+func TestEvictAllNamespaces(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, admin := newStore()
+			quota := admin.(ExecutionCacheQuotaStore)
+			createNamespaceEntries(t, store, "", "legacy")
+			createNamespaceEntries(t, store, "team-a", "a1")
+			kept := createNamespaceEntries(t, store, "team-b", "b1")
+
+			usage, err := quota.NamespaceUsage(context.Background(), AllNamespaces)
+			require.Nil(t, err)
+			assert.Equal(t, &NamespaceUsage{Entries: 3, OutputBytes: 3 * int64(len("output"))}, usage)
+
+			eviction, err := quota.Evict(context.Background(), AllNamespaces, NamespaceUsage{Entries: 2}, entryID(kept[0]), EvictionPolicyLRU)
+			require.Nil(t, err)
+			assert.Equal(t, int64(2), eviction.Freed.Entries)
+			assert.Equal(t, map[string]NamespaceUsage{
+				"":       {Entries: 1, OutputBytes: int64(len("output"))},
+				"team-a": {Entries: 1, OutputBytes: int64(len("output"))},
+			}, eviction.FreedNamespaces)
+			page, _, err := admin.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 0, "")
+			require.Nil(t, err)
+			assert.Equal(t, []string{"b1"}, entryKeys(page), "entries without namespace are evicted too")
+		})
+	}
+}
+
+func TestAddEvictionIndexes(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+	createNamespaceEntries(t, store, "team-a", "a1")
+	partitions, err := store.partitionNames()
+	require.Nil(t, err)
+	require.Len(t, partitions, 1)
+
+	require.Nil(t, AddEvictionIndexes(db, "execution_caches"), "indexes already added are skipped")
+	for _, table := range append(partitions, "execution_caches") {
+		for _, index := range evictionIndexes {
+			assert.True(t, db.Dialect().HasIndex(table, "idx_"+table+"_"+index.suffix), "%s of %s", index.suffix, table)
+		}
+	}
+}
+
+func TestWriteThroughQuotaStoreEvictsRedisCopies(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+	quota := store.QuotaStore(backing)
+	evicted := createNamespaceEntries(t, store, "team-a", "old", "new")
+
+	_, err := quota.Evict(context.Background(), "team-a", NamespaceUsage{Entries: 1}, entryID(evicted[1]), EvictionPolicyLRU)
+	require.Nil(t, err)
+	assert.False(t, server.Exists("cache:old"))
+	assert.True(t, server.Exists("cache:new"))
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"net/url"
+	"strings"
+
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+// entryScopeSeparator separates the cache key of an entry name from its owner and cluster. It
+// cannot clash with cache keys, which are hex encoded hashes, and is escaped in owners and clusters.
+const entryScopeSeparator = "@"
+
+// EntryScope tells the S3 and Redis stores, which keep a single entry per name, what besides the
+// cache key the name of an entry holds. Entries that lookups tell apart then get names of their
+// own, so that the entry of one owner or cluster does not keep the others from being cached.
+type EntryScope struct {
+	// Owner names the entries after their owner, for lookups that enforce owners.
+	Owner bool
+	// Cluster names the entries after their cluster, for lookups restricted to a cluster.
+	Cluster bool
+}
+
+// name returns the name of the entry. Entries with neither owner nor cluster in scope are named
+// after their cache key alone, like those written before scopes were introduced.
+func (s EntryScope) name(executionCache *model.ExecutionCache) string {
+	owner, cluster := "", ""
+	if s.Owner {
+		owner = executionCache.Owner
+	}
+	if s.Cluster {
+		cluster = executionCache.ClusterID
+	}
+	return scopedEntryName(executionCache.ExecutionCacheKey, owner, cluster)
+}
+
+// lookupNames returns the names of the entries of the cache key that the filter may match, most
+// specific first. The second result is false when the filter may match entries of any owner or
+// cluster in scope, whose names are then to be listed.
+func (s EntryScope) lookupNames(executionCacheKey string, filter ExecutionCacheFilter) ([]string, bool) {
+	owners := []string{""}
+	if s.Owner {
+		if !filter.EnforceOwner {
+			return nil, false
+		}
+		if filter.Owner != "" {
+			owners = []string{filter.Owner, ""}
+		}
+	}
+	clusters := []string{""}
+	if s.Cluster {
+		if filter.ClusterID == "" {
+			return nil, false
+		}
+		// Entries named after their cache key alone may still have been recorded in the cluster.
+		clusters = []string{filter.ClusterID, ""}
+	}
+	var names []string
+	for _, owner := range owners {
+		for _, cluster := range clusters {
+			names = append(names, scopedEntryName(executionCacheKey, owner, cluster))
+		}
+	}
+	return names, true
+}
+
+func scopedEntryName(executionCacheKey string, owner string, cluster string) string {
+	if owner == "" && cluster == "" {
+		return executionCacheKey
+	}
+	return executionCacheKey + entryScopeSeparator + url.QueryEscape(owner) + entryScopeSeparator + url.QueryEscape(cluster)
+}
+
+// scopedEntryNamePrefix is the prefix of the names of the entries of the cache key that are named
+// after their owner or cluster.
+func scopedEntryNamePrefix(executionCacheKey string) string {
+	return executionCacheKey + entryScopeSeparator
+}
+
+// entryNameCacheKey returns the cache key of the entry of that name.
+func entryNameCacheKey(name string) string {
+	if i := strings.Index(name, entryScopeSeparator); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
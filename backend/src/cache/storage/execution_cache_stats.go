@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+const (
+	secondsPerDay  int64 = 24 * 60 * 60
+	secondsPerWeek int64 = 7 * secondsPerDay
+)
+
+// ExecutionCacheStatsStore summarizes the entries of a store.
+type ExecutionCacheStatsStore interface {
+	// ExecutionCacheStats aggregates the entries, stale or not, as of nowInSec.
+	ExecutionCacheStats(ctx context.Context, nowInSec int64) (*ExecutionCacheStats, error)
+}
+
+// ExecutionCacheStats are the aggregates of the entries of a store.
+type ExecutionCacheStats struct {
+	Entries     int64
+	OutputBytes int64
+	// CreatedLastDay and CreatedLastWeek count the entries created in the last 24 hours and 7 days.
+	CreatedLastDay  int64
+	CreatedLastWeek int64
+	// OldestCreatedAtInSec is the creation time of the oldest entry, 0 without entries.
+	OldestCreatedAtInSec int64
+	// Templates aggregates the entries by the name of their template. Entries whose template has no
+	// name are aggregated under the empty name.
+	Templates map[string]*TemplateStats
+}
+
+// TemplateStats are the aggregates of the entries of a template.
+type TemplateStats struct {
+	Entries     int64
+	OutputBytes int64
+}
+
+// templateHeadColumn selects the start of the template up to its first field, which is its name as
+// Argo serializes templates, e.g. {"name":"train",. Grouping by it aggregates the entries by
+// template in the database, whatever their inputs.
+const templateHeadColumn = "SUBSTR(ExecutionTemplate, 1, INSTR(ExecutionTemplate, ','))"
+
+// templateNameFromHead returns the name of the template starting with head, empty when the head
+// does not hold the name.
+func templateNameFromHead(head string) string {
+	var template struct {
+		Name string `json:"name"`
+	}
+	if !strings.HasSuffix(head, ",") || json.Unmarshal([]byte(strings.TrimSuffix(head, ",")+"}"), &template) != nil {
+		return ""
+	}
+	return template.Name
+}
+
+// addExecutionCacheTableStats adds the aggregates of the entries of the table to stats.
+func addExecutionCacheTableStats(db *DB, table string, nowInSec int64, stats *ExecutionCacheStats) error {
+	var entries, outputBytes, createdLastDay, createdLastWeek, oldest int64
+	row := db.Table(table).Select(`COUNT(*), COALESCE(SUM(LENGTH(ExecutionOutput)), 0),
+		COALESCE(SUM(CASE WHEN StartedAtInSec >= ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN StartedAtInSec >= ? THEN 1 ELSE 0 END), 0),
+		COALESCE(MIN(StartedAtInSec), 0)`, nowInSec-secondsPerDay, nowInSec-secondsPerWeek).Row()
+	if err := row.Scan(&entries, &outputBytes, &createdLastDay, &createdLastWeek, &oldest); err != nil {
+		return fmt.Errorf("Failed to aggregate the execution caches of %s: %v", table, err)
+	}
+	stats.Entries += entries
+	stats.OutputBytes += outputBytes
+	stats.CreatedLastDay += createdLastDay
+	stats.CreatedLastWeek += createdLastWeek
+	if oldest != 0 && (stats.OldestCreatedAtInSec == 0 || oldest < stats.OldestCreatedAtInSec) {
+		stats.OldestCreatedAtInSec = oldest
+	}
+
+	rows, err := db.Table(table).Select(templateHeadColumn + " AS TemplateHead, COUNT(*), COALESCE(SUM(LENGTH(ExecutionOutput)), 0)").
+		Group("TemplateHead").Rows()
+	if err != nil {
+		return fmt.Errorf("Failed to aggregate the execution caches of %s by template: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var head string
+		var template TemplateStats
+		if err := rows.Scan(&head, &template.Entries, &template.OutputBytes); err != nil {
+			return fmt.Errorf("Failed to aggregate the execution caches of %s by template: %v", table, err)
+		}
+		name := templateNameFromHead(head)
+		if stats.Templates[name] == nil {
+			stats.Templates[name] = &TemplateStats{}
+		}
+		stats.Templates[name].Entries += template.Entries
+		stats.Templates[name].OutputBytes += template.OutputBytes
+	}
+	return rows.Err()
+}
+
+func (s *ExecutionCacheStore) ExecutionCacheStats(ctx context.Context, nowInSec int64) (*ExecutionCacheStats, error) {
+	stats := &ExecutionCacheStats{Templates: make(map[string]*TemplateStats)}
+	if err := addExecutionCacheTableStats(s.db, "execution_caches", nowInSec, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *PartitionedExecutionCacheStore) ExecutionCacheStats(ctx context.Context, nowInSec int64) (*ExecutionCacheStats, error) {
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Find(&partitions); d.Error != nil {
+		return nil, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	stats := &ExecutionCacheStats{Templates: make(map[string]*TemplateStats)}
+	for _, partition := range partitions {
+		if err := addExecutionCacheTableStats(s.db, partition.Name, nowInSec, stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionCacheStatsAggregatesTheEntries(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore()
+			var created []*model.ExecutionCache
+			for _, entry := range []model.ExecutionCache{
+				{ExecutionCacheKey: "a", ExecutionTemplate: `{"name":"train","inputs":{"parameters":[{"name":"p","value":"1"}]}}`, ExecutionOutput: "12345"},
+				{ExecutionCacheKey: "b", ExecutionTemplate: `{"name":"train","inputs":{"parameters":[{"name":"p","value":"2"}]}}`, ExecutionOutput: "123"},
+				{ExecutionCacheKey: "c", ExecutionTemplate: `{"name":"evaluate","container":{}}`, ExecutionOutput: "12"},
+				{ExecutionCacheKey: "d", ExecutionTemplate: "not a template", ExecutionOutput: "1"},
+			} {
+				entry.MaxCacheStaleness = -1
+				executionCache, err := store.CreateExecutionCache(context.Background(), &entry)
+				require.Nil(t, err)
+				created = append(created, executionCache)
+			}
+			oldest := created[0].StartedAtInSec
+
+			// The last two entries were created in the last 24 hours.
+			stats, err := store.(ExecutionCacheStatsStore).ExecutionCacheStats(context.Background(), created[2].StartedAtInSec+secondsPerDay)
+			require.Nil(t, err)
+			assert.Equal(t, int64(4), stats.Entries)
+			assert.Equal(t, int64(11), stats.OutputBytes)
+			assert.Equal(t, int64(2), stats.CreatedLastDay)
+			assert.Equal(t, int64(4), stats.CreatedLastWeek)
+			assert.Equal(t, oldest, stats.OldestCreatedAtInSec)
+			assert.Equal(t, map[string]*TemplateStats{
+				"train":    {Entries: 2, OutputBytes: 8},
+				"evaluate": {Entries: 1, OutputBytes: 2},
+				"":         {Entries: 1, OutputBytes: 1},
+			}, stats.Templates)
+		})
+	}
+}
+
+func TestExecutionCacheStatsWithoutEntries(t *testing.T) {
+	for name, newStore := range adminStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store, _ := newStore()
+
+			stats, err := store.(ExecutionCacheStatsStore).ExecutionCacheStats(context.Background(), endOfJanuary.Unix())
+			require.Nil(t, err)
+			assert.Equal(t, &ExecutionCacheStats{Templates: map[string]*TemplateStats{}}, stats)
+		})
+	}
+}
+
+func TestTemplateNameFromHead(t *testing.T) {
+	assert.Equal(t, "train", templateNameFromHead(`{"name":"train",`))
+	assert.Equal(t, "", templateNameFromHead(`{"container":{},`))
+	assert.Equal(t, "", templateNameFromHead(`not a template`))
+	assert.Equal(t, "", templateNameFromHead(``))
+}
@@ -15,19 +15,120 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"strconv"
+	"strings"
 
+	"github.com/jinzhu/gorm"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
 	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
 	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/sirupsen/logrus"
 )
 
+// ExecutionCacheFilter narrows down the entries a lookup may return.
+type ExecutionCacheFilter struct {
+	// EnforceOwner restricts matches to entries owned by Owner and to shared entries without owner.
+	EnforceOwner bool
+	Owner        string
+	// Namespace, when set, restricts matches to the entries owned by the profile of that name or by
+	// a service account of the namespace. Lookups leave it empty.
+	Namespace string
+	// CreatedAfterInSec and CreatedBeforeInSec, when set, restrict matches to the entries created
+	// at or after and before those times. Lookups leave them zero.
+	CreatedAfterInSec  int64
+	CreatedBeforeInSec int64
+	// PipelineName, when set, restricts matches to the entries produced by the runs of that
+	// pipeline. Lookups leave it empty.
+	PipelineName string
+	// ClusterID, when set, restricts matches to the entries recorded in that cluster.
+	ClusterID string
+	// KeyVersion, when set, restricts matches to the entries whose key was generated by the key
+	// strategy of that version.
+	KeyVersion string
+}
+
+// matches reports whether the entry passes the filter.
+func (f ExecutionCacheFilter) matches(executionCache *model.ExecutionCache) bool {
+	if f.EnforceOwner && executionCache.Owner != "" && executionCache.Owner != f.Owner {
+		return false
+	}
+	if f.Namespace != "" && executionCache.Owner != f.Namespace &&
+		!strings.HasPrefix(executionCache.Owner, serviceAccountOwnerPrefix+f.Namespace+":") {
+		return false
+	}
+	if f.CreatedAfterInSec != 0 && executionCache.StartedAtInSec < f.CreatedAfterInSec {
+		return false
+	}
+	if f.PipelineName != "" && executionCache.PipelineName != f.PipelineName {
+		return false
+	}
+	if f.ClusterID != "" && executionCache.ClusterID != f.ClusterID {
+		return false
+	}
+	if f.KeyVersion != "" && entryKeyVersion(executionCache) != f.KeyVersion {
+		return false
+	}
+	return f.CreatedBeforeInSec == 0 || executionCache.StartedAtInSec < f.CreatedBeforeInSec
+}
+
+// apply adds the filter conditions to a query over an execution cache table.
+func (f ExecutionCacheFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.EnforceOwner {
+		db = db.Where("Owner = ? OR Owner = ?", "", f.Owner)
+	}
+	if f.Namespace != "" {
+		db = db.Where("Owner = ? OR Owner LIKE ? ESCAPE '!'", f.Namespace, escapeLike(serviceAccountOwnerPrefix+f.Namespace+":")+"%")
+	}
+	if f.CreatedAfterInSec != 0 {
+		db = db.Where("StartedAtInSec >= ?", f.CreatedAfterInSec)
+	}
+	if f.CreatedBeforeInSec != 0 {
+		db = db.Where("StartedAtInSec < ?", f.CreatedBeforeInSec)
+	}
+	if f.PipelineName != "" {
+		db = db.Where("PipelineName = ?", f.PipelineName)
+	}
+	if f.ClusterID != "" {
+		db = db.Where("ClusterID = ?", f.ClusterID)
+	}
+	if f.KeyVersion == model.LegacyKeyVersion {
+		db = db.Where("KeyVersion = ? OR KeyVersion = ?", f.KeyVersion, "")
+	} else if f.KeyVersion != "" {
+		db = db.Where("KeyVersion = ?", f.KeyVersion)
+	}
+	return db
+}
+
+// entryKeyVersion is the key version of the entry, which is the legacy one for the entries of
+// stores recorded before they held key versions.
+func entryKeyVersion(executionCache *model.ExecutionCache) string {
+	if executionCache.KeyVersion == "" {
+		return model.LegacyKeyVersion
+	}
+	return executionCache.KeyVersion
+}
+
+// executionCacheColumns lists the columns read by scanExecutionCacheRows, in scan order.
+var executionCacheColumns = []string{
+	"ID", "ExecutionCacheKey", "ExecutionTemplate", "ExecutionOutput", "MaxCacheStaleness",
+	"StartedAtInSec", "EndedAtInSec", "Owner", "ExecutionDurationInSec", "PipelineName", "RunID",
+	"Namespace", "LastUsedAtInSec", "ClusterID", "KeyVersion", "PipelineVersionID", "ExpiresAtInSec",
+}
+
+// ErrExecutionCacheNotCached is returned by the stores that expire entries themselves, e.g. Redis,
+// when the entry to create has no staleness budget left. Nothing is stored and the entry has no ID.
+var ErrExecutionCacheNotCached = errors.New("execution cache entry is stale on creation, nothing is cached")
+
 type ExecutionCacheStoreInterface interface {
-	GetExecutionCache(executionCacheKey string, maxCacheStaleness int64) (*model.ExecutionCache, error)
-	CreateExecutionCache(*model.ExecutionCache) (*model.ExecutionCache, error)
-	DeleteExecutionCache(executionCacheKey string) error
+	GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error)
+	CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error)
+	// DeleteExecutionCache deletes the entries of the cache key, whatever their owner, and returns a
+	// NOT_FOUND error when there is none. Single entries are deleted by ID through the admin store.
+	DeleteExecutionCache(ctx context.Context, executionCacheKey string) error
 }
 
 type ExecutionCacheStore struct {
@@ -35,21 +136,24 @@ type ExecutionCacheStore struct {
 	time util.TimeInterface
 }
 
-func (s *ExecutionCacheStore) GetExecutionCache(executionCacheKey string, maxCacheStaleness int64) (*model.ExecutionCache, error) {
+func (s *ExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (executionCache *model.ExecutionCache, err error) {
+	ctx, span := tracer.Start(ctx, tracing.SpanDBLookup)
+	defer func() { endLookupSpan(span, err) }()
 	if maxCacheStaleness == 0 {
-		return nil, fmt.Errorf("MaxCacheStaleness=0, Cache is disabled.")
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "MaxCacheStaleness=0, Cache is disabled.")
 	}
-	r, err := s.db.Table("execution_caches").Where("ExecutionCacheKey = ?", executionCacheKey).Rows()
+	query := s.db.Table("execution_caches").Select(executionCacheColumns).Where("ExecutionCacheKey = ?", executionCacheKey)
+	r, err := filter.apply(query).Rows()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get execution cache: %q", executionCacheKey)
 	}
 	defer r.Close()
-	executionCaches, err := s.scanRows(r, maxCacheStaleness)
+	executionCaches, err := scanExecutionCacheRows(ctx, r, maxCacheStaleness, s.time)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get execution cache: %q", executionCacheKey)
 	}
 	if len(executionCaches) == 0 {
-		return nil, fmt.Errorf("Execution cache not found with cache key: %q", executionCacheKey)
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
 	}
 	latestCache, err := getLatestCacheEntry(executionCaches)
 	if err != nil {
@@ -58,11 +162,13 @@ func (s *ExecutionCacheStore) GetExecutionCache(executionCacheKey string, maxCac
 	return latestCache, nil
 }
 
-func (s *ExecutionCacheStore) scanRows(rows *sql.Rows, podMaxCacheStaleness int64) ([]*model.ExecutionCache, error) {
+// scanExecutionCacheRows returns the entries of the rows that are fresh for a pod accepting results
+// up to podMaxCacheStaleness seconds old.
+func scanExecutionCacheRows(ctx context.Context, rows *sql.Rows, podMaxCacheStaleness int64, time util.TimeInterface) ([]*model.ExecutionCache, error) {
 	var executionCaches []*model.ExecutionCache
 	for rows.Next() {
-		var executionCacheKey, executionTemplate, executionOutput string
-		var id, maxCacheStaleness, startedAtInSec, endedAtInSec int64
+		var executionCacheKey, executionTemplate, executionOutput, owner, pipelineName, runID, namespace, clusterID, keyVersion, pipelineVersionID string
+		var id, maxCacheStaleness, startedAtInSec, endedAtInSec, executionDurationInSec, lastUsedAtInSec, expiresAtInSec int64
 		err := rows.Scan(
 			&id,
 			&executionCacheKey,
@@ -70,28 +176,66 @@ func (s *ExecutionCacheStore) scanRows(rows *sql.Rows, podMaxCacheStaleness int6
 			&executionOutput,
 			&maxCacheStaleness,
 			&startedAtInSec,
-			&endedAtInSec)
+			&endedAtInSec,
+			&owner,
+			&executionDurationInSec,
+			&pipelineName,
+			&runID,
+			&namespace,
+			&lastUsedAtInSec,
+			&clusterID,
+			&keyVersion,
+			&pipelineVersionID,
+			&expiresAtInSec)
 		if err != nil {
 			return executionCaches, nil
 		}
-		log.Println("Get id: " + strconv.FormatInt(id, 10))
-		log.Println("Get template: " + executionTemplate)
-		if maxCacheStaleness == -1 || s.time.Now().UTC().Unix()-startedAtInSec <= podMaxCacheStaleness {
-			executionCaches = append(executionCaches, &model.ExecutionCache{
-				ID:                id,
-				ExecutionCacheKey: executionCacheKey,
-				ExecutionTemplate: executionTemplate,
-				ExecutionOutput:   executionOutput,
-				MaxCacheStaleness: maxCacheStaleness,
-				StartedAtInSec:    startedAtInSec,
-				EndedAtInSec:      endedAtInSec,
-			})
+		logging.WithContext(logger, ctx).WithFields(logrus.Fields{
+			logging.FieldCacheKey: executionCacheKey,
+			logging.FieldCacheID:  id,
+		}).Debug("Found execution cache row")
+		executionCache := &model.ExecutionCache{
+			ID:                     id,
+			ExecutionCacheKey:      executionCacheKey,
+			ExecutionTemplate:      executionTemplate,
+			ExecutionOutput:        executionOutput,
+			MaxCacheStaleness:      maxCacheStaleness,
+			StartedAtInSec:         startedAtInSec,
+			EndedAtInSec:           endedAtInSec,
+			Owner:                  owner,
+			ExecutionDurationInSec: executionDurationInSec,
+			PipelineName:           pipelineName,
+			PipelineVersionID:      pipelineVersionID,
+			RunID:                  runID,
+			Namespace:              namespace,
+			LastUsedAtInSec:        lastUsedAtInSec,
+			ClusterID:              clusterID,
+			KeyVersion:             keyVersion,
+			ExpiresAtInSec:         expiresAtInSec,
+		}
+		if isExecutionCacheFresh(executionCache, podMaxCacheStaleness, time.Now().UTC().Unix()) {
+			executionCaches = append(executionCaches, executionCache)
 		}
 
 	}
 	return executionCaches, nil
 }
 
+// isExecutionCacheFresh reports whether a cache entry can still be reused by a pod that accepts
+// results up to podMaxCacheStaleness seconds old. Entries past their time-to-live are never
+// reused, whether or not the store deleted them yet.
+func isExecutionCacheFresh(executionCache *model.ExecutionCache, podMaxCacheStaleness int64, nowInSec int64) bool {
+	if isExecutionCacheExpired(executionCache, nowInSec) {
+		return false
+	}
+	return executionCache.MaxCacheStaleness == -1 || nowInSec-executionCache.StartedAtInSec <= podMaxCacheStaleness
+}
+
+// isExecutionCacheExpired reports whether the time-to-live of the entry ended.
+func isExecutionCacheExpired(executionCache *model.ExecutionCache, nowInSec int64) bool {
+	return executionCache.ExpiresAtInSec > 0 && nowInSec >= executionCache.ExpiresAtInSec
+}
+
 // Demo version will return the latest cache entry within same cache key. MaxCacheStaleness will
 // be taken into consideration in the future.
 func getLatestCacheEntry(executionCaches []*model.ExecutionCache) (*model.ExecutionCache, error) {
@@ -104,20 +248,19 @@ func getLatestCacheEntry(executionCaches []*model.ExecutionCache) (*model.Execut
 		}
 	}
 	if latestCacheEntry == nil {
-		return nil, fmt.Errorf("No cache entry found.")
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "No cache entry found.")
 	}
 	return latestCacheEntry, nil
 }
 
-func (s *ExecutionCacheStore) CreateExecutionCache(executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
-	log.Println("Input cache: " + executionCache.ExecutionCacheKey)
+func (s *ExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
 	newExecutionCache := *executionCache
-	log.Println("New cache key: " + newExecutionCache.ExecutionCacheKey)
 	now := s.time.Now().UTC().Unix()
 
 	newExecutionCache.StartedAtInSec = now
 	// TODO: ended time need to be modified after demo version.
 	newExecutionCache.EndedAtInSec = now
+	newExecutionCache.LastUsedAtInSec = now
 
 	ok := s.db.NewRecord(newExecutionCache)
 	if !ok {
@@ -128,20 +271,97 @@ func (s *ExecutionCacheStore) CreateExecutionCache(executionCache *model.Executi
 	if d.Error != nil {
 		return nil, d.Error
 	}
-	log.Println("Cache entry created with cache key: " + newExecutionCache.ExecutionCacheKey)
-	log.Println(newExecutionCache.ExecutionTemplate)
-	log.Println(rowInsert.ID)
+	logging.WithContext(logger, ctx).WithFields(logrus.Fields{
+		logging.FieldCacheKey: newExecutionCache.ExecutionCacheKey,
+		logging.FieldCacheID:  rowInsert.ID,
+	}).Debug("Cache entry created")
 	return &rowInsert, nil
 }
 
-func (s *ExecutionCacheStore) DeleteExecutionCache(executionCacheID string) error {
-	db := s.db.Delete(&model.ExecutionCache{}, "ID = ?", executionCacheID)
-	if db.Error != nil {
-		return db.Error
+func (s *ExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	deleted, err := s.DeleteExecutionCachesByKey(ctx, executionCacheKey)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
 	}
 	return nil
 }
 
+func (s *ExecutionCacheStore) ListExecutionCaches(ctx context.Context, keyPrefix string, filter ExecutionCacheFilter, pageSize int, pageToken string) ([]*model.ExecutionCache, string, error) {
+	pageSize = listPageSize(pageSize)
+	afterID, err := parseIDPageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	query := s.db.Table("execution_caches").Select(executionCacheColumns).
+		Where("ID > ?", afterID).Order("ID").Limit(pageSize + 1)
+	if keyPrefix != "" {
+		query = query.Where("ExecutionCacheKey LIKE ? ESCAPE '!'", escapeLike(keyPrefix)+"%")
+	}
+	r, err := filter.apply(query).Rows()
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to list execution caches: %v", err)
+	}
+	defer r.Close()
+	executionCaches, err := scanAllExecutionCacheRows(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to list execution caches: %v", err)
+	}
+	executionCaches, nextPageToken := idPage(executionCaches, pageSize)
+	return executionCaches, nextPageToken, nil
+}
+
+func (s *ExecutionCacheStore) GetExecutionCacheByID(ctx context.Context, executionCacheID string) (*model.ExecutionCache, error) {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.db.Table("execution_caches").Select(executionCacheColumns).Where("ID = ?", id).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get execution cache with ID %q: %v", executionCacheID, err)
+	}
+	defer r.Close()
+	executionCaches, err := scanAllExecutionCacheRows(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get execution cache with ID %q: %v", executionCacheID, err)
+	}
+	if len(executionCaches) == 0 {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	return executionCaches[0], nil
+}
+
+func (s *ExecutionCacheStore) DeleteExecutionCacheByID(ctx context.Context, executionCacheID string) error {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return err
+	}
+	d := s.db.Delete(&model.ExecutionCache{}, "ID = ?", id)
+	if d.Error != nil {
+		return d.Error
+	}
+	if d.RowsAffected == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	return nil
+}
+
+func (s *ExecutionCacheStore) DeleteExecutionCachesByKey(ctx context.Context, executionCacheKey string) (int64, error) {
+	d := s.db.Delete(&model.ExecutionCache{}, "ExecutionCacheKey = ?", executionCacheKey)
+	return d.RowsAffected, d.Error
+}
+
+func (s *ExecutionCacheStore) InvalidateExecutionCaches(ctx context.Context, selector ExecutionCacheSelector, dryRun bool) (*ExecutionCacheInvalidation, error) {
+	if err := selector.validate(); err != nil {
+		return nil, err
+	}
+	invalidation := &ExecutionCacheInvalidation{}
+	err := invalidateExecutionCacheTable(s.db, "execution_caches", selector, dryRun, invalidation)
+	return invalidation, err
+}
+
 // factory function for execution cache store
 func NewExecutionCacheStore(db *DB, time util.TimeInterface) *ExecutionCacheStore {
 	return &ExecutionCacheStore{
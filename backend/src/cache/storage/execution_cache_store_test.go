@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"context"
 	"testing"
 
 	"github.com/kubeflow/pipelines/backend/src/cache/model"
@@ -46,6 +47,7 @@ func TestCreateExecutionCache(t *testing.T) {
 		MaxCacheStaleness: -1,
 		StartedAtInSec:    1,
 		EndedAtInSec:      1,
+		LastUsedAtInSec:   1,
 	}
 	executionCache := &model.ExecutionCache{
 		ExecutionCacheKey: "test",
@@ -53,7 +55,7 @@ func TestCreateExecutionCache(t *testing.T) {
 		ExecutionOutput:   "testOutput",
 		MaxCacheStaleness: -1,
 	}
-	executionCache, err := executionCacheStore.CreateExecutionCache(executionCache)
+	executionCache, err := executionCacheStore.CreateExecutionCache(context.Background(), executionCache)
 	assert.Nil(t, err)
 	require.Equal(t, executionCacheExpected, *executionCache)
 }
@@ -71,8 +73,8 @@ func TestCreateExecutionCacheWithDuplicateRecord(t *testing.T) {
 	db := NewFakeDbOrFatal()
 	defer db.Close()
 	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
-	executionCacheStore.CreateExecutionCache(executionCache)
-	cache, err := executionCacheStore.CreateExecutionCache(executionCache)
+	executionCacheStore.CreateExecutionCache(context.Background(), executionCache)
+	cache, err := executionCacheStore.CreateExecutionCache(context.Background(), executionCache)
 	assert.Nil(t, cache)
 	assert.Contains(t, err.Error(), "Failed to create a new execution cache")
 }
@@ -82,7 +84,7 @@ func TestGetExecutionCache(t *testing.T) {
 	defer db.Close()
 	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
 
-	executionCacheStore.CreateExecutionCache(createExecutionCache("testKey", "testOutput"))
+	executionCacheStore.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
 	executionCacheExpected := model.ExecutionCache{
 		ID:                1,
 		ExecutionCacheKey: "testKey",
@@ -91,10 +93,11 @@ func TestGetExecutionCache(t *testing.T) {
 		MaxCacheStaleness: -1,
 		StartedAtInSec:    1,
 		EndedAtInSec:      1,
+		LastUsedAtInSec:   1,
 	}
 
 	var executionCache *model.ExecutionCache
-	executionCache, err := executionCacheStore.GetExecutionCache("testKey", -1)
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
 	require.Nil(t, err)
 	require.Equal(t, &executionCacheExpected, executionCache)
 }
@@ -104,20 +107,102 @@ func TestGetExecutionCacheWithEmptyCacheEntry(t *testing.T) {
 	defer db.Close()
 	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
 
-	executionCacheStore.CreateExecutionCache(createExecutionCache("testKey", "testOutput"))
+	executionCacheStore.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
 	var executionCache *model.ExecutionCache
-	executionCache, err := executionCacheStore.GetExecutionCache("wrongKey", -1)
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "wrongKey", -1, ExecutionCacheFilter{})
 	require.Nil(t, executionCache)
 	require.Contains(t, err.Error(), `Execution cache not found with cache key: "wrongKey"`)
 }
 
+func TestGetExecutionCacheWithOwnerFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		entryOwner   string
+		enforceOwner bool
+		requester    string
+		found        bool
+	}{
+		{"not enforced, matching owner", "alice", false, "alice", true},
+		{"not enforced, other owner", "alice", false, "bob", true},
+		{"not enforced, shared entry", "", false, "bob", true},
+		{"enforced, matching owner", "alice", true, "alice", true},
+		{"enforced, other owner", "alice", true, "bob", false},
+		{"enforced, shared entry", "", true, "bob", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewFakeDbOrFatal()
+			defer db.Close()
+			executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+			executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+			executionCacheToPersist.Owner = tc.entryOwner
+			_, err := executionCacheStore.CreateExecutionCache(context.Background(), executionCacheToPersist)
+			require.Nil(t, err)
+
+			filter := ExecutionCacheFilter{EnforceOwner: tc.enforceOwner, Owner: tc.requester}
+			executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, filter)
+			if tc.found {
+				require.Nil(t, err)
+				assert.Equal(t, tc.entryOwner, executionCache.Owner)
+			} else {
+				assert.Nil(t, executionCache)
+				assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+			}
+		})
+	}
+}
+
+func TestGetExecutionCacheWithClusterFilter(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	for _, clusterID := range []string{"us-east", "eu-west", ""} {
+		executionCacheToPersist := createExecutionCache("testKey", "output of "+clusterID)
+		executionCacheToPersist.ClusterID = clusterID
+		_, err := executionCacheStore.CreateExecutionCache(context.Background(), executionCacheToPersist)
+		require.Nil(t, err)
+	}
+
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{ClusterID: "us-east"})
+	require.Nil(t, err)
+	assert.Equal(t, "us-east", executionCache.ClusterID)
+	assert.Equal(t, "output of us-east", executionCache.ExecutionOutput)
+	executionCache, err = executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "", executionCache.ClusterID, "the latest entry of any cluster matches without cluster")
+	_, err = executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{ClusterID: "ap-south"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestGetExecutionCacheWithKeyVersionFilter(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	// Entries recorded before key versions have none, and were keyed by the legacy strategy.
+	for _, keyVersion := range []string{"", "2"} {
+		executionCacheToPersist := createExecutionCache("testKey", "output of version "+keyVersion)
+		executionCacheToPersist.KeyVersion = keyVersion
+		_, err := executionCacheStore.CreateExecutionCache(context.Background(), executionCacheToPersist)
+		require.Nil(t, err)
+	}
+
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{KeyVersion: model.LegacyKeyVersion})
+	require.Nil(t, err)
+	assert.Equal(t, "output of version ", executionCache.ExecutionOutput)
+	executionCache, err = executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{KeyVersion: "2"})
+	require.Nil(t, err)
+	assert.Equal(t, "output of version 2", executionCache.ExecutionOutput)
+	_, err = executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{KeyVersion: "3"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
 func TestGetExecutionCacheWithLatestCacheEntry(t *testing.T) {
 	db := NewFakeDbOrFatal()
 	defer db.Close()
 	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
 
-	executionCacheStore.CreateExecutionCache(createExecutionCache("testKey", "testOutput"))
-	executionCacheStore.CreateExecutionCache(createExecutionCache("testKey", "testOutput2"))
+	executionCacheStore.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	executionCacheStore.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput2"))
 
 	executionCacheExpected := model.ExecutionCache{
 		ID:                2,
@@ -127,9 +212,10 @@ func TestGetExecutionCacheWithLatestCacheEntry(t *testing.T) {
 		MaxCacheStaleness: -1,
 		StartedAtInSec:    2,
 		EndedAtInSec:      2,
+		LastUsedAtInSec:   2,
 	}
 	var executionCache *model.ExecutionCache
-	executionCache, err := executionCacheStore.GetExecutionCache("testKey", -1)
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
 	require.Nil(t, err)
 	require.Equal(t, &executionCacheExpected, executionCache)
 }
@@ -144,10 +230,10 @@ func TestGetExecutionCacheWithExpiredMaxCacheStaleness(t *testing.T) {
 		ExecutionOutput:   "testOutput",
 		MaxCacheStaleness: 0,
 	}
-	executionCacheStore.CreateExecutionCache(executionCacheToPersist)
+	executionCacheStore.CreateExecutionCache(context.Background(), executionCacheToPersist)
 
 	var executionCache *model.ExecutionCache
-	executionCache, err := executionCacheStore.GetExecutionCache("testKey", -1)
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
 	require.Contains(t, err.Error(), "Execution cache not found")
 	require.Nil(t, executionCache)
 }
@@ -156,14 +242,16 @@ func TestDeleteExecutionCache(t *testing.T) {
 	db := NewFakeDbOrFatal()
 	defer db.Close()
 	executionCacheStore := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
-	executionCacheStore.CreateExecutionCache(createExecutionCache("testKey", "testOutput"))
-	executionCache, err := executionCacheStore.GetExecutionCache("testKey", -1)
+	executionCacheStore.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	executionCache, err := executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
 	assert.Nil(t, err)
 	assert.NotNil(t, executionCache)
 
-	err = executionCacheStore.DeleteExecutionCache("1")
+	err = executionCacheStore.DeleteExecutionCache(context.Background(), "testKey")
 	assert.Nil(t, err)
-	_, err = executionCacheStore.GetExecutionCache("testKey", -1)
+	_, err = executionCacheStore.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "not found")
+	err = executionCacheStore.DeleteExecutionCache(context.Background(), "testKey")
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
 }
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StoreOutcomeOK        string = "ok"
+	StoreOutcomeNotFound  string = "not_found"
+	StoreOutcomeNotCached string = "not_cached"
+	StoreOutcomeError     string = "error"
+)
+
+// InstrumentedExecutionCacheStore wraps an ExecutionCacheStoreInterface and records the latency and
+// outcome of every call. Errors returned by the wrapped store are passed through unmodified.
+type InstrumentedExecutionCacheStore struct {
+	store             ExecutionCacheStoreInterface
+	name              string
+	requestDuration   *prometheus.HistogramVec
+	slowCallThreshold time.Duration
+}
+
+func (s *InstrumentedExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	start := time.Now()
+	executionCache, err := s.store.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+	s.observe(ctx, "GetExecutionCache", start, err)
+	return executionCache, err
+}
+
+func (s *InstrumentedExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	start := time.Now()
+	createdExecutionCache, err := s.store.CreateExecutionCache(ctx, executionCache)
+	s.observe(ctx, "CreateExecutionCache", start, err)
+	return createdExecutionCache, err
+}
+
+func (s *InstrumentedExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	start := time.Now()
+	err := s.store.DeleteExecutionCache(ctx, executionCacheKey)
+	s.observe(ctx, "DeleteExecutionCache", start, err)
+	return err
+}
+
+func (s *InstrumentedExecutionCacheStore) observe(ctx context.Context, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	s.requestDuration.WithLabelValues(s.name, method, storeOutcome(err)).Observe(elapsed.Seconds())
+	if s.slowCallThreshold > 0 && elapsed > s.slowCallThreshold {
+		logging.WithContext(logger, ctx).WithFields(logrus.Fields{
+			logging.FieldStore:      s.name,
+			logging.FieldMethod:     method,
+			logging.FieldDurationMs: logging.DurationMs(elapsed),
+		}).Warnf("Slow store call, threshold %v", s.slowCallThreshold)
+	}
+}
+
+func storeOutcome(err error) string {
+	switch {
+	case err == nil:
+		return StoreOutcomeOK
+	case util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND):
+		return StoreOutcomeNotFound
+	case errors.Is(err, ErrExecutionCacheNotCached):
+		return StoreOutcomeNotCached
+	default:
+		return StoreOutcomeError
+	}
+}
+
+// factory function for instrumented execution cache store. The name distinguishes stacked stores
+// (e.g. "db" and "redis") in the exported metrics.
+func NewInstrumentedExecutionCacheStore(store ExecutionCacheStoreInterface, name string, registerer prometheus.Registerer, slowCallThreshold time.Duration) *InstrumentedExecutionCacheStore {
+	requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cache_store_request_duration_seconds",
+		Help:    "Latency of execution cache store calls by store, method and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"store", "method", "outcome"})
+	registered, err := metrics.RegisterOrReuse(registerer, requestDuration)
+	if err != nil {
+		logger.Errorf("Failed to register store metrics: %v", err)
+	}
+	requestDuration = registered.(*prometheus.HistogramVec)
+	return &InstrumentedExecutionCacheStore{
+		store:             store,
+		name:              name,
+		requestDuration:   requestDuration,
+		slowCallThreshold: slowCallThreshold,
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type erroringExecutionCacheStore struct {
+	err error
+}
+
+func (s *erroringExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	return nil, s.err
+}
+
+func (s *erroringExecutionCacheStore) CreateExecutionCache(context.Context, *model.ExecutionCache) (*model.ExecutionCache, error) {
+	return nil, s.err
+}
+
+func (s *erroringExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	return s.err
+}
+
+func getSampleCount(t *testing.T, registry *prometheus.Registry, labels map[string]string) uint64 {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != "cache_store_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if labelsMatch(metric, labels) {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func labelsMatch(metric *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range metric.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+func TestInstrumentedExecutionCacheStore(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	registry := prometheus.NewRegistry()
+	store := NewInstrumentedExecutionCacheStore(NewExecutionCacheStore(db, util.NewFakeTimeForEpoch()), "db", registry, 0)
+
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	require.NotNil(t, executionCache)
+	_, err = store.GetExecutionCache(context.Background(), "wrongKey", -1, ExecutionCacheFilter{})
+	require.NotNil(t, err)
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "db", "method": "CreateExecutionCache", "outcome": StoreOutcomeOK}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "db", "method": "GetExecutionCache", "outcome": StoreOutcomeOK}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "db", "method": "GetExecutionCache", "outcome": StoreOutcomeNotFound}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "db", "method": "DeleteExecutionCache", "outcome": StoreOutcomeOK}))
+}
+
+func TestInstrumentedExecutionCacheStorePassesErrorsThrough(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	storeErr := errors.New("connection refused")
+	store := NewInstrumentedExecutionCacheStore(&erroringExecutionCacheStore{err: storeErr}, "db", registry, 0)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.Equal(t, storeErr, err)
+	executionCache, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	assert.Nil(t, executionCache)
+	assert.Equal(t, storeErr, err)
+	assert.Equal(t, storeErr, store.DeleteExecutionCache(context.Background(), "testKey"))
+
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"method": "GetExecutionCache", "outcome": StoreOutcomeError}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"method": "CreateExecutionCache", "outcome": StoreOutcomeError}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"method": "DeleteExecutionCache", "outcome": StoreOutcomeError}))
+}
+
+func TestInstrumentedExecutionCacheStoreSharesCollectorOnReregistration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	storeErr := errors.New("connection refused")
+	first := NewInstrumentedExecutionCacheStore(&erroringExecutionCacheStore{err: storeErr}, "db", registry, 0)
+	second := NewInstrumentedExecutionCacheStore(&erroringExecutionCacheStore{err: storeErr}, "redis", registry, 0)
+
+	first.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	second.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "db", "method": "GetExecutionCache"}))
+	assert.Equal(t, uint64(1), getSampleCount(t, registry, map[string]string{"store": "redis", "method": "GetExecutionCache"}))
+}
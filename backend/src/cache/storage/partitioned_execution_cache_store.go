@@ -0,0 +1,484 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+)
+
+const (
+	PartitionByNone  string = "none"
+	PartitionByMonth string = "month"
+
+	legacyExecutionCacheTable string = "execution_caches"
+	partitionTablePrefix      string = "execution_caches_p"
+	// IDs handed out by the partitioned store embed the partition (as yyyymm) above this multiplier
+	// so that an ID alone is enough to locate the row.
+	partitionIDMultiplier int64 = 1000000000000
+)
+
+// partitionRow mirrors model.ExecutionCache for the partition tables. It carries no named index so
+// that each partition can get its own index name, and declares the long text columns up front.
+type partitionRow struct {
+	ID                     int64  `gorm:"column:ID; not null; primary_key; AUTO_INCREMENT"`
+	ExecutionCacheKey      string `gorm:"column:ExecutionCacheKey; not null"`
+	ExecutionTemplate      string `gorm:"column:ExecutionTemplate; type:longtext; not null"`
+	ExecutionOutput        string `gorm:"column:ExecutionOutput; type:longtext"`
+	MaxCacheStaleness      int64  `gorm:"column:MaxCacheStaleness; not null"`
+	StartedAtInSec         int64  `gorm:"column:StartedAtInSec; not null"`
+	EndedAtInSec           int64  `gorm:"column:EndedAtInSec; not null"`
+	Owner                  string `gorm:"column:Owner; not null; default:''"`
+	ExecutionDurationInSec int64  `gorm:"column:ExecutionDurationInSec; not null; default:0"`
+	PipelineName           string `gorm:"column:PipelineName; not null; default:''"`
+	RunID                  string `gorm:"column:RunID; not null; default:''"`
+	Namespace              string `gorm:"column:Namespace; not null; default:''"`
+	LastUsedAtInSec        int64  `gorm:"column:LastUsedAtInSec; not null; default:0"`
+	UseCount               int64  `gorm:"column:UseCount; not null; default:0"`
+	ClusterID              string `gorm:"column:ClusterID; not null; default:''"`
+	KeyVersion             string `gorm:"column:KeyVersion; not null; default:''"`
+	PipelineVersionID      string `gorm:"column:PipelineVersionID; not null; default:''"`
+	ExpiresAtInSec         int64  `gorm:"column:ExpiresAtInSec; not null; default:0"`
+}
+
+// PartitionedExecutionCacheStore spreads execution cache entries over monthly tables selected by
+// creation time. Lookups fan out newest-first over a bounded number of recent partitions and
+// garbage collection drops whole partitions, which is far cheaper than row-level deletes.
+type PartitionedExecutionCacheStore struct {
+	db       *DB
+	time     util.TimeInterface
+	lookback int
+
+	mutex           sync.Mutex
+	knownPartitions map[string]bool
+}
+
+func (s *PartitionedExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (executionCache *model.ExecutionCache, err error) {
+	ctx, span := tracer.Start(ctx, tracing.SpanDBLookup)
+	defer func() { endLookupSpan(span, err) }()
+	if maxCacheStaleness == 0 {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "MaxCacheStaleness=0, Cache is disabled.")
+	}
+	var partitions []model.ExecutionCachePartition
+	d := s.db.Order("StartsAtInSec desc").Limit(s.lookback).Find(&partitions)
+	if d.Error != nil {
+		return nil, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	for _, partition := range partitions {
+		query := s.db.Table(partition.Name).Select(executionCacheColumns).Where("ExecutionCacheKey = ?", executionCacheKey)
+		r, err := filter.apply(query).Rows()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get execution cache: %q", executionCacheKey)
+		}
+		executionCaches, err := scanExecutionCacheRows(ctx, r, maxCacheStaleness, s.time)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get execution cache: %q", executionCacheKey)
+		}
+		if len(executionCaches) == 0 {
+			continue
+		}
+		latestCache, err := getLatestCacheEntry(executionCaches)
+		if err != nil {
+			return nil, err
+		}
+		latestCache.ID = encodePartitionedID(partition.Name, latestCache.ID)
+		return latestCache, nil
+	}
+	return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+}
+
+func (s *PartitionedExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	now := s.time.Now().UTC().Unix()
+	newExecutionCache := *executionCache
+	newExecutionCache.ID = 0
+	newExecutionCache.StartedAtInSec = now
+	newExecutionCache.EndedAtInSec = now
+	newExecutionCache.LastUsedAtInSec = now
+	return s.insert(&newExecutionCache)
+}
+
+// insert writes the entry into the partition covering its StartedAtInSec, keeping its timestamps.
+func (s *PartitionedExecutionCacheStore) insert(executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	partitionName, err := s.ensurePartition(executionCache.StartedAtInSec)
+	if err != nil {
+		return nil, err
+	}
+	return insertIntoPartition(s.db.DB, partitionName, executionCache)
+}
+
+// insertIntoPartition writes the entry into the given partition, which must exist, through db, e.g.
+// a transaction.
+func insertIntoPartition(db *gorm.DB, partitionName string, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	row := partitionRow{
+		ExecutionCacheKey:      executionCache.ExecutionCacheKey,
+		ExecutionTemplate:      executionCache.ExecutionTemplate,
+		ExecutionOutput:        executionCache.ExecutionOutput,
+		MaxCacheStaleness:      executionCache.MaxCacheStaleness,
+		StartedAtInSec:         executionCache.StartedAtInSec,
+		EndedAtInSec:           executionCache.EndedAtInSec,
+		Owner:                  executionCache.Owner,
+		ExecutionDurationInSec: executionCache.ExecutionDurationInSec,
+		PipelineName:           executionCache.PipelineName,
+		RunID:                  executionCache.RunID,
+		Namespace:              executionCache.Namespace,
+		LastUsedAtInSec:        executionCache.LastUsedAtInSec,
+		ClusterID:              executionCache.ClusterID,
+		KeyVersion:             executionCache.KeyVersion,
+		PipelineVersionID:      executionCache.PipelineVersionID,
+		ExpiresAtInSec:         executionCache.ExpiresAtInSec,
+	}
+	if d := db.Table(partitionName).Create(&row); d.Error != nil {
+		return nil, d.Error
+	}
+	created := *executionCache
+	created.ID = encodePartitionedID(partitionName, row.ID)
+	logger.WithField(logging.FieldCacheKey, created.ExecutionCacheKey).Debugf("Cache entry created in partition %s", partitionName)
+	return &created, nil
+}
+
+func (s *PartitionedExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	deleted, err := s.DeleteExecutionCachesByKey(ctx, executionCacheKey)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return nil
+}
+
+// ListExecutionCaches lists the partitions oldest first, so that the IDs, which embed the month
+// of their partition, come in order. Partitions outside of the creation range of the filter are
+// skipped.
+func (s *PartitionedExecutionCacheStore) ListExecutionCaches(ctx context.Context, keyPrefix string, filter ExecutionCacheFilter, pageSize int, pageToken string) ([]*model.ExecutionCache, string, error) {
+	pageSize = listPageSize(pageSize)
+	afterID, err := parseIDPageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	afterPartition, afterRowID := decodePartitionedID(afterID)
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Order("StartsAtInSec").Find(&partitions); d.Error != nil {
+		return nil, "", fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	var executionCaches []*model.ExecutionCache
+	for _, partition := range partitions {
+		if len(executionCaches) > pageSize {
+			break
+		}
+		if partition.Name < afterPartition ||
+			(filter.CreatedAfterInSec != 0 && partition.EndsAtInSec <= filter.CreatedAfterInSec) ||
+			(filter.CreatedBeforeInSec != 0 && partition.StartsAtInSec >= filter.CreatedBeforeInSec) {
+			continue
+		}
+		query := s.db.Table(partition.Name).Select(executionCacheColumns).Order("ID").Limit(pageSize + 1 - len(executionCaches))
+		if partition.Name == afterPartition {
+			query = query.Where("ID > ?", afterRowID)
+		}
+		if keyPrefix != "" {
+			query = query.Where("ExecutionCacheKey LIKE ? ESCAPE '!'", escapeLike(keyPrefix)+"%")
+		}
+		r, err := filter.apply(query).Rows()
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to list execution cache partition %s: %v", partition.Name, err)
+		}
+		partitionCaches, err := scanAllExecutionCacheRows(r)
+		r.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to list execution cache partition %s: %v", partition.Name, err)
+		}
+		for _, executionCache := range partitionCaches {
+			executionCache.ID = encodePartitionedID(partition.Name, executionCache.ID)
+		}
+		executionCaches = append(executionCaches, partitionCaches...)
+	}
+	executionCaches, nextPageToken := idPage(executionCaches, pageSize)
+	return executionCaches, nextPageToken, nil
+}
+
+func (s *PartitionedExecutionCacheStore) GetExecutionCacheByID(ctx context.Context, executionCacheID string) (*model.ExecutionCache, error) {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return nil, err
+	}
+	partitionName, rowID := decodePartitionedID(id)
+	if !s.db.HasTable(partitionName) {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	r, err := s.db.Table(partitionName).Select(executionCacheColumns).Where("ID = ?", rowID).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get execution cache with ID %q: %v", executionCacheID, err)
+	}
+	defer r.Close()
+	executionCaches, err := scanAllExecutionCacheRows(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get execution cache with ID %q: %v", executionCacheID, err)
+	}
+	if len(executionCaches) == 0 {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	executionCaches[0].ID = id
+	return executionCaches[0], nil
+}
+
+func (s *PartitionedExecutionCacheStore) DeleteExecutionCacheByID(ctx context.Context, executionCacheID string) error {
+	id, err := parseExecutionCacheID(executionCacheID)
+	if err != nil {
+		return err
+	}
+	partitionName, rowID := decodePartitionedID(id)
+	if !s.db.HasTable(partitionName) {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	d := s.db.Table(partitionName).Delete(&partitionRow{}, "ID = ?", rowID)
+	if d.Error != nil {
+		return d.Error
+	}
+	if d.RowsAffected == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with ID: %q", executionCacheID)
+	}
+	return nil
+}
+
+func (s *PartitionedExecutionCacheStore) DeleteExecutionCachesByKey(ctx context.Context, executionCacheKey string) (int64, error) {
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Find(&partitions); d.Error != nil {
+		return 0, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	var deleted int64
+	for _, partition := range partitions {
+		d := s.db.Table(partition.Name).Delete(&partitionRow{}, "ExecutionCacheKey = ?", executionCacheKey)
+		if d.Error != nil {
+			return deleted, fmt.Errorf("Failed to delete from execution cache partition %s: %v", partition.Name, d.Error)
+		}
+		deleted += d.RowsAffected
+	}
+	return deleted, nil
+}
+
+// InvalidateExecutionCaches invalidates the entries of every partition, skipping those created
+// after the selected time range.
+func (s *PartitionedExecutionCacheStore) InvalidateExecutionCaches(ctx context.Context, selector ExecutionCacheSelector, dryRun bool) (*ExecutionCacheInvalidation, error) {
+	if err := selector.validate(); err != nil {
+		return nil, err
+	}
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Order("StartsAtInSec").Find(&partitions); d.Error != nil {
+		return nil, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	invalidation := &ExecutionCacheInvalidation{}
+	for _, partition := range partitions {
+		if selector.OlderThanInSec != 0 && partition.StartsAtInSec >= selector.OlderThanInSec {
+			continue
+		}
+		if err := invalidateExecutionCacheTable(s.db, partition.Name, selector, dryRun, invalidation); err != nil {
+			return invalidation, err
+		}
+	}
+	return invalidation, nil
+}
+
+// DropPartitionsOlderThan drops every partition whose time range ends at or before cutoff and
+// returns the number of dropped partitions.
+func (s *PartitionedExecutionCacheStore) DropPartitionsOlderThan(cutoff time.Time) (int, error) {
+	var partitions []model.ExecutionCachePartition
+	d := s.db.Where("EndsAtInSec <= ?", cutoff.UTC().Unix()).Find(&partitions)
+	if d.Error != nil {
+		return 0, fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, partition := range partitions {
+		if d := s.db.DropTableIfExists(partition.Name); d.Error != nil {
+			return i, fmt.Errorf("Failed to drop execution cache partition %s: %v", partition.Name, d.Error)
+		}
+		if d := s.db.Delete(&model.ExecutionCachePartition{}, "Name = ?", partition.Name); d.Error != nil {
+			return i, fmt.Errorf("Failed to unregister execution cache partition %s: %v", partition.Name, d.Error)
+		}
+		delete(s.knownPartitions, partition.Name)
+		logger.Infof("Dropped execution cache partition %s", partition.Name)
+	}
+	return len(partitions), nil
+}
+
+// MigratePartitionColumns adds the columns and indexes introduced since they were created to the
+// registered partitions. Existing rows get the column defaults.
+func (s *PartitionedExecutionCacheStore) MigratePartitionColumns() error {
+	var partitions []model.ExecutionCachePartition
+	if d := s.db.Find(&partitions); d.Error != nil {
+		return fmt.Errorf("Failed to list execution cache partitions: %v", d.Error)
+	}
+	for _, partition := range partitions {
+		if d := s.db.Table(partition.Name).AutoMigrate(&partitionRow{}); d.Error != nil {
+			return fmt.Errorf("Failed to migrate execution cache partition %s: %v", partition.Name, d.Error)
+		}
+		if err := s.addNamespaceIndex(partition.Name); err != nil {
+			return err
+		}
+		if err := s.addExpiryIndex(partition.Name); err != nil {
+			return err
+		}
+		if err := AddEvictionIndexes(s.db, partition.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNamespaceIndex indexes the namespace of the partition, which the namespace quotas select the
+// entries by, unless it is already.
+func (s *PartitionedExecutionCacheStore) addNamespaceIndex(partitionName string) error {
+	indexName := "idx_" + partitionName + "_namespace"
+	if s.db.Dialect().HasIndex(partitionName, indexName) {
+		return nil
+	}
+	if d := s.db.Table(partitionName).AddIndex(indexName, "Namespace"); d.Error != nil {
+		return fmt.Errorf("Failed to index execution cache partition %s: %v", partitionName, d.Error)
+	}
+	return nil
+}
+
+// MigrateLegacyExecutionCaches moves the rows of the unpartitioned execution_caches table into
+// their monthly partitions in batches, preserving timestamps, and returns the number of migrated
+// rows. Each batch is inserted and deleted from the legacy table in one transaction, so that an
+// interrupted migration leaves every row in exactly one of the tables and can be re-run.
+func (s *PartitionedExecutionCacheStore) MigrateLegacyExecutionCaches(batchSize int) (int, error) {
+	if !s.db.HasTable(legacyExecutionCacheTable) {
+		return 0, nil
+	}
+	migrated := 0
+	for {
+		var executionCaches []model.ExecutionCache
+		d := s.db.Table(legacyExecutionCacheTable).Order("ID").Limit(batchSize).Find(&executionCaches)
+		if d.Error != nil {
+			return migrated, fmt.Errorf("Failed to read legacy execution caches: %v", d.Error)
+		}
+		if len(executionCaches) == 0 {
+			return migrated, nil
+		}
+		if err := s.migrateLegacyBatch(executionCaches); err != nil {
+			return migrated, err
+		}
+		migrated += len(executionCaches)
+	}
+}
+
+func (s *PartitionedExecutionCacheStore) migrateLegacyBatch(executionCaches []model.ExecutionCache) error {
+	// Partitions are created ahead of the transaction, as MySQL commits it on CREATE TABLE.
+	partitionNames := make([]string, len(executionCaches))
+	for i := range executionCaches {
+		partitionName, err := s.ensurePartition(executionCaches[i].StartedAtInSec)
+		if err != nil {
+			return err
+		}
+		partitionNames[i] = partitionName
+	}
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("Failed to start migrating legacy execution caches: %v", tx.Error)
+	}
+	for i := range executionCaches {
+		if _, err := insertIntoPartition(tx, partitionNames[i], &executionCaches[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to migrate legacy execution cache %d: %v", executionCaches[i].ID, err)
+		}
+		if d := tx.Table(legacyExecutionCacheTable).Delete(&model.ExecutionCache{}, "ID = ?", executionCaches[i].ID); d.Error != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to migrate legacy execution cache %d: %v", executionCaches[i].ID, d.Error)
+		}
+	}
+	if d := tx.Commit(); d.Error != nil {
+		return fmt.Errorf("Failed to commit migrated legacy execution caches: %v", d.Error)
+	}
+	return nil
+}
+
+// ensurePartition returns the name of the partition covering the given time, creating and
+// registering it first if needed.
+func (s *PartitionedExecutionCacheStore) ensurePartition(atInSec int64) (string, error) {
+	start, end := monthBounds(atInSec)
+	partitionName := partitionTablePrefix + start.Format("200601")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.knownPartitions[partitionName] {
+		return partitionName, nil
+	}
+	if !s.db.HasTable(partitionName) {
+		if d := s.db.Table(partitionName).CreateTable(&partitionRow{}); d.Error != nil {
+			return "", fmt.Errorf("Failed to create execution cache partition %s: %v", partitionName, d.Error)
+		}
+		if d := s.db.Table(partitionName).AddIndex("idx_"+partitionName+"_cache_key", "ExecutionCacheKey"); d.Error != nil {
+			return "", fmt.Errorf("Failed to index execution cache partition %s: %v", partitionName, d.Error)
+		}
+		if err := s.addNamespaceIndex(partitionName); err != nil {
+			return "", err
+		}
+		if err := s.addExpiryIndex(partitionName); err != nil {
+			return "", err
+		}
+		if err := AddEvictionIndexes(s.db, partitionName); err != nil {
+			return "", err
+		}
+	}
+	partition := model.ExecutionCachePartition{
+		Name:          partitionName,
+		StartsAtInSec: start.Unix(),
+		EndsAtInSec:   end.Unix(),
+	}
+	if d := s.db.Where(model.ExecutionCachePartition{Name: partitionName}).FirstOrCreate(&partition); d.Error != nil {
+		return "", fmt.Errorf("Failed to register execution cache partition %s: %v", partitionName, d.Error)
+	}
+	s.knownPartitions[partitionName] = true
+	return partitionName, nil
+}
+
+func monthBounds(atInSec int64) (time.Time, time.Time) {
+	t := time.Unix(atInSec, 0).UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+func encodePartitionedID(partitionName string, rowID int64) int64 {
+	month, _ := strconv.ParseInt(partitionName[len(partitionTablePrefix):], 10, 64)
+	return month*partitionIDMultiplier + rowID
+}
+
+func decodePartitionedID(id int64) (string, int64) {
+	return fmt.Sprintf("%s%06d", partitionTablePrefix, id/partitionIDMultiplier), id % partitionIDMultiplier
+}
+
+// factory function for partitioned execution cache store. lookback bounds the number of most
+// recent partitions a lookup fans out to.
+func NewPartitionedExecutionCacheStore(db *DB, time util.TimeInterface, lookback int) *PartitionedExecutionCacheStore {
+	if lookback <= 0 {
+		lookback = 1
+	}
+	return &PartitionedExecutionCacheStore{
+		db:              db,
+		time:            time,
+		lookback:        lookback,
+		knownPartitions: make(map[string]bool),
+	}
+}
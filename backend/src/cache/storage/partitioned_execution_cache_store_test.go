@@ -0,0 +1,212 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endOfJanuary is one second before the January/February 2020 partition boundary. The fake clock
+// advances by one second on every read, so consecutive writes straddle the boundary.
+var endOfJanuary = time.Date(2020, time.January, 31, 23, 59, 58, 0, time.UTC)
+
+func TestPartitionedCreateAndGetAcrossPartitionBoundary(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+
+	january, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "januaryOutput"))
+	require.Nil(t, err)
+	february, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "februaryOutput"))
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("januaryOnlyKey", "output"))
+	require.Nil(t, err)
+
+	assert.True(t, db.HasTable("execution_caches_p202001"))
+	assert.True(t, db.HasTable("execution_caches_p202002"))
+	assert.Equal(t, int64(202001000000000001), january.ID)
+	assert.Equal(t, int64(202002000000000001), february.ID)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "februaryOutput", executionCache.ExecutionOutput)
+	assert.Equal(t, february.ID, executionCache.ID)
+
+	require.Nil(t, store.DeleteExecutionCacheByID(context.Background(), strconv.FormatInt(february.ID, 10)))
+	executionCache, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "januaryOutput", executionCache.ExecutionOutput)
+	assert.Equal(t, january.ID, executionCache.ID)
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	_, err = store.GetExecutionCache(context.Background(), "januaryOnlyKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, err)
+}
+
+func TestPartitionedGetExecutionCacheRespectsLookback(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 1)
+
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("januaryKey", "output"))
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("februaryKey", "output"))
+	require.Nil(t, err)
+
+	_, err = store.GetExecutionCache(context.Background(), "februaryKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, err)
+	_, err = store.GetExecutionCache(context.Background(), "januaryKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestPartitionedGetExecutionCacheNotFound(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTimeForEpoch(), 3)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "wrongKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.Contains(t, err.Error(), `Execution cache not found with cache key: "wrongKey"`)
+}
+
+func TestPartitionedDropPartitionsOlderThan(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("januaryKey", "output"))
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("februaryKey", "output"))
+	require.Nil(t, err)
+
+	dropped, err := store.DropPartitionsOlderThan(time.Date(2020, time.February, 15, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.False(t, db.HasTable("execution_caches_p202001"))
+	assert.True(t, db.HasTable("execution_caches_p202002"))
+
+	_, err = store.GetExecutionCache(context.Background(), "januaryKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	_, err = store.GetExecutionCache(context.Background(), "februaryKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, err)
+
+	// Writing into a dropped month recreates its partition.
+	_, err = store.insert(&model.ExecutionCache{ExecutionCacheKey: "late", StartedAtInSec: endOfJanuary.Unix(), EndedAtInSec: endOfJanuary.Unix(), MaxCacheStaleness: -1})
+	require.Nil(t, err)
+	assert.True(t, db.HasTable("execution_caches_p202001"))
+}
+
+func TestPartitionedMigrateLegacyExecutionCaches(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	legacyStore := NewExecutionCacheStore(db, util.NewFakeTime(endOfJanuary))
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := legacyStore.CreateExecutionCache(context.Background(), createExecutionCache(key, "output"))
+		require.Nil(t, err)
+	}
+
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+	migrated, err := store.MigrateLegacyExecutionCaches(2)
+	require.Nil(t, err)
+	assert.Equal(t, 3, migrated)
+
+	var remaining int
+	db.Table("execution_caches").Count(&remaining)
+	assert.Equal(t, 0, remaining)
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := store.GetExecutionCache(context.Background(), key, -1, ExecutionCacheFilter{})
+		assert.Nil(t, err)
+	}
+	executionCache, err := store.GetExecutionCache(context.Background(), "c", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	// "a" lands in January, "b" and "c" in February.
+	assert.Equal(t, endOfJanuary.Unix()+3, executionCache.StartedAtInSec)
+	assert.Equal(t, int64(202002000000000002), executionCache.ID)
+
+	migrated, err = store.MigrateLegacyExecutionCaches(2)
+	require.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestPartitionedMigrateLegacyExecutionCachesResumesAfterAFailedBatch(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	legacyStore := NewExecutionCacheStore(db, util.NewFakeTime(endOfJanuary))
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := legacyStore.CreateExecutionCache(context.Background(), createExecutionCache(key, "output"))
+		require.Nil(t, err)
+	}
+	// Interrupt the migration after "c" is inserted into its partition, before it leaves the
+	// legacy table.
+	require.Nil(t, db.Exec(`CREATE TRIGGER interrupt_migration BEFORE DELETE ON execution_caches
+		WHEN old.ExecutionCacheKey = 'c' BEGIN SELECT RAISE(ABORT, 'interrupted'); END`).Error)
+
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+	migrated, err := store.MigrateLegacyExecutionCaches(2)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "interrupted")
+	assert.Equal(t, 2, migrated)
+	var remaining int
+	db.Table("execution_caches").Count(&remaining)
+	assert.Equal(t, 1, remaining)
+	var partitioned int
+	db.Table("execution_caches_p202002").Count(&partitioned)
+	assert.Equal(t, 1, partitioned, "the insert of the failed batch is rolled back")
+
+	require.Nil(t, db.Exec("DROP TRIGGER interrupt_migration").Error)
+	migrated, err = store.MigrateLegacyExecutionCaches(2)
+	require.Nil(t, err)
+	assert.Equal(t, 1, migrated)
+	db.Table("execution_caches").Count(&remaining)
+	assert.Equal(t, 0, remaining)
+	db.Table("execution_caches_p202002").Count(&partitioned)
+	assert.Equal(t, 2, partitioned, "every row is migrated exactly once")
+}
+
+func TestPartitionedMigratePartitionColumns(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	// A partition created before execution durations and provenance were recorded.
+	require.Nil(t, db.Exec(`CREATE TABLE execution_caches_p202001 (ID integer primary key autoincrement,
+		ExecutionCacheKey varchar(255) not null, ExecutionTemplate text not null, ExecutionOutput text,
+		MaxCacheStaleness bigint not null, StartedAtInSec bigint not null, EndedAtInSec bigint not null,
+		Owner varchar(255) not null default '')`).Error)
+	require.Nil(t, db.Exec(`INSERT INTO execution_caches_p202001 (ExecutionCacheKey, ExecutionTemplate, ExecutionOutput,
+		MaxCacheStaleness, StartedAtInSec, EndedAtInSec) VALUES ('testKey', 'template', 'output', -1, ?, ?)`,
+		endOfJanuary.Unix(), endOfJanuary.Unix()).Error)
+	require.Nil(t, db.Create(&model.ExecutionCachePartition{
+		Name:          "execution_caches_p202001",
+		StartsAtInSec: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		EndsAtInSec:   time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC).Unix(),
+	}).Error)
+	store := NewPartitionedExecutionCacheStore(db, util.NewFakeTime(endOfJanuary), 3)
+
+	require.Nil(t, store.MigratePartitionColumns())
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "output", executionCache.ExecutionOutput)
+	assert.Equal(t, int64(0), executionCache.ExecutionDurationInSec)
+	assert.Equal(t, "", executionCache.PipelineName)
+}
@@ -0,0 +1,477 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+)
+
+const (
+	DefaultRedisKeyPrefix        string        = "cache:"
+	DefaultRedisListMaxPageSize  int           = 1000
+	DefaultRedisOperationTimeout time.Duration = 200 * time.Millisecond
+
+	// redisIDKeyInfix marks the keys indexing entries by ID. It cannot clash with cache keys, which
+	// are hex encoded hashes.
+	redisIDKeyInfix = "id:"
+
+	redisFieldID                = "id"
+	redisFieldTemplate          = "template"
+	redisFieldOutput            = "output"
+	redisFieldMaxCacheStaleness = "maxCacheStaleness"
+	redisFieldStartedAtInSec    = "startedAtInSec"
+	redisFieldEndedAtInSec      = "endedAtInSec"
+	redisFieldOwner             = "owner"
+	// redisFieldExecutionDuration is missing from the entries written before it was introduced.
+	redisFieldExecutionDuration = "executionDurationInSec"
+	redisFieldPipelineName      = "pipelineName"
+	redisFieldRunID             = "runId"
+	redisFieldNamespace         = "namespace"
+	// redisFieldLastUsedAtInSec is missing from the entries written before it was introduced.
+	redisFieldLastUsedAtInSec = "lastUsedAtInSec"
+	redisFieldClusterID       = "clusterId"
+	// redisFieldKeyVersion is missing from the entries written before it was introduced, which
+	// are of the legacy key version.
+	redisFieldKeyVersion = "keyVersion"
+	// redisFieldPipelineVersionID is missing from the entries written before it was introduced.
+	redisFieldPipelineVersionID = "pipelineVersionId"
+	// redisFieldExpiresAtInSec is missing from the entries written before it was introduced, which
+	// never expire.
+	redisFieldExpiresAtInSec = "expiresAtInSec"
+)
+
+// redisPutScript replaces the hash in KEYS[1] and maps the ID in KEYS[2] to it. Both expire after
+// ARGV[1] seconds, or persist when that is negative. The remaining arguments are field value pairs.
+const redisPutScript = `
+redis.call("DEL", KEYS[1])
+redis.call("HMSET", KEYS[1], unpack(ARGV, 2))
+redis.call("SET", KEYS[2], KEYS[1])
+local ttl = tonumber(ARGV[1])
+if ttl >= 0 then
+	redis.call("EXPIRE", KEYS[1], ttl)
+	redis.call("EXPIRE", KEYS[2], ttl)
+else
+	redis.call("PERSIST", KEYS[1])
+	redis.call("PERSIST", KEYS[2])
+end
+return 1
+`
+
+// redisDeleteIfIDScript deletes the hash in KEYS[1] if its id field equals ARGV[1].
+const redisDeleteIfIDScript = `
+if redis.call("HGET", KEYS[1], "id") == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// redisCreateIfAbsentScript writes the hash in KEYS[1] unless the key exists and sets its expiry to
+// ARGV[1] seconds unless that is negative. The remaining arguments are field value pairs. When
+// given, the set in KEYS[2] indexes the hash among the entries of its cache key, and lives at least
+// as long as it. It returns 1 when the hash was written.
+const redisCreateIfAbsentScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HMSET", KEYS[1], unpack(ARGV, 2))
+local ttl = tonumber(ARGV[1])
+if ttl >= 0 then
+	redis.call("EXPIRE", KEYS[1], ttl)
+end
+if #KEYS > 1 then
+	local remaining = redis.call("TTL", KEYS[2])
+	redis.call("SADD", KEYS[2], KEYS[1])
+	if ttl < 0 then
+		redis.call("PERSIST", KEYS[2])
+	elseif remaining == -2 or (remaining >= 0 and remaining < ttl) then
+		redis.call("EXPIRE", KEYS[2], ttl)
+	end
+end
+return 1
+`
+
+// RedisExecutionCacheStore keeps one hash per entry name, see EntryScope. The expiry of the Redis
+// key follows the MaxCacheStaleness of the entry, so Redis drops stale entries on its own. The
+// entries named after their owner or cluster are indexed by a set per cache key, so that they can
+// be found without scanning the keyspace.
+type RedisExecutionCacheStore struct {
+	client           client.RedisClientInterface
+	keyPrefix        string
+	scope            EntryScope
+	operationTimeout time.Duration
+	time             util.TimeInterface
+}
+
+func (s *RedisExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	executionCache, err := s.getExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+	if client.IsRedisTimeout(err) {
+		// A slow Redis must not hold up admissions, so the lookup counts as a miss.
+		logging.WithContext(logger, ctx).WithField(logging.FieldCacheKey, executionCacheKey).Warnf("Redis lookup timed out: %v", err)
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return executionCache, err
+}
+
+// getExecutionCache is GetExecutionCache without turning timeouts into misses, so that the
+// write-through store can count them as Redis failures. It returns the first live entry matching
+// the filter among the names of the lookup, most specific first, or the newest one when the names
+// of the cache key have to be listed.
+func (s *RedisExecutionCacheStore) getExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (executionCache *model.ExecutionCache, err error) {
+	ctx, span := tracer.Start(ctx, tracing.SpanRedisLookup)
+	defer func() { endLookupSpan(span, err) }()
+	if maxCacheStaleness == 0 {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "MaxCacheStaleness=0, Cache is disabled.")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	names, direct := s.scope.lookupNames(executionCacheKey, filter)
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, s.key(name))
+	}
+	if !direct {
+		if keys, err = s.entryKeys(ctx, executionCacheKey); err != nil {
+			return nil, err
+		}
+	}
+	var found *model.ExecutionCache
+	for _, key := range keys {
+		fields, err := s.client.HGetAll(ctx, key).Result()
+		if client.IsRedisTimeout(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get execution cache: %q: %v", executionCacheKey, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		executionCache, err := decodeRedisExecutionCache(executionCacheKey, fields)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.matches(executionCache) || !isExecutionCacheFresh(executionCache, maxCacheStaleness, s.time.Now().UTC().Unix()) {
+			continue
+		}
+		if direct {
+			return executionCache, nil
+		}
+		if found == nil || executionCache.ID > found.ID {
+			found = executionCache
+		}
+	}
+	if found == nil {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return found, nil
+}
+
+// CreateExecutionCache atomically writes the entry unless one of the same name exists, so entries
+// of other owners or clusters in scope do not get in the way. Stale entries do not either since
+// Redis already expired them.
+func (s *RedisExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	now := s.time.Now().UTC()
+	newExecutionCache := *executionCache
+	newExecutionCache.ID = now.UnixNano()
+	newExecutionCache.StartedAtInSec = now.Unix()
+	newExecutionCache.EndedAtInSec = now.Unix()
+	newExecutionCache.LastUsedAtInSec = now.Unix()
+
+	ttl, live := redisTTL(&newExecutionCache, now.Unix())
+	if !live {
+		// An entry without staleness budget is stale right away, so there is nothing to keep.
+		return nil, ErrExecutionCacheNotCached
+	}
+	args := append([]interface{}{ttl}, encodeRedisExecutionCache(&newExecutionCache)...)
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	keys := []string{s.key(s.scope.name(executionCache))}
+	if keys[0] != s.key(executionCache.ExecutionCacheKey) {
+		keys = append(keys, s.indexKey(executionCache.ExecutionCacheKey))
+	}
+	created, err := s.client.Eval(ctx, redisCreateIfAbsentScript, keys, args...).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create execution cache %q: %v", executionCache.ExecutionCacheKey, err)
+	}
+	if created == 0 {
+		return nil, util.NewAlreadyExistError("Execution cache with cache key %q already exists", executionCache.ExecutionCacheKey)
+	}
+	logging.WithContext(logger, ctx).WithField(logging.FieldCacheKey, newExecutionCache.ExecutionCacheKey).Debug("Cache entry created")
+	return &newExecutionCache, nil
+}
+
+// DeleteExecutionCache deletes the entries of the cache key, whatever their owner or cluster.
+func (s *RedisExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	keys, err := s.entryKeys(ctx, executionCacheKey)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.client.Del(ctx, keys...).Result()
+	if err != nil {
+		return fmt.Errorf("Failed to delete execution cache %q: %v", executionCacheKey, err)
+	}
+	if err := s.client.Del(ctx, s.indexKey(executionCacheKey)).Err(); err != nil {
+		return fmt.Errorf("Failed to delete execution cache %q: %v", executionCacheKey, err)
+	}
+	if deleted == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return nil
+}
+
+// ListExecutionCaches returns a page of entries whose cache key starts with keyPrefix and that pass
+// the filter, together with the token for the next page. It walks the keyspace with SCAN, so a page
+// may hold fewer entries than the page size, and an empty next page token means the listing is
+// complete. In cluster mode only the keys of a single node are listed.
+func (s *RedisExecutionCacheStore) ListExecutionCaches(ctx context.Context, keyPrefix string, filter ExecutionCacheFilter, pageSize int, pageToken string) ([]*model.ExecutionCache, string, error) {
+	if pageSize <= 0 || pageSize > DefaultRedisListMaxPageSize {
+		pageSize = DefaultRedisListMaxPageSize
+	}
+	var cursor uint64
+	if pageToken != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(pageToken, 10, 64); err != nil {
+			return nil, "", util.NewInvalidInputError("Invalid page token %q", pageToken)
+		}
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	keys, nextCursor, err := s.client.Scan(scanCtx, cursor, escapeRedisPattern(s.key(keyPrefix))+"*", int64(pageSize)).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to list execution caches: %v", err)
+	}
+	var executionCaches []*model.ExecutionCache
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, s.keyPrefix)
+		if strings.HasPrefix(name, redisIDKeyInfix) || strings.HasSuffix(name, entryScopeSeparator) {
+			continue
+		}
+		executionCacheKey := entryNameCacheKey(name)
+		fields, err := s.hGetAll(ctx, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to get execution cache: %q: %v", executionCacheKey, err)
+		}
+		// The key may have expired between the scan and the read.
+		if len(fields) == 0 {
+			continue
+		}
+		executionCache, err := decodeRedisExecutionCache(executionCacheKey, fields)
+		if err != nil {
+			return nil, "", err
+		}
+		if filter.matches(executionCache) {
+			executionCaches = append(executionCaches, executionCache)
+		}
+	}
+	nextPageToken := ""
+	if nextCursor != 0 {
+		nextPageToken = strconv.FormatUint(nextCursor, 10)
+	}
+	return executionCaches, nextPageToken, nil
+}
+
+// putExecutionCache stores a copy of an entry of another store, keeping its ID and timestamps, and
+// indexes it by ID so that invalidateExecutionCache can find it. Expired entries are not stored.
+func (s *RedisExecutionCacheStore) putExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) error {
+	ttl, live := redisTTL(executionCache, s.time.Now().UTC().Unix())
+	if !live {
+		return nil
+	}
+	idKey := s.idKey(strconv.FormatInt(executionCache.ID, 10))
+	args := append([]interface{}{ttl}, encodeRedisExecutionCache(executionCache)...)
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	return s.client.Eval(ctx, redisPutScript, []string{s.key(executionCache.ExecutionCacheKey), idKey}, args...).Err()
+}
+
+// invalidateExecutionCache removes the copy stored by putExecutionCache for the given ID, unless it
+// has been replaced by a newer entry in the meantime.
+func (s *RedisExecutionCacheStore) invalidateExecutionCache(ctx context.Context, executionCacheID string) error {
+	idKey := s.idKey(executionCacheID)
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	key, err := s.client.Get(ctx, idKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.client.Eval(ctx, redisDeleteIfIDScript, []string{key}, executionCacheID).Err(); err != nil && err != redis.Nil {
+		return err
+	}
+	return s.client.Del(ctx, idKey).Err()
+}
+
+// ping checks that Redis answers within the operation timeout.
+func (s *RedisExecutionCacheStore) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+// hGetAll reads a hash within its own operation timeout.
+func (s *RedisExecutionCacheStore) hGetAll(ctx context.Context, key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	defer cancel()
+	return s.client.HGetAll(ctx, key).Result()
+}
+
+// entryKeys returns the keys of the entries of the cache key, that named after the cache key alone
+// first, whether they still exist or not.
+func (s *RedisExecutionCacheStore) entryKeys(ctx context.Context, executionCacheKey string) ([]string, error) {
+	indexed, err := s.client.SMembers(ctx, s.indexKey(executionCacheKey)).Result()
+	if client.IsRedisTimeout(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list execution caches: %q: %v", executionCacheKey, err)
+	}
+	return append([]string{s.key(executionCacheKey)}, indexed...), nil
+}
+
+// indexKey is the key of the set indexing the entries of the cache key named after their owner or
+// cluster. It ends with the scope separator, unlike the keys of the entries.
+func (s *RedisExecutionCacheStore) indexKey(executionCacheKey string) string {
+	return s.keyPrefix + scopedEntryNamePrefix(executionCacheKey)
+}
+
+func (s *RedisExecutionCacheStore) idKey(executionCacheID string) string {
+	return s.keyPrefix + redisIDKeyInfix + executionCacheID
+}
+
+// redisTTL returns the remaining lifetime in seconds of the entry, so that the Redis copy expires
+// exactly when the entry goes stale or its time-to-live ends, or -1 for entries that never do.
+// The second result is false when the entry has already expired.
+func redisTTL(executionCache *model.ExecutionCache, nowInSec int64) (int64, bool) {
+	if executionCache.MaxCacheStaleness < 0 && executionCache.ExpiresAtInSec == 0 {
+		return -1, true
+	}
+	ttl := executionCache.ExpiresAtInSec - nowInSec
+	if executionCache.MaxCacheStaleness >= 0 {
+		staleIn := executionCache.EndedAtInSec + executionCache.MaxCacheStaleness - nowInSec
+		if executionCache.ExpiresAtInSec == 0 || staleIn < ttl {
+			ttl = staleIn
+		}
+	}
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+func (s *RedisExecutionCacheStore) key(name string) string {
+	return s.keyPrefix + name
+}
+
+func encodeRedisExecutionCache(executionCache *model.ExecutionCache) []interface{} {
+	return []interface{}{
+		redisFieldID, executionCache.ID,
+		redisFieldTemplate, executionCache.ExecutionTemplate,
+		redisFieldOutput, executionCache.ExecutionOutput,
+		redisFieldMaxCacheStaleness, executionCache.MaxCacheStaleness,
+		redisFieldStartedAtInSec, executionCache.StartedAtInSec,
+		redisFieldEndedAtInSec, executionCache.EndedAtInSec,
+		redisFieldOwner, executionCache.Owner,
+		redisFieldExecutionDuration, executionCache.ExecutionDurationInSec,
+		redisFieldPipelineName, executionCache.PipelineName,
+		redisFieldRunID, executionCache.RunID,
+		redisFieldNamespace, executionCache.Namespace,
+		redisFieldLastUsedAtInSec, executionCache.LastUsedAtInSec,
+		redisFieldClusterID, executionCache.ClusterID,
+		redisFieldKeyVersion, executionCache.KeyVersion,
+		redisFieldPipelineVersionID, executionCache.PipelineVersionID,
+		redisFieldExpiresAtInSec, executionCache.ExpiresAtInSec,
+	}
+}
+
+func decodeRedisExecutionCache(executionCacheKey string, fields map[string]string) (*model.ExecutionCache, error) {
+	executionCache := &model.ExecutionCache{
+		ExecutionCacheKey: executionCacheKey,
+		ExecutionTemplate: fields[redisFieldTemplate],
+		ExecutionOutput:   fields[redisFieldOutput],
+		Owner:             fields[redisFieldOwner],
+		PipelineName:      fields[redisFieldPipelineName],
+		PipelineVersionID: fields[redisFieldPipelineVersionID],
+		RunID:             fields[redisFieldRunID],
+		Namespace:         fields[redisFieldNamespace],
+		ClusterID:         fields[redisFieldClusterID],
+		KeyVersion:        fields[redisFieldKeyVersion],
+	}
+	for field, value := range map[string]*int64{
+		redisFieldID:                &executionCache.ID,
+		redisFieldMaxCacheStaleness: &executionCache.MaxCacheStaleness,
+		redisFieldStartedAtInSec:    &executionCache.StartedAtInSec,
+		redisFieldEndedAtInSec:      &executionCache.EndedAtInSec,
+	} {
+		parsed, err := strconv.ParseInt(fields[field], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode execution cache: %q: invalid %s %q", executionCacheKey, field, fields[field])
+		}
+		*value = parsed
+	}
+	for field, value := range map[string]*int64{
+		redisFieldExecutionDuration: &executionCache.ExecutionDurationInSec,
+		redisFieldLastUsedAtInSec:   &executionCache.LastUsedAtInSec,
+		redisFieldExpiresAtInSec:    &executionCache.ExpiresAtInSec,
+	} {
+		if encoded, ok := fields[field]; ok {
+			parsed, err := strconv.ParseInt(encoded, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode execution cache: %q: invalid %s %q", executionCacheKey, field, encoded)
+			}
+			*value = parsed
+		}
+	}
+	return executionCache, nil
+}
+
+// escapeRedisPattern escapes the glob characters of a SCAN MATCH pattern.
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// factory function for Redis execution cache store. Every call gives up on Redis after the
+// operation timeout, on top of the deadline of its context.
+func NewRedisExecutionCacheStore(client client.RedisClientInterface, keyPrefix string, scope EntryScope, operationTimeout time.Duration, time util.TimeInterface) *RedisExecutionCacheStore {
+	return &RedisExecutionCacheStore{
+		client:           client,
+		keyPrefix:        keyPrefix,
+		scope:            scope,
+		operationTimeout: operationTimeout,
+		time:             time,
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/cache/tracing"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+)
+
+func newMiniredisExecutionCacheStore(t *testing.T) (*RedisExecutionCacheStore, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	require.Nil(t, err)
+	redisClient := client.NewRedisClientInterface(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	t.Cleanup(func() {
+		redisClient.Close()
+		server.Close()
+	})
+	return NewRedisExecutionCacheStore(redisClient, DefaultRedisKeyPrefix, EntryScope{}, DefaultRedisOperationTimeout, util.NewFakeTimeForEpoch()), server
+}
+
+// newUnresponsiveRedisAddress returns the address of a listener that accepts connections but never
+// answers, like a hung Redis server.
+func newUnresponsiveRedisAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	var conns []net.Conn
+	var mutex sync.Mutex
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, conn)
+			mutex.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return listener.Addr().String()
+}
+
+func TestRedisCreateAndGetExecutionCache(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.Owner = "alice"
+	executionCacheToPersist.ExecutionDurationInSec = 90
+	executionCacheToPersist.PipelineName = "train"
+	executionCacheToPersist.PipelineVersionID = "train-v1"
+	executionCacheToPersist.RunID = "run-1"
+
+	created, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), created.StartedAtInSec)
+	assert.True(t, server.Exists("cache:testKey"))
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, executionCache)
+}
+
+func TestRedisGetExecutionCacheWithoutDuration(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	// Entries written before durations were recorded.
+	server.HDel("cache:testKey", redisFieldExecutionDuration)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), executionCache.ExecutionDurationInSec)
+}
+
+func TestRedisGetExecutionCacheNotFound(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "wrongKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	assert.Contains(t, err.Error(), `Execution cache not found with cache key: "wrongKey"`)
+}
+
+func TestRedisCreateExecutionCacheIfAbsent(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	executionCache, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput2"))
+	assert.Nil(t, executionCache)
+	assert.True(t, util.IsUserErrorCodeMatch(err, codes.AlreadyExists))
+
+	stored, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", stored.ExecutionOutput)
+}
+
+func TestRedisExecutionCacheExpiresWithMaxCacheStaleness(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.MaxCacheStaleness = 100
+	_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+	assert.Equal(t, 100*time.Second, server.TTL("cache:testKey"))
+
+	server.FastForward(101 * time.Second)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+
+	// The expired entry no longer blocks a new one.
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput2"))
+	require.Nil(t, err)
+	assert.Equal(t, time.Duration(0), server.TTL("cache:testKey"))
+}
+
+func TestRedisCreateExecutionCacheSkipsEntryWithoutStalenessBudget(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.MaxCacheStaleness = 0
+
+	created, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	assert.Equal(t, ErrExecutionCacheNotCached, err)
+	assert.Nil(t, created)
+	assert.False(t, server.Exists("cache:testKey"))
+}
+
+func TestRedisGetExecutionCacheWithLargeOutput(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+	largeOutput := strings.Repeat("x", 8*1024*1024)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", largeOutput))
+	require.Nil(t, err)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, largeOutput, executionCache.ExecutionOutput)
+}
+
+func TestRedisGetExecutionCacheWithOwnerFilter(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.Owner = "alice"
+	_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{EnforceOwner: true, Owner: "alice"})
+	assert.Nil(t, err)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{EnforceOwner: true, Owner: "bob"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestRedisGetExecutionCacheWithClusterFilter(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.ClusterID = "us-east"
+	_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{ClusterID: "us-east"})
+	require.Nil(t, err)
+	assert.Equal(t, "us-east", executionCache.ClusterID)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{ClusterID: "eu-west"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestRedisGetExecutionCacheWithKeyVersionFilter(t *testing.T) {
+	store, _ := newMiniredisExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.KeyVersion = "2"
+	_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{KeyVersion: "2"})
+	require.Nil(t, err)
+	assert.Equal(t, "2", executionCache.KeyVersion)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{KeyVersion: model.LegacyKeyVersion})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestRedisDeleteExecutionCache(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	assert.False(t, server.Exists("cache:testKey"))
+	err = store.DeleteExecutionCache(context.Background(), "testKey")
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestRedisCreateExecutionCacheOfEachOwnerAndCluster(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	store.scope = EntryScope{Owner: true, Cluster: true}
+	for _, entry := range []struct {
+		owner, cluster    string
+		maxCacheStaleness int64
+	}{{"alice", "us-east", 100}, {"bob", "us-east", 300}, {"alice", "eu-west", 200}} {
+		executionCacheToPersist := createExecutionCache("testKey", entry.owner+"@"+entry.cluster)
+		executionCacheToPersist.Owner = entry.owner
+		executionCacheToPersist.ClusterID = entry.cluster
+		executionCacheToPersist.MaxCacheStaleness = entry.maxCacheStaleness
+		_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+		require.Nil(t, err)
+	}
+	// The index of the entries of the cache key outlives all of them.
+	assert.Equal(t, 300*time.Second, server.TTL("cache:testKey@"))
+	duplicate := createExecutionCache("testKey", "testOutput")
+	duplicate.Owner = "bob"
+	duplicate.ClusterID = "us-east"
+	_, err := store.CreateExecutionCache(context.Background(), duplicate)
+	assert.True(t, util.IsUserErrorCodeMatch(err, codes.AlreadyExists))
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{EnforceOwner: true, Owner: "bob", ClusterID: "us-east"})
+	require.Nil(t, err)
+	assert.Equal(t, "bob@us-east", executionCache.ExecutionOutput)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{EnforceOwner: true, Owner: "bob", ClusterID: "eu-west"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	// Lookups of any cluster read the entries of the index and serve the newest.
+	executionCache, err = store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{EnforceOwner: true, Owner: "alice"})
+	require.Nil(t, err)
+	assert.Equal(t, "alice@eu-west", executionCache.ExecutionOutput)
+
+	executionCaches, _, err := store.ListExecutionCaches(context.Background(), "test", ExecutionCacheFilter{}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, executionCaches, 3)
+	for _, executionCache := range executionCaches {
+		assert.Equal(t, "testKey", executionCache.ExecutionCacheKey)
+	}
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	assert.Empty(t, server.Keys())
+}
+
+func TestRedisListExecutionCachesWithPagination(t *testing.T) {
+	store, server := newMiniredisExecutionCacheStore(t)
+	for i := 0; i < 5; i++ {
+		_, err := store.CreateExecutionCache(context.Background(), createExecutionCache(fmt.Sprintf("key%d", i), "testOutput"))
+		require.Nil(t, err)
+	}
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("other", "testOutput"))
+	require.Nil(t, err)
+	server.Set("unrelated", "value")
+
+	var keys []string
+	pageToken := ""
+	for {
+		executionCaches, nextPageToken, err := store.ListExecutionCaches(context.Background(), "key", ExecutionCacheFilter{}, 2, pageToken)
+		require.Nil(t, err)
+		for _, executionCache := range executionCaches {
+			keys = append(keys, executionCache.ExecutionCacheKey)
+		}
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+	assert.ElementsMatch(t, []string{"key0", "key1", "key2", "key3", "key4"}, keys)
+
+	_, _, err = store.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 2, "not-a-cursor")
+	assert.True(t, util.IsUserErrorCodeMatch(err, codes.InvalidArgument))
+}
+
+func TestRedisGetExecutionCacheTimeoutIsMiss(t *testing.T) {
+	redisClient := client.NewRedisClientInterface(redis.NewClient(&redis.Options{Addr: newUnresponsiveRedisAddress(t)}))
+	defer redisClient.Close()
+	store := NewRedisExecutionCacheStore(redisClient, DefaultRedisKeyPrefix, EntryScope{}, 200*time.Millisecond, util.NewFakeTimeForEpoch())
+
+	start := time.Now()
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	assert.True(t, time.Since(start) < time.Second, "lookup took %v", time.Since(start))
+
+	// The deadline of the caller applies as well.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = store.GetExecutionCache(ctx, "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	assert.True(t, time.Since(start) < 150*time.Millisecond, "lookup took %v", time.Since(start))
+
+	// Writes still report the failure.
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	assert.NotNil(t, err)
+}
+
+func TestRedisLookupSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	SetTracerProvider(provider)
+	defer SetTracerProvider(tracing.NoopTracerProvider())
+	store, _ := newMiniredisExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+
+	_, err = store.GetExecutionCache(ctx, "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	_, err = store.GetExecutionCache(ctx, "wrongKey", -1, ExecutionCacheFilter{})
+	require.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	unresponsiveClient := client.NewRedisClientInterface(redis.NewClient(&redis.Options{Addr: newUnresponsiveRedisAddress(t)}))
+	defer unresponsiveClient.Close()
+	unresponsiveStore := NewRedisExecutionCacheStore(unresponsiveClient, DefaultRedisKeyPrefix, EntryScope{}, 50*time.Millisecond, util.NewFakeTimeForEpoch())
+	_, err = unresponsiveStore.GetExecutionCache(ctx, "testKey", -1, ExecutionCacheFilter{})
+	require.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	for _, span := range spans[:3] {
+		assert.Equal(t, tracing.SpanRedisLookup, span.Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+	// Misses are not failures, but timeouts are even though the caller sees a miss.
+	assert.Equal(t, otelcodes.Unset, spans[0].Status.Code)
+	assert.Equal(t, otelcodes.Unset, spans[1].Status.Code)
+	assert.Equal(t, otelcodes.Error, spans[2].Status.Code)
+}
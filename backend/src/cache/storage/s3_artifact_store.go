@@ -0,0 +1,45 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "fmt"
+
+// S3ArtifactStore tells whether the artifacts of cache entries still exist in the object store
+// they were uploaded to, e.g. once lifecycle policies expired them.
+type S3ArtifactStore struct {
+	client S3ClientInterface
+	// defaultBucket is the bucket of the artifacts whose location does not name one.
+	defaultBucket string
+}
+
+// ArtifactExists reports whether the object of the key exists in the bucket, the default bucket
+// when empty, with a HEAD request.
+func (s *S3ArtifactStore) ArtifactExists(bucket string, key string) (bool, error) {
+	if bucket == "" {
+		bucket = s.defaultBucket
+	}
+	if _, err := s.client.StatObject(bucket, key); err != nil {
+		if isS3NotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Failed to stat artifact %s/%s: %v", bucket, key, err)
+	}
+	return true, nil
+}
+
+// factory function for an S3 artifact store
+func NewS3ArtifactStore(client S3ClientInterface, defaultBucket string) *S3ArtifactStore {
+	return &S3ArtifactStore{client: client, defaultBucket: defaultBucket}
+}
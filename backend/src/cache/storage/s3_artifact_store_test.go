@@ -0,0 +1,55 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	minio "github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableS3Client fails every stat, as an unreachable object store does.
+type unavailableS3Client struct {
+	*FakeS3Client
+}
+
+func (c unavailableS3Client) StatObject(bucketName, objectName string) (minio.ObjectInfo, error) {
+	return minio.ObjectInfo{}, errors.New("connection refused")
+}
+
+func TestS3ArtifactStoreArtifactExists(t *testing.T) {
+	client := NewFakeS3Client()
+	_, err := client.PutObject("mlpipeline", "artifacts/live.tgz", bytes.NewReader([]byte("data")), 4, minio.PutObjectOptions{})
+	require.Nil(t, err)
+	store := NewS3ArtifactStore(client, "mlpipeline")
+
+	exists, err := store.ArtifactExists("mlpipeline", "artifacts/live.tgz")
+	require.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = store.ArtifactExists("", "artifacts/live.tgz")
+	require.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = store.ArtifactExists("mlpipeline", "artifacts/expired.tgz")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	exists, err = NewS3ArtifactStore(unavailableS3Client{client}, "mlpipeline").ArtifactExists("mlpipeline", "artifacts/live.tgz")
+	assert.NotNil(t, err, "failures are not taken for missing artifacts")
+	assert.False(t, exists)
+}
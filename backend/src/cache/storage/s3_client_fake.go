@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	minio "github.com/minio/minio-go"
+)
+
+type FakeS3Client struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func NewFakeS3Client() *FakeS3Client {
+	return &FakeS3Client{
+		objects: make(map[string][]byte),
+	}
+}
+
+func (c *FakeS3Client) PutObject(bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error) {
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.objects[objectName] = b
+	return int64(len(b)), nil
+}
+
+func (c *FakeS3Client) GetObject(bucketName, objectName string) (io.ReadCloser, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	b, ok := c.objects[objectName]
+	if !ok {
+		return nil, minio.ErrorResponse{Code: s3NoSuchKeyErrorCode, Key: objectName}
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (c *FakeS3Client) StatObject(bucketName, objectName string) (minio.ObjectInfo, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	b, ok := c.objects[objectName]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: s3NoSuchKeyErrorCode, Key: objectName}
+	}
+	return minio.ObjectInfo{Key: objectName, Size: int64(len(b))}, nil
+}
+
+func (c *FakeS3Client) RemoveObject(bucketName, objectName string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.objects, objectName)
+	return nil
+}
+
+// ListObjectsV2 pages through the objects in key order. The continuation token is the last key of
+// the previous page, which is opaque to callers just like the real S3 token.
+func (c *FakeS3Client) ListObjectsV2(bucketName, prefix, continuationToken string, maxKeys int) (minio.ListBucketV2Result, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var keys []string
+	for key := range c.objects {
+		if strings.HasPrefix(key, prefix) && key > continuationToken {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := minio.ListBucketV2Result{ContinuationToken: continuationToken}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, minio.ObjectInfo{Key: key, Size: int64(len(c.objects[key]))})
+	}
+	return result, nil
+}
+
+func (c *FakeS3Client) GetObjectCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.objects)
+}
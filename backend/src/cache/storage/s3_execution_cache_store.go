@@ -0,0 +1,279 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	minio "github.com/minio/minio-go"
+)
+
+const (
+	s3NoSuchKeyErrorCode     string = "NoSuchKey"
+	s3ObjectSuffix           string = ".json"
+	DefaultS3ListMaxPageSize int    = 1000
+)
+
+// Create interface for the S3 client, making it more unit testable.
+type S3ClientInterface interface {
+	PutObject(bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error)
+	GetObject(bucketName, objectName string) (io.ReadCloser, error)
+	StatObject(bucketName, objectName string) (minio.ObjectInfo, error)
+	RemoveObject(bucketName, objectName string) error
+	ListObjectsV2(bucketName, prefix, continuationToken string, maxKeys int) (minio.ListBucketV2Result, error)
+}
+
+type MinioS3Client struct {
+	Core *minio.Core
+}
+
+func (c *MinioS3Client) PutObject(bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error) {
+	return c.Core.Client.PutObject(bucketName, objectName, reader, objectSize, opts)
+}
+
+func (c *MinioS3Client) GetObject(bucketName, objectName string) (io.ReadCloser, error) {
+	reader, _, err := c.Core.GetObject(bucketName, objectName, minio.GetObjectOptions{})
+	return reader, err
+}
+
+func (c *MinioS3Client) StatObject(bucketName, objectName string) (minio.ObjectInfo, error) {
+	return c.Core.StatObject(bucketName, objectName, minio.StatObjectOptions{})
+}
+
+func (c *MinioS3Client) RemoveObject(bucketName, objectName string) error {
+	return c.Core.RemoveObject(bucketName, objectName)
+}
+
+func (c *MinioS3Client) ListObjectsV2(bucketName, prefix, continuationToken string, maxKeys int) (minio.ListBucketV2Result, error) {
+	return c.Core.ListObjectsV2(bucketName, prefix, continuationToken, false, "", maxKeys, "")
+}
+
+// S3ExecutionCacheStore keeps one JSON encoded model.ExecutionCache object per entry name, see
+// EntryScope, under a configurable prefix of an S3-compatible bucket.
+type S3ExecutionCacheStore struct {
+	client     S3ClientInterface
+	bucketName string
+	prefix     string
+	scope      EntryScope
+	time       util.TimeInterface
+}
+
+// GetExecutionCache returns the first live entry matching the filter among the names of the lookup,
+// most specific first, or the newest one when the names of the cache key have to be listed.
+func (s *S3ExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if maxCacheStaleness == 0 {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "MaxCacheStaleness=0, Cache is disabled.")
+	}
+	names, direct := s.scope.lookupNames(executionCacheKey, filter)
+	if !direct {
+		var err error
+		if names, err = s.entryNames(executionCacheKey); err != nil {
+			return nil, err
+		}
+	}
+	var found *model.ExecutionCache
+	for _, name := range names {
+		executionCache, err := s.getObject(name)
+		if util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !filter.matches(executionCache) || !isExecutionCacheFresh(executionCache, maxCacheStaleness, s.time.Now().UTC().Unix()) {
+			continue
+		}
+		if direct {
+			return executionCache, nil
+		}
+		if found == nil || executionCache.ID > found.ID {
+			found = executionCache
+		}
+	}
+	if found == nil {
+		return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return found, nil
+}
+
+// CreateExecutionCache rejects the write when a live entry of the same name already exists, so
+// entries of other owners or clusters in scope do not get in the way. Entries that expired under
+// their own MaxCacheStaleness count as absent so that a fresh execution can replace them. The check
+// and the put are not atomic, as minio-go cannot send If-None-Match: two watchers recording the
+// same entry at once both succeed and the last writer wins. Either entry holds the outputs of an
+// execution with that cache key, so lookups serve whichever remains.
+func (s *S3ExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	now := s.time.Now().UTC()
+	name := s.scope.name(executionCache)
+	existing, err := s.getObject(name)
+	if err == nil && isExecutionCacheFresh(existing, existing.MaxCacheStaleness, now.Unix()) {
+		return nil, util.NewAlreadyExistError("Execution cache with cache key %q already exists", executionCache.ExecutionCacheKey)
+	}
+	if err != nil && !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+		return nil, err
+	}
+
+	newExecutionCache := *executionCache
+	newExecutionCache.ID = now.UnixNano()
+	newExecutionCache.StartedAtInSec = now.Unix()
+	newExecutionCache.EndedAtInSec = now.Unix()
+	b, err := json.Marshal(newExecutionCache)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode execution cache %q: %v", executionCache.ExecutionCacheKey, err)
+	}
+	_, err = s.client.PutObject(s.bucketName, s.objectName(name), bytes.NewReader(b), int64(len(b)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create execution cache %q: %v", executionCache.ExecutionCacheKey, err)
+	}
+	logging.WithContext(logger, ctx).WithField(logging.FieldCacheKey, newExecutionCache.ExecutionCacheKey).Debug("Cache entry created")
+	return &newExecutionCache, nil
+}
+
+// DeleteExecutionCache deletes the entries of the cache key, whatever their owner or cluster.
+func (s *S3ExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	names, err := s.entryNames(executionCacheKey)
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for _, name := range names {
+		objectName := s.objectName(name)
+		if _, err := s.client.StatObject(s.bucketName, objectName); err != nil {
+			if isS3NotFound(err) {
+				continue
+			}
+			return err
+		}
+		if err := s.client.RemoveObject(s.bucketName, objectName); err != nil {
+			return err
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		return util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+	}
+	return nil
+}
+
+// ListExecutionCaches returns a page of entries whose cache key starts with keyPrefix and that pass
+// the filter, together with the token for the next page. An empty next page token means the listing
+// is complete. Filtered out entries still count towards the page size.
+func (s *S3ExecutionCacheStore) ListExecutionCaches(ctx context.Context, keyPrefix string, filter ExecutionCacheFilter, pageSize int, pageToken string) ([]*model.ExecutionCache, string, error) {
+	if pageSize <= 0 || pageSize > DefaultS3ListMaxPageSize {
+		pageSize = DefaultS3ListMaxPageSize
+	}
+	result, err := s.client.ListObjectsV2(s.bucketName, s.prefix+keyPrefix, pageToken, pageSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to list execution caches: %v", err)
+	}
+	var executionCaches []*model.ExecutionCache
+	for _, object := range result.Contents {
+		if !strings.HasSuffix(object.Key, s3ObjectSuffix) {
+			continue
+		}
+		executionCache, err := s.getObject(strings.TrimSuffix(strings.TrimPrefix(object.Key, s.prefix), s3ObjectSuffix))
+		if err != nil {
+			// The object may have been removed between the listing and the read.
+			if util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+				continue
+			}
+			return nil, "", err
+		}
+		if filter.matches(executionCache) {
+			executionCaches = append(executionCaches, executionCache)
+		}
+	}
+	nextPageToken := ""
+	if result.IsTruncated {
+		nextPageToken = result.NextContinuationToken
+	}
+	return executionCaches, nextPageToken, nil
+}
+
+// entryNames returns the names of the entries of the cache key, that named after the cache key
+// alone first, whether it exists or not.
+func (s *S3ExecutionCacheStore) entryNames(executionCacheKey string) ([]string, error) {
+	names := []string{executionCacheKey}
+	prefix := s.prefix + scopedEntryNamePrefix(executionCacheKey)
+	pageToken := ""
+	for {
+		result, err := s.client.ListObjectsV2(s.bucketName, prefix, pageToken, DefaultS3ListMaxPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list execution caches: %q: %v", executionCacheKey, err)
+		}
+		for _, object := range result.Contents {
+			if strings.HasSuffix(object.Key, s3ObjectSuffix) {
+				names = append(names, strings.TrimSuffix(strings.TrimPrefix(object.Key, s.prefix), s3ObjectSuffix))
+			}
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		pageToken = result.NextContinuationToken
+	}
+}
+
+func (s *S3ExecutionCacheStore) getObject(name string) (*model.ExecutionCache, error) {
+	executionCacheKey := entryNameCacheKey(name)
+	reader, err := s.client.GetObject(s.bucketName, s.objectName(name))
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, util.NewCustomErrorf(util.CUSTOM_CODE_NOT_FOUND, "Execution cache not found with cache key: %q", executionCacheKey)
+		}
+		return nil, fmt.Errorf("Failed to get execution cache: %q: %v", executionCacheKey, err)
+	}
+	defer reader.Close()
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read execution cache: %q: %v", executionCacheKey, err)
+	}
+	var executionCache model.ExecutionCache
+	if err := json.Unmarshal(b, &executionCache); err != nil {
+		return nil, fmt.Errorf("Failed to decode execution cache: %q: %v", executionCacheKey, err)
+	}
+	return &executionCache, nil
+}
+
+func (s *S3ExecutionCacheStore) objectName(name string) string {
+	return s.prefix + name + s3ObjectSuffix
+}
+
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == s3NoSuchKeyErrorCode
+}
+
+// factory function for S3 execution cache store
+func NewS3ExecutionCacheStore(client S3ClientInterface, bucketName string, prefix string, scope EntryScope, time util.TimeInterface) *S3ExecutionCacheStore {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return &S3ExecutionCacheStore{
+		client:     client,
+		bucketName: bucketName,
+		prefix:     prefix,
+		scope:      scope,
+		time:       time,
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	minio "github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestS3CreateAndGetExecutionCache(t *testing.T) {
+	client := NewFakeS3Client()
+	store := NewS3ExecutionCacheStore(client, "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+
+	created, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), created.StartedAtInSec)
+	assert.Equal(t, 1, client.GetObjectCount())
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, executionCache)
+}
+
+func TestS3GetExecutionCacheNotFound(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "wrongKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	assert.Contains(t, err.Error(), `Execution cache not found with cache key: "wrongKey"`)
+}
+
+func TestS3GetExecutionCacheWithExpiredMaxCacheStaleness(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.MaxCacheStaleness = 0
+	_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.Nil(t, executionCache)
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestS3CreateExecutionCacheIfAbsent(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	executionCache, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput2"))
+	assert.Nil(t, executionCache)
+	assert.True(t, util.IsUserErrorCodeMatch(err, codes.AlreadyExists))
+
+	stored, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", stored.ExecutionOutput)
+}
+
+func TestS3CreateExecutionCacheReplacesExpiredEntry(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	expired := createExecutionCache("testKey", "testOutput")
+	expired.MaxCacheStaleness = 0
+	_, err := store.CreateExecutionCache(context.Background(), expired)
+	require.Nil(t, err)
+
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput2"))
+	require.Nil(t, err)
+	stored, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput2", stored.ExecutionOutput)
+}
+
+func TestS3DeleteExecutionCache(t *testing.T) {
+	client := NewFakeS3Client()
+	store := NewS3ExecutionCacheStore(client, "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	assert.Equal(t, 0, client.GetObjectCount())
+	err = store.DeleteExecutionCache(context.Background(), "testKey")
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestS3ListExecutionCachesWithPagination(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	for i := 0; i < 5; i++ {
+		_, err := store.CreateExecutionCache(context.Background(), createExecutionCache(fmt.Sprintf("key%d", i), "testOutput"))
+		require.Nil(t, err)
+	}
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("other", "testOutput"))
+	require.Nil(t, err)
+
+	var keys []string
+	pageToken := ""
+	pages := 0
+	for {
+		executionCaches, nextPageToken, err := store.ListExecutionCaches(context.Background(), "key", ExecutionCacheFilter{}, 2, pageToken)
+		require.Nil(t, err)
+		for _, executionCache := range executionCaches {
+			keys = append(keys, executionCache.ExecutionCacheKey)
+		}
+		pages++
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+	assert.Equal(t, []string{"key0", "key1", "key2", "key3", "key4"}, keys)
+	assert.Equal(t, 3, pages)
+}
+
+func TestS3GetAndListExecutionCachesWithOwnerFilter(t *testing.T) {
+	store := NewS3ExecutionCacheStore(NewFakeS3Client(), "bucket", "cache", EntryScope{}, util.NewFakeTimeForEpoch())
+	for key, owner := range map[string]string{"key-alice": "alice", "key-bob": "bob", "key-shared": ""} {
+		executionCacheToPersist := createExecutionCache(key, "testOutput")
+		executionCacheToPersist.Owner = owner
+		_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+		require.Nil(t, err)
+	}
+	filter := ExecutionCacheFilter{EnforceOwner: true, Owner: "alice"}
+
+	_, err := store.GetExecutionCache(context.Background(), "key-alice", -1, filter)
+	assert.Nil(t, err)
+	_, err = store.GetExecutionCache(context.Background(), "key-shared", -1, filter)
+	assert.Nil(t, err)
+	_, err = store.GetExecutionCache(context.Background(), "key-bob", -1, filter)
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	_, err = store.GetExecutionCache(context.Background(), "key-bob", -1, ExecutionCacheFilter{Owner: "alice"})
+	assert.Nil(t, err)
+
+	executionCaches, _, err := store.ListExecutionCaches(context.Background(), "key", filter, 10, "")
+	require.Nil(t, err)
+	var keys []string
+	for _, executionCache := range executionCaches {
+		keys = append(keys, executionCache.ExecutionCacheKey)
+	}
+	assert.Equal(t, []string{"key-alice", "key-shared"}, keys)
+}
+
+func TestS3CreateExecutionCacheOfEachOwnerAndCluster(t *testing.T) {
+	client := NewFakeS3Client()
+	fakeTime := util.NewFakeTimeForEpoch()
+	store := NewS3ExecutionCacheStore(client, "bucket", "cache", EntryScope{Owner: true, Cluster: true}, fakeTime)
+	for _, entry := range []struct{ owner, cluster string }{{"alice", "us-east"}, {"bob", "us-east"}, {"alice", "eu-west"}} {
+		executionCacheToPersist := createExecutionCache("testKey", entry.owner+"@"+entry.cluster)
+		executionCacheToPersist.Owner = entry.owner
+		executionCacheToPersist.ClusterID = entry.cluster
+		_, err := store.CreateExecutionCache(context.Background(), executionCacheToPersist)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, 3, client.GetObjectCount())
+	duplicate := createExecutionCache("testKey", "testOutput")
+	duplicate.Owner = "bob"
+	duplicate.ClusterID = "us-east"
+	_, err := store.CreateExecutionCache(context.Background(), duplicate)
+	assert.True(t, util.IsUserErrorCodeMatch(err, codes.AlreadyExists))
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{EnforceOwner: true, Owner: "bob", ClusterID: "us-east"})
+	require.Nil(t, err)
+	assert.Equal(t, "bob@us-east", executionCache.ExecutionOutput)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{EnforceOwner: true, Owner: "bob", ClusterID: "eu-west"})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	// Lookups of any cluster list the entries of the cache key and serve the newest.
+	executionCache, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{EnforceOwner: true, Owner: "alice"})
+	require.Nil(t, err)
+	assert.Equal(t, "alice@eu-west", executionCache.ExecutionOutput)
+
+	executionCaches, _, err := store.ListExecutionCaches(context.Background(), "test", ExecutionCacheFilter{}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, executionCaches, 3)
+	for _, executionCache := range executionCaches {
+		assert.Equal(t, "testKey", executionCache.ExecutionCacheKey)
+	}
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	assert.Equal(t, 0, client.GetObjectCount())
+}
+
+// TestS3ExecutionCacheStoreAgainstMinio runs against a real MinIO server when MINIO_TEST_ENDPOINT
+// is set, e.g. one started with `docker run -p 9000:9000 minio/minio server /data`.
+func TestS3ExecutionCacheStoreAgainstMinio(t *testing.T) {
+	endpoint := os.Getenv("MINIO_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_TEST_ENDPOINT is not set")
+	}
+	core, err := minio.NewCore(endpoint, os.Getenv("MINIO_TEST_ACCESS_KEY"), os.Getenv("MINIO_TEST_SECRET_KEY"), false)
+	require.Nil(t, err)
+	bucketName := "cache-store-test"
+	if exists, err := core.BucketExists(bucketName); err == nil && !exists {
+		require.Nil(t, core.MakeBucket(bucketName, ""))
+	}
+	prefix := fmt.Sprintf("test-%d", util.NewRealTime().Now().UnixNano())
+	store := NewS3ExecutionCacheStore(&MinioS3Client{Core: core}, bucketName, prefix, EntryScope{}, util.NewRealTime())
+
+	var executionCache *model.ExecutionCache
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	executionCache, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", executionCache.ExecutionOutput)
+	executionCaches, nextPageToken, err := store.ListExecutionCaches(context.Background(), "", ExecutionCacheFilter{}, 10, "")
+	require.Nil(t, err)
+	assert.Len(t, executionCaches, 1)
+	assert.Empty(t, nextPageToken)
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
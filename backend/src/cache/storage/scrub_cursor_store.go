@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kubeflow/pipelines/backend/src/cache/model"
+)
+
+// ScrubCursorStore persists the cursors of the jobs walking the cache entries in the
+// scrub_cursors table, by job name.
+type ScrubCursorStore struct {
+	db *DB
+}
+
+// GetScrubCursor returns the cursor saved for the job, empty when none was.
+func (s *ScrubCursorStore) GetScrubCursor(name string) (string, error) {
+	var cursor model.ScrubCursor
+	err := s.db.Where("Name = ?", name).First(&cursor).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get the scrub cursor of %s: %v", name, err)
+	}
+	return cursor.Cursor, nil
+}
+
+// SaveScrubCursor saves the cursor of the job, replacing the previous one.
+func (s *ScrubCursorStore) SaveScrubCursor(name string, cursor string, nowInSec int64) error {
+	if err := s.db.Save(&model.ScrubCursor{Name: name, Cursor: cursor, UpdatedAtInSec: nowInSec}).Error; err != nil {
+		return fmt.Errorf("failed to save the scrub cursor of %s: %v", name, err)
+	}
+	return nil
+}
+
+// factory function for a scrub cursor store, creating the scrub_cursors table if it is missing
+func NewScrubCursorStore(db *DB) (*ScrubCursorStore, error) {
+	if err := db.AutoMigrate(&model.ScrubCursor{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to create the scrub_cursors table: %v", err)
+	}
+	return &ScrubCursorStore{db: db}, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubCursorStore(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	store, err := NewScrubCursorStore(db)
+	require.Nil(t, err)
+
+	cursor, err := store.GetScrubCursor("artifacts")
+	require.Nil(t, err)
+	assert.Equal(t, "", cursor, "jobs start over without a saved cursor")
+
+	require.Nil(t, store.SaveScrubCursor("artifacts", "42", 100))
+	require.Nil(t, store.SaveScrubCursor("other", "7", 100))
+	require.Nil(t, store.SaveScrubCursor("artifacts", "84", 200))
+	cursor, err = store.GetScrubCursor("artifacts")
+	require.Nil(t, err)
+	assert.Equal(t, "84", cursor)
+	cursor, err = store.GetScrubCursor("other")
+	require.Nil(t, err)
+	assert.Equal(t, "7", cursor)
+
+	// A store over the same database resumes from the saved cursors.
+	store, err = NewScrubCursorStore(db)
+	require.Nil(t, err)
+	require.Nil(t, store.SaveScrubCursor("artifacts", "", 300))
+	cursor, err = store.GetScrubCursor("artifacts")
+	require.Nil(t, err)
+	assert.Equal(t, "", cursor)
+}
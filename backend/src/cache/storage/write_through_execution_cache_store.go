@@ -0,0 +1,264 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/logging"
+	"github.com/kubeflow/pipelines/backend/src/cache/metrics"
+	model "github.com/kubeflow/pipelines/backend/src/cache/model"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WriteThroughExecutionCacheStore serves lookups from Redis and falls back to a durable backing
+// store, typically the database store, on a miss. Writes go to the backing store first. Redis
+// failures never fail a call: they are counted and the call is served by the backing store alone.
+// After repeated failures a circuit breaker skips Redis altogether until it is reachable again.
+// The Redis deletions skipped or failed meanwhile are replayed before Redis is read again, so that
+// it never serves the entries deleted from the backing store.
+type WriteThroughExecutionCacheStore struct {
+	backing       ExecutionCacheStoreInterface
+	redis         *RedisExecutionCacheStore
+	redisFailures *prometheus.CounterVec
+	breaker       *CircuitBreaker
+
+	// The pending invalidations are the cache keys and IDs whose Redis copies are still to be
+	// deleted. They are at most the entries deleted from the backing store during an outage.
+	invalidationMutex sync.Mutex
+	pendingKeys       map[string]bool
+	pendingIDs        map[string]bool
+}
+
+func (s *WriteThroughExecutionCacheStore) GetExecutionCache(ctx context.Context, executionCacheKey string, maxCacheStaleness int64, filter ExecutionCacheFilter) (*model.ExecutionCache, error) {
+	if maxCacheStaleness == 0 {
+		return s.backing.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+	}
+	if s.breaker.Allow() && s.replayInvalidations(ctx) {
+		executionCache, err := s.redis.getExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+		if err == nil || util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			s.breaker.RecordSuccess()
+		} else {
+			s.redisFailed(ctx, "get", err)
+		}
+		if err == nil {
+			return executionCache, nil
+		}
+	}
+	executionCache, err := s.backing.GetExecutionCache(ctx, executionCacheKey, maxCacheStaleness, filter)
+	if err != nil {
+		return nil, err
+	}
+	if s.breaker.Allow() {
+		s.redisDone(ctx, "populate", s.redis.putExecutionCache(ctx, executionCache))
+	}
+	return executionCache, nil
+}
+
+func (s *WriteThroughExecutionCacheStore) CreateExecutionCache(ctx context.Context, executionCache *model.ExecutionCache) (*model.ExecutionCache, error) {
+	createdExecutionCache, err := s.backing.CreateExecutionCache(ctx, executionCache)
+	if err != nil {
+		return nil, err
+	}
+	if s.breaker.Allow() {
+		s.redisDone(ctx, "create", s.redis.putExecutionCache(ctx, createdExecutionCache))
+	}
+	return createdExecutionCache, nil
+}
+
+// DeleteExecutionCache deletes the entries of the cache key from the backing store and their Redis
+// copy, if any. While the circuit is open the copy is deleted once Redis is reachable again.
+func (s *WriteThroughExecutionCacheStore) DeleteExecutionCache(ctx context.Context, executionCacheKey string) error {
+	if err := s.backing.DeleteExecutionCache(ctx, executionCacheKey); err != nil {
+		return err
+	}
+	s.invalidateKey(ctx, executionCacheKey)
+	return nil
+}
+
+// AdminStore manages the entries of the backing store through admin, keeping Redis coherent: the
+// Redis copies of deleted entries are removed too.
+func (s *WriteThroughExecutionCacheStore) AdminStore(admin ExecutionCacheAdminStore) ExecutionCacheAdminStore {
+	return &writeThroughAdminStore{ExecutionCacheAdminStore: admin, store: s}
+}
+
+type writeThroughAdminStore struct {
+	ExecutionCacheAdminStore
+	store *WriteThroughExecutionCacheStore
+}
+
+func (s *writeThroughAdminStore) DeleteExecutionCacheByID(ctx context.Context, executionCacheID string) error {
+	if err := s.ExecutionCacheAdminStore.DeleteExecutionCacheByID(ctx, executionCacheID); err != nil {
+		return err
+	}
+	s.store.invalidateID(ctx, executionCacheID)
+	return nil
+}
+
+func (s *writeThroughAdminStore) DeleteExecutionCachesByKey(ctx context.Context, executionCacheKey string) (int64, error) {
+	deleted, err := s.ExecutionCacheAdminStore.DeleteExecutionCachesByKey(ctx, executionCacheKey)
+	if err != nil {
+		return deleted, err
+	}
+	s.store.invalidateKey(ctx, executionCacheKey)
+	return deleted, nil
+}
+
+func (s *writeThroughAdminStore) InvalidateExecutionCaches(ctx context.Context, selector ExecutionCacheSelector, dryRun bool) (*ExecutionCacheInvalidation, error) {
+	invalidation, err := s.ExecutionCacheAdminStore.InvalidateExecutionCaches(ctx, selector, dryRun)
+	if invalidation == nil {
+		return nil, err
+	}
+	for _, executionCacheKey := range invalidation.CacheKeys {
+		s.store.invalidateKey(ctx, executionCacheKey)
+	}
+	return invalidation, err
+}
+
+// QuotaStore enforces the namespace quotas on the backing store through quota, keeping Redis
+// coherent: the Redis copies of evicted entries are removed too.
+func (s *WriteThroughExecutionCacheStore) QuotaStore(quota ExecutionCacheQuotaStore) ExecutionCacheQuotaStore {
+	return &writeThroughQuotaStore{ExecutionCacheQuotaStore: quota, store: s}
+}
+
+type writeThroughQuotaStore struct {
+	ExecutionCacheQuotaStore
+	store *WriteThroughExecutionCacheStore
+}
+
+func (s *writeThroughQuotaStore) Evict(ctx context.Context, namespace string, excess NamespaceUsage, keepID string, policy string) (*ExecutionCacheEviction, error) {
+	eviction, err := s.ExecutionCacheQuotaStore.Evict(ctx, namespace, excess, keepID, policy)
+	if eviction == nil {
+		return nil, err
+	}
+	for _, executionCacheID := range eviction.ExecutionCacheIDs {
+		s.store.invalidateID(ctx, executionCacheID)
+	}
+	return eviction, err
+}
+
+// RedisCircuitState returns the state of the circuit around Redis, one of CircuitClosed,
+// CircuitHalfOpen and CircuitOpen.
+func (s *WriteThroughExecutionCacheStore) RedisCircuitState() string {
+	return s.breaker.State()
+}
+
+// invalidateKey deletes the Redis copies of the entries of the cache key, or remembers to delete
+// them once Redis is reachable again.
+func (s *WriteThroughExecutionCacheStore) invalidateKey(ctx context.Context, executionCacheKey string) {
+	if s.breaker.Allow() {
+		err := s.redis.DeleteExecutionCache(ctx, executionCacheKey)
+		if err == nil || util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			s.breaker.RecordSuccess()
+			return
+		}
+		s.redisFailed(ctx, "delete", err)
+	}
+	s.invalidationMutex.Lock()
+	defer s.invalidationMutex.Unlock()
+	s.pendingKeys[executionCacheKey] = true
+}
+
+// invalidateID deletes the Redis copy of the entry of the ID, or remembers to delete it once Redis
+// is reachable again.
+func (s *WriteThroughExecutionCacheStore) invalidateID(ctx context.Context, executionCacheID string) {
+	if s.breaker.Allow() {
+		err := s.redis.invalidateExecutionCache(ctx, executionCacheID)
+		if err == nil {
+			s.breaker.RecordSuccess()
+			return
+		}
+		s.redisFailed(ctx, "delete", err)
+	}
+	s.invalidationMutex.Lock()
+	defer s.invalidationMutex.Unlock()
+	s.pendingIDs[executionCacheID] = true
+}
+
+// replayInvalidations deletes the Redis copies of the pending invalidations and reports whether
+// none is left, in which case Redis can be read.
+func (s *WriteThroughExecutionCacheStore) replayInvalidations(ctx context.Context) bool {
+	err := s.deletePendingInvalidations(ctx)
+	if err != nil {
+		s.redisFailed(ctx, "delete", err)
+		return false
+	}
+	return true
+}
+
+func (s *WriteThroughExecutionCacheStore) deletePendingInvalidations(ctx context.Context) error {
+	s.invalidationMutex.Lock()
+	defer s.invalidationMutex.Unlock()
+	for executionCacheKey := range s.pendingKeys {
+		err := s.redis.DeleteExecutionCache(ctx, executionCacheKey)
+		if err != nil && !util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND) {
+			return err
+		}
+		delete(s.pendingKeys, executionCacheKey)
+	}
+	for executionCacheID := range s.pendingIDs {
+		if err := s.redis.invalidateExecutionCache(ctx, executionCacheID); err != nil {
+			return err
+		}
+		delete(s.pendingIDs, executionCacheID)
+	}
+	return nil
+}
+
+func (s *WriteThroughExecutionCacheStore) redisDone(ctx context.Context, operation string, err error) {
+	if err != nil {
+		s.redisFailed(ctx, operation, err)
+		return
+	}
+	s.breaker.RecordSuccess()
+}
+
+func (s *WriteThroughExecutionCacheStore) redisFailed(ctx context.Context, operation string, err error) {
+	s.redisFailures.WithLabelValues(operation).Inc()
+	s.breaker.RecordFailure()
+	logging.WithContext(logger, ctx).Warnf("Redis %s failed, using the backing store only: %v", operation, err)
+}
+
+// factory function for write-through execution cache store. Redis is skipped for circuitCoolDown
+// after circuitFailureThreshold consecutive failures, and a threshold of 0 never skips it.
+func NewWriteThroughExecutionCacheStore(backing ExecutionCacheStoreInterface, redis *RedisExecutionCacheStore, circuitFailureThreshold int, circuitCoolDown time.Duration, registerer prometheus.Registerer) *WriteThroughExecutionCacheStore {
+	redisFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_store_redis_failures_total",
+		Help: "Redis failures of the write-through cache store by operation. Such calls are served by the backing store.",
+	}, []string{"operation"})
+	registered, err := metrics.RegisterOrReuse(registerer, redisFailures)
+	if err != nil {
+		logger.Errorf("Failed to register write-through store metrics: %v", err)
+	}
+	redisFailures = registered.(*prometheus.CounterVec)
+	store := &WriteThroughExecutionCacheStore{
+		backing:       backing,
+		redis:         redis,
+		redisFailures: redisFailures,
+		pendingKeys:   map[string]bool{},
+		pendingIDs:    map[string]bool{},
+	}
+	// The circuit only closes once the invalidations skipped while it was open are replayed.
+	store.breaker = newRedisCircuitBreaker(circuitFailureThreshold, circuitCoolDown, func() error {
+		if err := redis.ping(context.Background()); err != nil {
+			return err
+		}
+		return store.deletePendingInvalidations(context.Background())
+	}, redis.time, registerer)
+	return store
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWriteThroughExecutionCacheStore(t *testing.T) (*WriteThroughExecutionCacheStore, *ExecutionCacheStore, *miniredis.Miniredis) {
+	db := NewFakeDbOrFatal()
+	t.Cleanup(func() { db.Close() })
+	backing := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	redisStore, server := newMiniredisExecutionCacheStore(t)
+	return NewWriteThroughExecutionCacheStore(backing, redisStore, DefaultRedisCircuitFailureThreshold, DefaultRedisCircuitCoolDown, prometheus.NewRegistry()), backing, server
+}
+
+func TestWriteThroughCreateWritesBothStores(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+
+	created, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	fromDB, err := backing.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, fromDB)
+	fromRedis, err := store.redis.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, fromRedis)
+	assert.True(t, server.Exists("cache:id:1"))
+}
+
+func TestWriteThroughGetPopulatesRedisOnMiss(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.MaxCacheStaleness = 100
+	created, err := backing.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+	assert.False(t, server.Exists("cache:testKey"))
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, executionCache)
+	assert.True(t, server.Exists("cache:testKey"))
+	assert.Equal(t, 100*time.Second, server.TTL("cache:testKey"))
+
+	// Served from Redis even once the database has lost the row.
+	require.Nil(t, backing.DeleteExecutionCache(context.Background(), "testKey"))
+	executionCache, err = store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, created, executionCache)
+}
+
+func TestWriteThroughDeleteInvalidatesRedis(t *testing.T) {
+	store, _, server := newWriteThroughExecutionCacheStore(t)
+	created, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), created.ExecutionCacheKey))
+	assert.False(t, server.Exists("cache:testKey"))
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestWriteThroughDeleteRemovesEveryEntryOfTheKey(t *testing.T) {
+	store, _, server := newWriteThroughExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "olderOutput"))
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "newerOutput"))
+	require.Nil(t, err)
+
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "testKey"))
+	assert.False(t, server.Exists("cache:testKey"))
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	err = store.DeleteExecutionCache(context.Background(), "testKey")
+	assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+}
+
+func TestWriteThroughFallsBackToBackingStoreWhenRedisIsDown(t *testing.T) {
+	store, _, server := newWriteThroughExecutionCacheStore(t)
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	server.Close()
+
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", executionCache.ExecutionOutput)
+	created, err := store.CreateExecutionCache(context.Background(), createExecutionCache("otherKey", "otherOutput"))
+	require.Nil(t, err)
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), created.ExecutionCacheKey))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("get")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("populate")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("create")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("delete")))
+}
+
+// fixedTime is a clock that only moves when told to.
+type fixedTime struct {
+	now time.Time
+}
+
+func (f *fixedTime) Now() time.Time {
+	return f.now
+}
+
+func TestRedisTTL(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxCacheStaleness int64
+		expiresAtInSec    int64
+		nowInSec          int64
+		ttl               int64
+		live              bool
+	}{
+		{"infinite staleness", -1, 0, 5000, -1, true},
+		{"remaining lifetime", 100, 0, 1060, 40, true},
+		{"expires now", 100, 0, 1100, 0, false},
+		{"already expired", 100, 0, 1200, 0, false},
+		{"no staleness budget", 0, 0, 1000, 0, false},
+		{"time-to-live of infinite staleness", -1, 2000, 1060, 940, true},
+		{"time-to-live ends first", 100, 1080, 1060, 20, true},
+		{"goes stale first", 100, 2000, 1060, 40, true},
+		{"time-to-live ended", -1, 1060, 1060, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			executionCache := createExecutionCache("testKey", "testOutput")
+			executionCache.EndedAtInSec = 1000
+			executionCache.MaxCacheStaleness = tc.maxCacheStaleness
+			executionCache.ExpiresAtInSec = tc.expiresAtInSec
+			ttl, live := redisTTL(executionCache, tc.nowInSec)
+			assert.Equal(t, tc.ttl, ttl)
+			assert.Equal(t, tc.live, live)
+		})
+	}
+}
+
+func TestWriteThroughPopulateUsesRemainingLifetime(t *testing.T) {
+	store, backing, server := newWriteThroughExecutionCacheStore(t)
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	backing.time = clock
+	store.redis.time = clock
+	executionCacheToPersist := createExecutionCache("testKey", "testOutput")
+	executionCacheToPersist.MaxCacheStaleness = 100
+	created, err := backing.CreateExecutionCache(context.Background(), executionCacheToPersist)
+	require.Nil(t, err)
+
+	clock.now = time.Unix(1060, 0)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, 40*time.Second, server.TTL("cache:testKey"))
+	assert.Equal(t, 40*time.Second, server.TTL("cache:id:1"))
+
+	// Populating again after Redis lost the copy does not restart the lifetime.
+	server.Del("cache:testKey")
+	clock.now = time.Unix(1090, 0)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", 1000, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, 10*time.Second, server.TTL("cache:testKey"))
+
+	// Expired entries are not written at all.
+	server.Del("cache:testKey")
+	clock.now = time.Unix(1100, 0)
+	require.Nil(t, store.redis.putExecutionCache(context.Background(), created))
+	assert.False(t, server.Exists("cache:testKey"))
+}
+
+func TestWriteThroughInfiniteStalenessPersists(t *testing.T) {
+	store, _, server := newWriteThroughExecutionCacheStore(t)
+	created, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+	server.SetTTL("cache:testKey", time.Minute)
+
+	require.Nil(t, store.redis.putExecutionCache(context.Background(), created))
+	assert.True(t, server.Exists("cache:testKey"))
+	assert.Equal(t, time.Duration(0), server.TTL("cache:testKey"))
+}
+
+func TestWriteThroughServesFromBackingStoreWhenRedisHangs(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	backing := NewExecutionCacheStore(db, util.NewFakeTimeForEpoch())
+	redisClient := client.NewRedisClientInterface(redis.NewClient(&redis.Options{Addr: newUnresponsiveRedisAddress(t)}))
+	defer redisClient.Close()
+	redisStore := NewRedisExecutionCacheStore(redisClient, DefaultRedisKeyPrefix, EntryScope{}, 200*time.Millisecond, util.NewFakeTimeForEpoch())
+	store := NewWriteThroughExecutionCacheStore(backing, redisStore, DefaultRedisCircuitFailureThreshold, DefaultRedisCircuitCoolDown, prometheus.NewRegistry())
+	_, err := backing.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	start := time.Now()
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", executionCache.ExecutionOutput)
+	assert.True(t, time.Since(start) < time.Second, "lookup took %v", time.Since(start))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("get")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("populate")))
+}
+
+func TestWriteThroughSkipsRedisWhileCircuitIsOpen(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	backing := NewExecutionCacheStore(db, clock)
+	redisStore, server := newMiniredisExecutionCacheStore(t)
+	redisStore.time = clock
+	store := NewWriteThroughExecutionCacheStore(backing, redisStore, 2, time.Minute, prometheus.NewRegistry())
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("testKey", "testOutput"))
+	require.Nil(t, err)
+
+	server.Close()
+	// The failed lookup and the failed repopulation open the circuit.
+	executionCache, err := store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	assert.Equal(t, "testOutput", executionCache.ExecutionOutput)
+	assert.Equal(t, CircuitOpen, store.RedisCircuitState())
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("otherKey", "otherOutput"))
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("get")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(store.redisFailures.WithLabelValues("populate")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(store.redisFailures.WithLabelValues("create")))
+
+	require.Nil(t, server.Restart())
+	clock.now = time.Unix(1060, 0)
+	_, err = store.GetExecutionCache(context.Background(), "testKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool { return store.RedisCircuitState() == CircuitClosed }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = store.CreateExecutionCache(context.Background(), createExecutionCache("thirdKey", "thirdOutput"))
+	require.Nil(t, err)
+	assert.True(t, server.Exists("cache:thirdKey"))
+	assert.False(t, server.Exists("cache:otherKey"))
+}
+
+func TestWriteThroughReplaysInvalidationsSkippedWhileCircuitIsOpen(t *testing.T) {
+	db := NewFakeDbOrFatal()
+	defer db.Close()
+	clock := &fixedTime{now: time.Unix(1000, 0)}
+	backing := NewExecutionCacheStore(db, clock)
+	redisStore, server := newMiniredisExecutionCacheStore(t)
+	redisStore.time = clock
+	store := NewWriteThroughExecutionCacheStore(backing, redisStore, 2, time.Minute, prometheus.NewRegistry())
+	_, err := store.CreateExecutionCache(context.Background(), createExecutionCache("byKey", "output"))
+	require.Nil(t, err)
+	byID, err := store.CreateExecutionCache(context.Background(), createExecutionCache("byID", "output"))
+	require.Nil(t, err)
+
+	server.Close()
+	_, err = store.GetExecutionCache(context.Background(), "byKey", -1, ExecutionCacheFilter{})
+	require.Nil(t, err)
+	require.Equal(t, CircuitOpen, store.RedisCircuitState())
+	require.Nil(t, store.DeleteExecutionCache(context.Background(), "byKey"))
+	require.Nil(t, store.AdminStore(backing).DeleteExecutionCacheByID(context.Background(), strconv.FormatInt(byID.ID, 10)))
+
+	require.Nil(t, server.Restart())
+	assert.True(t, server.Exists("cache:byKey"), "the copies outlive the outage")
+	clock.now = time.Unix(1060, 0)
+	_, err = store.GetExecutionCache(context.Background(), "other", -1, ExecutionCacheFilter{})
+	require.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND))
+	require.Eventually(t, func() bool { return store.RedisCircuitState() == CircuitClosed }, 5*time.Second, 10*time.Millisecond)
+
+	for _, key := range []string{"byKey", "byID"} {
+		_, err = store.GetExecutionCache(context.Background(), key, -1, ExecutionCacheFilter{})
+		assert.True(t, util.HasCustomCode(err, util.CUSTOM_CODE_NOT_FOUND), key)
+		assert.False(t, server.Exists("cache:"+key), key)
+	}
+}
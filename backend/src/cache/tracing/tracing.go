@@ -0,0 +1,83 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ServiceName string = "cache-server"
+	TracerName  string = "github.com/kubeflow/pipelines/backend/src/cache"
+)
+
+// Names of the spans of the mutation path. The admission span is the parent of the others.
+const (
+	SpanAdmission    string = "admission"
+	SpanGenerateKey  string = "generate_cache_key"
+	SpanDBLookup     string = "db_lookup"
+	SpanRedisLookup  string = "redis_lookup"
+	SpanBuildPatches string = "build_patches"
+)
+
+// Attributes set on the admission span.
+const (
+	AttributeNamespace attribute.Key = "k8s.namespace.name"
+	AttributeDecision  attribute.Key = "cache.decision"
+	AttributeCacheKey  attribute.Key = "cache.key"
+)
+
+// Propagator reads the W3C traceparent header of incoming admission requests, so that admissions
+// join the trace of the API server when it propagates one.
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// NoopTracerProvider creates spans that are never recorded. It is used when no OTLP endpoint is
+// configured, which keeps tracing free of cost.
+func NoopTracerProvider() trace.TracerProvider {
+	return trace.NewNoopTracerProvider()
+}
+
+// NewTracerProvider creates a provider batching spans to the OTLP/HTTP collector at endpoint,
+// e.g. http://otel-collector:4318. Endpoints with the http scheme are exported to without TLS.
+// Headers, certificates and timeouts are read from the OTEL_EXPORTER_OTLP_* variables.
+func NewTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	options := []otlptracehttp.Option{}
+	if strings.HasPrefix(strings.ToLower(endpoint), "http://") {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	host := endpoint
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+len("://"):]
+	}
+	host = strings.TrimSuffix(host, "/")
+	options = append(options, otlptracehttp.WithEndpoint(host))
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	), nil
+}
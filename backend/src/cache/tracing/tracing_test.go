@@ -0,0 +1,53 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTracerProviderExportsToEndpoint(t *testing.T) {
+	var mutex sync.Mutex
+	var paths []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		paths = append(paths, r.URL.Path)
+	}))
+	defer collector.Close()
+
+	provider, err := NewTracerProvider(context.Background(), collector.URL+"/")
+	require.Nil(t, err)
+	_, span := provider.Tracer(TracerName).Start(context.Background(), SpanAdmission)
+	span.End()
+	require.Nil(t, provider.Shutdown(context.Background()))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"/v1/traces"}, paths)
+}
+
+func TestNoopTracerProviderDoesNotRecord(t *testing.T) {
+	_, span := NoopTracerProvider().Tracer(TracerName).Start(context.Background(), SpanAdmission)
+	defer span.End()
+	assert.False(t, span.IsRecording())
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package version holds the build metadata of the cache server. It is set at build time with
+// -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/kubeflow/pipelines/backend/src/cache/version.Version=1.0.0"
+package version
+
+import "runtime"
+
+// Unset is reported for build metadata that was not set at build time, e.g. in local builds.
+const Unset string = "dev"
+
+var (
+	Version   string
+	GitCommit string
+	BuildDate string
+)
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build metadata, with Unset in place of the values not set at build time.
+func Get() Info {
+	return Info{
+		Version:   orUnset(Version),
+		GitCommit: orUnset(GitCommit),
+		BuildDate: orUnset(BuildDate),
+		GoVersion: runtime.Version(),
+	}
+}
+
+func orUnset(value string) string {
+	if value == "" {
+		return Unset
+	}
+	return value
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// watcherCommand records the outputs of completed pods without serving the webhook, so that the
+// recording can be scaled and deployed apart from the admissions.
+type watcherCommand struct{}
+
+func (c *watcherCommand) registerFlags(flags *flag.FlagSet) {}
+
+func (c *watcherCommand) run(cfg *config.Config, configuredLogger *logrus.Logger) {
+	tracerProvider := startTracing(cfg)
+
+	logger.Info("Initing client manager")
+	clientManager := NewClientManager(cfg)
+	server.RegisterBuildInfo(prometheus.DefaultRegisterer)
+	// The entries deleted by the artifact scrubber are audited.
+	auditLog := newAuditLog(cfg.Audit, clientManager)
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	watcherDone := make(chan struct{})
+	leadership := newWatcherLeadership(cfg)
+	go func() {
+		runWatchers(watchCtx, cfg, clientManager, leadership, auditLog)
+		close(watcherDone)
+	}()
+	watchConfiguration(watchCtx, cfg, configuredLogger, clientManager, nil)
+	debugServer := startDebugServer(cfg, clientManager.AdminToken(), nil, nil)
+	statsCollector := newStatsCollector(cfg, clientManager)
+	grpcServer := startGRPCServer(cfg, clientManager, statsCollector)
+
+	// The health listener is served until a signal arrives, then the pod at hand is recorded
+	// before the stores are closed.
+	healthServer := newHealthServer(cfg, clientManager, statsCollector, nil, leadership, nil, nil)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	// No admission is routed to the watcher, so its health listener is not drained.
+	serveErr := server.ServeUntilSignalled(healthServer, healthServer.ListenAndServe, signals, 0, cfg.Listener.ShutdownGracePeriod)
+	if serveErr != nil {
+		logger.Errorf("The health listener stopped serving: %v", serveErr)
+	}
+
+	stopWatching()
+	<-watcherDone
+	// The audit events still queued are written before the database is closed.
+	if err := auditLog.Close(); err != nil {
+		logger.Warnf("Failed to close the audit sink: %v", err)
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if debugServer != nil {
+		debugServer.Close()
+	}
+	clientManager.Close()
+	stopTracing(tracerProvider)
+	logger.Info("Shutdown complete")
+	if serveErr != nil {
+		os.Exit(1)
+	}
+}
+
+// newWatcherLeadership returns the leadership of the watchers when electing a leader, nil
+// otherwise.
+func newWatcherLeadership(cfg *config.Config) *server.WatcherLeadership {
+	if !cfg.Watcher.LeaderElection {
+		return nil
+	}
+	return &server.WatcherLeadership{}
+}
+
+// runWatchers records the outputs of completed pods until ctx is done. With a leadership, only
+// while the replica holds the lease. The entries deleted by the artifact scrubber are audited to
+// auditLog.
+func runWatchers(ctx context.Context, cfg *config.Config, clientManager *ClientManager, leadership *server.WatcherLeadership, auditLog *server.AuditLog) {
+	keys := server.NewAnnotationKeys(cfg.Cache.AnnotationPrefix)
+	metrics := server.NewPrometheusWatcherMetrics(prometheus.DefaultRegisterer)
+	watcherConfig := server.WatcherConfig{
+		ResyncPeriod:    cfg.Watcher.ResyncPeriod,
+		CatchUpLookback: cfg.Watcher.CatchUpLookback,
+		PatchQPS:        cfg.Watcher.PatchQPS,
+		PatchBurst:      cfg.Watcher.PatchBurst,
+		WriteWorkers:    cfg.Watcher.WriteWorkers,
+		WriteQPS:        cfg.Watcher.WriteQPS,
+		WriteBurst:      cfg.Watcher.WriteBurst,
+		WriteMaxRetries: cfg.Watcher.WriteMaxRetries,
+		WriteQueueSize:  cfg.Watcher.WriteQueueSize,
+		BackfillOnStart: cfg.Watcher.BackfillOnStart,
+		BackfillMaxAge:  cfg.Watcher.BackfillMaxAge,
+		Namespaces:      cfg.Watcher.Namespaces,
+		Quotas:          newNamespaceQuotaEnforcer(ctx, cfg, clientManager, metrics),
+		ClusterID:       cfg.Cache.ClusterID,
+		EnforceOwner:    cfg.Cache.EnforceOwner,
+		DefaultTTL:      cfg.Cache.DefaultTTL,
+		Keys:            keys,
+		Metrics:         metrics,
+	}
+	if reuseStore := clientManager.ReuseStore(); reuseStore != nil {
+		watcherConfig.CacheReuses = reuseStore
+	}
+	if cfg.Watcher.MLMDAddress != "" {
+		// The connection is established lazily, so that an unavailable server only delays the
+		// recording of the cached executions.
+		conn, err := grpc.Dial(cfg.Watcher.MLMDAddress, grpc.WithInsecure())
+		if err != nil {
+			logger.Fatalf("Failed to connect to the ML Metadata server %s: %v", cfg.Watcher.MLMDAddress, err)
+		}
+		defer conn.Close()
+		logger.Infof("Recording the pods served from cache as executions in the ML Metadata server %s", cfg.Watcher.MLMDAddress)
+		watcherConfig.CachedExecutions = server.NewCachedExecutionRecorder(ml_metadata.NewMetadataStoreServiceClient(conn), cfg.Watcher.MLMDMaxRetries, keys, metrics)
+	}
+	if cfg.Watcher.ScrubInterval > 0 {
+		watcherConfig.Scrubber = newArtifactScrubber(cfg, clientManager, auditLog, metrics)
+	}
+	if adminStore := clientManager.AdminStore(); adminStore != nil {
+		watcherConfig.Purger = server.NewWorkflowPurger(adminStore)
+	} else {
+		logger.Warnf("The %s cache store cannot purge the entries of runs, the purge-cache-on-delete annotation of workflows is ignored", cfg.Cache.Store)
+	}
+	if leadership == nil {
+		server.WatchPods(ctx, cfg.NamespaceToWatch, clientManager, watcherConfig)
+		return
+	}
+	election := server.LeaderElectionConfig{
+		LeaseName:      cfg.Watcher.LeaseName,
+		LeaseNamespace: cfg.Watcher.LeaseNamespace,
+		Identity:       cfg.Watcher.LeaseIdentity,
+		LeaseDuration:  cfg.Watcher.LeaseDuration,
+		RenewDeadline:  cfg.Watcher.RenewDeadline,
+		RetryPeriod:    cfg.Watcher.RetryPeriod,
+	}
+	if election.LeaseNamespace == "" {
+		election.LeaseNamespace = cfg.NamespaceToWatch
+	}
+	if election.Identity == "" {
+		// The hostname of a pod is its name.
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.Fatalf("Failed to get the identity of the replica for leader election: %v", err)
+		}
+		election.Identity = identity
+	}
+	logger.Infof("Electing the leader of the watchers with the lease %s/%s as %s", election.LeaseNamespace, election.LeaseName, election.Identity)
+	server.WatchPodsWhileLeading(ctx, cfg.NamespaceToWatch, clientManager, watcherConfig, election, leadership)
+}
+
+// newArtifactScrubber returns the scrubber of the entries whose artifacts no longer exist in the
+// object store, nil when the cache store cannot be scrubbed.
+func newArtifactScrubber(cfg *config.Config, clientManager *ClientManager, auditLog *server.AuditLog, metrics server.WatcherMetrics) *server.ArtifactScrubber {
+	if clientManager.ScrubCursorStore() == nil {
+		logger.Warnf("The %s cache store cannot be scrubbed, the artifact scrubber is disabled", cfg.Cache.Store)
+		return nil
+	}
+	logger.Infof("Scrubbing the cache entries older than %v whose artifacts no longer exist in %s every %v",
+		cfg.Watcher.ScrubMinAge, cfg.S3.Host, cfg.Watcher.ScrubInterval)
+	return server.NewArtifactScrubber(clientManager.AdminStore(), clientManager.ArtifactStore(), clientManager.ScrubCursorStore(), server.ArtifactScrubberConfig{
+		Interval:    cfg.Watcher.ScrubInterval,
+		MinAge:      cfg.Watcher.ScrubMinAge,
+		Concurrency: cfg.Watcher.ScrubConcurrency,
+		QPS:         cfg.Watcher.ScrubQPS,
+		Burst:       cfg.Watcher.ScrubBurst,
+		AuditLog:    auditLog,
+		Metrics:     metrics,
+	})
+}
+
+// newNamespaceQuotaEnforcer returns the enforcer of the namespace quotas and of the bound of the
+// store, kept up to date with the namespace quotas file until ctx is done, nil when no quota is set.
+func newNamespaceQuotaEnforcer(ctx context.Context, cfg *config.Config, clientManager *ClientManager, metrics server.WatcherMetrics) *server.NamespaceQuotaEnforcer {
+	if !cfg.EvictionEnabled() {
+		return nil
+	}
+	if clientManager.QuotaStore() == nil {
+		logger.Warnf("The %s cache store cannot enforce quotas, they are ignored", cfg.Cache.Store)
+		return nil
+	}
+	quotas := server.NewNamespaceQuotas(cfg.DefaultNamespaceQuota(), cfg.Cache.NamespaceQuotas)
+	go cfg.WatchNamespaceQuotas(ctx, quotas.SetOverrides)
+	logger.Infof("Enforcing namespace quotas of %+v by default, overridden for %d namespaces, and a bound of %+v on the cache, evicting under the %s policy",
+		cfg.DefaultNamespaceQuota(), len(cfg.Cache.NamespaceQuotas), cfg.StoreQuota(), cfg.Cache.EvictionPolicy)
+	return server.NewNamespaceQuotaEnforcer(clientManager.QuotaStore(), quotas, cfg.StoreQuota(), cfg.Cache.EvictionPolicy, metrics)
+}
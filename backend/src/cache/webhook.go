@@ -0,0 +1,376 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kubeflow/pipelines/backend/src/cache/api/ml_metadata"
+	"github.com/kubeflow/pipelines/backend/src/cache/client"
+	"github.com/kubeflow/pipelines/backend/src/cache/config"
+	"github.com/kubeflow/pipelines/backend/src/cache/server"
+	"github.com/kubeflow/pipelines/backend/src/cache/storage"
+	"github.com/kubeflow/pipelines/backend/src/common/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// webhookCommand serves the mutating webhook looking up the cache for the pods being created, and
+// the validating webhook flagging pods with cache fields it did not issue. It also records the outputs of completed pods unless they are recorded by the watcher command.
+type webhookCommand struct {
+	watchPods bool
+	// selfTest runs the self test of the webhook serving in the same pod instead of serving.
+	selfTest bool
+}
+
+func (c *webhookCommand) registerFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.watchPods, "watch_pods", true, "Record the outputs of completed pods. Disable when the watcher command runs separately.")
+	flags.BoolVar(&c.selfTest, "self_test", false, "Run the self test of the webhook serving in this pod through "+server.SelfTestAPI+" on the health port and exit with 0 when it passes, 1 otherwise. Meant as the exec command of a startupProbe.")
+	flags.BoolVar(&c.selfTest, "self-test", false, "Same as --self_test.")
+}
+
+func (c *webhookCommand) run(cfg *config.Config, configuredLogger *logrus.Logger) {
+	if c.selfTest {
+		os.Exit(runSelfTest(cfg, os.Stdout))
+	}
+	tracerProvider := startTracing(cfg)
+
+	logger.Info("Initing client manager")
+	clientManager := NewClientManager(cfg)
+	// Admissions are only served once the cache store is connected.
+	clientManager.CacheStore()
+
+	entryUses := newEntryUseRecorder(cfg, clientManager)
+	server.RegisterBuildInfo(prometheus.DefaultRegisterer)
+	auditLog := newAuditLog(cfg.Audit, clientManager)
+	mutationMetrics := server.NewPrometheusMutationMetrics(prometheus.DefaultRegisterer, cfg.Observability.MaxTemplateLabels)
+	webhookConfig := server.WebhookConfig{
+		Mutation:             mutationConfig(cfg, entryUses, clientManager.SignatureKeys(), remoteArtifactStore(cfg, clientManager)),
+		Keys:                 server.NewAnnotationKeys(cfg.Cache.AnnotationPrefix),
+		LookupCircuitBreaker: server.NewLookupCircuitBreaker(cfg.Cache.LookupCircuitFailureThreshold, cfg.Cache.LookupCircuitCoolDown, util.NewRealTime(), prometheus.DefaultRegisterer),
+		LookupCoalescer:      server.NewLookupCoalescer(cfg.Cache.LookupMissTTL, util.NewRealTime(), prometheus.DefaultRegisterer),
+		AdmissionLimiter: server.NewAdmissionLimiter(server.AdmissionLimiterConfig{
+			MaxConcurrent:  cfg.Cache.MaxConcurrentAdmissions,
+			QueueTimeout:   cfg.Cache.AdmissionQueueTimeout,
+			RatePerSource:  cfg.Cache.AdmissionRatePerNamespace,
+			BurstPerSource: cfg.Cache.AdmissionBurstPerNamespace,
+		}, util.NewRealTime(), prometheus.DefaultRegisterer),
+		AuditLog: auditLog,
+		Metrics:  mutationMetrics,
+	}
+	if cfg.Cache.ImageDigests {
+		webhookConfig.ImageDigests = server.NewImageDigestResolver(cfg.Cache.ImageDigestTTL, util.NewRealTime())
+	}
+	if cfg.Watcher.MLMDAddress != "" {
+		// TFX pods are only served from cache when their executions can be restored in ML
+		// Metadata, where the next components of their runs find their outputs.
+		conn, err := grpc.Dial(cfg.Watcher.MLMDAddress, grpc.WithInsecure())
+		if err != nil {
+			logger.Fatalf("Failed to connect to the ML Metadata server %s: %v", cfg.Watcher.MLMDAddress, err)
+		}
+		defer conn.Close()
+		webhookConfig.TFXExecutions = server.NewTFXExecutionRestorer(ml_metadata.NewMetadataStoreServiceClient(conn), webhookConfig.Keys)
+	}
+	var decisions *server.DecisionRecorder
+	if cfg.Observability.DecisionBufferSize > 0 {
+		decisions = server.NewDecisionRecorder(cfg.Observability.DecisionBufferSize)
+		webhookConfig.Decisions = decisions
+	}
+	webhook := server.NewWebhook(webhookConfig)
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	watcherDone := make(chan struct{})
+	var leadership *server.WatcherLeadership
+	if c.watchPods {
+		// Admissions are served by all replicas, whether they lead the watchers or not.
+		leadership = newWatcherLeadership(cfg)
+		go func() {
+			runWatchers(watchCtx, cfg, clientManager, leadership, auditLog)
+			close(watcherDone)
+		}()
+	} else {
+		close(watcherDone)
+	}
+	watchConfiguration(watchCtx, cfg, configuredLogger, clientManager, func(reloaded *config.Config) {
+		webhook.Reconfigure(mutationConfig(reloaded, entryUses, clientManager.SignatureKeys(), remoteArtifactStore(reloaded, clientManager)))
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle(MutateAPI, webhook.AdmitFuncHandler(webhook.MutatePodIfCached, clientManager))
+	mux.Handle(ValidateAPI, webhook.ValidateHandler(clientManager.SignatureKeys()))
+	if !clientManager.SignatureKeys().Enabled() {
+		logger.Warnf("No cache signature key is configured, %s admits every pod", ValidateAPI)
+	}
+	if cfg.Cache.MarkWorkflows {
+		mux.Handle(WorkflowMutateAPI, webhook.WorkflowMarkingHandler(clientManager))
+	}
+	webhookServer := &http.Server{
+		// The Service object will take care of mapping this port to the HTTPS port 443.
+		Addr:    ":" + cfg.Listener.WebhookPort,
+		Handler: server.RecoverPanics(mux, mutationMetrics),
+	}
+	serve := webhookServer.ListenAndServe
+	selfTest := &server.SelfTest{
+		WebhookURL:    "http://localhost:" + cfg.Listener.WebhookPort + MutateAPI,
+		Namespace:     cfg.NamespaceToWatch,
+		ClientManager: clientManager,
+		Timeout:       cfg.Listener.SelfTestTimeout,
+		Webhook:       webhook,
+	}
+	var certificateReloader *server.CertificateReloader
+	if cfg.TLS.Enabled {
+		certPath := filepath.Join(cfg.TLS.Dir, cfg.TLS.CertFile)
+		keyPath := filepath.Join(cfg.TLS.Dir, cfg.TLS.KeyFile)
+		if cfg.TLS.SelfSigned {
+			certPath, keyPath = generateSelfSignedKeyPair(cfg.TLS, cfg.NamespaceToWatch)
+		}
+		var err error
+		certificateReloader, err = server.NewCertificateReloader(certPath, keyPath)
+		if err != nil {
+			logger.Fatalf("Failed to load the TLS certificate: %v", err)
+		}
+		// The certificate is reloaded when cert-manager rotates the mounted secret. The health
+		// listener stays reachable without client certificates.
+		webhookServer.TLSConfig, err = server.WebhookTLSConfig(certificateReloader, cfg.TLS.ClientCAFile)
+		if err != nil {
+			logger.Fatalf("Failed to configure client certificate verification: %v", err)
+		}
+		if cfg.TLS.ClientCAFile != "" {
+			logger.Infof("Requiring client certificates signed by the CAs in %s", cfg.TLS.ClientCAFile)
+		}
+		serve = func() error {
+			return webhookServer.ListenAndServeTLS("", "")
+		}
+		// The self test trusts the serving certificate itself unless the CA it chains to is given.
+		selfTest.WebhookURL = "https://localhost:" + cfg.Listener.WebhookPort + MutateAPI
+		selfTest.RootCAs = server.SelfTestRootCAs(certPath)
+		if cfg.TLS.CAFile != "" && !cfg.TLS.SelfSigned {
+			selfTest.RootCAs = server.SelfTestRootCAs(filepath.Join(cfg.TLS.Dir, cfg.TLS.CAFile))
+		}
+		// A webhook requiring client certificates gets the serving certificate, which must then be
+		// signed by one of the client CAs.
+		selfTest.ClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certificateReloader.GetCertificate(nil)
+		}
+	} else {
+		logger.Warnf("TLS is disabled, the webhook serves admission requests over plain HTTP on port %s. "+
+			"This is only safe behind a service mesh sidecar or local proxy terminating TLS.", cfg.Listener.WebhookPort)
+	}
+
+	statsCollector := newStatsCollector(cfg, clientManager)
+	healthServer := newHealthServer(cfg, clientManager, statsCollector, selfTest, leadership, certificateReloader, mutationMetrics)
+	go func() {
+		if err := healthServer.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Fatal(err)
+		}
+	}()
+	debugServer := startDebugServer(cfg, clientManager.AdminToken(), decisions, mutationMetrics)
+	grpcServer := startGRPCServer(cfg, clientManager, statsCollector)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	// The stores are closed whether or not the webhook stopped serving on its own.
+	serveErr := server.ServeUntilSignalled(webhookServer, serve, signals, cfg.Listener.ShutdownDrainDelay, cfg.Listener.ShutdownGracePeriod)
+	if serveErr != nil {
+		logger.Errorf("The webhook stopped serving: %v", serveErr)
+	}
+
+	// Let the watcher finish recording the pod at hand before the stores are closed.
+	stopWatching()
+	<-watcherDone
+	healthServer.Close()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if debugServer != nil {
+		debugServer.Close()
+	}
+	// The audit events still queued are written before the database is closed.
+	if err := auditLog.Close(); err != nil {
+		logger.Warnf("Failed to close the audit sink: %v", err)
+	}
+	clientManager.Close()
+	// Flush the spans of the last admissions.
+	stopTracing(tracerProvider)
+	logger.Info("Shutdown complete")
+	if serveErr != nil {
+		os.Exit(1)
+	}
+}
+
+// runSelfTest has the webhook serving in this pod run its self test and prints its report. It
+// returns the exit code of the command.
+func runSelfTest(cfg *config.Config, out io.Writer) int {
+	// The checks run one after the other.
+	timeout := 2*cfg.Listener.SelfTestTimeout + time.Second
+	return requestSelfTest("http://localhost:"+cfg.Listener.HealthPort+server.SelfTestAPI, timeout, out)
+}
+
+// requestSelfTest gets the self test at url and returns 0 when it passed, 1 when it failed or could
+// not be run, e.g. because the webhook is not serving yet.
+func requestSelfTest(url string, timeout time.Duration, out io.Writer) int {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(out, "Self test failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	report, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(out, "Self test failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "%s\n", report)
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
+
+// generateSelfSignedKeyPair writes a generated key pair to a temporary directory, logs the
+// caBundle and patches it into the MutatingWebhookConfiguration if one is given.
+func generateSelfSignedKeyPair(tlsConfig config.TLSConfig, namespaceToWatch string) (string, string) {
+	var dnsNames []string
+	for _, name := range strings.Split(tlsConfig.SelfSignedDNSNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	if len(dnsNames) == 0 {
+		dnsNames = []string{
+			fmt.Sprintf("cache-server.%s.svc", namespaceToWatch),
+			fmt.Sprintf("cache-server.%s.svc.cluster.local", namespaceToWatch),
+		}
+	}
+	generated, err := server.GenerateSelfSignedCertificate(dnsNames, server.DefaultSelfSignedCertificateValidity)
+	if err != nil {
+		logger.Fatalf("Failed to generate a self-signed certificate: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "cache-server-certs")
+	if err != nil {
+		logger.Fatalf("Failed to create the self-signed certificate directory: %v", err)
+	}
+	certPath := filepath.Join(dir, tlsConfig.CertFile)
+	keyPath := filepath.Join(dir, tlsConfig.KeyFile)
+	if err := generated.WriteKeyPair(certPath, keyPath); err != nil {
+		logger.Fatal(err)
+	}
+	caBundle := base64.StdEncoding.EncodeToString(generated.CACertPEM)
+	logger.Warnf("Serving a generated self-signed certificate for %s. This is meant for local development only.", strings.Join(dnsNames, ", "))
+	logger.Infof("caBundle of the MutatingWebhookConfiguration: %s", caBundle)
+
+	if tlsConfig.MutatingWebhookConfiguration != "" {
+		webhookConfigClient, err := client.CreateMutatingWebhookConfigurationClient()
+		if err == nil {
+			err = client.PatchMutatingWebhookCABundle(webhookConfigClient, tlsConfig.MutatingWebhookConfiguration, generated.CACertPEM)
+		}
+		if err != nil {
+			logger.Fatalf("Failed to patch the caBundle, paste the one above into the MutatingWebhookConfiguration instead: %v", err)
+		}
+		logger.Infof("Patched the caBundle of MutatingWebhookConfiguration %s", tlsConfig.MutatingWebhookConfiguration)
+	}
+	return certPath, keyPath
+}
+
+// newAuditLog creates the audit log of the configured sink, or returns nil when auditing is
+// disabled.
+func newAuditLog(auditConfig config.AuditConfig, clientManager *ClientManager) *server.AuditLog {
+	var sink server.AuditSink
+	switch auditConfig.Sink {
+	case "":
+		return nil
+	case server.AuditSinkStdout:
+		sink = server.NewJSONAuditSink(os.Stdout)
+	case server.AuditSinkFile:
+		fileSink, err := server.NewFileAuditSink(auditConfig.File, int64(auditConfig.FileMaxBytes), auditConfig.FileMaxBackups)
+		if err != nil {
+			logger.Fatalf("Failed to open the audit file: %v", err)
+		}
+		sink = fileSink
+	case server.AuditSinkDB:
+		dbSink, err := storage.NewAuditEventStore(clientManager.DB())
+		if err != nil {
+			logger.Fatalf("Failed to create the audit sink: %v", err)
+		}
+		sink = dbSink
+	}
+	logger.Infof("Recording the cache decisions of admissions with the %s audit sink", auditConfig.Sink)
+	return server.NewAuditLog(sink, auditConfig.BufferSize, prometheus.DefaultRegisterer)
+}
+
+// mutationConfig returns the webhook settings of the configuration, which are replaced when the
+// configuration file changes. The signature keys follow the rotated credentials instead. The
+// artifacts of the entries of other clusters are verified in remoteArtifacts, when not nil.
+func mutationConfig(cfg *config.Config, entryUses *server.EntryUseRecorder, signatureKeys *server.CacheSignatureKeys, remoteArtifacts server.ArtifactStore) server.MutationConfig {
+	// The patterns were validated when loading the configuration.
+	sensitiveParameterPatterns, _ := server.ParseSensitiveParameterPatterns(cfg.Observability.SensitiveParameterPatterns)
+	namespaces, _ := server.NewNamespaceFilter(cfg.Cache.AllowedNamespaces, cfg.Cache.DeniedNamespaces)
+	ignoredFields, _ := server.ParseIgnoredTemplateFields(cfg.Cache.KeyIgnoredFields)
+	dummyContainer, _ := server.NewDummyContainer(cfg.Cache.DummyImage, cfg.Cache.DummyCommand, cfg.Cache.DummyResourceRequests, cfg.Cache.DummyResourceLimits, cfg.Cache.DummyImagePullSecrets)
+	hitScheduling, _ := server.NewHitScheduling(cfg.Cache.HitScheduling, cfg.Cache.HitNodeSelector)
+	return server.MutationConfig{
+		EnforceOwner:               cfg.Cache.EnforceOwner,
+		MaxRequestBodyBytes:        int64(cfg.Cache.MaxRequestBodyBytes),
+		AdmissionDeadline:          cfg.Cache.AdmissionDeadline,
+		FailPolicy:                 cfg.Cache.FailPolicy,
+		Mode:                       cfg.Cache.Mode,
+		LogCachedOutputs:           cfg.Observability.LogCachedOutputs,
+		SensitiveParameterPatterns: sensitiveParameterPatterns,
+		EntryUses:                  entryUses,
+		SignatureKeys:              signatureKeys,
+		ValidationMode:             cfg.Cache.ValidationMode,
+		ClusterID:                  cfg.Cache.ClusterID,
+		CrossCluster:               cfg.Cache.CrossCluster,
+		RemoteArtifacts:            remoteArtifacts,
+		KeyVersion:                 cfg.Cache.KeyVersion,
+		Namespaces:                 namespaces,
+		IgnoredFields:              ignoredFields,
+		DummyContainer:             dummyContainer,
+		HitScheduling:              hitScheduling,
+	}
+}
+
+// remoteArtifactStore returns the store the artifacts of the entries of other clusters are
+// verified in, nil when they are not verified.
+func remoteArtifactStore(cfg *config.Config, clientManager *ClientManager) server.ArtifactStore {
+	if !cfg.Cache.VerifyRemoteArtifacts {
+		return nil
+	}
+	return clientManager.ArtifactStore()
+}
+
+// newEntryUseRecorder returns the recorder of the reuse of the cache entries when quotas evict the
+// least recently or least frequently used ones, nil otherwise.
+func newEntryUseRecorder(cfg *config.Config, clientManager *ClientManager) *server.EntryUseRecorder {
+	if !cfg.EvictionEnabled() || clientManager.QuotaStore() == nil {
+		return nil
+	}
+	return server.NewEntryUseRecorder(clientManager.QuotaStore(), util.NewRealTime())
+}
@@ -51,6 +51,11 @@ const (
 	AnnotationValueIstioSidecarInjectEnabled  = "true"
 	AnnotationValueIstioSidecarInjectDisabled = "false"
 
+	// AnnotationKeyPipelineVersionId is a workflow template annotation key.
+	// It captures the ID of the pipeline version the step runs, which the cache service records with
+	// the cache entries of the step.
+	AnnotationKeyPipelineVersionId = "pipelines.kubeflow.org/pipeline_version_id"
+
 	// LabelKeyCacheEnabled is a workflow label key.
 	// It captures whether this step will be selected by cache service.
 	// To disable/enable cache for a single run, this label needs to be added in every step under a run.
@@ -15,13 +15,13 @@
 # limitations under the License.
 
 # The current directory is /home/prow/go/src/github.com/kubeflow/pipelines
-# 1. install go in /home/prow/go1.13.3
+# 1. install go in /home/prow/go1.15.15
 cd /home/prow
-mkdir go1.13.3
-cd go1.13.3
-wget --quiet https://dl.google.com/go/go1.13.3.linux-amd64.tar.gz
-tar -xf go1.13.3.linux-amd64.tar.gz
+mkdir go1.15.15
+cd go1.15.15
+wget --quiet https://dl.google.com/go/go1.15.15.linux-amd64.tar.gz
+tar -xf go1.15.15.linux-amd64.tar.gz
 # 2. run test in project directory
 cd /home/prow/go/src/github.com/kubeflow/pipelines
-/home/prow/go1.13.3/go/bin/go mod vendor
-/home/prow/go1.13.3/go/bin/go test -v -cover ./backend/...
+/home/prow/go1.15.15/go/bin/go mod vendor
+/home/prow/go1.15.15/go/bin/go test -v -cover ./backend/...